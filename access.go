@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// SetAccessHandler replaces the access rules of a link the caller owns or
+// manages through an organization. Empty rules remove them.
+func (a *App) SetAccessHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req shortener.Access
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.SetAccess(ctx, user, shortCode, &req)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidAccess) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeOwnedLinkError(w, r, "set access", shortCode, err)
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
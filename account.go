@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type CredentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type AuthResponse struct {
+	User   *shortener.User `json:"user"`
+	APIKey string          `json:"api_key"`
+}
+
+// UpdateURLRequest changes the fields that are set and keeps the others.
+type UpdateURLRequest struct {
+	URL string `json:"url,omitempty"`
+	// ExpiresAt and TTLSeconds set a new expiry, NoExpiry removes it. At
+	// most one of them may be set.
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	NoExpiry   bool       `json:"no_expiry,omitempty"`
+	// MaxClicks sets a new click limit; zero removes it.
+	MaxClicks *int64 `json:"max_clicks,omitempty"`
+	// CampaignID moves the link to another campaign; zero removes it from
+	// its campaign.
+	CampaignID *uint64 `json:"campaign_id,omitempty"`
+	// Domain moves the link to another verified custom domain; "" serves
+	// it under the base URL again.
+	Domain *string `json:"domain,omitempty"`
+	// DisableAnalytics turns click tracking off or back on.
+	DisableAnalytics *bool `json:"disable_analytics,omitempty"`
+	// OrgID gives the link to another organization of the caller; zero
+	// leaves it to its owner alone.
+	OrgID *uint64 `json:"org_id,omitempty"`
+	// QueryPassthrough sets how the query string of redirect requests is
+	// passed on to the destination; "" stops passing it on.
+	QueryPassthrough *string `json:"query_passthrough,omitempty"`
+	// Mask sets how the destination is hidden from visitors; "" redirects
+	// them again.
+	Mask *string `json:"mask,omitempty"`
+}
+
+// registerAccountRoutes mounts signup/login and the routes through which
+// users manage the links they own or share through organizations.
+func (a *App) registerAccountRoutes(r *mux.Router, authenticate mux.MiddlewareFunc) {
+	r.HandleFunc("/api/auth/signup", a.SignupHandler).Methods("POST")
+	r.HandleFunc("/api/auth/login", a.LoginHandler).Methods("POST")
+
+	// Viewers read, editors also change links, campaigns, domains and
+	// organizations, and only admins delete links. API keys also need the
+	// scope of the role.
+	require := func(role string, h http.HandlerFunc) http.Handler {
+		return authenticate(auth.RequireRole(role)(h))
+	}
+	viewer, editor, admin := shortener.RoleViewer, shortener.RoleEditor, shortener.RoleAdmin
+	// Any key may log out and manage keys no more powerful than itself
+	anyKey := func(h http.HandlerFunc) http.Handler {
+		return authenticate(auth.Require(h))
+	}
+	r.Handle("/api/auth/logout", anyKey(a.LogoutHandler)).Methods("POST")
+	r.Handle("/api/keys", anyKey(a.ListAPIKeysHandler)).Methods("GET")
+	r.Handle("/api/keys", anyKey(a.CreateAPIKeyHandler)).Methods("POST")
+	r.Handle("/api/keys/{keyID}", anyKey(a.RevokeAPIKeyHandler)).Methods("DELETE")
+	r.Handle("/api/keys/{keyID}/rotate", anyKey(a.RotateAPIKeyHandler)).Methods("POST")
+	r.Handle("/api/me/urls", require(viewer, a.MyURLsHandler)).Methods("GET")
+	r.Handle("/api/urls/{shortCode}", require(editor, a.UpdateURLHandler)).Methods("PATCH")
+	r.Handle("/api/urls/{shortCode}", require(admin, a.DeleteURLHandler)).Methods("DELETE")
+	r.Handle("/api/urls/{shortCode}/restore", require(editor, a.RestoreURLHandler)).Methods("POST")
+	r.Handle("/api/urls/{shortCode}/history", require(viewer, a.URLHistoryHandler)).Methods("GET")
+	r.Handle("/api/urls/{shortCode}/history/{changeID:[0-9]+}/rollback", require(editor, a.RollbackURLHandler)).Methods("POST")
+	r.Handle("/api/urls/{shortCode}/targets", require(editor, a.SetTargetsHandler)).Methods("PUT")
+	r.Handle("/api/urls/{shortCode}/rules", require(editor, a.SetRulesHandler)).Methods("PUT")
+	r.Handle("/api/urls/{shortCode}/metadata", require(editor, a.SetMetadataHandler)).Methods("PUT")
+	r.Handle("/api/urls/{shortCode}/access", require(editor, a.SetAccessHandler)).Methods("PUT")
+	r.Handle("/api/urls/{shortCode}/experiment", require(viewer, a.ExperimentHandler)).Methods("GET")
+	r.Handle("/api/urls/{shortCode}/experiment", require(editor, a.SetExperimentHandler)).Methods("PUT")
+	r.Handle("/api/urls/{shortCode}/stats/timeseries", require(viewer, a.TimeSeriesHandler)).Methods("GET")
+	r.Handle("/api/urls/{shortCode}/stats/sources", require(viewer, a.SourcesHandler)).Methods("GET")
+	r.Handle("/api/urls/{shortCode}/stats/export", require(viewer, a.ExportHandler)).Methods("GET")
+	r.Handle("/api/import", require(editor, a.ImportHandler)).Methods("POST")
+	r.Handle("/api/import/{importID}", require(viewer, a.ImportStatusHandler)).Methods("GET")
+	r.Handle("/api/campaigns", require(editor, a.CreateCampaignHandler)).Methods("POST")
+	r.Handle("/api/campaigns", require(viewer, a.ListCampaignsHandler)).Methods("GET")
+	r.Handle("/api/campaigns/{campaignID}", require(viewer, a.GetCampaignHandler)).Methods("GET")
+	r.Handle("/api/campaigns/{campaignID}", require(editor, a.RenameCampaignHandler)).Methods("PATCH")
+	r.Handle("/api/campaigns/{campaignID}", require(editor, a.DeleteCampaignHandler)).Methods("DELETE")
+	r.Handle("/api/campaigns/{campaignID}/urls", require(viewer, a.CampaignURLsHandler)).Methods("GET")
+	r.Handle("/api/campaigns/{campaignID}/stats", require(viewer, a.CampaignStatsHandler)).Methods("GET")
+	r.Handle("/api/domains", require(editor, a.AddDomainHandler)).Methods("POST")
+	r.Handle("/api/domains", require(viewer, a.ListDomainsHandler)).Methods("GET")
+	r.Handle("/api/domains/{domainID}", require(viewer, a.GetDomainHandler)).Methods("GET")
+	r.Handle("/api/domains/{domainID}", require(editor, a.DeleteDomainHandler)).Methods("DELETE")
+	r.Handle("/api/domains/{domainID}/verify", require(editor, a.VerifyDomainHandler)).Methods("POST")
+	r.Handle("/api/orgs", require(editor, a.CreateOrgHandler)).Methods("POST")
+	r.Handle("/api/orgs", require(viewer, a.ListOrgsHandler)).Methods("GET")
+	r.Handle("/api/orgs/{orgID}", require(viewer, a.GetOrgHandler)).Methods("GET")
+	r.Handle("/api/orgs/{orgID}/urls", require(viewer, a.OrgURLsHandler)).Methods("GET")
+	r.Handle("/api/orgs/{orgID}/invitations", require(editor, a.InviteHandler)).Methods("POST")
+	r.Handle("/api/orgs/{orgID}/members/{userID}", require(editor, a.RemoveOrgMemberHandler)).Methods("DELETE")
+	r.Handle("/api/invitations/{token}/accept", require(viewer, a.AcceptInvitationHandler)).Methods("POST")
+	// Mutations check the roles of the endpoints they stand for
+	r.Handle("/graphql", require(viewer, a.GraphQLHandler(a.graphQLSchema()))).Methods("POST")
+}
+
+// SignupHandler creates an account and returns its first API key.
+func (a *App) SignupHandler(w http.ResponseWriter, r *http.Request) {
+	var req CredentialsRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, key, err := a.Service.Signup(ctx, req.Email, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrInvalidEmail), errors.Is(err, shortener.ErrWeakPassword):
+			writeServiceError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shortener.ErrEmailTaken):
+			apierror.Write(w, http.StatusConflict, apierror.EmailTaken, "Email already registered")
+		default:
+			writeAccountError(w, r, "signup", err)
+		}
+		return
+	}
+
+	writeJSONStatus(w, r, http.StatusCreated, AuthResponse{User: user, APIKey: key})
+}
+
+// LoginHandler exchanges email and password for a new API key.
+func (a *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req CredentialsRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, key, err := a.Service.Login(ctx, req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidCredentials) {
+			apierror.Write(w, http.StatusUnauthorized, apierror.InvalidCredentials, "Invalid email or password")
+			return
+		}
+		writeAccountError(w, r, "login", err)
+		return
+	}
+
+	writeJSON(w, r, AuthResponse{User: user, APIKey: key})
+}
+
+// LogoutHandler revokes the API key the request was made with.
+func (a *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.Logout(ctx, auth.BearerToken(r)); err != nil {
+		writeAccountError(w, r, "logout", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MyURLsHandler lists the caller's links, newest first.
+func (a *App) MyURLsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	links, next, err := a.Service.ListOwned(ctx, user, cursor, limit)
+	if err != nil {
+		writeAccountError(w, r, "list own urls", err)
+		return
+	}
+	writeJSON(w, r, a.listURLsResponse(links, next))
+}
+
+// UpdateURLHandler changes the destination, expiry, click limit, campaign,
+// custom domain, analytics opt-out or organization of a link the caller owns
+// or manages through an organization.
+func (a *App) UpdateURLHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req UpdateURLRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	update, apiErr := req.update()
+	if apiErr != nil {
+		apierror.WriteError(w, http.StatusBadRequest, *apiErr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.UpdateLink(ctx, user, shortCode, update)
+	if err != nil {
+		writeUpdateError(w, r, "update url", shortCode, err)
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
+
+// writeUpdateError responds to an error of shortener.Service.UpdateLink.
+func writeUpdateError(w http.ResponseWriter, r *http.Request, op, shortCode string, err error) {
+	if errors.Is(err, shortener.ErrUnsafeURL) {
+		apierror.Write(w, http.StatusBadRequest, apierror.UnsafeURL, "URL is flagged as unsafe")
+		return
+	}
+	if errors.Is(err, shortener.ErrInvalidExpiry) {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidExpiry, "Expiry must be in the future")
+		return
+	}
+	if errors.Is(err, shortener.ErrInvalidCampaign) || errors.Is(err, shortener.ErrInvalidDomain) || errors.Is(err, shortener.ErrInvalidOrg) ||
+		errors.Is(err, shortener.ErrInvalidURL) || errors.Is(err, shortener.ErrHomograph) || errors.Is(err, shortener.ErrDomainBlocked) ||
+		errors.Is(err, shortener.ErrRecursiveURL) || errors.Is(err, shortener.ErrInvalidMask) {
+		writeServiceError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errors.Is(err, shortener.ErrURLTooLong) {
+		writeServiceError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeOwnedLinkError(w, r, op, shortCode, err)
+}
+
+// update validates req and returns the changes it makes, or the error to
+// answer with 400.
+func (req *UpdateURLRequest) update() (shortener.LinkUpdate, *apierror.Error) {
+	if req.URL == "" && req.ExpiresAt == nil && req.TTLSeconds == 0 && !req.NoExpiry && req.MaxClicks == nil &&
+		req.CampaignID == nil && req.Domain == nil && req.DisableAnalytics == nil && req.OrgID == nil && req.QueryPassthrough == nil && req.Mask == nil {
+		return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.NothingToUpdate, Message: "Nothing to update"}
+	}
+	if req.URL != "" {
+		if err := shortener.ValidateURL(req.URL); err != nil {
+			return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.InvalidURL, Message: "Invalid URL format. Must be http:// or https://"}
+		}
+	}
+	expiries := 0
+	for _, set := range []bool{req.ExpiresAt != nil, req.TTLSeconds != 0, req.NoExpiry} {
+		if set {
+			expiries++
+		}
+	}
+	if expiries > 1 {
+		return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.InvalidExpiry, Message: "Only one of expires_at, ttl_seconds or no_expiry may be set"}
+	}
+	if req.TTLSeconds < 0 {
+		return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.InvalidExpiry, Message: "ttl_seconds must be positive"}
+	}
+	if req.MaxClicks != nil && *req.MaxClicks < 0 {
+		return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.InvalidMaxClicks, Message: "max_clicks must not be negative"}
+	}
+
+	update := shortener.LinkUpdate{
+		URL:               req.URL,
+		ExpiresAt:         req.ExpiresAt,
+		NoExpiry:          req.NoExpiry,
+		MaxClicks:         req.MaxClicks,
+		CampaignID:        req.CampaignID,
+		Domain:            req.Domain,
+		AnalyticsDisabled: req.DisableAnalytics,
+		OrgID:             req.OrgID,
+	}
+	if req.QueryPassthrough != nil {
+		passthrough, err := shortener.ParseQueryPassthrough(*req.QueryPassthrough)
+		if err != nil {
+			return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.InvalidPassthrough, Message: err.Error()}
+		}
+		update.QueryPassthrough = &passthrough
+	}
+	if req.Mask != nil {
+		mask, err := shortener.ParseMask(*req.Mask)
+		if err != nil {
+			return shortener.LinkUpdate{}, &apierror.Error{Code: apierror.InvalidMask, Message: err.Error()}
+		}
+		update.Mask = &mask
+	}
+	if req.TTLSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+		update.ExpiresAt = &expiresAt
+	}
+	return update, nil
+}
+
+// DeleteURLHandler deletes a link owned by the caller. The link keeps its
+// short code and can be brought back with RestoreURLHandler.
+func (a *App) DeleteURLHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.Delete(ctx, user, shortCode); err != nil {
+		writeOwnedLinkError(w, r, "delete url", shortCode, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreURLHandler restores a deleted link owned by the caller.
+func (a *App) RestoreURLHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.Restore(ctx, user, shortCode)
+	if err != nil {
+		var quotaErr *shortener.QuotaError
+		if errors.As(err, &quotaErr) {
+			writeQuotaError(w, quotaErr)
+			return
+		}
+		writeOwnedLinkError(w, r, "restore url", shortCode, err)
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
+
+func writeOwnedLinkError(w http.ResponseWriter, r *http.Request, op, shortCode string, err error) {
+	if errors.Is(err, shortener.ErrForbidden) {
+		apierror.Write(w, http.StatusForbidden, apierror.Forbidden, "Forbidden")
+		return
+	}
+	writeAdminError(w, r, op, shortCode, err)
+}
+
+func writeAccountError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+		slog.WarnContext(r.Context(), op+" timeout", "error", err)
+		return
+	}
+	apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+	slog.ErrorContext(r.Context(), op+" failed", "error", err)
+}
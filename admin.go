@@ -0,0 +1,675 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/jobs"
+	"github.com/hszk-dev/url-shortener/internal/scheduler"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// registerAdminRoutes mounts the admin API under /api/admin, guarded by token
+// or the API key of an admin, as authenticated by authenticate.
+func (a *App) registerAdminRoutes(r *mux.Router, token string, authenticate mux.MiddlewareFunc) {
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(requireAdmin(token, authenticate))
+	admin.HandleFunc("/links", a.AdminSearchHandler).Methods("GET")
+	admin.HandleFunc("/links/{shortCode}", a.AdminGetLinkHandler).Methods("GET")
+	admin.HandleFunc("/links/{shortCode}", a.AdminDeleteHandler).Methods("DELETE")
+	admin.HandleFunc("/links/{shortCode}/disable", a.AdminSetStatusHandler(shortener.StatusDisabled)).Methods("POST")
+	admin.HandleFunc("/links/{shortCode}/enable", a.AdminSetStatusHandler(shortener.StatusActive)).Methods("POST")
+	admin.HandleFunc("/links/{shortCode}/invalidate", a.AdminInvalidateHandler).Methods("POST")
+	admin.HandleFunc("/links/{shortCode}/clicks", a.AdminDeleteClicksHandler).Methods("DELETE")
+	admin.HandleFunc("/stats", a.AdminStatsHandler).Methods("GET")
+	admin.HandleFunc("/stats/top", a.TopStatsHandler).Methods("GET")
+	admin.HandleFunc("/reports", a.AdminReportsHandler).Methods("GET")
+	admin.HandleFunc("/domain-rules", a.AdminDomainRulesHandler).Methods("GET")
+	admin.HandleFunc("/domain-rules", a.AdminAddDomainRuleHandler).Methods("POST")
+	admin.HandleFunc("/domain-rules/{ruleID:[0-9]+}", a.AdminDeleteDomainRuleHandler).Methods("DELETE")
+	admin.HandleFunc("/alias-reservations", a.AdminAliasReservationsHandler).Methods("GET")
+	admin.HandleFunc("/alias-reservations", a.AdminAddAliasReservationHandler).Methods("POST")
+	admin.HandleFunc("/alias-reservations/{reservationID:[0-9]+}", a.AdminDeleteAliasReservationHandler).Methods("DELETE")
+	admin.HandleFunc("/users/{userID:[0-9]+}/plan", a.AdminSetPlanHandler).Methods("PUT")
+	admin.HandleFunc("/users/{userID:[0-9]+}/role", a.AdminSetRoleHandler).Methods("PUT")
+	admin.HandleFunc("/audit", a.AdminAuditHandler).Methods("GET")
+	admin.HandleFunc("/export", a.AdminExportHandler).Methods("GET")
+	admin.HandleFunc("/import", a.AdminImportHandler).Methods("POST")
+	admin.HandleFunc("/jobs", a.AdminJobsHandler).Methods("GET")
+	admin.HandleFunc("/jobs/{kind}/dead", a.AdminDeadJobsHandler).Methods("GET")
+	admin.HandleFunc("/events", a.AdminEventsHandler).Methods("GET")
+	admin.Handle("/metrics", expvar.Handler()).Methods("GET")
+}
+
+// requireAdmin rejects requests that carry neither token nor the API key of
+// a user with the admin role as a bearer token. The token comparison is
+// constant-time so the token cannot be guessed byte by byte.
+func requireAdmin(token string, authenticate mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		byRole := authenticate(auth.RequireRole(shortener.RoleAdmin)(next))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Unauthorized")
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			byRole.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminSearchHandler lists links whose destination, metadata title or
+// metadata description contains the q query parameter, newest first. Without q it lists all links, including disabled ones.
+func (a *App) AdminSearchHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	links, next, err := a.Service.Search(ctx, r.URL.Query().Get("q"), cursor, limit)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+			slog.WarnContext(ctx, "admin search timeout", "error", err)
+			return
+		}
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(ctx, "admin search failed", "error", err)
+		return
+	}
+
+	writeJSON(w, r, a.listURLsResponse(links, next))
+}
+
+// AdminGetLinkHandler returns the link behind a short code whatever its
+// status, e.g. to inspect a disabled or flagged link.
+func (a *App) AdminGetLinkHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.Resolve(ctx, shortCode)
+	if err != nil {
+		writeAdminError(w, r, "admin get link", shortCode, err)
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
+
+// AdminDeleteHandler soft-deletes a link whoever owns it.
+func (a *App) AdminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.AdminDelete(ctx, shortCode); err != nil {
+		writeAdminError(w, r, "admin delete", shortCode, err)
+		return
+	}
+	slog.InfoContext(ctx, "link deleted by admin", "short_code", shortCode)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminSetStatusHandler returns a handler that moves a link to status.
+func (a *App) AdminSetStatusHandler(status shortener.Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shortCode := mux.Vars(r)["shortCode"]
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if _, err := a.Service.SetStatus(ctx, shortCode, status); err != nil {
+			writeAdminError(w, r, "admin set status", shortCode, err)
+			return
+		}
+		slog.InfoContext(ctx, "link status changed", "short_code", shortCode, "status", status)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AdminInvalidateHandler drops cached copies of a link, e.g. after editing
+// its row directly in the database.
+func (a *App) AdminInvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.Invalidate(ctx, shortCode); err != nil {
+		writeAdminError(w, r, "admin invalidate", shortCode, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type DeleteClicksResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// AdminDeleteClicksHandler erases the click analytics of a link, for data
+// subject requests. The link itself is kept.
+func (a *App) AdminDeleteClicksHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	n, err := a.Service.DeleteClicks(ctx, shortCode)
+	if err != nil {
+		writeAdminError(w, r, "admin delete clicks", shortCode, err)
+		return
+	}
+	writeJSON(w, r, DeleteClicksResponse{Deleted: n})
+}
+
+// AdminStatsHandler returns aggregate link counts.
+func (a *App) AdminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := a.Service.Stats(ctx)
+	if err != nil {
+		writeAdminError(w, r, "admin stats", "", err)
+		return
+	}
+	writeJSONWithETag(w, r, stats)
+}
+
+type ListJobsResponse struct {
+	Jobs []scheduler.JobStatus `json:"jobs"`
+	// Queues describes the queued jobs of the service by kind.
+	Queues []jobs.KindStatus `json:"queues"`
+}
+
+// AdminJobsHandler reports the background jobs of this instance and the
+// outcome of their latest runs, and the queued jobs by kind.
+func (a *App) AdminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	resp := ListJobsResponse{Jobs: []scheduler.JobStatus{}}
+	if a.Jobs != nil {
+		resp.Jobs = a.Jobs.Status()
+	}
+	queues, err := a.Service.Jobs().Status(ctx)
+	if err != nil {
+		writeAdminError(w, r, "admin jobs", "", err)
+		return
+	}
+	resp.Queues = queues
+	writeJSON(w, r, resp)
+}
+
+// ListDeadJobsResponse is the response of AdminDeadJobsHandler.
+type ListDeadJobsResponse struct {
+	Jobs []jobs.Job `json:"jobs"`
+}
+
+// AdminDeadJobsHandler lists the latest jobs of a kind that failed for
+// good, latest first.
+func (a *App) AdminDeadJobsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r, shortener.MaxListLimit)
+	if !ok {
+		return
+	}
+	if limit == 0 {
+		limit = shortener.DefaultListLimit
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	dead, err := a.Service.Jobs().DeadLetters(ctx, mux.Vars(r)["kind"], limit)
+	if err != nil {
+		writeAdminError(w, r, "admin dead jobs", "", err)
+		return
+	}
+	writeJSON(w, r, ListDeadJobsResponse{Jobs: dead})
+}
+
+// AdminReportsHandler lists open abuse reports, newest first: the review
+// queue for links suspended by reports. Enabling or disabling a link
+// resolves its reports.
+func (a *App) AdminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	reports, next, err := a.Service.ListReports(ctx, cursor, limit)
+	if err != nil {
+		writeAdminError(w, r, "admin list reports", "", err)
+		return
+	}
+
+	resp := ListReportsResponse{
+		Reports: make([]ReportResponse, 0, len(reports)),
+	}
+	for _, report := range reports {
+		resp.Reports = append(resp.Reports, ReportResponse{
+			ID:        report.ID,
+			ShortCode: report.ShortCode(),
+			Reason:    report.Reason,
+			Reporter:  report.Reporter,
+			CreatedAt: report.CreatedAt,
+		})
+	}
+	if next != 0 {
+		resp.NextCursor = strconv.FormatUint(next, 10)
+	}
+	writeJSON(w, r, resp)
+}
+
+type ListDomainRulesResponse struct {
+	Rules []*shortener.DomainRule `json:"rules"`
+}
+
+// AdminDomainRulesHandler lists the destination domain rules: those
+// configured with DOMAIN_BLOCKLIST and DOMAIN_ALLOWLIST first, then those
+// added through the API, oldest first.
+func (a *App) AdminDomainRulesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rules, err := a.Service.DomainRules(ctx)
+	if err != nil {
+		writeAdminError(w, r, "admin list domain rules", "", err)
+		return
+	}
+	writeJSON(w, r, ListDomainRulesResponse{Rules: rules})
+}
+
+type DomainRuleRequest struct {
+	Pattern string                 `json:"pattern"`
+	Action  shortener.DomainAction `json:"action"`
+	Reason  string                 `json:"reason"`
+}
+
+// AdminAddDomainRuleHandler blocks or allows the destinations on a domain.
+// The rule applies to links created or changed from then on; existing
+// links are left alone.
+func (a *App) AdminAddDomainRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var req DomainRuleRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rule := &shortener.DomainRule{Pattern: req.Pattern, Action: req.Action, Reason: req.Reason}
+	if err := a.Service.AddDomainRule(ctx, rule); err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrInvalidDomainRule):
+			writeServiceError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shortener.ErrDomainRuleExists):
+			writeServiceError(w, http.StatusConflict, err)
+		default:
+			writeAdminError(w, r, "admin add domain rule", "", err)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "domain rule added", "rule_id", rule.ID, "pattern", rule.Pattern, "action", rule.Action)
+	writeJSONStatus(w, r, http.StatusCreated, rule)
+}
+
+// AdminDeleteDomainRuleHandler removes a domain rule added through the API.
+func (a *App) AdminDeleteDomainRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["ruleID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid rule ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.DeleteDomainRule(ctx, id); err != nil {
+		if errors.Is(err, shortener.ErrNotFound) {
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Domain rule not found")
+			return
+		}
+		writeAdminError(w, r, "admin delete domain rule", "", err)
+		return
+	}
+	slog.InfoContext(ctx, "domain rule deleted", "rule_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ListAliasReservationsResponse struct {
+	Reservations []*shortener.AliasReservation `json:"reservations"`
+}
+
+// AdminAliasReservationsHandler lists the alias reservations, oldest first.
+func (a *App) AdminAliasReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	reservations, err := a.Service.AliasReservations(ctx)
+	if err != nil {
+		writeAdminError(w, r, "admin list alias reservations", "", err)
+		return
+	}
+	writeJSON(w, r, ListAliasReservationsResponse{Reservations: reservations})
+}
+
+type AliasReservationRequest struct {
+	Pattern string `json:"pattern"`
+	Prefix  bool   `json:"prefix"`
+	OrgID   uint64 `json:"org_id"`
+}
+
+// AdminAddAliasReservationHandler keeps a custom alias, or every alias
+// starting with a prefix, from everybody or for one organization.
+func (a *App) AdminAddAliasReservationHandler(w http.ResponseWriter, r *http.Request) {
+	var req AliasReservationRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	res := &shortener.AliasReservation{Pattern: req.Pattern, Prefix: req.Prefix, OrgID: req.OrgID}
+	if err := a.Service.AddAliasReservation(ctx, res); err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrInvalidReservation):
+			writeServiceError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shortener.ErrReservationExists):
+			writeServiceError(w, http.StatusConflict, err)
+		default:
+			writeAdminError(w, r, "admin add alias reservation", "", err)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "alias reservation added", "reservation_id", res.ID, "pattern", res.Pattern, "prefix", res.Prefix, "org_id", res.OrgID)
+	writeJSONStatus(w, r, http.StatusCreated, res)
+}
+
+// AdminDeleteAliasReservationHandler releases an alias reservation.
+func (a *App) AdminDeleteAliasReservationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["reservationID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid reservation ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.DeleteAliasReservation(ctx, id); err != nil {
+		if errors.Is(err, shortener.ErrNotFound) {
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Alias reservation not found")
+			return
+		}
+		writeAdminError(w, r, "admin delete alias reservation", "", err)
+		return
+	}
+	slog.InfoContext(ctx, "alias reservation deleted", "reservation_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ListAuditResponse struct {
+	Entries    []*shortener.AuditEntry `json:"entries"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+// AdminAuditHandler lists audit log entries, newest first, optionally
+// filtered by the actor and code query parameters and by an RFC 3339 time
+// range [since, until).
+func (a *App) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := shortener.AuditFilter{
+		Actor: query.Get("actor"),
+		Code:  query.Get("code"),
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid "+name+". Must be an RFC 3339 timestamp")
+			return
+		}
+		*t = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	entries, next, err := a.Service.ListAudit(ctx, filter, cursor, limit)
+	if err != nil {
+		writeAdminError(w, r, "admin list audit", "", err)
+		return
+	}
+
+	resp := ListAuditResponse{Entries: entries}
+	if next != 0 {
+		resp.NextCursor = strconv.FormatUint(next, 10)
+	}
+	writeJSON(w, r, resp)
+}
+
+type SetPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// AdminSetPlanHandler moves a user to another quota plan.
+func (a *App) AdminSetPlanHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["userID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid user ID")
+		return
+	}
+	var req SetPlanRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.SetPlan(ctx, userID, req.Plan); err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrUnknownPlan):
+			apierror.Write(w, http.StatusBadRequest, apierror.UnknownPlan, "Unknown plan")
+		case errors.Is(err, shortener.ErrNotFound):
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "User not found")
+		default:
+			writeAdminError(w, r, "admin set plan", "", err)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "user plan changed", "user_id", userID, "plan", req.Plan)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AdminSetRoleHandler gives a user another role.
+func (a *App) AdminSetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(mux.Vars(r)["userID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid user ID")
+		return
+	}
+	var req SetRoleRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.SetRole(ctx, userID, req.Role); err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrUnknownRole):
+			apierror.Write(w, http.StatusBadRequest, apierror.UnknownRole, "Unknown role")
+		case errors.Is(err, shortener.ErrNotFound):
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "User not found")
+		default:
+			writeAdminError(w, r, "admin set role", "", err)
+		}
+		return
+	}
+	slog.InfoContext(ctx, "user role changed", "user_id", userID, "role", req.Role)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminExportHandler downloads every link that is not deleted, newest first,
+// as newline-delimited JSON (format=ndjson, the default) or CSV
+// (format=csv), for AdminImportHandler of another instance.
+func (a *App) AdminExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := exportFormat(w, r)
+	if format == "" {
+		return
+	}
+	// The export takes as long as the database needs: lift the write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.WarnContext(r.Context(), "failed to lift write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "links." + format}))
+	if format == shortener.ExportCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	enc, err := shortener.NewLinkEncoder(w, format)
+	if err != nil {
+		writeAdminError(w, r, "admin export", "", err)
+		return
+	}
+	n := 0
+	err = a.Service.ExportLinks(r.Context(), func(link *shortener.Link) error {
+		if err := enc.Encode(link); err != nil {
+			return err
+		}
+		if n++; n%exportFlushRows == 0 {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err != nil {
+		// Headers are sent: the client sees a truncated download
+		slog.ErrorContext(r.Context(), "admin export interrupted", "links", n, "error", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "links exported", "links", n, "format", format)
+}
+
+// AdminImportHandler stores the links in the request body, written by
+// AdminExportHandler in the format query parameter, under their codes.
+// Owners, campaigns, domains and organizations are dropped unless
+// keep_owners=true. Links whose code is taken are skipped, so a failed
+// import can be retried.
+func (a *App) AdminImportHandler(w http.ResponseWriter, r *http.Request) {
+	format := exportFormat(w, r)
+	if format == "" {
+		return
+	}
+	keepOwners, err := strconv.ParseBool(cmp.Or(r.URL.Query().Get("keep_owners"), "false"))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid keep_owners. Must be true or false")
+		return
+	}
+	rc := http.NewResponseController(w)
+	for _, set := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := set(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.WarnContext(r.Context(), "failed to lift deadline", "error", err)
+		}
+	}
+
+	dec, err := shortener.NewLinkDecoder(r.Body, format)
+	if err != nil {
+		writeAdminError(w, r, "admin import", "", err)
+		return
+	}
+	res, err := a.Service.ImportLinks(r.Context(), dec.Decode, shortener.ImportOptions{KeepOwners: keepOwners})
+	slog.InfoContext(r.Context(), "links imported", "imported", res.Imported, "skipped", res.Skipped, "format", format)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidImport) {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidImport, fmt.Sprintf("%v (imported %d, skipped %d)", err, res.Imported, res.Skipped))
+			return
+		}
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, fmt.Sprintf("Import failed (imported %d, skipped %d)", res.Imported, res.Skipped))
+		slog.ErrorContext(r.Context(), "admin import failed", "error", err)
+		return
+	}
+	writeJSON(w, r, res)
+}
+
+// exportFormat returns the format query parameter of an export or import,
+// or writes an error and returns "" if it is invalid.
+func exportFormat(w http.ResponseWriter, r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", shortener.ExportNDJSON:
+		return shortener.ExportNDJSON
+	case shortener.ExportCSV:
+		return format
+	default:
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidFormat, "Invalid format. Must be ndjson or csv")
+		return ""
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, r *http.Request, op, shortCode string, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+		slog.WarnContext(r.Context(), op+" timeout", "short_code", shortCode, "error", err)
+	case errors.Is(err, shortener.ErrInvalidShortCode):
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidCode, "Invalid short code")
+	case errors.Is(err, shortener.ErrNotFound):
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "URL not found")
+	default:
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(r.Context(), op+" failed", "short_code", shortCode, "error", err)
+	}
+}
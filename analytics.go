@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// TimeSeriesHandler returns the clicks per hour or day of a link owned by
+// the caller. Query parameters: granularity (hour or day, default hour) and
+// from/to as RFC 3339 timestamps (default the last 24 hours or 30 days).
+func (a *App) TimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	series, err := a.Service.ClickSeries(ctx, user, shortCode, r.URL.Query().Get("granularity"), from, to)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidSeries) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeOwnedLinkError(w, r, "click series", shortCode, err)
+		return
+	}
+	writeJSONWithETag(w, r, series)
+}
+
+// SourcesHandler returns the clicks of a link owned by the caller per
+// referrer domain and UTM parameter. Query parameters: from/to as RFC 3339
+// timestamps (default the last 30 days) and limit, the number of values
+// per dimension (default 10).
+func (a *App) SourcesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parseLimit(w, r, shortener.MaxSourceLimit)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	breakdown, err := a.Service.ClickBreakdown(ctx, user, shortCode, from, to, limit)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidSeries) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeOwnedLinkError(w, r, "click sources", shortCode, err)
+		return
+	}
+	writeJSONWithETag(w, r, breakdown)
+}
+
+// exportFlushRows is the number of rows written between flushes of an
+// export, so that clients receive it in chunks as it is written.
+const exportFlushRows = 500
+
+// ExportHandler downloads the clicks per hour or day of a link owned by the
+// caller as CSV or JSON. Query parameters: format (csv or json, default
+// csv), granularity (hour or day, default day) and from/to as RFC 3339
+// timestamps (default from the creation of the link until now).
+func (a *App) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidFormat, "Invalid format. Must be csv or json")
+		return
+	}
+	granularity := query.Get("granularity")
+	if granularity == "" {
+		granularity = shortener.GranularityDay
+	}
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	buckets, err := a.Service.ExportClicks(ctx, user, shortCode, granularity, from, to)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidSeries) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeOwnedLinkError(w, r, "click export", shortCode, err)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-clicks-%s.%s", shortCode, granularity, format)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = writeCSVExport(w, shortCode, buckets)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = writeJSONExport(w, shortCode, buckets)
+	}
+	if err != nil {
+		// Headers are sent: the client sees a truncated download
+		slog.WarnContext(r.Context(), "click export interrupted", "short_code", shortCode, "error", err)
+	}
+}
+
+// writeCSVExport writes buckets as CSV rows of code, bucket start and
+// clicks, after a header row.
+func writeCSVExport(w http.ResponseWriter, shortCode string, buckets []shortener.ClickBucket) error {
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"short_code", "start", "clicks"}); err != nil {
+		return err
+	}
+	for i, b := range buckets {
+		if err := cw.Write([]string{shortCode, b.Start.UTC().Format(time.RFC3339), strconv.FormatInt(b.Clicks, 10)}); err != nil {
+			return err
+		}
+		if (i+1)%exportFlushRows == 0 {
+			if err := flushExport(cw, rc); err != nil {
+				return err
+			}
+		}
+	}
+	return flushExport(cw, rc)
+}
+
+func flushExport(cw *csv.Writer, rc *http.ResponseController) error {
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// writeJSONExport writes buckets as a JSON array of objects, one per line.
+func writeJSONExport(w http.ResponseWriter, shortCode string, buckets []shortener.ClickBucket) error {
+	rc := http.NewResponseController(w)
+	type row struct {
+		Code   string    `json:"short_code"`
+		Start  time.Time `json:"start"`
+		Clicks int64     `json:"clicks"`
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, b := range buckets {
+		line, err := json.Marshal(row{shortCode, b.Start.UTC(), b.Clicks})
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if i == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(w, sep+string(line)); err != nil {
+			return err
+		}
+		if (i+1)%exportFlushRows == 0 {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// parseTimeRange reads the optional from and to query parameters as RFC
+// 3339 timestamps, writing a 400 response if either is invalid.
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (from, to time.Time, ok bool) {
+	query := r.URL.Query()
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid "+name+". Must be an RFC 3339 timestamp")
+			return time.Time{}, time.Time{}, false
+		}
+		*t = parsed
+	}
+	return from, to, true
+}
+
+// parseLimit reads the optional limit query parameter, writing a 400
+// response unless it is between 1 and maxLimit. A missing limit is 0.
+func parseLimit(w http.ResponseWriter, r *http.Request, maxLimit int) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 || limit > maxLimit {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidLimit, fmt.Sprintf("Invalid limit. Must be between 1 and %d", maxLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+// parseStatsPeriod parses a period of whole days ("7d") or a Go duration
+// ("12h").
+func parseStatsPeriod(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// TopStatsHandler returns global stats over a period: redirects, unique
+// visitors, links created and the most clicked links. Query parameters:
+// period (e.g. 7d or 12h, default 7d) and limit (default 10). It covers the
+// links of every user, so it is only mounted on the admin API.
+func (a *App) TopStatsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	period := shortener.DefaultStatsPeriod
+	if v := query.Get("period"); v != "" {
+		p, err := parseStatsPeriod(v)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidPeriod, "Invalid period. Must be a number of days (e.g. 7d) or hours (e.g. 12h)")
+			return
+		}
+		period = p
+	}
+	limit, ok := parseLimit(w, r, shortener.MaxTopLimit)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := a.Service.TopStats(ctx, period, limit)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidPeriod) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeAccountError(w, r, "top stats", err)
+		return
+	}
+	writeJSONWithETag(w, r, stats)
+}
+
+// writeJSONWithETag writes v like writeJSON, tagged with a hash of the
+// encoded body so that polling clients can revalidate with If-None-Match
+// and get 304 Not Modified while the stats are unchanged. The ETag is weak
+// because the compression middleware may re-encode the body.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	respJSON, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		return
+	}
+
+	sum := sha256.Sum256(respJSON)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	// Private: stats are only served to their owner or an admin, so shared
+	// caches must not store them. no-cache: always revalidate.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(respJSON); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write response", "error", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison of RFC 9110.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	httpSwagger "github.com/swaggo/http-swagger"
+
+	"github.com/hszk-dev/url-shortener/docs"
+)
+
+// registerDocsRoutes serves the OpenAPI document at /api/docs/openapi.yaml
+// and a Swagger UI for it at /api/docs/. The former /docs paths keep working.
+func registerDocsRoutes(r *mux.Router) {
+	spec := openAPIHandler()
+	r.Handle("/api/docs/openapi.yaml", spec).Methods("GET", "HEAD")
+	r.Handle("/api/docs", http.RedirectHandler("/api/docs/", http.StatusMovedPermanently)).Methods("GET")
+	r.PathPrefix("/api/docs/").Handler(httpSwagger.Handler(
+		httpSwagger.URL("/api/docs/openapi.yaml"),
+	))
+
+	r.Handle("/docs/swagger.yaml", spec).Methods("GET", "HEAD")
+	r.PathPrefix("/docs/").Handler(http.RedirectHandler("/api/docs/", http.StatusMovedPermanently))
+}
+
+// openAPIHandler serves the embedded OpenAPI document. Its ETag lets clients
+// and code generators revalidate instead of downloading it again.
+func openAPIHandler() http.Handler {
+	sum := sha256.Sum256(docs.OpenAPI)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "openapi.yaml", time.Time{}, bytes.NewReader(docs.OpenAPI))
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// serviceErrorCodes are the API error codes of the shortener errors caused
+// by clients, checked in order with errors.Is.
+var serviceErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{shortener.ErrInvalidHost, apierror.InvalidHost},
+	{shortener.ErrInvalidURL, apierror.InvalidURL},
+	{shortener.ErrURLTooLong, apierror.URLTooLong},
+	{shortener.ErrUnsafeURL, apierror.UnsafeURL},
+	{shortener.ErrHomograph, apierror.HomographDomain},
+	{shortener.ErrDomainBlocked, apierror.DomainBlocked},
+	{shortener.ErrRecursiveURL, apierror.RecursiveURL},
+	{shortener.ErrInvalidShortCode, apierror.InvalidCode},
+	{shortener.ErrInvalidAlias, apierror.InvalidAlias},
+	{shortener.ErrAliasTaken, apierror.AliasTaken},
+	{shortener.ErrAliasReserved, apierror.AliasReserved},
+	{shortener.ErrInvalidAlphabet, apierror.InvalidAlphabet},
+	{shortener.ErrInvalidExpiry, apierror.InvalidExpiry},
+	{shortener.ErrInvalidActiveWindow, apierror.InvalidActiveWindow},
+	{shortener.ErrInvalidMaxClicks, apierror.InvalidMaxClicks},
+	{shortener.ErrInvalidTarget, apierror.InvalidTarget},
+	{shortener.ErrInvalidRule, apierror.InvalidRule},
+	{shortener.ErrInvalidExperiment, apierror.InvalidExperiment},
+	{shortener.ErrInvalidMetadata, apierror.InvalidMetadata},
+	{shortener.ErrInvalidAccess, apierror.InvalidAccess},
+	{shortener.ErrInvalidQueryPassthrough, apierror.InvalidPassthrough},
+	{shortener.ErrInvalidMask, apierror.InvalidMask},
+	{shortener.ErrInvalidStatus, apierror.InvalidStatus},
+	{shortener.ErrReasonTooLong, apierror.ReasonTooLong},
+	{shortener.ErrInvalidPeriod, apierror.InvalidPeriod},
+	{shortener.ErrInvalidSeries, apierror.InvalidSeries},
+	{shortener.ErrInvalidImport, apierror.InvalidImport},
+	{shortener.ErrInvalidEmail, apierror.InvalidEmail},
+	{shortener.ErrWeakPassword, apierror.WeakPassword},
+	{shortener.ErrEmailTaken, apierror.EmailTaken},
+	{shortener.ErrUnknownPlan, apierror.UnknownPlan},
+	{shortener.ErrUnknownRole, apierror.UnknownRole},
+	{shortener.ErrInvalidScopes, apierror.InvalidScopes},
+	{shortener.ErrMissingScope, apierror.MissingScope},
+	{shortener.ErrSigningDisabled, apierror.SigningDisabled},
+	{shortener.ErrInvalidOrg, apierror.InvalidOrg},
+	{shortener.ErrInvalidInvitation, apierror.InvalidInvitation},
+	{shortener.ErrAlreadyMember, apierror.AlreadyMember},
+	{shortener.ErrLastMember, apierror.LastMember},
+	{shortener.ErrInvalidCampaign, apierror.InvalidCampaign},
+	{shortener.ErrCampaignExists, apierror.CampaignExists},
+	{shortener.ErrInvalidDomain, apierror.InvalidDomain},
+	{shortener.ErrDomainExists, apierror.DomainExists},
+	{shortener.ErrDomainTaken, apierror.DomainTaken},
+	{shortener.ErrDomainUnverified, apierror.DomainUnverified},
+	{shortener.ErrInvalidDomainRule, apierror.InvalidDomainRule},
+	{shortener.ErrDomainRuleExists, apierror.DomainRuleExists},
+	{shortener.ErrInvalidReservation, apierror.InvalidReservation},
+	{shortener.ErrReservationExists, apierror.ReservationExists},
+}
+
+// errorCode returns the API error code of err, or apierror.InvalidRequest
+// for errors without one.
+func errorCode(err error) string {
+	for _, c := range serviceErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return apierror.InvalidRequest
+}
+
+// writeServiceError responds with status to err, a shortener error caused
+// by the client, using its message.
+func writeServiceError(w http.ResponseWriter, status int, err error) {
+	apierror.Write(w, status, errorCode(err), err.Error())
+}
+
+// writeQuotaError responds with 403 to a request over a limit of the
+// caller's plan. The code is that of the limit, one of the
+// shortener.Quota* constants.
+func writeQuotaError(w http.ResponseWriter, err *shortener.QuotaError) {
+	apierror.WriteError(w, http.StatusForbidden, apierror.Error{
+		Code:    err.Code,
+		Message: "Plan limit reached",
+		Limit:   err.Limit,
+	})
+}
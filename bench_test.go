@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/redis/go-redis/v9"
+)
+
+// The benchmarks below run requests through the router and handlers down to
+// the repository, with Redis in miniredis and PostgreSQL in sqlmock, so that
+// they measure this code rather than the database. Compare builds with
+// benchstat:
+//
+//	go test -run '^$' -bench Handler -benchmem -count 10 . > new.txt
+
+const benchBaseURL = "http://localhost:8080"
+
+// benchRouter routes shorten and redirect requests to a service over repo.
+func benchRouter(repo shortener.Repository) http.Handler {
+	app := &App{
+		Service: shortener.NewService(repo, shortener.WithBaseURL(benchBaseURL)),
+		BaseURL: benchBaseURL,
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/shorten", app.ShortenHandler).Methods("POST")
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET", "HEAD")
+	return r
+}
+
+// benchPostgres returns a PostgreSQL repository over sqlmock and miniredis.
+func benchPostgres(b *testing.B, opts ...shortener.PostgresOption) (*shortener.PostgresRedisRepository, sqlmock.Sqlmock) {
+	b.Helper()
+	mr := miniredis.RunT(b)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	b.Cleanup(func() { redisClient.Close() })
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		b.Fatalf("failed to create mock: %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return shortener.NewPostgresRedisRepository(db, redisClient, append([]shortener.PostgresOption{shortener.WithoutPreparedStatements()}, opts...)...), mock
+}
+
+var benchLinkColumns = []string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata"}
+
+// expectLink expects the lookup of the link with id on a cache miss.
+func expectLink(mock sqlmock.Sqlmock, id uint64) {
+	mock.ExpectQuery(`SELECT .* FROM urls WHERE id = \$1`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(benchLinkColumns).
+			AddRow(id, nil, "https://example.com/landing", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil))
+	mock.ExpectQuery(`SELECT country, url FROM link_targets`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
+}
+
+func BenchmarkShortenHandler(b *testing.B) {
+	body := `{"url":"https://example.com/landing"}`
+	shorten := func(b *testing.B, h http.Handler) {
+		b.Helper()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for range b.N {
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				b.Fatalf("status = %d, body = %s", w.Code, w.Body)
+			}
+		}
+	}
+
+	b.Run("memory", func(b *testing.B) {
+		shorten(b, benchRouter(shortener.NewInMemoryRepository()))
+	})
+	b.Run("postgres", func(b *testing.B) {
+		repo, mock := benchPostgres(b)
+		// Domain rules are cached in Redis after the first request; every
+		// request then checks that the destination is not a custom domain,
+		// inserts the link and records it in the audit log
+		mock.ExpectQuery(`SELECT .* FROM domain_rules`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "pattern", "action", "reason", "created_at"}))
+		for i := range b.N {
+			mock.ExpectQuery(`SELECT .* FROM domains WHERE host = \$1`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "host", "token", "verified_at", "created_at"}))
+			mock.ExpectQuery(`INSERT INTO urls`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(i+1, time.Now()))
+			mock.ExpectQuery(`INSERT INTO audit_log`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(i + 1))
+		}
+		shorten(b, benchRouter(repo))
+	})
+}
+
+func BenchmarkRedirectHandler(b *testing.B) {
+	redirect := func(b *testing.B, h http.Handler, code func(i int) string) {
+		b.Helper()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := range b.N {
+			req := httptest.NewRequest(http.MethodGet, "/"+code(i), nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusFound {
+				b.Fatalf("status = %d, body = %s", w.Code, w.Body)
+			}
+		}
+	}
+	first := func(int) string { return shortener.Encode(1) }
+
+	b.Run("memory", func(b *testing.B) {
+		repo := shortener.NewInMemoryRepository()
+		if _, err := shortener.NewService(repo).Shorten(b.Context(), "https://example.com/landing"); err != nil {
+			b.Fatalf("Shorten() error = %v", err)
+		}
+		redirect(b, benchRouter(repo), first)
+	})
+	// The link is read from the database once, then from Redis
+	b.Run("redis", func(b *testing.B) {
+		repo, mock := benchPostgres(b)
+		expectLink(mock, 1)
+		redirect(b, benchRouter(repo), first)
+	})
+	b.Run("local_cache", func(b *testing.B) {
+		repo, mock := benchPostgres(b, shortener.WithLocalCache(1000, time.Minute))
+		expectLink(mock, 1)
+		redirect(b, benchRouter(repo), first)
+	})
+	// Every request is for another link, missing the caches
+	b.Run("database", func(b *testing.B) {
+		repo, mock := benchPostgres(b)
+		for i := range b.N {
+			expectLink(mock, uint64(i+1))
+		}
+		redirect(b, benchRouter(repo), func(i int) string { return shortener.Encode(uint64(i + 1)) })
+	})
+}
+
+func BenchmarkRedirectHandler_Parallel(b *testing.B) {
+	repo, mock := benchPostgres(b, shortener.WithLocalCache(1000, time.Minute))
+	expectLink(mock, 1)
+	h := benchRouter(repo)
+	path := fmt.Sprintf("/%s", shortener.Encode(1))
+	// Cache the link before the clock starts
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != http.StatusFound {
+				b.Errorf("status = %d", w.Code)
+				return
+			}
+		}
+	})
+}
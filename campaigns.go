@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type CampaignRequest struct {
+	Name string `json:"name"`
+}
+
+type ListCampaignsResponse struct {
+	Campaigns []*shortener.Campaign `json:"campaigns"`
+}
+
+// CreateCampaignHandler creates a campaign of the caller.
+func (a *App) CreateCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	var req CampaignRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := a.Service.CreateCampaign(ctx, user, req.Name)
+	if err != nil {
+		writeCampaignError(w, r, "create campaign", 0, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, c)
+}
+
+// ListCampaignsHandler lists the caller's campaigns, oldest first.
+func (a *App) ListCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	campaigns, err := a.Service.Campaigns(ctx, user)
+	if err != nil {
+		writeCampaignError(w, r, "list campaigns", 0, err)
+		return
+	}
+	writeJSON(w, r, ListCampaignsResponse{Campaigns: campaigns})
+}
+
+// GetCampaignHandler returns a campaign of the caller.
+func (a *App) GetCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := a.Service.Campaign(ctx, user, id)
+	if err != nil {
+		writeCampaignError(w, r, "get campaign", id, err)
+		return
+	}
+	writeJSON(w, r, c)
+}
+
+// RenameCampaignHandler renames a campaign of the caller.
+func (a *App) RenameCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+	var req CampaignRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	c, err := a.Service.RenameCampaign(ctx, user, id, req.Name)
+	if err != nil {
+		writeCampaignError(w, r, "rename campaign", id, err)
+		return
+	}
+	writeJSON(w, r, c)
+}
+
+// DeleteCampaignHandler deletes a campaign of the caller. Its links are kept.
+func (a *App) DeleteCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.DeleteCampaign(ctx, user, id); err != nil {
+		writeCampaignError(w, r, "delete campaign", id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CampaignURLsHandler lists the links of a campaign of the caller, newest
+// first.
+func (a *App) CampaignURLsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	links, next, err := a.Service.CampaignLinks(ctx, user, id, cursor, limit)
+	if err != nil {
+		writeCampaignError(w, r, "list campaign urls", id, err)
+		return
+	}
+	writeJSON(w, r, a.listURLsResponse(links, next))
+}
+
+// CampaignStatsHandler returns the clicks, unique visitors and most clicked
+// links of a campaign of the caller. It takes the same period and limit
+// query parameters as TopStatsHandler.
+func (a *App) CampaignStatsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseCampaignID(w, r)
+	if !ok {
+		return
+	}
+	period := shortener.DefaultStatsPeriod
+	if v := r.URL.Query().Get("period"); v != "" {
+		p, err := parseStatsPeriod(v)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidPeriod, "Invalid period. Must be a number of days (e.g. 7d) or hours (e.g. 12h)")
+			return
+		}
+		period = p
+	}
+	limit, ok := parseLimit(w, r, shortener.MaxTopLimit)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := a.Service.CampaignStats(ctx, user, id, period, limit)
+	if err != nil {
+		writeCampaignError(w, r, "campaign stats", id, err)
+		return
+	}
+	writeJSONWithETag(w, r, stats)
+}
+
+// parseCampaignID reads the campaignID path variable, writing a 400
+// response if it is not a number.
+func parseCampaignID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["campaignID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid campaign ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeCampaignError(w http.ResponseWriter, r *http.Request, op string, id uint64, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+		slog.WarnContext(r.Context(), op+" timeout", "campaign_id", id, "error", err)
+	case errors.Is(err, shortener.ErrInvalidCampaign), errors.Is(err, shortener.ErrInvalidPeriod):
+		writeServiceError(w, http.StatusBadRequest, err)
+	case errors.Is(err, shortener.ErrCampaignExists):
+		apierror.Write(w, http.StatusConflict, apierror.CampaignExists, "Campaign name already in use")
+	case errors.Is(err, shortener.ErrNotFound):
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Campaign not found")
+	default:
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(r.Context(), op+" failed", "campaign_id", id, "error", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Command loadtest drives a running instance of the URL shortener with a mix
+// of shorten and redirect requests and reports the latency percentiles of
+// each, so that performance can be compared between builds and deployments.
+//
+// Usage:
+//
+//	loadtest [flags]
+//
+// It first creates -links links, then runs -concurrency workers for
+// -duration, each sending a shorten request with probability -write-ratio
+// and a redirect to one of the links otherwise. The sequence of requests of
+// each worker only depends on -seed, so runs with the same flags are
+// comparable. Per-IP rate limits of the target should be disabled.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// config holds the flags of a run.
+type config struct {
+	baseURL     string
+	apiKey      string
+	duration    time.Duration
+	concurrency int
+	links       int
+	writeRatio  float64
+	seed        uint64
+}
+
+// Operations measured by a run.
+const (
+	opShorten  = "shorten"
+	opRedirect = "redirect"
+)
+
+// opReport is the outcome of the requests of one operation.
+type opReport struct {
+	Op       string  `json:"op"`
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	RPS      float64 `json:"rps"`
+	// Latencies are in milliseconds, over the requests that succeeded.
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+// samples collects the latencies and errors of the requests of one worker,
+// by operation.
+type samples struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func newSamples() *samples {
+	return &samples{latencies: map[string][]time.Duration{}, errors: map[string]int{}}
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.baseURL, "url", "http://localhost:8080", "base URL of the instance under test")
+	flag.StringVar(&cfg.apiKey, "api-key", "", "API key sent with shorten requests")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to send requests for")
+	flag.IntVar(&cfg.concurrency, "concurrency", 50, "number of concurrent workers")
+	flag.IntVar(&cfg.links, "links", 100, "number of links created up front to redirect to")
+	flag.Float64Var(&cfg.writeRatio, "write-ratio", 0.1, "fraction of requests that shorten a URL rather than redirect")
+	flag.Uint64Var(&cfg.seed, "seed", 1, "seed of the request sequence")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	if cfg.concurrency < 1 || cfg.links < 1 || cfg.writeRatio < 0 || cfg.writeRatio > 1 {
+		fmt.Fprintln(os.Stderr, "loadtest: -concurrency and -links must be positive and -write-ratio between 0 and 1")
+		os.Exit(2)
+	}
+
+	report, err := run(context.Background(), cfg, newClient(cfg.concurrency))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		err = json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		err = printReport(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest:", err)
+		os.Exit(1)
+	}
+}
+
+// newClient returns a client that keeps a connection per worker alive and
+// does not follow redirects, so that redirects measure the shortener alone.
+func newClient(concurrency int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// run creates the links to redirect to, sends requests for cfg.duration and
+// returns a report per operation.
+func run(ctx context.Context, cfg config, client *http.Client) ([]opReport, error) {
+	base := strings.TrimSuffix(cfg.baseURL, "/")
+	codes := make([]string, 0, cfg.links)
+	for i := range cfg.links {
+		code, err := shorten(ctx, client, base, cfg.apiKey, fmt.Sprintf("https://example.com/loadtest/setup/%d", i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create links: %w", err)
+		}
+		codes = append(codes, code)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+	results := make([]*samples, cfg.concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := range cfg.concurrency {
+		results[w] = newSamples()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(ctx, cfg, client, base, codes, uint64(w), results[w])
+		}()
+	}
+	wg.Wait()
+	return summarize(results, time.Since(start)), nil
+}
+
+// work sends requests until ctx is done and records them in s. Requests cut
+// short by the end of the run are not recorded.
+func work(ctx context.Context, cfg config, client *http.Client, base string, codes []string, worker uint64, s *samples) {
+	rng := rand.New(rand.NewPCG(cfg.seed, worker))
+	for i := 0; ctx.Err() == nil; i++ {
+		op, err := opRedirect, error(nil)
+		begin := time.Now()
+		if rng.Float64() < cfg.writeRatio {
+			op = opShorten
+			_, err = shorten(ctx, client, base, cfg.apiKey, fmt.Sprintf("https://example.com/loadtest/%d/%d/%d", cfg.seed, worker, i))
+		} else {
+			err = redirect(ctx, client, base, codes[rng.IntN(len(codes))])
+		}
+		elapsed := time.Since(begin)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.errors[op]++
+			continue
+		}
+		s.latencies[op] = append(s.latencies[op], elapsed)
+	}
+}
+
+// shorten creates a link to rawURL and returns its code.
+func shorten(ctx context.Context, client *http.Client, base, apiKey, rawURL string) (string, error) {
+	body, err := json.Marshal(map[string]string{"url": rawURL})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/api/shorten", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to shorten: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("shorten answered %s", resp.Status)
+	}
+	var created struct {
+		ShortCode string `json:"short_code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("failed to decode shorten response: %w", err)
+	}
+	if created.ShortCode == "" {
+		return "", errors.New("shorten response has no short_code")
+	}
+	return created.ShortCode, nil
+}
+
+// redirect requests the link code and expects a redirect.
+func redirect(ctx context.Context, client *http.Client, base, code string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/"+code, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to redirect: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection is reused
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return fmt.Errorf("failed to read redirect response: %w", err)
+	}
+	if resp.StatusCode != http.StatusFound {
+		return fmt.Errorf("redirect answered %s", resp.Status)
+	}
+	return nil
+}
+
+// summarize merges the samples of the workers of a run that took elapsed.
+func summarize(results []*samples, elapsed time.Duration) []opReport {
+	reports := make([]opReport, 0, 2)
+	for _, op := range []string{opShorten, opRedirect} {
+		var latencies []time.Duration
+		errs := 0
+		for _, s := range results {
+			latencies = append(latencies, s.latencies[op]...)
+			errs += s.errors[op]
+		}
+		slices.Sort(latencies)
+		r := opReport{Op: op, Requests: len(latencies) + errs, Errors: errs}
+		if elapsed > 0 {
+			r.RPS = float64(r.Requests) / elapsed.Seconds()
+		}
+		if len(latencies) > 0 {
+			r.P50 = ms(percentile(latencies, 0.50))
+			r.P95 = ms(percentile(latencies, 0.95))
+			r.P99 = ms(percentile(latencies, 0.99))
+			r.Max = ms(latencies[len(latencies)-1])
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, which must
+// not be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printReport writes reports as a table.
+func printReport(w io.Writer, reports []opReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\terrors\trps\tp50 ms\tp95 ms\tp99 ms\tmax ms\t")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", r.Op, r.Requests, r.Errors, r.RPS, r.P50, r.P95, r.P99, r.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{"median", sorted, 0.50, 50 * time.Millisecond},
+		{"p95", sorted, 0.95, 95 * time.Millisecond},
+		{"p99", sorted, 0.99, 99 * time.Millisecond},
+		{"max", sorted, 1, 100 * time.Millisecond},
+		{"zero", sorted, 0, time.Millisecond},
+		{"single", []time.Duration{time.Second}, 0.99, time.Second},
+		{"few", []time.Duration{1, 2, 3}, 0.5, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	var created atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"short_code": fmt.Sprint(created.Add(1))})
+	})
+	mux.HandleFunc("GET /{code}", func(w http.ResponseWriter, r *http.Request) {
+		// Redirects to the second link fail
+		if r.PathValue("code") == "2" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "https://example.com/", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := config{
+		baseURL:     srv.URL + "/",
+		apiKey:      "sk_test",
+		duration:    200 * time.Millisecond,
+		concurrency: 4,
+		links:       2,
+		writeRatio:  0.2,
+		seed:        1,
+	}
+	reports, err := run(context.Background(), cfg, newClient(cfg.concurrency))
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(reports) != 2 || reports[0].Op != opShorten || reports[1].Op != opRedirect {
+		t.Fatalf("run() = %+v, want shorten and redirect reports", reports)
+	}
+	for _, r := range reports {
+		if r.Requests == 0 || r.RPS <= 0 || r.P50 <= 0 || r.P50 > r.P95 || r.P95 > r.P99 || r.P99 > r.Max {
+			t.Errorf("%s report = %+v, want requests with ordered percentiles", r.Op, r)
+		}
+	}
+	// Requests cut short by the end of the run may have created links but
+	// are not reported
+	if shorten := reports[0]; shorten.Errors != 0 || int64(shorten.Requests) > created.Load()-2 || int64(shorten.Requests)+int64(cfg.concurrency) < created.Load()-2 {
+		t.Errorf("shorten report = %+v, want the %d links created", shorten, created.Load()-2)
+	}
+	if redirect := reports[1]; redirect.Errors == 0 || redirect.Errors == redirect.Requests {
+		t.Errorf("redirect report = %+v, want the redirects to the second link failed", redirect)
+	}
+
+	var out strings.Builder
+	if err := printReport(&out, reports); err != nil {
+		t.Fatalf("printReport() error = %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[0], "p99 ms") {
+		t.Errorf("printReport() =\n%s\nwant a header and a line per operation", out.String())
+	}
+
+	cfg.apiKey = ""
+	if _, err := run(context.Background(), cfg, newClient(cfg.concurrency)); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("run() without API key error = %v, want the failed setup", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// apiBackend talks to a running instance over its HTTP API.
+type apiBackend struct {
+	server     string
+	apiKey     string
+	adminToken string
+	client     *http.Client
+}
+
+func newAPIBackend(server, apiKey, adminToken string) *apiBackend {
+	return &apiBackend{
+		server:     strings.TrimSuffix(server, "/"),
+		apiKey:     apiKey,
+		adminToken: adminToken,
+		client:     &http.Client{},
+	}
+}
+
+func (b *apiBackend) Shorten(ctx context.Context, req shortenRequest) (*shortenResponse, error) {
+	var resp shortenResponse
+	if err := b.do(ctx, "POST", "/api/shorten", b.apiKey, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *apiBackend) Lookup(ctx context.Context, code string) (*link, error) {
+	if err := b.requireAdmin(); err != nil {
+		return nil, err
+	}
+	var l link
+	if err := b.do(ctx, "GET", "/api/admin/links/"+url.PathEscape(code), b.adminToken, nil, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Delete deletes any link with the admin token, or else a link owned by the
+// user of the API key.
+func (b *apiBackend) Delete(ctx context.Context, code string) error {
+	if b.adminToken != "" {
+		return b.do(ctx, "DELETE", "/api/admin/links/"+url.PathEscape(code), b.adminToken, nil, nil)
+	}
+	if b.apiKey == "" {
+		return errors.New("delete needs -admin-token, or -api-key of the owner")
+	}
+	return b.do(ctx, "DELETE", "/api/urls/"+url.PathEscape(code), b.apiKey, nil, nil)
+}
+
+func (b *apiBackend) Stats(ctx context.Context) (*shortener.Stats, error) {
+	if err := b.requireAdmin(); err != nil {
+		return nil, err
+	}
+	var st shortener.Stats
+	if err := b.do(ctx, "GET", "/api/admin/stats", b.adminToken, nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (b *apiBackend) Migrate(ctx context.Context, file string, shardsOnly bool) error {
+	return errors.New("migrate runs against the database: use -direct")
+}
+
+func (b *apiBackend) Reshard(ctx context.Context, strategy string, dsns []string, bounds []uint64) (int, error) {
+	return 0, errors.New("reshard runs against the databases: use -direct")
+}
+
+// Export copies the download of GET /api/admin/export to w.
+func (b *apiBackend) Export(ctx context.Context, format string, w io.Writer) error {
+	if err := b.requireAdmin(); err != nil {
+		return err
+	}
+	resp, err := b.send(ctx, "GET", "/api/admin/export?format="+url.QueryEscape(format), b.adminToken, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Import uploads r to POST /api/admin/import.
+func (b *apiBackend) Import(ctx context.Context, format string, r io.Reader, keepOwners bool) (*shortener.ImportResult, error) {
+	if err := b.requireAdmin(); err != nil {
+		return nil, err
+	}
+	contentType := "application/x-ndjson"
+	if format == shortener.ExportCSV {
+		contentType = "text/csv"
+	}
+	query := url.Values{"format": {format}, "keep_owners": {strconv.FormatBool(keepOwners)}}
+	resp, err := b.send(ctx, "POST", "/api/admin/import?"+query.Encode(), b.adminToken, contentType, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res shortener.ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &res, nil
+}
+
+func (b *apiBackend) Close() error {
+	return nil
+}
+
+func (b *apiBackend) requireAdmin() error {
+	if b.adminToken == "" {
+		return errors.New("this command needs -admin-token (or ADMIN_TOKEN), or -direct")
+	}
+	return nil
+}
+
+// do sends a request with body encoded as JSON, authenticated with the
+// bearer token if any, and decodes a successful response into out.
+func (b *apiBackend) do(ctx context.Context, method, path, token string, body, out any) error {
+	var r io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r, contentType = bytes.NewReader(data), "application/json"
+	}
+	resp, err := b.send(ctx, method, path, token, contentType, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// send sends a request with body of contentType, authenticated with the
+// bearer token if any, and returns the response if it is successful. Error
+// responses are returned with their status and message.
+func (b *apiBackend) send(ctx context.Context, method, path, token, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %s: failed to read error: %w", method, path, resp.Status, err)
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, errorMessage(msg))
+	}
+	return resp, nil
+}
+
+// errorMessage returns the message of an API error body, or the body
+// itself if it is not one (e.g. from a proxy in front of the server).
+func errorMessage(body []byte) string {
+	var resp apierror.Response
+	if err := json.Unmarshal(body, &resp); err == nil && resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/hszk-dev/url-shortener/internal/config"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// directBackend works on the storage backend of the server, bypassing the
+// API. Deletions still drop cached copies when REDIS_ADDR is set.
+type directBackend struct {
+	cfg *config.Config
+	// dbs are the PostgreSQL databases, primary first, then the shards in
+	// SHARD_DSNS.
+	dbs     []*sql.DB
+	repo    shortener.Repository
+	service *shortener.Service
+}
+
+// newDirectBackend opens the storage backend configured by the server's
+// environment variables.
+func newDirectBackend(getenv func(string) string) (*directBackend, error) {
+	cfg, err := config.Load(nil, getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &directBackend{cfg: cfg}
+	switch cfg.StorageBackend {
+	case config.BackendPostgres:
+		var redisClient *redis.Client
+		if cfg.RedisAddr != "" {
+			redisClient = redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+		}
+		var shards []shortener.Repository
+		for i, dsn := range append([]string{cfg.Postgres.DSN()}, cfg.ShardDSNs...) {
+			db, err := sql.Open("postgres", dsn)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open database: %w", err)
+			}
+			b.dbs = append(b.dbs, db)
+			shards = append(shards, shortener.NewPostgresRedisRepository(db, redisClient,
+				shortener.WithoutPreparedStatements(), shortener.WithShardIndex(i)))
+		}
+		b.repo = shards[0]
+		if len(shards) > 1 {
+			m, err := shardMap(cfg.ShardStrategy, len(shards), cfg.ShardBounds)
+			if err != nil {
+				return nil, err
+			}
+			if b.repo, err = shortener.NewShardedRepository(m, shards...); err != nil {
+				return nil, err
+			}
+		}
+	case config.BackendSQLite:
+		repo, err := shortener.NewSQLiteRepository(cfg.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		b.repo = repo
+	case config.BackendDynamoDB:
+		repo, err := shortener.NewDynamoDBRepository(shortener.DynamoDBConfig{
+			Table:           cfg.DynamoDB.Table,
+			Region:          cfg.DynamoDB.Region,
+			Endpoint:        cfg.DynamoDB.Endpoint,
+			AccessKeyID:     cfg.DynamoDB.AccessKeyID,
+			SecretAccessKey: cfg.DynamoDB.SecretAccessKey,
+			SessionToken:    cfg.DynamoDB.SessionToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		b.repo = repo
+	default:
+		return nil, fmt.Errorf("-direct needs a persistent STORAGE_BACKEND, not %q", cfg.StorageBackend)
+	}
+	// Shorten goes through the API, so the strategy only tells imports
+	// whether to advance the ID sequence past the imported links
+	var opts []shortener.Option
+	switch cfg.CodeStrategy {
+	case config.CodeRandom:
+		opts = append(opts, shortener.WithCodeStrategy(shortener.RandomCodes{Length: cfg.RandomCodeLength}))
+	case config.CodeSnowflake:
+		opts = append(opts, shortener.WithCodeStrategy(shortener.SnowflakeCodes{}))
+	case config.CodeObfuscated:
+		codes, err := shortener.NewObfuscatedCodes([]byte(cfg.CodeObfuscationKey))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, shortener.WithCodeStrategy(codes))
+	}
+	b.service = shortener.NewService(b.repo, opts...)
+	return b, nil
+}
+
+// Shorten is not supported: links must be created through the API, which
+// applies CODE_STRATEGY, URL screening and plan quotas.
+func (b *directBackend) Shorten(ctx context.Context, req shortenRequest) (*shortenResponse, error) {
+	return nil, errors.New("shorten goes through the API, which applies the code strategy, URL screening and quotas: drop -direct")
+}
+
+func (b *directBackend) Lookup(ctx context.Context, code string) (*link, error) {
+	l, err := b.service.Resolve(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	status := l.Status
+	if status == "" {
+		status = shortener.StatusActive
+	}
+	return &link{
+		ShortCode:   l.ShortCode(),
+		ShortURL:    b.cfg.BaseURL + "/" + l.ShortCode(),
+		OriginalURL: l.OriginalURL,
+		CreatedAt:   l.CreatedAt,
+		ExpiresAt:   l.ExpiresAt,
+		MaxClicks:   l.MaxClicks,
+		Clicks:      l.Clicks,
+		Status:      string(status),
+	}, nil
+}
+
+func (b *directBackend) Delete(ctx context.Context, code string) error {
+	return b.service.AdminDelete(ctx, code)
+}
+
+func (b *directBackend) Stats(ctx context.Context) (*shortener.Stats, error) {
+	return b.service.Stats(ctx)
+}
+
+// Migrate applies the schema in file to every PostgreSQL database, or only
+// to the shards other than the primary. SQLite databases and DynamoDB tables
+// are set up when they are opened, which has already happened.
+func (b *directBackend) Migrate(ctx context.Context, file string, shardsOnly bool) error {
+	schema, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+	dbs := b.dbs
+	if shardsOnly && len(dbs) > 0 {
+		dbs = dbs[1:]
+	}
+	for i, db := range dbs {
+		if _, err := db.ExecContext(ctx, string(schema)); err != nil {
+			if len(b.dbs) > 1 {
+				return fmt.Errorf("failed to apply schema to shard %d: %w", i+len(b.dbs)-len(dbs), err)
+			}
+			return fmt.Errorf("failed to apply schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// Reshard moves links to the shards of the layout the server would load
+// with SHARD_STRATEGY=strategy, SHARD_BOUNDS=bounds and SHARD_DSNS=dsns, or
+// the current SHARD_DSNS if dsns is nil. Current shards missing from dsns
+// are emptied.
+func (b *directBackend) Reshard(ctx context.Context, strategy string, dsns []string, bounds []uint64) (int, error) {
+	if len(b.dbs) == 0 {
+		return 0, errors.New("reshard needs STORAGE_BACKEND=postgres")
+	}
+	if dsns == nil {
+		dsns = b.cfg.ShardDSNs
+	}
+	m, err := shardMap(strategy, len(dsns)+1, bounds)
+	if err != nil {
+		return 0, err
+	}
+
+	// The new layout first, then the shards it retires
+	dbs := []*sql.DB{b.dbs[0]}
+	for _, dsn := range dsns {
+		if i := slices.Index(b.cfg.ShardDSNs, dsn); i >= 0 {
+			dbs = append(dbs, b.dbs[i+1])
+			continue
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+	for i, dsn := range b.cfg.ShardDSNs {
+		if !slices.Contains(dsns, dsn) {
+			dbs = append(dbs, b.dbs[i+1])
+		}
+	}
+	return shortener.Reshard(ctx, dbs, m)
+}
+
+// shardMap builds the ShardMap of n shards selected by SHARD_STRATEGY.
+func shardMap(strategy string, n int, bounds []uint64) (shortener.ShardMap, error) {
+	switch strategy {
+	case config.ShardHash:
+		return shortener.HashShards(n)
+	case config.ShardRange:
+		if len(bounds) != n-1 {
+			return shortener.ShardMap{}, fmt.Errorf("%d shards need %d bounds, got %d", n, n-1, len(bounds))
+		}
+		return shortener.RangeShards(bounds...)
+	default:
+		return shortener.ShardMap{}, fmt.Errorf("unknown shard strategy %q", strategy)
+	}
+}
+
+// Export writes every link to w with the encoder of format.
+func (b *directBackend) Export(ctx context.Context, format string, w io.Writer) error {
+	enc, err := shortener.NewLinkEncoder(w, format)
+	if err != nil {
+		return err
+	}
+	if err := b.service.ExportLinks(ctx, enc.Encode); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func (b *directBackend) Import(ctx context.Context, format string, r io.Reader, keepOwners bool) (*shortener.ImportResult, error) {
+	dec, err := shortener.NewLinkDecoder(r, format)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.service.ImportLinks(ctx, dec.Decode, shortener.ImportOptions{KeepOwners: keepOwners})
+	return &res, err
+}
+
+func (b *directBackend) Close() error {
+	return b.repo.Close()
+}
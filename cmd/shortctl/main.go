@@ -0,0 +1,370 @@
+// Command shortctl is an operator tool for the URL shortener. It shortens
+// URLs, looks up and deletes links, prints stats, exports and imports links
+// and applies migrations, either through the HTTP API of a running instance or, with -direct,
+// against the storage backend configured by the server's environment
+// variables (STORAGE_BACKEND, DB_*, SQLITE_PATH, REDIS_ADDR).
+//
+// Usage:
+//
+//	shortctl [global flags] <command> [flags] [args]
+//
+// Run shortctl -h for the list of commands and flags.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// backend performs the operations of shortctl, through the API or directly
+// against the database.
+type backend interface {
+	Shorten(ctx context.Context, req shortenRequest) (*shortenResponse, error)
+	Lookup(ctx context.Context, code string) (*link, error)
+	Delete(ctx context.Context, code string) error
+	Stats(ctx context.Context) (*shortener.Stats, error)
+	Migrate(ctx context.Context, file string, shardsOnly bool) error
+	Reshard(ctx context.Context, strategy string, dsns []string, bounds []uint64) (int, error)
+	Export(ctx context.Context, format string, w io.Writer) error
+	Import(ctx context.Context, format string, r io.Reader, keepOwners bool) (*shortener.ImportResult, error)
+	Close() error
+}
+
+// shortenRequest and shortenResponse mirror the /api/shorten payloads.
+type shortenRequest struct {
+	URL         string `json:"url"`
+	CustomAlias string `json:"custom_alias,omitempty"`
+	TTLSeconds  int64  `json:"ttl_seconds,omitempty"`
+	MaxClicks   int64  `json:"max_clicks,omitempty"`
+}
+
+type shortenResponse struct {
+	ShortCode string `json:"short_code"`
+	ShortURL  string `json:"short_url"`
+}
+
+// link is printed by lookup, in the format of the items of GET /api/me/urls.
+type link struct {
+	ShortCode   string     `json:"short_code"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int64      `json:"max_clicks,omitempty"`
+	Clicks      int64      `json:"clicks"`
+	Status      string     `json:"status"`
+}
+
+// errUsage reports invalid arguments; the usage has already been printed.
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand of shortctl.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, env *env, args []string) error
+}
+
+var commands = []command{
+	{"shorten", "[-alias code] [-ttl duration] [-max-clicks n] URL", "create a short link", runShorten},
+	{"lookup", "CODE", "show the link behind a short code, whatever its status", runLookup},
+	{"delete", "CODE", "delete a link, whoever owns it", runDelete},
+	{"stats", "", "print aggregate link counts", runStats},
+	{"migrate", "[-file init.sql] [-shards-only]", "apply the PostgreSQL schema (with -direct)", runMigrate},
+	{"reshard", "-strategy range|hash [-bounds ids] [-dsns urls]", "move links to a new shard layout (with -direct, instances stopped)", runReshard},
+	{"export", "[-format ndjson|csv] [-o file]", "write every link, e.g. to move to another storage backend", runExport},
+	{"import", "[-format ndjson|csv] [-keep-owners] FILE", "store the links of an export under their codes (- reads stdin)", runImport},
+}
+
+// env is what commands run with.
+type env struct {
+	cmd     *command
+	backend backend
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func main() {
+	ctx := context.Background()
+	if err := run(ctx, os.Args[1:], os.Getenv, os.Stdout, os.Stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "shortctl:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags, picks the backend and runs the command named
+// by the first remaining argument.
+func run(ctx context.Context, args []string, getenv func(string) string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("shortctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", envOr(getenv, "SHORTCTL_SERVER", "http://localhost:8080"), "base URL of the running instance (env SHORTCTL_SERVER)")
+	apiKey := fs.String("api-key", getenv("SHORTCTL_API_KEY"), "API key whose user owns new links (env SHORTCTL_API_KEY)")
+	adminToken := fs.String("admin-token", getenv("ADMIN_TOKEN"), "admin token for lookup, delete and stats (env ADMIN_TOKEN)")
+	direct := fs.Bool("direct", false, "use the database configured by the server's environment variables instead of the API")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of the command")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: shortctl [global flags] <command> [flags] [args]")
+		fmt.Fprintln(stderr, "\nCommands:")
+		for _, c := range commands {
+			fmt.Fprintf(stderr, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintln(stderr, "\nGlobal flags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "shortctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+
+	var b backend
+	if *direct {
+		d, err := newDirectBackend(getenv)
+		if err != nil {
+			return err
+		}
+		b = d
+	} else {
+		b = newAPIBackend(*server, *apiKey, *adminToken)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	return cmd.run(ctx, &env{cmd: cmd, backend: b, stdout: stdout, stderr: stderr}, fs.Args()[1:])
+}
+
+// flags returns a flag set for the running command that prints its usage.
+func (e *env) flags() *flag.FlagSet {
+	c := e.cmd
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: shortctl %s %s\n\n%s.\n", c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args and checks that n positional arguments remain.
+func (e *env) parse(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func runShorten(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	alias := fs.String("alias", "", "custom short code")
+	ttl := fs.Duration("ttl", 0, "expire the link after this long (e.g. 720h)")
+	maxClicks := fs.Int64("max-clicks", 0, "expire the link after this many redirects")
+	if err := e.parse(fs, args, 1); err != nil {
+		return err
+	}
+	if *ttl < 0 || *ttl%time.Second != 0 {
+		return fmt.Errorf("-ttl must be a positive number of seconds")
+	}
+
+	resp, err := e.backend.Shorten(ctx, shortenRequest{
+		URL:         fs.Arg(0),
+		CustomAlias: *alias,
+		TTLSeconds:  int64(*ttl / time.Second),
+		MaxClicks:   *maxClicks,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.stdout, resp.ShortURL)
+	return err
+}
+
+func runLookup(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := e.parse(fs, args, 1); err != nil {
+		return err
+	}
+	l, err := e.backend.Lookup(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(e.stdout, l)
+}
+
+func runDelete(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := e.parse(fs, args, 1); err != nil {
+		return err
+	}
+	if err := e.backend.Delete(ctx, fs.Arg(0)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(e.stdout, "deleted %s\n", fs.Arg(0))
+	return err
+}
+
+func runStats(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	if err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	st, err := e.backend.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	return printJSON(e.stdout, st)
+}
+
+func runMigrate(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	file := fs.String("file", "init.sql", "schema to apply; its statements must be idempotent")
+	shardsOnly := fs.Bool("shards-only", false, "apply the schema to the shards in SHARD_DSNS but not the primary (e.g. shard.sql)")
+	if err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if err := e.backend.Migrate(ctx, *file, *shardsOnly); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(e.stdout, "schema is up to date")
+	return err
+}
+
+func runReshard(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	strategy := fs.String("strategy", "", "shard strategy of the new layout: range or hash")
+	bounds := fs.String("bounds", "", "comma-separated exclusive upper ID bounds of every shard but the last (range)")
+	dsns := fs.String("dsns", "", "comma-separated PostgreSQL URLs of the shards besides the primary (default: those in SHARD_DSNS)")
+	if err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *strategy == "" {
+		fs.Usage()
+		return errUsage
+	}
+	var ids []uint64
+	for _, s := range splitList(*bounds) {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("-bounds %q: must be a comma-separated list of IDs", *bounds)
+		}
+		ids = append(ids, n)
+	}
+	// Without -dsns the shards stay the same; -dsns= retires them all
+	var urls []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "dsns" {
+			urls = append([]string{}, splitList(*dsns)...)
+		}
+	})
+
+	n, err := e.backend.Reshard(ctx, *strategy, urls, ids)
+	if err != nil {
+		return fmt.Errorf("reshard stopped after moving %d links: %w", n, err)
+	}
+	_, err = fmt.Fprintf(e.stdout, "moved %d links\n", n)
+	return err
+}
+
+func runExport(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	format := fs.String("format", shortener.ExportNDJSON, "ndjson or csv")
+	out := fs.String("o", "", "file to write (default stdout)")
+	if err := e.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *out == "" {
+		return e.backend.Export(ctx, *format, e.stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := e.backend.Export(ctx, *format, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runImport(ctx context.Context, e *env, args []string) error {
+	fs := e.flags()
+	format := fs.String("format", shortener.ExportNDJSON, "ndjson or csv")
+	keepOwners := fs.Bool("keep-owners", false, "keep the owners, campaigns and domains of the links, which must exist")
+	if err := e.parse(fs, args, 1); err != nil {
+		return err
+	}
+	r := os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	res, err := e.backend.Import(ctx, *format, r, *keepOwners)
+	if res != nil {
+		fmt.Fprintf(e.stdout, "imported %d links, skipped %d taken codes\n", res.Imported, res.Skipped)
+	}
+	return err
+}
+
+// splitList splits a comma-separated flag value, ignoring blanks.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// envOr returns the environment variable key, or def if it is unset.
+func envOr(getenv func(string) string, key, def string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+const adminToken = "0123456789abcdef"
+
+// fakeAPI serves the endpoints used by shortctl for the link "abc".
+func fakeAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	requireAuth := func(w http.ResponseWriter, r *http.Request, token string) bool {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Unauthorized")
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("POST /api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		var req shortenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL != "https://example.com" || req.CustomAlias != "abc" || req.TTLSeconds != 3600 {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidBody, "Invalid request body")
+			return
+		}
+		json.NewEncoder(w).Encode(shortenResponse{ShortCode: "abc", ShortURL: "http://sho.rt/abc"})
+	})
+	mux.HandleFunc("GET /api/admin/links/{code}", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth(w, r, adminToken) {
+			return
+		}
+		if r.PathValue("code") != "abc" {
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "URL not found")
+			return
+		}
+		json.NewEncoder(w).Encode(link{ShortCode: "abc", OriginalURL: "https://example.com", Status: "disabled"})
+	})
+	mux.HandleFunc("DELETE /api/admin/links/abc", func(w http.ResponseWriter, r *http.Request) {
+		if requireAuth(w, r, adminToken) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("DELETE /api/urls/abc", func(w http.ResponseWriter, r *http.Request) {
+		if requireAuth(w, r, "owner-key") {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("GET /api/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		if requireAuth(w, r, adminToken) {
+			json.NewEncoder(w).Encode(shortener.Stats{Total: 3, Active: 2, Disabled: 1})
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func runCLI(t *testing.T, env map[string]string, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, func(key string) string { return env[key] }, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRun_API(t *testing.T) {
+	srv := fakeAPI(t)
+	env := map[string]string{"SHORTCTL_SERVER": srv.URL, "ADMIN_TOKEN": adminToken}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "shorten", args: []string{"shorten", "-alias", "abc", "-ttl", "1h", "https://example.com"}, want: "http://sho.rt/abc\n"},
+		{name: "lookup", args: []string{"lookup", "abc"}, want: `"status": "disabled"`},
+		{name: "lookup missing", args: []string{"lookup", "zzz"}, wantErr: "404 Not Found: URL not found"},
+		{name: "delete as admin", args: []string{"delete", "abc"}, want: "deleted abc\n"},
+		{name: "delete as owner", env: map[string]string{"SHORTCTL_SERVER": srv.URL}, args: []string{"-api-key", "owner-key", "delete", "abc"}, want: "deleted abc\n"},
+		{name: "delete without credentials", env: map[string]string{"SHORTCTL_SERVER": srv.URL}, args: []string{"delete", "abc"}, wantErr: "-admin-token"},
+		{name: "stats", args: []string{"stats"}, want: `"total": 3`},
+		{name: "wrong admin token", args: []string{"-admin-token", "wrong", "stats"}, wantErr: "401"},
+		{name: "stats without token", env: map[string]string{"SHORTCTL_SERVER": srv.URL}, args: []string{"stats"}, wantErr: "-admin-token"},
+		{name: "migrate", args: []string{"migrate"}, wantErr: "-direct"},
+		{name: "server flag overrides env", env: map[string]string{"SHORTCTL_SERVER": "http://127.0.0.1:1"}, args: []string{"-server", srv.URL, "-admin-token", adminToken, "stats"}, want: `"total": 3`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := env
+			if tt.env != nil {
+				e = tt.env
+			}
+			got, err := runCLI(t, e, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("run() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run() unexpected error = %v", err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("output = %q, want containing %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_Direct(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortener.db")
+	repo, err := shortener.NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() unexpected error = %v", err)
+	}
+	code, err := shortener.NewService(repo).Shorten(context.Background(), "https://example.com/direct")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	repo.Close()
+
+	env := map[string]string{"STORAGE_BACKEND": "sqlite", "SQLITE_PATH": path, "BASE_URL": "http://sho.rt"}
+	out, err := runCLI(t, env, "-direct", "lookup", code)
+	if err != nil {
+		t.Fatalf("lookup unexpected error = %v", err)
+	}
+	var l link
+	if err := json.Unmarshal([]byte(out), &l); err != nil {
+		t.Fatalf("failed to decode lookup output %q: %v", out, err)
+	}
+	if l.ShortURL != "http://sho.rt/"+code || l.OriginalURL != "https://example.com/direct" || l.Status != "active" {
+		t.Errorf("lookup = %+v", l)
+	}
+
+	if out, err := runCLI(t, env, "-direct", "stats"); err != nil || !strings.Contains(out, `"total": 1`) {
+		t.Errorf("stats = %q, %v, want 1 link", out, err)
+	}
+	if _, err := runCLI(t, env, "-direct", "delete", code); err != nil {
+		t.Fatalf("delete unexpected error = %v", err)
+	}
+	if _, err := runCLI(t, env, "-direct", "lookup", code); !errors.Is(err, shortener.ErrNotFound) {
+		t.Errorf("lookup after delete error = %v, want ErrNotFound", err)
+	}
+	if _, err := runCLI(t, env, "-direct", "shorten", "https://example.com"); err == nil {
+		t.Error("shorten with -direct succeeded, want an error")
+	}
+
+	// SQLite databases are migrated when opened
+	schema := filepath.Join(t.TempDir(), "init.sql")
+	if _, err := runCLI(t, env, "-direct", "migrate", "-file", schema); err == nil {
+		t.Error("migrate with a missing schema file succeeded, want an error")
+	}
+	if err := os.WriteFile(schema, []byte("SELECT 1;"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCLI(t, env, "-direct", "migrate", "-file", schema); err != nil || out != "schema is up to date\n" {
+		t.Errorf("migrate = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, env, "-direct", "reshard", "-strategy", "hash"); err == nil {
+		t.Error("reshard of a SQLite database succeeded, want an error")
+	}
+
+	if _, err := runCLI(t, map[string]string{"STORAGE_BACKEND": "memory"}, "-direct", "stats"); err == nil {
+		t.Error("-direct with the memory backend succeeded, want an error")
+	}
+}
+
+func TestRun_ExportImport(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.db")
+	repo, err := shortener.NewSQLiteRepository(src)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() unexpected error = %v", err)
+	}
+	service := shortener.NewService(repo)
+	for _, alias := range []string{"", "", "promo"} {
+		if _, err := service.ShortenWithOptions(context.Background(), "https://example.com/"+alias, shortener.ShortenOptions{CustomAlias: alias}); err != nil {
+			t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+		}
+	}
+	repo.Close()
+
+	export := filepath.Join(dir, "links.csv")
+	if _, err := runCLI(t, map[string]string{"STORAGE_BACKEND": "sqlite", "SQLITE_PATH": src}, "-direct", "export", "-format", "csv", "-o", export); err != nil {
+		t.Fatalf("export unexpected error = %v", err)
+	}
+	dst := map[string]string{"STORAGE_BACKEND": "sqlite", "SQLITE_PATH": filepath.Join(dir, "dst.db")}
+	if out, err := runCLI(t, dst, "-direct", "import", "-format", "csv", export); err != nil || out != "imported 3 links, skipped 0 taken codes\n" {
+		t.Fatalf("import = %q, %v", out, err)
+	}
+	if out, err := runCLI(t, dst, "-direct", "lookup", "promo"); err != nil || !strings.Contains(out, "https://example.com/promo") {
+		t.Errorf("lookup after import = %q, %v", out, err)
+	}
+	if _, err := runCLI(t, dst, "-direct", "import", "-format", "xml", export); err == nil {
+		t.Error("import of an unknown format succeeded, want an error")
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"lookup"},
+		{"lookup", "a", "b"},
+		{"stats", "extra"},
+		{"reshard"},
+	} {
+		if _, err := runCLI(t, nil, args...); !errors.Is(err, errUsage) {
+			t.Errorf("run(%q) error = %v, want errUsage", args, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// Package docs embeds the OpenAPI specification of the HTTP API, so that the
+// server can serve it without the docs directory being deployed alongside.
+package docs
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 document describing the API, in YAML.
+//
+//go:embed swagger.yaml
+var OpenAPI []byte
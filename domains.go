@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type DomainRequest struct {
+	Host string `json:"host"`
+}
+
+// DomainResponse describes a custom domain together with the DNS record
+// that verifies it.
+type DomainResponse struct {
+	*shortener.Domain
+	Verified     bool               `json:"verified"`
+	Verification VerificationRecord `json:"verification"`
+}
+
+// VerificationRecord is the TXT record to publish for a custom domain.
+type VerificationRecord struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ListDomainsResponse struct {
+	Domains []DomainResponse `json:"domains"`
+}
+
+func domainResponse(d *shortener.Domain) DomainResponse {
+	name, value := d.VerificationRecord()
+	return DomainResponse{
+		Domain:       d,
+		Verified:     d.Verified(),
+		Verification: VerificationRecord{Type: "TXT", Name: name, Value: value},
+	}
+}
+
+// AddDomainHandler registers a custom domain of the caller, to be verified
+// with VerifyDomainHandler.
+func (a *App) AddDomainHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	var req DomainRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	d, err := a.Service.AddDomain(ctx, user, req.Host)
+	if err != nil {
+		writeDomainError(w, r, "add domain", 0, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, domainResponse(d))
+}
+
+// ListDomainsHandler lists the caller's custom domains, oldest first.
+func (a *App) ListDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	domains, err := a.Service.Domains(ctx, user)
+	if err != nil {
+		writeDomainError(w, r, "list domains", 0, err)
+		return
+	}
+	resp := ListDomainsResponse{Domains: make([]DomainResponse, 0, len(domains))}
+	for _, d := range domains {
+		resp.Domains = append(resp.Domains, domainResponse(d))
+	}
+	writeJSON(w, r, resp)
+}
+
+// GetDomainHandler returns a custom domain of the caller.
+func (a *App) GetDomainHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseDomainID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	d, err := a.Service.Domain(ctx, user, id)
+	if err != nil {
+		writeDomainError(w, r, "get domain", id, err)
+		return
+	}
+	writeJSON(w, r, domainResponse(d))
+}
+
+// VerifyDomainHandler checks the verification record of a custom domain of
+// the caller. Links can be bound to the domain once it is verified.
+func (a *App) VerifyDomainHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseDomainID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	d, err := a.Service.VerifyDomain(ctx, user, id)
+	if err != nil {
+		writeDomainError(w, r, "verify domain", id, err)
+		return
+	}
+	writeJSON(w, r, domainResponse(d))
+}
+
+// DeleteDomainHandler removes a custom domain of the caller. Links bound to
+// it are served on any host again.
+func (a *App) DeleteDomainHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseDomainID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.DeleteDomain(ctx, user, id); err != nil {
+		writeDomainError(w, r, "delete domain", id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseDomainID reads the domainID path variable, writing a 400 response if
+// it is not a number.
+func parseDomainID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["domainID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid domain ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeDomainError(w http.ResponseWriter, r *http.Request, op string, id uint64, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+		slog.WarnContext(r.Context(), op+" timeout", "domain_id", id, "error", err)
+	case errors.Is(err, shortener.ErrInvalidDomain):
+		writeServiceError(w, http.StatusBadRequest, err)
+	case errors.Is(err, shortener.ErrDomainExists), errors.Is(err, shortener.ErrDomainTaken):
+		writeServiceError(w, http.StatusConflict, err)
+	case errors.Is(err, shortener.ErrDomainUnverified):
+		writeServiceError(w, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, shortener.ErrNotFound):
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Domain not found")
+	default:
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(r.Context(), op+" failed", "domain_id", id, "error", err)
+	}
+}
+
+// shortURL returns the public URL of shortCode: on domain, with the scheme
+// of the base URL, for links bound to a custom domain and under the base URL
+// otherwise.
+func (a *App) shortURL(domain, shortCode string) string {
+	if domain == "" {
+		return fmt.Sprintf("%s/%s", a.BaseURL, shortCode)
+	}
+	scheme := "https"
+	if u, err := url.Parse(a.BaseURL); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, domain, shortCode)
+}
+
+// requestHost returns the lowercase hostname r was sent to, without port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
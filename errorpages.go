@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// Error pages shown to browsers by kind. An ERROR_PAGES_DIR may replace each
+// with <kind>.html; kinds it lacks use its error.html, then the built-in page.
+const (
+	pageNotFound = "not_found"
+	pageExpired  = "expired"
+	pageDisabled = "disabled"
+	pageRegion   = "region"
+	pageError    = "error"
+)
+
+// pageTitles are the headings of the built-in pages.
+var pageTitles = map[string]string{
+	pageNotFound: "Link not found",
+	pageExpired:  "Link expired",
+	pageDisabled: "Link unavailable",
+	pageRegion:   regionTexts[defaultLanguage].Title,
+	pageError:    "Something went wrong",
+}
+
+// defaultLanguage is the language of the built-in pages.
+const defaultLanguage = "en"
+
+// pageText is the wording of a page in one language.
+type pageText struct {
+	Title, Message, Home string
+}
+
+// regionTexts are the region page, shown to visitors in countries a link is
+// not available in, in the languages it is translated to, by ISO 639-1
+// code. Visitors get the one their Accept-Language prefers.
+var regionTexts = map[string]pageText{
+	"en": {Title: "Not available in your region", Message: "This link is not available in your country or region", Home: "Go to the home page"},
+	"de": {Title: "In Ihrer Region nicht verfügbar", Message: "Dieser Link ist in Ihrem Land oder Ihrer Region nicht verfügbar", Home: "Zur Startseite"},
+	"es": {Title: "No disponible en tu región", Message: "Este enlace no está disponible en tu país o región", Home: "Ir a la página de inicio"},
+	"fr": {Title: "Non disponible dans votre région", Message: "Ce lien n'est pas disponible dans votre pays ou votre région", Home: "Aller à la page d'accueil"},
+	"it": {Title: "Non disponibile nella tua area geografica", Message: "Questo link non è disponibile nel tuo paese o nella tua area geografica", Home: "Vai alla home page"},
+	"nl": {Title: "Niet beschikbaar in jouw regio", Message: "Deze link is niet beschikbaar in jouw land of regio", Home: "Naar de startpagina"},
+	"pt": {Title: "Indisponível na sua região", Message: "Este link não está disponível no seu país ou região", Home: "Ir para a página inicial"},
+}
+
+// preferredLanguage returns the language of texts that the Accept-Language
+// header prefers, or defaultLanguage if it lists none of them. Regional
+// variants, e.g. de-AT, count as their language.
+func preferredLanguage(header string, texts map[string]pageText) string {
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := texts[lang]; ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.status { color: #888; font-size: .9rem; }
+.button { display: inline-block; margin-top: 1.5rem; padding: .6rem 1.2rem; background: #2456d3; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<p class="status">{{.Status}} {{.StatusText}}</p>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}.</p>
+{{if .HomeURL}}<a class="button" href="{{.HomeURL}}">{{.Home}}</a>{{end}}
+</body>
+</html>
+`))
+
+// errorPageData is what error page templates are executed with.
+type errorPageData struct {
+	Status     int
+	StatusText string
+	// Title is the heading of the page kind, e.g. "Link expired".
+	Title string
+	// Message explains the failure, e.g. "URL has expired".
+	Message   string
+	ShortCode string
+	HomeURL   string
+	// Lang is the language of Title, Message and Home, e.g. "en".
+	Lang string
+	// Home is the text of the link to HomeURL.
+	Home string
+}
+
+// ErrorPages renders the HTML pages shown to browsers when a short link
+// cannot be followed. Other clients get the JSON errors of the API.
+type ErrorPages struct {
+	templates map[string]*template.Template
+	homeURL   string
+}
+
+// LoadErrorPages reads the templates in dir over the built-in pages. An
+// empty dir uses the built-in pages only. homeURL is linked from the pages.
+func LoadErrorPages(dir, homeURL string) (*ErrorPages, error) {
+	p := &ErrorPages{templates: make(map[string]*template.Template), homeURL: homeURL}
+	if dir == "" {
+		return p, nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to load error pages: %w", err)
+	}
+	for kind := range pageTitles {
+		t, err := template.ParseFiles(filepath.Join(dir, kind+".html"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load error page: %w", err)
+		}
+		p.templates[kind] = t
+	}
+	return p, nil
+}
+
+// template returns the template for kind.
+func (p *ErrorPages) template(kind string) *template.Template {
+	if t, ok := p.templates[kind]; ok {
+		return t
+	}
+	if t, ok := p.templates[pageError]; ok {
+		return t
+	}
+	return defaultErrorTemplate
+}
+
+// Write responds with status and message, as the page of kind if the
+// client accepts HTML and as an API error of code otherwise.
+func (p *ErrorPages) Write(w http.ResponseWriter, r *http.Request, status int, kind, code, message, shortCode string) {
+	if p == nil || !accepts(r, "text/html") {
+		apierror.Write(w, status, code, message)
+		return
+	}
+
+	// Render first so that a broken custom template still yields an error
+	var buf bytes.Buffer
+	data := errorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Title:      pageTitles[kind],
+		Message:    message,
+		ShortCode:  shortCode,
+		HomeURL:    p.homeURL,
+		Lang:       defaultLanguage,
+		Home:       "Go to the home page",
+	}
+	if kind == pageRegion {
+		data.Lang = preferredLanguage(r.Header.Get("Accept-Language"), regionTexts)
+		text := regionTexts[data.Lang]
+		data.Title, data.Message, data.Home = text.Title, text.Message, text.Home
+		w.Header().Set("Content-Language", data.Lang)
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	if err := p.template(kind).Execute(&buf, data); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render error page", "kind", kind, "error", err)
+		apierror.Write(w, status, code, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write error page", "error", err)
+	}
+}
+
+// accepts reports whether the Accept header of r lists mediaType, e.g.
+// text/html for browsers.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, _ := strings.Cut(v, ";")
+		if strings.EqualFold(strings.TrimSpace(t), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLinkError responds to a failed lookup of shortCode by the redirect
+// and preview handlers, op naming the handler in log lines.
+func (a *App) writeLinkError(w http.ResponseWriter, r *http.Request, op, shortCode string, err error) {
+	var (
+		status        int
+		kind          string
+		code, message string
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		status, kind, code, message = http.StatusRequestTimeout, pageError, apierror.Timeout, "Request timeout"
+		slog.WarnContext(r.Context(), op+" timeout", "short_code", shortCode, "error", err)
+	case errors.Is(err, shortener.ErrInvalidShortCode):
+		status, kind, code, message = http.StatusBadRequest, pageNotFound, apierror.InvalidCode, "Invalid short code"
+	case errors.Is(err, shortener.ErrNotFound):
+		status, kind, code, message = http.StatusNotFound, pageNotFound, apierror.NotFound, "URL not found"
+	case errors.Is(err, shortener.ErrNotYetActive):
+		status, kind, code, message = http.StatusNotFound, pageNotFound, apierror.NotYetActive, "URL is not yet available"
+	case errors.Is(err, shortener.ErrExpired):
+		status, kind, code, message = http.StatusGone, pageExpired, apierror.Expired, "URL has expired"
+	case errors.Is(err, shortener.ErrNoLongerActive):
+		status, kind, code, message = http.StatusGone, pageExpired, apierror.NoLongerActive, "URL is no longer available"
+	case errors.Is(err, shortener.ErrClickLimitReached):
+		status, kind, code, message = http.StatusGone, pageExpired, apierror.ClickLimitReached, "URL has reached its click limit"
+	case errors.Is(err, shortener.ErrConsumed):
+		status, kind, code, message = http.StatusGone, pageExpired, apierror.Consumed, "URL has already been used"
+	case errors.Is(err, shortener.ErrBurnAfterReading):
+		status, kind, code, message = http.StatusForbidden, pageDisabled, apierror.BurnAfterReading, "URL can only be opened once and cannot be previewed"
+	case errors.Is(err, shortener.ErrAccessDenied):
+		status, kind, code, message = http.StatusForbidden, pageDisabled, apierror.AccessDenied, "URL is not available to you"
+	case errors.Is(err, shortener.ErrRegionUnavailable):
+		status, kind, code, message = http.StatusUnavailableForLegalReasons, pageRegion, apierror.RegionUnavailable, "URL is not available in your region"
+	case errors.Is(err, shortener.ErrDisabled):
+		status, kind, code, message = http.StatusGone, pageDisabled, apierror.Disabled, "URL has been disabled"
+	case errors.Is(err, shortener.ErrUnsafeURL):
+		status, kind, code, message = http.StatusGone, pageDisabled, apierror.UnsafeURL, "URL has been flagged as unsafe"
+	case errors.Is(err, errDestinationUnavailable):
+		status, kind, code, message = http.StatusBadGateway, pageError, apierror.BadGateway, "Destination is unavailable"
+		slog.WarnContext(r.Context(), op+" failed", "short_code", shortCode, "error", err)
+	case errors.Is(err, shortener.ErrRedirectLoop):
+		status, kind, code, message = http.StatusLoopDetected, pageError, apierror.RedirectLoop, "URL redirects in a loop"
+		slog.WarnContext(r.Context(), op+" loop", "short_code", shortCode, "error", err)
+	default:
+		status, kind, code, message = http.StatusInternalServerError, pageError, apierror.Internal, "Internal server error"
+		slog.ErrorContext(r.Context(), op+" failed", "short_code", shortCode, "error", err)
+	}
+	a.ErrorPages.Write(w, r, status, kind, code, message, shortCode)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/events"
+	"github.com/hszk-dev/url-shortener/internal/ratelimit"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/hszk-dev/url-shortener/internal/websocket"
+)
+
+const (
+	// eventFeedBuffer is the number of events queued for a feed client
+	// before newer ones are dropped.
+	eventFeedBuffer = 64
+	// eventFeedPingInterval keeps idle feeds from being closed by proxies.
+	eventFeedPingInterval = 30 * time.Second
+)
+
+// auditEvents are the system events of the audit actions that are
+// published, by action.
+var auditEvents = map[string]string{
+	shortener.AuditCreate:  events.LinkCreated,
+	shortener.AuditDisable: events.LinkDisabled,
+	shortener.AuditSuspend: events.LinkDisabled,
+	shortener.AuditFlag:    events.LinkDisabled,
+}
+
+// publishAudit returns an audit listener for the service that publishes
+// the entries of auditEvents on bus.
+func publishAudit(bus *events.Bus) func(ctx context.Context, e shortener.AuditEntry) {
+	return func(ctx context.Context, e shortener.AuditEntry) {
+		typ, ok := auditEvents[e.Action]
+		if !ok {
+			return
+		}
+		event := events.Event{Type: typ, Timestamp: e.CreatedAt, ShortCode: e.Code, Actor: e.Actor}
+		// Links are disabled by admins, suspended after reports or flagged
+		// as unsafe
+		if typ == events.LinkDisabled {
+			event.Detail = e.Action
+		}
+		bus.Publish(event)
+	}
+}
+
+// publishingLimiter publishes an event on bus for every request its
+// Limiter refuses. Clients are not named, as their IPs are not kept.
+type publishingLimiter struct {
+	ratelimit.Limiter
+	name string
+	bus  *events.Bus
+}
+
+func (l publishingLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	res, err := l.Limiter.Allow(ctx, key)
+	if err == nil && !res.Allowed {
+		l.bus.Publish(events.Event{Type: events.RateLimited, Detail: l.name})
+	}
+	return res, err
+}
+
+// AdminEventsHandler streams system events over a WebSocket as JSON text
+// messages, one per event, until either side closes it. Events a client is
+// too slow to take are dropped.
+func (a *App) AdminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Bus == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Event feed disabled")
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, websocket.ErrBadHandshake):
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "WebSocket handshake required")
+		case errors.Is(err, http.ErrHijacked):
+			slog.WarnContext(r.Context(), "failed to open event feed", "error", err)
+		default:
+			apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+			slog.ErrorContext(r.Context(), "failed to open event feed", "error", err)
+		}
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.DebugContext(r.Context(), "failed to close event feed", "error", err)
+		}
+	}()
+
+	sub := a.Bus.Subscribe(eventFeedBuffer)
+	defer sub.Close()
+	closed := make(chan error, 1)
+	go func() { closed <- conn.Discard() }()
+	ping := time.NewTicker(eventFeedPingInterval)
+	defer ping.Stop()
+
+	slog.InfoContext(r.Context(), "event feed opened")
+	for {
+		select {
+		case e := <-sub.Events():
+			data, err := json.Marshal(e)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to encode event", "type", e.Type, "error", err)
+				continue
+			}
+			if err := conn.WriteText(data); err != nil {
+				slog.InfoContext(r.Context(), "event feed closed", "error", err)
+				return
+			}
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				slog.InfoContext(r.Context(), "event feed closed", "error", err)
+				return
+			}
+		case err := <-closed:
+			slog.InfoContext(r.Context(), "event feed closed", "error", err, "dropped", sub.Dropped())
+			return
+		}
+	}
+}
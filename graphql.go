@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/graphql"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// graphQLSchema returns the schema of /graphql: the caller's links with
+// their click series and sources, and their campaigns with links and stats,
+// plus mutations to create, update and delete links. Fields are named like
+// the properties of the REST API.
+func (a *App) graphQLSchema() *graphql.Schema {
+	source := &graphql.Object{Name: "SourceCount", Fields: map[string]*graphql.Field{
+		"value":  {Type: graphql.String},
+		"clicks": {Type: graphql.Int},
+	}}
+	bucket := &graphql.Object{Name: "ClickBucket", Fields: map[string]*graphql.Field{
+		"start":           {Type: graphql.Time},
+		"clicks":          {Type: graphql.Int},
+		"unique_visitors": {Type: graphql.Int},
+	}}
+	series := &graphql.Object{Name: "ClickSeries", Fields: map[string]*graphql.Field{
+		"short_code":         {Type: graphql.String},
+		"granularity":        {Type: graphql.String},
+		"from":               {Type: graphql.Time},
+		"to":                 {Type: graphql.Time},
+		"unique_visitors":    {Type: graphql.Int},
+		"buckets":            {Type: &graphql.List{Of: bucket}},
+		"analytics_disabled": {Type: graphql.Boolean},
+	}}
+	breakdown := &graphql.Object{Name: "ClickBreakdown", Fields: map[string]*graphql.Field{
+		"short_code":         {Type: graphql.String},
+		"from":               {Type: graphql.Time},
+		"to":                 {Type: graphql.Time},
+		"referrers":          {Type: &graphql.List{Of: source}},
+		"utm_sources":        {Type: &graphql.List{Of: source}},
+		"utm_mediums":        {Type: &graphql.List{Of: source}},
+		"utm_campaigns":      {Type: &graphql.List{Of: source}},
+		"analytics_disabled": {Type: graphql.Boolean},
+	}}
+
+	link := &graphql.Object{Name: "Link", Fields: map[string]*graphql.Field{
+		"short_code":         {Type: graphql.String},
+		"short_url":          {Type: graphql.String},
+		"original_url":       {Type: graphql.String},
+		"created_at":         {Type: graphql.Time},
+		"expires_at":         {Type: graphql.Time},
+		"max_clicks":         {Type: graphql.Int},
+		"clicks":             {Type: graphql.Int},
+		"status":             {Type: graphql.String},
+		"active_from":        {Type: graphql.Time},
+		"active_until":       {Type: graphql.Time},
+		"campaign_id":        {Type: graphql.Int},
+		"domain":             {Type: graphql.String},
+		"org_id":             {Type: graphql.Int},
+		"analytics_disabled": {Type: graphql.Boolean},
+		"query_passthrough":  {Type: graphql.String},
+		"mask":               {Type: graphql.String},
+		"burn_after_reading": {Type: graphql.Boolean},
+		"consumed_at":        {Type: graphql.Time},
+		// series and sources take the arguments of the timeseries and
+		// sources endpoints.
+		"series": {Type: series, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			granularity, err := args.String("granularity")
+			if err != nil {
+				return nil, err
+			}
+			from, to, err := timeRangeArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			return a.Service.ClickSeries(ctx, user, src.(URLResponse).ShortCode, granularity, from, to)
+		}},
+		"sources": {Type: breakdown, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			from, to, err := timeRangeArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			limit, err := limitArg(args, shortener.MaxSourceLimit)
+			if err != nil {
+				return nil, err
+			}
+			return a.Service.ClickBreakdown(ctx, user, src.(URLResponse).ShortCode, from, to, limit)
+		}},
+	}}
+	linkPage := &graphql.Object{Name: "LinkPage", Fields: map[string]*graphql.Field{
+		"urls": {Type: &graphql.List{Of: link}},
+		// next_cursor is null on the last page.
+		"next_cursor": {Type: graphql.String, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			if next := src.(ListURLsResponse).NextCursor; next != "" {
+				return next, nil
+			}
+			return nil, nil
+		}},
+	}}
+
+	topLink := &graphql.Object{Name: "TopLink", Fields: map[string]*graphql.Field{
+		"short_code": {Type: graphql.String},
+		"clicks":     {Type: graphql.Int},
+	}}
+	campaignStats := &graphql.Object{Name: "CampaignStats", Fields: map[string]*graphql.Field{
+		"from":            {Type: graphql.Time},
+		"to":              {Type: graphql.Time},
+		"links":           {Type: graphql.Int},
+		"clicks":          {Type: graphql.Int},
+		"unique_visitors": {Type: graphql.Int},
+		"top":             {Type: &graphql.List{Of: topLink}},
+	}}
+	campaign := &graphql.Object{Name: "Campaign", Fields: map[string]*graphql.Field{
+		"id":         {Type: graphql.Int},
+		"name":       {Type: graphql.String},
+		"created_at": {Type: graphql.Time},
+		"links": {Type: linkPage, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			cursor, limit, err := pageArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			links, next, err := a.Service.CampaignLinks(ctx, user, src.(*shortener.Campaign).ID, cursor, limit)
+			if err != nil {
+				return nil, err
+			}
+			return a.listURLsResponse(links, next), nil
+		}},
+		// stats takes the period and limit of the campaign stats endpoint.
+		"stats": {Type: campaignStats, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			period := shortener.DefaultStatsPeriod
+			if v, err := args.String("period"); err != nil {
+				return nil, err
+			} else if v != "" {
+				if period, err = parseStatsPeriod(v); err != nil {
+					return nil, apierror.Error{Code: apierror.InvalidPeriod, Message: "Invalid period. Must be a number of days (e.g. 7d) or hours (e.g. 12h)"}
+				}
+			}
+			limit, err := limitArg(args, shortener.MaxTopLimit)
+			if err != nil {
+				return nil, err
+			}
+			return a.Service.CampaignStats(ctx, user, src.(*shortener.Campaign).ID, period, limit)
+		}},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"link": {Type: link, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			shortCode, err := args.String("short_code")
+			if err != nil {
+				return nil, err
+			}
+			l, err := a.Service.OwnedLink(ctx, user, shortCode)
+			if err != nil {
+				return nil, err
+			}
+			return a.urlResponse(l), nil
+		}},
+		"links": {Type: linkPage, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			cursor, limit, err := pageArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			links, next, err := a.Service.ListOwned(ctx, user, cursor, limit)
+			if err != nil {
+				return nil, err
+			}
+			return a.listURLsResponse(links, next), nil
+		}},
+		"campaigns": {Type: &graphql.List{Of: campaign}, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			return a.Service.Campaigns(ctx, user)
+		}},
+		"campaign": {Type: campaign, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			id, err := args.Int("id")
+			if err != nil {
+				return nil, err
+			}
+			return a.Service.Campaign(ctx, user, uint64(id))
+		}},
+	}}
+
+	// Mutations take the bodies of the REST endpoints as input and need
+	// the same roles.
+	mutation := &graphql.Object{Name: "Mutation", Fields: map[string]*graphql.Field{
+		"createLink": {Type: link, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			if !user.May(shortener.RoleEditor) {
+				return nil, shortener.ErrForbidden
+			}
+			var req ShortenRequest
+			if err := args.Decode("input", &req); err != nil {
+				return nil, err
+			}
+			opts, apiErr := req.options()
+			if apiErr != nil {
+				return nil, *apiErr
+			}
+			opts.Owner = user
+			shortCode, err := a.Service.ShortenWithOptions(ctx, req.URL, opts)
+			if err != nil {
+				return nil, err
+			}
+			l, err := a.Service.OwnedLink(ctx, user, shortCode)
+			if err != nil {
+				return nil, err
+			}
+			return a.urlResponse(l), nil
+		}},
+		"updateLink": {Type: link, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			if !user.May(shortener.RoleEditor) {
+				return nil, shortener.ErrForbidden
+			}
+			shortCode, err := args.String("short_code")
+			if err != nil {
+				return nil, err
+			}
+			var req UpdateURLRequest
+			if err := args.Decode("input", &req); err != nil {
+				return nil, err
+			}
+			update, apiErr := req.update()
+			if apiErr != nil {
+				return nil, *apiErr
+			}
+			l, err := a.Service.UpdateLink(ctx, user, shortCode, update)
+			if err != nil {
+				return nil, err
+			}
+			return a.urlResponse(l), nil
+		}},
+		"deleteLink": {Type: graphql.Boolean, Resolve: func(ctx context.Context, src any, args graphql.Args) (any, error) {
+			user, _ := auth.UserFromContext(ctx)
+			if !user.May(shortener.RoleAdmin) {
+				return nil, shortener.ErrForbidden
+			}
+			shortCode, err := args.String("short_code")
+			if err != nil {
+				return nil, err
+			}
+			if err := a.Service.Delete(ctx, user, shortCode); err != nil {
+				return nil, err
+			}
+			return true, nil
+		}},
+	}}
+
+	return &graphql.Schema{Query: query, Mutation: mutation, FormatError: graphQLError}
+}
+
+// GraphQLHandler executes a GraphQL request with the caller's permissions.
+// Like other GraphQL servers it answers 200 unless the body cannot be
+// decoded, reporting errors in the errors of the response, with the code
+// a REST request would have failed with in their extensions.
+func (a *App) GraphQLHandler(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.Request
+		if err := a.decodeJSON(w, r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if req.Query == "" {
+			apierror.WriteError(w, http.StatusBadRequest, apierror.Error{Code: apierror.InvalidRequest, Message: "query is required", Field: "query"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		writeJSON(w, r, schema.Execute(ctx, req))
+	}
+}
+
+// graphQLError returns the code and message of the error of a resolver.
+// Unexpected errors are logged and reported as internal errors, like the
+// REST API does.
+func graphQLError(ctx context.Context, err error) (code, message string) {
+	var apiErr apierror.Error
+	var quotaErr *shortener.QuotaError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(ctx, "graphql timeout", "error", err)
+		return apierror.Timeout, "Request timeout"
+	case errors.As(err, &apiErr):
+		return apiErr.Code, apiErr.Message
+	case errors.As(err, &quotaErr):
+		return quotaErr.Code, "Plan limit reached"
+	case errors.Is(err, shortener.ErrForbidden):
+		return apierror.Forbidden, "Forbidden"
+	case errors.Is(err, shortener.ErrNotFound):
+		return apierror.NotFound, "Not found"
+	case errors.Is(err, graphql.ErrInvalidArgument):
+		return apierror.InvalidRequest, err.Error()
+	}
+	for _, c := range serviceErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code, err.Error()
+		}
+	}
+	slog.ErrorContext(ctx, "graphql resolver failed", "error", err)
+	return apierror.Internal, "Internal server error"
+}
+
+// pageArgs reads the cursor and limit arguments of a list, like parsePage
+// reads the query parameters.
+func pageArgs(args graphql.Args) (cursor uint64, limit int, err error) {
+	v, err := args.String("cursor")
+	if err != nil {
+		return 0, 0, err
+	}
+	if v != "" {
+		if cursor, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, 0, apierror.Error{Code: apierror.InvalidCursor, Message: "Invalid cursor", Field: "cursor"}
+		}
+	}
+	limit, err = limitArg(args, shortener.MaxListLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cursor, limit, nil
+}
+
+// limitArg reads the optional limit argument, which must be between 1 and
+// maxLimit. A missing limit is 0.
+func limitArg(args graphql.Args, maxLimit int) (int, error) {
+	limit, err := args.Int("limit")
+	if err != nil {
+		return 0, err
+	}
+	if args.Has("limit") && (limit <= 0 || limit > int64(maxLimit)) {
+		return 0, apierror.Error{Code: apierror.InvalidLimit, Message: fmt.Sprintf("Invalid limit. Must be between 1 and %d", maxLimit), Field: "limit"}
+	}
+	return int(limit), nil
+}
+
+// timeRangeArgs reads the optional from and to arguments, RFC 3339
+// timestamps.
+func timeRangeArgs(args graphql.Args) (from, to time.Time, err error) {
+	if from, err = args.Time("from"); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to, err = args.Time("to"); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type URLHistoryResponse struct {
+	Changes    []*shortener.LinkChange `json:"changes"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+// URLHistoryHandler lists the destination changes of a link the caller owns
+// or manages through an organization, newest first.
+func (a *App) URLHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	changes, next, err := a.Service.LinkHistory(ctx, user, shortCode, cursor, limit)
+	if err != nil {
+		writeOwnedLinkError(w, r, "url history", shortCode, err)
+		return
+	}
+	resp := URLHistoryResponse{Changes: changes}
+	if next != 0 {
+		resp.NextCursor = strconv.FormatUint(next, 10)
+	}
+	writeJSON(w, r, resp)
+}
+
+// RollbackURLHandler sets the destination of a link back to the one it had
+// before a change listed by URLHistoryHandler. The new destination is
+// screened like any other.
+func (a *App) RollbackURLHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
+	changeID, err := strconv.ParseUint(vars["changeID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid change ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.RollbackLink(ctx, user, shortCode, changeID)
+	if err != nil {
+		if errors.Is(err, shortener.ErrNotFound) {
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "URL or change not found")
+			return
+		}
+		writeUpdateError(w, r, "rollback url", shortCode, err)
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// ImportLineResponse is the outcome of a line of POST /api/import: the link
+// created for it or why none was.
+type ImportLineResponse struct {
+	Line      int             `json:"line"`
+	ShortCode string          `json:"short_code,omitempty"`
+	ShortURL  string          `json:"short_url,omitempty"`
+	Error     *apierror.Error `json:"error,omitempty"`
+}
+
+// ImportSummaryResponse is the last line of POST /api/import.
+type ImportSummaryResponse struct {
+	Import shortener.ImportStatus `json:"import"`
+}
+
+// ImportHandler shortens the URLs of the CSV or NDJSON request body on
+// behalf of the caller. The body is read as it arrives and its links saved
+// a batch at a time, and the response streams back an ImportLineResponse
+// per line as NDJSON, then an ImportSummaryResponse. The Location header,
+// sent before the body is read, points at the progress of the import for
+// other clients.
+func (a *App) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	format := importFormat(w, r)
+	if format == "" {
+		return
+	}
+	dec, err := shortener.NewURLDecoder(r.Body, format)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidFormat, err.Error())
+		return
+	}
+	imp, err := a.Service.NewURLImport(user)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(r.Context(), "import failed to start", "error", err)
+		return
+	}
+
+	// A large import takes as long as it takes: lift the server timeouts
+	rc := http.NewResponseController(w)
+	for _, set := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := set(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.WarnContext(r.Context(), "failed to lift deadline", "error", err)
+		}
+	}
+	// Results are written while the body is still being read
+	if err := rc.EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.WarnContext(r.Context(), "failed to enable full duplex", "error", err)
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Location", "/api/import/"+imp.ID())
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.WarnContext(r.Context(), "failed to flush import headers", "error", err)
+	}
+
+	enc := json.NewEncoder(w)
+	status, err := imp.Run(r.Context(), dec.Decode, func(results []shortener.ImportLineResult) error {
+		for _, res := range results {
+			if err := enc.Encode(a.importLineResponse(res)); err != nil {
+				return fmt.Errorf("failed to write results: %w", err)
+			}
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return fmt.Errorf("failed to write results: %w", err)
+		}
+		return nil
+	})
+	slog.InfoContext(r.Context(), "urls imported", "import_id", status.ID, "lines", status.Lines, "created", status.Created, "failed", status.Failed, "format", format)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "import failed", "import_id", status.ID, "error", err)
+	}
+	// Headers are sent: failures are reported in the summary
+	if err := enc.Encode(ImportSummaryResponse{Import: status}); err != nil {
+		slog.WarnContext(r.Context(), "failed to write import summary", "import_id", status.ID, "error", err)
+	}
+}
+
+// importLineResponse converts res for the response of ImportHandler.
+func (a *App) importLineResponse(res shortener.ImportLineResult) ImportLineResponse {
+	resp := ImportLineResponse{Line: res.Line}
+	var quotaErr *shortener.QuotaError
+	switch {
+	case res.Err == nil:
+		resp.ShortCode = res.Link.ShortCode()
+		resp.ShortURL = a.shortURL(res.Link.Domain, resp.ShortCode)
+	case errors.As(res.Err, &quotaErr):
+		resp.Error = &apierror.Error{Code: quotaErr.Code, Message: "Plan limit reached", Limit: quotaErr.Limit}
+	default:
+		resp.Error = &apierror.Error{Code: errorCode(res.Err), Message: res.Err.Error()}
+	}
+	return resp
+}
+
+// ImportStatusHandler returns the progress of an import of the caller,
+// which is only known to the instance running it.
+func (a *App) ImportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	status, err := a.Service.URLImportStatus(user, mux.Vars(r)["importID"])
+	if err != nil {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Import not found")
+		return
+	}
+	writeJSON(w, r, status)
+}
+
+// importFormat returns the format query parameter of an import, else the
+// format of its Content-Type, else NDJSON, or writes an error and returns
+// "" if the parameter is invalid.
+func importFormat(w http.ResponseWriter, r *http.Request) string {
+	if r.URL.Query().Get("format") == "" {
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "text/csv" {
+			return shortener.ExportCSV
+		}
+	}
+	return exportFormat(w, r)
+}
@@ -5,3 +5,273 @@ CREATE TABLE IF NOT EXISTS urls (
 );
 
 CREATE INDEX IF NOT EXISTS idx_urls_original_url ON urls(original_url);
+
+-- Custom aliases that are not canonical Base62 strings live in the code column.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS code TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_code ON urls(code);
+
+-- Optional link expiry; expired rows are purged by the background reaper.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Optional click limit. click_count is maintained in Redis and flushed here periodically.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks BIGINT;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS click_count BIGINT NOT NULL DEFAULT 0;
+
+-- Administrative state: 'active', 'disabled' (soft ban, see /api/admin),
+-- 'flagged' (Safe Browsing re-scan) or 'reported' (abuse reports).
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+
+-- End-user abuse reports. Each client counts once per link until the reports
+-- are resolved by an administrator; links with too many open reports are
+-- suspended with status 'reported'.
+CREATE TABLE IF NOT EXISTS abuse_reports (
+    id BIGSERIAL PRIMARY KEY,
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    reporter TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_abuse_reports_open ON abuse_reports(url_id, reporter) WHERE resolved_at IS NULL;
+
+-- User accounts. Passwords are bcrypt hashes; API keys are stored as SHA-256
+-- hashes and sent as bearer tokens.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Subjects of an external identity provider (OIDC) linked to users, so that
+-- tokens of the provider authenticate as the user.
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
+
+-- Owner of the link; NULL for anonymous links, which nobody can edit or delete.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner_id BIGINT REFERENCES users(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_owner ON urls(owner_id, id) WHERE owner_id IS NOT NULL;
+
+-- Quota tier of the user (see PLAN_LIMITS), and whether a link was created
+-- with a custom alias, which counts against the owner's alias allowance.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT 'free';
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS custom_alias BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- What the user may do: viewer, editor or admin.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'editor';
+
+-- What an API key may be used for (comma-separated shorten, read and delete),
+-- until when, and when it was last used, to the minute.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT 'shorten,read,delete';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id, id);
+
+-- Alternative destinations by visitor country (ISO 3166-1 alpha-2), chosen
+-- at redirect time from a GeoIP lookup of the client.
+CREATE TABLE IF NOT EXISTS link_targets (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    country CHAR(2) NOT NULL,
+    url TEXT NOT NULL,
+    PRIMARY KEY (url_id, country)
+);
+
+-- Routing rules that send visitors elsewhere by device (see shortener.Rule).
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules JSONB;
+
+-- A/B experiment splitting visitors between weighted destinations, and how
+-- often each variant has been served.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS experiment JSONB;
+CREATE TABLE IF NOT EXISTS variant_clicks (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    variant TEXT NOT NULL,
+    served BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, variant)
+);
+
+-- Optional window in which a link redirects (embargoes, time-boxed campaigns).
+-- Unlike expires_at, links past active_until are not purged.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS active_from TIMESTAMP WITH TIME ZONE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS active_until TIMESTAMP WITH TIME ZONE;
+
+-- Soft deletion: deleted links are hidden from lookups, lists and stats but
+-- keep their code until restored (or purged once expired).
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Append-only audit log of changes to links. There is no foreign key so
+-- entries outlive purged links.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    code TEXT NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_code ON audit_log (code, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at);
+CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
+
+-- Clicks per link and UTC hour/day for time series. The click rollup task
+-- adds the clicks buffered in Redis, so dashboards never count raw clicks.
+CREATE TABLE IF NOT EXISTS clicks_hourly (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, bucket)
+);
+CREATE TABLE IF NOT EXISTS clicks_daily (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, bucket)
+);
+
+-- Clicks per link, UTC day and traffic source: dimension is referrer,
+-- utm_source, utm_medium or utm_campaign. Filled by the click rollup task.
+CREATE TABLE IF NOT EXISTS click_sources (
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    dimension TEXT NOT NULL,
+    value TEXT NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, bucket, dimension, value)
+);
+
+-- Link creations per period for /api/stats/top.
+CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls (created_at);
+
+-- Title, description and favicon of the destination page (see
+-- shortener.PageInfo), fetched in the background after creation.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS page JSONB;
+
+-- Campaigns group links of one owner so that their analytics roll up.
+-- Deleting a campaign keeps its links.
+CREATE TABLE IF NOT EXISTS campaigns (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (owner_id, name)
+);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS campaign_id BIGINT REFERENCES campaigns(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_campaign ON urls(campaign_id, id) WHERE campaign_id IS NOT NULL;
+
+-- Custom domains users serve their links on. Any number of users may add a
+-- host, but only one can verify it.
+CREATE TABLE IF NOT EXISTS domains (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    host TEXT NOT NULL,
+    token TEXT NOT NULL,
+    verified_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (owner_id, host)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_verified_host ON domains(host) WHERE verified_at IS NOT NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS domain TEXT;
+
+-- Domains destinations may not, or alone may, point at, besides those
+-- configured with DOMAIN_BLOCKLIST and DOMAIN_ALLOWLIST. Patterns are
+-- hostnames or *.hostname for their subdomains.
+CREATE TABLE IF NOT EXISTS domain_rules (
+    id BIGSERIAL PRIMARY KEY,
+    pattern TEXT NOT NULL UNIQUE,
+    action TEXT NOT NULL CHECK (action IN ('block', 'allow')),
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Links whose owners opted out of click tracking
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS analytics_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Organizations own links on behalf of all their members, so that a link
+-- stays manageable after its creator leaves. Invitations are stored as
+-- SHA-256 hashes of their token, like API keys.
+CREATE TABLE IF NOT EXISTS orgs (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id BIGINT NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_org_members_user ON org_members(user_id);
+CREATE TABLE IF NOT EXISTS org_invitations (
+    token_hash TEXT PRIMARY KEY,
+    org_id BIGINT NOT NULL REFERENCES orgs(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    inviter_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES orgs(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_org ON urls(org_id, id) WHERE org_id IS NOT NULL;
+
+-- Latest health check of the destination of each link
+CREATE TABLE IF NOT EXISTS link_health (
+    url_id BIGINT PRIMARY KEY REFERENCES urls(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    checked_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Custom aliases kept from everybody, or for the links of one organization.
+-- A reserved word is the lowercased alias it keeps; a reserved prefix keeps
+-- every alias starting with it. Checked when a custom alias is saved.
+CREATE TABLE IF NOT EXISTS alias_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    pattern TEXT NOT NULL,
+    prefix BOOLEAN NOT NULL DEFAULT FALSE,
+    org_id BIGINT REFERENCES orgs(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pattern, prefix)
+);
+
+-- Destination changes of each link, for its history and rollbacks
+CREATE TABLE IF NOT EXISTS link_history (
+    id BIGSERIAL PRIMARY KEY,
+    url_id BIGINT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    actor TEXT NOT NULL,
+    old_url TEXT NOT NULL,
+    new_url TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_link_history_url ON link_history(url_id, id);
+
+-- Notes of the owner about each link (see shortener.Metadata): a title, a
+-- description and free-form JSON attributes
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+-- How the query string of redirect requests is passed on to the
+-- destination (see shortener.QueryPassthrough): merge or override
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS query_passthrough TEXT;
+
+-- How the destination is hidden from visitors instead of redirecting them
+-- (see shortener.Mask): frame or proxy
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS mask TEXT;
+
+-- Links that redirect once (see shortener.Link.BurnAfterReading), and when
+-- they were followed
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS burn_after_reading BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS consumed_at TIMESTAMP WITH TIME ZONE;
+
+-- Which visitors links redirect (see shortener.Access), as JSON
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS access JSONB;
@@ -0,0 +1,130 @@
+// Package accesslog logs one structured line per HTTP request with its
+// method, path, status, response size, latency and client IP.
+//
+// Redirects can make up nearly all of the traffic, so successful requests
+// may be sampled; failed ones (status 400 and above) are always logged.
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Options configure Middleware.
+type Options struct {
+	// SampleRate is the fraction of successful requests that are logged,
+	// from 0 (none) to 1 (all).
+	SampleRate float64
+	// RedactCodes logs the route pattern instead of the path of requests
+	// matched by a route with variables, e.g. /{shortCode} instead of the
+	// short code, which may be a secret link.
+	RedactCodes bool
+	// ClientIP extracts the client address of a request.
+	ClientIP func(*http.Request) string
+	// Logger receives the lines; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+type contextKey struct{}
+
+// Middleware logs the requests passed to next. Set RouteMiddleware on the
+// router for RedactCodes to know the routes.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var route string
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), contextKey{}, &route)))
+
+			if rw.status < 400 && (opts.SampleRate <= 0 || (opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate)) {
+				return
+			}
+			path := r.URL.Path
+			if opts.RedactCodes && route != "" {
+				path = route
+			}
+			level := slog.LevelInfo
+			if rw.status >= 500 {
+				level = slog.LevelWarn
+			}
+			logger := opts.Logger
+			if logger == nil {
+				logger = slog.Default()
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", path),
+				slog.Int("status", rw.status),
+				slog.Int64("bytes", rw.bytes),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			}
+			if opts.ClientIP != nil {
+				attrs = append(attrs, slog.String("client_ip", opts.ClientIP(r)))
+			}
+			logger.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// RouteMiddleware records the pattern of the route matched by a mux.Router
+// for Middleware. Install it with Router.Use.
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(contextKey{}).(*string); ok && len(mux.Vars(r)) > 0 {
+			if pattern, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				*route = pattern
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseWriter records the status and the number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes on flushes of streamed responses.
+func (w *responseWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket and similar handlers take over the connection.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(RouteMiddleware)
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	})
+	r.HandleFunc("/{shortCode}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["shortCode"] == "missing" {
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, "https://example.com", http.StatusFound)
+	})
+
+	tests := []struct {
+		name     string
+		opts     Options
+		path     string
+		want     bool
+		wantPath string
+		status   int
+	}{
+		{name: "logs everything", opts: Options{SampleRate: 1}, path: "/abc", want: true, wantPath: "/abc", status: http.StatusFound},
+		{name: "redacts codes", opts: Options{SampleRate: 1, RedactCodes: true}, path: "/abc", want: true, wantPath: "/{shortCode}", status: http.StatusFound},
+		{name: "keeps fixed paths", opts: Options{SampleRate: 1, RedactCodes: true}, path: "/health", want: true, wantPath: "/health", status: http.StatusOK},
+		{name: "samples successes", opts: Options{SampleRate: 0}, path: "/abc"},
+		{name: "always logs failures", opts: Options{SampleRate: 0, RedactCodes: true}, path: "/missing", want: true, wantPath: "/{shortCode}", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.opts.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+			tt.opts.ClientIP = func(r *http.Request) string { return "192.0.2.1" }
+			Middleware(tt.opts)(r).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+			if !tt.want {
+				if buf.Len() != 0 {
+					t.Errorf("logged %s, want nothing", buf.String())
+				}
+				return
+			}
+			var line struct {
+				Msg       string
+				Method    string
+				Path      string
+				Status    int
+				Bytes     int64
+				LatencyMS *float64 `json:"latency_ms"`
+				ClientIP  string   `json:"client_ip"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q: %v", buf.String(), err)
+			}
+			if line.Msg != "request" || line.Method != "GET" || line.Path != tt.wantPath || line.Status != tt.status || line.Bytes == 0 || line.LatencyMS == nil || line.ClientIP != "192.0.2.1" {
+				t.Errorf("log line = %s, want GET %s %d", buf.String(), tt.wantPath, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package apierror writes the JSON envelope every API failure is reported
+// with:
+//
+//	{"error": {"code": "invalid_url", "message": "Invalid URL format. Must be http:// or https://"}}
+//
+// Codes are stable and meant for programs to branch on; messages are meant
+// for people and may change.
+package apierror
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Codes shared by many routes.
+const (
+	// InvalidRequest is a malformed parameter or field without a more
+	// specific code.
+	InvalidRequest = "invalid_request"
+	// InvalidBody is a request body that is not the JSON expected.
+	InvalidBody = "invalid_body"
+	// UnknownField is a request body field the route does not take.
+	UnknownField = "unknown_field"
+	// BodyTooLarge is a request body over the configured limit.
+	BodyTooLarge = "body_too_large"
+	// Unauthorized is a missing or invalid credential.
+	Unauthorized = "unauthorized"
+	// Forbidden is a credential that does not allow the request.
+	Forbidden = "forbidden"
+	// NotFound is an unknown resource, or one of another user.
+	NotFound = "not_found"
+	// Timeout is a request that took too long to serve.
+	Timeout = "timeout"
+	// BadGateway is a destination that could not be fetched to be served
+	// in place of a redirect.
+	BadGateway = "bad_gateway"
+	// RateLimited is a client over its rate limit.
+	RateLimited = "rate_limited"
+	// Internal is a failure of the server.
+	Internal = "internal_error"
+)
+
+// Codes of links and their destinations.
+const (
+	URLRequired         = "url_required"
+	InvalidURL          = "invalid_url"
+	InvalidHost         = "invalid_host"
+	URLTooLong          = "url_too_long"
+	UnsafeURL           = "unsafe_url"
+	HomographDomain     = "homograph_domain"
+	DomainBlocked       = "domain_blocked"
+	RecursiveURL        = "recursive_url"
+	InvalidCode         = "invalid_code"
+	InvalidAlias        = "invalid_alias"
+	AliasTaken          = "alias_taken"
+	AliasReserved       = "alias_reserved"
+	InvalidAlphabet     = "invalid_alphabet"
+	InvalidExpiry       = "invalid_expiry"
+	InvalidActiveWindow = "invalid_active_window"
+	InvalidMaxClicks    = "invalid_max_clicks"
+	InvalidTarget       = "invalid_target"
+	InvalidRule         = "invalid_rule"
+	InvalidExperiment   = "invalid_experiment"
+	InvalidMetadata     = "invalid_metadata"
+	InvalidAccess       = "invalid_access"
+	InvalidPassthrough  = "invalid_query_passthrough"
+	InvalidMask         = "invalid_mask"
+	InvalidStatus       = "invalid_status"
+	NothingToUpdate     = "nothing_to_update"
+	NotYetActive        = "not_yet_active"
+	Expired             = "expired"
+	NoLongerActive      = "no_longer_active"
+	ClickLimitReached   = "click_limit_reached"
+	Consumed            = "consumed"
+	BurnAfterReading    = "burn_after_reading"
+	AccessDenied        = "access_denied"
+	RegionUnavailable   = "region_unavailable"
+	Disabled            = "disabled"
+	RedirectLoop        = "redirect_loop"
+	ReasonTooLong       = "reason_too_long"
+)
+
+// Codes of stats and listings.
+const (
+	InvalidPeriod = "invalid_period"
+	InvalidSeries = "invalid_series"
+	InvalidCursor = "invalid_cursor"
+	InvalidLimit  = "invalid_limit"
+	InvalidFormat = "invalid_format"
+	InvalidImport = "invalid_import"
+)
+
+// Codes of accounts, keys, organizations, campaigns, domains and alias
+// reservations.
+const (
+	InvalidEmail       = "invalid_email"
+	WeakPassword       = "weak_password"
+	EmailTaken         = "email_taken"
+	InvalidCredentials = "invalid_credentials"
+	UnknownPlan        = "unknown_plan"
+	UnknownRole        = "unknown_role"
+	InvalidScopes      = "invalid_scopes"
+	MissingScope       = "missing_scope"
+	SigningDisabled    = "signing_disabled"
+	InvalidOrg         = "invalid_org"
+	InvalidInvitation  = "invalid_invitation"
+	AlreadyMember      = "already_member"
+	LastMember         = "last_member"
+	InvalidCampaign    = "invalid_campaign"
+	CampaignExists     = "campaign_exists"
+	InvalidDomain      = "invalid_domain"
+	DomainExists       = "domain_exists"
+	DomainTaken        = "domain_taken"
+	DomainUnverified   = "domain_unverified"
+	InvalidDomainRule  = "invalid_domain_rule"
+	DomainRuleExists   = "domain_rule_exists"
+	InvalidReservation = "invalid_reservation"
+	ReservationExists  = "reservation_exists"
+)
+
+// Codes of requests with an Idempotency-Key.
+const (
+	IdempotencyKeyReused     = "idempotency_key_reused"
+	IdempotencyKeyInProgress = "idempotency_key_in_progress"
+)
+
+// Error is the body of a failed API request.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Field names the request field at fault, if one is.
+	Field string `json:"field,omitempty"`
+	// Limit is the plan limit reached, for the codes of
+	// shortener.QuotaError.
+	Limit int64 `json:"limit,omitempty"`
+}
+
+// Error returns the message of e, so that e can be returned as an error
+// where it is not written as a response.
+func (e Error) Error() string {
+	return e.Message
+}
+
+// Response is the envelope errors are written in.
+type Response struct {
+	Error Error `json:"error"`
+}
+
+// Write responds with status and an error of code and message.
+func Write(w http.ResponseWriter, status int, code, message string) {
+	WriteError(w, status, Error{Code: code, Message: message})
+}
+
+// WriteError responds with status and e. Like http.Error, it should be the
+// only write to w.
+func WriteError(w http.ResponseWriter, status int, e Error) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(Response{Error: e}); err != nil {
+		slog.Warn("failed to write error response", "code", e.Code, "error", err)
+	}
+}
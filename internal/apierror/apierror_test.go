@@ -0,0 +1,61 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		err      Error
+		wantBody string
+	}{
+		{
+			name:     "code and message",
+			status:   http.StatusBadRequest,
+			err:      Error{Code: InvalidURL, Message: "Invalid URL format"},
+			wantBody: `{"error":{"code":"invalid_url","message":"Invalid URL format"}}` + "\n",
+		},
+		{
+			name:     "field",
+			status:   http.StatusBadRequest,
+			err:      Error{Code: InvalidRequest, Message: "Unknown field", Field: "urll"},
+			wantBody: `{"error":{"code":"invalid_request","message":"Unknown field","field":"urll"}}` + "\n",
+		},
+		{
+			name:     "limit",
+			status:   http.StatusForbidden,
+			err:      Error{Code: "total_link_limit", Message: "Plan limit reached", Limit: 50},
+			wantBody: `{"error":{"code":"total_link_limit","message":"Plan limit reached","limit":50}}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Length", "3")
+			WriteError(w, tt.status, tt.err)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if cl := w.Header().Get("Content-Length"); cl != "" {
+				t.Errorf("Content-Length = %q, want it removed", cl)
+			}
+			var resp Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error != tt.err {
+				t.Errorf("decoded = %+v, %v, want %+v", resp.Error, err, tt.err)
+			}
+		})
+	}
+}
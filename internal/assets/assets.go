@@ -0,0 +1,16 @@
+// Package assets embeds the files served at fixed paths of the site root,
+// which browsers and crawlers request from every host they visit.
+package assets
+
+import _ "embed"
+
+// Favicon is the default /favicon.ico.
+//
+//go:embed favicon.ico
+var Favicon []byte
+
+// Robots is the default /robots.txt. It disallows crawling altogether, as
+// short codes only redirect to pages indexed under their own URLs.
+//
+//go:embed robots.txt
+var Robots []byte
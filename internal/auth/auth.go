@@ -0,0 +1,147 @@
+// Package auth identifies the user behind an API request from the API key
+// or OIDC token sent as a bearer token and makes it available to handlers.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/oidc"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// Authenticator resolves an API key to its user. It is implemented by
+// *shortener.Service.
+type Authenticator interface {
+	Authenticate(ctx context.Context, apiKey string) (*shortener.User, error)
+}
+
+// IdentityAuthenticator resolves an identity vouched for by an identity
+// provider to its user. It is implemented by *shortener.Service.
+type IdentityAuthenticator interface {
+	AuthenticateIdentity(ctx context.Context, id shortener.Identity) (*shortener.User, error)
+}
+
+// TokenVerifier verifies a token of an identity provider. It is implemented
+// by *oidc.Verifier.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*oidc.Claims, error)
+}
+
+// WithOIDC returns an Authenticator that accepts tokens verified by v, as
+// resolved by ids, besides the API keys accepted by a.
+func WithOIDC(a Authenticator, ids IdentityAuthenticator, v TokenVerifier) Authenticator {
+	return &oidcAuthenticator{keys: a, ids: ids, verifier: v}
+}
+
+type oidcAuthenticator struct {
+	keys     Authenticator
+	ids      IdentityAuthenticator
+	verifier TokenVerifier
+}
+
+func (a *oidcAuthenticator) Authenticate(ctx context.Context, token string) (*shortener.User, error) {
+	if !oidc.IsJWT(token) {
+		return a.keys.Authenticate(ctx, token)
+	}
+	claims, err := a.verifier.Verify(ctx, token)
+	if errors.Is(err, oidc.ErrInvalidToken) {
+		return nil, fmt.Errorf("%w: %w", shortener.ErrInvalidCredentials, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.ids.AuthenticateIdentity(ctx, shortener.Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	})
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying user.
+func NewContext(ctx context.Context, user *shortener.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the authenticated user stored in ctx, if any.
+func UserFromContext(ctx context.Context) (*shortener.User, bool) {
+	user, ok := ctx.Value(contextKey{}).(*shortener.User)
+	return user, ok
+}
+
+// BearerToken returns the token of a "Bearer" Authorization header, or "".
+func BearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// Middleware authenticates requests that carry an API key and stores the
+// user in the request context. Requests without a key pass through
+// anonymously; an invalid key is rejected rather than silently ignored.
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := BearerToken(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := a.Authenticate(r.Context(), key)
+			if errors.Is(err, shortener.ErrInvalidCredentials) {
+				unauthorized(w)
+				return
+			}
+			if err != nil {
+				apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+				slog.ErrorContext(r.Context(), "authentication failed", "error", err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), user)))
+		})
+	}
+}
+
+// Require rejects requests that Middleware did not authenticate.
+func Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserFromContext(r.Context()); !ok {
+			unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole is Require for users that may do what role may (see
+// shortener.User.May): they need at least role and, with an API key, the
+// scope of role. Others are forbidden.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				unauthorized(w)
+				return
+			}
+			if !user.May(role) {
+				apierror.Write(w, http.StatusForbidden, apierror.Forbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Unauthorized")
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/oidc"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type stubAuthenticator map[string]*shortener.User
+
+func (s stubAuthenticator) Authenticate(ctx context.Context, apiKey string) (*shortener.User, error) {
+	if apiKey == "sk_broken" {
+		return nil, errors.New("database down")
+	}
+	user, ok := s[apiKey]
+	if !ok {
+		return nil, shortener.ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	users := stubAuthenticator{"sk_alice": {ID: 1, Email: "alice@example.com"}}
+
+	tests := []struct {
+		name          string
+		authorization string
+		require       bool
+		wantStatus    int
+		wantUserID    uint64
+	}{
+		{name: "anonymous", wantStatus: http.StatusOK},
+		{name: "valid key", authorization: "Bearer sk_alice", wantStatus: http.StatusOK, wantUserID: 1},
+		{name: "unknown key", authorization: "Bearer sk_mallory", wantStatus: http.StatusUnauthorized},
+		{name: "authenticator error", authorization: "Bearer sk_broken", wantStatus: http.StatusInternalServerError},
+		{name: "required without key", require: true, wantStatus: http.StatusUnauthorized},
+		{name: "required with key", authorization: "Bearer sk_alice", require: true, wantStatus: http.StatusOK, wantUserID: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID uint64
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, ok := UserFromContext(r.Context()); ok {
+					gotUserID = user.ID
+				}
+			})
+			if tt.require {
+				handler = Require(handler)
+			}
+			handler = Middleware(users)(handler)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if gotUserID != tt.wantUserID {
+				t.Errorf("user ID = %d, want %d", gotUserID, tt.wantUserID)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header on 401")
+			}
+			if w.Code == http.StatusUnauthorized {
+				var resp apierror.Response
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Error.Code != apierror.Unauthorized {
+					t.Errorf("401 body = %+v, %v, want code %s", resp, err, apierror.Unauthorized)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		user       *shortener.User
+		role       string
+		wantStatus int
+	}{
+		{nil, shortener.RoleViewer, http.StatusUnauthorized},
+		{&shortener.User{Role: shortener.RoleViewer}, shortener.RoleViewer, http.StatusOK},
+		{&shortener.User{Role: shortener.RoleViewer}, shortener.RoleEditor, http.StatusForbidden},
+		{&shortener.User{Role: shortener.RoleAdmin}, shortener.RoleEditor, http.StatusOK},
+		{&shortener.User{Role: "owner"}, shortener.RoleViewer, http.StatusForbidden},
+		{&shortener.User{Role: shortener.RoleAdmin, Scopes: []string{shortener.ScopeRead}}, shortener.RoleViewer, http.StatusOK},
+		{&shortener.User{Role: shortener.RoleAdmin, Scopes: []string{shortener.ScopeRead}}, shortener.RoleEditor, http.StatusForbidden},
+		{&shortener.User{Role: shortener.RoleEditor, Scopes: []string{shortener.ScopeDelete}}, shortener.RoleAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		handler := RequireRole(tt.role)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.user != nil {
+			req = req.WithContext(NewContext(req.Context(), tt.user))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("user %+v requiring %q: status = %d, want %d", tt.user, tt.role, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+type stubVerifier map[string]*oidc.Claims
+
+func (s stubVerifier) Verify(ctx context.Context, token string) (*oidc.Claims, error) {
+	if token == "eyJ.down.x" {
+		return nil, errors.New("provider down")
+	}
+	claims, ok := s[token]
+	if !ok {
+		return nil, oidc.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+type stubIdentities map[string]*shortener.User
+
+func (s stubIdentities) AuthenticateIdentity(ctx context.Context, id shortener.Identity) (*shortener.User, error) {
+	user, ok := s[id.Issuer+" "+id.Subject]
+	if !ok {
+		return nil, shortener.ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func TestWithOIDC(t *testing.T) {
+	a := WithOIDC(
+		stubAuthenticator{"sk_alice": {ID: 1}},
+		stubIdentities{"https://idp.example.com bob": {ID: 2}},
+		stubVerifier{
+			"eyJ.bob.x":     {Issuer: "https://idp.example.com", Subject: "bob"},
+			"eyJ.mallory.x": {Issuer: "https://idp.example.com", Subject: "mallory"},
+		},
+	)
+
+	tests := []struct {
+		token      string
+		wantUserID uint64
+		wantErr    error
+	}{
+		{token: "sk_alice", wantUserID: 1},
+		{token: "eyJ.bob.x", wantUserID: 2},
+		{token: "eyJ.forged.x", wantErr: shortener.ErrInvalidCredentials},
+		{token: "eyJ.mallory.x", wantErr: shortener.ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		user, err := a.Authenticate(context.Background(), tt.token)
+		if !errors.Is(err, tt.wantErr) || (err == nil && user.ID != tt.wantUserID) {
+			t.Errorf("Authenticate(%q) = %+v, %v, want user %d, error %v", tt.token, user, err, tt.wantUserID, tt.wantErr)
+		}
+	}
+	// Failing to fetch the keys is not the client's fault
+	if _, err := a.Authenticate(context.Background(), "eyJ.down.x"); err == nil || errors.Is(err, shortener.ErrInvalidCredentials) {
+		t.Errorf("Authenticate() with the provider down error = %v, want a server error", err)
+	}
+}
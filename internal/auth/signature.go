@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/redis/go-redis/v9"
+)
+
+// SignatureScheme is the Authorization scheme of signed requests:
+//
+//	Authorization: HMAC-SHA256 KeyId=<key ID>, Timestamp=<unix seconds>, Nonce=<nonce>, Signature=<hex>
+//
+// where Signature is the HMAC-SHA256, under the signing secret of the key,
+// of the string returned by StringToSign.
+const SignatureScheme = "HMAC-SHA256"
+
+// Nonces must be long enough that callers drawing them at random do not
+// repeat one by accident.
+const (
+	minNonceLength = 16
+	maxNonceLength = 128
+)
+
+var (
+	// ErrInvalidSignature is returned for signed requests that are
+	// malformed, signed with the wrong secret or too far from the server's
+	// clock.
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrReplayed is returned for signed requests whose nonce was already
+	// used.
+	ErrReplayed = errors.New("request nonce already used")
+)
+
+// SigningKeys resolves the key ID of a signed request to its user and
+// signing secret. It is implemented by *shortener.Service.
+type SigningKeys interface {
+	AuthenticateSigningKey(ctx context.Context, keyID string) (*shortener.User, string, error)
+}
+
+// NonceStore remembers the nonces of signed requests.
+type NonceStore interface {
+	// Claim records nonce for ttl, returning false if it is already
+	// recorded.
+	Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// RedisNonces stores nonces in Redis, so that a request replayed to another
+// replica of the service is rejected as well.
+type RedisNonces struct {
+	client *redis.Client
+}
+
+func NewRedisNonces(client *redis.Client) *RedisNonces {
+	return &RedisNonces{client: client}
+}
+
+func (n *RedisNonces) Claim(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	ok, err := n.client.SetNX(ctx, "nonce:"+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record nonce: %w", err)
+	}
+	return ok, nil
+}
+
+// StringToSign returns what the signature of a request covers: its method,
+// path with query, timestamp and nonce, and the SHA-256 of its body.
+func StringToSign(method, requestURI string, timestamp int64, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		method, requestURI, strconv.FormatInt(timestamp, 10), nonce, hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// Sign returns the Signature of a request signed with secret.
+func Sign(secret, method, requestURI string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, requestURI, timestamp, nonce, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureVerifier authenticates signed requests.
+type SignatureVerifier struct {
+	keys    SigningKeys
+	nonces  NonceStore
+	maxSkew time.Duration
+	maxBody int64
+	now     func() time.Time
+}
+
+// NewSignatureVerifier accepts requests signed for keys within maxSkew of
+// the server's clock, with bodies of up to maxBody bytes. Nonces are
+// remembered in nonces for as long as their requests would be accepted.
+func NewSignatureVerifier(keys SigningKeys, nonces NonceStore, maxSkew time.Duration, maxBody int64) *SignatureVerifier {
+	return &SignatureVerifier{keys: keys, nonces: nonces, maxSkew: maxSkew, maxBody: maxBody, now: time.Now}
+}
+
+// signedHeader is a parsed SignatureScheme Authorization header.
+type signedHeader struct {
+	keyID, nonce, signature string
+	timestamp               int64
+}
+
+func parseSignedHeader(value string) (signedHeader, bool) {
+	var h signedHeader
+	for _, param := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return h, false
+		}
+		switch name {
+		case "KeyId":
+			h.keyID = v
+		case "Timestamp":
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return h, false
+			}
+			h.timestamp = ts
+		case "Nonce":
+			h.nonce = v
+		case "Signature":
+			h.signature = v
+		default:
+			return h, false
+		}
+	}
+	ok := h.keyID != "" && h.timestamp != 0 && h.signature != "" &&
+		len(h.nonce) >= minNonceLength && len(h.nonce) <= maxNonceLength
+	return h, ok
+}
+
+// Verify authenticates r, whose Authorization header has SignatureScheme
+// params, and returns its user. The body of r is read and replaced so that
+// handlers can still read it. Returns ErrInvalidSignature or
+// shortener.ErrInvalidCredentials for requests that are not correctly
+// signed by a valid key, ErrReplayed for requests seen before and an
+// *http.MaxBytesError for bodies over the limit.
+func (v *SignatureVerifier) Verify(r *http.Request, params string) (*shortener.User, error) {
+	h, ok := parseSignedHeader(params)
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+	if skew := v.now().Sub(time.Unix(h.timestamp, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+		return nil, fmt.Errorf("%w: timestamp outside the allowed clock skew", ErrInvalidSignature)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, v.maxBody))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	user, secret, err := v.keys.AuthenticateSigningKey(r.Context(), h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	want := Sign(secret, r.Method, r.URL.RequestURI(), h.timestamp, h.nonce, body)
+	if !hmac.Equal([]byte(want), []byte(h.signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	// Only correctly signed requests claim nonces, so that forged ones cannot
+	// use up a caller's. A nonce is remembered until its timestamp leaves the
+	// window.
+	ok, err = v.nonces.Claim(r.Context(), h.keyID+":"+h.nonce, 2*v.maxSkew)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrReplayed
+	}
+	return user, nil
+}
+
+// WithSignatures returns a middleware that authenticates signed requests
+// with v and passes all others to authenticate, e.g. Middleware. Like
+// Middleware, it stores the user in the request context and rejects
+// requests with invalid credentials.
+func WithSignatures(authenticate func(http.Handler) http.Handler, v *SignatureVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		other := authenticate(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params, ok := strings.CutPrefix(r.Header.Get("Authorization"), SignatureScheme+" ")
+			if !ok {
+				other.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := v.Verify(r, params)
+			var maxBytes *http.MaxBytesError
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), user)))
+			case errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrReplayed),
+				errors.Is(err, shortener.ErrInvalidCredentials):
+				w.Header().Set("WWW-Authenticate", SignatureScheme+` realm="api"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Unauthorized")
+			case errors.As(err, &maxBytes):
+				apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.BodyTooLarge, "Request body too large")
+			default:
+				apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+				slog.ErrorContext(r.Context(), "signature verification failed", "error", err)
+			}
+		})
+	}
+}
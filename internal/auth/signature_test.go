@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/redis/go-redis/v9"
+)
+
+type stubSigningKeys map[string]*shortener.User
+
+func (s stubSigningKeys) AuthenticateSigningKey(ctx context.Context, keyID string) (*shortener.User, string, error) {
+	if keyID == "hk_broken" {
+		return nil, "", errors.New("database down")
+	}
+	user, ok := s[keyID]
+	if !ok {
+		return nil, "", shortener.ErrInvalidCredentials
+	}
+	return user, "secret-of-" + keyID, nil
+}
+
+func TestWithSignatures(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	alice := &shortener.User{ID: 1, Email: "alice@example.com"}
+	now := time.Unix(1_700_000_000, 0)
+	v := NewSignatureVerifier(stubSigningKeys{"hk_alice": alice}, NewRedisNonces(client), 5*time.Minute, 64)
+	v.now = func() time.Time { return now }
+
+	var gotBody string
+	handler := WithSignatures(Middleware(stubAuthenticator{"sk_bob": {ID: 2}}), v)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := UserFromContext(r.Context())
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("handler failed to read body: %v", err)
+			}
+			gotBody = string(body)
+			fmt.Fprintf(w, "%d", user.ID)
+		}))
+
+	type request struct {
+		keyID, secret, nonce string
+		ts                   time.Time
+		// signed overrides what is signed, e.g. to tamper with the request
+		signedURI, signedBody string
+	}
+	valid := func() request {
+		return request{
+			keyID: "hk_alice", secret: "secret-of-hk_alice", nonce: "0123456789abcdef", ts: now,
+			signedURI: "/api/shorten?x=1", signedBody: `{"url":"https://example.com"}`,
+		}
+	}
+	tests := []struct {
+		name     string
+		req      func(r *request)
+		header   string
+		wantCode int
+	}{
+		{name: "valid", req: func(r *request) {}, wantCode: http.StatusOK},
+		{name: "replayed nonce", req: func(r *request) {}, wantCode: http.StatusUnauthorized},
+		{name: "another nonce", req: func(r *request) { r.nonce = "fedcba9876543210" }, wantCode: http.StatusOK},
+		{name: "tampered body", req: func(r *request) { r.nonce += "1"; r.signedBody = "{}" }, wantCode: http.StatusUnauthorized},
+		{name: "tampered query", req: func(r *request) { r.nonce += "2"; r.signedURI = "/api/shorten" }, wantCode: http.StatusUnauthorized},
+		{name: "wrong secret", req: func(r *request) { r.nonce += "3"; r.secret = "guess" }, wantCode: http.StatusUnauthorized},
+		{name: "unknown key", req: func(r *request) { r.nonce += "4"; r.keyID = "hk_mallory" }, wantCode: http.StatusUnauthorized},
+		{name: "stale timestamp", req: func(r *request) { r.nonce += "5"; r.ts = now.Add(-6 * time.Minute) }, wantCode: http.StatusUnauthorized},
+		{name: "future timestamp", req: func(r *request) { r.nonce += "6"; r.ts = now.Add(6 * time.Minute) }, wantCode: http.StatusUnauthorized},
+		{name: "short nonce", req: func(r *request) { r.nonce = "abc" }, wantCode: http.StatusUnauthorized},
+		{name: "key error", req: func(r *request) { r.nonce += "7"; r.keyID = "hk_broken" }, wantCode: http.StatusInternalServerError},
+		{name: "malformed header", header: "HMAC-SHA256 KeyId", wantCode: http.StatusUnauthorized},
+		{name: "bearer key", header: "Bearer sk_bob", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"url":"https://example.com"}`
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten?x=1", strings.NewReader(body))
+			if tt.req != nil {
+				r := valid()
+				tt.req(&r)
+				sig := Sign(r.secret, http.MethodPost, r.signedURI, r.ts.Unix(), r.nonce, []byte(r.signedBody))
+				req.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 KeyId=%s, Timestamp=%d, Nonce=%s, Signature=%s",
+					r.keyID, r.ts.Unix(), r.nonce, sig))
+			} else {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode == http.StatusOK && gotBody != body {
+				t.Errorf("handler read body %q, want %q", gotBody, body)
+			}
+			if tt.wantCode == http.StatusUnauthorized && !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "HMAC-SHA256") {
+				t.Errorf("WWW-Authenticate = %q, want the signature scheme", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		body := strings.Repeat("x", 65)
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		sig := Sign("secret-of-hk_alice", http.MethodPost, "/api/shorten", now.Unix(), "large-body-nonce", []byte(body))
+		req.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 KeyId=hk_alice, Timestamp=%d, Nonce=large-body-nonce, Signature=%s", now.Unix(), sig))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want 413", rec.Code)
+		}
+	})
+
+	// Nonces are forgotten once their requests would be too old anyway
+	if ttl := mr.TTL("nonce:hk_alice:0123456789abcdef"); ttl != 10*time.Minute {
+		t.Errorf("nonce TTL = %v, want 10m", ttl)
+	}
+}
@@ -0,0 +1,197 @@
+// Package compress gzips HTTP responses for clients that accept it. Only
+// text formats that compress well are encoded, and only once they reach
+// MinSize, so that redirects and tiny bodies are sent as they are.
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MinSize is the body size from which responses are compressed; below it
+// the gzip header and trailer outweigh the savings.
+const MinSize = 1024
+
+// compressible lists the media types worth compressing.
+var compressible = map[string]bool{
+	"application/json": true,
+	"application/yaml": true,
+	"text/csv":         true,
+	"text/plain":       true,
+}
+
+var writers = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// Middleware gzips responses of compressible media types when the request
+// carries Accept-Encoding: gzip.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &responseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, i.e.
+// lists gzip (or *) with a non-zero q value.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		v, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		q, err := strconv.ParseFloat(v, 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// responseWriter buffers the start of the body until it knows whether to
+// compress: the response must be a compressible media type of at least
+// MinSize bytes (or be flushed first) and not already encoded.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		compress := w.eligible()
+		if compress && len(w.buf)+len(p) < MinSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.start(compress); err != nil {
+			return 0, err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// eligible reports whether the response may be compressed, judging by the
+// headers set so far.
+func (w *responseWriter) eligible() bool {
+	h := w.Header()
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	// Byte ranges refer to the unencoded body
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && compressible[mediaType]
+}
+
+// start sends the headers and the buffered body, compressed or not.
+func (w *responseWriter) start(compress bool) error {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = writers.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) > 0 {
+		buf := w.buf
+		w.buf = nil
+		var err error
+		if w.gz != nil {
+			_, err = w.gz.Write(buf)
+		} else {
+			_, err = w.ResponseWriter.Write(buf)
+		}
+		return err
+	}
+	return nil
+}
+
+// Flush sends what was written so far. A streamed response is compressed
+// if it is eligible, whatever its size.
+func (w *responseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.start(w.eligible()); err != nil {
+			slog.Debug("failed to write response", "error", err)
+			return
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			slog.Debug("failed to write response", "error", err)
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close sends a small response uncompressed and finishes a compressed one.
+func (w *responseWriter) close() {
+	if !w.decided {
+		if err := w.start(false); err != nil {
+			slog.Debug("failed to write response", "error", err)
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			slog.Debug("failed to write response", "error", err)
+		}
+		w.gz.Reset(nil)
+		writers.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// Hijack lets WebSocket and similar handlers take over the connection.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,136 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	large := strings.Repeat(`{"clicks":1},`, MinSize)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantGzip       bool
+	}{
+		{name: "compresses large JSON", acceptEncoding: "gzip, deflate, br", contentType: "application/json", body: large, wantGzip: true},
+		{name: "compresses large CSV", acceptEncoding: "gzip", contentType: "text/csv; charset=utf-8", body: large, wantGzip: true},
+		{name: "skips small bodies", acceptEncoding: "gzip", contentType: "application/json", body: `{"clicks":1}`},
+		{name: "skips other media types", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "skips without Accept-Encoding", contentType: "application/json", body: large},
+		{name: "skips when gzip is refused", acceptEncoding: "gzip;q=0, deflate", contentType: "application/json", body: large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				// Write in small chunks to exercise buffering up to MinSize
+				for body := tt.body; body != ""; {
+					n := min(len(body), 100)
+					if _, err := io.WriteString(w, body[:n]); err != nil {
+						t.Errorf("Write() unexpected error = %v", err)
+					}
+					body = body[n:]
+				}
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			Middleware(next).ServeHTTP(w, req)
+
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip = %v", w.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+
+			body := w.Body.String()
+			if gzipped {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() unexpected error = %v", err)
+				}
+				b, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("failed to decompress body: %v", err)
+				}
+				body = string(b)
+			}
+			if body != tt.body {
+				t.Errorf("body = %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestMiddleware_StatusOnly(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	Middleware(next).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotModified || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("status = %d, Content-Encoding = %q, want 304 unencoded", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestMiddleware_Flush(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		io.WriteString(w, "short_code,start,clicks\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush() unexpected error = %v", err)
+		}
+		io.WriteString(w, "abc,2024-01-01T00:00:00Z,3\n")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	Middleware(next).ServeHTTP(w, req)
+
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("flushed = %v, Content-Encoding = %q, want a flushed gzip stream", w.Flushed, w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() unexpected error = %v", err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+	if want := "short_code,start,clicks\nabc,2024-01-01T00:00:00Z,3\n"; string(b) != want {
+		t.Errorf("body = %q, want %q", b, want)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, GZIP":        true,
+		"br;q=1.0, gzip;q=0.5": true,
+		"*":                    true,
+		"gzip;q=0":             false,
+		"identity":             false,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
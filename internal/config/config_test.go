@@ -0,0 +1,939 @@
+package config
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envFunc(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+
+	if cfg.Port != 8080 {
+		t.Errorf("Port = %d, want 8080", cfg.Port)
+	}
+	if cfg.BaseURL != "http://localhost:8080" {
+		t.Errorf("BaseURL = %q", cfg.BaseURL)
+	}
+	if cfg.LogLevel != slog.LevelInfo {
+		t.Errorf("LogLevel = %v, want info", cfg.LogLevel)
+	}
+	if cfg.ReaperInterval != time.Minute {
+		t.Errorf("ReaperInterval = %v, want 1m", cfg.ReaperInterval)
+	}
+	if cfg.RateLimitShorten != 10 || cfg.RateLimitShortenBurst != 10 {
+		t.Errorf("rate limit = %d/%d, want 10/10", cfg.RateLimitShorten, cfg.RateLimitShortenBurst)
+	}
+	if cfg.CanonicalizeMaxBatch != 100 {
+		t.Errorf("CanonicalizeMaxBatch = %d, want 100", cfg.CanonicalizeMaxBatch)
+	}
+	if cfg.RateLimitReport != 5 || cfg.AbuseReportThreshold != 5 {
+		t.Errorf("report limit/threshold = %d/%d, want 5/5", cfg.RateLimitReport, cfg.AbuseReportThreshold)
+	}
+	if cfg.IdempotencyTTL != 24*time.Hour {
+		t.Errorf("IdempotencyTTL = %v, want 24h", cfg.IdempotencyTTL)
+	}
+}
+
+func TestLoad_Postgres(t *testing.T) {
+	env := map[string]string{
+		"DB_HOST":     "db",
+		"DB_USER":     "postgres",
+		"DB_PASSWORD": "password",
+		"DB_NAME":     "shortener",
+		"REDIS_ADDR":  "cache:6379",
+		"BASE_URL":    "https://sho.rt/",
+	}
+	cfg, err := Load(nil, envFunc(env))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+
+	if cfg.StorageBackend != BackendPostgres {
+		t.Errorf("StorageBackend = %q, want postgres", cfg.StorageBackend)
+	}
+	want := "host=db port=5432 user=postgres password=password dbname=shortener sslmode=disable"
+	if got := cfg.Postgres.DSN(); got != want {
+		t.Errorf("DSN() = %q, want %q", got, want)
+	}
+	if cfg.BaseURL != "https://sho.rt" {
+		t.Errorf("BaseURL = %q, want trailing slash trimmed", cfg.BaseURL)
+	}
+}
+
+func TestLoad_FlagsOverrideEnv(t *testing.T) {
+	env := map[string]string{"STORAGE_BACKEND": "memory", "PORT": "9000", "RATE_LIMIT_SHORTEN": "5"}
+	cfg, err := Load([]string{"-port", "9100", "-rate-limit-shorten-burst=20"}, envFunc(env))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+
+	if cfg.Port != 9100 {
+		t.Errorf("Port = %d, want 9100 from flag", cfg.Port)
+	}
+	if cfg.RateLimitShorten != 5 || cfg.RateLimitShortenBurst != 20 {
+		t.Errorf("rate limit = %d/%d, want 5/20", cfg.RateLimitShorten, cfg.RateLimitShortenBurst)
+	}
+}
+
+func TestLoad_ReportsAllProblems(t *testing.T) {
+	env := map[string]string{
+		"PORT":            "http",
+		"REAPER_INTERVAL": "-1s",
+		"LOG_LEVEL":       "verbose",
+	}
+	_, err := Load(nil, envFunc(env))
+	if err == nil {
+		t.Fatal("Load() expected error")
+	}
+
+	for _, want := range []string{"PORT", "REAPER_INTERVAL", "LOG_LEVEL", "DB_HOST is required", "DB_USER is required", "DB_NAME is required", "REDIS_ADDR is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoad_InvalidBackend(t *testing.T) {
+	_, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "mysql"}))
+	if err == nil || !strings.Contains(err.Error(), "STORAGE_BACKEND") {
+		t.Errorf("Load() error = %v, want STORAGE_BACKEND error", err)
+	}
+}
+
+func TestLoad_PoolSettings(t *testing.T) {
+	env := map[string]string{
+		"STORAGE_BACKEND":   "memory",
+		"DB_MAX_OPEN_CONNS": "5",
+		"DB_MAX_IDLE_CONNS": "10",
+	}
+	_, err := Load(nil, envFunc(env))
+	if err == nil || !strings.Contains(err.Error(), "DB_MAX_IDLE_CONNS") {
+		t.Errorf("Load() error = %v, want DB_MAX_IDLE_CONNS error", err)
+	}
+
+	env["DB_MAX_IDLE_CONNS"] = "2"
+	cfg, err := Load(nil, envFunc(env))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.Postgres.MaxOpenConns != 5 || cfg.Postgres.MaxIdleConns != 2 {
+		t.Errorf("pool = %d/%d, want 5/2", cfg.Postgres.MaxOpenConns, cfg.Postgres.MaxIdleConns)
+	}
+	if cfg.Postgres.ConnectTimeout != 30*time.Second {
+		t.Errorf("ConnectTimeout = %v, want 30s", cfg.Postgres.ConnectTimeout)
+	}
+	if !cfg.Postgres.PreparedStatements {
+		t.Error("PreparedStatements = false, want true by default")
+	}
+
+	env["DB_PREPARED_STATEMENTS"] = "false"
+	if cfg, err = Load(nil, envFunc(env)); err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.Postgres.PreparedStatements {
+		t.Error("PreparedStatements = true, want false")
+	}
+	env["DB_PREPARED_STATEMENTS"] = "sometimes"
+	if _, err := Load(nil, envFunc(env)); err == nil || !strings.Contains(err.Error(), "DB_PREPARED_STATEMENTS") {
+		t.Errorf("Load() error = %v, want DB_PREPARED_STATEMENTS error", err)
+	}
+}
+
+func TestLoad_CacheTTL(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CacheTTL != 24*time.Hour || cfg.CacheJitterPercent != 10 {
+		t.Errorf("cache TTL = %v ±%d%%, want 24h ±10%%", cfg.CacheTTL, cfg.CacheJitterPercent)
+	}
+
+	cfg, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CACHE_TTL": "1h", "CACHE_TTL_JITTER_PERCENT": "0"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CacheTTL != time.Hour || cfg.CacheJitterPercent != 0 {
+		t.Errorf("cache TTL = %v ±%d%%, want 1h ±0%%", cfg.CacheTTL, cfg.CacheJitterPercent)
+	}
+
+	for _, env := range []map[string]string{{"CACHE_TTL": "0"}, {"CACHE_TTL_JITTER_PERCENT": "101"}} {
+		env["STORAGE_BACKEND"] = "memory"
+		if _, err := Load(nil, envFunc(env)); err == nil || !strings.Contains(err.Error(), "CACHE_TTL") {
+			t.Errorf("Load(%v) error = %v, want CACHE_TTL error", env, err)
+		}
+	}
+}
+
+func TestLoad_LocalCache(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.LocalCacheSize != 0 || cfg.LocalCacheTTL != 5*time.Second {
+		t.Errorf("local cache = %d/%v, want 0/5s", cfg.LocalCacheSize, cfg.LocalCacheTTL)
+	}
+
+	cfg, err = Load([]string{"-local-cache-size", "10000", "-local-cache-ttl", "1s"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.LocalCacheSize != 10000 || cfg.LocalCacheTTL != time.Second {
+		t.Errorf("local cache = %d/%v, want 10000/1s", cfg.LocalCacheSize, cfg.LocalCacheTTL)
+	}
+
+	for _, env := range []map[string]string{{"LOCAL_CACHE_SIZE": "-1"}, {"LOCAL_CACHE_TTL": "0s"}} {
+		env["STORAGE_BACKEND"] = "memory"
+		if _, err := Load(nil, envFunc(env)); err == nil || !strings.Contains(err.Error(), "LOCAL_CACHE") {
+			t.Errorf("Load(%v) error = %v, want LOCAL_CACHE error", env, err)
+		}
+	}
+}
+
+func TestLoad_AdminToken(t *testing.T) {
+	_, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "ADMIN_TOKEN": "short"}))
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_TOKEN") {
+		t.Errorf("Load() error = %v, want ADMIN_TOKEN error", err)
+	}
+
+	cfg, err := Load([]string{"-admin-token", "0123456789abcdef"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.AdminToken != "0123456789abcdef" {
+		t.Errorf("AdminToken = %q", cfg.AdminToken)
+	}
+}
+
+func TestLoad_SigningSecret(t *testing.T) {
+	_, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "REQUEST_SIGNING_SECRET": "short"}))
+	if err == nil || !strings.Contains(err.Error(), "REQUEST_SIGNING_SECRET") {
+		t.Errorf("Load() error = %v, want REQUEST_SIGNING_SECRET error", err)
+	}
+
+	secret := strings.Repeat("s", 32)
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "REQUEST_SIGNING_SECRET": secret}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.SigningSecret != secret || cfg.SigningMaxSkew != 5*time.Minute {
+		t.Errorf("SigningSecret, SigningMaxSkew = %q, %v", cfg.SigningSecret, cfg.SigningMaxSkew)
+	}
+}
+
+func TestLoad_CodeStrategy(t *testing.T) {
+	cfg, err := Load([]string{"-code-strategy", "random", "-random-code-length", "8"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CodeStrategy != CodeRandom || cfg.RandomCodeLength != 8 {
+		t.Errorf("code strategy = %s/%d, want random/8", cfg.CodeStrategy, cfg.RandomCodeLength)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "uuid", "RANDOM_CODE_LENGTH": "20"}))
+	if err == nil || !strings.Contains(err.Error(), "CODE_STRATEGY") || !strings.Contains(err.Error(), "RANDOM_CODE_LENGTH") {
+		t.Errorf("Load() error = %v, want CODE_STRATEGY and RANDOM_CODE_LENGTH errors", err)
+	}
+}
+
+func TestLoad_ObfuscatedCodes(t *testing.T) {
+	_, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "obfuscated"}))
+	if err == nil || !strings.Contains(err.Error(), "CODE_OBFUSCATION_KEY") {
+		t.Errorf("Load() error = %v, want CODE_OBFUSCATION_KEY error", err)
+	}
+
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "obfuscated", "CODE_OBFUSCATION_KEY": "0123456789abcdef"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CodeStrategy != CodeObfuscated {
+		t.Errorf("CodeStrategy = %q, want obfuscated", cfg.CodeStrategy)
+	}
+}
+
+func TestLoad_BlockCodes(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "block"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CodeStrategy != CodeBlock || cfg.IDBlockSize != 100 {
+		t.Errorf("code strategy = %q/%d, want block/100", cfg.CodeStrategy, cfg.IDBlockSize)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "block", "ID_BLOCK_SIZE": "0"}))
+	if err == nil || !strings.Contains(err.Error(), "ID_BLOCK_SIZE") {
+		t.Errorf("Load() error = %v, want ID_BLOCK_SIZE error", err)
+	}
+}
+
+func TestLoad_SnowflakeCodes(t *testing.T) {
+	for _, worker := range []string{"", "-1", "1024"} {
+		_, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "snowflake", "SNOWFLAKE_WORKER_ID": worker}))
+		if err == nil || !strings.Contains(err.Error(), "SNOWFLAKE_WORKER_ID") {
+			t.Errorf("worker %q: Load() error = %v, want SNOWFLAKE_WORKER_ID error", worker, err)
+		}
+	}
+
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_STRATEGY": "snowflake", "SNOWFLAKE_WORKER_ID": "12"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CodeStrategy != CodeSnowflake || cfg.SnowflakeWorkerID != 12 {
+		t.Errorf("code strategy = %q/%d, want snowflake/12", cfg.CodeStrategy, cfg.SnowflakeWorkerID)
+	}
+}
+
+func TestLoad_CodeAlphabet(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CODE_ALPHABET": "base58"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CodeAlphabet != AlphabetBase58 {
+		t.Errorf("CodeAlphabet = %q, want base58", cfg.CodeAlphabet)
+	}
+
+	for _, env := range []map[string]string{
+		{"STORAGE_BACKEND": "memory", "CODE_ALPHABET": "base64"},
+		{"STORAGE_BACKEND": "memory", "CODE_ALPHABET": "base32", "CODE_STRATEGY": "snowflake", "SNOWFLAKE_WORKER_ID": "1"},
+	} {
+		if _, err := Load(nil, envFunc(env)); err == nil || !strings.Contains(err.Error(), "CODE_ALPHABET") {
+			t.Errorf("%v: Load() error = %v, want CODE_ALPHABET error", env, err)
+		}
+	}
+}
+
+func TestLoad_CodePolicy(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{
+		"STORAGE_BACKEND":        "memory",
+		"CODE_MIN_LENGTH":        "4",
+		"CODE_ORG_PREFIXES":      "1:acme-, 2:globex_",
+		"CODE_RESERVED_PREFIXES": "admin,x-",
+	}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CodeMinLength != 4 || len(cfg.CodeOrgPrefixes) != 2 || cfg.CodeOrgPrefixes[1] != "acme-" || cfg.CodeOrgPrefixes[2] != "globex_" {
+		t.Errorf("code policy = %d/%v", cfg.CodeMinLength, cfg.CodeOrgPrefixes)
+	}
+	if len(cfg.CodeReservedPrefixes) != 2 || cfg.CodeReservedPrefixes[1] != "x-" {
+		t.Errorf("CodeReservedPrefixes = %v", cfg.CodeReservedPrefixes)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"too long", map[string]string{"CODE_MIN_LENGTH": "11"}, "CODE_MIN_LENGTH"},
+		{"with snowflake", map[string]string{"CODE_MIN_LENGTH": "5", "CODE_STRATEGY": "snowflake", "SNOWFLAKE_WORKER_ID": "1"}, "CODE_MIN_LENGTH"},
+		{"prefix without organization", map[string]string{"CODE_ORG_PREFIXES": "acme-"}, "CODE_ORG_PREFIXES"},
+		{"organization zero", map[string]string{"CODE_ORG_PREFIXES": "0:acme-"}, "CODE_ORG_PREFIXES"},
+		{"two prefixes", map[string]string{"CODE_ORG_PREFIXES": "1:acme-,1:ac-"}, "two prefixes"},
+		{"empty reserved prefix", map[string]string{"CODE_RESERVED_PREFIXES": "admin,"}, "CODE_RESERVED_PREFIXES"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["STORAGE_BACKEND"] = "memory"
+			_, err := Load(nil, envFunc(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %s error", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Plans(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if len(cfg.Plans) != 0 || cfg.DefaultPlan != "free" {
+		t.Errorf("plans = %v/%q, want none/free", cfg.Plans, cfg.DefaultPlan)
+	}
+
+	cfg, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "PLAN_LIMITS": "free:50/500/5; pro:0/0/100"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if got := cfg.Plans["free"]; got != (Plan{LinksPerDay: 50, TotalLinks: 500, CustomAliases: 5}) {
+		t.Errorf("free plan = %+v", got)
+	}
+	if got := cfg.Plans["pro"]; got != (Plan{CustomAliases: 100}) {
+		t.Errorf("pro plan = %+v", got)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"malformed entry", map[string]string{"PLAN_LIMITS": "free:50/500"}, "PLAN_LIMITS"},
+		{"negative limit", map[string]string{"PLAN_LIMITS": "free:-1/0/0"}, "PLAN_LIMITS"},
+		{"duplicate plan", map[string]string{"PLAN_LIMITS": "free:1/1/1;free:2/2/2"}, "defined twice"},
+		{"unknown default plan", map[string]string{"PLAN_LIMITS": "pro:0/0/0"}, "DEFAULT_PLAN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["STORAGE_BACKEND"] = "memory"
+			_, err := Load(nil, envFunc(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %s error", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_EventSink(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.EventSink != "" || cfg.EventSubject != "clicks" || cfg.EventBufferSize != 10000 {
+		t.Errorf("event sink = %q/%q/%d, want disabled/clicks/10000", cfg.EventSink, cfg.EventSubject, cfg.EventBufferSize)
+	}
+
+	cfg, err = Load([]string{"-event-sink", "nats", "-event-sink-url", "nats://localhost:4222"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.EventSink != EventSinkNATS || cfg.EventSinkURL != "nats://localhost:4222" {
+		t.Errorf("event sink = %q/%q", cfg.EventSink, cfg.EventSinkURL)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"nats without URL", map[string]string{"EVENT_SINK": "nats"}, "EVENT_SINK_URL is required"},
+		{"redis without Redis", map[string]string{"EVENT_SINK": "redis"}, "REDIS_ADDR is required"},
+		{"unknown sink", map[string]string{"EVENT_SINK": "kafka"}, "EVENT_SINK"},
+		{"zero buffer", map[string]string{"EVENT_BUFFER_SIZE": "0"}, "EVENT_BUFFER_SIZE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["STORAGE_BACKEND"] = "memory"
+			_, err := Load(nil, envFunc(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %s error", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_TLS(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.TLSEnabled() || cfg.HTTPRedirectPort != 0 {
+		t.Errorf("TLS enabled = %v, redirect port %d, want plain HTTP", cfg.TLSEnabled(), cfg.HTTPRedirectPort)
+	}
+
+	cfg, err = Load([]string{"-autocert-domains", "Sho.rt, go.example.com.", "-http-redirect-port", "80", "-port", "443"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if !cfg.TLSEnabled() || strings.Join(cfg.AutocertDomains, ",") != "sho.rt,go.example.com" || cfg.AutocertCacheDir != "autocert-cache" {
+		t.Errorf("autocert = %v in %q, want sho.rt,go.example.com in autocert-cache", cfg.AutocertDomains, cfg.AutocertCacheDir)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"cert without key", map[string]string{"TLS_CERT_FILE": "cert.pem"}, "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{"cert and autocert", map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem", "AUTOCERT_DOMAINS": "sho.rt"}, "cannot be used together"},
+		{"wildcard", map[string]string{"AUTOCERT_DOMAINS": "*.sho.rt"}, "AUTOCERT_DOMAINS"},
+		{"port", map[string]string{"AUTOCERT_DOMAINS": "sho.rt:443"}, "AUTOCERT_DOMAINS"},
+		{"IP", map[string]string{"AUTOCERT_DOMAINS": "192.0.2.1"}, "AUTOCERT_DOMAINS"},
+		{"redirect without TLS", map[string]string{"HTTP_REDIRECT_PORT": "80"}, "HTTP_REDIRECT_PORT requires"},
+		{"redirect on the same port", map[string]string{"AUTOCERT_DOMAINS": "sho.rt", "HTTP_REDIRECT_PORT": "8080"}, "must differ from PORT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["STORAGE_BACKEND"] = "memory"
+			_, err := Load(nil, envFunc(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %s error", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Proxies(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if len(cfg.TrustedProxies) != 0 || cfg.ClientIPHeader != "X-Forwarded-For" {
+		t.Errorf("proxies = %v/%q, want none/X-Forwarded-For", cfg.TrustedProxies, cfg.ClientIPHeader)
+	}
+	if !cfg.KeepAlives || cfg.H2C || cfg.HTTP2MaxConcurrentStreams != 250 || cfg.ReadHeaderTimeout != 5*time.Second {
+		t.Errorf("server = keep-alives %v, h2c %v, %d streams, %v header timeout", cfg.KeepAlives, cfg.H2C, cfg.HTTP2MaxConcurrentStreams, cfg.ReadHeaderTimeout)
+	}
+
+	cfg, err = Load([]string{"-trusted-proxies", "10.0.0.0/8, 192.0.2.1,fd00::/8", "-client-ip-header", "forwarded", "-http-h2c", "true"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	var got []string
+	for _, p := range cfg.TrustedProxies {
+		got = append(got, p.String())
+	}
+	if strings.Join(got, ",") != "10.0.0.0/8,192.0.2.1/32,fd00::/8" || !cfg.H2C {
+		t.Errorf("TrustedProxies = %v, H2C = %v", got, cfg.H2C)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "TRUSTED_PROXIES": "10.0.0.0/33", "CLIENT_IP_HEADER": "X-Client"}))
+	if err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") || !strings.Contains(err.Error(), "CLIENT_IP_HEADER") {
+		t.Errorf("Load() error = %v, want TRUSTED_PROXIES and CLIENT_IP_HEADER errors", err)
+	}
+}
+
+func TestLoad_AccessLog(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if !cfg.AccessLog || cfg.AccessLogSampleRate != 1 || cfg.AccessLogRedactCodes {
+		t.Errorf("access log = %v/%v/%v, want enabled, unsampled, unredacted", cfg.AccessLog, cfg.AccessLogSampleRate, cfg.AccessLogRedactCodes)
+	}
+
+	cfg, err = Load([]string{"-access-log-sample-rate", "0.05", "-access-log-redact-codes", "true"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.AccessLogSampleRate != 0.05 || !cfg.AccessLogRedactCodes {
+		t.Errorf("access log = %v/%v, want 0.05/redacted", cfg.AccessLogSampleRate, cfg.AccessLogRedactCodes)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "ACCESS_LOG_SAMPLE_RATE": "1.5"}))
+	if err == nil || !strings.Contains(err.Error(), "ACCESS_LOG_SAMPLE_RATE") {
+		t.Errorf("Load() error = %v, want ACCESS_LOG_SAMPLE_RATE error", err)
+	}
+}
+
+func TestLoad_Retry(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.RetryMaxAttempts != 3 || cfg.RetryBaseDelay != 20*time.Millisecond || cfg.RetryMaxDelay != 200*time.Millisecond {
+		t.Errorf("retry = %d/%v/%v, want 3/20ms/200ms", cfg.RetryMaxAttempts, cfg.RetryBaseDelay, cfg.RetryMaxDelay)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "RETRY_MAX_ATTEMPTS": "0", "RETRY_MAX_DELAY": "0s"}))
+	if err == nil || !strings.Contains(err.Error(), "RETRY_MAX_ATTEMPTS") || !strings.Contains(err.Error(), "RETRY_MAX_DELAY") {
+		t.Errorf("Load() error = %v, want RETRY_MAX_ATTEMPTS and RETRY_MAX_DELAY errors", err)
+	}
+}
+
+func TestLoad_StaleCache(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.StaleCacheTTL != 0 {
+		t.Errorf("StaleCacheTTL = %v, want disabled", cfg.StaleCacheTTL)
+	}
+
+	cfg, err = Load([]string{"-stale-cache-ttl", "168h"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.StaleCacheTTL != 168*time.Hour {
+		t.Errorf("StaleCacheTTL = %v, want 168h", cfg.StaleCacheTTL)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "STALE_CACHE_TTL": "a week"}))
+	if err == nil || !strings.Contains(err.Error(), "STALE_CACHE_TTL") {
+		t.Errorf("Load() error = %v, want STALE_CACHE_TTL error", err)
+	}
+}
+
+func TestLoad_CacheWarming(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{
+		"STORAGE_BACKEND":     "postgres",
+		"DB_HOST":             "db",
+		"DB_USER":             "app",
+		"DB_NAME":             "urls",
+		"REDIS_ADDR":          "redis:6379",
+		"CACHE_WARM_INTERVAL": "10m",
+	}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CacheWarmInterval != 10*time.Minute {
+		t.Errorf("CacheWarmInterval = %v, want 10m", cfg.CacheWarmInterval)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CACHE_WARM_INTERVAL": "10m"}))
+	if err == nil || !strings.Contains(err.Error(), "CACHE_WARM_INTERVAL requires STORAGE_BACKEND=postgres") {
+		t.Errorf("Load() error = %v, want a backend error", err)
+	}
+}
+
+func TestLoad_CachePreload(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{
+		"STORAGE_BACKEND":   "postgres",
+		"DB_HOST":           "db",
+		"DB_USER":           "app",
+		"DB_NAME":           "urls",
+		"REDIS_ADDR":        "redis:6379",
+		"CACHE_PRELOAD_TOP": "1000",
+	}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.CachePreloadTop != 1000 {
+		t.Errorf("CachePreloadTop = %d, want 1000", cfg.CachePreloadTop)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "sqlite", "CACHE_PRELOAD_TOP": "1000"}))
+	if err == nil || !strings.Contains(err.Error(), "CACHE_PRELOAD_TOP requires STORAGE_BACKEND=postgres") {
+		t.Errorf("Load() error = %v, want a backend error", err)
+	}
+}
+
+func TestLoad_IPAnonymization(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.IPAnonymization != IPAnonNone {
+		t.Errorf("IPAnonymization = %q, want %q", cfg.IPAnonymization, IPAnonNone)
+	}
+
+	cfg, err = Load([]string{"-ip-anonymization", "hash"}, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "IP_HASH_KEY": "0123456789abcdef"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.IPAnonymization != IPAnonHash || cfg.IPHashKey != "0123456789abcdef" {
+		t.Errorf("IPAnonymization = %q with key %q", cfg.IPAnonymization, cfg.IPHashKey)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"unknown mode", map[string]string{"IP_ANONYMIZATION": "mask"}, "IP_ANONYMIZATION"},
+		{"hash without key", map[string]string{"IP_ANONYMIZATION": "hash"}, "IP_HASH_KEY"},
+		{"short key", map[string]string{"IP_ANONYMIZATION": "hash", "IP_HASH_KEY": "short"}, "IP_HASH_KEY"},
+	}
+	for _, tt := range tests {
+		tt.env["STORAGE_BACKEND"] = "memory"
+		if _, err := Load(nil, envFunc(tt.env)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Load() error = %v, want %s error", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoad_OIDC(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{
+		"STORAGE_BACKEND": "memory",
+		"OIDC_ISSUER":     "https://idp.example.com/realms/corp",
+		"OIDC_AUDIENCE":   "shortener",
+	}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.OIDCIssuer != "https://idp.example.com/realms/corp" || cfg.OIDCAudience != "shortener" || cfg.OIDCJWKSURL != "" || cfg.OIDCJWKSCacheTTL != time.Hour {
+		t.Errorf("OIDC = %q for %q from %q cached %v", cfg.OIDCIssuer, cfg.OIDCAudience, cfg.OIDCJWKSURL, cfg.OIDCJWKSCacheTTL)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"issuer without audience", map[string]string{"OIDC_ISSUER": "https://idp.example.com"}, "OIDC_AUDIENCE"},
+		{"relative issuer", map[string]string{"OIDC_ISSUER": "idp.example.com", "OIDC_AUDIENCE": "shortener"}, "OIDC_ISSUER"},
+		{"bad keys URL", map[string]string{"OIDC_ISSUER": "https://idp.example.com", "OIDC_AUDIENCE": "shortener", "OIDC_JWKS_URL": "file:///keys"}, "OIDC_JWKS_URL"},
+		{"keys URL without issuer", map[string]string{"OIDC_JWKS_URL": "https://idp.example.com/keys"}, "OIDC_JWKS_URL"},
+	}
+	for _, tt := range tests {
+		tt.env["STORAGE_BACKEND"] = "memory"
+		if _, err := Load(nil, envFunc(tt.env)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Load() error = %v, want %s error", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoad_HonorDoNotTrack(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "true": true} {
+		cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "HONOR_DNT": value}))
+		if err != nil {
+			t.Fatalf("Load() unexpected error = %v", err)
+		}
+		if cfg.HonorDoNotTrack != want {
+			t.Errorf("HONOR_DNT=%q: HonorDoNotTrack = %v, want %v", value, cfg.HonorDoNotTrack, want)
+		}
+	}
+}
+
+func TestLoad_SecurityContacts(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"unset", "", nil, false},
+		{"several", "mailto:security@example.com, https://example.com/security", []string{"mailto:security@example.com", "https://example.com/security"}, false},
+		{"phone", "tel:+1-201-555-0123", []string{"tel:+1-201-555-0123"}, false},
+		{"plain address", "security@example.com", nil, true},
+		{"http URL", "http://example.com/security", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "SECURITY_CONTACTS": tt.value}))
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "SECURITY_CONTACTS") {
+					t.Errorf("Load() error = %v, want SECURITY_CONTACTS error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() unexpected error = %v", err)
+			}
+			if !slices.Equal(cfg.SecurityContacts, tt.want) {
+				t.Errorf("SecurityContacts = %q, want %q", cfg.SecurityContacts, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_HomographPolicy(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "HOMOGRAPH_POLICY": "flag"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.HomographPolicy != "flag" {
+		t.Errorf("HomographPolicy = %q, want flag", cfg.HomographPolicy)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "HOMOGRAPH_POLICY": "warn"}))
+	if err == nil || !strings.Contains(err.Error(), "HOMOGRAPH_POLICY") {
+		t.Errorf("Load() error = %v, want HOMOGRAPH_POLICY error", err)
+	}
+}
+
+func TestLoad_DomainLists(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "DOMAIN_BLOCKLIST": "spam.example, *.spam.example", "DOMAIN_ALLOWLIST": "example.com"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if !slices.Equal(cfg.DomainBlocklist, []string{"spam.example", "*.spam.example"}) || !slices.Equal(cfg.DomainAllowlist, []string{"example.com"}) {
+		t.Errorf("domain lists = %q, %q", cfg.DomainBlocklist, cfg.DomainAllowlist)
+	}
+}
+
+func TestLoad_KnownShorteners(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", strings.Split(defaultKnownShorteners, ",")},
+		{"bit.ly, lnkd.in", []string{"bit.ly", "lnkd.in"}},
+		{"none", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "KNOWN_SHORTENERS": tt.value}))
+			if err != nil {
+				t.Fatalf("Load() unexpected error = %v", err)
+			}
+			if !slices.Equal(cfg.KnownShorteners, tt.want) {
+				t.Errorf("KnownShorteners = %q, want %q", cfg.KnownShorteners, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_MaskedRedirects(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "frame", want: []string{"frame"}},
+		{value: "frame, proxy", want: []string{"frame", "proxy"}},
+		{value: "cloak", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "MASKED_REDIRECTS": tt.value}))
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "MASKED_REDIRECTS") {
+					t.Errorf("Load() error = %v, want MASKED_REDIRECTS error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() unexpected error = %v", err)
+			}
+			if !slices.Equal(cfg.MaskedRedirects, tt.want) || cfg.MaskProxyTimeout != 10*time.Second || cfg.MaskProxyMaxBytes != 10<<20 {
+				t.Errorf("masking = %q, %s, %d", cfg.MaskedRedirects, cfg.MaskProxyTimeout, cfg.MaskProxyMaxBytes)
+			}
+		})
+	}
+}
+
+func TestLoad_ClickRetention(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.ClickHourlyRetention != 0 || cfg.ClickDailyRetention != 0 || cfg.CleanupInterval != time.Hour {
+		t.Errorf("retention = %v, %v every %v, want forever every 1h", cfg.ClickHourlyRetention, cfg.ClickDailyRetention, cfg.CleanupInterval)
+	}
+
+	cfg, err = Load(nil, envFunc(map[string]string{
+		"STORAGE_BACKEND":        "memory",
+		"CLICK_HOURLY_RETENTION": "168h",
+		"CLICK_DAILY_RETENTION":  "8760h",
+		"CLEANUP_INTERVAL":       "15m",
+	}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.ClickHourlyRetention != 168*time.Hour || cfg.ClickDailyRetention != 8760*time.Hour || cfg.CleanupInterval != 15*time.Minute {
+		t.Errorf("retention = %v, %v every %v", cfg.ClickHourlyRetention, cfg.ClickDailyRetention, cfg.CleanupInterval)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "CLICK_DAILY_RETENTION": "1y"}))
+	if err == nil || !strings.Contains(err.Error(), "CLICK_DAILY_RETENTION") {
+		t.Errorf("Load() error = %v, want CLICK_DAILY_RETENTION error", err)
+	}
+}
+
+func TestLoad_HealthCheck(t *testing.T) {
+	cfg, err := Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory"}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.HealthCheckInterval != 0 || cfg.HealthCheckTimeout != 5*time.Second || cfg.HealthCheckConcurrency != 10 || cfg.HealthCheckDisableAfter != 0 {
+		t.Errorf("health check = every %v, %v timeout, %d at once, disable after %d, want disabled with defaults",
+			cfg.HealthCheckInterval, cfg.HealthCheckTimeout, cfg.HealthCheckConcurrency, cfg.HealthCheckDisableAfter)
+	}
+
+	cfg, err = Load(nil, envFunc(map[string]string{
+		"STORAGE_BACKEND":            "memory",
+		"HEALTH_CHECK_INTERVAL":      "10m",
+		"HEALTH_CHECK_TIMEOUT":       "3s",
+		"HEALTH_CHECK_CONCURRENCY":   "4",
+		"HEALTH_CHECK_DISABLE_AFTER": "5",
+	}))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.HealthCheckInterval != 10*time.Minute || cfg.HealthCheckTimeout != 3*time.Second || cfg.HealthCheckConcurrency != 4 || cfg.HealthCheckDisableAfter != 5 {
+		t.Errorf("health check = every %v, %v timeout, %d at once, disable after %d",
+			cfg.HealthCheckInterval, cfg.HealthCheckTimeout, cfg.HealthCheckConcurrency, cfg.HealthCheckDisableAfter)
+	}
+
+	_, err = Load(nil, envFunc(map[string]string{"STORAGE_BACKEND": "memory", "HEALTH_CHECK_CONCURRENCY": "0"}))
+	if err == nil || !strings.Contains(err.Error(), "HEALTH_CHECK_CONCURRENCY") {
+		t.Errorf("Load() error = %v, want HEALTH_CHECK_CONCURRENCY error", err)
+	}
+}
+
+func TestLoad_Shards(t *testing.T) {
+	env := map[string]string{
+		"STORAGE_BACKEND": "postgres",
+		"DB_HOST":         "db",
+		"DB_USER":         "app",
+		"DB_NAME":         "urls",
+		"REDIS_ADDR":      "redis:6379",
+		"SHARD_DSNS":      "postgres://shard1/urls, postgres://shard2/urls",
+		"SHARD_BOUNDS":    "1000000,2000000",
+	}
+	cfg, err := Load(nil, envFunc(env))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if len(cfg.ShardDSNs) != 2 || cfg.ShardDSNs[1] != "postgres://shard2/urls" {
+		t.Errorf("ShardDSNs = %q, want two DSNs", cfg.ShardDSNs)
+	}
+	if cfg.ShardStrategy != ShardRange || len(cfg.ShardBounds) != 2 || cfg.ShardBounds[1] != 2000000 {
+		t.Errorf("ShardStrategy = %q, ShardBounds = %v, want range 1000000,2000000", cfg.ShardStrategy, cfg.ShardBounds)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "missing bound", env: map[string]string{"SHARD_BOUNDS": "1000000"}, wantErr: "must have one bound per shard"},
+		{name: "decreasing bounds", env: map[string]string{"SHARD_BOUNDS": "2000000,1000000"}, wantErr: "must be increasing"},
+		{name: "hash with bounds", env: map[string]string{"SHARD_STRATEGY": "hash"}, wantErr: "cannot be used with SHARD_STRATEGY=hash"},
+		{name: "unknown strategy", env: map[string]string{"SHARD_STRATEGY": "modulo"}, wantErr: "must be one of range, hash"},
+		{name: "memory backend", env: map[string]string{"STORAGE_BACKEND": "memory"}, wantErr: "SHARD_DSNS requires STORAGE_BACKEND=postgres"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := make(map[string]string)
+			for k, v := range env {
+				merged[k] = v
+			}
+			for k, v := range tt.env {
+				merged[k] = v
+			}
+			_, err := Load(nil, envFunc(merged))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_DynamoDB(t *testing.T) {
+	env := map[string]string{
+		"STORAGE_BACKEND":       "dynamodb",
+		"AWS_REGION":            "eu-west-1",
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	}
+	cfg, err := Load([]string{"-dynamodb-endpoint", "http://localhost:4566"}, envFunc(env))
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.DynamoDB.Table != "url-shortener" || cfg.DynamoDB.Region != "eu-west-1" || cfg.DynamoDB.Endpoint != "http://localhost:4566" {
+		t.Errorf("DynamoDB = %+v", cfg.DynamoDB)
+	}
+
+	for _, tt := range []struct {
+		env     map[string]string
+		wantErr string
+	}{
+		{map[string]string{"AWS_REGION": ""}, "AWS_REGION"},
+		{map[string]string{"AWS_SECRET_ACCESS_KEY": ""}, "AWS_SECRET_ACCESS_KEY"},
+		{map[string]string{"DYNAMODB_ENDPOINT": "localhost:4566"}, "DYNAMODB_ENDPOINT"},
+	} {
+		merged := make(map[string]string)
+		for k, v := range env {
+			merged[k] = v
+		}
+		for k, v := range tt.env {
+			merged[k] = v
+		}
+		if _, err := Load(nil, envFunc(merged)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Load(%v) error = %v, want it to contain %q", tt.env, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of system events.
+const (
+	LinkCreated      = "link.created"
+	LinkDisabled     = "link.disabled"
+	RateLimited      = "rate_limit.triggered"
+	AbuseReportFiled = "abuse_report.filed"
+)
+
+// Event is a change of the system that operators may want to follow, such
+// as a link being created or disabled.
+type Event struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	ShortCode string    `json:"short_code,omitempty"`
+	// Actor is who caused the event, as in the audit log.
+	Actor string `json:"actor,omitempty"`
+	// Detail is specific to the type, e.g. the audit action that disabled
+	// a link or the limit that was hit.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Bus fans system events out to subscribers such as the admin event feed.
+// Like Publisher it never blocks: events for a subscriber that falls behind
+// are dropped.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish hands e to every subscriber, stamping it with the current time if
+// it has none. A nil Bus discards events.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		select {
+		case s.events <- e:
+		default:
+			if s.dropped.Add(1)%100 == 1 {
+				slog.Warn("event subscriber falling behind, dropping events", "type", e.Type, "dropped", s.dropped.Load())
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription receiving the events published from now
+// on, buffering up to bufferSize of them.
+func (b *Bus) Subscribe(bufferSize int) *Subscription {
+	s := &Subscription{bus: b, events: make(chan Event, bufferSize)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Subscription receives the events of a Bus until it is closed.
+type Subscription struct {
+	bus     *Bus
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// Events returns the channel events are delivered on. It is closed by
+// Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the subscriber fell
+// behind.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops the delivery of events. It may be called more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	b.Publish(Event{Type: LinkCreated, ShortCode: "before"})
+
+	fast := b.Subscribe(10)
+	slow := b.Subscribe(1)
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	b.Publish(Event{Type: LinkCreated, ShortCode: "a", Timestamp: at})
+	b.Publish(Event{Type: LinkDisabled, ShortCode: "a"})
+
+	tests := []struct {
+		name        string
+		sub         *Subscription
+		wantCodes   []string
+		wantDropped int64
+	}{
+		{name: "fast subscriber", sub: fast, wantCodes: []string{LinkCreated, LinkDisabled}},
+		{name: "slow subscriber", sub: slow, wantCodes: []string{LinkCreated}, wantDropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.sub.Close()
+			var got []Event
+			for e := range tt.sub.Events() {
+				got = append(got, e)
+			}
+			if len(got) != len(tt.wantCodes) {
+				t.Fatalf("received %+v, want types %v", got, tt.wantCodes)
+			}
+			for i, e := range got {
+				if e.Type != tt.wantCodes[i] || e.ShortCode != "a" {
+					t.Errorf("event %d = %+v, want type %s for a", i, e, tt.wantCodes[i])
+				}
+				if e.Timestamp.IsZero() {
+					t.Errorf("event %d has no timestamp", i)
+				}
+			}
+			if !got[0].Timestamp.Equal(at) {
+				t.Errorf("timestamp = %v, want the published %v", got[0].Timestamp, at)
+			}
+			if d := tt.sub.Dropped(); d != tt.wantDropped {
+				t.Errorf("Dropped() = %d, want %d", d, tt.wantDropped)
+			}
+			tt.sub.Close()
+		})
+	}
+
+	// Publishing without subscribers, or to a nil bus, does nothing
+	b.Publish(Event{Type: LinkCreated})
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: LinkCreated})
+}
@@ -0,0 +1,154 @@
+// Package events streams click events to a message broker so that analytics
+// pipelines can consume them without tailing logs.
+//
+// Redirects hand events to a Publisher, which never blocks: events are queued
+// in memory and delivered in batches by a background goroutine. If the
+// broker falls behind and the queue fills up, new events are dropped (and
+// counted) rather than slowing redirects down. Delivery is therefore
+// at-most-once.
+//
+// System events, such as links being created or disabled, are fanned out
+// in process by a Bus to the admin event feed and other subscribers.
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxBatch is the largest number of events handed to a Sink at once.
+	maxBatch = 100
+	// sendTimeout bounds a single delivery to the broker.
+	sendTimeout = 5 * time.Second
+)
+
+// Click describes one redirect.
+type Click struct {
+	ShortCode string    `json:"short_code"`
+	Timestamp time.Time `json:"timestamp"`
+	// IP is the client address. It is never published: the Publisher
+	// replaces it with IPHash.
+	IP        string `json:"-"`
+	IPHash    string `json:"ip_hash,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+}
+
+// Sink delivers encoded events to a broker. Send is only called from one
+// goroutine at a time.
+type Sink interface {
+	Send(ctx context.Context, events [][]byte) error
+	Close() error
+}
+
+// Publisher queues events for asynchronous delivery to a Sink.
+type Publisher struct {
+	sink    Sink
+	ipKey   []byte
+	queue   chan Click
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewPublisher starts delivering events to sink, queueing up to bufferSize
+// of them. Client IPs are hashed with HMAC-SHA256 under ipKey so that
+// consumers can count unique visitors without learning their addresses.
+func NewPublisher(sink Sink, bufferSize int, ipKey string) *Publisher {
+	p := &Publisher{
+		sink:  sink,
+		ipKey: []byte(ipKey),
+		queue: make(chan Click, bufferSize),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues c without blocking. It must not be called after Close.
+func (p *Publisher) Publish(c Click) {
+	if c.IP != "" {
+		c.IPHash = HashIP(p.ipKey, c.IP)
+		c.IP = ""
+	}
+	select {
+	case p.queue <- c:
+	default:
+		if p.dropped.Add(1)%1000 == 1 {
+			slog.Warn("click event queue full, dropping events", "dropped", p.dropped.Load())
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (p *Publisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Close delivers the events still queued, giving up when ctx is done, and
+// closes the sink.
+func (p *Publisher) Close(ctx context.Context) error {
+	close(p.queue)
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+	}
+	return p.sink.Close()
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	batch := make([][]byte, 0, maxBatch)
+	for c := range p.queue {
+		batch = appendEncoded(batch[:0], c)
+		// Take whatever else is already waiting, without blocking
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case c, ok := <-p.queue:
+				if !ok {
+					break fill
+				}
+				batch = appendEncoded(batch, c)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := p.sink.Send(ctx, batch); err != nil {
+			slog.Warn("failed to publish click events", "events", len(batch), "error", err)
+		}
+		cancel()
+	}
+}
+
+// appendEncoded appends the JSON encoding of c to batch. Click only holds
+// strings and a time, so encoding is not expected to fail; if it does the
+// event is dropped.
+func appendEncoded(batch [][]byte, c Click) [][]byte {
+	data, err := json.Marshal(c)
+	if err != nil {
+		slog.Warn("failed to encode click event", "error", err)
+		return batch
+	}
+	return append(batch, data)
+}
+
+// HashIP returns the hex-encoded HMAC-SHA256 of ip under key, truncated to
+// 128 bits.
+func HashIP(key []byte, ip string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink collects delivered events. If block is set, Send waits for
+// it to be closed first.
+type recordingSink struct {
+	mu     sync.Mutex
+	events [][]byte
+	block  chan struct{}
+	err    error
+	closed bool
+}
+
+func (s *recordingSink) Send(ctx context.Context, events [][]byte) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return s.err
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	sink := &recordingSink{}
+	p := NewPublisher(sink, 10, "secret")
+
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	p.Publish(Click{ShortCode: "b", Timestamp: at, IP: "192.0.2.1", UserAgent: "curl/8.0", Referrer: "https://news.example"})
+	p.Publish(Click{ShortCode: "c", Timestamp: at})
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+
+	if !sink.closed {
+		t.Error("Close() did not close the sink")
+	}
+	if len(sink.events) != 2 {
+		t.Fatalf("delivered %d events, want 2", len(sink.events))
+	}
+	var got map[string]any
+	if err := json.Unmarshal(sink.events[0], &got); err != nil {
+		t.Fatalf("event is not JSON: %v", err)
+	}
+	want := map[string]any{
+		"short_code": "b",
+		"timestamp":  "2025-03-01T12:00:00Z",
+		"ip_hash":    HashIP([]byte("secret"), "192.0.2.1"),
+		"user_agent": "curl/8.0",
+		"referrer":   "https://news.example",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("event[%q] = %v, want %v", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("event = %v, want only %v", got, want)
+	}
+}
+
+func TestPublisher_DropsWhenFull(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	p := NewPublisher(sink, 2, "")
+
+	// The first event is taken by the delivery goroutine, which then blocks
+	p.Publish(Click{ShortCode: "a"})
+	deadline := time.Now().Add(time.Second)
+	for len(p.queue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for range 5 {
+		p.Publish(Click{ShortCode: "b"})
+	}
+	if got := p.Dropped(); got != 3 {
+		t.Errorf("Dropped() = %d, want 3", got)
+	}
+
+	close(sink.block)
+	p.Close(context.Background())
+	if len(sink.events) != 3 {
+		t.Errorf("delivered %d events, want 3", len(sink.events))
+	}
+}
+
+func TestPublisher_SurvivesSinkErrors(t *testing.T) {
+	sink := &recordingSink{err: errors.New("broker down")}
+	p := NewPublisher(sink, 10, "")
+
+	p.Publish(Click{ShortCode: "a"})
+	p.Publish(Click{ShortCode: "b"})
+	p.Close(context.Background())
+
+	if len(sink.events) != 2 {
+		t.Errorf("attempted %d events, want 2", len(sink.events))
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	a := HashIP([]byte("key"), "192.0.2.1")
+	if len(a) != 32 {
+		t.Errorf("HashIP() = %q, want 32 hex characters", a)
+	}
+	if a != HashIP([]byte("key"), "192.0.2.1") {
+		t.Error("HashIP() is not deterministic")
+	}
+	if a == HashIP([]byte("other key"), "192.0.2.1") || a == HashIP([]byte("key"), "192.0.2.2") {
+		t.Error("HashIP() collides across keys or addresses")
+	}
+}
@@ -0,0 +1,199 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a minimal NATS server recording CONNECT options and published
+// messages. Each connection is closed after closeAfter PINGs when set.
+type fakeNATS struct {
+	ln         net.Listener
+	info       string
+	errOnPub   string
+	closeAfter int
+
+	mu       sync.Mutex
+	connects []string
+	messages []string
+	conns    int
+}
+
+func newFakeNATS(t *testing.T, configure ...func(*fakeNATS)) *fakeNATS {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	f := &fakeNATS{ln: ln, info: `{"server_id":"test"}`}
+	for _, c := range configure {
+		c(f)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeNATS) url(userinfo string) string {
+	return "nats://" + userinfo + f.ln.Addr().String()
+}
+
+func (f *fakeNATS) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeNATS) handle(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO %s\r\n", f.info)
+
+	r := bufio.NewReader(conn)
+	pings := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			f.mu.Lock()
+			f.connects = append(f.connects, strings.TrimPrefix(line, "CONNECT "))
+			f.mu.Unlock()
+		case strings.HasPrefix(line, "PUB "):
+			var subject string
+			var n int
+			fmt.Sscanf(line, "PUB %s %d", &subject, &n)
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			if f.errOnPub != "" {
+				fmt.Fprintf(conn, "-ERR '%s'\r\n", f.errOnPub)
+				continue
+			}
+			f.mu.Lock()
+			f.messages = append(f.messages, subject+" "+string(payload[:n]))
+			f.mu.Unlock()
+		case line == "PING":
+			// Exercise answering server PINGs before our PONG arrives
+			fmt.Fprint(conn, "PING\r\n")
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			fmt.Fprint(conn, "PONG\r\n")
+			pings++
+			if f.closeAfter > 0 && pings >= f.closeAfter {
+				return
+			}
+		}
+	}
+}
+
+func TestNATS_Send(t *testing.T) {
+	server := newFakeNATS(t)
+	sink, err := NewNATS(server.url("alice:s3cret@"), "clicks", time.Second)
+	if err != nil {
+		t.Fatalf("NewNATS() unexpected error = %v", err)
+	}
+	defer sink.Close()
+
+	ctx := context.Background()
+	if err := sink.Send(ctx, [][]byte{[]byte(`{"short_code":"b"}`), []byte(`{"short_code":"c"}`)}); err != nil {
+		t.Fatalf("Send() unexpected error = %v", err)
+	}
+	if err := sink.Send(ctx, [][]byte{[]byte(`{"short_code":"d"}`)}); err != nil {
+		t.Fatalf("second Send() unexpected error = %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	want := []string{`clicks {"short_code":"b"}`, `clicks {"short_code":"c"}`, `clicks {"short_code":"d"}`}
+	if strings.Join(server.messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("messages = %q, want %q", server.messages, want)
+	}
+	if server.conns != 1 {
+		t.Errorf("connections = %d, want 1 (reused)", server.conns)
+	}
+	if len(server.connects) != 1 || !strings.Contains(server.connects[0], `"user":"alice"`) || !strings.Contains(server.connects[0], `"pass":"s3cret"`) {
+		t.Errorf("CONNECT = %q, want credentials from the URL", server.connects)
+	}
+}
+
+func TestNATS_Reconnects(t *testing.T) {
+	server := newFakeNATS(t, func(f *fakeNATS) { f.closeAfter = 1 })
+	sink, err := NewNATS(server.url(""), "clicks", time.Second)
+	if err != nil {
+		t.Fatalf("NewNATS() unexpected error = %v", err)
+	}
+	defer sink.Close()
+
+	for i := range 3 {
+		if err := sink.Send(context.Background(), [][]byte{[]byte("x")}); err != nil {
+			t.Fatalf("Send() #%d unexpected error = %v", i, err)
+		}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.messages) != 3 {
+		t.Errorf("messages = %q, want 3", server.messages)
+	}
+}
+
+func TestNATS_ServerError(t *testing.T) {
+	server := newFakeNATS(t, func(f *fakeNATS) { f.errOnPub = "Permissions Violation for Publish to clicks" })
+	sink, err := NewNATS(server.url("token@"), "clicks", time.Second)
+	if err != nil {
+		t.Fatalf("NewNATS() unexpected error = %v", err)
+	}
+	defer sink.Close()
+
+	err = sink.Send(context.Background(), [][]byte{[]byte("x")})
+	if err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("Send() error = %v, want the server error", err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.connects) == 0 || !strings.Contains(server.connects[0], `"auth_token":"token"`) {
+		t.Errorf("CONNECT = %q, want the token from the URL", server.connects)
+	}
+}
+
+func TestNATS_RequiresTLS(t *testing.T) {
+	server := newFakeNATS(t, func(f *fakeNATS) { f.info = `{"tls_required":true}` })
+	sink, err := NewNATS(server.url(""), "clicks", time.Second)
+	if err != nil {
+		t.Fatalf("NewNATS() unexpected error = %v", err)
+	}
+	if err := sink.Send(context.Background(), [][]byte{[]byte("x")}); err == nil || !strings.Contains(err.Error(), "TLS") {
+		t.Errorf("Send() error = %v, want TLS error", err)
+	}
+}
+
+func TestNewNATS_Invalid(t *testing.T) {
+	for _, tt := range []struct{ url, subject string }{
+		{"http://localhost:4222", "clicks"},
+		{"nats://", "clicks"},
+		{"nats://localhost:4222", ""},
+		{"nats://localhost:4222", "two words"},
+	} {
+		if _, err := NewNATS(tt.url, tt.subject, time.Second); err == nil {
+			t.Errorf("NewNATS(%q, %q) succeeded, want error", tt.url, tt.subject)
+		}
+	}
+}
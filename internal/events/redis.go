@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStream appends events to a Redis stream, where consumer groups can
+// read them with XREADGROUP. The stream is trimmed to about maxLen entries.
+type RedisStream struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+func NewRedisStream(client *redis.Client, stream string, maxLen int64) *RedisStream {
+	return &RedisStream{client: client, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisStream) Send(ctx context.Context, events [][]byte) error {
+	pipe := s.client.Pipeline()
+	for _, e := range events {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.stream,
+			MaxLen: s.maxLen,
+			Approx: true,
+			Values: []any{"event", e},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to add events to stream %s: %w", s.stream, err)
+	}
+	return nil
+}
+
+// Close does nothing: the client is shared and closed by its owner.
+func (s *RedisStream) Close() error {
+	return nil
+}
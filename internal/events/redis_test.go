@@ -0,0 +1,34 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStream_Send(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	sink := NewRedisStream(client, "clicks", 1000)
+	ctx := context.Background()
+	if err := sink.Send(ctx, [][]byte{[]byte(`{"short_code":"b"}`), []byte(`{"short_code":"c"}`)}); err != nil {
+		t.Fatalf("Send() unexpected error = %v", err)
+	}
+
+	entries, err := client.XRange(ctx, "clicks", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange() unexpected error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Values["event"] != `{"short_code":"b"}` || entries[1].Values["event"] != `{"short_code":"c"}` {
+		t.Errorf("stream entries = %+v", entries)
+	}
+
+	mr.Close()
+	if err := sink.Send(ctx, [][]byte{[]byte("x")}); err == nil {
+		t.Error("Send() with Redis down succeeded, want error")
+	}
+}
@@ -0,0 +1,117 @@
+// Package geoip maps client IP addresses to the country they are located in.
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Resolver returns the ISO 3166-1 alpha-2 country code of addr, or "" if it
+// is unknown.
+type Resolver interface {
+	Country(addr netip.Addr) string
+}
+
+type network struct {
+	first, last netip.Addr
+	country     string
+}
+
+// Table is a Resolver backed by an in-memory list of non-overlapping networks.
+type Table struct {
+	networks []network // sorted by first
+}
+
+// LoadCSV reads a table from the file at path; see ParseCSV for the format.
+func LoadCSV(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open geoip table: %w", err)
+	}
+	defer f.Close()
+
+	t, err := ParseCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// ParseCSV reads lines of the form "network,country", such as
+// "203.0.113.0/24,JP". Blank lines, lines starting with '#' and a
+// "network,..." header are skipped. Networks must not overlap.
+func ParseCSV(r io.Reader) (*Table, error) {
+	var networks []network
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "network,") {
+			continue
+		}
+
+		cidr, country, ok := strings.Cut(line, ",")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected network,country", n)
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 {
+			return nil, fmt.Errorf("line %d: invalid country code %q", n, country)
+		}
+
+		prefix = prefix.Masked()
+		networks = append(networks, network{
+			first:   prefix.Addr().Unmap(),
+			last:    lastAddr(prefix).Unmap(),
+			country: country,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read geoip table: %w", err)
+	}
+
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].first.Less(networks[j].first)
+	})
+	for i := 1; i < len(networks); i++ {
+		if !networks[i-1].last.Less(networks[i].first) {
+			return nil, fmt.Errorf("networks containing %s overlap", networks[i].first)
+		}
+	}
+	return &Table{networks: networks}, nil
+}
+
+// Len returns the number of networks in the table.
+func (t *Table) Len() int {
+	return len(t.networks)
+}
+
+func (t *Table) Country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	// Find the last network starting at or before addr
+	i := sort.Search(len(t.networks), func(i int) bool {
+		return addr.Less(t.networks[i].first)
+	}) - 1
+	if i < 0 || t.networks[i].last.Less(addr) {
+		return ""
+	}
+	return t.networks[i].country
+}
+
+// lastAddr returns the highest address in the masked prefix p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for bit := p.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
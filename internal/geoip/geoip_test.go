@@ -0,0 +1,64 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestTable_Country(t *testing.T) {
+	table, err := ParseCSV(strings.NewReader(`network,country
+# documentation ranges
+203.0.113.0/24,jp
+198.51.100.128/25,US
+
+2001:db8::/32,DE
+`))
+	if err != nil {
+		t.Fatalf("ParseCSV() unexpected error = %v", err)
+	}
+	if table.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", table.Len())
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"203.0.113.0", "JP"},
+		{"203.0.113.255", "JP"},
+		{"203.0.114.0", ""},
+		{"198.51.100.127", ""},
+		{"198.51.100.200", "US"},
+		{"::ffff:203.0.113.7", "JP"},
+		{"2001:db8:1::1", "DE"},
+		{"2001:db9::1", ""},
+		{"10.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		if got := table.Country(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Country(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestParseCSV_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "missing country", input: "203.0.113.0/24\n", want: "line 1"},
+		{name: "bad network", input: "203.0.113.0/33,JP\n", want: "line 1"},
+		{name: "bad country", input: "\n203.0.113.0/24,JPN\n", want: "line 2"},
+		{name: "overlap", input: "203.0.113.0/24,JP\n203.0.113.128/25,US\n", want: "overlap"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCSV(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseCSV() error = %v, want mention of %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,567 @@
+// Package graphql executes GraphQL requests against a schema of Go
+// resolvers. It implements the part of the language dashboards use —
+// queries and mutations with arguments, variables, aliases and fragments —
+// which is small enough not to need a library. Directives, subscriptions
+// and introspection are not supported, and argument types are checked by
+// the resolvers reading them rather than against a schema.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDepth bounds how deeply selections may nest.
+	maxDepth = 10
+	// maxResolves bounds the resolvers a request may run, so that aliases
+	// cannot multiply the work of one request without limit.
+	maxResolves = 200
+)
+
+// ErrInvalidArgument is returned by Args for arguments of the wrong type.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// Type is the type of a field: a *Scalar, *List or *Object.
+type Type interface {
+	typeName() string
+}
+
+// Scalar is a leaf type, encoded as JSON.
+type Scalar struct {
+	Name string
+}
+
+func (s *Scalar) typeName() string { return s.Name }
+
+// The built-in scalars, and Time for RFC 3339 timestamps.
+var (
+	String  = &Scalar{Name: "String"}
+	Int     = &Scalar{Name: "Int"}
+	Float   = &Scalar{Name: "Float"}
+	Boolean = &Scalar{Name: "Boolean"}
+	ID      = &Scalar{Name: "ID"}
+	Time    = &Scalar{Name: "Time"}
+)
+
+// List is a list of values of type Of.
+type List struct {
+	Of Type
+}
+
+func (l *List) typeName() string { return "[" + l.Of.typeName() + "]" }
+
+// Object is a type with fields, which requests select.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+func (o *Object) typeName() string { return o.Name }
+
+// ResolveFunc returns the value of a field of source.
+type ResolveFunc func(ctx context.Context, source any, args Args) (any, error)
+
+// Field is a field of an Object. Fields without Resolve take the value of
+// the struct field of source named like the field in its JSON encoding, or
+// of the key of a map, so that the types of the REST API can be served as
+// they are.
+type Field struct {
+	Type    Type
+	Resolve ResolveFunc
+}
+
+// Schema is the entry points of requests.
+type Schema struct {
+	Query *Object
+	// Mutation may be nil if there are no mutations.
+	Mutation *Object
+	// FormatError turns the error of a resolver into the code and message
+	// reported to the client. Nil reports err.Error() without a code.
+	FormatError func(ctx context.Context, err error) (code, message string)
+}
+
+// Request is the body of a GraphQL request.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is nil if the request could
+// not be executed at all.
+type Response struct {
+	Data   *Map    `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Error is an error of a request or of one of its fields.
+type Error struct {
+	Message    string          `json:"message"`
+	Path       []any           `json:"path,omitempty"`
+	Extensions *ErrorExtension `json:"extensions,omitempty"`
+}
+
+// ErrorExtension carries the machine-readable code of an error.
+type ErrorExtension struct {
+	Code string `json:"code"`
+}
+
+// Map is an object of a result, which keeps the order of its fields as
+// the specification requires.
+type Map struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *Map) set(key string, v any) {
+	if m.values == nil {
+		m.values = make(map[string]any)
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = v
+}
+
+// Get returns the value of key.
+func (m *Map) Get(key string) any {
+	return m.values[key]
+}
+
+func (m *Map) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Args are the arguments of a field, with variables substituted. Values
+// are those JSON decodes to; the accessors return the zero value for
+// missing or null arguments.
+type Args map[string]any
+
+// Has reports whether the argument name was given and is not null.
+func (a Args) Has(name string) bool {
+	return a[name] != nil
+}
+
+// String returns the string argument name.
+func (a Args) String(name string) (string, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("%w: %s must be a string", ErrInvalidArgument, name)
+}
+
+// Int returns the integer argument name.
+func (a Args) Int(name string) (int64, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return v, nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s must be an integer", ErrInvalidArgument, name)
+}
+
+// Bool returns the boolean argument name.
+func (a Args) Bool(name string) (bool, error) {
+	switch v := a[name].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	}
+	return false, fmt.Errorf("%w: %s must be a boolean", ErrInvalidArgument, name)
+}
+
+// Time returns the argument name, an RFC 3339 timestamp, or the zero time.
+func (a Args) Time(name string) (time.Time, error) {
+	s, err := a.String(name)
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 timestamp", ErrInvalidArgument, name)
+	}
+	return t, nil
+}
+
+// Decode decodes the argument name, typically an input object, into v as
+// encoding/json would, so that input objects can share the request types
+// of the REST API.
+func (a Args) Decode(name string, v any) error {
+	data, err := json.Marshal(a[name])
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArgument, name, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArgument, name, err)
+	}
+	return nil
+}
+
+// Execute runs the operation of req. Errors of fields are reported in the
+// response next to the data of the other fields; errors of the request
+// leave Data nil.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return requestError(err)
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return requestError(err)
+	}
+	root := s.Query
+	if op.kind == "mutation" {
+		root = s.Mutation
+	}
+	if root == nil {
+		return requestError(fmt.Errorf("%ss are not supported", op.kind))
+	}
+	vars, err := op.variableValues(req.Variables)
+	if err != nil {
+		return requestError(err)
+	}
+
+	e := &executor{schema: s, doc: doc, vars: vars}
+	data, err := e.selectionSet(ctx, root, nil, op.selections, nil, 1)
+	if err != nil {
+		return requestError(err)
+	}
+	return &Response{Data: data, Errors: e.errors}
+}
+
+func requestError(err error) *Response {
+	return &Response{Errors: []Error{{Message: err.Error()}}}
+}
+
+// operation returns the operation called name, or the only one if name is
+// empty.
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, errors.New("operationName is required for documents with several operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// variableValues returns the variables of op from values and the defaults.
+func (op *operation) variableValues(values map[string]any) (map[string]any, error) {
+	vars := make(map[string]any, len(op.variables))
+	for _, def := range op.variables {
+		v, ok := values[def.name]
+		if !ok {
+			v = def.defaultVal
+		}
+		if v == nil && def.required {
+			return nil, fmt.Errorf("variable $%s is required", def.name)
+		}
+		vars[def.name] = v
+	}
+	return vars, nil
+}
+
+// executor runs the selections of an operation, collecting field errors.
+type executor struct {
+	schema   *Schema
+	doc      *document
+	vars     map[string]any
+	errors   []Error
+	resolves int
+}
+
+// selectionSet resolves sels on source, a value of obj. Errors of fields
+// are recorded and leave the field null; the returned error aborts the
+// request.
+func (e *executor) selectionSet(ctx context.Context, obj *Object, source any, sels []selection, path []any, depth int) (*Map, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("selections are nested more than %d levels deep", maxDepth)
+	}
+	fields, err := e.collectFields(obj, sels, nil, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Map{}
+	for _, key := range fields.keys {
+		group := fields.values[key].([]*field)
+		f := group[0]
+		fieldPath := append(path[:len(path):len(path)], key)
+		if f.name == "__typename" {
+			result.set(key, obj.Name)
+			continue
+		}
+		def, ok := obj.Fields[f.name]
+		if !ok {
+			return nil, fmt.Errorf("cannot query field %q on type %s", f.name, obj.Name)
+		}
+		var subs []selection
+		for _, g := range group {
+			subs = append(subs, g.selections...)
+		}
+		if err := checkSelections(f.name, def.Type, subs); err != nil {
+			return nil, err
+		}
+		args, err := e.args(f.args)
+		if err != nil {
+			return nil, err
+		}
+
+		var v any
+		if def.Resolve != nil {
+			if e.resolves++; e.resolves > maxResolves {
+				return nil, fmt.Errorf("request runs more than %d resolvers", maxResolves)
+			}
+			v, err = def.Resolve(ctx, source, args)
+		} else {
+			v, err = property(source, f.name)
+		}
+		if err != nil {
+			e.fieldError(ctx, fieldPath, err)
+			result.set(key, nil)
+			continue
+		}
+
+		v, err = e.complete(ctx, def.Type, v, subs, fieldPath, depth)
+		if err != nil {
+			return nil, err
+		}
+		result.set(key, v)
+	}
+	return result, nil
+}
+
+// checkSelections reports whether sels suit a field of type t: objects
+// need selections and scalars cannot have any.
+func checkSelections(name string, t Type, sels []selection) error {
+	leaf := t
+	for {
+		list, ok := leaf.(*List)
+		if !ok {
+			break
+		}
+		leaf = list.Of
+	}
+	switch leaf.(type) {
+	case *Scalar:
+		if len(sels) > 0 {
+			return fmt.Errorf("field %s of type %s cannot have selections", name, t.typeName())
+		}
+	case *Object:
+		if len(sels) == 0 {
+			return fmt.Errorf("field %s of type %s must have selections", name, t.typeName())
+		}
+	}
+	return nil
+}
+
+// collectFields groups the fields of sels, expanding fragments, by their
+// response key in the order they are first selected.
+func (e *executor) collectFields(obj *Object, sels []selection, fields *Map, visited map[string]bool) (*Map, error) {
+	if fields == nil {
+		fields = &Map{}
+	}
+	for _, sel := range sels {
+		var frag *fragment
+		switch {
+		case sel.field != nil:
+			key := sel.field.responseKey()
+			group, _ := fields.Get(key).([]*field)
+			fields.set(key, append(group, sel.field))
+			continue
+		case sel.inline != nil:
+			frag = sel.inline
+		default:
+			if visited[sel.spread] {
+				continue
+			}
+			visited[sel.spread] = true
+			var ok bool
+			if frag, ok = e.doc.fragments[sel.spread]; !ok {
+				return nil, fmt.Errorf("unknown fragment %q", sel.spread)
+			}
+		}
+		if frag.on != "" && frag.on != obj.Name {
+			continue
+		}
+		if _, err := e.collectFields(obj, frag.selections, fields, visited); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// args returns the arguments of a field with variables substituted.
+func (e *executor) args(list []argument) (Args, error) {
+	args := make(Args, len(list))
+	for _, arg := range list {
+		v, err := e.substitute(arg.value)
+		if err != nil {
+			return nil, err
+		}
+		args[arg.name] = v
+	}
+	return args, nil
+}
+
+func (e *executor) substitute(v any) (any, error) {
+	switch v := v.(type) {
+	case variable:
+		value, ok := e.vars[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", v)
+		}
+		return value, nil
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = e.substitute(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]any:
+		obj := make(map[string]any, len(v))
+		for k, item := range v {
+			var err error
+			if obj[k], err = e.substitute(item); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	}
+	return v, nil
+}
+
+// complete turns v, the value of a field of type t, into its result.
+func (e *executor) complete(ctx context.Context, t Type, v any, sels []selection, path []any, depth int) (any, error) {
+	if isNull(v) {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *Scalar:
+		return v, nil
+	case *List:
+		items := reflect.ValueOf(v)
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			return nil, fmt.Errorf("field %v resolved to %T, not a list", path[len(path)-1], v)
+		}
+		list := make([]any, items.Len())
+		for i := range list {
+			var err error
+			itemPath := append(path[:len(path):len(path)], i)
+			if list[i], err = e.complete(ctx, t.Of, items.Index(i).Interface(), sels, itemPath, depth); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case *Object:
+		return e.selectionSet(ctx, t, v, sels, path, depth+1)
+	}
+	return nil, fmt.Errorf("unknown type %T", t)
+}
+
+func (e *executor) fieldError(ctx context.Context, path []any, err error) {
+	gqlErr := Error{Message: err.Error(), Path: path}
+	if e.schema.FormatError != nil {
+		code, message := e.schema.FormatError(ctx, err)
+		gqlErr.Message = message
+		if code != "" {
+			gqlErr.Extensions = &ErrorExtension{Code: code}
+		}
+	}
+	e.errors = append(e.errors, gqlErr)
+}
+
+// property returns the property name of source: the value of a map key or
+// of the struct field with that name in its JSON encoding.
+func property(source any, name string) (any, error) {
+	if m, ok := source.(map[string]any); ok {
+		return m[name], nil
+	}
+	v := reflect.ValueOf(source)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot read %s of %T", name, source)
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if key == "" {
+			key = f.Name
+		}
+		if key == name {
+			return v.Field(i).Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("%T has no property %s", source, name)
+}
+
+// isNull reports whether v is nil, including nil pointers, slices and maps
+// in an interface.
+func isNull(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testBook struct {
+	Title  string `json:"title"`
+	Pages  int    `json:"pages"`
+	Author string `json:"author,omitempty"`
+}
+
+func testSchema() *Schema {
+	book := &Object{Name: "Book", Fields: map[string]*Field{
+		"title":  {Type: String},
+		"pages":  {Type: Int},
+		"author": {Type: String},
+		"shout": {Type: String, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+			return source.(testBook).Title + "!", nil
+		}},
+	}}
+	books := []testBook{{Title: "Go", Pages: 300, Author: "Gopher"}, {Title: "SQL", Pages: 200}}
+	return &Schema{
+		Query: &Object{Name: "Query", Fields: map[string]*Field{
+			"books": {Type: &List{Of: book}, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+				limit, err := args.Int("limit")
+				if err != nil {
+					return nil, err
+				}
+				if limit > 0 && int(limit) < len(books) {
+					return books[:limit], nil
+				}
+				return books, nil
+			}},
+			"book": {Type: book, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+				title, err := args.String("title")
+				if err != nil {
+					return nil, err
+				}
+				for _, b := range books {
+					if b.Title == title {
+						return b, nil
+					}
+				}
+				return nil, errors.New("no such book")
+			}},
+			"missing": {Type: book, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+				var b *testBook
+				return b, nil
+			}},
+		}},
+		Mutation: &Object{Name: "Mutation", Fields: map[string]*Field{
+			"addBook": {Type: book, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+				var b testBook
+				if err := args.Decode("input", &b); err != nil {
+					return nil, err
+				}
+				return b, nil
+			}},
+		}},
+		FormatError: func(ctx context.Context, err error) (string, string) {
+			if errors.Is(err, ErrInvalidArgument) {
+				return "invalid_request", err.Error()
+			}
+			return "not_found", err.Error()
+		},
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{
+			name: "anonymous query",
+			req:  Request{Query: `{ books { title pages } }`},
+			want: `{"data":{"books":[{"title":"Go","pages":300},{"title":"SQL","pages":200}]}}`,
+		},
+		{
+			name: "arguments aliases and resolvers",
+			req:  Request{Query: `query { first: books(limit: 1) { name: title shout } go: book(title: "Go") { author } }`},
+			want: `{"data":{"first":[{"name":"Go","shout":"Go!"}],"go":{"author":"Gopher"}}}`,
+		},
+		{
+			name: "variables and defaults",
+			req: Request{
+				Query:     `query Find($title: String!, $limit: Int = 1) { book(title: $title) { pages } books(limit: $limit) { title } }`,
+				Variables: map[string]any{"title": "SQL"},
+			},
+			want: `{"data":{"book":{"pages":200},"books":[{"title":"Go"}]}}`,
+		},
+		{
+			name: "fragments and typename",
+			req: Request{Query: `{ book(title: "Go") { ...Info ... on Book { pages } ... on Author { name } __typename } }
+				fragment Info on Book { title title }`},
+			want: `{"data":{"book":{"title":"Go","pages":300,"__typename":"Book"}}}`,
+		},
+		{
+			name: "mutation with input object",
+			req:  Request{Query: `mutation { addBook(input: {title: "New", pages: 10}) { title pages } }`},
+			want: `{"data":{"addBook":{"title":"New","pages":10}}}`,
+		},
+		{
+			name: "null object",
+			req:  Request{Query: `{ missing { title } }`},
+			want: `{"data":{"missing":null}}`,
+		},
+		{
+			name: "field error",
+			req:  Request{Query: `{ books(limit: 1) { title } book(title: "Rust") { title } }`},
+			want: `{"data":{"books":[{"title":"Go"}],"book":null},"errors":[{"message":"no such book","path":["book"],"extensions":{"code":"not_found"}}]}`,
+		},
+		{
+			name: "invalid argument",
+			req:  Request{Query: `{ books(limit: "one") { title } }`},
+			want: `{"data":{"books":null},"errors":[{"message":"invalid argument: limit must be an integer","path":["books"],"extensions":{"code":"invalid_request"}}]}`,
+		},
+		{
+			name: "unknown input field",
+			req:  Request{Query: `mutation { addBook(input: {titel: "New"}) { title } }`},
+			want: `{"data":{"addBook":null},"errors":[{"message":"invalid argument: input: json: unknown field \"titel\"","path":["addBook"],"extensions":{"code":"invalid_request"}}]}`,
+		},
+		{
+			name: "syntax error",
+			req:  Request{Query: `{ books { title }`},
+			want: `{"errors":[{"message":"syntax error at 1:18: unexpected end of document"}]}`,
+		},
+		{
+			name: "unknown field",
+			req:  Request{Query: `{ books { isbn } }`},
+			want: `{"errors":[{"message":"cannot query field \"isbn\" on type Book"}]}`,
+		},
+		{
+			name: "scalar with selections",
+			req:  Request{Query: `{ books { title { length } } }`},
+			want: `{"errors":[{"message":"field title of type String cannot have selections"}]}`,
+		},
+		{
+			name: "object without selections",
+			req:  Request{Query: `{ books }`},
+			want: `{"errors":[{"message":"field books of type [Book] must have selections"}]}`,
+		},
+		{
+			name: "missing required variable",
+			req:  Request{Query: `query ($title: String!) { book(title: $title) { title } }`},
+			want: `{"errors":[{"message":"variable $title is required"}]}`,
+		},
+		{
+			name: "undefined variable",
+			req:  Request{Query: `{ book(title: $title) { title } }`},
+			want: `{"errors":[{"message":"variable $title is not defined"}]}`,
+		},
+		{
+			name: "operation name required",
+			req:  Request{Query: `query A { books { title } } query B { books { pages } }`},
+			want: `{"errors":[{"message":"operationName is required for documents with several operations"}]}`,
+		},
+		{
+			name: "operation by name",
+			req:  Request{Query: `query A { books(limit: 1) { title } } query B { books(limit: 1) { pages } }`, OperationName: "B"},
+			want: `{"data":{"books":[{"pages":300}]}}`,
+		},
+		{
+			name: "subscription",
+			req:  Request{Query: `subscription { books { title } }`},
+			want: `{"errors":[{"message":"syntax error at 1:1: subscriptions are not supported"}]}`,
+		},
+		{
+			name: "too many resolvers",
+			req:  Request{Query: `{ ` + repeat(`b%d: books { shout } `, 70) + `}`},
+			want: `{"errors":[{"message":"request runs more than 200 resolvers"}]}`,
+		},
+	}
+
+	schema := testSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := schema.Execute(context.Background(), tt.req)
+			got, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatalf("failed to encode response: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("response = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_Depth(t *testing.T) {
+	node := &Object{Name: "Node"}
+	node.Fields = map[string]*Field{
+		"id": {Type: ID},
+		"next": {Type: node, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+			return map[string]any{"id": "n"}, nil
+		}},
+	}
+	schema := &Schema{Query: &Object{Name: "Query", Fields: map[string]*Field{
+		"node": {Type: node, Resolve: func(ctx context.Context, source any, args Args) (any, error) {
+			return map[string]any{"id": "n"}, nil
+		}},
+	}}}
+
+	tests := []struct {
+		name    string
+		depth   int
+		wantErr bool
+	}{
+		{name: "within limit", depth: maxDepth - 2},
+		{name: "too deep", depth: maxDepth, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := "{ node { "
+			for i := 0; i < tt.depth; i++ {
+				query += "next { "
+			}
+			query += "id"
+			for i := 0; i < tt.depth+2; i++ {
+				query += " }"
+			}
+			resp := schema.Execute(context.Background(), Request{Query: query})
+			if gotErr := resp.Data == nil; gotErr != tt.wantErr {
+				t.Errorf("request failed = %v (%+v), want %v", gotErr, resp.Errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func repeat(format string, n int) string {
+	var s string
+	for i := 0; i < n; i++ {
+		s += fmt.Sprintf(format, i)
+	}
+	return s
+}
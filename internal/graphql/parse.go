@@ -0,0 +1,657 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request: its operations and the fragments they
+// spread.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// operation is a query or mutation.
+type operation struct {
+	kind       string
+	name       string
+	variables  []variableDef
+	selections []selection
+}
+
+// variableDef declares a variable of an operation. Types are not checked
+// beyond whether a value is required.
+type variableDef struct {
+	name       string
+	required   bool
+	defaultVal any
+}
+
+type fragment struct {
+	name, on   string
+	selections []selection
+}
+
+// selection is one of a field, a fragment spread or an inline fragment.
+type selection struct {
+	field  *field
+	spread string
+	inline *fragment
+}
+
+type field struct {
+	alias, name string
+	args        []argument
+	selections  []selection
+}
+
+// responseKey is the name of the field in the result.
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type argument struct {
+	name  string
+	value any
+}
+
+// variable is a reference to a variable in an argument value. Other values
+// are parsed to what they decode to from JSON: string, int64, float64,
+// bool, nil, []any and map[string]any; enum values are strings.
+type variable string
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lexer splits a document into tokens, skipping whitespace, commas and
+// comments.
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return token{kind: tokEOF, pos: l.pos}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokPunct, text: "...", pos: start}, nil
+	case strings.IndexByte("!$()&:=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, text: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, text: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString()
+		}
+		return l.string()
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, l.errorf(start, "unexpected character %q", r)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return token{}, l.errorf(start, "invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		if digits() == 0 {
+			return token{}, l.errorf(start, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, l.errorf(start, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == '_' || l.src[l.pos] == '.' || isLetter(l.src[l.pos])) {
+		return token{}, l.errorf(start, "invalid number")
+	}
+	return token{kind: kind, text: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokString, text: b.String(), pos: start}, nil
+		case c == '\n' || c == '\r':
+			return token{}, l.errorf(start, "unterminated string")
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, l.errorf(start, "unterminated string")
+			}
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, l.errorf(start, "invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(start, "invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				l.pos += 4
+			default:
+				return token{}, l.errorf(start, "invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, l.errorf(start, "unterminated string")
+}
+
+// blockString reads a """block string""", removing the indentation common
+// to its lines and leading and trailing blank lines.
+func (l *lexer) blockString() (token, error) {
+	start := l.pos
+	l.pos += 3
+	end := l.pos
+	for {
+		i := strings.Index(l.src[end:], `"""`)
+		if i < 0 {
+			return token{}, l.errorf(start, "unterminated string")
+		}
+		end += i
+		if l.src[end-1] != '\\' {
+			break
+		}
+		end += 3
+	}
+	raw := strings.ReplaceAll(l.src[l.pos:end], `\"""`, `"""`)
+	l.pos = end + 3
+
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return token{kind: tokString, text: strings.Join(lines, "\n"), pos: start}, nil
+}
+
+// errorf returns a syntax error at offset pos, located by line and column.
+func (l *lexer) errorf(pos int, format string, args ...any) error {
+	line := 1 + strings.Count(l.src[:pos], "\n")
+	col := pos - strings.LastIndex(l.src[:pos], "\n")
+	return fmt.Errorf("syntax error at %d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// parser builds a document from the tokens of a lexer, one token ahead.
+type parser struct {
+	lex lexer
+	tok token
+}
+
+// parse parses src into a document.
+func parse(src string) (*document, error) {
+	p := &parser{lex: lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: sels})
+		case p.peek(tokName, "query"), p.peek(tokName, "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokName, "subscription"):
+			return nil, p.errorf("subscriptions are not supported")
+		case p.peek(tokName, "fragment"):
+			f, err := p.fragmentDefinition()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined more than once", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.errorf("unexpected %q", p.tok.text)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is of kind and, unless text is
+// empty, text.
+func (p *parser) peek(kind tokenKind, text string) bool {
+	return p.tok.kind == kind && (text == "" || p.tok.text == text)
+}
+
+// skip consumes the current token if peek(kind, text), reporting whether
+// it did.
+func (p *parser) skip(kind tokenKind, text string) (bool, error) {
+	if !p.peek(kind, text) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expect consumes the current token, which must be of kind and text (if
+// not empty), and returns its text.
+func (p *parser) expect(kind tokenKind, text string) (string, error) {
+	if !p.peek(kind, text) {
+		if p.tok.kind == tokEOF {
+			return "", p.errorf("unexpected end of document")
+		}
+		return "", p.errorf("unexpected %q", p.tok.text)
+	}
+	got := p.tok.text
+	return got, p.advance()
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return p.lex.errorf(p.tok.pos, format, args...)
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.text}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokName, "") {
+		op.name = p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip(tokPunct, "("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(tokPunct, ")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.noDirectives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (variableDef, error) {
+	var def variableDef
+	if _, err := p.expect(tokPunct, "$"); err != nil {
+		return def, err
+	}
+	name, err := p.expect(tokName, "")
+	if err != nil {
+		return def, err
+	}
+	def.name = name
+	if _, err := p.expect(tokPunct, ":"); err != nil {
+		return def, err
+	}
+	if def.required, err = p.typeRef(); err != nil {
+		return def, err
+	}
+	if ok, err := p.skip(tokPunct, "="); err != nil {
+		return def, err
+	} else if ok {
+		if def.defaultVal, err = p.value(true); err != nil {
+			return def, err
+		}
+	}
+	return def, p.noDirectives()
+}
+
+// typeRef skips a type such as [String!]!, reporting whether it is non-null.
+func (p *parser) typeRef() (bool, error) {
+	if ok, err := p.skip(tokPunct, "["); err != nil {
+		return false, err
+	} else if ok {
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if _, err := p.expect(tokPunct, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.expect(tokName, ""); err != nil {
+		return false, err
+	}
+	return p.skip(tokPunct, "!")
+}
+
+func (p *parser) fragmentDefinition() (*fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokName, "on") {
+		return nil, p.errorf("fragment must have a name")
+	}
+	name, err := p.expect(tokName, "")
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.fragmentBody()
+	if err != nil {
+		return nil, err
+	}
+	if f.on == "" {
+		return nil, p.errorf("fragment %q must have a type condition", name)
+	}
+	f.name = name
+	return f, nil
+}
+
+// fragmentBody parses the optional type condition and the selections of a
+// fragment.
+func (p *parser) fragmentBody() (*fragment, error) {
+	f := &fragment{}
+	if ok, err := p.skip(tokName, "on"); err != nil {
+		return nil, err
+	} else if ok {
+		if f.on, err = p.expect(tokName, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.noDirectives(); err != nil {
+		return nil, err
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	f.selections = sels
+	return f, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if _, err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+	var sels []selection
+	for !p.peek(tokPunct, "}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf("selection set must not be empty")
+	}
+	return sels, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	if ok, err := p.skip(tokPunct, "..."); err != nil {
+		return selection{}, err
+	} else if ok {
+		if p.peek(tokName, "") && p.tok.text != "on" {
+			name := p.tok.text
+			if err := p.advance(); err != nil {
+				return selection{}, err
+			}
+			return selection{spread: name}, p.noDirectives()
+		}
+		f, err := p.fragmentBody()
+		return selection{inline: f}, err
+	}
+
+	name, err := p.expect(tokName, "")
+	if err != nil {
+		return selection{}, err
+	}
+	f := &field{name: name}
+	if ok, err := p.skip(tokPunct, ":"); err != nil {
+		return selection{}, err
+	} else if ok {
+		f.alias = name
+		if f.name, err = p.expect(tokName, ""); err != nil {
+			return selection{}, err
+		}
+	}
+	if ok, err := p.skip(tokPunct, "("); err != nil {
+		return selection{}, err
+	} else if ok {
+		for !p.peek(tokPunct, ")") {
+			argName, err := p.expect(tokName, "")
+			if err != nil {
+				return selection{}, err
+			}
+			if _, err := p.expect(tokPunct, ":"); err != nil {
+				return selection{}, err
+			}
+			v, err := p.value(false)
+			if err != nil {
+				return selection{}, err
+			}
+			f.args = append(f.args, argument{name: argName, value: v})
+		}
+		if err := p.advance(); err != nil {
+			return selection{}, err
+		}
+	}
+	if err := p.noDirectives(); err != nil {
+		return selection{}, err
+	}
+	if p.peek(tokPunct, "{") {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return selection{}, err
+		}
+	}
+	return selection{field: f}, nil
+}
+
+// noDirectives rejects directives such as @include, which are not
+// supported.
+func (p *parser) noDirectives() error {
+	if p.peek(tokPunct, "@") {
+		return p.errorf("directives are not supported")
+	}
+	return nil
+}
+
+// value parses an argument value; constant values may not refer to
+// variables.
+func (p *parser) value(constant bool) (any, error) {
+	tok := p.tok
+	switch {
+	case p.peek(tokPunct, "$"):
+		if constant {
+			return nil, p.errorf("variables are not allowed here")
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expect(tokName, "")
+		return variable(name), err
+	case p.peek(tokPunct, "["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for !p.peek(tokPunct, "]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.advance()
+	case p.peek(tokPunct, "{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		obj := map[string]any{}
+		for !p.peek(tokPunct, "}") {
+			name, err := p.expect(tokName, "")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokPunct, ":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.advance()
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, p.lex.errorf(tok.pos, "integer %s out of range", tok.text)
+		}
+		return n, nil
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.lex.errorf(tok.pos, "invalid number %s", tok.text)
+		}
+		return f, nil
+	case tokString:
+		return tok.text, nil
+	case tokName:
+		switch tok.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return tok.text, nil
+	case tokEOF:
+		return nil, p.lex.errorf(tok.pos, "unexpected end of document")
+	}
+	return nil, p.lex.errorf(tok.pos, "unexpected %q", tok.text)
+}
@@ -0,0 +1,44 @@
+// Package httpx holds helpers for reading HTTP requests that handlers and
+// middleware share, so that each does not parse requests its own way.
+package httpx
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseAddr parses an IP address as found in RemoteAddr and forwarding
+// headers: with or without a port, optionally in brackets, with or without
+// an IPv6 zone. IPv4-mapped IPv6 addresses are unmapped, so that a client
+// is known by one address whichever way it connected. It returns an
+// invalid Addr if s is not an IP address.
+func ParseAddr(s string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().WithZone("").Unmap()
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.WithZone("").Unmap()
+}
+
+// ClientAddr returns the address of the client of r, or an invalid Addr if
+// its RemoteAddr is not an IP address. Behind trusted proxies,
+// realip.Middleware puts the address from the forwarding headers there
+// first.
+func ClientAddr(r *http.Request) netip.Addr {
+	return ParseAddr(r.RemoteAddr)
+}
+
+// ClientIP returns ClientAddr as a string for rate limiting, analytics and
+// logs. A RemoteAddr that is not an IP address, as with Unix sockets, is
+// returned as is so that its clients still share a key.
+func ClientIP(r *http.Request) string {
+	if addr := ClientAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
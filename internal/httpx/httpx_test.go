@@ -0,0 +1,38 @@
+package httpx
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+		wantAddr   bool
+	}{
+		{remoteAddr: "192.0.2.1:1234", want: "192.0.2.1", wantAddr: true},
+		{remoteAddr: "192.0.2.1", want: "192.0.2.1", wantAddr: true},
+		{remoteAddr: "[2001:db8::1]:443", want: "2001:db8::1", wantAddr: true},
+		{remoteAddr: "[2001:db8::1]", want: "2001:db8::1", wantAddr: true},
+		{remoteAddr: "2001:db8::1", want: "2001:db8::1", wantAddr: true},
+		{remoteAddr: "[::ffff:192.0.2.1]:1234", want: "192.0.2.1", wantAddr: true},
+		{remoteAddr: "[fe80::1%eth0]:443", want: "fe80::1", wantAddr: true},
+		{remoteAddr: "fe80::1%eth0", want: "fe80::1", wantAddr: true},
+		{remoteAddr: "@", want: "@"},
+		{remoteAddr: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if got := ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+			if addr := ClientAddr(req); addr.IsValid() != tt.wantAddr {
+				t.Errorf("ClientAddr() = %v, want valid %v", addr, tt.wantAddr)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package idempotency replays the stored response of a request whose
+// Idempotency-Key header was seen before, so that clients can safely retry
+// requests that create things (e.g. after a mobile network dropped the
+// response, or a queue redelivered a message).
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Header is the request header carrying the key of a request.
+	Header = "Idempotency-Key"
+	// ReplayHeader is set to "true" on replayed responses.
+	ReplayHeader = "Idempotent-Replay"
+
+	// maxKeyLength bounds the keys clients may choose.
+	maxKeyLength = 255
+	// lockTTL is how long a key stays reserved by a request that never
+	// completes, e.g. because its instance crashed.
+	lockTTL = time.Minute
+)
+
+// record is what is stored under a key: the fingerprint of the request and,
+// once it completed, its response. Status is zero while the request is in
+// progress.
+type record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Store keeps the responses of requests with an idempotency key in Redis,
+// so that a retry reaching another replica of the service is replayed as
+// well.
+type Store struct {
+	client  *redis.Client
+	ttl     time.Duration
+	maxBody int64
+}
+
+// New returns a Store remembering responses for ttl. Requests with bodies
+// over maxBody bytes are rejected.
+func New(client *redis.Client, ttl time.Duration, maxBody int64) *Store {
+	return &Store{client: client, ttl: ttl, maxBody: maxBody}
+}
+
+// fingerprint identifies a request, so that a key reused for a different
+// request is told apart from a retry.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reserve stores an in-progress record for key, or returns the record
+// already stored.
+func (s *Store) reserve(ctx context.Context, key, fp string) (*record, error) {
+	pending, err := json.Marshal(record{Fingerprint: fp})
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.client.SetNX(ctx, key, pending, lockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if ok {
+		return nil, nil
+	}
+	data, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The other request failed and released the key in the meantime
+		return s.reserve(ctx, key, fp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	return &rec, nil
+}
+
+// Middleware runs requests with an Idempotency-Key header once per key and
+// scope(r), e.g. the user or client IP, and answers retries with the stored
+// response and an Idempotent-Replay header. A key reused for a different
+// request gets 422, and a retry while the first request is still in
+// progress 409. Server errors are not stored, so that they can be retried.
+//
+// If Redis fails the request runs without the guarantee: availability of
+// the shortener matters more than deduplication.
+func (s *Store) Middleware(scope func(*http.Request) string) func(http.Handler) http.Handler {
+	logger := slog.Default().With("component", "idempotency")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("%s must be at most %d characters", Header, maxKeyLength))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+			if err != nil {
+				apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.BodyTooLarge, "Request body too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			redisKey := "idempotency:" + scope(r) + ":" + key
+			fp := fingerprint(r, body)
+			rec, err := s.reserve(r.Context(), redisKey, fp)
+			if err != nil {
+				logger.WarnContext(r.Context(), "idempotency check failed, running request", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			switch {
+			case rec == nil:
+			case rec.Fingerprint != fp:
+				apierror.Write(w, http.StatusUnprocessableEntity, apierror.IdempotencyKeyReused, Header+" was already used for a different request")
+				return
+			case rec.Status == 0:
+				apierror.Write(w, http.StatusConflict, apierror.IdempotencyKeyInProgress, "A request with this "+Header+" is still in progress")
+				return
+			default:
+				for name, values := range rec.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(ReplayHeader, "true")
+				w.WriteHeader(rec.Status)
+				if _, err := w.Write(rec.Body); err != nil {
+					logger.WarnContext(r.Context(), "failed to write replayed response", "error", err)
+				}
+				return
+			}
+
+			// Headers set before, e.g. the request ID, belong to this request
+			// rather than to the response to replay
+			before := w.Header().Clone()
+			rw := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			for name, values := range before {
+				if slices.Equal(rw.header[name], values) {
+					delete(rw.header, name)
+				}
+			}
+
+			// Storing must not depend on the client still waiting for the
+			// response it is going to retry
+			ctx := context.WithoutCancel(r.Context())
+			if rw.status >= http.StatusInternalServerError {
+				if err := s.client.Del(ctx, redisKey).Err(); err != nil {
+					logger.WarnContext(ctx, "failed to release idempotency key", "error", err)
+				}
+				return
+			}
+			data, err := json.Marshal(record{Fingerprint: fp, Status: rw.status, Header: rw.header, Body: rw.body.Bytes()})
+			if err == nil {
+				err = s.client.Set(ctx, redisKey, data, s.ttl).Err()
+			}
+			if err != nil {
+				logger.WarnContext(ctx, "failed to store idempotent response", "error", err)
+			}
+		})
+	}
+}
+
+// recorder captures the response it passes through.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,115 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMiddleware(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	var calls atomic.Int64
+	status := http.StatusCreated
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+	scope := func(r *http.Request) string { return r.Header.Get("X-User") }
+	mw := New(client, time.Hour, 64).Middleware(scope)(handler)
+
+	do := func(user, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		if key != "" {
+			req.Header.Set(Header, key)
+		}
+		rec := httptest.NewRecorder()
+		rec.Header().Set("X-Request-ID", "request-of-"+key)
+		mw.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := do("alice", "k1", `{"url":"https://example.com"}`)
+	if first.Code != http.StatusCreated || first.Body.String() != `{"call":1}` || first.Header().Get(ReplayHeader) != "" {
+		t.Fatalf("first request = %d %q, want the handler's response", first.Code, first.Body)
+	}
+
+	tests := []struct {
+		name       string
+		user, key  string
+		body       string
+		wantCode   int
+		wantBody   string
+		wantReplay bool
+	}{
+		{"retry", "alice", "k1", `{"url":"https://example.com"}`, http.StatusCreated, `{"call":1}`, true},
+		{"other body", "alice", "k1", `{"url":"https://example.org"}`, http.StatusUnprocessableEntity, "", false},
+		{"other user", "bob", "k1", `{"url":"https://example.com"}`, http.StatusCreated, `{"call":2}`, false},
+		{"without key", "alice", "", `{"url":"https://example.com"}`, http.StatusCreated, `{"call":3}`, false},
+		{"long key", "alice", strings.Repeat("k", 256), `{}`, http.StatusBadRequest, "", false},
+		{"large body", "alice", "k2", strings.Repeat("x", 65), http.StatusRequestEntityTooLarge, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.user, tt.key, tt.body)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if got := rec.Header().Get(ReplayHeader) == "true"; got != tt.wantReplay {
+				t.Errorf("%s = %q, want replay %v", ReplayHeader, rec.Header().Get(ReplayHeader), tt.wantReplay)
+			}
+			if tt.wantReplay {
+				if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("X-Request-ID") != "request-of-"+tt.key {
+					t.Errorf("replayed headers = %v, want the stored content type and this request's ID", rec.Header())
+				}
+			}
+		})
+	}
+
+	t.Run("in progress", func(t *testing.T) {
+		mr.Set("idempotency:alice:k3", `{"fingerprint":"`+fingerprint(httptest.NewRequest(http.MethodPost, "/api/shorten", nil), []byte("{}"))+`"}`)
+		rec := do("alice", "k3", "{}")
+		if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"code":"`+apierror.IdempotencyKeyInProgress+`"`) {
+			t.Errorf("response = %d %q, want 409 %s", rec.Code, rec.Body, apierror.IdempotencyKeyInProgress)
+		}
+	})
+
+	t.Run("server errors are not stored", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		if rec := do("alice", "k4", "{}"); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want 500", rec.Code)
+		}
+		status = http.StatusCreated
+		if rec := do("alice", "k4", "{}"); rec.Code != http.StatusCreated || rec.Header().Get(ReplayHeader) != "" {
+			t.Errorf("retry after a server error = %d, replay %q, want a new response", rec.Code, rec.Header().Get(ReplayHeader))
+		}
+	})
+
+	if ttl := mr.TTL("idempotency:alice:k1"); ttl != time.Hour {
+		t.Errorf("TTL = %v, want 1h", ttl)
+	}
+
+	t.Run("redis down", func(t *testing.T) {
+		mr.Close()
+		before := calls.Load()
+		if rec := do("alice", "k1", `{"url":"https://example.com"}`); rec.Code != http.StatusCreated || calls.Load() != before+1 {
+			t.Errorf("status = %d, want the request to run without Redis", rec.Code)
+		}
+	})
+}
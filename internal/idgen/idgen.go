@@ -0,0 +1,108 @@
+// Package idgen generates Snowflake-style IDs: 63-bit integers made of a
+// millisecond timestamp, the ID of the generating worker and a per-worker
+// sequence. Instances with distinct worker IDs never produce the same ID, so
+// they can assign IDs without coordinating through the database.
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	workerBits   = 10
+	sequenceBits = 12
+	// timestampBits keeps IDs below 2^63 so they fit a signed BIGINT; 41
+	// bits of milliseconds last about 69 years from Epoch.
+	timestampBits = 63 - workerBits - sequenceBits
+
+	// MaxWorkerID is the largest worker ID, giving 1024 workers.
+	MaxWorkerID = 1<<workerBits - 1
+	maxSequence = 1<<sequenceBits - 1
+
+	// maxClockSkew is how far the clock may step back before Next fails
+	// instead of waiting for it to catch up.
+	maxClockSkew = 10 * time.Millisecond
+)
+
+// Epoch is the zero time of ID timestamps. It must never change once IDs
+// have been issued.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	// ErrClockMovedBackwards is returned when the system clock steps back by
+	// more than maxClockSkew, which could otherwise repeat earlier IDs.
+	ErrClockMovedBackwards = errors.New("clock moved backwards")
+	// ErrEpochExhausted is returned once the timestamp outgrows its bits.
+	ErrEpochExhausted = errors.New("id timestamp space exhausted")
+)
+
+// Generator issues increasing IDs for one worker. It is safe for concurrent
+// use; up to 4096 IDs are issued per millisecond.
+type Generator struct {
+	worker uint64
+
+	mu       sync.Mutex
+	lastMs   int64
+	sequence uint64
+
+	// now and sleep are stubbed in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// New returns a generator for workerID, which must be unique among all
+// instances issuing IDs into the same namespace.
+func New(workerID int) (*Generator, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, fmt.Errorf("worker id must be between 0 and %d", MaxWorkerID)
+	}
+	return &Generator{worker: uint64(workerID), lastMs: -1, now: time.Now, sleep: time.Sleep}, nil
+}
+
+// Next returns a new ID. IDs of one generator increase strictly.
+func (g *Generator) Next() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(Epoch).Milliseconds()
+	if ms < g.lastMs {
+		skew := time.Duration(g.lastMs-ms) * time.Millisecond
+		if skew > maxClockSkew {
+			return 0, fmt.Errorf("%w by %v", ErrClockMovedBackwards, skew)
+		}
+		g.sleep(skew)
+		ms = g.lastMs
+	}
+
+	if ms == g.lastMs {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			// Sequence exhausted for this millisecond: wait for the next
+			for ms <= g.lastMs {
+				g.sleep(time.Millisecond)
+				ms = g.now().Sub(Epoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	if ms < 0 || ms >= 1<<timestampBits {
+		return 0, ErrEpochExhausted
+	}
+	g.lastMs = ms
+
+	return uint64(ms)<<(workerBits+sequenceBits) | g.worker<<sequenceBits | g.sequence, nil
+}
+
+// Time returns the time id was issued, to the millisecond.
+func Time(id uint64) time.Time {
+	return Epoch.Add(time.Duration(id>>(workerBits+sequenceBits)) * time.Millisecond)
+}
+
+// Worker returns the ID of the worker that issued id.
+func Worker(id uint64) int {
+	return int(id >> sequenceBits & MaxWorkerID)
+}
@@ -0,0 +1,140 @@
+package idgen
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock whose sleep advances it.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) sleep(d time.Duration)   { c.t = c.t.Add(d) }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestGenerator(t *testing.T, worker int) (*Generator, *fakeClock) {
+	t.Helper()
+	g, err := New(worker)
+	if err != nil {
+		t.Fatalf("New(%d) unexpected error = %v", worker, err)
+	}
+	clock := &fakeClock{t: Epoch.Add(365 * 24 * time.Hour)}
+	g.now, g.sleep = clock.now, clock.sleep
+	return g, clock
+}
+
+func TestNew_InvalidWorker(t *testing.T) {
+	for _, worker := range []int{-1, MaxWorkerID + 1} {
+		if _, err := New(worker); err == nil {
+			t.Errorf("New(%d) error = nil, want error", worker)
+		}
+	}
+}
+
+func TestGenerator_Next(t *testing.T) {
+	g, clock := newTestGenerator(t, 42)
+
+	first, err := g.Next()
+	if err != nil {
+		t.Fatalf("Next() unexpected error = %v", err)
+	}
+	if Worker(first) != 42 {
+		t.Errorf("Worker() = %d, want 42", Worker(first))
+	}
+	if !Time(first).Equal(clock.t) {
+		t.Errorf("Time() = %v, want %v", Time(first), clock.t)
+	}
+	if first >= 1<<63 {
+		t.Errorf("Next() = %d, does not fit a signed BIGINT", first)
+	}
+
+	second, err := g.Next()
+	if err != nil || second != first+1 {
+		t.Errorf("Next() in the same millisecond = %d, %v, want %d", second, err, first+1)
+	}
+
+	clock.advance(time.Millisecond)
+	third, err := g.Next()
+	if err != nil || third <= second || !Time(third).Equal(clock.t) {
+		t.Errorf("Next() a millisecond later = %d (%v), %v, want > %d", third, Time(third), err, second)
+	}
+}
+
+func TestGenerator_SequenceExhausted(t *testing.T) {
+	g, clock := newTestGenerator(t, 1)
+	start := clock.t
+
+	var last uint64
+	for i := 0; i <= maxSequence+1; i++ {
+		id, err := g.Next()
+		if err != nil {
+			t.Fatalf("Next() unexpected error = %v", err)
+		}
+		if id <= last {
+			t.Fatalf("Next() = %d after %d, want increasing", id, last)
+		}
+		last = id
+	}
+	if !Time(last).Equal(start.Add(time.Millisecond)) {
+		t.Errorf("ID %d after the sequence was exhausted issued at %v, want the next millisecond", maxSequence+1, Time(last))
+	}
+}
+
+func TestGenerator_ClockMovedBackwards(t *testing.T) {
+	g, clock := newTestGenerator(t, 1)
+	first, err := g.Next()
+	if err != nil {
+		t.Fatalf("Next() unexpected error = %v", err)
+	}
+
+	// Small steps back are waited out.
+	clock.advance(-5 * time.Millisecond)
+	second, err := g.Next()
+	if err != nil || second <= first {
+		t.Errorf("Next() after small skew = %d, %v, want > %d", second, err, first)
+	}
+
+	clock.advance(-time.Second)
+	if _, err := g.Next(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("Next() after 1s skew error = %v, want ErrClockMovedBackwards", err)
+	}
+}
+
+func TestGenerator_Concurrent(t *testing.T) {
+	g, err := New(7)
+	if err != nil {
+		t.Fatalf("New() unexpected error = %v", err)
+	}
+
+	const goroutines, perGoroutine = 8, 2000
+	ids := make(chan uint64, goroutines*perGoroutine)
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perGoroutine {
+				id, err := g.Next()
+				if err != nil {
+					t.Errorf("Next() unexpected error = %v", err)
+					return
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[uint64]bool, goroutines*perGoroutine)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate id %d", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,35 @@
+package ipanon
+
+import "testing"
+
+func TestAnonymizer_IP(t *testing.T) {
+	truncate := New(Truncate, "")
+	tests := []struct {
+		ip, want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{"::ffff:203.0.113.77", "203.0.113.0"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"fe80::1%eth0", "fe80::"},
+		{"", ""},
+		{"not an ip", ""},
+	}
+	for _, tt := range tests {
+		if got := truncate.IP(tt.ip); got != tt.want {
+			t.Errorf("truncate IP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	hash := New(Hash, "secret")
+	a, b := hash.IP("203.0.113.77"), hash.IP("203.0.113.78")
+	if len(a) != 32 || a == b || a != hash.IP("203.0.113.77") {
+		t.Errorf("hash IP() = %q and %q, want distinct stable 32-digit hashes", a, b)
+	}
+	if other := New(Hash, "other").IP("203.0.113.77"); other == a {
+		t.Error("hash IP() does not depend on the key")
+	}
+
+	if none := New(None, ""); none != nil || none.IP("203.0.113.77") != "203.0.113.77" {
+		t.Errorf("New(None) = %v, want nil leaving addresses untouched", none)
+	}
+}
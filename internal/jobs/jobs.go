@@ -0,0 +1,399 @@
+// Package jobs runs work queued by the service, such as fetching the page of
+// a new link, on pools of background workers instead of in the request that
+// queued it.
+//
+// Jobs are kept in a Store: in memory for a single instance, or in Redis so
+// that the instances sharing it share the work and jobs survive restarts.
+// Each kind of job has a handler and workers of its own. A job whose handler
+// fails is retried with exponential backoff, and moved to the dead letters
+// of its kind once it has failed MaxAttempts times. A job whose worker dies
+// is retried once its lease expires, so handlers must tolerate running a
+// job more than once.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMaxAttempts is how many times a job is tried by default.
+	defaultMaxAttempts = 5
+	// defaultTimeout bounds a run of a job by default.
+	defaultTimeout = time.Minute
+	// leaseMargin is how long after its timeout a reserved job stays
+	// invisible to other workers.
+	leaseMargin = 30 * time.Second
+	// minBackoff and maxBackoff bound the delay before a failed job is
+	// retried, which doubles with each failure.
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+	// pollInterval is how often idle workers look for due jobs.
+	pollInterval = time.Second
+	// deadLetterLimit is how many dead jobs are kept per kind.
+	deadLetterLimit = 1000
+)
+
+// Job is a unit of queued work.
+type Job struct {
+	ID      string          `json:"id"`
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts counts the failed runs of the job.
+	Attempts   int       `json:"attempts"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	LastError  string    `json:"last_error,omitempty"`
+	// FailedAt is when the job was moved to the dead letters.
+	FailedAt *time.Time `json:"failed_at,omitempty"`
+}
+
+// Handler runs a job of a kind given its payload.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the failed job is moved to the dead letters
+// right away instead of being retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Store keeps the queued jobs of every kind.
+type Store interface {
+	// Push queues j to run at at, replacing the queued job with the same
+	// ID if there is one.
+	Push(ctx context.Context, j *Job, at time.Time) error
+	// Reserve returns the job of kind that has been due the longest at now,
+	// or nil if none is due, and makes it due again at until so that it
+	// is retried if it is not acknowledged by then.
+	Reserve(ctx context.Context, kind string, now, until time.Time) (*Job, error)
+	// Ack removes j from the queue.
+	Ack(ctx context.Context, j *Job) error
+	// Bury removes j from the queue and adds it to the dead letters of its
+	// kind, of which the latest limit are kept.
+	Bury(ctx context.Context, j *Job, limit int) error
+	// Counts returns the number of queued and dead jobs of kind.
+	Counts(ctx context.Context, kind string) (queued, dead int64, err error)
+	// Dead returns up to limit dead jobs of kind, latest first.
+	Dead(ctx context.Context, kind string, limit int) ([]Job, error)
+}
+
+// KindStatus describes the jobs of a kind. Counters cover the runs of this
+// instance since it started.
+type KindStatus struct {
+	Kind    string `json:"kind"`
+	Workers int    `json:"workers"`
+	// Queued counts the jobs waiting or running, and Dead the jobs kept in
+	// the dead letters, on every instance sharing the store.
+	Queued    int64 `json:"queued"`
+	Dead      int64 `json:"dead"`
+	Succeeded int64 `json:"succeeded"`
+	Retried   int64 `json:"retried"`
+	Buried    int64 `json:"buried"`
+}
+
+// kind is a kind of job handled by a Queue.
+type kind struct {
+	name        string
+	handler     Handler
+	workers     int
+	maxAttempts int
+	timeout     time.Duration
+	// wake nudges an idle worker when a job is queued by this instance.
+	wake chan struct{}
+
+	succeeded atomic.Int64
+	retried   atomic.Int64
+	buried    atomic.Int64
+}
+
+// Option configures a kind of job.
+type Option func(*kind)
+
+// Workers runs n jobs of the kind at a time on each instance. The default
+// is 1.
+func Workers(n int) Option {
+	return func(k *kind) { k.workers = max(n, 1) }
+}
+
+// MaxAttempts moves a job of the kind to the dead letters after it failed n
+// times. The default is 5.
+func MaxAttempts(n int) Option {
+	return func(k *kind) { k.maxAttempts = max(n, 1) }
+}
+
+// Timeout bounds each run of a job of the kind. The default is a minute.
+func Timeout(d time.Duration) Option {
+	return func(k *kind) { k.timeout = d }
+}
+
+// Queue dispatches queued jobs to the handlers of their kind. Handlers are
+// registered before Run is called.
+type Queue struct {
+	store Store
+	// now is the clock used to schedule jobs; tests replace it.
+	now func() time.Time
+
+	mu    sync.RWMutex
+	kinds map[string]*kind
+}
+
+// New returns a queue keeping its jobs in store.
+func New(store Store) *Queue {
+	return &Queue{store: store, now: time.Now, kinds: map[string]*kind{}}
+}
+
+// Handle runs the jobs of kind name with h. Registering a kind again
+// replaces its handler.
+func (q *Queue) Handle(name string, h Handler, opts ...Option) {
+	k := &kind{
+		name:        name,
+		handler:     h,
+		workers:     1,
+		maxAttempts: defaultMaxAttempts,
+		timeout:     defaultTimeout,
+		wake:        make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.kinds[name] = k
+}
+
+func (q *Queue) kind(name string) *kind {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.kinds[name]
+}
+
+// Enqueue queues a job of kind name to run as soon as a worker is free,
+// with payload encoded as JSON.
+func (q *Queue) Enqueue(ctx context.Context, name string, payload any) error {
+	k := q.kind(name)
+	if k == nil {
+		return fmt.Errorf("no handler for jobs of kind %s", name)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s job: %w", name, err)
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to generate job id: %w", err)
+	}
+	now := q.now()
+	j := &Job{ID: hex.EncodeToString(id), Kind: name, Payload: data, EnqueuedAt: now}
+	if err := q.store.Push(ctx, j, now); err != nil {
+		return fmt.Errorf("failed to queue %s job: %w", name, err)
+	}
+	select {
+	case k.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run starts the workers of every kind and blocks until ctx is cancelled
+// and the jobs in progress have returned. Jobs in progress are not
+// cancelled with ctx but run until their timeout.
+func (q *Queue) Run(ctx context.Context) {
+	q.mu.RLock()
+	kinds := make([]*kind, 0, len(q.kinds))
+	for _, k := range q.kinds {
+		kinds = append(kinds, k)
+	}
+	q.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, k := range kinds {
+		for range k.workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.loop(ctx, k)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// loop runs the due jobs of k until ctx is cancelled, waiting for new ones
+// when there are none.
+func (q *Queue) loop(ctx context.Context, k *kind) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-k.wake:
+		}
+		ran, err := q.work(ctx, k)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to reserve job", "kind", k.name, "error", err)
+		}
+		// Keep going while there is work, else wait for the next poll
+		wait := time.Duration(0)
+		if !ran {
+			wait = pollInterval
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Drain runs the due jobs of every kind one at a time until none is left,
+// and returns how many it ran. Jobs queued again for a retry are not due
+// until their backoff elapsed.
+func (q *Queue) Drain(ctx context.Context) (int, error) {
+	q.mu.RLock()
+	kinds := make([]*kind, 0, len(q.kinds))
+	for _, k := range q.kinds {
+		kinds = append(kinds, k)
+	}
+	q.mu.RUnlock()
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].name < kinds[j].name })
+
+	total := 0
+	for _, k := range kinds {
+		for {
+			ran, err := q.work(ctx, k)
+			if err != nil {
+				return total, err
+			}
+			if !ran {
+				break
+			}
+			total++
+		}
+	}
+	return total, nil
+}
+
+// work runs the job of k that has been due the longest, if any, and reports
+// whether there was one.
+func (q *Queue) work(ctx context.Context, k *kind) (bool, error) {
+	now := q.now()
+	j, err := q.store.Reserve(ctx, k.name, now, now.Add(k.timeout+leaseMargin))
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve %s job: %w", k.name, err)
+	}
+	if j == nil {
+		return false, nil
+	}
+
+	// A job being run finishes even if the workers are stopping
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), k.timeout)
+	defer cancel()
+	runErr := run(runCtx, k.handler, j.Payload)
+	if runErr == nil {
+		if err := q.store.Ack(runCtx, j); err != nil {
+			slog.WarnContext(ctx, "failed to acknowledge job", "kind", k.name, "id", j.ID, "error", err)
+		}
+		k.succeeded.Add(1)
+		return true, nil
+	}
+
+	j.Attempts++
+	j.LastError = runErr.Error()
+	var permanent *permanentError
+	if j.Attempts >= k.maxAttempts || errors.As(runErr, &permanent) {
+		failedAt := q.now()
+		j.FailedAt = &failedAt
+		if err := q.store.Bury(runCtx, j, deadLetterLimit); err != nil {
+			slog.WarnContext(ctx, "failed to bury job", "kind", k.name, "id", j.ID, "error", err)
+		}
+		k.buried.Add(1)
+		slog.ErrorContext(ctx, "job failed", "kind", k.name, "id", j.ID, "attempts", j.Attempts, "error", runErr)
+		return true, nil
+	}
+	if err := q.store.Push(runCtx, j, q.now().Add(backoff(j.Attempts))); err != nil {
+		slog.WarnContext(ctx, "failed to requeue job", "kind", k.name, "id", j.ID, "error", err)
+	}
+	k.retried.Add(1)
+	slog.WarnContext(ctx, "job failed, will retry", "kind", k.name, "id", j.ID, "attempts", j.Attempts, "error", runErr)
+	return true, nil
+}
+
+// run calls h, turning a panic into an error so that it fails the job
+// rather than the worker.
+func run(ctx context.Context, h Handler, payload json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, payload)
+}
+
+// backoff returns the delay before the retry of a job that failed attempts
+// times.
+func backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// Status describes every kind of job, sorted by kind.
+func (q *Queue) Status(ctx context.Context) ([]KindStatus, error) {
+	q.mu.RLock()
+	kinds := make([]*kind, 0, len(q.kinds))
+	for _, k := range q.kinds {
+		kinds = append(kinds, k)
+	}
+	q.mu.RUnlock()
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].name < kinds[j].name })
+
+	statuses := make([]KindStatus, 0, len(kinds))
+	for _, k := range kinds {
+		queued, dead, err := q.store.Counts(ctx, k.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s jobs: %w", k.name, err)
+		}
+		statuses = append(statuses, KindStatus{
+			Kind:      k.name,
+			Workers:   k.workers,
+			Queued:    queued,
+			Dead:      dead,
+			Succeeded: k.succeeded.Load(),
+			Retried:   k.retried.Load(),
+			Buried:    k.buried.Load(),
+		})
+	}
+	return statuses, nil
+}
+
+// DeadLetters returns up to limit of the jobs of kind name that failed for
+// good, latest first.
+func (q *Queue) DeadLetters(ctx context.Context, name string, limit int) ([]Job, error) {
+	jobs, err := q.store.Dead(ctx, name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead %s jobs: %w", name, err)
+	}
+	return jobs, nil
+}
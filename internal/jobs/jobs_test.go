@@ -0,0 +1,271 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// stores returns a store of each implementation.
+func stores(t *testing.T) map[string]Store {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(client),
+	}
+}
+
+func TestQueue(t *testing.T) {
+	type payload struct {
+		N int `json:"n"`
+	}
+
+	tests := []struct {
+		name        string
+		fail        func(attempt int) error
+		maxAttempts int
+		// wantRuns is how many times the job runs, over retries.
+		wantRuns    int
+		wantBuried  bool
+		wantLastErr string
+	}{
+		{
+			name:     "success",
+			fail:     func(int) error { return nil },
+			wantRuns: 1,
+		},
+		{
+			name: "retried until it succeeds",
+			fail: func(attempt int) error {
+				if attempt < 3 {
+					return errors.New("connection refused")
+				}
+				return nil
+			},
+			wantRuns: 3,
+		},
+		{
+			name:        "dead after max attempts",
+			fail:        func(int) error { return errors.New("connection refused") },
+			maxAttempts: 2,
+			wantRuns:    2,
+			wantBuried:  true,
+			wantLastErr: "connection refused",
+		},
+		{
+			name:        "permanent failure",
+			fail:        func(int) error { return Permanent(errors.New("bad payload")) },
+			wantRuns:    1,
+			wantBuried:  true,
+			wantLastErr: "bad payload",
+		},
+		{
+			name: "panic",
+			fail: func(attempt int) error {
+				if attempt == 1 {
+					panic("nil map")
+				}
+				return nil
+			},
+			wantRuns: 2,
+		},
+	}
+
+	for storeName, store := range stores(t) {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				q := New(store)
+				now := time.Unix(1700000000, 0)
+				q.now = func() time.Time { return now }
+
+				kind := storeName + "-" + tt.name
+				runs := 0
+				opts := []Option{}
+				if tt.maxAttempts > 0 {
+					opts = append(opts, MaxAttempts(tt.maxAttempts))
+				}
+				q.Handle(kind, func(ctx context.Context, data json.RawMessage) error {
+					var p payload
+					if err := json.Unmarshal(data, &p); err != nil || p.N != 42 {
+						t.Errorf("payload = %s, %v, want n 42", data, err)
+					}
+					runs++
+					return tt.fail(runs)
+				}, opts...)
+
+				if err := q.Enqueue(ctx, kind, payload{N: 42}); err != nil {
+					t.Fatalf("Enqueue() error = %v", err)
+				}
+				// Each retry waits for its backoff
+				for i := range tt.wantRuns + 1 {
+					if _, err := q.Drain(ctx); err != nil {
+						t.Fatalf("Drain() error = %v", err)
+					}
+					if i < tt.wantRuns-1 && runs != i+1 {
+						t.Fatalf("ran %d times before the backoff %d elapsed, want %d", runs, i+1, i+1)
+					}
+					now = now.Add(maxBackoff)
+				}
+				if runs != tt.wantRuns {
+					t.Errorf("ran %d times, want %d", runs, tt.wantRuns)
+				}
+
+				status, err := q.Status(ctx)
+				if err != nil || len(status) != 1 {
+					t.Fatalf("Status() = %+v, %v", status, err)
+				}
+				st := status[0]
+				wantDead := int64(0)
+				if tt.wantBuried {
+					wantDead = 1
+				}
+				if st.Kind != kind || st.Queued != 0 || st.Dead != wantDead || st.Buried != wantDead || st.Succeeded != 1-wantDead {
+					t.Errorf("Status() = %+v, want %d dead", st, wantDead)
+				}
+				dead, err := q.DeadLetters(ctx, kind, 10)
+				if err != nil {
+					t.Fatalf("DeadLetters() error = %v", err)
+				}
+				if len(dead) != int(wantDead) {
+					t.Fatalf("DeadLetters() = %+v, want %d jobs", dead, wantDead)
+				}
+				if tt.wantBuried && (dead[0].LastError != tt.wantLastErr || dead[0].Attempts != tt.wantRuns || dead[0].FailedAt == nil) {
+					t.Errorf("dead job = %+v, want %d attempts failing with %q", dead[0], tt.wantRuns, tt.wantLastErr)
+				}
+			})
+		}
+	}
+}
+
+func TestQueue_EnqueueUnknownKind(t *testing.T) {
+	q := New(NewMemoryStore())
+	if err := q.Enqueue(context.Background(), "fetch_page", nil); err == nil {
+		t.Error("Enqueue() of a kind without handler error = nil")
+	}
+}
+
+func TestStore_Reserve(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, id := range []string{"b", "a", "c"} {
+				j := &Job{ID: id, Kind: "fetch_page", Payload: json.RawMessage(`{}`)}
+				if err := store.Push(ctx, j, now.Add(time.Duration(i-1)*time.Second)); err != nil {
+					t.Fatalf("Push() error = %v", err)
+				}
+			}
+
+			// Jobs come out by due time, and a reserved job is hidden until
+			// its lease expires
+			var got []string
+			for range 3 {
+				j, err := store.Reserve(ctx, "fetch_page", now, now.Add(time.Minute))
+				if err != nil {
+					t.Fatalf("Reserve() error = %v", err)
+				}
+				if j != nil {
+					got = append(got, j.ID)
+				}
+			}
+			if len(got) != 2 || got[0] != "b" || got[1] != "a" {
+				t.Errorf("reserved %v, want the due jobs b then a", got)
+			}
+			if err := store.Ack(ctx, &Job{ID: "a", Kind: "fetch_page"}); err != nil {
+				t.Fatalf("Ack() error = %v", err)
+			}
+			got = nil
+			for range 2 {
+				j, err := store.Reserve(ctx, "fetch_page", now.Add(time.Minute), now.Add(2*time.Minute))
+				if err != nil || j == nil {
+					t.Fatalf("Reserve() after the lease = %+v, %v", j, err)
+				}
+				got = append(got, j.ID)
+			}
+			if got[0] != "c" || got[1] != "b" {
+				t.Errorf("reserved %v after the lease, want c then b again", got)
+			}
+			if j, err := store.Reserve(ctx, "other", now.Add(time.Hour), now.Add(time.Hour)); err != nil || j != nil {
+				t.Errorf("Reserve() of another kind = %+v, %v, want none", j, err)
+			}
+
+			for i := range 3 {
+				if err := store.Bury(ctx, &Job{ID: "b", Kind: "fetch_page", Attempts: i}, 2); err != nil {
+					t.Fatalf("Bury() error = %v", err)
+				}
+			}
+			queued, dead, err := store.Counts(ctx, "fetch_page")
+			if err != nil || queued != 1 || dead != 2 {
+				t.Errorf("Counts() = %d, %d, %v, want c queued and 2 dead", queued, dead, err)
+			}
+			jobs, err := store.Dead(ctx, "fetch_page", 10)
+			if err != nil || len(jobs) != 2 || jobs[0].Attempts != 2 {
+				t.Errorf("Dead() = %+v, %v, want the two latest", jobs, err)
+			}
+		})
+	}
+}
+
+func TestQueue_Run(t *testing.T) {
+	q := New(NewMemoryStore())
+	var done atomic.Int64
+	finished := make(chan struct{})
+	q.Handle("fetch_page", func(ctx context.Context, payload json.RawMessage) error {
+		if done.Add(1) == 10 {
+			close(finished)
+		}
+		return nil
+	}, Workers(3))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+	for i := range 10 {
+		if err := q.Enqueue(context.Background(), "fetch_page", i); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("ran %d of 10 jobs", done.Load())
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{9, 256 * time.Second},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
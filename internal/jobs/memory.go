@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// queued is a job in a MemoryStore and when it is due.
+type queued struct {
+	job Job
+	due time.Time
+}
+
+// MemoryStore is a Store keeping jobs in memory, for a single instance.
+// Queued jobs are lost when the process exits.
+type MemoryStore struct {
+	mu     sync.Mutex
+	queues map[string]map[string]*queued
+	dead   map[string][]Job
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{queues: map[string]map[string]*queued{}, dead: map[string][]Job{}}
+}
+
+// Push implements Store.
+func (s *MemoryStore) Push(ctx context.Context, j *Job, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[j.Kind]
+	if queue == nil {
+		queue = map[string]*queued{}
+		s.queues[j.Kind] = queue
+	}
+	queue[j.ID] = &queued{job: *j, due: at}
+	return nil
+}
+
+// Reserve implements Store.
+func (s *MemoryStore) Reserve(ctx context.Context, kind string, now, until time.Time) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first *queued
+	for _, q := range s.queues[kind] {
+		if !q.due.After(now) && (first == nil || q.due.Before(first.due)) {
+			first = q
+		}
+	}
+	if first == nil {
+		return nil, nil
+	}
+	first.due = until
+	j := first.job
+	return &j, nil
+}
+
+// Ack implements Store.
+func (s *MemoryStore) Ack(ctx context.Context, j *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queues[j.Kind], j.ID)
+	return nil
+}
+
+// Bury implements Store.
+func (s *MemoryStore) Bury(ctx context.Context, j *Job, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queues[j.Kind], j.ID)
+	dead := append([]Job{*j}, s.dead[j.Kind]...)
+	s.dead[j.Kind] = dead[:min(len(dead), limit)]
+	return nil
+}
+
+// Counts implements Store.
+func (s *MemoryStore) Counts(ctx context.Context, kind string) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.queues[kind])), int64(len(s.dead[kind])), nil
+}
+
+// Dead implements Store.
+func (s *MemoryStore) Dead(ctx context.Context, kind string, limit int) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dead := s.dead[kind]
+	return append([]Job{}, dead[:min(len(dead), max(limit, 0))]...), nil
+}
@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix prefixes the Redis keys of queued jobs. The jobs of each kind
+// are kept under three keys: a sorted set of job IDs scored by when they
+// are due in Unix milliseconds, a hash of the jobs by ID, and a list of
+// the dead jobs, latest first.
+const keyPrefix = "shorturl:jobs:"
+
+func queueKey(kind string) string { return keyPrefix + kind + ":queue" }
+func dataKey(kind string) string  { return keyPrefix + kind + ":data" }
+func deadKey(kind string) string  { return keyPrefix + kind + ":dead" }
+
+// reserveScript returns the job that has been due the longest by ARGV[1]
+// and makes it due again at ARGV[2], or false if none is due. An ID left
+// without a job is dropped.
+var reserveScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if #ids == 0 then
+	return false
+end
+local data = redis.call("HGET", KEYS[2], ids[1])
+if data == false then
+	redis.call("ZREM", KEYS[1], ids[1])
+	return false
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ids[1])
+return data
+`)
+
+// RedisStore is a Store keeping jobs in Redis, shared by the instances
+// using the same Redis server.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore returns a store keeping jobs in client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Push implements Store with HSET and ZADD in a transaction.
+func (s *RedisStore) Push(ctx context.Context, j *Job, at time.Time) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, dataKey(j.Kind), j.ID, data)
+		pipe.ZAdd(ctx, queueKey(j.Kind), redis.Z{Score: float64(at.UnixMilli()), Member: j.ID})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+// Reserve implements Store with a script, so that a job is reserved by a
+// single worker.
+func (s *RedisStore) Reserve(ctx context.Context, kind string, now, until time.Time) (*Job, error) {
+	data, err := reserveScript.Run(ctx, s.client, []string{queueKey(kind), dataKey(kind)}, now.UnixMilli(), until.UnixMilli()).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve job: %w", err)
+	}
+	var j Job
+	if err := json.Unmarshal([]byte(data), &j); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return &j, nil
+}
+
+// Ack implements Store.
+func (s *RedisStore) Ack(ctx context.Context, j *Job) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, queueKey(j.Kind), j.ID)
+		pipe.HDel(ctx, dataKey(j.Kind), j.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ack job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+// Bury implements Store, trimming the dead letters with LTRIM.
+func (s *RedisStore) Bury(ctx context.Context, j *Job, limit int) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, queueKey(j.Kind), j.ID)
+		pipe.HDel(ctx, dataKey(j.Kind), j.ID)
+		pipe.LPush(ctx, deadKey(j.Kind), data)
+		pipe.LTrim(ctx, deadKey(j.Kind), 0, int64(limit)-1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to bury job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+// Counts implements Store.
+func (s *RedisStore) Counts(ctx context.Context, kind string) (int64, int64, error) {
+	pipe := s.client.Pipeline()
+	queued := pipe.ZCard(ctx, queueKey(kind))
+	dead := pipe.LLen(ctx, deadKey(kind))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	return queued.Val(), dead.Val(), nil
+}
+
+// Dead implements Store.
+func (s *RedisStore) Dead(ctx context.Context, kind string, limit int) ([]Job, error) {
+	if limit <= 0 {
+		return []Job{}, nil
+	}
+	values, err := s.client.LRange(ctx, deadKey(kind), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead jobs: %w", err)
+	}
+	jobs := make([]Job, 0, len(values))
+	for _, v := range values {
+		var j Job
+		if err := json.Unmarshal([]byte(v), &j); err != nil {
+			return nil, fmt.Errorf("failed to decode job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
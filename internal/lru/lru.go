@@ -0,0 +1,127 @@
+// Package lru is a size-bounded, sharded in-process cache with least
+// recently used eviction and a fixed time to live per entry.
+package lru
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// shardCount spreads keys over independently locked shards so that
+// concurrent lookups of different keys rarely contend.
+const shardCount = 16
+
+// Cache maps string keys to values of type V. It is safe for concurrent use.
+type Cache[V any] struct {
+	seed   maphash.Seed
+	ttl    time.Duration
+	shards [shardCount]shard[V]
+	// now is stubbed in tests.
+	now func() time.Time
+}
+
+type shard[V any] struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // of *entry[V], most recently used first
+	items map[string]*list.Element
+}
+
+type entry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// New returns a cache holding up to about maxEntries entries, each for at
+// most ttl. maxEntries is split evenly between the shards.
+func New[V any](maxEntries int, ttl time.Duration) *Cache[V] {
+	c := &Cache[V]{seed: maphash.MakeSeed(), ttl: ttl, now: time.Now}
+	perShard := max(1, (maxEntries+shardCount-1)/shardCount)
+	for i := range c.shards {
+		c.shards[i] = shard[V]{max: perShard, order: list.New(), items: make(map[string]*list.Element)}
+	}
+	return c
+}
+
+func (c *Cache[V]) shard(key string) *shard[V] {
+	return &c.shards[maphash.String(c.seed, key)%shardCount]
+}
+
+// Get returns the value stored under key unless it has expired.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zero V
+	el, ok := s.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[V])
+	if !c.now().Before(e.expires) {
+		s.remove(el)
+		return zero, false
+	}
+	s.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores value under key for the cache TTL, or for ttl if that is
+// shorter and positive, evicting the least recently used entry of the
+// shard if it is full.
+func (c *Cache[V]) Set(key string, value V, ttl time.Duration) {
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+	expires := c.now().Add(ttl)
+
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*entry[V])
+		e.value, e.expires = value, expires
+		s.order.MoveToFront(el)
+		return
+	}
+	s.items[key] = s.order.PushFront(&entry[V]{key: key, value: value, expires: expires})
+	if s.order.Len() > s.max {
+		s.remove(s.order.Back())
+	}
+}
+
+// Delete removes the entries stored under keys.
+func (c *Cache[V]) Delete(keys ...string) {
+	for _, key := range keys {
+		s := c.shard(key)
+		s.mu.Lock()
+		if el, ok := s.items[key]; ok {
+			s.remove(el)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Len returns the number of entries, including expired ones not yet
+// evicted.
+func (c *Cache[V]) Len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		n += s.order.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// remove drops el. Callers must hold s.mu.
+func (s *shard[V]) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.items, el.Value.(*entry[V]).key)
+}
@@ -0,0 +1,107 @@
+package lru
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache_GetSet(t *testing.T) {
+	c := New[int](100, time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get() on empty cache hit")
+	}
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	c.Set("a", 3, 0)
+	if v, ok := c.Get("a"); !ok || v != 3 {
+		t.Errorf("Get(a) = %d, %v, want 3, true", v, ok)
+	}
+	if v, ok := c.Get("b"); !ok || v != 2 {
+		t.Errorf("Get(b) = %d, %v, want 2, true", v, ok)
+	}
+	c.Delete("a", "missing")
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get() after Delete() hit")
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+}
+
+func TestCache_Expiry(t *testing.T) {
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	c := New[string](100, 10*time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Set("default", "x", 0)
+	c.Set("short", "x", 2*time.Second)
+	c.Set("long", "x", time.Hour)
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("short"); ok {
+		t.Error("entry with a shorter TTL not expired")
+	}
+	if _, ok := c.Get("default"); !ok {
+		t.Error("entry expired before the cache TTL")
+	}
+	now = now.Add(8 * time.Second)
+	for _, key := range []string{"default", "long"} {
+		if _, ok := c.Get(key); ok {
+			t.Errorf("%s not expired after the cache TTL", key)
+		}
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len() = %d, want expired entries evicted on Get", n)
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[int](shardCount, time.Minute)
+	// Fill one shard (of capacity 1) with a key, then find another key of
+	// the same shard
+	c.Set("k0", 0, 0)
+	var other string
+	for i := 1; other == ""; i++ {
+		if key := fmt.Sprintf("k%d", i); c.shard(key) == c.shard("k0") {
+			other = key
+		}
+	}
+	c.Set(other, 1, 0)
+	if _, ok := c.Get("k0"); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	if v, ok := c.Get(other); !ok || v != 1 {
+		t.Errorf("Get(%s) = %d, %v, want 1, true", other, v, ok)
+	}
+
+	// Bounded overall
+	c = New[int](64, time.Minute)
+	for i := range 10000 {
+		c.Set(fmt.Sprint(i), i, 0)
+	}
+	if n := c.Len(); n > 64 {
+		t.Errorf("Len() = %d, want at most 64", n)
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := New[int](1000, time.Minute)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := fmt.Sprint((g*1000 + i) % 1500)
+				c.Set(key, i, 0)
+				c.Get(key)
+				if i%10 == 0 {
+					c.Delete(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,313 @@
+// Package metadata fetches descriptive information (the page title,
+// description, favicon and sharing image) about destination URLs.
+//
+// Destinations are user-supplied, so the fetcher is hardened against SSRF:
+// it only speaks http(s), refuses to connect to loopback, private,
+// link-local and other non-public addresses (checked after DNS resolution,
+// so rebinding tricks do not help), follows a bounded number of redirects and
+// reads a bounded amount of the response body.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxBodyBytes is how much of an HTML document is scanned for metadata.
+	maxBodyBytes = 256 << 10
+	// maxRedirects is the number of redirects followed before giving up.
+	maxRedirects = 3
+	// maxTitleLength and maxDescriptionLength truncate absurdly long texts.
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+var (
+	ErrForbiddenAddress = errors.New("destination address is not publicly routable")
+	ErrUnsupportedURL   = errors.New("only http and https URLs can be fetched")
+	ErrNotHTML          = errors.New("destination is not an HTML page")
+)
+
+// Metadata describes a destination page.
+type Metadata struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// FaviconURL is the absolute URL of the icon the page declares, if any.
+	FaviconURL string `json:"favicon_url,omitempty"`
+	// ImageURL is the absolute URL of the Open Graph or Twitter card image
+	// of the page, if any.
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// Fetcher retrieves Metadata over HTTP.
+type Fetcher struct {
+	client *http.Client
+}
+
+// NewFetcher returns a Fetcher whose requests time out after timeout.
+func NewFetcher(timeout time.Duration) *Fetcher {
+	return newFetcher(timeout, false)
+}
+
+// newFetcher optionally allows private addresses so tests can use httptest servers.
+func newFetcher(timeout time.Duration, allowPrivate bool) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+	if !allowPrivate {
+		dialer.Control = denyNonPublic
+	}
+
+	transport := &http.Transport{
+		// No proxy: the address check must see the real destination
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return ErrUnsupportedURL
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Fetch downloads the page at rawURL and extracts its metadata.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, ErrUnsupportedURL
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "url-shortener-preview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", rawURL, resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return nil, ErrNotHTML
+	}
+
+	// Relative favicon links resolve against the page after redirects
+	return parse(io.LimitReader(resp.Body, maxBodyBytes), resp.Request.URL), nil
+}
+
+// Probe requests rawURL with HEAD, or with GET if the server does not allow
+// HEAD, following redirects like Fetch, and returns the status code of the
+// response. The body is not read.
+func (f *Fetcher) Probe(ctx context.Context, rawURL string) (int, error) {
+	code, err := f.probe(ctx, http.MethodHead, rawURL)
+	if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		code, err = f.probe(ctx, http.MethodGet, rawURL)
+	}
+	return code, err
+}
+
+// probe requests rawURL with method and returns the status code of the
+// response.
+func (f *Fetcher) probe(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return 0, ErrUnsupportedURL
+	}
+	req.Header.Set("User-Agent", "url-shortener-health/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe %s: %w", rawURL, err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return 0, fmt.Errorf("failed to probe %s: %w", rawURL, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// Open requests rawURL with method, GET or HEAD, on behalf of a visitor
+// whose request has header, for proxying the page. Only the headers that
+// describe what the visitor accepts are passed on. The caller must close
+// the body of the response.
+func (f *Fetcher) Open(ctx context.Context, method, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, ErrUnsupportedURL
+	}
+	for _, name := range []string{"Accept", "Accept-Language", "User-Agent"} {
+		if v := header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", rawURL, err)
+	}
+	return resp, nil
+}
+
+// parse extracts metadata from an HTML document served at base. It stops at
+// </head> or the first <body> element since everything of interest lives in
+// the head.
+func parse(r io.Reader, base *url.URL) *Metadata {
+	var md Metadata
+	var ogDescription, twitterImage string
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				if md.Title == "" && z.Next() == html.TextToken {
+					md.Title = cleanText(string(z.Text()), maxTitleLength)
+				}
+			case "meta":
+				attrs := attributes(z, hasAttr)
+				content := cleanText(attrs["content"], maxDescriptionLength)
+				switch {
+				case strings.EqualFold(attrs["name"], "description") && md.Description == "":
+					md.Description = content
+				case attrs["property"] == "og:description" && ogDescription == "":
+					ogDescription = content
+				case attrs["property"] == "og:image" && md.ImageURL == "":
+					md.ImageURL = resolveHTTP(base, attrs["content"])
+				case attrs["name"] == "twitter:image" && twitterImage == "":
+					twitterImage = resolveHTTP(base, attrs["content"])
+				}
+			case "link":
+				attrs := attributes(z, hasAttr)
+				if md.FaviconURL == "" && isIconRel(attrs["rel"]) {
+					md.FaviconURL = resolveHTTP(base, attrs["href"])
+				}
+			case "body":
+				break loop
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				break loop
+			}
+		}
+	}
+	// The Open Graph description is only a fallback for the standard one
+	if md.Description == "" {
+		md.Description = ogDescription
+	}
+	if md.ImageURL == "" {
+		md.ImageURL = twitterImage
+	}
+	return &md
+}
+
+// attributes returns the attributes of the current tag by lowercase name.
+func attributes(z *html.Tokenizer, more bool) map[string]string {
+	attrs := make(map[string]string)
+	for more {
+		var key, val []byte
+		key, val, more = z.TagAttr()
+		attrs[string(key)] = string(val)
+	}
+	return attrs
+}
+
+// isIconRel reports whether a link rel attribute, such as "shortcut icon",
+// declares a favicon.
+func isIconRel(rel string) bool {
+	for _, v := range strings.Fields(rel) {
+		if strings.EqualFold(v, "icon") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveHTTP resolves href against base, returning "" unless the result is
+// an http(s) URL: data: and javascript: icons and images are not worth
+// keeping.
+func resolveHTTP(base *url.URL, href string) string {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || href == "" {
+		return ""
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+// cleanText collapses whitespace and truncates to max runes.
+func cleanText(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > max {
+		s = string(runes[:max])
+	}
+	return s
+}
+
+// denyNonPublic is a net.Dialer Control hook that rejects connections to
+// addresses that are not publicly routable.
+func denyNonPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// cgnat is the carrier-grade NAT shared address space (RFC 6598).
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublicIP reports whether ip is a globally routable unicast address.
+func IsPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!cgnat.Contains(ip)
+}
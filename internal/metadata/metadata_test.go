@@ -0,0 +1,256 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetcher_Fetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><head><title>\n  Spring   Sale \n</title></head><body><title>ignored</title></body></html>"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		wantTitle string
+		wantErr   bool
+	}{
+		{name: "title", path: "/page", wantTitle: "Spring Sale"},
+		{name: "follows redirects", path: "/redirect", wantTitle: "Spring Sale"},
+		{name: "redirect loop", path: "/loop", wantErr: true},
+		{name: "not html", path: "/image", wantErr: true},
+		{name: "not found", path: "/missing", wantErr: true},
+	}
+
+	f := newFetcher(time.Second, true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := f.Fetch(context.Background(), srv.URL+tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && md.Title != tt.wantTitle {
+				t.Errorf("Fetch() title = %q, want %q", md.Title, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestFetcher_Probe(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("/page method = %s, want HEAD", r.Method)
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/page", http.StatusOK},
+		{"/redirect", http.StatusOK},
+		{"/get-only", http.StatusOK},
+		{"/missing", http.StatusNotFound},
+		{"/broken", http.StatusBadGateway},
+	}
+
+	f := newFetcher(time.Second, true)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			code, err := f.Probe(context.Background(), srv.URL+tt.path)
+			if err != nil || code != tt.want {
+				t.Errorf("Probe() = %d, %v, want %d", code, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewFetcher(time.Second).Probe(context.Background(), srv.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Probe() of a loopback address error = %v, want ErrForbiddenAddress", err)
+	}
+}
+
+func TestFetcher_Open(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Language") != "de" || r.Header.Get("Cookie") != "" {
+			t.Errorf("request headers = %v, want Accept-Language alone passed on", r.Header)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, r.Method)
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Accept-Language": {"de"}, "Cookie": {"session=secret"}}
+	resp, err := newFetcher(time.Second, true).Open(context.Background(), http.MethodGet, srv.URL, header)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK || string(body) != http.MethodGet {
+		t.Errorf("Open() = %d %q, %v, want 200 GET", resp.StatusCode, body, err)
+	}
+
+	if _, err := NewFetcher(time.Second).Open(context.Background(), http.MethodGet, srv.URL, header); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Open() of a loopback address error = %v, want ErrForbiddenAddress", err)
+	}
+	if _, err := NewFetcher(time.Second).Open(context.Background(), http.MethodGet, "file:///etc/passwd", header); !errors.Is(err, ErrUnsupportedURL) {
+		t.Errorf("Open() error = %v, want ErrUnsupportedURL", err)
+	}
+}
+
+func TestFetcher_RejectsPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request to loopback address should not have been made")
+	}))
+	defer srv.Close()
+
+	_, err := NewFetcher(time.Second).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Fetch() error = %v, want ErrForbiddenAddress", err)
+	}
+
+	if _, err := NewFetcher(time.Second).Fetch(context.Background(), "ftp://example.com/"); !errors.Is(err, ErrUnsupportedURL) {
+		t.Errorf("Fetch() error = %v, want ErrUnsupportedURL", err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := IsPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("IsPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_StopsAtBody(t *testing.T) {
+	md := parse(strings.NewReader("<html><body><title>not a title</title></body></html>"), &url.URL{Scheme: "https", Host: "example.com"})
+	if md.Title != "" {
+		t.Errorf("parse() title = %q, want empty", md.Title)
+	}
+}
+
+func TestParse_Image(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/blog/post"}
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{name: "open graph", head: `<meta property="og:image" content="/img/sale.png">`, want: "https://example.com/img/sale.png"},
+		{name: "twitter fallback", head: `<meta name="twitter:image" content="https://cdn.example.com/sale.png">`, want: "https://cdn.example.com/sale.png"},
+		{name: "open graph wins over twitter", head: `<meta name="twitter:image" content="/twitter.png"><meta property="og:image" content="/og.png">`, want: "https://example.com/og.png"},
+		{name: "data image ignored", head: `<meta property="og:image" content="data:image/png;base64,AAAA">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := parse(strings.NewReader("<html><head>"+tt.head+"</head><body></body></html>"), base)
+			if md.ImageURL != tt.want {
+				t.Errorf("parse() image = %q, want %q", md.ImageURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_DescriptionAndFavicon(t *testing.T) {
+	base := &url.URL{Scheme: "https", Host: "example.com", Path: "/blog/post"}
+	tests := []struct {
+		name            string
+		head            string
+		wantDescription string
+		wantFavicon     string
+	}{
+		{
+			name:            "description and relative icon",
+			head:            `<meta name="Description" content=" Our  spring sale "><link rel="shortcut icon" href="/static/icon.png">`,
+			wantDescription: "Our spring sale",
+			wantFavicon:     "https://example.com/static/icon.png",
+		},
+		{
+			name:            "open graph fallback",
+			head:            `<meta property="og:description" content="From OG"/><link rel="icon" href="icon.svg"/>`,
+			wantDescription: "From OG",
+			wantFavicon:     "https://example.com/blog/icon.svg",
+		},
+		{
+			name:            "standard description wins over open graph",
+			head:            `<meta property="og:description" content="From OG"><meta name="description" content="Standard">`,
+			wantDescription: "Standard",
+		},
+		{
+			name: "data icon ignored",
+			head: `<link rel="icon" href="data:image/png;base64,AAAA"><link rel="stylesheet" href="/style.css">`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := parse(strings.NewReader("<html><head>"+tt.head+"</head><body></body></html>"), base)
+			if md.Description != tt.wantDescription {
+				t.Errorf("parse() description = %q, want %q", md.Description, tt.wantDescription)
+			}
+			if md.FaviconURL != tt.wantFavicon {
+				t.Errorf("parse() favicon = %q, want %q", md.FaviconURL, tt.wantFavicon)
+			}
+		})
+	}
+}
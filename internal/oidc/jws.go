@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+)
+
+// verifyFunc checks signature over signed with key.
+type verifyFunc func(key crypto.PublicKey, signed, signature []byte) error
+
+var errSignature = errors.New("bad signature")
+
+// algorithms are the JWS algorithms accepted, by name.
+var algorithms = map[string]verifyFunc{
+	"RS256": rsaVerifier(crypto.SHA256, false),
+	"RS384": rsaVerifier(crypto.SHA384, false),
+	"RS512": rsaVerifier(crypto.SHA512, false),
+	"PS256": rsaVerifier(crypto.SHA256, true),
+	"PS384": rsaVerifier(crypto.SHA384, true),
+	"PS512": rsaVerifier(crypto.SHA512, true),
+	"ES256": ecdsaVerifier(crypto.SHA256, elliptic.P256()),
+	"ES384": ecdsaVerifier(crypto.SHA384, elliptic.P384()),
+	"ES512": ecdsaVerifier(crypto.SHA512, elliptic.P521()),
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func rsaVerifier(hash crypto.Hash, pss bool) verifyFunc {
+	return func(key crypto.PublicKey, signed, signature []byte) error {
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an RSA key")
+		}
+		var err error
+		if pss {
+			err = rsa.VerifyPSS(pub, hash, digest(hash, signed), signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest(hash, signed), signature)
+		}
+		if err != nil {
+			return errSignature
+		}
+		return nil
+	}
+}
+
+// ecdsaVerifier verifies signatures made on curve, which JWS encodes as the
+// raw R and S values rather than ASN.1.
+func ecdsaVerifier(hash crypto.Hash, curve elliptic.Curve) verifyFunc {
+	return func(key crypto.PublicKey, signed, signature []byte) error {
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != curve {
+			return errors.New("key is not an EC key on " + curve.Params().Name)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest(hash, signed), r, s) {
+			return errSignature
+		}
+		return nil
+	}
+}
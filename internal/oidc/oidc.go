@@ -0,0 +1,354 @@
+// Package oidc verifies JWTs issued by an OpenID Connect provider, so that
+// the API can sit behind an existing identity provider instead of (or
+// besides) its own API keys.
+//
+// The signing keys are fetched from the JWKS of the issuer, found through
+// its discovery document unless configured directly, and cached. An
+// unknown key ID triggers a refetch, which is how providers roll keys, but
+// at most once per minRefresh so that forged tokens cannot hammer the
+// provider. Only asymmetric algorithms are accepted: RS*, PS* and ES*.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed,
+// expired or meant for another issuer or audience. Other errors mean that
+// the keys could not be fetched.
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	// leeway absorbs clock skew between the provider and the service.
+	leeway = time.Minute
+	// minRefresh is the shortest time between two JWKS fetches.
+	minRefresh = time.Minute
+	// maxDocumentBytes caps discovery documents and key sets.
+	maxDocumentBytes = 1 << 20
+)
+
+// Config configures a Verifier.
+type Config struct {
+	// Issuer is the iss claim tokens must carry and, unless JWKSURL is set,
+	// where the discovery document is looked up.
+	Issuer string
+	// Audience is a value the aud claim of tokens must hold, normally the
+	// client ID of the service at the provider.
+	Audience string
+	// JWKSURL is where the signing keys are fetched from. Empty discovers it.
+	JWKSURL string
+	// CacheTTL is how long fetched keys are used before they are fetched
+	// again.
+	CacheTTL time.Duration
+	// Client fetches the documents. Nil means a client with a 10s timeout.
+	Client *http.Client
+}
+
+// Claims are the claims of a verified token that the service uses.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	ExpiresAt     time.Time
+}
+
+// Verifier verifies tokens of one issuer.
+type Verifier struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	jwksURL string
+	keys    map[string]jwk
+	// fetchedAt is when keys were fetched, attemptedAt when the latest
+	// fetch started and fetchErr how it failed, if it did.
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetchErr    error
+}
+
+// NewVerifier returns a Verifier for cfg. Nothing is fetched until the
+// first token is verified.
+func NewVerifier(cfg Config) *Verifier {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Verifier{cfg: cfg, now: time.Now, jwksURL: cfg.JWKSURL}
+}
+
+// IsJWT reports whether token has the shape of a compact JWT, to tell it
+// apart from other bearer tokens such as API keys.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type payload struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      audience        `json:"aud"`
+	ExpiresAt     *int64          `json:"exp"`
+	NotBefore     *int64          `json:"nbf"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+}
+
+// audience is the aud claim, which is a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Verify checks the signature and the claims of token and returns them.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+	verify, ok := algorithms[h.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidToken, err)
+	}
+
+	key, err := v.key(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	var p payload
+	if err := decodeSegment(parts[1], &p); err != nil {
+		return nil, fmt.Errorf("%w: payload: %w", ErrInvalidToken, err)
+	}
+	return v.check(&p)
+}
+
+// check validates the registered claims of p.
+func (v *Verifier) check(p *payload) (*Claims, error) {
+	now := v.now()
+	switch {
+	case p.Issuer != v.cfg.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, p.Issuer)
+	case !slices.Contains(p.Audience, v.cfg.Audience):
+		return nil, fmt.Errorf("%w: audience %q", ErrInvalidToken, p.Audience)
+	case p.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case p.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	case now.After(time.Unix(*p.ExpiresAt, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case p.NotBefore != nil && now.Add(leeway).Before(time.Unix(*p.NotBefore, 0)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	// Some providers send email_verified as a string
+	verified := string(p.EmailVerified) == "true" || string(p.EmailVerified) == `"true"`
+	return &Claims{
+		Issuer:        p.Issuer,
+		Subject:       p.Subject,
+		Email:         p.Email,
+		EmailVerified: verified,
+		ExpiresAt:     time.Unix(*p.ExpiresAt, 0),
+	}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwk is a public key of the key set.
+type jwk struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// key returns the public key kid for alg, fetching the key set if it is
+// stale or does not have kid.
+func (v *Verifier) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	k, ok := v.keys[kid]
+	stale := v.keys == nil || now.Sub(v.fetchedAt) > v.cfg.CacheTTL
+	if (stale || !ok) && now.Sub(v.attemptedAt) > minRefresh {
+		v.attemptedAt = now
+		keys, err := v.fetchKeys(ctx)
+		v.fetchErr = err
+		if err == nil {
+			v.keys, v.fetchedAt = keys, now
+			k, ok = keys[kid]
+		} else if v.keys != nil {
+			// The keys we have keep working while the provider is down
+			slog.WarnContext(ctx, "failed to refresh oidc keys", "error", err)
+		}
+	}
+	if v.keys == nil {
+		return nil, v.fetchErr
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("%w: key %q is for %s, not %s", ErrInvalidToken, kid, k.alg, alg)
+	}
+	return k.key, nil
+}
+
+// fetchKeys fetches the key set, discovering its URL first if needed.
+// Called with v.mu held.
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]jwk, error) {
+	if v.jwksURL == "" {
+		var doc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		url := strings.TrimSuffix(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.fetch(ctx, url, &doc); err != nil {
+			return nil, fmt.Errorf("failed to discover jwks: %w", err)
+		}
+		if doc.Issuer != v.cfg.Issuer || doc.JWKSURI == "" {
+			return nil, fmt.Errorf("failed to discover jwks: discovery document of %q is for %q", v.cfg.Issuer, doc.Issuer)
+		}
+		v.jwksURL = doc.JWKSURI
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := v.fetch(ctx, v.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, raw := range set.Keys {
+		// Keys of unknown types, or for encryption, are skipped rather than
+		// failing the whole set
+		if kid, k, err := parseJWK(raw); err == nil {
+			keys[kid] = k
+		}
+	}
+	return keys, nil
+}
+
+func (v *Verifier) fetch(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxDocumentBytes)).Decode(dst)
+}
+
+func parseJWK(raw json.RawMessage) (string, jwk, error) {
+	var k struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return "", jwk{}, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return "", jwk{}, fmt.Errorf("key %q is for %s", k.Kid, k.Use)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return "", jwk{}, fmt.Errorf("key %q: bad exponent", k.Kid)
+		}
+		return k.Kid, jwk{alg: k.Alg, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return "", jwk{}, fmt.Errorf("key %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return "", jwk{}, err
+		}
+		return k.Kid, jwk{alg: k.Alg, key: &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, nil
+	}
+	return "", jwk{}, fmt.Errorf("key %q: unsupported type %q", k.Kid, k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("bad key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
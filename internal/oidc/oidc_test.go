@@ -0,0 +1,203 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// provider is a fake identity provider serving a discovery document and the
+// public keys of keys.
+type provider struct {
+	*httptest.Server
+	keys    map[string]crypto.Signer
+	fetches atomic.Int64
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{keys: map[string]crypto.Signer{"rsa1": rsaKey, "ec1": ecKey}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.fetches.Add(1)
+		var keys []map[string]string
+		for kid, key := range p.keys {
+			switch pub := key.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())})
+			case *ecdsa.PublicKey:
+				keys = append(keys, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))})
+			}
+		}
+		keys = append(keys, map[string]string{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"})
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a token with claims signed by the key kid.
+func (p *provider) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	key := p.keys[kid]
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("failed to encode header: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to encode claims: %v", err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	digest := digest(crypto.SHA256, []byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest)
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func (p *provider) claims(overrides map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss":            p.URL,
+		"sub":            "user-42",
+		"aud":            []string{"shortener", "other"},
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	p := newProvider(t)
+	v := NewVerifier(Config{Issuer: p.URL, Audience: "shortener", CacheTTL: time.Hour})
+	ctx := context.Background()
+
+	for _, kid := range []string{"rsa1", "ec1"} {
+		claims, err := v.Verify(ctx, p.sign(t, kid, p.claims(nil)))
+		if err != nil {
+			t.Fatalf("Verify() with %s unexpected error = %v", kid, err)
+		}
+		if claims.Subject != "user-42" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Issuer != p.URL {
+			t.Errorf("Verify() with %s = %+v", kid, claims)
+		}
+	}
+
+	valid := p.sign(t, "rsa1", p.claims(nil))
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong audience", p.sign(t, "rsa1", p.claims(map[string]any{"aud": "other"}))},
+		{"wrong issuer", p.sign(t, "rsa1", p.claims(map[string]any{"iss": "https://evil.example.com"}))},
+		{"expired", p.sign(t, "rsa1", p.claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}))},
+		{"no expiry", p.sign(t, "rsa1", p.claims(map[string]any{"exp": nil}))},
+		{"not yet valid", p.sign(t, "rsa1", p.claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()}))},
+		{"no subject", p.sign(t, "rsa1", p.claims(map[string]any{"sub": ""}))},
+		{"tampered", valid[:len(valid)-4] + "AAAA"},
+		{"unsigned", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"admin"}`)) + "."},
+		{"symmetric", b64([]byte(`{"alg":"HS256","kid":"secret"}`)) + "." + b64([]byte(`{"sub":"admin"}`)) + ".c2ln"},
+		{"unknown key", b64([]byte(`{"alg":"RS256","kid":"rsa9"}`)) + "." + b64([]byte(`{"sub":"admin"}`)) + ".c2ln"},
+		{"not a JWT", "sk_abc"},
+	}
+	for _, tt := range tests {
+		if _, err := v.Verify(ctx, tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: Verify() error = %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+	if IsJWT("sk_abc") || !IsJWT(valid) {
+		t.Error("IsJWT() does not tell API keys and JWTs apart")
+	}
+}
+
+func TestVerifier_KeyRotation(t *testing.T) {
+	p := newProvider(t)
+	v := NewVerifier(Config{Issuer: p.URL, Audience: "shortener", CacheTTL: time.Hour})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, p.sign(t, "rsa1", p.claims(nil))); err != nil {
+		t.Fatalf("Verify() unexpected error = %v", err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.keys["rsa2"] = newKey
+	rotated := p.sign(t, "rsa2", p.claims(map[string]any{"exp": now.Add(3 * time.Hour).Unix()}))
+
+	// Unknown keys are refetched, but not more than once a minute
+	if _, err := v.Verify(ctx, rotated); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() right after a fetch = %v, want ErrInvalidToken", err)
+	}
+	now = now.Add(2 * minRefresh)
+	if _, err := v.Verify(ctx, rotated); err != nil {
+		t.Errorf("Verify() with a rotated key unexpected error = %v", err)
+	}
+	if got := p.fetches.Load(); got != 2 {
+		t.Errorf("fetched keys %d times, want 2", got)
+	}
+
+	// Cached keys keep working while the provider is down
+	p.Close()
+	now = now.Add(2 * time.Hour)
+	if _, err := v.Verify(ctx, rotated); err != nil {
+		t.Errorf("Verify() with the provider down unexpected error = %v", err)
+	}
+	down := NewVerifier(Config{Issuer: p.URL, Audience: "shortener", CacheTTL: time.Hour})
+	if _, err := down.Verify(ctx, rotated); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() without keys error = %v, want a fetch error", err)
+	}
+}
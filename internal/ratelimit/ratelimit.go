@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/redis/go-redis/v9"
+)
+
+// Result describes the outcome of a single rate limit check.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether a request identified by key may proceed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// tokenBucketScript refills and consumes a token atomically.
+//
+// The bucket is stored as a hash {tokens, ts}. Tokens refill continuously at
+// ARGV[1] tokens per millisecond up to ARGV[2] (burst). Running the whole
+// read-modify-write in Lua keeps concurrent requests from different app
+// instances from double-spending tokens.
+//
+// Returns {allowed (0|1), remaining tokens, retry after in ms}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry_after = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry_after = math.ceil((1 - tokens) / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate))
+
+return {allowed, math.floor(tokens), retry_after}
+`)
+
+// RedisLimiter is a token bucket limiter whose state lives in Redis, so the
+// limit is shared by every replica of the service.
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+	rate   float64 // tokens per millisecond
+	burst  int
+	now    func() time.Time
+}
+
+// NewRedisLimiter allows limit requests per period for each key, with bursts
+// of up to burst requests. Keys are namespaced under prefix.
+func NewRedisLimiter(client *redis.Client, prefix string, limit int, period time.Duration, burst int) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		prefix: prefix,
+		rate:   float64(limit) / float64(period.Milliseconds()),
+		burst:  burst,
+		now:    time.Now,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	redisKey := fmt.Sprintf("ratelimit:%s:%s", l.prefix, key)
+	nowMs := l.now().UnixMilli()
+
+	vals, err := tokenBucketScript.Run(ctx, l.client, []string{redisKey},
+		strconv.FormatFloat(l.rate, 'g', -1, 64), l.burst, nowMs).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to evaluate rate limit for key=%s: %w", redisKey, err)
+	}
+	if len(vals) != 3 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result: %v", vals)
+	}
+
+	return Result{
+		Allowed:    vals[0] == 1,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+	}, nil
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests and a
+// Retry-After header. Requests are keyed by keyFunc (e.g. httpx.ClientIP).
+//
+// If the limiter itself fails (e.g. Redis is unreachable) the request is let
+// through: availability of the shortener matters more than strict limiting.
+func Middleware(l Limiter, keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	logger := slog.Default().With("component", "ratelimit")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := l.Allow(r.Context(), keyFunc(r))
+			if err != nil {
+				logger.WarnContext(r.Context(), "rate limit check failed, allowing request", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				apierror.Write(w, http.StatusTooManyRequests, apierror.RateLimited, "Too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
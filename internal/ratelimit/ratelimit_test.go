@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hszk-dev/url-shortener/internal/httpx"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLimiter(t *testing.T, limit int, period time.Duration, burst int) (*RedisLimiter, *time.Time) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewRedisLimiter(client, "test", limit, period, burst)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestRedisLimiter_Allow(t *testing.T) {
+	l, now := newTestLimiter(t, 10, time.Minute, 3)
+	ctx := context.Background()
+
+	// Burst is consumed first
+	for i := 0; i < 3; i++ {
+		res, err := l.Allow(ctx, "1.2.3.4")
+		if err != nil {
+			t.Fatalf("Allow() unexpected error = %v", err)
+		}
+		if !res.Allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+		if res.Remaining != 2-i {
+			t.Errorf("request %d: Remaining = %d, want %d", i+1, res.Remaining, 2-i)
+		}
+	}
+
+	// Bucket empty: rejected with a retry hint of one refill interval (6s)
+	res, err := l.Allow(ctx, "1.2.3.4")
+	if err != nil {
+		t.Fatalf("Allow() unexpected error = %v", err)
+	}
+	if res.Allowed {
+		t.Fatal("request over the limit should be rejected")
+	}
+	if res.RetryAfter != 6*time.Second {
+		t.Errorf("RetryAfter = %v, want 6s", res.RetryAfter)
+	}
+
+	// Other keys have their own bucket
+	res, err = l.Allow(ctx, "5.6.7.8")
+	if err != nil || !res.Allowed {
+		t.Errorf("different key should be allowed, got %+v (err=%v)", res, err)
+	}
+
+	// One token refills after 6 seconds
+	*now = now.Add(6 * time.Second)
+	res, err = l.Allow(ctx, "1.2.3.4")
+	if err != nil || !res.Allowed {
+		t.Errorf("request after refill should be allowed, got %+v (err=%v)", res, err)
+	}
+}
+
+type stubLimiter struct {
+	res Result
+	err error
+}
+
+func (s stubLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return s.res, s.err
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		limiter        Limiter
+		expectedStatus int
+		wantRetryAfter string
+	}{
+		{
+			name:           "allowed",
+			limiter:        stubLimiter{res: Result{Allowed: true, Remaining: 4}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "rejected",
+			limiter:        stubLimiter{res: Result{Allowed: false, RetryAfter: 1500 * time.Millisecond}},
+			expectedStatus: http.StatusTooManyRequests,
+			wantRetryAfter: "2",
+		},
+		{
+			name:           "limiter failure fails open",
+			limiter:        stubLimiter{err: errors.New("redis down")},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := Middleware(tt.limiter, httpx.ClientIP)(next)
+
+			req := httptest.NewRequest("POST", "/api/shorten", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package realip recovers the address of the client behind trusted reverse
+// proxies, so that rate limiting, abuse reports and analytics see visitors
+// rather than the load balancer.
+//
+// Forwarding headers are only believed when the request comes from a trusted
+// proxy; otherwise anyone could pick the IP they are counted as. Without
+// trusted proxies (strict mode), the headers are ignored altogether.
+package realip
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/hszk-dev/url-shortener/internal/httpx"
+)
+
+// Headers a proxy may pass the client address in.
+const (
+	XForwardedFor = "X-Forwarded-For"
+	XRealIP       = "X-Real-IP"
+	Forwarded     = "Forwarded"
+)
+
+// Resolver finds the client address of requests.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// New returns a Resolver believing header (one of the constants in any case;
+// others mean X-Forwarded-For) in requests from the trusted networks. With no
+// trusted networks, the peer address is always the client.
+func New(trusted []netip.Prefix, header string) *Resolver {
+	for _, h := range []string{XRealIP, Forwarded} {
+		if strings.EqualFold(header, h) {
+			header = h
+		}
+	}
+	return &Resolver{trusted: trusted, header: header}
+}
+
+// ClientIP returns the client address of r, or an invalid Addr if its
+// RemoteAddr is not an IP address.
+//
+// For X-Forwarded-For and Forwarded, which list every hop, the list is walked
+// from the right (the hop closest to the server) past trusted proxies: the
+// first untrusted address is the client, as entries to its left may have
+// been made up by the client itself.
+func (res *Resolver) ClientIP(r *http.Request) netip.Addr {
+	peer := httpx.ClientAddr(r)
+	if !peer.IsValid() || !res.isTrusted(peer) {
+		return peer
+	}
+
+	var hops []string
+	switch res.header {
+	case XRealIP:
+		hops = []string{r.Header.Get(XRealIP)}
+	case Forwarded:
+		hops = forwardedFor(r.Header.Values(Forwarded))
+	default:
+		for _, v := range r.Header.Values(XForwardedFor) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr := httpx.ParseAddr(strings.TrimSpace(hops[i]))
+		if !addr.IsValid() {
+			// Garbage or an obfuscated identifier: the last trusted hop is
+			// the best we know
+			break
+		}
+		client = addr
+		if !res.isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// Middleware replaces the RemoteAddr of requests with their client address,
+// so that handlers reading it (e.g. through httpx.ClientIP) need not know
+// about proxies. The port is dropped, as it is the proxy's.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(res.trusted) > 0 {
+			if client := res.ClientIP(r); client.IsValid() {
+				r.RemoteAddr = client.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range res.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for= parameters of the Forwarded header values
+// (RFC 7239) in order, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]"`.
+// Elements without one yield "" so that they end the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(name, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
@@ -0,0 +1,70 @@
+package realip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolver_ClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name       string
+		trusted    []netip.Prefix
+		header     string
+		remoteAddr string
+		values     []string
+		want       string
+	}{
+		{name: "strict mode ignores headers", header: XForwardedFor, remoteAddr: "203.0.113.7:5000", values: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "untrusted peer", trusted: trusted, header: XForwardedFor, remoteAddr: "203.0.113.7:5000", values: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "no header", trusted: trusted, header: XForwardedFor, remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "x-forwarded-for", trusted: trusted, header: XForwardedFor, remoteAddr: "10.0.0.1:5000", values: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed entries on the left", trusted: trusted, header: XForwardedFor, remoteAddr: "10.0.0.1:5000", values: []string{"1.2.3.4, 198.51.100.1, 10.0.0.2"}, want: "198.51.100.1"},
+		{name: "several header lines", trusted: trusted, header: XForwardedFor, remoteAddr: "10.0.0.1:5000", values: []string{"198.51.100.1", "10.0.0.2"}, want: "198.51.100.1"},
+		{name: "garbage stops the walk", trusted: trusted, header: XForwardedFor, remoteAddr: "10.0.0.1:5000", values: []string{"198.51.100.1, bogus, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "only proxies", trusted: trusted, header: XForwardedFor, remoteAddr: "10.0.0.1:5000", values: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "ipv6 peer", trusted: trusted, header: XForwardedFor, remoteAddr: "[fd00::1]:5000", values: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "ipv4-mapped peer", trusted: trusted, header: XForwardedFor, remoteAddr: "[::ffff:10.0.0.1]:5000", values: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "x-real-ip", trusted: trusted, header: XRealIP, remoteAddr: "10.0.0.1:5000", values: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forwarded", trusted: trusted, header: Forwarded, remoteAddr: "10.0.0.1:5000", values: []string{`for=198.51.100.1;proto=https, For="[2001:db8::1]:4711";by=10.0.0.2`}, want: "2001:db8::1"},
+		{name: "forwarded obfuscated", trusted: trusted, header: Forwarded, remoteAddr: "10.0.0.1:5000", values: []string{"for=198.51.100.1, for=_hidden"}, want: "10.0.0.1"},
+		{name: "forwarded without for", trusted: trusted, header: Forwarded, remoteAddr: "10.0.0.1:5000", values: []string{"proto=https"}, want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.values {
+				r.Header.Add(tt.header, v)
+			}
+			if got := New(tt.trusted, tt.header).ClientIP(r); got.String() != tt.want {
+				t.Errorf("ClientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.RemoteAddr })
+
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set(XForwardedFor, "2001:db8::1")
+	New([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, XForwardedFor).Middleware(next).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "2001:db8::1" {
+		t.Errorf("RemoteAddr = %q, want the forwarded client", got)
+	}
+
+	r = httptest.NewRequest("GET", "/abc", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set(XForwardedFor, "2001:db8::1")
+	New(nil, XForwardedFor).Middleware(next).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "10.0.0.1:5000" {
+		t.Errorf("RemoteAddr in strict mode = %q, want the peer", got)
+	}
+}
@@ -0,0 +1,76 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantSame bool
+	}{
+		{name: "propagates incoming ID", incoming: "abc-123", wantSame: true},
+		{name: "generates when missing", incoming: "", wantSame: false},
+		{name: "replaces ID with spaces", incoming: "bad id", wantSame: false},
+		{name: "replaces overly long ID", incoming: strings.Repeat("a", maxLength+1), wantSame: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = FromContext(r.Context())
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(Header, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			Middleware(next).ServeHTTP(w, req)
+
+			got := w.Header().Get(Header)
+			if got == "" {
+				t.Fatal("expected X-Request-ID response header")
+			}
+			if got != ctxID {
+				t.Errorf("response header %q does not match context ID %q", got, ctxID)
+			}
+			if (got == tt.incoming) != tt.wantSame {
+				t.Errorf("got ID %q for incoming %q, wantSame = %v", got, tt.incoming, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))).With("component", "test")
+
+	logger.InfoContext(NewContext(context.Background(), "req-1"), "hello")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log line: %v", err)
+	}
+	if entry["request_id"] != "req-1" {
+		t.Errorf("request_id = %v, want req-1", entry["request_id"])
+	}
+	if entry["component"] != "test" {
+		t.Errorf("component = %v, want test", entry["component"])
+	}
+
+	buf.Reset()
+	logger.Info("no context")
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("unexpected request_id without context: %s", buf.String())
+	}
+}
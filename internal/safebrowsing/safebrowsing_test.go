@@ -0,0 +1,73 @@
+package safebrowsing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_Check(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got := r.Header.Get("X-Goog-Api-Key"); got != "test-key" {
+			t.Errorf("api key header = %q, want test-key", got)
+		}
+		var req findRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if n := len(req.ThreatInfo.ThreatEntries); n > maxURLsPerRequest {
+			t.Errorf("request has %d entries, want at most %d", n, maxURLsPerRequest)
+		}
+
+		var resp findResponse
+		for _, e := range req.ThreatInfo.ThreatEntries {
+			if e.URL == "https://malware.test/" {
+				resp.Matches = append(resp.Matches, struct {
+					ThreatType string      `json:"threatType"`
+					Threat     threatEntry `json:"threat"`
+				}{"MALWARE", e})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	c := NewClient("test-key", time.Second)
+	c.endpoint = srv.URL
+
+	urls := []string{"https://malware.test/"}
+	for i := 0; i < maxURLsPerRequest; i++ {
+		urls = append(urls, fmt.Sprintf("https://example.com/%d", i))
+	}
+
+	threats, err := c.Check(context.Background(), urls)
+	if err != nil {
+		t.Fatalf("Check() unexpected error = %v", err)
+	}
+	if len(threats) != 1 || threats["https://malware.test/"] != "MALWARE" {
+		t.Errorf("Check() = %v, want only malware.test", threats)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+}
+
+func TestClient_Check_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := NewClient("test-key", time.Second)
+	c.endpoint = srv.URL
+
+	if _, err := c.Check(context.Background(), []string{"https://example.com/"}); err == nil {
+		t.Error("Check() expected error for non-200 response")
+	}
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// lockKeyPrefix prefixes the Redis keys of job locks.
+const lockKeyPrefix = "shorturl:lock:job:"
+
+// acquireScript sets the lock to the owner for ARGV[2] milliseconds if it is
+// free or already the owner's, and returns 1 if the owner holds it.
+var acquireScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// RedisLocker is a Locker keeping its locks in Redis, shared by the
+// instances using the same Redis server. Each RedisLocker is a distinct
+// owner, so an instance should use one for all its jobs.
+type RedisLocker struct {
+	client *redis.Client
+	owner  string
+}
+
+// NewRedisLocker returns a locker storing its locks in client under an
+// owner token of its own.
+func NewRedisLocker(client *redis.Client) (*RedisLocker, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate lock owner: %w", err)
+	}
+	return &RedisLocker{client: client, owner: hex.EncodeToString(token)}, nil
+}
+
+// Acquire implements Locker with SET PX, done only if the lock is free or
+// already held by l.
+func (l *RedisLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	held, err := acquireScript.Run(ctx, l.client, []string{lockKeyPrefix + name}, l.owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to set lock %s: %w", name, err)
+	}
+	return held == 1, nil
+}
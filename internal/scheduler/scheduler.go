@@ -0,0 +1,260 @@
+// Package scheduler runs maintenance jobs, such as purging expired links, at
+// fixed intervals and keeps track of how each of them went so that operators
+// can see it without reading logs.
+//
+// A job runs at most once at a time: if a run takes longer than the interval,
+// the next tick is skipped rather than queued. Each run is given a timeout of
+// one interval. Jobs added with Exclusive also run on only one of the
+// instances sharing a Locker.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Task does one run of a job and returns the number of items it processed.
+type Task func(context.Context) (int, error)
+
+// JobStatus describes a job and the outcome of its latest run. Times are
+// nil until the corresponding event has happened.
+type JobStatus struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval"`
+	Running  bool   `json:"running"`
+	Runs     int64  `json:"runs"`
+	Failures int64  `json:"failures"`
+	// Skipped counts the ticks of an Exclusive job that another instance
+	// held the lock of.
+	Skipped int64 `json:"skipped"`
+	// Processed is the number of items processed by all runs.
+	Processed   int64      `json:"processed"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// LastDuration is how long the latest run took, in seconds.
+	LastDuration float64    `json:"last_duration_seconds"`
+	LastCount    int        `json:"last_count"`
+	LastError    string     `json:"last_error,omitempty"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+}
+
+type job struct {
+	name      string
+	interval  time.Duration
+	task      Task
+	immediate bool
+	// locker elects the instance that runs the job; nil runs it on every
+	// instance.
+	locker Locker
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// Scheduler runs jobs until its context is cancelled. Jobs are added before
+// Run is called.
+type Scheduler struct {
+	jobs []*job
+}
+
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Option configures a job.
+type Option func(*job)
+
+// Immediately runs the job once as soon as the scheduler starts instead of
+// waiting for the first interval to elapse.
+func Immediately() Option {
+	return func(j *job) { j.immediate = true }
+}
+
+// Locker elects the instance that runs an Exclusive job.
+type Locker interface {
+	// Acquire takes the lock name for ttl, or extends it if this instance
+	// already holds it, and reports whether this instance holds it.
+	Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error)
+}
+
+// Exclusive runs the job on one instance at a time among those sharing
+// locker. On each tick, the instance holding the lock of the job, or the
+// first to take it once it is free, runs the job and the others skip the
+// tick. The lock lives for one interval and is renewed while a run lasts,
+// so the holder keeps the job until it stops, and another instance takes it
+// over within an interval. Runs that lose the lock are cancelled.
+func Exclusive(locker Locker) Option {
+	return func(j *job) { j.locker = locker }
+}
+
+// Add registers task to run every interval under name. Names need not be
+// unique; jobs sharing a name are reported separately.
+func (s *Scheduler) Add(name string, interval time.Duration, task Task, opts ...Option) {
+	j := &job{name: name, interval: interval, task: task}
+	for _, opt := range opts {
+		opt(j)
+	}
+	j.status = JobStatus{Name: name, Interval: interval.String()}
+	s.jobs = append(s.jobs, j)
+}
+
+// Run starts every job and blocks until ctx is cancelled and the runs in
+// progress have returned.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	if j.immediate {
+		s.runOnce(ctx, j)
+	} else {
+		next := time.Now().Add(j.interval)
+		j.mu.Lock()
+		j.status.NextRun = &next
+		j.mu.Unlock()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			j.mu.Lock()
+			j.status.NextRun = nil
+			j.mu.Unlock()
+			return
+		case <-ticker.C:
+			s.runOnce(ctx, j)
+		}
+	}
+}
+
+// runOnce runs the task of j with a timeout of its interval, logs the
+// outcome and records it in the status of j, along with the time of the
+// next tick. Exclusive jobs first take their lock, and skip the tick if
+// another instance holds it.
+func (s *Scheduler) runOnce(ctx context.Context, j *job) {
+	start := time.Now()
+	if j.locker != nil {
+		held, err := j.locker.Acquire(ctx, j.name, j.interval)
+		if err != nil {
+			j.finish(start, time.Now(), 0, fmt.Errorf("failed to acquire lock: %w", err))
+			return
+		}
+		if !held {
+			j.skip(start)
+			return
+		}
+	}
+	j.mu.Lock()
+	j.status.Running = true
+	j.status.LastRun = &start
+	j.mu.Unlock()
+
+	taskCtx, cancel := context.WithTimeout(ctx, j.interval)
+	renewed := make(chan struct{})
+	if j.locker != nil {
+		go func() {
+			defer close(renewed)
+			j.renew(taskCtx, cancel)
+		}()
+	} else {
+		close(renewed)
+	}
+	n, err := j.task(taskCtx)
+	cancel()
+	<-renewed
+	j.finish(start, time.Now(), n, err)
+}
+
+// renew extends the lock of j every third of its interval until ctx is
+// done, and calls cancel if another instance has taken the lock. Failing to
+// reach the locker is logged: the lock then still holds until it expires.
+func (j *job) renew(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(j.interval / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			held, err := j.locker.Acquire(ctx, j.name, j.interval)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("failed to renew background task lock", "task", j.name, "error", err)
+				}
+				continue
+			}
+			if !held {
+				slog.Error("background task lock lost, cancelling run", "task", j.name)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// skip records that j did not run on the tick at start because another
+// instance holds its lock.
+func (j *job) skip(start time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Skipped++
+	next := start.Add(j.interval)
+	j.status.NextRun = &next
+}
+
+// finish records a run of j from start to end that processed n items and
+// failed with err, if not nil.
+func (j *job) finish(start, end time.Time, n int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Running = false
+	j.status.LastRun = &start
+	j.status.Runs++
+	j.status.Processed += int64(n)
+	j.status.LastDuration = end.Sub(start).Seconds()
+	j.status.LastCount = n
+	// A run that overran its interval is followed right away by the tick
+	// it missed
+	next := start.Add(j.interval)
+	if next.Before(end) {
+		next = end
+	}
+	j.status.NextRun = &next
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+		slog.Error("background task failed", "task", j.name, "error", err)
+		return
+	}
+	j.status.LastError = ""
+	j.status.LastSuccess = &end
+	if n > 0 {
+		slog.Info("background task completed", "task", j.name, "count", n)
+	}
+}
+
+// Status reports every job, sorted by name.
+func (s *Scheduler) Status() []JobStatus {
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		statuses = append(statuses, j.status)
+		j.mu.Unlock()
+	}
+	sort.SliceStable(statuses, func(i, k int) bool { return statuses[i].Name < statuses[k].Name })
+	return statuses
+}
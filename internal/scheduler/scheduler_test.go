@@ -0,0 +1,248 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestScheduler(t *testing.T) {
+	s := New()
+	var calls atomic.Int64
+	s.Add("purge", 10*time.Millisecond, func(ctx context.Context) (int, error) {
+		if calls.Add(1)%2 == 0 {
+			return 0, errors.New("database unavailable")
+		}
+		return 3, nil
+	})
+	started := make(chan struct{})
+	s.Add("warm", time.Hour, func(ctx context.Context) (int, error) {
+		close(started)
+		return 5, nil
+	}, Immediately())
+
+	if got := s.Status(); len(got) != 2 || got[0].Name != "purge" || got[0].Runs != 0 || got[0].LastRun != nil {
+		t.Fatalf("Status() before Run() = %+v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("job added with Immediately() did not run at start")
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	statuses := s.Status()
+	purge, warm := statuses[0], statuses[1]
+	if purge.Runs < 4 || purge.Failures != purge.Runs/2 || purge.Processed != 3*(purge.Runs-purge.Failures) {
+		t.Errorf("purge status = %+v, want every other run failed", purge)
+	}
+	if purge.LastRun == nil || purge.LastSuccess == nil || purge.NextRun != nil || purge.Running {
+		t.Errorf("purge status times = %+v", purge)
+	}
+	if purge.Runs%2 == 0 && purge.LastError != "database unavailable" {
+		t.Errorf("purge LastError = %q after a failed run", purge.LastError)
+	}
+	if warm.Runs != 1 || warm.LastCount != 5 || warm.Interval != "1h0m0s" || warm.LastError != "" {
+		t.Errorf("warm status = %+v, want one run of 5 items", warm)
+	}
+}
+
+func TestScheduler_Timeout(t *testing.T) {
+	s := New()
+	errc := make(chan error, 1)
+	s.Add("slow", 20*time.Millisecond, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		select {
+		case errc <- ctx.Err():
+		default:
+		}
+		return 0, ctx.Err()
+	}, Immediately())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go s.Run(ctx)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("task context error = %v, want DeadlineExceeded", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("run was not cancelled after its interval")
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+
+	a, err := NewRedisLocker(client)
+	if err != nil {
+		t.Fatalf("NewRedisLocker() error = %v", err)
+	}
+	b, err := NewRedisLocker(client)
+	if err != nil {
+		t.Fatalf("NewRedisLocker() error = %v", err)
+	}
+
+	steps := []struct {
+		name    string
+		locker  *RedisLocker
+		advance time.Duration
+		want    bool
+	}{
+		{name: "free lock", locker: a, want: true},
+		{name: "held by another", locker: b, want: false},
+		{name: "extended by the holder", locker: a, advance: 40 * time.Second, want: true},
+		{name: "still held after the first ttl", locker: b, advance: 30 * time.Second, want: false},
+		{name: "expired", locker: b, advance: time.Minute, want: true},
+		{name: "taken over", locker: a, want: false},
+	}
+	for _, step := range steps {
+		mr.FastForward(step.advance)
+		held, err := step.locker.Acquire(ctx, "reaper", time.Minute)
+		if err != nil || held != step.want {
+			t.Errorf("%s: Acquire() = %v, %v, want %v", step.name, held, err, step.want)
+		}
+	}
+	if ttl := mr.TTL(lockKeyPrefix + "reaper"); ttl != time.Minute {
+		t.Errorf("lock TTL = %v, want 1m", ttl)
+	}
+	if held, err := a.Acquire(ctx, "cache_clean", time.Minute); err != nil || !held {
+		t.Errorf("Acquire() of another job = %v, %v, want held", held, err)
+	}
+}
+
+func TestScheduler_Exclusive(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	// Two instances schedule the same job
+	var runs [2]atomic.Int64
+	instances := make([]*Scheduler, 2)
+	for i := range instances {
+		locker, err := NewRedisLocker(client)
+		if err != nil {
+			t.Fatalf("NewRedisLocker() error = %v", err)
+		}
+		instances[i] = New()
+		instances[i].Add("reaper", 10*time.Millisecond, func(ctx context.Context) (int, error) {
+			runs[i].Add(1)
+			return 1, nil
+		}, Exclusive(locker))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, len(instances))
+	for _, s := range instances {
+		go func() {
+			s.Run(ctx)
+			done <- struct{}{}
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runs[0].Load()+runs[1].Load() < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	for range instances {
+		<-done
+	}
+
+	// The lock never expires in miniredis, so the first to take it keeps it
+	leader, follower := 0, 1
+	if runs[1].Load() > 0 {
+		leader, follower = 1, 0
+	}
+	if runs[leader].Load() < 5 || runs[follower].Load() != 0 {
+		t.Errorf("runs = %d, %d, want every run on one instance", runs[0].Load(), runs[1].Load())
+	}
+	status := instances[follower].Status()[0]
+	if status.Runs != 0 || status.Skipped == 0 || status.LastRun != nil {
+		t.Errorf("follower status = %+v, want skipped ticks only", status)
+	}
+	if status := instances[leader].Status()[0]; status.Runs != runs[leader].Load() || status.Skipped != 0 {
+		t.Errorf("leader status = %+v, want %d runs", status, runs[leader].Load())
+	}
+}
+
+// stubLocker holds locks while held is true, and fails with err if set.
+type stubLocker struct {
+	held atomic.Bool
+	err  error
+}
+
+func (l *stubLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return l.held.Load(), l.err
+}
+
+func TestScheduler_ExclusiveLock(t *testing.T) {
+	tests := []struct {
+		name string
+		// lose gives the lock up once the run has started
+		lose    bool
+		err     error
+		wantErr string
+	}{
+		{name: "lock lost", lose: true, wantErr: context.Canceled.Error()},
+		{name: "locker unavailable", err: errors.New("connection refused"), wantErr: "failed to acquire lock: connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locker := &stubLocker{err: tt.err}
+			locker.held.Store(tt.err == nil)
+			s := New()
+			ran := make(chan struct{}, 1)
+			s.Add("slow", 30*time.Millisecond, func(ctx context.Context) (int, error) {
+				if tt.lose {
+					locker.held.Store(false)
+				}
+				<-ctx.Done()
+				ran <- struct{}{}
+				return 0, ctx.Err()
+			}, Exclusive(locker), Immediately())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				s.Run(ctx)
+				close(done)
+			}()
+			deadline := time.Now().Add(time.Second)
+			for s.Status()[0].Runs == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			status := s.Status()[0]
+			if status.Failures == 0 || status.LastError != tt.wantErr {
+				t.Errorf("status = %+v, want a failed run with %q", status, tt.wantErr)
+			}
+			if tt.lose && status.LastDuration >= (30*time.Millisecond).Seconds() {
+				t.Errorf("run took %vs, want it cancelled before its timeout", status.LastDuration)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// MaxAccessReferrers is the maximum number of referrer domains of a
+	// link.
+	MaxAccessReferrers = 100
+	// MaxAccessNetworks is the maximum number of networks a link allows or
+	// denies, each.
+	MaxAccessNetworks = 1000
+	// MaxAccessCountries is the maximum number of countries a link allows
+	// or denies, each.
+	MaxAccessCountries = 250
+)
+
+var (
+	ErrInvalidAccess = errors.New("invalid access rules")
+	// ErrAccessDenied is returned for visitors the Access rules of a link
+	// turn away by referrer or network.
+	ErrAccessDenied = errors.New("url is not available to this visitor")
+	// ErrRegionUnavailable is returned for visitors the Access rules of a
+	// link turn away by country.
+	ErrRegionUnavailable = errors.New("url is not available in this region")
+)
+
+// Access restricts who a link redirects. Rules left empty let everybody
+// through.
+type Access struct {
+	// Referrers are the sites visitors must come from: the hostname of
+	// their Referer header must be one of these domains or a subdomain of
+	// one. Visitors without a Referer are turned away.
+	Referrers []string `json:"referrers,omitempty"`
+	// Networks are the IP networks, in CIDR notation, visitors must connect
+	// from. A single address stands for a network of its own.
+	Networks []string `json:"networks,omitempty"`
+	// DeniedNetworks are IP networks visitors are turned away from, even
+	// within Networks.
+	DeniedNetworks []string `json:"denied_networks,omitempty"`
+	// Countries are the ISO 3166-1 alpha-2 codes of the countries visitors
+	// must be located in. Visitors whose country is unknown are turned
+	// away.
+	Countries []string `json:"countries,omitempty"`
+	// DeniedCountries are the countries visitors are turned away from.
+	DeniedCountries []string `json:"denied_countries,omitempty"`
+
+	// networks and deniedNetworks are Networks and DeniedNetworks compiled
+	// for matching, set by normalize and when decoding.
+	networks, deniedNetworks networkSet
+}
+
+// UnmarshalJSON decodes a and compiles its networks.
+func (a *Access) UnmarshalJSON(data []byte) error {
+	type plain Access
+	if err := json.Unmarshal(data, (*plain)(a)); err != nil {
+		return err
+	}
+	a.compile()
+	return nil
+}
+
+// compile compiles the networks of a for matching.
+func (a *Access) compile() {
+	a.networks, a.deniedNetworks = newNetworkSet(a.Networks), newNetworkSet(a.DeniedNetworks)
+}
+
+// networkSets returns the compiled networks of a, compiling them if a was
+// built rather than normalized or decoded.
+func (a *Access) networkSets() (allowed, denied networkSet) {
+	allowed, denied = a.networks, a.deniedNetworks
+	if allowed == nil && len(a.Networks) > 0 {
+		allowed = newNetworkSet(a.Networks)
+	}
+	if denied == nil && len(a.DeniedNetworks) > 0 {
+		denied = newNetworkSet(a.DeniedNetworks)
+	}
+	return allowed, denied
+}
+
+// empty reports whether a restricts nothing.
+func (a *Access) empty() bool {
+	return len(a.Referrers) == 0 && len(a.Networks) == 0 && len(a.DeniedNetworks) == 0 &&
+		len(a.Countries) == 0 && len(a.DeniedCountries) == 0
+}
+
+// normalize converts the referrer domains of a to lowercase punycode, its
+// networks to canonical CIDR notation and its countries to upper case,
+// dropping duplicates, checks them against the limits and compiles the
+// networks.
+func (a *Access) normalize() error {
+	if len(a.Referrers) > MaxAccessReferrers {
+		return fmt.Errorf("%w: %d referrers, at most %d are allowed", ErrInvalidAccess, len(a.Referrers), MaxAccessReferrers)
+	}
+	var referrers []string
+	seen := make(map[string]bool, len(a.Referrers))
+	for _, r := range a.Referrers {
+		ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(r), "."))
+		if err == nil {
+			ascii, err = NormalizeDomain(ascii)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: referrer %q is not a domain name", ErrInvalidAccess, r)
+		}
+		if !seen[ascii] {
+			seen[ascii] = true
+			referrers = append(referrers, ascii)
+		}
+	}
+	a.Referrers = referrers
+	var err error
+	if a.Networks, err = normalizeNetworks(a.Networks); err != nil {
+		return err
+	}
+	if a.DeniedNetworks, err = normalizeNetworks(a.DeniedNetworks); err != nil {
+		return err
+	}
+	if a.Countries, err = normalizeCountries(a.Countries); err != nil {
+		return err
+	}
+	if a.DeniedCountries, err = normalizeCountries(a.DeniedCountries); err != nil {
+		return err
+	}
+	a.compile()
+	return nil
+}
+
+// normalizeNetworks converts networks to canonical CIDR notation, dropping
+// duplicates.
+func normalizeNetworks(networks []string) ([]string, error) {
+	if len(networks) > MaxAccessNetworks {
+		return nil, fmt.Errorf("%w: %d networks, at most %d are allowed", ErrInvalidAccess, len(networks), MaxAccessNetworks)
+	}
+	var normalized []string
+	seen := make(map[string]bool, len(networks))
+	for _, n := range networks {
+		prefix, err := parseNetwork(n)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a network in CIDR notation or an IP address", ErrInvalidAccess, n)
+		}
+		if s := prefix.String(); !seen[s] {
+			seen[s] = true
+			normalized = append(normalized, s)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeCountries upper-cases countries, dropping duplicates.
+func normalizeCountries(countries []string) ([]string, error) {
+	if len(countries) > MaxAccessCountries {
+		return nil, fmt.Errorf("%w: %d countries, at most %d are allowed", ErrInvalidAccess, len(countries), MaxAccessCountries)
+	}
+	var normalized []string
+	for _, c := range countries {
+		country := strings.ToUpper(strings.TrimSpace(c))
+		if !isCountryCode(country) {
+			return nil, fmt.Errorf("%w: %q is not a two-letter country code", ErrInvalidAccess, c)
+		}
+		if !slices.Contains(normalized, country) {
+			normalized = append(normalized, country)
+		}
+	}
+	return normalized, nil
+}
+
+// check returns ErrAccessDenied or ErrRegionUnavailable unless a lets v
+// through. A nil a lets everybody through.
+func (a *Access) check(v Visitor) error {
+	if a == nil {
+		return nil
+	}
+	if len(a.Referrers) > 0 && !matchesDomain(v.Referrer, a.Referrers) {
+		return fmt.Errorf("%w: referrer %q is not allowed", ErrAccessDenied, v.Referrer)
+	}
+	allowed, denied := a.networkSets()
+	if len(a.Networks) > 0 && !allowed.contains(v.Addr) {
+		return fmt.Errorf("%w: address %s is not in an allowed network", ErrAccessDenied, v.Addr)
+	}
+	if denied.contains(v.Addr) {
+		return fmt.Errorf("%w: address %s is in a denied network", ErrAccessDenied, v.Addr)
+	}
+	if (len(a.Countries) > 0 && !slices.Contains(a.Countries, v.Country)) || slices.Contains(a.DeniedCountries, v.Country) {
+		return fmt.Errorf("%w: country %q", ErrRegionUnavailable, v.Country)
+	}
+	return nil
+}
+
+// ReferrerHost returns the lowercased hostname of referer, the value of a
+// Referer header, or "" if it has none.
+func ReferrerHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of
+// one.
+func matchesDomain(host string, domains []string) bool {
+	if host == "" {
+		return false
+	}
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAccess returns ErrAccessDenied or ErrRegionUnavailable unless the
+// Access rules of link let v follow it. RedirectVisitor and Peek check
+// them; callers showing where a link leads by other means check them with
+// CheckAccess.
+func (s *Service) CheckAccess(link *Link, v Visitor) error {
+	return link.Access.check(v)
+}
+
+// SetAccess replaces the access rules of the link behind shortCode, which
+// user must own or manage through an organization. Empty rules remove
+// them. Returns ErrInvalidAccess for rules that are malformed or over the
+// limits.
+func (s *Service) SetAccess(ctx context.Context, user *User, shortCode string, a *Access) (*Link, error) {
+	if a != nil {
+		if err := a.normalize(); err != nil {
+			return nil, err
+		}
+		if a.empty() {
+			a = nil
+		}
+	}
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetAccess(ctx, link, a); err != nil {
+		return nil, fmt.Errorf("failed to set access rules: %w", err)
+	}
+	link.Access = a
+	s.audit(ctx, UserActor(user), AuditSetAccess, link.ShortCode(), "")
+	return link, nil
+}
+
+// marshalAccess encodes a for the access column; nil is stored as NULL.
+func marshalAccess(a *Access) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// unmarshalAccess decodes the access column.
+func unmarshalAccess(data []byte) (*Access, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var a Access
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to decode access rules: %w", err)
+	}
+	return &a, nil
+}
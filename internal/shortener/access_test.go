@@ -0,0 +1,239 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestService_SetAccess(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	bob, _, err := svc.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/sale", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	tooMany := make([]string, MaxAccessReferrers+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("site%d.example.com", i)
+	}
+
+	tests := []struct {
+		name    string
+		user    *User
+		access  *Access
+		want    *Access
+		wantErr error
+	}{
+		{
+			name:   "referrers are normalized",
+			user:   alice,
+			access: &Access{Referrers: []string{" News.Example.com. ", "bücher.example", "news.example.com"}},
+			want:   &Access{Referrers: []string{"news.example.com", "xn--bcher-kva.example"}},
+		},
+		{
+			name:   "networks are normalized",
+			user:   alice,
+			access: &Access{Networks: []string{"10.1.2.3/8", "2001:DB8::/32", "::ffff:192.0.2.1", "10.0.0.0/8"}, DeniedNetworks: []string{"198.51.100.7"}},
+			want:   &Access{Networks: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1/32"}, DeniedNetworks: []string{"198.51.100.7/32"}},
+		},
+		{
+			name:   "countries are upper-cased",
+			user:   alice,
+			access: &Access{Countries: []string{"de", " AT", "DE"}, DeniedCountries: []string{"us"}},
+			want:   &Access{Countries: []string{"DE", "AT"}, DeniedCountries: []string{"US"}},
+		},
+		{name: "not a country", user: alice, access: &Access{Countries: []string{"DEU"}}, wantErr: ErrInvalidAccess},
+		{name: "not a network", user: alice, access: &Access{DeniedNetworks: []string{"10.0.0.0/33"}}, wantErr: ErrInvalidAccess},
+		{name: "not a domain", user: alice, access: &Access{Referrers: []string{"https://news.example.com/"}}, wantErr: ErrInvalidAccess},
+		{name: "too many referrers", user: alice, access: &Access{Referrers: tooMany}, wantErr: ErrInvalidAccess},
+		{name: "another user", user: bob, access: &Access{Referrers: []string{"example.com"}}, wantErr: ErrForbidden},
+		{name: "empty rules remove them", user: alice, access: &Access{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := svc.SetAccess(ctx, tt.user, code, tt.access)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetAccess() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !sameAccess(link.Access, tt.want) {
+				t.Errorf("SetAccess() access = %+v, want %+v", link.Access, tt.want)
+			}
+			stored, err := svc.OwnedLink(ctx, alice, code)
+			if err != nil || !sameAccess(stored.Access, tt.want) {
+				t.Errorf("stored access = %+v, %v, want %+v", stored.Access, err, tt.want)
+			}
+		})
+	}
+}
+
+// sameAccess reports whether the rules of a and b are the same, leaving
+// their compiled networks aside.
+func sameAccess(a, b *Access) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return slices.Equal(a.Referrers, b.Referrers) && slices.Equal(a.Networks, b.Networks) && slices.Equal(a.DeniedNetworks, b.DeniedNetworks) &&
+		slices.Equal(a.Countries, b.Countries) && slices.Equal(a.DeniedCountries, b.DeniedCountries)
+}
+
+func TestService_Access(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	// Visitors turned away do not count against the click limit
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/offer", ShortenOptions{Owner: alice, MaxClicks: 2})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := svc.SetAccess(ctx, alice, code, &Access{Referrers: []string{"news.example.com"}}); err != nil {
+		t.Fatalf("SetAccess() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		referrer string
+		wantErr  error
+	}{
+		{name: "other domain", referrer: "othernews.example.com", wantErr: ErrAccessDenied},
+		{name: "parent domain", referrer: "example.com", wantErr: ErrAccessDenied},
+		{name: "no referrer", wantErr: ErrAccessDenied},
+		{name: "listed domain", referrer: "news.example.com"},
+		{name: "subdomain", referrer: "mail.news.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Visitor{Referrer: tt.referrer}
+			if _, err := svc.Peek(ctx, code, v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Peek() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := svc.RedirectVisitor(ctx, code, v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RedirectVisitor() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_AccessNetworks(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	code, err := svc.ShortenWithOptions(ctx, "https://wiki.internal.example.com/", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	access := &Access{Networks: []string{"10.0.0.0/8", "2001:db8::/32"}, DeniedNetworks: []string{"10.66.0.0/16"}}
+	if _, err := svc.SetAccess(ctx, alice, code, access); err != nil {
+		t.Fatalf("SetAccess() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr error
+	}{
+		{name: "allowed network", addr: "10.1.2.3"},
+		{name: "allowed IPv6 network", addr: "2001:db8::1"},
+		{name: "IPv4-mapped address", addr: "::ffff:10.1.2.3"},
+		{name: "denied within allowed", addr: "10.66.1.1", wantErr: ErrAccessDenied},
+		{name: "outside", addr: "192.0.2.1", wantErr: ErrAccessDenied},
+		{name: "unknown address", wantErr: ErrAccessDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Visitor
+			if tt.addr != "" {
+				v.Addr = netip.MustParseAddr(tt.addr)
+			}
+			if _, err := svc.RedirectVisitor(ctx, code, v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RedirectVisitor() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_AccessCountries(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	allowed, err := svc.ShortenWithOptions(ctx, "https://example.com/stream", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := svc.SetAccess(ctx, alice, allowed, &Access{Countries: []string{"DE", "AT"}}); err != nil {
+		t.Fatalf("SetAccess() error = %v", err)
+	}
+	denied, err := svc.ShortenWithOptions(ctx, "https://example.com/offer", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := svc.SetAccess(ctx, alice, denied, &Access{DeniedCountries: []string{"US"}}); err != nil {
+		t.Fatalf("SetAccess() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		country string
+		wantErr error
+	}{
+		{name: "allowed country", code: allowed, country: "AT"},
+		{name: "country not allowed", code: allowed, country: "US", wantErr: ErrRegionUnavailable},
+		{name: "unknown country not allowed", code: allowed, wantErr: ErrRegionUnavailable},
+		{name: "denied country", code: denied, country: "US", wantErr: ErrRegionUnavailable},
+		{name: "other country", code: denied, country: "DE"},
+		{name: "unknown country not denied", code: denied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Visitor{Country: tt.country}
+			if _, err := svc.Peek(ctx, tt.code, v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Peek() error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := svc.RedirectVisitor(ctx, tt.code, v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RedirectVisitor() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReferrerHost(t *testing.T) {
+	tests := []struct {
+		referer string
+		want    string
+	}{
+		{referer: "https://News.Example.com/issue/42?utm_source=mail", want: "news.example.com"},
+		{referer: "https://news.example.com.:8443/", want: "news.example.com"},
+		{referer: "", want: ""},
+		{referer: "android-app://com.example.mail/", want: "com.example.mail"},
+		{referer: "%zz", want: ""},
+	}
+	for _, tt := range tests {
+		if got := ReferrerHost(tt.referer); got != tt.want {
+			t.Errorf("ReferrerHost(%q) = %q, want %q", tt.referer, got, tt.want)
+		}
+	}
+}
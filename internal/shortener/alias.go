@@ -0,0 +1,78 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 32
+)
+
+var (
+	ErrInvalidAlias = errors.New("invalid custom alias")
+	ErrAliasTaken   = errors.New("custom alias already in use")
+)
+
+// reservedAliases collide with top-level routes served by the HTTP layer.
+// A link stored under one of these codes could never be reached.
+var reservedAliases = map[string]struct{}{
+	"api":    {},
+	"docs":   {},
+	"health": {},
+}
+
+// ValidateAlias checks that a user-chosen short code is well-formed and
+// not one of the routes of the service, which are reserved
+// (ErrAliasReserved). Allowed characters are the Base62 alphabet plus '-'
+// and '_'.
+func ValidateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return fmt.Errorf("%w: length must be between %d and %d characters",
+			ErrInvalidAlias, minAliasLength, maxAliasLength)
+	}
+
+	for i := 0; i < len(alias); i++ {
+		if !isAliasChar(alias[i]) {
+			return fmt.Errorf("%w: character %q at position %d is not allowed (use a-z, A-Z, 0-9, '-', '_')",
+				ErrInvalidAlias, alias[i], i)
+		}
+	}
+
+	if _, reserved := reservedAliases[alias]; reserved {
+		return fmt.Errorf("%w: %q is a route of the service", ErrAliasReserved, alias)
+	}
+
+	return nil
+}
+
+func isAliasChar(c byte) bool {
+	return (c >= '0' && c <= '9') ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		c == '-' || c == '_'
+}
+
+// idFromCode returns the ID a short code maps to when the code is the
+// canonical Base62 encoding of an ID that fits in a BIGINT column.
+//
+// Short codes live in two namespaces:
+//   - ID-derived codes: Encode(id). Custom aliases that happen to be canonical
+//     Base62 strings are pinned to the matching ID, so the primary key alone
+//     guarantees they never collide with generated codes.
+//   - Free-form codes: everything else (e.g. "spring-sale"), stored in the
+//     unique code column.
+//
+// Non-canonical inputs (leading zeros, overflowing values) return false.
+func idFromCode(code string) (uint64, bool) {
+	id, err := Decode(code)
+	if err != nil || id > math.MaxInt64 {
+		return 0, false
+	}
+	if Encode(id) != code {
+		return 0, false
+	}
+	return id, true
+}
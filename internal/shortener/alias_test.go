@@ -0,0 +1,74 @@
+package shortener
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   string
+		wantErr error
+	}{
+		{name: "alphanumeric", alias: "promo2024"},
+		{name: "with hyphen and underscore", alias: "spring-sale_24"},
+		{name: "minimum length", alias: "abc"},
+		{name: "maximum length", alias: strings.Repeat("a", maxAliasLength)},
+		{name: "too short", alias: "ab", wantErr: ErrInvalidAlias},
+		{name: "too long", alias: strings.Repeat("a", maxAliasLength+1), wantErr: ErrInvalidAlias},
+		{name: "space", alias: "my link", wantErr: ErrInvalidAlias},
+		{name: "slash", alias: "a/b/c", wantErr: ErrInvalidAlias},
+		{name: "unicode", alias: "café", wantErr: ErrInvalidAlias},
+		{name: "reserved route", alias: "health", wantErr: ErrAliasReserved},
+		{name: "reserved api prefix", alias: "api", wantErr: ErrAliasReserved},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAlias(tt.alias)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateAlias(%q) error = %v, want %v", tt.alias, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIDFromCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		wantID uint64
+		wantOK bool
+	}{
+		{name: "generated code", code: "3d7", wantID: 12345, wantOK: true},
+		{name: "zero", code: "0", wantID: 0, wantOK: true},
+		{name: "alphanumeric alias", code: "promo", wantID: mustDecode(t, "promo"), wantOK: true},
+		{name: "leading zero is not canonical", code: "03d7", wantOK: false},
+		{name: "hyphenated alias", code: "spring-sale", wantOK: false},
+		{name: "exceeds BIGINT range", code: "ZZZZZZZZZZZ", wantOK: false},
+		{name: "empty", code: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotID, gotOK := idFromCode(tt.code)
+			if gotOK != tt.wantOK {
+				t.Fatalf("idFromCode(%q) ok = %v, want %v", tt.code, gotOK, tt.wantOK)
+			}
+			if gotOK && gotID != tt.wantID {
+				t.Errorf("idFromCode(%q) = %d, want %d", tt.code, gotID, tt.wantID)
+			}
+		})
+	}
+}
+
+func mustDecode(t *testing.T, code string) uint64 {
+	t.Helper()
+	id, err := Decode(code)
+	if err != nil {
+		t.Fatalf("Decode(%q) failed: %v", code, err)
+	}
+	return id
+}
@@ -0,0 +1,147 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ErrInvalidAlphabet is returned for unknown alphabet names, for alphabets
+// requested together with a custom alias and for alphabets the code strategy
+// cannot write 63-bit IDs with.
+var ErrInvalidAlphabet = errors.New("invalid alphabet")
+
+// Alphabet is a set of characters short codes are written with. Every
+// alphabet is a subset of the Base62 one without '0', so a code written in
+// any of them is also the canonical Base62 code of some ID: links keep
+// being stored and resolved by ID whatever alphabet their code uses.
+type Alphabet struct {
+	name  string
+	chars string
+	// index maps a byte to its digit value plus one; zero means invalid.
+	index [256]byte
+}
+
+var (
+	// Base62 is the default alphabet of digits and both letter cases.
+	Base62 = newAlphabet("base62", alphabet)
+	// Base58 leaves out the characters easily confused in print: 0, O, I
+	// and l.
+	Base58 = newAlphabet("base58", "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+	// Base32 is lowercase only and also leaves out 1 and o, for codes that
+	// are read aloud or typed in. Lookups fall back to the lowercase code,
+	// so these codes work whatever case they are typed in.
+	Base32 = newAlphabet("base32", "23456789abcdefghijkmnpqrstuvwxyz")
+)
+
+// alphabets are the alphabets by name.
+var alphabets = map[string]*Alphabet{
+	Base62.name: Base62,
+	Base58.name: Base58,
+	Base32.name: Base32,
+}
+
+func newAlphabet(name, chars string) *Alphabet {
+	a := &Alphabet{name: name, chars: chars}
+	for i := 0; i < len(chars); i++ {
+		a.index[chars[i]] = byte(i + 1)
+	}
+	return a
+}
+
+// AlphabetByName returns the alphabet called name ("base62", "base58" or
+// "base32"), or ErrInvalidAlphabet.
+func AlphabetByName(name string) (*Alphabet, error) {
+	a, ok := alphabets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: must be one of base62, base58 or base32", ErrInvalidAlphabet)
+	}
+	return a, nil
+}
+
+// Name returns the name of the alphabet, e.g. "base58".
+func (a *Alphabet) Name() string {
+	return a.name
+}
+
+// Encode writes n with the characters of a, most significant digit first.
+func (a *Alphabet) Encode(n uint64) string {
+	if n < uint64(len(a.chars)) {
+		return a.chars[n : n+1]
+	}
+	var buf [maxEncodedLen]byte
+	return string(a.AppendEncode(buf[:0], n))
+}
+
+// AppendEncode appends the code of n to dst, as written by Encode, without
+// allocating if dst has room for it.
+func (a *Alphabet) AppendEncode(dst []byte, n uint64) []byte {
+	base := uint64(len(a.chars))
+	var buf [maxEncodedLen]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = a.chars[n%base]
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+	return append(dst, buf[i:]...)
+}
+
+// Decode is the inverse of Encode. Characters outside a are reported with
+// an *InvalidCharError.
+func (a *Alphabet) Decode(encoded string) (uint64, error) {
+	if encoded == "" {
+		return 0, errEmptyCode
+	}
+
+	base := uint64(len(a.chars))
+	var n uint64
+	for i := 0; i < len(encoded); i++ {
+		digit := a.index[encoded[i]]
+		if digit == 0 {
+			char, _ := utf8.DecodeRuneInString(encoded[i:])
+			return 0, &InvalidCharError{Char: char, Pos: i, Alphabet: a.name}
+		}
+		n = n*base + uint64(digit-1)
+	}
+	return n, nil
+}
+
+// alphabetCodes pins the links of another strategy to the ID whose Base62
+// code is the one it would have got, written with Alphabet instead.
+// Strategies that leave the ID to the repository get a pinned ID from
+// Repository.NextID.
+type alphabetCodes struct {
+	codes    CodeStrategy
+	alphabet *Alphabet
+}
+
+func (g alphabetCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	// Long codes can map to IDs beyond BIGINT; random codes may draw again
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		link.ID = 0
+		if err := g.codes.Assign(ctx, repo, link); err != nil {
+			return err
+		}
+		n := link.ID
+		if n == 0 {
+			var err error
+			if n, err = repo.NextID(ctx); err != nil {
+				return err
+			}
+		}
+		// Decode wraps around on overflow, which re-encoding catches
+		code := g.alphabet.Encode(n)
+		id, err := Decode(code)
+		if err == nil && id <= math.MaxInt64 && Encode(id) == code {
+			link.ID = id
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no %s code fits in a 63-bit id", ErrInvalidAlphabet, g.alphabet.name)
+}
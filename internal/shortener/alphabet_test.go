@@ -0,0 +1,184 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestAlphabet_Bijection(t *testing.T) {
+	numbers := []uint64{0, 1, 31, 32, 57, 58, 61, 62, 1000, 1<<32 - 1, math.MaxInt64, math.MaxUint64}
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 1000; i++ {
+		numbers = append(numbers, r.Uint64()>>r.IntN(64))
+	}
+
+	for _, a := range []*Alphabet{Base62, Base58, Base32} {
+		seen := make(map[string]uint64)
+		for _, n := range numbers {
+			code := a.Encode(n)
+			if strings.Trim(code, a.chars) != "" {
+				t.Errorf("%s: Encode(%d) = %q uses characters outside the alphabet", a.Name(), n, code)
+			}
+			got, err := a.Decode(code)
+			if err != nil || got != n {
+				t.Errorf("%s: Decode(Encode(%d)) = %d, %v", a.Name(), n, got, err)
+			}
+			if prev, ok := seen[code]; ok && prev != n {
+				t.Errorf("%s: %d and %d both encode to %q", a.Name(), prev, n, code)
+			}
+			seen[code] = n
+		}
+
+		// Every code without leading zero digits is the encoding of its value
+		for i := 0; i < 1000; i++ {
+			code := []byte{a.chars[1+r.IntN(len(a.chars)-1)]}
+			for j := r.IntN(9); j > 0; j-- {
+				code = append(code, a.chars[r.IntN(len(a.chars))])
+			}
+			n, err := a.Decode(string(code))
+			if err != nil || a.Encode(n) != string(code) {
+				t.Errorf("%s: Encode(Decode(%q)) = %q, %v", a.Name(), code, a.Encode(n), err)
+			}
+		}
+	}
+}
+
+func TestAlphabet_MatchesBase62(t *testing.T) {
+	for _, n := range []uint64{0, 1, 61, 62, 3844, math.MaxUint64} {
+		if got, want := Base62.Encode(n), Encode(n); got != want {
+			t.Errorf("Base62.Encode(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestAlphabet_NoLookAlikes(t *testing.T) {
+	for _, c := range "0OIl" {
+		if strings.ContainsRune(Base58.chars, c) {
+			t.Errorf("base58 contains %q", c)
+		}
+	}
+	for _, c := range "0O1Ilo" {
+		if strings.ContainsRune(Base32.chars, c) {
+			t.Errorf("base32 contains %q", c)
+		}
+	}
+	if strings.ToLower(Base32.chars) != Base32.chars {
+		t.Error("base32 is not lowercase")
+	}
+	for _, a := range []*Alphabet{Base58, Base32} {
+		// Codes written in the alphabet must resolve through the ID namespace
+		if strings.Trim(a.chars, alphabet[1:]) != "" {
+			t.Errorf("%s is not a subset of base62 without 0", a.Name())
+		}
+	}
+}
+
+func TestAlphabet_DecodeInvalid(t *testing.T) {
+	tests := []struct {
+		alphabet *Alphabet
+		input    string
+	}{
+		{Base58, "abl"},
+		{Base58, "0"},
+		{Base32, "ABC"},
+		{Base32, "ab1"},
+		{Base32, "日本"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.alphabet.Decode(tt.input); err == nil || !strings.Contains(err.Error(), tt.alphabet.Name()) {
+			t.Errorf("%s: Decode(%q) error = %v, want invalid character error", tt.alphabet.Name(), tt.input, err)
+		}
+	}
+	if _, err := Base58.Decode(""); err == nil {
+		t.Error("Decode(\"\") expected error")
+	}
+}
+
+func TestAlphabetByName(t *testing.T) {
+	for _, name := range []string{"base62", "base58", "base32"} {
+		a, err := AlphabetByName(name)
+		if err != nil || a.Name() != name {
+			t.Errorf("AlphabetByName(%q) = %v, %v", name, a, err)
+		}
+	}
+	if _, err := AlphabetByName("base64"); !errors.Is(err, ErrInvalidAlphabet) {
+		t.Errorf("AlphabetByName(base64) error = %v, want ErrInvalidAlphabet", err)
+	}
+}
+
+func TestService_ShortenWithAlphabet(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	service := NewService(repo, WithAlphabet(Base58))
+
+	for i := 0; i < 100; i++ {
+		code, err := service.Shorten(ctx, "https://example.com")
+		if err != nil {
+			t.Fatalf("Shorten() unexpected error = %v", err)
+		}
+		if _, err := Base58.Decode(code); err != nil {
+			t.Fatalf("Shorten() = %q, want a base58 code: %v", code, err)
+		}
+		if _, err := service.Resolve(ctx, code); err != nil {
+			t.Fatalf("Resolve(%q) unexpected error = %v", code, err)
+		}
+	}
+
+	// Per-link alphabets override the default and ignore case
+	code, err := service.ShortenWithOptions(ctx, "https://example.org", ShortenOptions{Alphabet: Base32})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	if _, err := Base32.Decode(code); err != nil {
+		t.Fatalf("ShortenWithOptions() = %q, want a base32 code: %v", code, err)
+	}
+	for _, typed := range []string{code, strings.ToUpper(code)} {
+		link, err := service.Resolve(ctx, typed)
+		if err != nil || link.OriginalURL != "https://example.org" {
+			t.Errorf("Resolve(%q) = %v, %v, want https://example.org", typed, link, err)
+		}
+	}
+
+	// Base62 codes stay case-sensitive
+	base62, err := service.ShortenWithOptions(ctx, "https://example.net", ShortenOptions{Alphabet: Base62})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	if swapped := strings.ToUpper(base62); swapped != base62 {
+		if link, err := service.Resolve(ctx, swapped); err == nil && link.OriginalURL == "https://example.net" {
+			t.Errorf("Resolve(%q) found %q", swapped, base62)
+		}
+	}
+
+	_, err = service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{CustomAlias: "my-link", Alphabet: Base58})
+	if !errors.Is(err, ErrInvalidAlphabet) {
+		t.Errorf("ShortenWithOptions() with alias error = %v, want ErrInvalidAlphabet", err)
+	}
+}
+
+func TestService_ShortenWithAlphabet_Overflow(t *testing.T) {
+	mockRepo := &MockRepository{
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+			return link.ID, nil
+		},
+	}
+	// Snowflake IDs have no base32 code below 2^63
+	ids := &sequenceIDs{next: 400_000_000_000_000_000}
+	service := NewService(mockRepo, WithCodeStrategy(SnowflakeCodes{IDs: ids}), WithAlphabet(Base32))
+	if _, err := service.Shorten(context.Background(), "https://example.com"); !errors.Is(err, ErrInvalidAlphabet) {
+		t.Errorf("Shorten() error = %v, want ErrInvalidAlphabet", err)
+	}
+
+	service = NewService(mockRepo, WithCodeStrategy(RandomCodes{Length: DefaultRandomCodeLength}), WithAlphabet(Base32))
+	code, err := service.Shorten(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	if _, err := Base32.Decode(code); err != nil {
+		t.Errorf("Shorten() = %q, want a base32 code: %v", code, err)
+	}
+}
@@ -0,0 +1,594 @@
+package shortener
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Granularities of click time series.
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+const (
+	// MaxSeriesBuckets caps the number of buckets in one time series.
+	MaxSeriesBuckets = 1000
+	// defaultHourlyRange and defaultDailyRange are the spans of a series
+	// requested without a start.
+	defaultHourlyRange = 24 * time.Hour
+	defaultDailyRange  = 30 * 24 * time.Hour
+)
+
+// Limits of TopStats.
+const (
+	DefaultStatsPeriod = 7 * 24 * time.Hour
+	MaxStatsPeriod     = 90 * 24 * time.Hour
+	DefaultTopLimit    = 10
+	MaxTopLimit        = 100
+)
+
+var (
+	ErrInvalidSeries = errors.New("invalid time series")
+	ErrInvalidPeriod = errors.New("invalid stats period")
+)
+
+// rollupTables maps each granularity to the table holding its rollup.
+var rollupTables = map[string]string{
+	GranularityHour: "clicks_hourly",
+	GranularityDay:  "clicks_daily",
+}
+
+// ClickBucket is the number of clicks in the hour or day starting at Start.
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+	// UniqueVisitors estimates the distinct visitors of a daily bucket. It is
+	// nil for hourly buckets and if visitors are not counted.
+	UniqueVisitors *int64 `json:"unique_visitors,omitempty"`
+}
+
+// ClickSeries is the number of clicks of a link per hour or day in
+// [From, To). Buckets cover the whole range, including those without clicks.
+type ClickSeries struct {
+	Code        string    `json:"short_code"`
+	Granularity string    `json:"granularity"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	// UniqueVisitors estimates the distinct visitors over the UTC days the
+	// series touches. It is nil if visitors are not counted.
+	UniqueVisitors *int64        `json:"unique_visitors,omitempty"`
+	Buckets        []ClickBucket `json:"buckets"`
+	// AnalyticsDisabled reports that the link opted out of click tracking:
+	// the buckets only hold clicks from before.
+	AnalyticsDisabled bool `json:"analytics_disabled"`
+}
+
+// TopLink is a link and its clicks in a TopStats period.
+type TopLink struct {
+	Code   string `json:"short_code"`
+	Clicks int64  `json:"clicks"`
+}
+
+// TopStats summarizes the activity of all links over a period [From, To).
+type TopStats struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Redirects counts the clicks of all links, including deleted ones.
+	Redirects int64 `json:"redirects"`
+	// UniqueVisitors estimates the distinct visitors over the UTC days the
+	// period touches. It is nil if visitors are not counted.
+	UniqueVisitors *int64 `json:"unique_visitors"`
+	LinksCreated   int64  `json:"links_created"`
+	// Top lists the most clicked links that still exist, most clicked first.
+	Top []TopLink `json:"top"`
+}
+
+// VisitorCounter estimates the number of distinct visitors per UTC day, of
+// all links and of each link, e.g. with Redis HyperLogLogs.
+type VisitorCounter interface {
+	// Add records that visitor followed the link with ID linkID at the given
+	// time.
+	Add(ctx context.Context, at time.Time, linkID uint64, visitor string) error
+	// Count estimates the distinct visitors of all links over the UTC days
+	// overlapping [from, to).
+	Count(ctx context.Context, from, to time.Time) (int64, error)
+	// CountLink estimates the distinct visitors of the link with ID linkID
+	// over the UTC days overlapping [from, to), in total and for each of
+	// those days.
+	CountLink(ctx context.Context, linkID uint64, from, to time.Time) (total int64, daily []int64, err error)
+	// CountLinks estimates the distinct visitors of any of the links with
+	// IDs linkIDs over the UTC days overlapping [from, to).
+	CountLinks(ctx context.Context, linkIDs []uint64, from, to time.Time) (int64, error)
+	// Forget drops the visitors of the link with ID linkID. The visitors of
+	// all links cannot be told apart by link and are kept.
+	Forget(ctx context.Context, linkID uint64) error
+}
+
+// VisitorID derives the Visitor.ID of a client from its IP address and
+// User-Agent. It is a hash so that neither needs to be stored.
+func VisitorID(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(ip + "\x00" + userAgent))
+	return hex.EncodeToString(sum[:16])
+}
+
+// truncateBucket returns the start of the UTC hour or day containing t.
+func truncateBucket(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// ceilBucket rounds t up to the start of a bucket.
+func ceilBucket(t time.Time, granularity string) time.Time {
+	start := truncateBucket(t, granularity)
+	if start.Equal(t) {
+		return t
+	}
+	return nextBucket(start, granularity)
+}
+
+// nextBucket returns the start of the bucket following start.
+func nextBucket(start time.Time, granularity string) time.Time {
+	if granularity == GranularityDay {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(time.Hour)
+}
+
+// recordClick counts a redirect of link by v for its time series and the
+// unique visitors. Analytics are best effort: a failure must not break the
+// redirect.
+func (s *Service) recordClick(ctx context.Context, link *Link, v Visitor) {
+	now := s.now()
+	if err := s.repo.RecordClick(ctx, link, now, v.Source); err != nil {
+		clickMetrics.Add("dropped", 1)
+		slog.WarnContext(ctx, "failed to record click", "id", link.ID, "error", err)
+	}
+	if s.visitors != nil && v.ID != "" {
+		if err := s.visitors.Add(ctx, now, link.ID, v.ID); err != nil {
+			slog.WarnContext(ctx, "failed to count visitor", "id", link.ID, "error", err)
+		}
+	}
+}
+
+// RollupClicks adds recorded clicks to the hourly and daily rollups.
+func (s *Service) RollupClicks(ctx context.Context) (int, error) {
+	n, err := s.repo.RollupClicks(ctx)
+	if err != nil {
+		return n, fmt.Errorf("failed to roll up clicks: %w", err)
+	}
+	lastRollup.Store(time.Now().Unix())
+	return n, nil
+}
+
+// PurgeClicks deletes the hourly and daily click buckets, and the source
+// counts of those days, older than their retention (see
+// WithClickRetention).
+func (s *Service) PurgeClicks(ctx context.Context) (int, error) {
+	now := s.now()
+	total := 0
+	for granularity, retention := range map[string]time.Duration{GranularityHour: s.hourlyRetention, GranularityDay: s.dailyRetention} {
+		if retention <= 0 {
+			continue
+		}
+		n, err := s.repo.PurgeClicks(ctx, granularity, now.Add(-retention))
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to purge %s clicks: %w", granularity, err)
+		}
+	}
+	return total, nil
+}
+
+// DeleteClicks erases the analytics of the link behind shortCode, e.g. for
+// a data subject request: its hourly and daily clicks, their sources and
+// its unique visitors. Clicks waiting for the rollup are rolled up first so
+// that they do not reappear. The click count of the link, which limits and
+// listings rely on, is kept. It returns the number of rows deleted.
+func (s *Service) DeleteClicks(ctx context.Context, shortCode string) (int, error) {
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.RollupClicks(ctx); err != nil {
+		return 0, err
+	}
+	n, err := s.repo.DeleteClicks(ctx, link)
+	if err != nil {
+		return n, fmt.Errorf("failed to delete clicks: %w", err)
+	}
+	if s.visitors != nil {
+		if err := s.visitors.Forget(ctx, link.ID); err != nil {
+			return n, fmt.Errorf("failed to delete visitors: %w", err)
+		}
+	}
+	s.audit(ctx, ActorAdmin, AuditDeleteClicks, link.ShortCode(), fmt.Sprintf("%d rows", n))
+	return n, nil
+}
+
+// ClickSeries returns the clicks per hour or day of the link behind
+// shortCode, which user must own, for the buckets starting in [from, to).
+// A zero to means now, rounded up to a bucket boundary, and a zero from a
+// default span before now. Clicks only appear once RollupClicks has run.
+// Returns ErrInvalidSeries for an unknown granularity or a range that is
+// empty or longer than MaxSeriesBuckets.
+func (s *Service) ClickSeries(ctx context.Context, user *User, shortCode, granularity string, from, to time.Time) (*ClickSeries, error) {
+	if granularity == "" {
+		granularity = GranularityHour
+	}
+	if _, ok := rollupTables[granularity]; !ok {
+		return nil, fmt.Errorf("%w: granularity must be %s or %s", ErrInvalidSeries, GranularityHour, GranularityDay)
+	}
+	toNow := to.IsZero()
+	if toNow {
+		to = s.now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultHourlyRange)
+		if granularity == GranularityDay {
+			from = to.Add(-defaultDailyRange)
+		}
+	}
+	from, to = truncateBucket(from, granularity), to.UTC()
+	if toNow {
+		// The same buckets, but repeated requests get identical series
+		to = ceilBucket(to, granularity)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidSeries)
+	}
+	n := 0
+	for t := from; t.Before(to); t = nextBucket(t, granularity) {
+		if n++; n > MaxSeriesBuckets {
+			return nil, fmt.Errorf("%w: range exceeds %d buckets", ErrInvalidSeries, MaxSeriesBuckets)
+		}
+	}
+
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.repo.ClickSeries(ctx, link, granularity, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click series: %w", err)
+	}
+
+	// Fill in the buckets without clicks so that charts need not
+	series := &ClickSeries{Code: shortCode, Granularity: granularity, From: from, To: to, Buckets: make([]ClickBucket, 0, n), AnalyticsDisabled: link.AnalyticsDisabled}
+	for t := from; t.Before(to); t = nextBucket(t, granularity) {
+		b := ClickBucket{Start: t}
+		for len(stored) > 0 && !stored[0].Start.After(t) {
+			if stored[0].Start.Equal(t) {
+				b.Clicks = stored[0].Clicks
+			}
+			stored = stored[1:]
+		}
+		series.Buckets = append(series.Buckets, b)
+	}
+
+	if s.visitors != nil {
+		total, daily, err := s.visitors.CountLink(ctx, link.ID, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count visitors: %w", err)
+		}
+		series.UniqueVisitors = &total
+		if granularity == GranularityDay {
+			for i := range min(len(daily), len(series.Buckets)) {
+				series.Buckets[i].UniqueVisitors = &daily[i]
+			}
+		}
+	}
+	return series, nil
+}
+
+// ExportClicks returns the buckets with clicks of the link behind shortCode,
+// which user must own, at granularity for [from, to), oldest first. Unlike
+// ClickSeries there is no cap on the range and empty buckets are left out.
+// A zero from means the creation of the link and a zero to now.
+// Returns ErrInvalidSeries for an unknown granularity or an empty range.
+func (s *Service) ExportClicks(ctx context.Context, user *User, shortCode, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	if _, ok := rollupTables[granularity]; !ok {
+		return nil, fmt.Errorf("%w: granularity must be %s or %s", ErrInvalidSeries, GranularityHour, GranularityDay)
+	}
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() {
+		from = link.CreatedAt
+	}
+	if to.IsZero() {
+		to = s.now()
+	}
+	from, to = truncateBucket(from, granularity), to.UTC()
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidSeries)
+	}
+
+	buckets, err := s.repo.ClickSeries(ctx, link, granularity, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export clicks: %w", err)
+	}
+	return buckets, nil
+}
+
+// TopStats returns the redirects, unique visitors, link creations and the
+// limit most clicked links over the period up to now. Periods of whole days
+// cover that many UTC days including today, others that many hours
+// including the current one. Like ClickSeries, it only sees rolled up
+// clicks. Returns ErrInvalidPeriod unless period is a positive number of
+// hours up to MaxStatsPeriod.
+func (s *Service) TopStats(ctx context.Context, period time.Duration, limit int) (*TopStats, error) {
+	limit, err := checkStatsPeriod(period, limit)
+	if err != nil {
+		return nil, err
+	}
+	granularity, from, to := statsRange(s.now(), period)
+
+	st, err := s.repo.TopStats(ctx, granularity, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top stats: %w", err)
+	}
+	st.From, st.To = from, to
+	if s.visitors != nil {
+		n, err := s.visitors.Count(ctx, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count visitors: %w", err)
+		}
+		st.UniqueVisitors = &n
+	}
+	return st, nil
+}
+
+// checkStatsPeriod returns ErrInvalidPeriod unless period is a positive
+// number of hours up to MaxStatsPeriod, and limit brought into range.
+func checkStatsPeriod(period time.Duration, limit int) (int, error) {
+	if period <= 0 || period > MaxStatsPeriod || period%time.Hour != 0 {
+		return 0, fmt.Errorf("%w: must be whole hours or days up to %d days", ErrInvalidPeriod, MaxStatsPeriod/(24*time.Hour))
+	}
+	if limit <= 0 {
+		limit = DefaultTopLimit
+	}
+	return min(limit, MaxTopLimit), nil
+}
+
+// statsRange returns the rollup granularity and the range [from, to) of a
+// stats period ending with the bucket containing now: whole UTC days for
+// periods of days, hours otherwise.
+func statsRange(now time.Time, period time.Duration) (granularity string, from, to time.Time) {
+	granularity, step := GranularityHour, time.Hour
+	if period%(24*time.Hour) == 0 {
+		granularity, step = GranularityDay, 24*time.Hour
+	}
+	start := truncateBucket(now, granularity)
+	return granularity, start.Add(step - period), nextBucket(start, granularity)
+}
+
+// clickCount is the number of clicks of a link in the bucket starting at
+// start. Counts with a dimension are the clicks from one traffic source
+// (see ClickSource) and are not part of the click totals.
+type clickCount struct {
+	linkID    uint64
+	start     time.Time
+	dimension string
+	value     string
+	clicks    int64
+}
+
+// rollupCounts sums clicks by link and hour, returning the hourly and daily
+// counts to add to the rollups. The traffic source counts are summed by day
+// under clickSourcesTable.
+func rollupCounts(clicks []clickCount) map[string][]clickCount {
+	type key struct {
+		linkID           uint64
+		start            int64
+		dimension, value string
+	}
+	rollups := make(map[string][]clickCount, len(rollupTables)+1)
+	for _, rollup := range []string{GranularityHour, GranularityDay, clickSourcesTable} {
+		granularity := rollup
+		if rollup == clickSourcesTable {
+			granularity = GranularityDay
+		}
+		index := make(map[key]int)
+		var counts []clickCount
+		for _, c := range clicks {
+			if (c.dimension != "") != (rollup == clickSourcesTable) {
+				continue
+			}
+			start := truncateBucket(c.start, granularity)
+			k := key{c.linkID, start.Unix(), c.dimension, c.value}
+			if i, ok := index[k]; ok {
+				counts[i].clicks += c.clicks
+				continue
+			}
+			index[k] = len(counts)
+			counts = append(counts, clickCount{linkID: c.linkID, start: start, dimension: c.dimension, value: c.value, clicks: c.clicks})
+		}
+		rollups[rollup] = counts
+	}
+	return rollups
+}
+
+// sqlExecer is implemented by *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// addClickCounts adds clicks to the rollup tables and returns the number of
+// clicks added. upsert is the dialect's INSERT ... ON CONFLICT statement
+// for a table keyed by url_id, bucket and the given extra columns; counts
+// of links that no longer exist are skipped by it.
+func addClickCounts(ctx context.Context, db sqlExecer, clicks []clickCount, upsert func(table string, keys ...string) string) (int, error) {
+	for rollup, counts := range rollupCounts(clicks) {
+		query := upsert(rollupTables[rollup])
+		if rollup == clickSourcesTable {
+			query = upsert(clickSourcesTable, "dimension", "value")
+		}
+		for _, c := range counts {
+			args := []any{int64(c.linkID), c.start, c.clicks}
+			if rollup == clickSourcesTable {
+				args = append(args, c.dimension, c.value)
+			}
+			if _, err := db.ExecContext(ctx, query, args...); err != nil {
+				return 0, fmt.Errorf("failed to add clicks for id %d: %w", c.linkID, err)
+			}
+		}
+	}
+	total := 0
+	for _, c := range clicks {
+		if c.dimension == "" {
+			total += int(c.clicks)
+		}
+	}
+	return total, nil
+}
+
+// topStatsQueries returns the queries of TopStats for the rollup table, with
+// bind rendering the n-th placeholder of the dialect. The top links query
+// takes the range and the limit, the others only the range.
+func topStatsQueries(table string, bind func(n int) string) (redirects, created, top string) {
+	from, to := bind(1), bind(2)
+	redirects = fmt.Sprintf(`SELECT COALESCE(SUM(clicks), 0) FROM %s WHERE bucket >= %s AND bucket < %s`, table, from, to)
+	created = fmt.Sprintf(`SELECT COUNT(*) FROM urls WHERE created_at >= %s AND created_at < %s`, from, to)
+	top = fmt.Sprintf(`SELECT u.id, u.code, SUM(c.clicks) AS total FROM %s c JOIN urls u ON u.id = c.url_id
+		WHERE c.bucket >= %s AND c.bucket < %s AND u.deleted_at IS NULL
+		GROUP BY u.id, u.code ORDER BY total DESC, u.id LIMIT %s`, table, from, to, bind(3))
+	return redirects, created, top
+}
+
+// queryTopStats runs the queries of topStatsQueries.
+func queryTopStats(ctx context.Context, db *sql.DB, table string, bind func(n int) string, from, to any, limit int) (*TopStats, error) {
+	redirectsQuery, createdQuery, topQuery := topStatsQueries(table, bind)
+	var st TopStats
+	if err := db.QueryRowContext(ctx, redirectsQuery, from, to).Scan(&st.Redirects); err != nil {
+		return nil, fmt.Errorf("failed to count redirects: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, createdQuery, from, to).Scan(&st.LinksCreated); err != nil {
+		return nil, fmt.Errorf("failed to count created urls: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, topQuery, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top urls: %w", err)
+	}
+	defer rows.Close()
+	st.Top = []TopLink{}
+	for rows.Next() {
+		var link Link
+		var code sql.NullString
+		var clicks int64
+		if err := rows.Scan(&link.ID, &code, &clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan top url: %w", err)
+		}
+		link.Code = code.String
+		st.Top = append(st.Top, TopLink{Code: link.ShortCode(), Clicks: clicks})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate top urls: %w", err)
+	}
+	return &st, nil
+}
+
+// purgeClickRows deletes the rows of the rollup at granularity, and of
+// click_sources for GranularityDay, with a bucket before before. Rows go in
+// batches of purgeBatchSize, picked by the row identifier rowID of the
+// dialect, so that a large backlog does not hold locks for long.
+func purgeClickRows(ctx context.Context, db *sql.DB, granularity, rowID string, bind func(n int) string, before any) (int, error) {
+	tables := []string{rollupTables[granularity]}
+	if granularity == GranularityDay {
+		tables = append(tables, clickSourcesTable)
+	}
+	total := 0
+	for _, table := range tables {
+		query := fmt.Sprintf(`DELETE FROM %[1]s WHERE %[2]s IN (SELECT %[2]s FROM %[1]s WHERE bucket < %[3]s LIMIT %[4]s)`,
+			table, rowID, bind(1), bind(2))
+		for {
+			res, err := db.ExecContext(ctx, query, before, purgeBatchSize)
+			if err != nil {
+				return total, fmt.Errorf("failed to purge %s: %w", table, err)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return total, fmt.Errorf("failed to count purged %s rows: %w", table, err)
+			}
+			total += int(n)
+			if n < purgeBatchSize {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// deleteClickRows deletes the rows of link in the rollup tables, with
+// placeholder binding its ID, in one transaction.
+func deleteClickRows(ctx context.Context, db *sql.DB, placeholder string, link *Link) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	total := 0
+	for _, table := range []string{rollupTables[GranularityHour], rollupTables[GranularityDay], clickSourcesTable} {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE url_id = %s`, table, placeholder), int64(link.ID))
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete %s of id %d: %w", table, link.ID, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to count deleted %s rows: %w", table, err)
+		}
+		total += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit click deletion: %w", err)
+	}
+	return total, nil
+}
+
+// scanSourceCounts reads dimension, value, clicks rows.
+func scanSourceCounts(rows *sql.Rows) ([]SourceCount, error) {
+	defer rows.Close()
+	var counts []SourceCount
+	for rows.Next() {
+		var c SourceCount
+		if err := rows.Scan(&c.Dimension, &c.Value, &c.Clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan click source: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate click sources: %w", err)
+	}
+	return counts, nil
+}
+
+// scanClickBuckets reads bucket, clicks rows.
+func scanClickBuckets(rows *sql.Rows) ([]ClickBucket, error) {
+	defer rows.Close()
+
+	var buckets []ClickBucket
+	for rows.Next() {
+		var b ClickBucket
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan click bucket: %w", err)
+		}
+		b.Start = b.Start.UTC()
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate click buckets: %w", err)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,560 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// analyticsRepos are the repositories analytics tests run against.
+var analyticsRepos = map[string]func(t *testing.T) Repository{
+	"memory": func(t *testing.T) Repository { return NewInMemoryRepository() },
+	"sqlite": func(t *testing.T) Repository {
+		repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "shortener.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteRepository() unexpected error = %v", err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	},
+}
+
+func TestService_ClickSeries(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(newRepo(t))
+			ctx := context.Background()
+
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+
+			day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			click := func(at time.Time) {
+				t.Helper()
+				service.now = func() time.Time { return at }
+				if _, err := service.Redirect(ctx, code); err != nil {
+					t.Fatalf("Redirect() unexpected error = %v", err)
+				}
+			}
+			rollup := func(want int) {
+				t.Helper()
+				if n, err := service.RollupClicks(ctx); err != nil || n != want {
+					t.Fatalf("RollupClicks() = %d, %v, want %d, nil", n, err, want)
+				}
+			}
+			series := func(granularity string, from, to time.Time) []int64 {
+				t.Helper()
+				s, err := service.ClickSeries(ctx, user, code, granularity, from, to)
+				if err != nil {
+					t.Fatalf("ClickSeries() unexpected error = %v", err)
+				}
+				counts := make([]int64, len(s.Buckets))
+				for i, b := range s.Buckets {
+					counts[i] = b.Clicks
+				}
+				return counts
+			}
+
+			click(day.Add(9*time.Hour + 10*time.Minute))
+			click(day.Add(9*time.Hour + 40*time.Minute))
+			click(day.Add(11*time.Hour + 5*time.Minute))
+			click(day.Add(32 * time.Hour))
+
+			// Clicks only show up once rolled up
+			from, to := day.Add(8*time.Hour+30*time.Minute), day.Add(12*time.Hour)
+			if got := series(GranularityHour, from, to); !slices.Equal(got, []int64{0, 0, 0, 0}) {
+				t.Errorf("hourly series before rollup = %v, want zeros", got)
+			}
+			rollup(4)
+			click(day.Add(9*time.Hour + 20*time.Minute))
+			rollup(1)
+			rollup(0)
+
+			s, err := service.ClickSeries(ctx, user, code, GranularityHour, from, to)
+			if err != nil {
+				t.Fatalf("ClickSeries() unexpected error = %v", err)
+			}
+			if !s.From.Equal(day.Add(8*time.Hour)) || !s.To.Equal(to) || s.Granularity != GranularityHour || s.Code != code {
+				t.Errorf("ClickSeries() = %+v, want hourly from 08:00", s)
+			}
+			if got := series(GranularityHour, from, to); !slices.Equal(got, []int64{0, 3, 0, 1}) {
+				t.Errorf("hourly series = %v, want [0 3 0 1]", got)
+			}
+			if got := series(GranularityDay, day, day.AddDate(0, 0, 3)); !slices.Equal(got, []int64{4, 1, 0}) {
+				t.Errorf("daily series = %v, want [4 1 0]", got)
+			}
+
+			// Defaults: hourly, for the 24 hours up to now
+			service.now = func() time.Time { return day.Add(33 * time.Hour) }
+			if got := series("", time.Time{}, time.Time{}); len(got) != 24 || got[0] != 3 || got[23] != 1 {
+				t.Errorf("default series = %v, want 24 hourly buckets from 09:00 the day before", got)
+			}
+
+			tests := []struct {
+				name        string
+				granularity string
+				from, to    time.Time
+			}{
+				{"unknown granularity", "week", day, day.AddDate(0, 0, 7)},
+				{"empty range", GranularityDay, day, day},
+				{"reversed range", GranularityHour, day.Add(time.Hour), day},
+				{"too many buckets", GranularityHour, day, day.AddDate(0, 0, 60)},
+			}
+			for _, tt := range tests {
+				if _, err := service.ClickSeries(ctx, user, code, tt.granularity, tt.from, tt.to); !errors.Is(err, ErrInvalidSeries) {
+					t.Errorf("%s: ClickSeries() error = %v, want ErrInvalidSeries", tt.name, err)
+				}
+			}
+
+			other, _, err := service.Signup(ctx, "bob@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			if _, err := service.ClickSeries(ctx, other, code, GranularityDay, day, day.AddDate(0, 0, 1)); !errors.Is(err, ErrForbidden) {
+				t.Errorf("ClickSeries() by another user error = %v, want ErrForbidden", err)
+			}
+		})
+	}
+}
+
+func TestService_PurgeClicks(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+			now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+			service := NewService(repo, WithClickRetention(48*time.Hour, 7*24*time.Hour))
+			service.now = func() time.Time { return now }
+
+			link := &Link{OriginalURL: "https://example.com"}
+			if _, err := repo.Save(ctx, link); err != nil {
+				t.Fatalf("Save() unexpected error = %v", err)
+			}
+			// One click a day for ten days, each in its own hour and day
+			for days := range 10 {
+				at := now.AddDate(0, 0, -days)
+				if err := repo.RecordClick(ctx, link, at, ClickSource{Referrer: "news.example.org"}); err != nil {
+					t.Fatalf("RecordClick() unexpected error = %v", err)
+				}
+			}
+			if _, err := repo.RollupClicks(ctx); err != nil {
+				t.Fatalf("RollupClicks() unexpected error = %v", err)
+			}
+
+			// 7 hourly buckets older than two days, and 3 daily buckets
+			// with their sources starting over a week ago
+			if n, err := service.PurgeClicks(ctx); err != nil || n != 13 {
+				t.Fatalf("PurgeClicks() = %d, %v, want 13", n, err)
+			}
+			from := now.AddDate(0, 0, -30)
+			if hours, _ := repo.ClickSeries(ctx, link, GranularityHour, from, now.Add(time.Hour)); len(hours) != 3 {
+				t.Errorf("hourly buckets left = %d, want 3", len(hours))
+			}
+			if days, _ := repo.ClickSeries(ctx, link, GranularityDay, from, now); len(days) != 7 {
+				t.Errorf("daily buckets left = %d, want 7", len(days))
+			}
+			if sources, _ := repo.ClickSources(ctx, link, from, now); len(sources) != 1 || sources[0].Clicks != 7 {
+				t.Errorf("sources left = %+v, want 7 clicks", sources)
+			}
+			if n, err := service.PurgeClicks(ctx); err != nil || n != 0 {
+				t.Errorf("PurgeClicks() again = %d, %v, want 0", n, err)
+			}
+
+			// Without retention nothing is purged
+			if n, err := NewService(repo).PurgeClicks(ctx); err != nil || n != 0 {
+				t.Errorf("PurgeClicks() without retention = %d, %v, want 0", n, err)
+			}
+		})
+	}
+}
+
+func TestService_DeleteClicks(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo(t)
+			visitors := &fakeVisitors{}
+			service := NewService(repo, WithVisitorCounter(visitors))
+			ctx := context.Background()
+
+			var codes []string
+			for _, u := range []string{"https://a.example.com", "https://b.example.com"} {
+				code, err := service.Shorten(ctx, u)
+				if err != nil {
+					t.Fatalf("Shorten() unexpected error = %v", err)
+				}
+				codes = append(codes, code)
+			}
+			click := func(code string) {
+				t.Helper()
+				if _, err := service.RedirectVisitor(ctx, code, Visitor{ID: "v1", Source: ClickSource{Referrer: "news.example.org"}}); err != nil {
+					t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+				}
+			}
+			click(codes[0])
+			if _, err := service.RollupClicks(ctx); err != nil {
+				t.Fatalf("RollupClicks() unexpected error = %v", err)
+			}
+			// Clicks pending a rollup are erased too
+			click(codes[0])
+			click(codes[1])
+
+			// An hourly and a daily bucket and a source
+			if n, err := service.DeleteClicks(ctx, codes[0]); err != nil || n != 3 {
+				t.Fatalf("DeleteClicks() = %d, %v, want 3", n, err)
+			}
+			now := time.Now()
+			from, to := now.Add(-48*time.Hour), now.Add(48*time.Hour)
+			for i, want := range []int{0, 1} {
+				link, err := service.Resolve(ctx, codes[i])
+				if err != nil {
+					t.Fatalf("Resolve() unexpected error = %v", err)
+				}
+				if days, _ := repo.ClickSeries(ctx, link, GranularityDay, from, to); len(days) != want {
+					t.Errorf("%s: daily buckets = %d, want %d", codes[i], len(days), want)
+				}
+				if n, _, _ := visitors.CountLink(ctx, link.ID, from, to); n != int64(want) {
+					t.Errorf("%s: visitors = %d, want %d", codes[i], n, want)
+				}
+			}
+
+			entries, _, _ := service.ListAudit(ctx, AuditFilter{Code: codes[0]}, 0, 10)
+			if len(entries) == 0 || entries[0].Action != AuditDeleteClicks {
+				t.Errorf("audit entries = %+v, want a delete_clicks entry", entries)
+			}
+			if _, err := service.DeleteClicks(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("DeleteClicks() of a missing link error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestService_AnalyticsDisabled(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			visitors := &fakeVisitors{}
+			service := NewService(newRepo(t), WithVisitorCounter(visitors))
+			ctx := context.Background()
+
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			code, err := service.ShortenWithOptions(ctx, "https://clinic.example.com", ShortenOptions{Owner: user, MaxClicks: 2, DisableAnalytics: true})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			redirect := func() (Route, error) {
+				return service.RedirectVisitor(ctx, code, Visitor{ID: "v1", Source: ClickSource{Referrer: "news.example.org"}})
+			}
+
+			route, err := redirect()
+			if err != nil || !route.Untracked {
+				t.Fatalf("RedirectVisitor() = %+v, %v, want an untracked route", route, err)
+			}
+			if n, err := service.RollupClicks(ctx); err != nil || n != 0 {
+				t.Errorf("RollupClicks() = %d, %v, want nothing recorded", n, err)
+			}
+			// The click limit still counts untracked clicks
+			if _, err := redirect(); err != nil {
+				t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+			}
+			if _, err := redirect(); !errors.Is(err, ErrClickLimitReached) {
+				t.Errorf("RedirectVisitor() past the limit error = %v, want ErrClickLimitReached", err)
+			}
+
+			series, err := service.ClickSeries(ctx, user, code, GranularityDay, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatalf("ClickSeries() unexpected error = %v", err)
+			}
+			if !series.AnalyticsDisabled || series.UniqueVisitors == nil || *series.UniqueVisitors != 0 {
+				t.Errorf("ClickSeries() = %+v, want analytics disabled and no visitors", series)
+			}
+			breakdown, err := service.ClickBreakdown(ctx, user, code, time.Time{}, time.Time{}, 0)
+			if err != nil || !breakdown.AnalyticsDisabled || len(breakdown.Referrers) != 0 {
+				t.Errorf("ClickBreakdown() = %+v, %v, want analytics disabled and no referrers", breakdown, err)
+			}
+
+			enabled := false
+			link, err := service.UpdateLink(ctx, user, code, LinkUpdate{AnalyticsDisabled: &enabled, MaxClicks: new(int64)})
+			if err != nil || link.AnalyticsDisabled {
+				t.Fatalf("UpdateLink() = %+v, %v, want analytics enabled", link, err)
+			}
+			if route, err := redirect(); err != nil || route.Untracked {
+				t.Errorf("RedirectVisitor() = %+v, %v, want a tracked route", route, err)
+			}
+			if n, err := service.RollupClicks(ctx); err != nil || n != 1 {
+				t.Errorf("RollupClicks() = %d, %v, want the click recorded", n, err)
+			}
+		})
+	}
+}
+
+func TestService_ExportClicks(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(newRepo(t))
+			ctx := context.Background()
+
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+
+			// Clicks over more than MaxSeriesBuckets hours
+			day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			at := []time.Time{day.Add(-90 * 24 * time.Hour), day.Add(time.Hour), day.Add(time.Hour + time.Minute), day.Add(50 * time.Hour)}
+			for _, t0 := range at {
+				service.now = func() time.Time { return t0 }
+				if _, err := service.Redirect(ctx, code); err != nil {
+					t.Fatalf("Redirect() unexpected error = %v", err)
+				}
+			}
+			if _, err := service.RollupClicks(ctx); err != nil {
+				t.Fatalf("RollupClicks() unexpected error = %v", err)
+			}
+
+			export := func(granularity string, from, to time.Time) []ClickBucket {
+				t.Helper()
+				buckets, err := service.ExportClicks(ctx, user, code, granularity, from, to)
+				if err != nil {
+					t.Fatalf("ExportClicks() unexpected error = %v", err)
+				}
+				return buckets
+			}
+			got := export(GranularityHour, day.AddDate(0, 0, -100), day.AddDate(0, 0, 5))
+			want := []ClickBucket{{Start: at[0], Clicks: 1}, {Start: at[1], Clicks: 2}, {Start: at[3], Clicks: 1}}
+			if len(got) != len(want) {
+				t.Fatalf("hourly export = %+v, want %+v", got, want)
+			}
+			for i := range want {
+				if !got[i].Start.Equal(want[i].Start) || got[i].Clicks != want[i].Clicks {
+					t.Errorf("hourly export[%d] = %+v, want %+v", i, got[i], want[i])
+				}
+			}
+			if got := export(GranularityDay, day, day.AddDate(0, 0, 5)); len(got) != 2 || got[0].Clicks != 2 || got[1].Clicks != 1 {
+				t.Errorf("daily export = %+v, want 2 then 1 clicks", got)
+			}
+
+			// By default from the creation of the link, which is now in the
+			// tests, until now
+			service.now = time.Now
+			if got := export(GranularityDay, time.Time{}, time.Time{}); len(got) != 0 {
+				t.Errorf("default export = %+v, want no clicks since creation", got)
+			}
+
+			if _, err := service.ExportClicks(ctx, user, code, "week", day, day.AddDate(0, 0, 1)); !errors.Is(err, ErrInvalidSeries) {
+				t.Errorf("ExportClicks() with unknown granularity error = %v, want ErrInvalidSeries", err)
+			}
+			if _, err := service.ExportClicks(ctx, user, code, GranularityDay, day, day); !errors.Is(err, ErrInvalidSeries) {
+				t.Errorf("ExportClicks() with empty range error = %v, want ErrInvalidSeries", err)
+			}
+		})
+	}
+}
+
+func TestService_ClickSeries_UniqueVisitors(t *testing.T) {
+	visitors := &fakeVisitors{}
+	service := NewService(NewInMemoryRepository(), WithVisitorCounter(visitors))
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	var codes []string
+	for _, u := range []string{"https://a.example.com", "https://b.example.com"} {
+		code, err := service.ShortenWithOptions(ctx, u, ShortenOptions{Owner: user})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+		}
+		codes = append(codes, code)
+	}
+
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		code    string
+		at      time.Time
+		visitor string
+	}{
+		{codes[0], day.Add(time.Hour), "v1"},
+		{codes[0], day.Add(2 * time.Hour), "v1"},
+		{codes[0], day.Add(3 * time.Hour), "v2"},
+		{codes[0], day.Add(26 * time.Hour), "v1"},
+		{codes[0], day.Add(27 * time.Hour), ""},
+		{codes[1], day.Add(time.Hour), "v3"},
+	} {
+		service.now = func() time.Time { return c.at }
+		if _, err := service.RedirectVisitor(ctx, c.code, Visitor{ID: c.visitor}); err != nil {
+			t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+		}
+	}
+
+	// Clients without an ID are not counted
+	s, err := service.ClickSeries(ctx, user, codes[0], GranularityDay, day, day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatalf("ClickSeries() unexpected error = %v", err)
+	}
+	var daily []int64
+	for _, b := range s.Buckets {
+		if b.UniqueVisitors == nil {
+			t.Fatalf("daily bucket %v without unique visitors", b.Start)
+		}
+		daily = append(daily, *b.UniqueVisitors)
+	}
+	if s.UniqueVisitors == nil || *s.UniqueVisitors != 2 || !slices.Equal(daily, []int64{2, 1, 0}) {
+		t.Errorf("ClickSeries() unique visitors = %v, daily %v, want 2 and [2 1 0]", s.UniqueVisitors, daily)
+	}
+
+	// Hourly series only have the total, over the whole days they touch
+	s, err = service.ClickSeries(ctx, user, codes[0], GranularityHour, day.Add(26*time.Hour), day.Add(28*time.Hour))
+	if err != nil {
+		t.Fatalf("ClickSeries() unexpected error = %v", err)
+	}
+	if s.UniqueVisitors == nil || *s.UniqueVisitors != 1 || s.Buckets[0].UniqueVisitors != nil {
+		t.Errorf("hourly ClickSeries() = %+v, want 1 unique visitor and none per bucket", s)
+	}
+}
+
+// fakeVisitors is a VisitorCounter counting distinct visitors exactly.
+type fakeVisitors struct {
+	visits []fakeVisit
+}
+
+type fakeVisit struct {
+	at      time.Time
+	linkID  uint64
+	visitor string
+}
+
+func (f *fakeVisitors) Add(ctx context.Context, at time.Time, linkID uint64, visitor string) error {
+	f.visits = append(f.visits, fakeVisit{at, linkID, visitor})
+	return nil
+}
+
+// count counts the distinct visitors of the matching visits in the UTC days
+// overlapping [from, to).
+func (f *fakeVisitors) count(from, to time.Time, match func(fakeVisit) bool) int64 {
+	from = truncateBucket(from, GranularityDay)
+	seen := make(map[string]bool)
+	for _, v := range f.visits {
+		if !v.at.Before(from) && v.at.Before(to) && match(v) {
+			seen[v.visitor] = true
+		}
+	}
+	return int64(len(seen))
+}
+
+func (f *fakeVisitors) Count(ctx context.Context, from, to time.Time) (int64, error) {
+	return f.count(from, to, func(fakeVisit) bool { return true }), nil
+}
+
+func (f *fakeVisitors) CountLink(ctx context.Context, linkID uint64, from, to time.Time) (int64, []int64, error) {
+	match := func(v fakeVisit) bool { return v.linkID == linkID }
+	var daily []int64
+	for day := truncateBucket(from, GranularityDay); day.Before(to); day = day.AddDate(0, 0, 1) {
+		daily = append(daily, f.count(day, day.AddDate(0, 0, 1), match))
+	}
+	return f.count(from, to, match), daily, nil
+}
+
+func (f *fakeVisitors) Forget(ctx context.Context, linkID uint64) error {
+	f.visits = slices.DeleteFunc(f.visits, func(v fakeVisit) bool { return v.linkID == linkID })
+	return nil
+}
+
+func (f *fakeVisitors) CountLinks(ctx context.Context, linkIDs []uint64, from, to time.Time) (int64, error) {
+	return f.count(from, to, func(v fakeVisit) bool { return slices.Contains(linkIDs, v.linkID) }), nil
+}
+
+func TestService_TopStats(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			visitors := &fakeVisitors{}
+			service := NewService(newRepo(t), WithVisitorCounter(visitors))
+			ctx := context.Background()
+
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			var codes []string
+			for _, u := range []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"} {
+				code, err := service.ShortenWithOptions(ctx, u, ShortenOptions{Owner: user})
+				if err != nil {
+					t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+				}
+				codes = append(codes, code)
+			}
+			a, b, c := codes[0], codes[1], codes[2]
+
+			yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+			click := func(code string, at time.Time, visitor string) {
+				t.Helper()
+				service.now = func() time.Time { return at }
+				if _, err := service.RedirectVisitor(ctx, code, Visitor{ID: visitor}); err != nil {
+					t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+				}
+			}
+			for range 3 {
+				click(b, yesterday.Add(10*time.Hour), "v1")
+			}
+			click(a, yesterday.Add(11*time.Hour), "v2")
+			for range 5 {
+				click(c, yesterday.Add(12*time.Hour), "v3")
+			}
+			for range 3 {
+				click(a, yesterday.AddDate(0, 0, -10), "v4")
+			}
+			if _, err := service.RollupClicks(ctx); err != nil {
+				t.Fatalf("RollupClicks() unexpected error = %v", err)
+			}
+			if err := service.Delete(ctx, user, c); err != nil {
+				t.Fatalf("Delete() unexpected error = %v", err)
+			}
+			service.now = time.Now
+
+			st, err := service.TopStats(ctx, 2*24*time.Hour, 0)
+			if err != nil {
+				t.Fatalf("TopStats() unexpected error = %v", err)
+			}
+			// Deleted links still count towards redirects but are not ranked
+			want := []TopLink{{Code: b, Clicks: 3}, {Code: a, Clicks: 1}}
+			if !st.From.Equal(yesterday) || !st.To.Equal(yesterday.AddDate(0, 0, 2)) {
+				t.Errorf("TopStats() range = [%v, %v), want 2 days from %v", st.From, st.To, yesterday)
+			}
+			if st.Redirects != 9 || st.LinksCreated != 3 || !slices.Equal(st.Top, want) {
+				t.Errorf("TopStats() = %+v, want 9 redirects, 3 created and top %+v", st, want)
+			}
+			if st.UniqueVisitors == nil || *st.UniqueVisitors != 3 {
+				t.Errorf("TopStats() unique visitors = %v, want 3", st.UniqueVisitors)
+			}
+
+			st, err = service.TopStats(ctx, 30*24*time.Hour, 1)
+			if err != nil {
+				t.Fatalf("TopStats() unexpected error = %v", err)
+			}
+			if want := []TopLink{{Code: a, Clicks: 4}}; st.Redirects != 12 || !slices.Equal(st.Top, want) {
+				t.Errorf("TopStats(30d) = %+v, want 12 redirects and top %+v", st, want)
+			}
+
+			for _, period := range []time.Duration{0, -time.Hour, 30 * time.Minute, MaxStatsPeriod + 24*time.Hour} {
+				if _, err := service.TopStats(ctx, period, 0); !errors.Is(err, ErrInvalidPeriod) {
+					t.Errorf("TopStats(%v) error = %v, want ErrInvalidPeriod", period, err)
+				}
+			}
+		})
+	}
+}
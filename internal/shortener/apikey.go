@@ -0,0 +1,281 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes of an API key. Each allows the routes of one role (see RoleViewer,
+// RoleEditor and RoleAdmin), which the user must still have.
+const (
+	// ScopeRead allows listing links and reading their stats.
+	ScopeRead = "read"
+	// ScopeShorten allows creating and changing links.
+	ScopeShorten = "shorten"
+	// ScopeDelete allows deleting links and using the admin API.
+	ScopeDelete = "delete"
+)
+
+// AllScopes are the scopes of the keys issued by Signup and Login.
+var AllScopes = []string{ScopeShorten, ScopeRead, ScopeDelete}
+
+const (
+	// APIKeyRotationGrace is how long a rotated API key keeps working, so
+	// that clients can switch to its successor.
+	APIKeyRotationGrace = 24 * time.Hour
+
+	// lastUsedResolution is how stale the last use of a key may get before
+	// it is recorded again, so that busy keys are not written on every
+	// request.
+	lastUsedResolution = time.Minute
+)
+
+var (
+	// ErrInvalidScopes is returned for API keys requested without scopes or
+	// with unknown ones.
+	ErrInvalidScopes = errors.New("scopes must be one or more of shorten, read and delete")
+	// ErrMissingScope is returned when a key is used for something its scopes
+	// do not allow, including managing keys with scopes it lacks.
+	ErrMissingScope = errors.New("api key lacks the required scope")
+)
+
+// APIKey is a key a user authenticates with. Only the SHA-256 hash of its
+// secret is stored.
+type APIKey struct {
+	ID      uint64 `json:"id"`
+	UserID  uint64 `json:"-"`
+	KeyHash string `json:"-"`
+	// Scopes are what the key may be used for, in the order of AllScopes.
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// LastUsedAt is when the key last authenticated a request, give or take
+	// a minute.
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// expired reports whether the key no longer works at now.
+func (k *APIKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// HasScope reports whether the credential u authenticated with allows scope.
+// Users authenticated otherwise than with an API key, e.g. with an OIDC
+// token, have every scope.
+func (u *User) HasScope(scope string) bool {
+	return u.Scopes == nil || slices.Contains(u.Scopes, scope)
+}
+
+// roleScopes are the scopes API keys need to do what each role may.
+var roleScopes = map[string]string{RoleViewer: ScopeRead, RoleEditor: ScopeShorten, RoleAdmin: ScopeDelete}
+
+// May reports whether u may do what role may: u must have role, and the
+// credential it authenticated with the scope of role.
+func (u *User) May(role string) bool {
+	return u.HasRole(role) && u.HasScope(roleScopes[role])
+}
+
+// validScopes returns scopes without duplicates in the order of AllScopes, or
+// ErrInvalidScopes.
+func validScopes(scopes []string) ([]string, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidScopes, scope)
+		}
+	}
+	valid := slices.DeleteFunc(slices.Clone(AllScopes), func(scope string) bool {
+		return !slices.Contains(scopes, scope)
+	})
+	if len(valid) == 0 {
+		return nil, ErrInvalidScopes
+	}
+	return valid, nil
+}
+
+// checkScopes returns ErrMissingScope unless user has all scopes, so that a
+// key cannot be used to obtain a more powerful one.
+func checkScopes(user *User, scopes []string) error {
+	for _, scope := range scopes {
+		if !user.HasScope(scope) {
+			return fmt.Errorf("%w: %s", ErrMissingScope, scope)
+		}
+	}
+	return nil
+}
+
+// APIKeys returns the API keys of user, oldest first, including rotated
+// ones until their grace period ends.
+func (s *Service) APIKeys(ctx context.Context, user *User) ([]*APIKey, error) {
+	keys, err := s.repo.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	now := s.now()
+	return slices.DeleteFunc(keys, func(k *APIKey) bool { return k.expired(now) }), nil
+}
+
+// CreateAPIKey issues an API key for user limited to scopes and, if
+// expiresAt is set, expiring then. Returns the key with its secret, which is
+// not stored. Returns ErrInvalidScopes for invalid scopes, ErrMissingScope
+// for scopes the credential of user lacks and ErrInvalidExpiry for an
+// expiry in the past.
+func (s *Service) CreateAPIKey(ctx context.Context, user *User, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	scopes, err := validScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkScopes(user, scopes); err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(s.now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+	return s.issueAPIKey(ctx, user, scopes, expiresAt)
+}
+
+// RotateAPIKey issues a successor to the API key id of user, with the same
+// scopes and expiry, and makes the key expire after APIKeyRotationGrace.
+// Returns ErrNotFound for unknown or expired keys and ErrMissingScope if the
+// credential of user lacks some of the scopes of the key.
+func (s *Service) RotateAPIKey(ctx context.Context, user *User, id uint64) (*APIKey, string, error) {
+	old, err := s.apiKey(ctx, user, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Expiring the old key first leaves it in its grace period, rather than
+	// valid forever, if issuing the new one fails
+	grace := s.now().Add(APIKeyRotationGrace).UTC()
+	if old.ExpiresAt == nil || old.ExpiresAt.After(grace) {
+		if err := s.repo.ExpireAPIKey(ctx, old.KeyHash, grace); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, "", err
+			}
+			return nil, "", fmt.Errorf("failed to expire api key: %w", err)
+		}
+	}
+	return s.issueAPIKey(ctx, user, old.Scopes, old.ExpiresAt)
+}
+
+// RevokeAPIKey revokes the API key id of user at once. Returns ErrNotFound
+// for unknown or expired keys and ErrMissingScope if the credential of user
+// lacks some of the scopes of the key.
+func (s *Service) RevokeAPIKey(ctx context.Context, user *User, id uint64) error {
+	key, err := s.apiKey(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteAPIKey(ctx, key.KeyHash); err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return nil
+}
+
+// apiKey returns the unexpired API key id of user, which the credential of
+// user must have all the scopes of.
+func (s *Service) apiKey(ctx context.Context, user *User, id uint64) (*APIKey, error) {
+	keys, err := s.APIKeys(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(keys, func(k *APIKey) bool { return k.ID == id })
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	if err := checkScopes(user, keys[i].Scopes); err != nil {
+		return nil, err
+	}
+	return keys[i], nil
+}
+
+// touchAPIKey records that key was used at now, unless that was recorded
+// less than lastUsedResolution ago. Failing to is only logged, as it must
+// not fail the request.
+func (s *Service) touchAPIKey(ctx context.Context, key *APIKey, now time.Time) {
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < lastUsedResolution {
+		return
+	}
+	if err := s.repo.TouchAPIKey(ctx, key.KeyHash, now.UTC()); err != nil {
+		slog.WarnContext(ctx, "failed to record api key use", "key_id", key.ID, "error", err)
+	}
+}
+
+// apiKeyColumns is the column list scanned by apiKeyScan. Queries alias
+// api_keys as k.
+const apiKeyColumns = `k.id, k.user_id, k.key_hash, k.scopes, k.expires_at, k.last_used_at, k.created_at`
+
+// apiKeyScan holds the destinations of apiKeyColumns until key decodes them.
+type apiKeyScan struct {
+	apiKey            APIKey
+	scopes            string
+	expiresAt, usedAt sql.NullTime
+}
+
+func (s *apiKeyScan) dest() []any {
+	return []any{&s.apiKey.ID, &s.apiKey.UserID, &s.apiKey.KeyHash, &s.scopes, &s.expiresAt, &s.usedAt, &s.apiKey.CreatedAt}
+}
+
+func (s *apiKeyScan) key() *APIKey {
+	key := s.apiKey
+	key.Scopes = splitScopes(s.scopes)
+	if s.expiresAt.Valid {
+		key.ExpiresAt = &s.expiresAt.Time
+	}
+	if s.usedAt.Valid {
+		key.LastUsedAt = &s.usedAt.Time
+	}
+	return &key
+}
+
+// splitScopes decodes scopes stored comma-separated.
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
+
+// scanAPIKeys scans and closes rows selected with apiKeyColumns.
+func scanAPIKeys(rows *sql.Rows) ([]*APIKey, error) {
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		var s apiKeyScan
+		if err := rows.Scan(s.dest()...); err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, s.key())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// scanUserAPIKey scans a row selected with userColumns followed by
+// apiKeyColumns.
+func scanUserAPIKey(row rowScanner) (*User, *APIKey, error) {
+	var s apiKeyScan
+	user, err := scanUser(trailingColumns{row, s.dest()})
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, s.key(), nil
+}
+
+// trailingColumns scans the columns after the ones a scan function knows
+// about into dest.
+type trailingColumns struct {
+	row  rowScanner
+	dest []any
+}
+
+func (t trailingColumns) Scan(dest ...any) error {
+	return t.row.Scan(append(dest, t.dest...)...)
+}
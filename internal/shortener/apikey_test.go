@@ -0,0 +1,106 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestService_APIKeys(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(newRepo(t))
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Second)
+			service.now = func() time.Time { return now }
+
+			alice, login, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			user, err := service.Authenticate(ctx, login)
+			if err != nil || !slices.Equal(user.Scopes, AllScopes) {
+				t.Fatalf("Authenticate() = %+v, %v, want a user with all scopes", user, err)
+			}
+
+			for _, scopes := range [][]string{nil, {}, {"write"}} {
+				if _, _, err := service.CreateAPIKey(ctx, user, scopes, nil); !errors.Is(err, ErrInvalidScopes) {
+					t.Errorf("CreateAPIKey(%q) error = %v, want ErrInvalidScopes", scopes, err)
+				}
+			}
+			past := now.Add(-time.Second)
+			if _, _, err := service.CreateAPIKey(ctx, user, []string{ScopeRead}, &past); !errors.Is(err, ErrInvalidExpiry) {
+				t.Errorf("CreateAPIKey() expired error = %v, want ErrInvalidExpiry", err)
+			}
+
+			expiresAt := now.Add(30 * 24 * time.Hour)
+			key, secret, err := service.CreateAPIKey(ctx, user, []string{ScopeRead, ScopeShorten, ScopeRead}, &expiresAt)
+			if err != nil {
+				t.Fatalf("CreateAPIKey() unexpected error = %v", err)
+			}
+			if key.ID == 0 || !slices.Equal(key.Scopes, []string{ScopeShorten, ScopeRead}) || key.KeyHash == secret {
+				t.Errorf("CreateAPIKey() = %+v, want a stored key with scopes shorten and read", key)
+			}
+
+			// Keys cannot be used to obtain more powerful ones
+			scoped, err := service.Authenticate(ctx, secret)
+			if err != nil {
+				t.Fatalf("Authenticate() unexpected error = %v", err)
+			}
+			if !scoped.May(RoleEditor) || scoped.May(RoleAdmin) || scoped.ID != alice.ID {
+				t.Errorf("Authenticate() = %+v, want alice limited to shorten and read", scoped)
+			}
+			if _, _, err := service.CreateAPIKey(ctx, scoped, []string{ScopeDelete}, nil); !errors.Is(err, ErrMissingScope) {
+				t.Errorf("CreateAPIKey() beyond the caller's scopes error = %v, want ErrMissingScope", err)
+			}
+			keys, err := service.APIKeys(ctx, scoped)
+			if err != nil || len(keys) != 2 || keys[1].ID != key.ID {
+				t.Fatalf("APIKeys() = %+v, %v", keys, err)
+			}
+			if keys[1].LastUsedAt == nil || !keys[1].LastUsedAt.Equal(now) {
+				t.Errorf("APIKeys() last used = %v, want %v", keys[1].LastUsedAt, now)
+			}
+			if _, _, err := service.RotateAPIKey(ctx, scoped, keys[0].ID); !errors.Is(err, ErrMissingScope) {
+				t.Errorf("RotateAPIKey() of a more powerful key error = %v, want ErrMissingScope", err)
+			}
+
+			// Rotated keys keep working for the grace period
+			rotated, newSecret, err := service.RotateAPIKey(ctx, scoped, key.ID)
+			if err != nil {
+				t.Fatalf("RotateAPIKey() unexpected error = %v", err)
+			}
+			if rotated.ID == key.ID || !slices.Equal(rotated.Scopes, key.Scopes) || !rotated.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("RotateAPIKey() = %+v, want a new key like %+v", rotated, key)
+			}
+			now = now.Add(APIKeyRotationGrace - time.Second)
+			if _, err := service.Authenticate(ctx, secret); err != nil {
+				t.Errorf("Authenticate() within the grace period unexpected error = %v", err)
+			}
+			now = now.Add(time.Second)
+			if _, err := service.Authenticate(ctx, secret); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Authenticate() after the grace period error = %v, want ErrInvalidCredentials", err)
+			}
+			if _, _, err := service.RotateAPIKey(ctx, user, key.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("RotateAPIKey() of an expired key error = %v, want ErrNotFound", err)
+			}
+
+			if err := service.RevokeAPIKey(ctx, user, rotated.ID); err != nil {
+				t.Fatalf("RevokeAPIKey() unexpected error = %v", err)
+			}
+			if _, err := service.Authenticate(ctx, newSecret); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Authenticate() after revoke error = %v, want ErrInvalidCredentials", err)
+			}
+			if keys, err := service.APIKeys(ctx, user); err != nil || len(keys) != 1 {
+				t.Errorf("APIKeys() = %+v, %v, want only the key of the signup", keys, err)
+			}
+
+			// Keys without an expiry keep working
+			now = expiresAt
+			if _, err := service.Authenticate(ctx, login); err != nil {
+				t.Errorf("Authenticate() with a key without expiry unexpected error = %v", err)
+			}
+		})
+	}
+}
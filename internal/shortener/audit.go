@@ -0,0 +1,139 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audited actions on links.
+const (
+	AuditCreate        = "create"
+	AuditUpdate        = "update"
+	AuditDelete        = "delete"
+	AuditRestore       = "restore"
+	AuditEnable        = "enable"
+	AuditDisable       = "disable"
+	AuditSuspend       = "suspend"
+	AuditFlag          = "flag"
+	AuditSetTargets    = "set_targets"
+	AuditSetRules      = "set_rules"
+	AuditSetExperiment = "set_experiment"
+	AuditSetMetadata   = "set_metadata"
+	AuditSetAccess     = "set_access"
+	AuditImport        = "import"
+	AuditDeleteClicks  = "delete_clicks"
+)
+
+// Actors other than users (see UserActor).
+const (
+	ActorAnonymous = "anonymous"
+	ActorAdmin     = "admin"
+	// ActorSystem is used for automatic changes such as suspending reported
+	// links or flagging unsafe ones.
+	ActorSystem = "system"
+)
+
+// UserActor returns the audit actor for user, "user:<id>", or
+// ActorAnonymous for a nil user.
+func UserActor(user *User) string {
+	if user == nil {
+		return ActorAnonymous
+	}
+	return "user:" + strconv.FormatUint(user.ID, 10)
+}
+
+// AuditEntry records that Actor performed Action on the link with Code.
+// Entries are never changed or deleted, and outlive the links they refer to.
+type AuditEntry struct {
+	ID     uint64 `json:"id"`
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	Code   string `json:"code"`
+	// Detail is free-form context, e.g. the new destination of an update.
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter selects audit entries. Empty fields match everything; Since is
+// inclusive and Until exclusive.
+type AuditFilter struct {
+	Actor string
+	Code  string
+	Since time.Time
+	Until time.Time
+}
+
+// audit records an entry for the link with code and tells the audit
+// listener. Failures are logged rather than returned because the change
+// itself has already been made.
+func (s *Service) audit(ctx context.Context, actor, action, code, detail string) {
+	entry := &AuditEntry{Actor: actor, Action: action, Code: code, Detail: detail, CreatedAt: s.now()}
+	if err := s.repo.AddAudit(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "failed to record audit entry", "actor", actor, "action", action, "short_code", code, "error", err)
+	}
+	if s.auditListener != nil {
+		s.auditListener(ctx, *entry)
+	}
+}
+
+// ListAudit returns a page of the audit entries matching f, newest first, in
+// the same way as List.
+func (s *Service) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, uint64, error) {
+	entries, next, err := paginate(limit, func(n int) ([]*AuditEntry, error) {
+		return s.repo.ListAudit(ctx, f, cursor, n)
+	}, func(e *AuditEntry) uint64 { return e.ID })
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	return entries, next, nil
+}
+
+// auditQuery builds the SELECT for ListAudit. bind adds an argument and
+// returns its placeholder, so the same builder serves PostgreSQL and SQLite.
+func auditQuery(f AuditFilter, cursor uint64, limit int, bind func(any) string) string {
+	var where []string
+	if f.Actor != "" {
+		where = append(where, "actor = "+bind(f.Actor))
+	}
+	if f.Code != "" {
+		where = append(where, "code = "+bind(f.Code))
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= "+bind(f.Since.UTC()))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < "+bind(f.Until.UTC()))
+	}
+	if cursor != 0 {
+		where = append(where, "id < "+bind(int64(cursor)))
+	}
+
+	query := `SELECT id, actor, action, code, detail, created_at FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	return query + ` ORDER BY id DESC LIMIT ` + bind(limit)
+}
+
+// scanAuditEntries scans and closes rows selected by auditQuery.
+func scanAuditEntries(rows *sql.Rows, limit int) ([]*AuditEntry, error) {
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0, limit)
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Code, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit entries: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,138 @@
+package shortener
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestService_Audit(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo)
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	if _, err := service.UpdateDestination(ctx, user, code, "https://example.com/new"); err != nil {
+		t.Fatalf("UpdateDestination() unexpected error = %v", err)
+	}
+	if _, err := service.SetStatus(ctx, code, StatusDisabled); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+	if err := service.Delete(ctx, user, code); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	anonymous, err := service.Shorten(ctx, "https://example.org")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+
+	entries, next, err := service.ListAudit(ctx, AuditFilter{Code: code}, 0, 10)
+	if err != nil {
+		t.Fatalf("ListAudit() unexpected error = %v", err)
+	}
+	if next != 0 {
+		t.Errorf("ListAudit() next = %d, want 0", next)
+	}
+	actor := UserActor(user)
+	want := []AuditEntry{
+		{Actor: actor, Action: AuditDelete, Code: code},
+		{Actor: ActorAdmin, Action: AuditDisable, Code: code},
+		{Actor: actor, Action: AuditUpdate, Code: code, Detail: "https://example.com/new"},
+		{Actor: actor, Action: AuditCreate, Code: code, Detail: "https://example.com"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ListAudit() returned %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Actor != want[i].Actor || e.Action != want[i].Action || e.Code != want[i].Code || e.Detail != want[i].Detail {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
+		if e.CreatedAt.IsZero() {
+			t.Errorf("entry %d has no timestamp", i)
+		}
+	}
+
+	// Failed changes are not recorded
+	if _, err := service.SetStatus(ctx, "zzzzzz", StatusDisabled); err == nil {
+		t.Fatal("SetStatus() of unknown link succeeded")
+	}
+	entries, _, _ = service.ListAudit(ctx, AuditFilter{Actor: ActorAdmin}, 0, 10)
+	if len(entries) != 1 {
+		t.Errorf("admin entries = %+v, want only the disable", entries)
+	}
+
+	entries, _, _ = service.ListAudit(ctx, AuditFilter{Actor: ActorAnonymous}, 0, 10)
+	if len(entries) != 1 || entries[0].Code != anonymous {
+		t.Errorf("anonymous entries = %+v, want the creation of %s", entries, anonymous)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if entries, _, _ := service.ListAudit(ctx, AuditFilter{Since: future}, 0, 10); len(entries) != 0 {
+		t.Errorf("ListAudit() since the future = %+v, want none", entries)
+	}
+}
+
+func TestService_ListAuditPagination(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := service.Shorten(ctx, "https://example.com"); err != nil {
+			t.Fatalf("Shorten() unexpected error = %v", err)
+		}
+	}
+
+	page, next, err := service.ListAudit(ctx, AuditFilter{}, 0, 2)
+	if err != nil {
+		t.Fatalf("ListAudit() unexpected error = %v", err)
+	}
+	if len(page) != 2 || next != page[1].ID {
+		t.Fatalf("ListAudit() first page = %+v, next %d", page, next)
+	}
+	page, next, err = service.ListAudit(ctx, AuditFilter{}, next, 2)
+	if err != nil {
+		t.Fatalf("ListAudit() unexpected error = %v", err)
+	}
+	if len(page) != 1 || next != 0 {
+		t.Errorf("ListAudit() second page = %+v, next %d", page, next)
+	}
+}
+
+func TestService_AuditListener(t *testing.T) {
+	var heard []AuditEntry
+	service := NewService(NewInMemoryRepository(), WithAuditListener(func(ctx context.Context, e AuditEntry) {
+		heard = append(heard, e)
+	}))
+	ctx := context.Background()
+
+	code, err := service.Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	if _, err := service.SetStatus(ctx, code, StatusDisabled); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+
+	want := []AuditEntry{
+		{Actor: ActorAnonymous, Action: AuditCreate, Code: code, Detail: "https://example.com"},
+		{Actor: ActorAdmin, Action: AuditDisable, Code: code},
+	}
+	if len(heard) != len(want) {
+		t.Fatalf("listener heard %+v, want %d entries", heard, len(want))
+	}
+	for i, e := range heard {
+		if e.Actor != want[i].Actor || e.Action != want[i].Action || e.Code != want[i].Code || e.Detail != want[i].Detail {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
+		if e.ID == 0 || e.CreatedAt.IsZero() {
+			t.Errorf("entry %d = %+v, want it as recorded", i, e)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package shortener
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 )
 
 const (
@@ -10,43 +10,40 @@ const (
 	base     = uint64(len(alphabet))
 )
 
-// Encode converts a unique integer ID to a Base62 string.
-func Encode(id uint64) string {
-	if id == 0 {
-		return string(alphabet[0])
-	}
-
-	var sb strings.Builder
-	for id > 0 {
-		remainder := id % base
-		sb.WriteByte(alphabet[remainder])
-		id = id / base
-	}
-
-	// Reverse the string because we constructed it backwards
-	chars := []byte(sb.String())
-	for i, j := 0, len(chars)-1; i < j; i, j = i+1, j-1 {
-		chars[i], chars[j] = chars[j], chars[i]
-	}
-
-	return string(chars)
+// maxEncodedLen is the length of the longest code of a uint64, in the
+// smallest alphabet (Base32).
+const maxEncodedLen = 13
+
+// errEmptyCode is returned by Decode for an empty string.
+var errEmptyCode = errors.New("cannot decode empty string")
+
+// InvalidCharError is returned by Decode for a character outside the
+// alphabet.
+type InvalidCharError struct {
+	Char rune
+	// Pos is the byte offset of Char in the decoded string.
+	Pos int
+	// Alphabet is the name of the alphabet, e.g. "base62".
+	Alphabet string
 }
 
-// Decode converts a Base62 string back to a unique integer ID.
-func Decode(encoded string) (uint64, error) {
-	if encoded == "" {
-		return 0, fmt.Errorf("cannot decode empty string")
-	}
+func (e *InvalidCharError) Error() string {
+	return fmt.Sprintf("invalid character '%c' at position %d in %s string", e.Char, e.Pos, e.Alphabet)
+}
 
-	var id uint64
+// Encode converts a unique integer ID to a Base62 string.
+func Encode(id uint64) string {
+	return Base62.Encode(id)
+}
 
-	for i, char := range encoded {
-		index := strings.IndexRune(alphabet, char)
-		if index == -1 {
-			return 0, fmt.Errorf("invalid character '%c' at position %d in base62 string", char, i)
-		}
-		id = id*base + uint64(index)
-	}
+// AppendEncode appends the Base62 code of id to dst, without allocating if
+// dst has room for it.
+func AppendEncode(dst []byte, id uint64) []byte {
+	return Base62.AppendEncode(dst, id)
+}
 
-	return id, nil
+// Decode converts a Base62 string back to a unique integer ID. Characters
+// outside the alphabet are reported with an *InvalidCharError.
+func Decode(encoded string) (uint64, error) {
+	return Base62.Decode(encoded)
 }
@@ -1,6 +1,7 @@
 package shortener
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -60,6 +61,10 @@ func TestDecodeInvalid(t *testing.T) {
 		if err != nil && err.Error() != test.expectedErr {
 			t.Errorf("Decode(%q) error = %q; want %q", test.input, err.Error(), test.expectedErr)
 		}
+		var charErr *InvalidCharError
+		if !errors.As(err, &charErr) || charErr.Alphabet != "base62" {
+			t.Errorf("Decode(%q) error = %#v; want an *InvalidCharError", test.input, err)
+		}
 	}
 }
 
@@ -84,6 +89,7 @@ func BenchmarkEncode(b *testing.B) {
 		1000000,
 		18446744073709551615, // Max uint64
 	}
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		Encode(testCases[i%len(testCases)])
@@ -99,8 +105,38 @@ func BenchmarkDecode(b *testing.B) {
 		"4gfFC3",
 		"lYGhA16ahyf",
 	}
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, _ = Decode(codes[i%len(codes)])
 	}
 }
+
+func TestAppendEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  []byte
+		id   uint64
+		want string
+	}{
+		{"empty", nil, 0, "0"},
+		{"prefix", []byte("go/"), 12345, "go/3d7"},
+		{"max", nil, 18446744073709551615, "lYGhA16ahyf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AppendEncode(tt.dst, tt.id)); got != tt.want {
+				t.Errorf("AppendEncode(%q, %d) = %q; want %q", tt.dst, tt.id, got, tt.want)
+			}
+		})
+	}
+
+	buf := make([]byte, 0, maxEncodedLen)
+	if allocs := testing.AllocsPerRun(100, func() { buf = AppendEncode(buf[:0], 18446744073709551615) }); allocs != 0 {
+		t.Errorf("AppendEncode() allocs = %v; want 0", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { _, _ = Decode("lYGhA16ahyf!") }); allocs != 1 {
+		t.Errorf("Decode() of an invalid code allocs = %v; want only the error", allocs)
+	}
+}
@@ -0,0 +1,72 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestService_BurnAfterReading(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/secret", ShortenOptions{BurnAfterReading: true})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	steps := []struct {
+		name    string
+		do      func() error
+		wantErr error
+	}{
+		{name: "preview hides the destination", do: func() error { _, err := svc.Preview(ctx, code); return err }, wantErr: ErrBurnAfterReading},
+		{name: "peek does not consume", do: func() error { _, err := svc.Peek(ctx, code, Visitor{}); return err }, wantErr: ErrBurnAfterReading},
+		{name: "first redirect", do: func() error { _, err := svc.RedirectVisitor(ctx, code, Visitor{}); return err }},
+		{name: "second redirect", do: func() error { _, err := svc.RedirectVisitor(ctx, code, Visitor{}); return err }, wantErr: ErrConsumed},
+		{name: "preview once consumed", do: func() error { _, err := svc.Preview(ctx, code); return err }, wantErr: ErrConsumed},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err := step.do(); !errors.Is(err, step.wantErr) {
+				t.Errorf("error = %v, want %v", err, step.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_BurnAfterReading_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/invite", ShortenOptions{BurnAfterReading: true})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	const visitors = 20
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		redirected int
+	)
+	for range visitors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.RedirectVisitor(ctx, code, Visitor{})
+			if err != nil && !errors.Is(err, ErrConsumed) {
+				t.Errorf("RedirectVisitor() error = %v, want nil or ErrConsumed", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				redirected++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if redirected != 1 {
+		t.Errorf("%d of %d concurrent visitors were redirected, want 1", redirected, visitors)
+	}
+}
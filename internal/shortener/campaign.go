@@ -0,0 +1,279 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxCampaignNameLength caps campaign names, in characters.
+const MaxCampaignNameLength = 100
+
+var (
+	// ErrInvalidCampaign is returned for campaign names that are empty or
+	// too long, and for links assigned to a campaign their owner does not
+	// have.
+	ErrInvalidCampaign = errors.New("invalid campaign")
+	// ErrCampaignExists is returned when the owner already has a campaign
+	// with the name.
+	ErrCampaignExists = errors.New("campaign name already in use")
+)
+
+// Campaign groups links of one owner, e.g. all those printed for a product
+// launch, so that their analytics can be rolled up.
+type Campaign struct {
+	ID        uint64    `json:"id"`
+	OwnerID   uint64    `json:"-"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CampaignStats summarizes the links of a campaign over a period [From, To),
+// like TopStats does for all links.
+type CampaignStats struct {
+	Campaign *Campaign `json:"campaign"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	// Links is the number of links currently in the campaign.
+	Links int64 `json:"links"`
+	// Clicks counts the clicks of the campaign's links, including deleted
+	// ones.
+	Clicks int64 `json:"clicks"`
+	// UniqueVisitors estimates the distinct visitors of the campaign's links
+	// over the UTC days the period touches; a visitor of several of them
+	// counts once. It is nil if visitors are not counted.
+	UniqueVisitors *int64 `json:"unique_visitors"`
+	// Top lists the most clicked links of the campaign, most clicked first.
+	Top []TopLink `json:"top"`
+}
+
+// validCampaignName returns name without surrounding space, or
+// ErrInvalidCampaign.
+func validCampaignName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxCampaignNameLength {
+		return "", fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidCampaign, MaxCampaignNameLength)
+	}
+	return name, nil
+}
+
+// CreateCampaign creates a campaign of user. Returns ErrInvalidCampaign for
+// an invalid name and ErrCampaignExists if user already has one called name.
+func (s *Service) CreateCampaign(ctx context.Context, user *User, name string) (*Campaign, error) {
+	name, err := validCampaignName(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &Campaign{OwnerID: user.ID, Name: name}
+	if err := s.repo.CreateCampaign(ctx, c); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, ErrCampaignExists
+		}
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+	return c, nil
+}
+
+// Campaigns returns the campaigns of user, oldest first.
+func (s *Service) Campaigns(ctx context.Context, user *User) ([]*Campaign, error) {
+	campaigns, err := s.repo.ListCampaigns(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	return campaigns, nil
+}
+
+// Campaign returns the campaign with ID id, which user must own. Campaigns
+// of other users are reported as ErrNotFound.
+func (s *Service) Campaign(ctx context.Context, user *User, id uint64) (*Campaign, error) {
+	c, err := s.repo.GetCampaign(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.OwnerID != user.ID {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// RenameCampaign renames the campaign with ID id, which user must own, with
+// the same errors as CreateCampaign.
+func (s *Service) RenameCampaign(ctx context.Context, user *User, id uint64, name string) (*Campaign, error) {
+	name, err := validCampaignName(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.Campaign(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = name
+	if err := s.repo.UpdateCampaign(ctx, c); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, ErrCampaignExists
+		}
+		return nil, fmt.Errorf("failed to rename campaign: %w", err)
+	}
+	return c, nil
+}
+
+// DeleteCampaign deletes the campaign with ID id, which user must own. Its
+// links are kept, outside of any campaign.
+func (s *Service) DeleteCampaign(ctx context.Context, user *User, id uint64) error {
+	c, err := s.Campaign(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteCampaign(ctx, c); err != nil {
+		return fmt.Errorf("failed to delete campaign: %w", err)
+	}
+	return nil
+}
+
+// CampaignLinks returns a page of the links in the campaign with ID id,
+// which user must own, in the same way as ListOwned.
+func (s *Service) CampaignLinks(ctx context.Context, user *User, id uint64, cursor uint64, limit int) ([]*Link, uint64, error) {
+	if _, err := s.Campaign(ctx, user, id); err != nil {
+		return nil, 0, err
+	}
+	links, next, err := paginate(limit, func(n int) ([]*Link, error) {
+		return s.repo.ListByCampaign(ctx, id, cursor, n)
+	}, linkID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list campaign urls: %w", err)
+	}
+	if err := s.attachHealth(ctx, links); err != nil {
+		return nil, 0, err
+	}
+	return links, next, nil
+}
+
+// CampaignStats returns the clicks, unique visitors and the limit most
+// clicked links of the campaign with ID id, which user must own, over the
+// period up to now. The period and limit work as in TopStats.
+func (s *Service) CampaignStats(ctx context.Context, user *User, id uint64, period time.Duration, limit int) (*CampaignStats, error) {
+	limit, err := checkStatsPeriod(period, limit)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.Campaign(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	granularity, from, to := statsRange(s.now(), period)
+	st, err := s.repo.CampaignStats(ctx, c.ID, granularity, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign stats: %w", err)
+	}
+	st.Campaign, st.From, st.To = c, from, to
+
+	if s.visitors != nil {
+		var ids []uint64
+		for cursor := uint64(0); ; {
+			links, err := s.repo.ListByCampaign(ctx, c.ID, cursor, MaxListLimit)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list campaign urls: %w", err)
+			}
+			for _, link := range links {
+				ids = append(ids, link.ID)
+			}
+			if len(links) < MaxListLimit {
+				break
+			}
+			cursor = links[len(links)-1].ID
+		}
+		n, err := s.visitors.CountLinks(ctx, ids, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count visitors: %w", err)
+		}
+		st.UniqueVisitors = &n
+	}
+	return st, nil
+}
+
+// checkCampaign returns ErrInvalidCampaign unless the campaign with ID id
+// exists and belongs to owner.
+func (s *Service) checkCampaign(ctx context.Context, owner *User, id uint64) error {
+	if owner == nil {
+		return fmt.Errorf("%w: anonymous links cannot join a campaign", ErrInvalidCampaign)
+	}
+	if _, err := s.Campaign(ctx, owner, id); errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: unknown campaign %d", ErrInvalidCampaign, id)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// campaignStatsQueries returns the queries of CampaignStats for the rollup
+// table, with bind rendering the n-th placeholder of the dialect. The links
+// query takes the campaign, the clicks query the campaign and the range and
+// the top links query the campaign, the range and the limit.
+func campaignStatsQueries(table string, bind func(n int) string) (links, clicks, top string) {
+	campaign, from, to := bind(1), bind(2), bind(3)
+	links = fmt.Sprintf(`SELECT COUNT(*) FROM urls WHERE campaign_id = %s AND deleted_at IS NULL`, campaign)
+	clicks = fmt.Sprintf(`SELECT COALESCE(SUM(c.clicks), 0) FROM %s c JOIN urls u ON u.id = c.url_id
+		WHERE u.campaign_id = %s AND c.bucket >= %s AND c.bucket < %s`, table, campaign, from, to)
+	top = fmt.Sprintf(`SELECT u.id, u.code, SUM(c.clicks) AS total FROM %s c JOIN urls u ON u.id = c.url_id
+		WHERE u.campaign_id = %s AND c.bucket >= %s AND c.bucket < %s AND u.deleted_at IS NULL
+		GROUP BY u.id, u.code ORDER BY total DESC, u.id LIMIT %s`, table, campaign, from, to, bind(4))
+	return links, clicks, top
+}
+
+// queryCampaignStats runs the queries of campaignStatsQueries.
+func queryCampaignStats(ctx context.Context, db *sql.DB, table string, bind func(n int) string, campaignID uint64, from, to any, limit int) (*CampaignStats, error) {
+	linksQuery, clicksQuery, topQuery := campaignStatsQueries(table, bind)
+	var st CampaignStats
+	if err := db.QueryRowContext(ctx, linksQuery, int64(campaignID)).Scan(&st.Links); err != nil {
+		return nil, fmt.Errorf("failed to count campaign urls: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, clicksQuery, int64(campaignID), from, to).Scan(&st.Clicks); err != nil {
+		return nil, fmt.Errorf("failed to count campaign clicks: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, topQuery, int64(campaignID), from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top campaign urls: %w", err)
+	}
+	defer rows.Close()
+	st.Top = []TopLink{}
+	for rows.Next() {
+		var link Link
+		var code sql.NullString
+		var clicks int64
+		if err := rows.Scan(&link.ID, &code, &clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan top campaign url: %w", err)
+		}
+		link.Code = code.String
+		st.Top = append(st.Top, TopLink{Code: link.ShortCode(), Clicks: clicks})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate top campaign urls: %w", err)
+	}
+	return &st, nil
+}
+
+// scanCampaigns scans and closes rows selected with campaignColumns.
+func scanCampaigns(rows *sql.Rows) ([]*Campaign, error) {
+	defer rows.Close()
+	campaigns := []*Campaign{}
+	for rows.Next() {
+		var c Campaign
+		if err := rows.Scan(&c.ID, &c.OwnerID, &c.Name, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign: %w", err)
+		}
+		campaigns = append(campaigns, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read campaigns: %w", err)
+	}
+	return campaigns, nil
+}
+
+// campaignColumns are the columns scanned by scanCampaigns.
+const campaignColumns = `id, owner_id, name, created_at`
@@ -0,0 +1,213 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_Campaigns(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(newRepo(t))
+			ctx := context.Background()
+
+			alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			bob, _, err := service.Signup(ctx, "bob@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+
+			launch, err := service.CreateCampaign(ctx, alice, "  Spring launch ")
+			if err != nil {
+				t.Fatalf("CreateCampaign() unexpected error = %v", err)
+			}
+			if launch.ID == 0 || launch.Name != "Spring launch" || launch.CreatedAt.IsZero() {
+				t.Errorf("CreateCampaign() = %+v, want a stored campaign named Spring launch", launch)
+			}
+			if _, err := service.CreateCampaign(ctx, alice, "Spring launch"); !errors.Is(err, ErrCampaignExists) {
+				t.Errorf("CreateCampaign() duplicate error = %v, want ErrCampaignExists", err)
+			}
+			for _, name := range []string{"", "   ", strings.Repeat("x", MaxCampaignNameLength+1)} {
+				if _, err := service.CreateCampaign(ctx, alice, name); !errors.Is(err, ErrInvalidCampaign) {
+					t.Errorf("CreateCampaign(%q) error = %v, want ErrInvalidCampaign", name, err)
+				}
+			}
+			// Names are unique per owner only
+			if _, err := service.CreateCampaign(ctx, bob, "Spring launch"); err != nil {
+				t.Fatalf("CreateCampaign() for another owner unexpected error = %v", err)
+			}
+			newsletter, err := service.CreateCampaign(ctx, alice, "Newsletter")
+			if err != nil {
+				t.Fatalf("CreateCampaign() unexpected error = %v", err)
+			}
+
+			campaigns, err := service.Campaigns(ctx, alice)
+			if err != nil {
+				t.Fatalf("Campaigns() unexpected error = %v", err)
+			}
+			if len(campaigns) != 2 || campaigns[0].ID != launch.ID || campaigns[1].ID != newsletter.ID {
+				t.Errorf("Campaigns() = %+v, want Spring launch and Newsletter", campaigns)
+			}
+			if _, err := service.Campaign(ctx, bob, launch.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Campaign() of another owner error = %v, want ErrNotFound", err)
+			}
+
+			if _, err := service.RenameCampaign(ctx, alice, newsletter.ID, "Spring launch"); !errors.Is(err, ErrCampaignExists) {
+				t.Errorf("RenameCampaign() to a taken name error = %v, want ErrCampaignExists", err)
+			}
+			renamed, err := service.RenameCampaign(ctx, alice, newsletter.ID, "Weekly newsletter")
+			if err != nil || renamed.Name != "Weekly newsletter" {
+				t.Fatalf("RenameCampaign() = %+v, %v", renamed, err)
+			}
+			if c, err := service.Campaign(ctx, alice, newsletter.ID); err != nil || c.Name != "Weekly newsletter" {
+				t.Errorf("Campaign() after rename = %+v, %v", c, err)
+			}
+
+			// Links join campaigns of their owner only
+			code, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: alice, CampaignID: launch.ID})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			for _, opts := range []ShortenOptions{
+				{Owner: bob, CampaignID: launch.ID},
+				{CampaignID: launch.ID},
+				{Owner: alice, CampaignID: 999},
+			} {
+				if _, err := service.ShortenWithOptions(ctx, "https://example.com", opts); !errors.Is(err, ErrInvalidCampaign) {
+					t.Errorf("ShortenWithOptions(%+v) error = %v, want ErrInvalidCampaign", opts, err)
+				}
+			}
+			other, err := service.ShortenWithOptions(ctx, "https://example.com/b", ShortenOptions{Owner: alice})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			moved := launch.ID
+			if _, err := service.UpdateLink(ctx, alice, other, LinkUpdate{CampaignID: &moved}); err != nil {
+				t.Fatalf("UpdateLink() unexpected error = %v", err)
+			}
+			if _, err := service.UpdateLink(ctx, bob, other, LinkUpdate{CampaignID: &moved}); !errors.Is(err, ErrForbidden) {
+				t.Errorf("UpdateLink() by another user error = %v, want ErrForbidden", err)
+			}
+
+			links, next, err := service.CampaignLinks(ctx, alice, launch.ID, 0, 0)
+			if err != nil {
+				t.Fatalf("CampaignLinks() unexpected error = %v", err)
+			}
+			if len(links) != 2 || links[0].ShortCode() != other || links[1].ShortCode() != code || next != 0 {
+				t.Errorf("CampaignLinks() = %d links, next %d, want %s and %s", len(links), next, other, code)
+			}
+			if _, _, err := service.CampaignLinks(ctx, bob, launch.ID, 0, 0); !errors.Is(err, ErrNotFound) {
+				t.Errorf("CampaignLinks() of another owner error = %v, want ErrNotFound", err)
+			}
+
+			// Deleting a campaign keeps its links
+			if err := service.DeleteCampaign(ctx, bob, launch.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("DeleteCampaign() of another owner error = %v, want ErrNotFound", err)
+			}
+			if err := service.DeleteCampaign(ctx, alice, launch.ID); err != nil {
+				t.Fatalf("DeleteCampaign() unexpected error = %v", err)
+			}
+			if _, err := service.Campaign(ctx, alice, launch.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Campaign() after delete error = %v, want ErrNotFound", err)
+			}
+			link, err := service.Resolve(ctx, code)
+			if err != nil || link.CampaignID != 0 {
+				t.Errorf("Resolve() after campaign delete = %+v, %v, want the link outside of any campaign", link, err)
+			}
+		})
+	}
+}
+
+func TestService_CampaignStats(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			visitors := &fakeVisitors{}
+			service := NewService(newRepo(t), WithVisitorCounter(visitors))
+			ctx := context.Background()
+
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			campaign, err := service.CreateCampaign(ctx, user, "Launch")
+			if err != nil {
+				t.Fatalf("CreateCampaign() unexpected error = %v", err)
+			}
+			var codes []string
+			for _, id := range []uint64{campaign.ID, campaign.ID, campaign.ID, 0} {
+				code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user, CampaignID: id})
+				if err != nil {
+					t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+				}
+				codes = append(codes, code)
+			}
+			a, b, c, outside := codes[0], codes[1], codes[2], codes[3]
+
+			yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+			click := func(code string, at time.Time, visitor string) {
+				t.Helper()
+				service.now = func() time.Time { return at }
+				if _, err := service.RedirectVisitor(ctx, code, Visitor{ID: visitor}); err != nil {
+					t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+				}
+			}
+			for range 3 {
+				click(b, yesterday.Add(10*time.Hour), "v1")
+			}
+			click(a, yesterday.Add(11*time.Hour), "v1")
+			click(a, yesterday.Add(11*time.Hour), "v2")
+			for range 4 {
+				click(c, yesterday.Add(12*time.Hour), "v3")
+			}
+			click(outside, yesterday.Add(12*time.Hour), "v4")
+			click(a, yesterday.AddDate(0, 0, -10), "v5")
+			if _, err := service.RollupClicks(ctx); err != nil {
+				t.Fatalf("RollupClicks() unexpected error = %v", err)
+			}
+			if err := service.Delete(ctx, user, c); err != nil {
+				t.Fatalf("Delete() unexpected error = %v", err)
+			}
+			service.now = time.Now
+
+			st, err := service.CampaignStats(ctx, user, campaign.ID, 2*24*time.Hour, 0)
+			if err != nil {
+				t.Fatalf("CampaignStats() unexpected error = %v", err)
+			}
+			// Deleted links still count towards clicks but are not ranked
+			want := []TopLink{{Code: b, Clicks: 3}, {Code: a, Clicks: 2}}
+			if st.Campaign.ID != campaign.ID || !st.From.Equal(yesterday) || !st.To.Equal(yesterday.AddDate(0, 0, 2)) {
+				t.Errorf("CampaignStats() = %+v, want campaign %d over 2 days from %v", st, campaign.ID, yesterday)
+			}
+			if st.Links != 2 || st.Clicks != 9 || !slices.Equal(st.Top, want) {
+				t.Errorf("CampaignStats() = %+v, want 2 links, 9 clicks and top %+v", st, want)
+			}
+			// The visitors of deleted links are no longer counted
+			if st.UniqueVisitors == nil || *st.UniqueVisitors != 2 {
+				t.Errorf("CampaignStats() unique visitors = %v, want 2", st.UniqueVisitors)
+			}
+
+			st, err = service.CampaignStats(ctx, user, campaign.ID, 30*24*time.Hour, 1)
+			if err != nil {
+				t.Fatalf("CampaignStats() unexpected error = %v", err)
+			}
+			// Ties go to the older link
+			if want := []TopLink{{Code: a, Clicks: 3}}; st.Clicks != 10 || !slices.Equal(st.Top, want) {
+				t.Errorf("CampaignStats(30d) = %+v, want 10 clicks and top %+v", st, want)
+			}
+
+			if _, err := service.CampaignStats(ctx, user, campaign.ID, 30*time.Minute, 0); !errors.Is(err, ErrInvalidPeriod) {
+				t.Errorf("CampaignStats(30m) error = %v, want ErrInvalidPeriod", err)
+			}
+			if _, err := service.CampaignStats(ctx, user, campaign.ID+1, 24*time.Hour, 0); !errors.Is(err, ErrNotFound) {
+				t.Errorf("CampaignStats() of unknown campaign error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/hszk-dev/url-shortener/internal/jobs"
+)
+
+// rescanBatchSize is the number of stored links queued per Rescan call.
+// It matches the Safe Browsing Lookup API limit of 500 URLs per request.
+const rescanBatchSize = 500
+
+// ErrUnsafeURL is returned for destinations a URLChecker reports as
+// malicious, both when shortening and when following a flagged link.
+var ErrUnsafeURL = errors.New("url is flagged as unsafe")
+
+// URLChecker screens destination URLs against a reputation source such as
+// Google Safe Browsing.
+type URLChecker interface {
+	// Check returns the threat type (e.g. "MALWARE") of every URL in urls
+	// that is known to be unsafe. URLs missing from the result are safe.
+	Check(ctx context.Context, urls []string) (map[string]string, error)
+}
+
+// screen rejects originalURL if it is too long, its domain is not allowed,
+// it is itself a short link or the checker knows it to be unsafe. Checker
+// failures are logged and the URL is let through: an outage of the
+// reputation service should not take link creation down with it, and Rescan
+// catches anything missed.
+func (s *Service) screen(ctx context.Context, originalURL string) error {
+	if len(originalURL) > s.maxURLLength {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrURLTooLong, len(originalURL), s.maxURLLength)
+	}
+	if err := s.checkDestinationDomain(ctx, originalURL); err != nil {
+		return err
+	}
+	if err := s.checkRecursive(ctx, originalURL); err != nil {
+		return err
+	}
+	if s.checker == nil {
+		return nil
+	}
+	threats, err := s.checker.Check(ctx, []string{originalURL})
+	if err != nil {
+		slog.WarnContext(ctx, "url check failed, allowing url", "error", err)
+		return nil
+	}
+	if threat, ok := threats[originalURL]; ok {
+		slog.InfoContext(ctx, "rejected unsafe url", "threat", threat)
+		return ErrUnsafeURL
+	}
+	return nil
+}
+
+// Rescan queues the check of the next batch of stored links as a
+// JobCheckURLs job, which flags those whose destination has become known as
+// unsafe since they were created. Each call continues where the previous
+// one stopped and wraps around after the oldest link, so calling it
+// periodically eventually covers every link. It returns the number of links
+// queued for checking.
+func (s *Service) Rescan(ctx context.Context) (int, error) {
+	if s.checker == nil {
+		return 0, nil
+	}
+
+	s.rescanMu.Lock()
+	defer s.rescanMu.Unlock()
+
+	links, err := s.repo.List(ctx, s.rescanCursor, rescanBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list urls: %w", err)
+	}
+	next := uint64(0)
+	if len(links) == rescanBatchSize {
+		next = links[len(links)-1].ID
+	}
+
+	now := s.now()
+	job := checkJob{Links: make([]checkedLink, 0, len(links))}
+	for _, link := range links {
+		if link.IsFlagged() || link.IsExpired(now) {
+			continue
+		}
+		job.Links = append(job.Links, checkedLink{ID: link.ID, URL: link.OriginalURL})
+	}
+	if len(job.Links) > 0 {
+		if err := s.queue.Enqueue(ctx, JobCheckURLs, job); err != nil {
+			// Keep the cursor so the batch is queued again
+			return 0, fmt.Errorf("failed to queue url check: %w", err)
+		}
+	}
+	s.rescanCursor = next
+	return len(job.Links), nil
+}
+
+// checkJob is the payload of a JobCheckURLs job: links with the destination
+// they had when the job was queued.
+type checkJob struct {
+	Links []checkedLink `json:"links"`
+}
+
+type checkedLink struct {
+	ID  uint64 `json:"id"`
+	URL string `json:"url"`
+}
+
+// runCheckJob screens the destinations of a JobCheckURLs job and flags the
+// links whose destination is unsafe, unless they have been flagged or given
+// another destination since. A checker failure is retried with the job.
+func (s *Service) runCheckJob(ctx context.Context, payload json.RawMessage) error {
+	var job checkJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("failed to decode check job: %w", err))
+	}
+	urls := make([]string, 0, len(job.Links))
+	seen := make(map[string]bool, len(job.Links))
+	for _, l := range job.Links {
+		if !seen[l.URL] {
+			seen[l.URL] = true
+			urls = append(urls, l.URL)
+		}
+	}
+	threats, err := s.checker.Check(ctx, urls)
+	if err != nil {
+		return fmt.Errorf("failed to check urls: %w", err)
+	}
+
+	for _, l := range job.Links {
+		threat, ok := threats[l.URL]
+		if !ok {
+			continue
+		}
+		link, err := s.repo.Get(ctx, l.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get url %d: %w", l.ID, err)
+		}
+		if link.IsFlagged() || link.OriginalURL != l.URL {
+			continue
+		}
+		if err := s.repo.SetStatus(ctx, link, StatusFlagged); err != nil {
+			return fmt.Errorf("failed to flag url %d: %w", link.ID, err)
+		}
+		slog.WarnContext(ctx, "flagged unsafe link", "short_code", link.ShortCode(), "threat", threat)
+		s.audit(ctx, ActorSystem, AuditFlag, link.ShortCode(), threat)
+	}
+	return nil
+}
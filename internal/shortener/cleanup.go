@@ -0,0 +1,176 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// cleanBatchSize is the number of Redis keys CleanCache scans and checks
+// against the database at a time.
+const cleanBatchSize = 1000
+
+// linkOfKey returns the ID or code of the link that a cache entry, stale
+// copy or click counter written by the repository belongs to. ok is false
+// for other keys, such as the click queues.
+func linkOfKey(key string) (id uint64, code string, ok bool) {
+	rest, found := strings.CutPrefix(key, "shorturl:")
+	if !found {
+		return 0, "", false
+	}
+	rest = strings.TrimPrefix(rest, "stale:")
+	kind, value, _ := strings.Cut(rest, ":")
+	switch kind {
+	case "id", "clicks":
+		id, err := strconv.ParseUint(value, 10, 64)
+		return id, "", err == nil
+	case "code":
+		return 0, value, value != ""
+	}
+	return 0, "", false
+}
+
+// liveLinks returns which of ids and codes belong to links that exist and
+// are not deleted.
+type liveLinks func(ctx context.Context, ids []int64, codes []string) (map[uint64]bool, map[string]bool, error)
+
+// CleanCache deletes the Redis keys of links that no longer exist or are
+// deleted: their cache entries, stale copies and click counters. Deletes
+// normally remove them right away, but keys are left behind when Redis was
+// unavailable at the time or a link was removed directly in the database.
+// It returns the number of keys deleted.
+func (r *PostgresRedisRepository) CleanCache(ctx context.Context) (int, error) {
+	if r.redis == nil {
+		return 0, nil
+	}
+	return r.cleanCache(ctx, r.liveLinks)
+}
+
+// liveLinks implements liveLinks with one query.
+func (r *PostgresRedisRepository) liveLinks(ctx context.Context, ids []int64, codes []string) (map[uint64]bool, map[string]bool, error) {
+	liveIDs, liveCodes := make(map[uint64]bool), make(map[string]bool)
+	rows, err := r.db.QueryContext(ctx, `SELECT id, code FROM urls WHERE deleted_at IS NULL AND (id = ANY($1) OR code = ANY($2))`,
+		pq.Array(ids), pq.Array(codes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check cached urls: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		var code *string
+		if err := rows.Scan(&id, &code); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan cached url: %w", err)
+		}
+		liveIDs[id] = true
+		if code != nil {
+			liveCodes[*code] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read cached urls: %w", err)
+	}
+	return liveIDs, liveCodes, nil
+}
+
+// cleanCache scans the keys of the repository in Redis and deletes those of
+// links that live does not report, batch by batch.
+func (r *PostgresRedisRepository) cleanCache(ctx context.Context, live liveLinks) (int, error) {
+	total := 0
+	var cursor uint64
+	for {
+		keys, next, err := r.redis.Scan(ctx, cursor, "shorturl:*", cleanBatchSize).Result()
+		if err != nil {
+			return total, fmt.Errorf("failed to scan cache: %w", err)
+		}
+		n, err := r.cleanKeys(ctx, keys, live)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if total > 0 {
+		cacheMetrics.Add("cleaned", int64(total))
+	}
+	return total, nil
+}
+
+func (r *PostgresRedisRepository) cleanKeys(ctx context.Context, keys []string, live liveLinks) (int, error) {
+	var ids []int64
+	var codes []string
+	for _, key := range keys {
+		if id, code, ok := linkOfKey(key); ok && code != "" {
+			codes = append(codes, code)
+		} else if ok {
+			ids = append(ids, int64(id))
+		}
+	}
+	if len(ids) == 0 && len(codes) == 0 {
+		return 0, nil
+	}
+	liveIDs, liveCodes, err := live(ctx, ids, codes)
+	if err != nil {
+		return 0, err
+	}
+
+	var orphaned []string
+	for _, key := range keys {
+		id, code, ok := linkOfKey(key)
+		switch {
+		case !ok:
+		case code != "" && !liveCodes[code], code == "" && !liveIDs[id]:
+			orphaned = append(orphaned, key)
+		}
+	}
+	if len(orphaned) == 0 {
+		return 0, nil
+	}
+	if r.local != nil {
+		r.local.Delete(orphaned...)
+	}
+	if err := r.redis.Unlink(ctx, orphaned...).Err(); err != nil {
+		return 0, fmt.Errorf("failed to delete orphaned cache keys: %w", err)
+	}
+	return len(orphaned), nil
+}
+
+// CleanCache deletes the Redis keys of links that no longer exist on any
+// shard (see PostgresRedisRepository.CleanCache). The shards share one
+// Redis instance, so it is scanned once, through the primary, and every
+// shard is asked about the links found. It does nothing unless the shards
+// are PostgreSQL repositories.
+func (r *ShardedRepository) CleanCache(ctx context.Context) (int, error) {
+	var pgs []*PostgresRedisRepository
+	for _, shard := range r.shards {
+		pg, ok := shard.(*PostgresRedisRepository)
+		if !ok {
+			return 0, nil
+		}
+		pgs = append(pgs, pg)
+	}
+	if pgs[0].redis == nil {
+		return 0, nil
+	}
+	return pgs[0].cleanCache(ctx, func(ctx context.Context, ids []int64, codes []string) (map[uint64]bool, map[string]bool, error) {
+		liveIDs, liveCodes := make(map[uint64]bool), make(map[string]bool)
+		for i, pg := range pgs {
+			shardIDs, shardCodes, err := pg.liveLinks(ctx, ids, codes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("shard %d: %w", i, err)
+			}
+			for id := range shardIDs {
+				liveIDs[id] = true
+			}
+			for code := range shardCodes {
+				liveCodes[code] = true
+			}
+		}
+		return liveIDs, liveCodes, nil
+	})
+}
@@ -0,0 +1,78 @@
+package shortener
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBufferedClicks bounds the distinct link, hour and source counts
+// PostgresRedisRepository buffers in memory while Redis is unavailable.
+const maxBufferedClicks = 10000
+
+// clickBuffer aggregates click counts per link, hour and source in memory
+// until RollupClicks writes them, so that an outage of Redis does not turn
+// every redirect into a database write.
+type clickBuffer struct {
+	mu     sync.Mutex
+	max    int
+	counts map[sourceKey]int64
+}
+
+func newClickBuffer(max int) *clickBuffer {
+	return &clickBuffer{max: max, counts: make(map[sourceKey]int64)}
+}
+
+// add buffers counts unless that would hold more than max distinct counts,
+// and reports whether it did.
+func (b *clickBuffer) add(counts []clickCount) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	added := 0
+	for _, c := range counts {
+		if _, ok := b.counts[bufferKey(c)]; !ok {
+			added++
+		}
+	}
+	if len(b.counts)+added > b.max {
+		return false
+	}
+	for _, c := range counts {
+		b.counts[bufferKey(c)] += c.clicks
+	}
+	return true
+}
+
+// restore puts back counts taken by take that could not be written,
+// regardless of max.
+func (b *clickBuffer) restore(counts []clickCount) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range counts {
+		b.counts[bufferKey(c)] += c.clicks
+	}
+}
+
+// take removes and returns all buffered counts.
+func (b *clickBuffer) take() []clickCount {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make([]clickCount, 0, len(b.counts))
+	for k, n := range b.counts {
+		counts = append(counts, clickCount{linkID: k.linkID, start: time.Unix(k.start, 0).UTC(), dimension: k.dimension, value: k.value, clicks: n})
+	}
+	clear(b.counts)
+	return counts
+}
+
+// len returns the number of distinct buffered counts.
+func (b *clickBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.counts)
+}
+
+func bufferKey(c clickCount) sourceKey {
+	return sourceKey{bucketKey{c.linkID, truncateBucket(c.start, GranularityHour).Unix()}, c.dimension, c.value}
+}
@@ -0,0 +1,221 @@
+package shortener
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const (
+	// MinRandomCodeLength keeps random codes hard to guess.
+	MinRandomCodeLength = 5
+	// MaxRandomCodeLength is the longest code whose ID still fits in a
+	// BIGINT column (62^10 < 2^63 <= 62^11).
+	MaxRandomCodeLength = 10
+	// DefaultRandomCodeLength gives 62^7 (about 3.5 trillion) possible codes.
+	DefaultRandomCodeLength = 7
+
+	// maxGenerateAttempts bounds the retries when a generated code is taken.
+	maxGenerateAttempts = 5
+
+	// MinObfuscationKeyLength rejects keys that are easy to brute-force.
+	MinObfuscationKeyLength = 16
+	// obfuscatedBits is the size of the permuted ID domain: 2^40 (about
+	// 1.1 trillion) links, with codes of at most 7 characters.
+	obfuscatedBits = 40
+	// feistelRounds is enough rounds for a pseudorandom permutation.
+	feistelRounds = 4
+
+	// DefaultIDBlockSize is the number of IDs BlockCodes reserves at once.
+	DefaultIDBlockSize = 100
+	// MaxIDBlockSize bounds the IDs lost when an instance stops with most
+	// of its block unused.
+	MaxIDBlockSize = 100000
+)
+
+// ErrSequenceExhausted is returned once the sequence outgrows the
+// obfuscated ID domain.
+var ErrSequenceExhausted = errors.New("id sequence exceeds the obfuscated code space")
+
+// CodeStrategy assigns short codes to links created without a custom alias.
+type CodeStrategy interface {
+	// Assign prepares link before it is saved. It may pin link.ID, in which
+	// case the short code is Encode(link.ID) and a conflicting save is retried
+	// with a fresh assignment. Leaving link.ID zero lets the repository
+	// allocate the next sequential ID.
+	Assign(ctx context.Context, repo Repository, link *Link) error
+}
+
+// SequentialCodes encodes the repository's sequential IDs. Codes are as short
+// as possible but reveal creation volume and can be enumerated.
+type SequentialCodes struct{}
+
+func (SequentialCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	return nil
+}
+
+// RandomCodes pins links to cryptographically random IDs whose Base62
+// encoding is exactly Length characters, so codes are unguessable and do
+// not reveal how many links exist.
+//
+// Random IDs share the primary key namespace with sequential IDs and pinned
+// aliases, so uniqueness is enforced by the database. As IDs no longer follow
+// creation order, listings ordered by ID are not chronological in this mode.
+type RandomCodes struct {
+	Length int
+}
+
+// NewRandomCodes returns a RandomCodes strategy producing codes of length
+// characters.
+func NewRandomCodes(length int) (RandomCodes, error) {
+	if length < MinRandomCodeLength || length > MaxRandomCodeLength {
+		return RandomCodes{}, fmt.Errorf("random code length must be between %d and %d", MinRandomCodeLength, MaxRandomCodeLength)
+	}
+	return RandomCodes{Length: length}, nil
+}
+
+// Assign picks an ID uniformly from [62^(Length-1), 62^Length), the IDs
+// whose canonical encoding has exactly Length characters.
+func (g RandomCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	lo := new(big.Int).Exp(big.NewInt(int64(base)), big.NewInt(int64(g.Length-1)), nil)
+	span := new(big.Int).Mul(lo, big.NewInt(int64(base-1)))
+
+	n, err := rand.Int(rand.Reader, span)
+	if err != nil {
+		return fmt.Errorf("failed to generate random code: %w", err)
+	}
+	link.ID = n.Add(n, lo).Uint64()
+	return nil
+}
+
+// ObfuscatedCodes pins each link to a keyed permutation of the next
+// sequential ID, computed with a Feistel network over a 40-bit domain. Codes
+// are still derived from the sequence, so two links never get the same code
+// and no random collision checks are needed, but without the key they
+// reveal nothing about creation order or volume and cannot be enumerated.
+//
+// The key must stay fixed: codes are not re-derived for existing links, but a
+// new key maps new sequence values onto codes unrelated to the old ones, and
+// a collision with an existing link is then only caught by the retry.
+type ObfuscatedCodes struct {
+	key []byte
+}
+
+// NewObfuscatedCodes returns an ObfuscatedCodes strategy keyed with key.
+func NewObfuscatedCodes(key []byte) (*ObfuscatedCodes, error) {
+	if len(key) < MinObfuscationKeyLength {
+		return nil, fmt.Errorf("obfuscation key must be at least %d bytes", MinObfuscationKeyLength)
+	}
+	return &ObfuscatedCodes{key: key}, nil
+}
+
+func (g *ObfuscatedCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	for {
+		seq, err := repo.NextID(ctx)
+		if err != nil {
+			return err
+		}
+		if seq >= 1<<obfuscatedBits {
+			return ErrSequenceExhausted
+		}
+		// ID 0 means "allocate one"; its preimage is simply skipped
+		if id := g.permute(seq); id != 0 {
+			link.ID = id
+			return nil
+		}
+	}
+}
+
+// permute applies a balanced Feistel network to the low obfuscatedBits of
+// x. Every round is invertible, so the result is a bijection on the domain.
+func (g *ObfuscatedCodes) permute(x uint64) uint64 {
+	const half = obfuscatedBits / 2
+	const mask = 1<<half - 1
+
+	left, right := x>>half&mask, x&mask
+	for round := 0; round < feistelRounds; round++ {
+		left, right = right, left^(g.round(round, right)&mask)
+	}
+	return left<<half | right
+}
+
+// round is the Feistel round function: HMAC-SHA256 of the round number and
+// the right half.
+func (g *ObfuscatedCodes) round(round int, right uint64) uint64 {
+	var buf [9]byte
+	buf[0] = byte(round)
+	binary.BigEndian.PutUint64(buf[1:], right)
+
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write(buf[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// IDGenerator issues unique IDs without involving the repository.
+type IDGenerator interface {
+	Next() (uint64, error)
+}
+
+// SnowflakeCodes pins links to IDs from a Snowflake-style generator (see
+// internal/idgen), which combines a timestamp with the worker ID of the
+// instance. Instances with distinct worker IDs assign IDs without
+// coordinating, so the INSERT no longer decides which code a link gets.
+//
+// Codes are about 10 characters long and reveal the creation time, but not
+// how many links exist.
+type SnowflakeCodes struct {
+	IDs IDGenerator
+}
+
+func (g SnowflakeCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	id, err := g.IDs.Next()
+	if err != nil {
+		return fmt.Errorf("failed to generate id: %w", err)
+	}
+	link.ID = id
+	return nil
+}
+
+// BlockCodes hands out sequential IDs from blocks reserved with
+// Repository.ReserveIDs, so that saving a link is a single INSERT with a
+// known ID instead of a round trip to the sequence per link. Codes are as
+// short as sequential ones, but links created by different instances at the
+// same time get IDs from different blocks, and the unused rest of a block is
+// skipped when the instance stops.
+type BlockCodes struct {
+	size uint64
+
+	mu   sync.Mutex
+	next uint64
+	end  uint64
+}
+
+// NewBlockCodes returns a BlockCodes strategy reserving size IDs at a time.
+func NewBlockCodes(size int) (*BlockCodes, error) {
+	if size < 1 || size > MaxIDBlockSize {
+		return nil, fmt.Errorf("id block size must be between 1 and %d", MaxIDBlockSize)
+	}
+	return &BlockCodes{size: uint64(size)}, nil
+}
+
+func (g *BlockCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == g.end {
+		first, err := repo.ReserveIDs(ctx, g.size)
+		if err != nil {
+			return err
+		}
+		g.next, g.end = first, first+g.size
+	}
+	link.ID = g.next
+	g.next++
+	return nil
+}
@@ -0,0 +1,275 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestRandomCodes_Assign(t *testing.T) {
+	for _, length := range []int{MinRandomCodeLength, DefaultRandomCodeLength, MaxRandomCodeLength} {
+		codes, err := NewRandomCodes(length)
+		if err != nil {
+			t.Fatalf("NewRandomCodes(%d) unexpected error = %v", length, err)
+		}
+
+		seen := make(map[uint64]bool)
+		for i := 0; i < 100; i++ {
+			var link Link
+			if err := codes.Assign(context.Background(), nil, &link); err != nil {
+				t.Fatalf("Assign() unexpected error = %v", err)
+			}
+			code := Encode(link.ID)
+			if len(code) != length {
+				t.Errorf("code %q has length %d, want %d", code, len(code), length)
+			}
+			// Random codes must resolve through the ID namespace
+			if id, ok := idFromCode(code); !ok || id != link.ID {
+				t.Errorf("idFromCode(%q) = %d, %v, want %d, true", code, id, ok, link.ID)
+			}
+			seen[link.ID] = true
+		}
+		if len(seen) < 99 {
+			t.Errorf("length %d: only %d distinct codes out of 100", length, len(seen))
+		}
+	}
+}
+
+func TestNewRandomCodes_InvalidLength(t *testing.T) {
+	for _, length := range []int{0, MinRandomCodeLength - 1, MaxRandomCodeLength + 1} {
+		if _, err := NewRandomCodes(length); err == nil {
+			t.Errorf("NewRandomCodes(%d) expected error", length)
+		}
+	}
+}
+
+func TestService_ShortenWithRandomCodes(t *testing.T) {
+	var attempts int
+	mockRepo := &MockRepository{
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+			attempts++
+			if link.ID == 0 {
+				t.Error("Save() called without a pinned ID")
+			}
+			// The first random code is already taken
+			if attempts == 1 {
+				return 0, ErrConflict
+			}
+			return link.ID, nil
+		},
+	}
+	service := NewService(mockRepo, WithCodeStrategy(RandomCodes{Length: 7}))
+
+	code, err := service.Shorten(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	if len(code) != 7 || attempts != 2 {
+		t.Errorf("Shorten() = %q after %d attempts, want a 7-char code after 2", code, attempts)
+	}
+
+	// Give up eventually
+	mockRepo.SaveFunc = func(ctx context.Context, link *Link) (uint64, error) {
+		return 0, ErrConflict
+	}
+	if _, err := service.Shorten(context.Background(), "https://example.com"); err == nil || errors.Is(err, ErrAliasTaken) {
+		t.Errorf("Shorten() error = %v, want exhausted attempts error", err)
+	}
+}
+
+func TestObfuscatedCodes_Permute(t *testing.T) {
+	codes, err := NewObfuscatedCodes([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewObfuscatedCodes() unexpected error = %v", err)
+	}
+	other, _ := NewObfuscatedCodes([]byte("fedcba9876543210"))
+
+	seen := make(map[uint64]bool)
+	sameAsOther := 0
+	for seq := uint64(1); seq <= 100000; seq++ {
+		id := codes.permute(seq)
+		if id >= 1<<obfuscatedBits {
+			t.Fatalf("permute(%d) = %d, outside the domain", seq, id)
+		}
+		if seen[id] {
+			t.Fatalf("permute(%d) = %d collides with an earlier value", seq, id)
+		}
+		seen[id] = true
+		if len(Encode(id)) > 7 {
+			t.Errorf("code for %d is longer than 7 characters: %q", seq, Encode(id))
+		}
+		if other.permute(seq) == id {
+			sameAsOther++
+		}
+	}
+
+	// Consecutive IDs must not produce consecutive codes
+	if a, b := codes.permute(1), codes.permute(2); b == a+1 || a == b+1 {
+		t.Errorf("permute(1) = %d and permute(2) = %d are adjacent", a, b)
+	}
+	if sameAsOther > 1 {
+		t.Errorf("%d values agree between two keys, want the key to matter", sameAsOther)
+	}
+	if codes.permute(42) != codes.permute(42) {
+		t.Error("permute is not deterministic")
+	}
+}
+
+func TestObfuscatedCodes_Assign(t *testing.T) {
+	codes, err := NewObfuscatedCodes([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewObfuscatedCodes() unexpected error = %v", err)
+	}
+	var seq uint64
+	mockRepo := &MockRepository{
+		NextIDFunc: func(ctx context.Context) (uint64, error) {
+			seq++
+			return seq, nil
+		},
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+			return link.ID, nil
+		},
+	}
+	service := NewService(mockRepo, WithCodeStrategy(codes))
+
+	code, err := service.Shorten(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	if want := Encode(codes.permute(1)); code != want {
+		t.Errorf("Shorten() = %q, want %q", code, want)
+	}
+
+	seq = 1 << obfuscatedBits
+	if _, err := service.Shorten(context.Background(), "https://example.com"); !errors.Is(err, ErrSequenceExhausted) {
+		t.Errorf("Shorten() error = %v, want ErrSequenceExhausted", err)
+	}
+
+	if _, err := NewObfuscatedCodes([]byte("short")); err == nil {
+		t.Error("NewObfuscatedCodes() expected error for short key")
+	}
+}
+
+// sequenceIDs is an IDGenerator returning consecutive IDs from next.
+type sequenceIDs struct {
+	next uint64
+	err  error
+}
+
+func (s *sequenceIDs) Next() (uint64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.next++
+	return s.next - 1, nil
+}
+
+func TestService_ShortenWithSnowflakeCodes(t *testing.T) {
+	ids := &sequenceIDs{next: 400_000_000_000_000_000}
+	var saved []uint64
+	mockRepo := &MockRepository{
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+			saved = append(saved, link.ID)
+			// The first generated ID is already taken, e.g. by a pinned alias
+			if len(saved) == 1 {
+				return 0, ErrConflict
+			}
+			return link.ID, nil
+		},
+	}
+	service := NewService(mockRepo, WithCodeStrategy(SnowflakeCodes{IDs: ids}))
+
+	code, err := service.Shorten(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	if len(saved) != 2 || code != Encode(saved[1]) || saved[1] != saved[0]+1 {
+		t.Errorf("Shorten() = %q after saving IDs %v, want the code of the second", code, saved)
+	}
+	if id, ok := idFromCode(code); !ok || id != saved[1] {
+		t.Errorf("idFromCode(%q) = %d, %v, want %d, true", code, id, ok, saved[1])
+	}
+
+	ids.err = errors.New("clock moved backwards")
+	if _, err := service.Shorten(context.Background(), "https://example.com"); !errors.Is(err, ids.err) {
+		t.Errorf("Shorten() error = %v, want generator error", err)
+	}
+}
+
+func TestBlockCodes_Assign(t *testing.T) {
+	codes, err := NewBlockCodes(3)
+	if err != nil {
+		t.Fatalf("NewBlockCodes() unexpected error = %v", err)
+	}
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	// Another instance reserving in between gets a disjoint block.
+	var got []uint64
+	for i := 0; i < 5; i++ {
+		var link Link
+		if err := codes.Assign(ctx, repo, &link); err != nil {
+			t.Fatalf("Assign() unexpected error = %v", err)
+		}
+		got = append(got, link.ID)
+		if i == 0 {
+			if first, err := repo.ReserveIDs(ctx, 3); err != nil || first != 4 {
+				t.Fatalf("ReserveIDs() = %d, %v, want 4, nil", first, err)
+			}
+		}
+	}
+	if want := []uint64{1, 2, 3, 7, 8}; !slices.Equal(got, want) {
+		t.Errorf("assigned IDs = %v, want %v", got, want)
+	}
+
+	for _, size := range []int{0, MaxIDBlockSize + 1} {
+		if _, err := NewBlockCodes(size); err == nil {
+			t.Errorf("NewBlockCodes(%d) expected error", size)
+		}
+	}
+}
+
+func TestService_ShortenWithBlockCodes(t *testing.T) {
+	codes, err := NewBlockCodes(DefaultIDBlockSize)
+	if err != nil {
+		t.Fatalf("NewBlockCodes() unexpected error = %v", err)
+	}
+	reservations := 0
+	mockRepo := &MockRepository{
+		ReserveIDsFunc: func(ctx context.Context, n uint64) (uint64, error) {
+			reservations++
+			return 1000, nil
+		},
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+			// A plain sequential save already took the first ID of the block
+			if link.ID == 1000 {
+				return 0, ErrConflict
+			}
+			return link.ID, nil
+		},
+	}
+	service := NewService(mockRepo, WithCodeStrategy(codes))
+
+	for _, want := range []uint64{1001, 1002} {
+		code, err := service.Shorten(context.Background(), "https://example.com")
+		if err != nil {
+			t.Fatalf("Shorten() unexpected error = %v", err)
+		}
+		if code != Encode(want) {
+			t.Errorf("Shorten() = %q, want %q", code, Encode(want))
+		}
+	}
+	if reservations != 1 {
+		t.Errorf("reserved %d blocks, want 1", reservations)
+	}
+
+	mockRepo.ReserveIDsFunc = func(ctx context.Context, n uint64) (uint64, error) {
+		return 0, errors.New("database down")
+	}
+	codes, _ = NewBlockCodes(1)
+	service = NewService(mockRepo, WithCodeStrategy(codes))
+	if _, err := service.Shorten(context.Background(), "https://example.com"); err == nil {
+		t.Error("Shorten() expected error when no block can be reserved")
+	}
+}
@@ -0,0 +1,194 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxOrgPrefixLength leaves room in an alias for the longest generated code.
+const maxOrgPrefixLength = maxAliasLength - MaxRandomCodeLength
+
+// CodePolicy constrains the short codes of new links, generated or chosen
+// as custom aliases. Existing links keep their codes. The zero value
+// allows any code; use NewCodePolicy to build one.
+type CodePolicy struct {
+	// MinLength is the shortest code, not counting the prefix of an
+	// organization. Generated codes are padded to it: the IDs they are
+	// written from are shifted past those of shorter codes. Zero means no
+	// minimum.
+	MinLength int
+	// OrgPrefixes are the namespace prefixes of organizations by ID,
+	// lowercase. The generated codes of the links of an organization start
+	// with its prefix, as its custom aliases must, and the custom aliases
+	// of other links may not.
+	OrgPrefixes map[uint64]string
+	// ReservedPrefixes are the lowercase prefixes no new code may start
+	// with, whatever its case. Custom aliases starting with one are
+	// rejected and generated codes starting with one are drawn again.
+	ReservedPrefixes []string
+}
+
+// NewCodePolicy returns a CodePolicy after checking minLength, up to
+// MaxRandomCodeLength, and the prefixes: reserved prefixes are made of
+// alias characters and organization prefixes too, ending with '-' or '_' so
+// that prefixed codes are never read as Base62 ones. No organization
+// prefix may start with another.
+func NewCodePolicy(minLength int, orgPrefixes map[uint64]string, reserved []string) (CodePolicy, error) {
+	if minLength < 0 || minLength > MaxRandomCodeLength {
+		return CodePolicy{}, fmt.Errorf("minimum code length must be between 0 and %d", MaxRandomCodeLength)
+	}
+	p := CodePolicy{MinLength: minLength}
+	for _, prefix := range reserved {
+		prefix = strings.ToLower(prefix)
+		if prefix == "" || len(prefix) > maxReservationPrefixLength || !aliasChars(prefix) {
+			return CodePolicy{}, fmt.Errorf("reserved code prefix %q: must be 1 to %d alias characters", prefix, maxReservationPrefixLength)
+		}
+		p.ReservedPrefixes = append(p.ReservedPrefixes, prefix)
+	}
+	if len(orgPrefixes) > 0 {
+		p.OrgPrefixes = make(map[uint64]string, len(orgPrefixes))
+	}
+	for orgID, prefix := range orgPrefixes {
+		prefix = strings.ToLower(prefix)
+		if len(prefix) < 2 || len(prefix) > maxOrgPrefixLength || !aliasChars(prefix) || !strings.ContainsAny(prefix[len(prefix)-1:], "-_") {
+			return CodePolicy{}, fmt.Errorf("code prefix %q of organization %d: must be 2 to %d alias characters ending with '-' or '_'", prefix, orgID, maxOrgPrefixLength)
+		}
+		for otherID, other := range p.OrgPrefixes {
+			if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				return CodePolicy{}, fmt.Errorf("code prefixes %q of organization %d and %q of organization %d overlap", prefix, orgID, other, otherID)
+			}
+		}
+		p.OrgPrefixes[orgID] = prefix
+	}
+	return p, nil
+}
+
+// WithCodePolicy constrains the codes of new links with p.
+func WithCodePolicy(p CodePolicy) Option {
+	return func(s *Service) {
+		s.codePolicy = p
+	}
+}
+
+// pins reports whether generated codes must be known before links are
+// saved for p to apply to them.
+func (p *CodePolicy) pins() bool {
+	return p.MinLength > 0 || len(p.OrgPrefixes) > 0 || len(p.ReservedPrefixes) > 0
+}
+
+// reserved reports whether code starts with a reserved prefix.
+func (p *CodePolicy) reserved(code string) bool {
+	code = strings.ToLower(code)
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(code, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAlias returns ErrAliasReserved for a custom alias starting with a
+// reserved prefix or with the prefix of another organization than orgID,
+// and ErrInvalidAlias for one that is too short or lacks the prefix of
+// orgID.
+func (p *CodePolicy) checkAlias(alias string, orgID uint64) error {
+	if p.reserved(alias) {
+		return fmt.Errorf("%w: %q starts with a reserved prefix", ErrAliasReserved, alias)
+	}
+	lower := strings.ToLower(alias)
+	for id, prefix := range p.OrgPrefixes {
+		if id != orgID && strings.HasPrefix(lower, prefix) {
+			return fmt.Errorf("%w: %q starts with the prefix of another organization", ErrAliasReserved, alias)
+		}
+	}
+	prefix := p.OrgPrefixes[orgID]
+	if orgID != 0 && prefix != "" && !strings.HasPrefix(lower, prefix) {
+		return fmt.Errorf("%w: aliases of the organization must start with %q", ErrInvalidAlias, prefix)
+	}
+	if len(alias)-len(prefix) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters after the prefix", ErrInvalidAlias, p.MinLength)
+	}
+	return nil
+}
+
+// paddedCodes pins the links of another strategy to an ID, from
+// Repository.NextID if the strategy leaves it to the repository, shifted
+// by offset. With offset the first ID whose code has the minimum length,
+// every code is at least that long; the shift applies to all IDs so that
+// no two of them are shifted onto the same one.
+type paddedCodes struct {
+	codes  CodeStrategy
+	offset uint64
+}
+
+func (g paddedCodes) Assign(ctx context.Context, repo Repository, link *Link) error {
+	if err := g.codes.Assign(ctx, repo, link); err != nil {
+		return err
+	}
+	n := link.ID
+	if n == 0 {
+		var err error
+		if n, err = repo.NextID(ctx); err != nil {
+			return err
+		}
+	}
+	if n > math.MaxInt64-g.offset {
+		return fmt.Errorf("id %d cannot be padded to the minimum code length", n)
+	}
+	link.ID = n + g.offset
+	return nil
+}
+
+// codesFor returns the code strategy of the service writing codes with
+// alpha, nil meaning the default alphabet, under the code policy.
+func (s *Service) codesFor(alpha *Alphabet) CodeStrategy {
+	if alpha == nil {
+		alpha = s.alphabet
+	}
+	if alpha == nil {
+		alpha = Base62
+	}
+	codes := s.codes
+	if s.codePolicy.pins() {
+		// The shift is counted in digits of alpha, which writes the code
+		var offset uint64
+		if s.codePolicy.MinLength > 1 {
+			offset = 1
+			for range s.codePolicy.MinLength - 1 {
+				offset *= uint64(len(alpha.chars))
+			}
+		}
+		codes = paddedCodes{codes: codes, offset: offset}
+	}
+	if alpha != Base62 {
+		codes = alphabetCodes{codes: codes, alphabet: alpha}
+	}
+	return codes
+}
+
+// assignCode assigns link a generated code written with alpha, nil meaning
+// the default alphabet, under the code policy: codes starting with a
+// reserved prefix are drawn again, and those of organizations with a
+// namespace prefix are stored with it, as a free-form code.
+func (s *Service) assignCode(ctx context.Context, link *Link, alpha *Alphabet) error {
+	codes := s.codesFor(alpha)
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		link.ID, link.Code = 0, ""
+		if err := codes.Assign(ctx, s.repo, link); err != nil {
+			return fmt.Errorf("failed to assign code: %w", err)
+		}
+		// Without a policy the repository allocates the ID
+		if link.ID == 0 {
+			return nil
+		}
+		if prefix := s.codePolicy.OrgPrefixes[link.OrgID]; link.OrgID != 0 && prefix != "" {
+			link.Code = prefix + Encode(link.ID)
+		}
+		if !s.codePolicy.reserved(link.ShortCode()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to assign code: %d codes in a row started with a reserved prefix", maxGenerateAttempts)
+}
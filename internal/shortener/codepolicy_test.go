@@ -0,0 +1,129 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewCodePolicy(t *testing.T) {
+	p, err := NewCodePolicy(0, map[uint64]string{1: "Acme-"}, []string{"Admin"})
+	if err != nil {
+		t.Fatalf("NewCodePolicy() error = %v", err)
+	}
+	if p.OrgPrefixes[1] != "acme-" || p.ReservedPrefixes[0] != "admin" {
+		t.Errorf("NewCodePolicy() = %+v, want lowercase prefixes", p)
+	}
+
+	tests := []struct {
+		name      string
+		minLength int
+		orgs      map[uint64]string
+		reserved  []string
+		wantErr   bool
+	}{
+		{name: "empty"},
+		{name: "valid", minLength: 5, orgs: map[uint64]string{1: "Acme-", 2: "globex_"}, reserved: []string{"Admin"}},
+		{name: "negative length", minLength: -1, wantErr: true},
+		{name: "length beyond generated codes", minLength: MaxRandomCodeLength + 1, wantErr: true},
+		{name: "empty reserved prefix", reserved: []string{""}, wantErr: true},
+		{name: "reserved prefix with a slash", reserved: []string{"a/"}, wantErr: true},
+		{name: "organization prefix without separator", orgs: map[uint64]string{1: "acme"}, wantErr: true},
+		{name: "separator alone", orgs: map[uint64]string{1: "-"}, wantErr: true},
+		{name: "organization prefix too long", orgs: map[uint64]string{1: strings.Repeat("a", maxOrgPrefixLength) + "-"}, wantErr: true},
+		{name: "overlapping organization prefixes", orgs: map[uint64]string{1: "ac-", 2: "ac-me-"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCodePolicy(tt.minLength, tt.orgs, tt.reserved); (err != nil) != tt.wantErr {
+				t.Errorf("NewCodePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_CodePolicy(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	acme, err := svc.CreateOrg(ctx, alice, "Acme")
+	if err != nil {
+		t.Fatalf("CreateOrg() error = %v", err)
+	}
+	policy, err := NewCodePolicy(4, map[uint64]string{acme.ID: "acme-", acme.ID + 1: "globex-"}, []string{"1000", "zz"})
+	if err != nil {
+		t.Fatalf("NewCodePolicy() error = %v", err)
+	}
+	WithCodePolicy(policy)(svc)
+
+	// The first padded code, 1000, is reserved and drawn again
+	code, err := svc.Shorten(ctx, "https://example.com/")
+	if err != nil {
+		t.Fatalf("Shorten() error = %v", err)
+	}
+	if code != "1001" {
+		t.Errorf("Shorten() = %q, want 1001", code)
+	}
+	orgCode, err := svc.ShortenWithOptions(ctx, "https://example.com/acme", ShortenOptions{Owner: alice, OrgID: acme.ID})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if !strings.HasPrefix(orgCode, "acme-") || len(orgCode) != len("acme-")+4 {
+		t.Errorf("ShortenWithOptions() = %q, want acme- and a 4 character code", orgCode)
+	}
+	for code, want := range map[string]string{code: "https://example.com/", orgCode: "https://example.com/acme"} {
+		link, err := svc.Resolve(ctx, code)
+		if err != nil || link.OriginalURL != want {
+			t.Errorf("Resolve(%q) = %+v, %v, want %s", code, link, err, want)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		alias   string
+		orgID   uint64
+		wantErr error
+	}{
+		{name: "long enough", alias: "abcd"},
+		{name: "too short", alias: "abc", wantErr: ErrInvalidAlias},
+		{name: "reserved prefix", alias: "ZZ-top", wantErr: ErrAliasReserved},
+		{name: "prefix of an organization", alias: "acme-sale", wantErr: ErrAliasReserved},
+		{name: "prefix of another organization", alias: "globex-sale", orgID: acme.ID, wantErr: ErrAliasReserved},
+		{name: "with the organization prefix", alias: "acme-sale", orgID: acme.ID},
+		{name: "without the organization prefix", alias: "launch", orgID: acme.ID, wantErr: ErrInvalidAlias},
+		{name: "too short after the organization prefix", alias: "acme-abc", orgID: acme.ID, wantErr: ErrInvalidAlias},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{CustomAlias: tt.alias, Owner: alice, OrgID: tt.orgID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ShortenWithOptions(%q) error = %v, want %v", tt.alias, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_CodePolicyAlphabet(t *testing.T) {
+	ctx := context.Background()
+	policy, err := NewCodePolicy(6, nil, nil)
+	if err != nil {
+		t.Fatalf("NewCodePolicy() error = %v", err)
+	}
+	svc := NewService(NewInMemoryRepository(), WithCodePolicy(policy))
+	for _, alpha := range []*Alphabet{Base62, Base58, Base32} {
+		code, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Alphabet: alpha})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions(%s) error = %v", alpha.name, err)
+		}
+		if len(code) != 6 {
+			t.Errorf("ShortenWithOptions(%s) = %q, want 6 characters", alpha.name, code)
+		}
+		if _, err := svc.Resolve(ctx, code); err != nil {
+			t.Errorf("Resolve(%q) error = %v", code, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DomainVerificationPrefix is prepended to a custom domain to name the TXT
+// record that proves its ownership.
+const DomainVerificationPrefix = "_url-shortener."
+
+var (
+	// ErrInvalidDomain is returned for hostnames that cannot be custom
+	// domains, and for links bound to a domain their owner has not verified.
+	ErrInvalidDomain = errors.New("invalid domain")
+	// ErrDomainExists is returned when the user already added the domain.
+	ErrDomainExists = errors.New("domain already added")
+	// ErrDomainTaken is returned when verifying a domain another user has
+	// already verified.
+	ErrDomainTaken = errors.New("domain verified by another user")
+	// ErrDomainUnverified is returned when the verification record of a
+	// domain cannot be found.
+	ErrDomainUnverified = errors.New("domain verification record not found")
+)
+
+// Domain is a hostname a user serves their links on instead of the default
+// base URL. It must be verified before links can be bound to it.
+type Domain struct {
+	ID      uint64 `json:"id"`
+	OwnerID uint64 `json:"-"`
+	// Host is the lowercase hostname, without port.
+	Host string `json:"host"`
+	// Token is the value the verification record must hold.
+	Token      string     `json:"-"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Verified reports whether ownership of the domain has been proven.
+func (d *Domain) Verified() bool {
+	return d.VerifiedAt != nil
+}
+
+// VerificationRecord returns the name and value of the DNS TXT record that
+// proves ownership of the domain.
+func (d *Domain) VerificationRecord() (name, value string) {
+	return DomainVerificationPrefix + d.Host, d.Token
+}
+
+// TXTResolver looks up DNS TXT records. *net.Resolver implements it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// WithTXTResolver verifies custom domains with r instead of the system
+// resolver.
+func WithTXTResolver(r TXTResolver) Option {
+	return func(s *Service) {
+		s.resolver = r
+	}
+}
+
+// NormalizeDomain returns host lowercased and without a trailing dot, or
+// ErrInvalidDomain unless it is a fully qualified hostname without port.
+func NormalizeDomain(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	labels := strings.Split(host, ".")
+	if len(host) > 253 || len(labels) < 2 || net.ParseIP(host) != nil {
+		return "", fmt.Errorf("%w: %q is not a fully qualified hostname", ErrInvalidDomain, host)
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("%w: %q is not a fully qualified hostname", ErrInvalidDomain, host)
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return "", fmt.Errorf("%w: %q is not a fully qualified hostname", ErrInvalidDomain, host)
+			}
+		}
+	}
+	return host, nil
+}
+
+// AddDomain registers host as a custom domain of user, pending
+// verification. Returns ErrInvalidDomain for an invalid hostname and
+// ErrDomainExists if user already added it.
+func (s *Service) AddDomain(ctx context.Context, user *User, host string) (*Domain, error) {
+	host, err := NormalizeDomain(host)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate domain token: %w", err)
+	}
+	d := &Domain{OwnerID: user.ID, Host: host, Token: "url-shortener-verification=" + hex.EncodeToString(token)}
+	if err := s.repo.CreateDomain(ctx, d); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, ErrDomainExists
+		}
+		return nil, fmt.Errorf("failed to add domain: %w", err)
+	}
+	return d, nil
+}
+
+// Domains returns the custom domains of user, oldest first.
+func (s *Service) Domains(ctx context.Context, user *User) ([]*Domain, error) {
+	domains, err := s.repo.ListDomains(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	return domains, nil
+}
+
+// Domain returns the custom domain with ID id, which user must own. Domains
+// of other users are reported as ErrNotFound.
+func (s *Service) Domain(ctx context.Context, user *User, id uint64) (*Domain, error) {
+	d, err := s.repo.GetDomain(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if d.OwnerID != user.ID {
+		return nil, ErrNotFound
+	}
+	return d, nil
+}
+
+// VerifyDomain looks up the verification record of the domain with ID id,
+// which user must own, and marks the domain verified if the record holds
+// its token. Returns ErrDomainUnverified if it does not and ErrDomainTaken
+// if another user verified the domain first. Verified domains are returned
+// as they are.
+func (s *Service) VerifyDomain(ctx context.Context, user *User, id uint64) (*Domain, error) {
+	d, err := s.Domain(ctx, user, id)
+	if err != nil || d.Verified() {
+		return d, err
+	}
+
+	resolver := s.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	name, value := d.VerificationRecord()
+	records, err := resolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, fmt.Errorf("%w: no TXT record at %s", ErrDomainUnverified, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", name, err)
+	}
+	if !slices.Contains(records, value) {
+		return nil, fmt.Errorf("%w: the TXT record at %s does not hold the token", ErrDomainUnverified, name)
+	}
+
+	now := s.now()
+	d.VerifiedAt = &now
+	if err := s.repo.UpdateDomain(ctx, d); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, ErrDomainTaken
+		}
+		return nil, fmt.Errorf("failed to verify domain: %w", err)
+	}
+	return d, nil
+}
+
+// DeleteDomain removes the custom domain with ID id, which user must own.
+// Links bound to it are kept and served on any host again.
+func (s *Service) DeleteDomain(ctx context.Context, user *User, id uint64) error {
+	d, err := s.Domain(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteDomain(ctx, d); err != nil {
+		return fmt.Errorf("failed to delete domain: %w", err)
+	}
+	return nil
+}
+
+// checkDomain returns host normalized, or ErrInvalidDomain unless it is a
+// verified domain of owner.
+func (s *Service) checkDomain(ctx context.Context, owner *User, host string) (string, error) {
+	if owner == nil {
+		return "", fmt.Errorf("%w: anonymous links cannot use a custom domain", ErrInvalidDomain)
+	}
+	host, err := NormalizeDomain(host)
+	if err != nil {
+		return "", err
+	}
+	domains, err := s.repo.ListDomains(ctx, owner.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list domains: %w", err)
+	}
+	for _, d := range domains {
+		if d.Host == host && d.Verified() {
+			return host, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is not a verified domain of the user", ErrInvalidDomain, host)
+}
+
+// scanDomains scans and closes rows selected with domainColumns.
+func scanDomains(rows *sql.Rows) ([]*Domain, error) {
+	defer rows.Close()
+	domains := []*Domain{}
+	for rows.Next() {
+		d, err := scanDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan domain: %w", err)
+		}
+		domains = append(domains, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domains: %w", err)
+	}
+	return domains, nil
+}
+
+// scanDomain scans a row selected with domainColumns.
+func scanDomain(row rowScanner) (*Domain, error) {
+	var d Domain
+	var verifiedAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.OwnerID, &d.Host, &d.Token, &verifiedAt, &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	if verifiedAt.Valid {
+		d.VerifiedAt = &verifiedAt.Time
+	}
+	return &d, nil
+}
+
+// domainColumns are the columns scanned by scanDomain.
+const domainColumns = `id, owner_id, host, token, verified_at, created_at`
@@ -0,0 +1,158 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// stubResolver answers TXT lookups from a map, reporting other names as not
+// found.
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "Go.Example.COM", want: "go.example.com"},
+		{host: " links.example.com. ", want: "links.example.com"},
+		{host: "a-b.example.co.uk", want: "a-b.example.co.uk"},
+		{host: "localhost", wantErr: true},
+		{host: "example.com:8080", wantErr: true},
+		{host: "192.168.0.1", wantErr: true},
+		{host: "-bad.example.com", wantErr: true},
+		{host: "bad..example.com", wantErr: true},
+		{host: "ex_ample.com", wantErr: true},
+		{host: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := NormalizeDomain(tt.host)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidDomain) {
+					t.Errorf("NormalizeDomain(%q) error = %v, want ErrInvalidDomain", tt.host, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeDomain(%q) = %q, %v, want %q", tt.host, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Domains(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			resolver := stubResolver{}
+			service := NewService(newRepo(t), WithTXTResolver(resolver))
+			ctx := context.Background()
+
+			alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			bob, _, err := service.Signup(ctx, "bob@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+
+			d, err := service.AddDomain(ctx, alice, "Go.Example.com")
+			if err != nil {
+				t.Fatalf("AddDomain() unexpected error = %v", err)
+			}
+			if d.ID == 0 || d.Host != "go.example.com" || d.Token == "" || d.Verified() {
+				t.Errorf("AddDomain() = %+v, want an unverified go.example.com", d)
+			}
+			if _, err := service.AddDomain(ctx, alice, "go.example.com"); !errors.Is(err, ErrDomainExists) {
+				t.Errorf("AddDomain() duplicate error = %v, want ErrDomainExists", err)
+			}
+			// Any user may claim a host until one of them verifies it
+			bobs, err := service.AddDomain(ctx, bob, "go.example.com")
+			if err != nil {
+				t.Fatalf("AddDomain() for another owner unexpected error = %v", err)
+			}
+			if _, err := service.Domain(ctx, bob, d.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Domain() of another owner error = %v, want ErrNotFound", err)
+			}
+
+			// Unverified domains cannot serve links
+			if _, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: alice, Domain: "go.example.com"}); !errors.Is(err, ErrInvalidDomain) {
+				t.Errorf("ShortenWithOptions() on an unverified domain error = %v, want ErrInvalidDomain", err)
+			}
+
+			name, value := d.VerificationRecord()
+			if _, err := service.VerifyDomain(ctx, alice, d.ID); !errors.Is(err, ErrDomainUnverified) {
+				t.Errorf("VerifyDomain() without a record error = %v, want ErrDomainUnverified", err)
+			}
+			resolver[name] = []string{"something else"}
+			if _, err := service.VerifyDomain(ctx, alice, d.ID); !errors.Is(err, ErrDomainUnverified) {
+				t.Errorf("VerifyDomain() with a wrong record error = %v, want ErrDomainUnverified", err)
+			}
+			resolver[name] = []string{"something else", value}
+			verified, err := service.VerifyDomain(ctx, alice, d.ID)
+			if err != nil || !verified.Verified() {
+				t.Fatalf("VerifyDomain() = %+v, %v, want a verified domain", verified, err)
+			}
+			// Bob cannot verify the host too, even with his own token
+			_, bobValue := bobs.VerificationRecord()
+			resolver[name] = []string{value, bobValue}
+			if _, err := service.VerifyDomain(ctx, bob, bobs.ID); !errors.Is(err, ErrDomainTaken) {
+				t.Errorf("VerifyDomain() of a taken host error = %v, want ErrDomainTaken", err)
+			}
+
+			code, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: alice, Domain: "GO.example.com"})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			for _, opts := range []ShortenOptions{
+				{Owner: bob, Domain: "go.example.com"},
+				{Domain: "go.example.com"},
+			} {
+				if _, err := service.ShortenWithOptions(ctx, "https://example.com/b", opts); !errors.Is(err, ErrInvalidDomain) {
+					t.Errorf("ShortenWithOptions(%+v) error = %v, want ErrInvalidDomain", opts, err)
+				}
+			}
+
+			// Bound links answer on their domain only
+			if _, err := service.RedirectVisitor(ctx, code, Visitor{Host: "go.example.com"}); err != nil {
+				t.Errorf("RedirectVisitor() on the domain unexpected error = %v", err)
+			}
+			if _, err := service.RedirectVisitor(ctx, code, Visitor{Host: "sho.rt"}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("RedirectVisitor() on another host error = %v, want ErrNotFound", err)
+			}
+
+			// Deleting the domain unbinds its links
+			if err := service.DeleteDomain(ctx, bob, d.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("DeleteDomain() by another owner error = %v, want ErrNotFound", err)
+			}
+			if err := service.DeleteDomain(ctx, alice, d.ID); err != nil {
+				t.Fatalf("DeleteDomain() unexpected error = %v", err)
+			}
+			link, err := service.Preview(ctx, code)
+			if err != nil || link.Domain != "" {
+				t.Errorf("Preview() after DeleteDomain() = %+v, %v, want an unbound link", link, err)
+			}
+			domains, err := service.Domains(ctx, alice)
+			if err != nil || len(domains) != 0 {
+				t.Errorf("Domains() after DeleteDomain() = %+v, %v, want none", domains, err)
+			}
+			// The host is free for Bob now
+			if _, err := service.VerifyDomain(ctx, bob, bobs.ID); err != nil {
+				t.Errorf("VerifyDomain() of a released host unexpected error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	// ErrDomainBlocked is returned for destinations on a blocked domain,
+	// or outside the allowed domains when there are any.
+	ErrDomainBlocked = errors.New("destination domain is not allowed")
+	// ErrInvalidDomainRule is returned for domain rules with an invalid
+	// pattern or action.
+	ErrInvalidDomainRule = errors.New("invalid domain rule")
+	// ErrDomainRuleExists is returned when a rule already has the pattern.
+	ErrDomainRuleExists = errors.New("domain rule already exists")
+)
+
+// DomainAction is what a DomainRule does to the destinations it matches.
+type DomainAction string
+
+const (
+	// DomainBlock rejects destinations on the domain.
+	DomainBlock DomainAction = "block"
+	// DomainAllow admits destinations on the domain. Once there is any
+	// allow rule, destinations matching none are rejected.
+	DomainAllow DomainAction = "allow"
+)
+
+// DomainRule blocks or allows destinations on the domains matching Pattern:
+// a hostname matches itself and "*.example.com" matches the subdomains of
+// example.com at any depth, but not example.com. Block rules win over
+// allow rules.
+type DomainRule struct {
+	ID      uint64       `json:"id,omitempty"`
+	Pattern string       `json:"pattern"`
+	Action  DomainAction `json:"action"`
+	Reason  string       `json:"reason,omitempty"`
+	// Configured rules come from WithDomainLists rather than AddDomainRule,
+	// and cannot be deleted.
+	Configured bool      `json:"configured,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitzero"`
+}
+
+// Matches reports whether the lowercase hostname host matches the pattern
+// of the rule.
+func (r *DomainRule) Matches(host string) bool {
+	if parent, ok := strings.CutPrefix(r.Pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+parent)
+	}
+	return host == r.Pattern
+}
+
+// MaxDomainRuleReasonLength is the maximum length of the reason of a domain
+// rule, in characters.
+const MaxDomainRuleReasonLength = 500
+
+// NormalizeDomainPattern returns pattern lowercased and in punycode, or
+// ErrInvalidDomainRule unless it is a fully qualified hostname, optionally
+// prefixed with "*." to match its subdomains.
+func NormalizeDomainPattern(pattern string) (string, error) {
+	pattern = strings.TrimSpace(pattern)
+	host, wildcard := strings.CutPrefix(pattern, "*.")
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: %q is not a domain name: %v", ErrInvalidDomainRule, pattern, err)
+	}
+	host, err = NormalizeDomain(ascii)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q is not a fully qualified hostname", ErrInvalidDomainRule, pattern)
+	}
+	if wildcard {
+		return "*." + host, nil
+	}
+	return host, nil
+}
+
+// DomainLists are the domain patterns blocked and allowed by configuration,
+// in addition to the rules added with AddDomainRule.
+type DomainLists struct {
+	Block []string
+	Allow []string
+}
+
+// NewDomainLists normalizes the patterns of block and allow with
+// NormalizeDomainPattern.
+func NewDomainLists(block, allow []string) (DomainLists, error) {
+	var lists DomainLists
+	for _, l := range []struct {
+		patterns []string
+		dst      *[]string
+	}{{block, &lists.Block}, {allow, &lists.Allow}} {
+		for _, p := range l.patterns {
+			pattern, err := NormalizeDomainPattern(p)
+			if err != nil {
+				return DomainLists{}, err
+			}
+			*l.dst = append(*l.dst, pattern)
+		}
+	}
+	return lists, nil
+}
+
+// WithDomainLists blocks and allows destinations on the domains of lists,
+// in addition to the rules added with AddDomainRule.
+func WithDomainLists(lists DomainLists) Option {
+	return func(s *Service) {
+		s.domainLists = lists
+	}
+}
+
+// configuredRules returns the domain lists as rules.
+func (s *Service) configuredRules() []*DomainRule {
+	rules := make([]*DomainRule, 0, len(s.domainLists.Block)+len(s.domainLists.Allow))
+	for _, p := range s.domainLists.Block {
+		rules = append(rules, &DomainRule{Pattern: p, Action: DomainBlock, Configured: true})
+	}
+	for _, p := range s.domainLists.Allow {
+		rules = append(rules, &DomainRule{Pattern: p, Action: DomainAllow, Configured: true})
+	}
+	return rules
+}
+
+// DomainRules returns the configured domain rules followed by those added
+// with AddDomainRule, oldest first.
+func (s *Service) DomainRules(ctx context.Context) ([]*DomainRule, error) {
+	stored, err := s.repo.ListDomainRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain rules: %w", err)
+	}
+	return append(s.configuredRules(), stored...), nil
+}
+
+// AddDomainRule stores rule, which takes effect on every instance at once.
+// Returns ErrInvalidDomainRule for an invalid pattern or action and
+// ErrDomainRuleExists if a rule already has the pattern.
+func (s *Service) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	pattern, err := NormalizeDomainPattern(rule.Pattern)
+	if err != nil {
+		return err
+	}
+	if rule.Action != DomainBlock && rule.Action != DomainAllow {
+		return fmt.Errorf("%w: action must be block or allow", ErrInvalidDomainRule)
+	}
+	if utf8.RuneCountInString(rule.Reason) > MaxDomainRuleReasonLength {
+		return fmt.Errorf("%w: reason must be at most %d characters", ErrInvalidDomainRule, MaxDomainRuleReasonLength)
+	}
+	rule.Pattern, rule.Configured = pattern, false
+	if err := s.repo.AddDomainRule(ctx, rule); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return ErrDomainRuleExists
+		}
+		return fmt.Errorf("failed to add domain rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteDomainRule removes the domain rule with ID id. Returns ErrNotFound
+// if there is none.
+func (s *Service) DeleteDomainRule(ctx context.Context, id uint64) error {
+	if err := s.repo.DeleteDomainRule(ctx, id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to delete domain rule: %w", err)
+	}
+	return nil
+}
+
+// checkDestinationDomain rejects rawURL with ErrDomainBlocked if its host
+// matches a block rule, or no allow rule when there are any. URLs that do
+// not parse are left to the other checks.
+func (s *Service) checkDestinationDomain(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return nil
+	}
+	rules := s.configuredRules()
+	stored, err := s.repo.ListDomainRules(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list domain rules: %w", err)
+	}
+	rules = append(rules, stored...)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	allowed, allowRules := false, false
+	for _, r := range rules {
+		switch r.Action {
+		case DomainBlock:
+			if r.Matches(host) {
+				return fmt.Errorf("%w: %s is blocked", ErrDomainBlocked, host)
+			}
+		case DomainAllow:
+			allowRules = true
+			allowed = allowed || r.Matches(host)
+		}
+	}
+	if allowRules && !allowed {
+		return fmt.Errorf("%w: %s is not on the allowlist", ErrDomainBlocked, host)
+	}
+	return nil
+}
+
+// scanDomainRules scans and closes rows selected with domainRuleColumns.
+func scanDomainRules(rows *sql.Rows) ([]*DomainRule, error) {
+	defer rows.Close()
+	rules := []*DomainRule{}
+	for rows.Next() {
+		var r DomainRule
+		if err := rows.Scan(&r.ID, &r.Pattern, &r.Action, &r.Reason, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan domain rule: %w", err)
+		}
+		rules = append(rules, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read domain rules: %w", err)
+	}
+	return rules, nil
+}
+
+// domainRuleColumns are the columns scanned by scanDomainRules.
+const domainRuleColumns = `id, pattern, action, reason, created_at`
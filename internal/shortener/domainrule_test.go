@@ -0,0 +1,144 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeDomainPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{"spam.example", "spam.example", false},
+		{" Spam.Example. ", "spam.example", false},
+		{"*.spam.example", "*.spam.example", false},
+		{"*.Bücher.example", "*.xn--bcher-kva.example", false},
+		{"", "", true},
+		{"*", "", true},
+		{"localhost", "", true},
+		{"spam.*.example", "", true},
+		{"192.0.2.1", "", true},
+		{"spam.example:8080", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := NormalizeDomainPattern(tt.pattern)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidDomainRule) {
+					t.Errorf("NormalizeDomainPattern(%q) error = %v, want ErrInvalidDomainRule", tt.pattern, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeDomainPattern(%q) = %q, %v, want %q", tt.pattern, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_DomainRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		lists   DomainLists
+		stored  []*DomainRule
+		url     string
+		blocked bool
+	}{
+		{"no rules", DomainLists{}, nil, "https://spam.example/", false},
+		{"configured block", DomainLists{Block: []string{"spam.example"}}, nil, "https://SPAM.example./a", true},
+		{"stored block", DomainLists{}, []*DomainRule{{Pattern: "spam.example", Action: DomainBlock}}, "https://spam.example/", true},
+		{"exact pattern leaves subdomains", DomainLists{Block: []string{"spam.example"}}, nil, "https://www.spam.example/", false},
+		{"wildcard matches subdomains", DomainLists{Block: []string{"*.spam.example"}}, nil, "https://a.b.spam.example/", true},
+		{"wildcard leaves the domain", DomainLists{Block: []string{"*.spam.example"}}, nil, "https://spam.example/", false},
+		{"wildcard leaves lookalikes", DomainLists{Block: []string{"*.spam.example"}}, nil, "https://notspam.example/", false},
+		{"allowed", DomainLists{Allow: []string{"example.com"}}, nil, "https://example.com/", false},
+		{"not allowed", DomainLists{}, []*DomainRule{{Pattern: "example.com", Action: DomainAllow}}, "https://example.org/", true},
+		{"block wins over allow", DomainLists{Allow: []string{"*.example.com"}}, []*DomainRule{{Pattern: "spam.example.com", Action: DomainBlock}}, "https://spam.example.com/", true},
+		{"international domain", DomainLists{Block: []string{"bücher.example"}}, nil, "https://Bücher.example/", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			lists, err := NewDomainLists(tt.lists.Block, tt.lists.Allow)
+			if err != nil {
+				t.Fatalf("NewDomainLists() error = %v", err)
+			}
+			svc := NewService(NewInMemoryRepository(), WithDomainLists(lists))
+			for _, rule := range tt.stored {
+				if err := svc.AddDomainRule(ctx, rule); err != nil {
+					t.Fatalf("AddDomainRule() error = %v", err)
+				}
+			}
+
+			_, err = svc.Shorten(ctx, tt.url)
+			if blocked := errors.Is(err, ErrDomainBlocked); blocked != tt.blocked {
+				t.Errorf("Shorten(%q) error = %v, want blocked %v", tt.url, err, tt.blocked)
+			}
+			if !tt.blocked && err != nil {
+				t.Errorf("Shorten(%q) unexpected error = %v", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestService_AddDomainRule(t *testing.T) {
+	ctx := context.Background()
+	lists, err := NewDomainLists([]string{"spam.example"}, nil)
+	if err != nil {
+		t.Fatalf("NewDomainLists() error = %v", err)
+	}
+	svc := NewService(NewInMemoryRepository(), WithDomainLists(lists))
+
+	rule := &DomainRule{Pattern: "*.Farm.example", Action: DomainBlock, Reason: "link farm"}
+	if err := svc.AddDomainRule(ctx, rule); err != nil {
+		t.Fatalf("AddDomainRule() error = %v", err)
+	}
+	if rule.ID == 0 || rule.Pattern != "*.farm.example" {
+		t.Errorf("AddDomainRule() rule = %+v", rule)
+	}
+	if err := svc.AddDomainRule(ctx, &DomainRule{Pattern: "*.farm.example", Action: DomainAllow}); !errors.Is(err, ErrDomainRuleExists) {
+		t.Errorf("AddDomainRule() duplicate error = %v, want ErrDomainRuleExists", err)
+	}
+	if err := svc.AddDomainRule(ctx, &DomainRule{Pattern: "other.example", Action: "deny"}); !errors.Is(err, ErrInvalidDomainRule) {
+		t.Errorf("AddDomainRule() invalid action error = %v, want ErrInvalidDomainRule", err)
+	}
+
+	// Changed destinations are checked like new ones
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := svc.UpdateLink(ctx, alice, code, LinkUpdate{URL: "https://www.farm.example/"}); !errors.Is(err, ErrDomainBlocked) {
+		t.Errorf("UpdateLink() error = %v, want ErrDomainBlocked", err)
+	}
+	if _, err := svc.SetTargets(ctx, alice, code, []Target{{Country: "JP", URL: "https://www.farm.example/"}}); !errors.Is(err, ErrDomainBlocked) {
+		t.Errorf("SetTargets() error = %v, want ErrDomainBlocked", err)
+	}
+
+	rules, err := svc.DomainRules(ctx)
+	if err != nil {
+		t.Fatalf("DomainRules() error = %v", err)
+	}
+	if len(rules) != 2 || !rules[0].Configured || rules[0].Pattern != "spam.example" || rules[1].ID != rule.ID {
+		t.Errorf("DomainRules() = %+v", rules)
+	}
+
+	if err := svc.DeleteDomainRule(ctx, rule.ID); err != nil {
+		t.Fatalf("DeleteDomainRule() error = %v", err)
+	}
+	if err := svc.DeleteDomainRule(ctx, rule.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDomainRule() twice error = %v, want ErrNotFound", err)
+	}
+	if _, err := svc.Shorten(ctx, "https://www.farm.example/"); err != nil {
+		t.Errorf("Shorten() after delete error = %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package shortener
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DynamoDBConfig locates the DynamoDB table of a DynamoDBRepository and the
+// credentials to sign requests with.
+type DynamoDBConfig struct {
+	Table  string
+	Region string
+	// Endpoint overrides the regional endpoint, e.g. for localstack or
+	// DynamoDB Local; empty uses https://dynamodb.<Region>.amazonaws.com.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken accompanies temporary credentials, such as those AWS
+	// Lambda and ECS tasks get from their role.
+	SessionToken string
+	// Retry repeats requests DynamoDB throttled or failed to process;
+	// the zero value uses DefaultRetryPolicy.
+	Retry RetryPolicy
+}
+
+// dynamoValue is a DynamoDB attribute value in the JSON protocol. Nested
+// structures are stored as JSON strings, so only scalars are needed.
+type dynamoValue struct {
+	S    *string `json:"S,omitempty"`
+	N    *string `json:"N,omitempty"`
+	BOOL *bool   `json:"BOOL,omitempty"`
+}
+
+// dynamoItem is an item or key: attribute values by name.
+type dynamoItem map[string]dynamoValue
+
+func dynamoS(s string) dynamoValue {
+	return dynamoValue{S: &s}
+}
+
+func dynamoN(n int64) dynamoValue {
+	s := strconv.FormatInt(n, 10)
+	return dynamoValue{N: &s}
+}
+
+func dynamoBool(b bool) dynamoValue {
+	return dynamoValue{BOOL: &b}
+}
+
+// str returns the string attribute name, or "" if it is absent.
+func (it dynamoItem) str(name string) string {
+	if v := it[name].S; v != nil {
+		return *v
+	}
+	return ""
+}
+
+// num returns the number attribute name, or 0 if it is absent.
+func (it dynamoItem) num(name string) int64 {
+	if v := it[name].N; v != nil {
+		n, _ := strconv.ParseInt(*v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// bool returns the boolean attribute name, or false if it is absent.
+func (it dynamoItem) bool(name string) bool {
+	v := it[name].BOOL
+	return v != nil && *v
+}
+
+// time returns the time attribute name, stored in Unix microseconds, or nil
+// if it is absent.
+func (it dynamoItem) time(name string) *time.Time {
+	if it[name].N == nil {
+		return nil
+	}
+	t := time.UnixMicro(it.num(name)).UTC()
+	return &t
+}
+
+// dynamoExpr collects the placeholders of the expressions of one request.
+// Every attribute name goes through a placeholder, since DynamoDB reserves
+// hundreds of words such as "url", "status" and "domain".
+type dynamoExpr struct {
+	names  map[string]string
+	values dynamoItem
+}
+
+func newDynamoExpr() *dynamoExpr {
+	return &dynamoExpr{names: make(map[string]string), values: make(dynamoItem)}
+}
+
+// name returns the placeholder of the attribute name.
+func (e *dynamoExpr) name(name string) string {
+	e.names["#"+name] = name
+	return "#" + name
+}
+
+// value returns the placeholder :key bound to v.
+func (e *dynamoExpr) value(key string, v dynamoValue) string {
+	e.values[":"+key] = v
+	return ":" + key
+}
+
+// apply adds the placeholders to the request in.
+func (e *dynamoExpr) apply(in map[string]any) map[string]any {
+	if len(e.names) > 0 {
+		in["ExpressionAttributeNames"] = e.names
+	}
+	if len(e.values) > 0 {
+		in["ExpressionAttributeValues"] = e.values
+	}
+	return in
+}
+
+// dynamoError is an error response of DynamoDB.
+type dynamoError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	// MessageUpper is how some errors spell message.
+	MessageUpper string `json:"Message"`
+	// CancellationReasons explain a TransactionCanceledException, one per
+	// action of the transaction.
+	CancellationReasons []struct {
+		Code string `json:"Code"`
+	} `json:"CancellationReasons"`
+	status int
+}
+
+func (e *dynamoError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.MessageUpper
+	}
+	return fmt.Sprintf("dynamodb: %s (%d): %s", e.Type, e.status, msg)
+}
+
+// dynamoErrorIs reports whether err is a DynamoDB error of type typ, e.g.
+// "ConditionalCheckFailedException".
+func dynamoErrorIs(err error, typ string) bool {
+	var dErr *dynamoError
+	return errors.As(err, &dErr) && dErr.Type == typ
+}
+
+// dynamoCanceled reports whether err is a canceled transaction whose action
+// number i failed its condition.
+func dynamoCanceled(err error, i int) bool {
+	var dErr *dynamoError
+	if !errors.As(err, &dErr) || dErr.Type != "TransactionCanceledException" || i >= len(dErr.CancellationReasons) {
+		return false
+	}
+	return dErr.CancellationReasons[i].Code == "ConditionalCheckFailed"
+}
+
+// transientDynamo reports whether err may go away by itself: throttling,
+// internal errors and conflicting transactions, none of which took effect,
+// and refused connections.
+func transientDynamo(err error) bool {
+	var dErr *dynamoError
+	if errors.As(err, &dErr) {
+		switch dErr.Type {
+		case "ProvisionedThroughputExceededException", "ThrottlingException", "RequestLimitExceeded",
+			"InternalServerError", "TransactionConflictException":
+			return true
+		}
+		return dErr.status == http.StatusServiceUnavailable
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// dynamoClient calls the DynamoDB JSON API, signing requests with AWS
+// Signature Version 4.
+type dynamoClient struct {
+	cfg      DynamoDBConfig
+	endpoint *url.URL
+	http     *http.Client
+	// now is replaced in tests to sign at a fixed time.
+	now func() time.Time
+}
+
+func newDynamoClient(cfg DynamoDBConfig) (*dynamoClient, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + cfg.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid DynamoDB endpoint %q", endpoint)
+	}
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry = DefaultRetryPolicy
+	}
+	return &dynamoClient{cfg: cfg, endpoint: u, http: &http.Client{Timeout: 10 * time.Second}, now: time.Now}, nil
+}
+
+// call runs the DynamoDB operation op with the request in and decodes the
+// response into out, if not nil.
+func (c *dynamoClient) call(ctx context.Context, op string, in map[string]any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", op, err)
+	}
+	return c.cfg.Retry.do(ctx, "dynamodb", transientDynamo, func() error {
+		return c.send(ctx, op, body, out)
+	})
+}
+
+func (c *dynamoClient) send(ctx context.Context, op string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	c.sign(req, body, c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("dynamodb %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("dynamodb %s: failed to read response: %w", op, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		dErr := &dynamoError{status: resp.StatusCode}
+		json.Unmarshal(data, dErr)
+		// The type is qualified, e.g. com.amazonaws.dynamodb.v20120810#ResourceNotFoundException
+		dErr.Type = dErr.Type[strings.LastIndex(dErr.Type, "#")+1:]
+		return dErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("dynamodb %s: failed to decode response: %w", op, err)
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers for the body of req at now.
+func (c *dynamoClient) sign(req *http.Request, body []byte, now time.Time) {
+	const service = "dynamodb"
+	stamp := now.UTC().Format("20060102T150405Z")
+	date := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	if c.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.cfg.SessionToken)
+	}
+
+	// Canonical headers are sorted by lowercase name: content-type, host,
+	// x-amz-date, x-amz-security-token, x-amz-target
+	signed := []string{"content-type", "host", "x-amz-date"}
+	values := []string{req.Header.Get("Content-Type"), req.URL.Host, stamp}
+	if c.cfg.SessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+		values = append(values, c.cfg.SessionToken)
+	}
+	signed = append(signed, "x-amz-target")
+	values = append(values, req.Header.Get("X-Amz-Target"))
+	var headers strings.Builder
+	for i, name := range signed {
+		headers.WriteString(name + ":" + strings.TrimSpace(values[i]) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers.String(), signedHeaders, hex.EncodeToString(payload[:])}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + c.cfg.Region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signature := hex.EncodeToString(hmacSHA256(signingKey(c.cfg.SecretAccessKey, date, c.cfg.Region, service), toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the Signature Version 4 key of a day, region and service.
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,196 @@
+//go:build integration
+
+package shortener_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// setupDynamoDB starts localstack and returns a repository on a fresh table.
+func setupDynamoDB(t *testing.T) *shortener.DynamoDBRepository {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "localstack/localstack:3",
+			ExposedPorts: []string{"4566/tcp"},
+			Env:          map[string]string{"SERVICES": "dynamodb"},
+			WaitingFor:   wait.ForHTTP("/_localstack/health").WithPort("4566/tcp").WithStartupTimeout(90 * time.Second),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to start localstack container: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	endpoint, err := container.PortEndpoint(ctx, "4566/tcp", "http")
+	if err != nil {
+		t.Fatalf("failed to get localstack endpoint: %v", err)
+	}
+	repo, err := shortener.NewDynamoDBRepository(shortener.DynamoDBConfig{
+		Table:           "links",
+		Region:          "us-east-1",
+		Endpoint:        endpoint,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+	})
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository() failed: %v", err)
+	}
+	return repo
+}
+
+// TestIntegration_DynamoDB runs the main flows of the service against a
+// DynamoDB table in localstack.
+func TestIntegration_DynamoDB(t *testing.T) {
+	repo := setupDynamoDB(t)
+	ctx := context.Background()
+	service := shortener.NewService(repo)
+
+	// Generated codes skip IDs pinned ahead of the counter
+	if _, err := repo.Save(ctx, &shortener.Link{ID: 3, OriginalURL: "https://example.com/pinned"}); err != nil {
+		t.Fatalf("Save() of a pinned ID failed: %v", err)
+	}
+	if _, err := repo.Save(ctx, &shortener.Link{ID: 3, OriginalURL: "https://example.com/again"}); !errors.Is(err, shortener.ErrConflict) {
+		t.Errorf("Save() of a taken ID error = %v, want ErrConflict", err)
+	}
+	var codes []string
+	for i := 0; i < 4; i++ {
+		code, err := service.Shorten(ctx, fmt.Sprintf("https://example.com/%d", i))
+		if err != nil {
+			t.Fatalf("Shorten() failed: %v", err)
+		}
+		if code == "3" {
+			t.Errorf("Shorten() reused pinned code %q", code)
+		}
+		codes = append(codes, code)
+	}
+
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/promo", shortener.ShortenOptions{CustomAlias: "promo"})
+	if err != nil || code != "promo" {
+		t.Fatalf("ShortenWithOptions() = %q, %v", code, err)
+	}
+	if _, err := service.ShortenWithOptions(ctx, "https://example.com/other", shortener.ShortenOptions{CustomAlias: "promo"}); !errors.Is(err, shortener.ErrAliasTaken) {
+		t.Errorf("duplicate alias error = %v, want ErrAliasTaken", err)
+	}
+	for _, c := range append(codes, "promo") {
+		if _, err := service.Redirect(ctx, c); err != nil {
+			t.Errorf("Redirect(%q) failed: %v", c, err)
+		}
+	}
+
+	links, err := repo.List(ctx, 0, 10)
+	if err != nil || len(links) != 6 || links[0].Code != "promo" {
+		t.Errorf("List() = %d links, %v, want 6 newest first", len(links), err)
+	}
+	if links, err := repo.Search(ctx, "EXAMPLE.COM/2", 0, 10); err != nil || len(links) != 1 {
+		t.Errorf("Search() = %d links, %v, want 1", len(links), err)
+	}
+
+	// Clicks, one per redirect above, are rolled up as they are recorded
+	link, err := repo.GetByCode(ctx, "promo")
+	if err != nil {
+		t.Fatalf("GetByCode() failed: %v", err)
+	}
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		if err := repo.RecordClick(ctx, link, now, shortener.ClickSource{Referrer: "news.example.org"}); err != nil {
+			t.Fatalf("RecordClick() failed: %v", err)
+		}
+	}
+	series, err := repo.ClickSeries(ctx, link, shortener.GranularityHour, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(series) != 1 || series[0].Clicks != 4 {
+		t.Errorf("ClickSeries() = %+v, %v, want one bucket of 4", series, err)
+	}
+	top, err := repo.TopStats(ctx, shortener.GranularityDay, now.Add(-24*time.Hour), now.Add(24*time.Hour), 5)
+	if err != nil || top.Redirects != 8 || len(top.Top) != 5 || top.Top[0].Code != "promo" || top.LinksCreated != 6 {
+		t.Errorf("TopStats() = %+v, %v", top, err)
+	}
+
+	// Deleted links disappear but keep their code reserved
+	if err := repo.Delete(ctx, link); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := repo.GetByCode(ctx, "promo"); !errors.Is(err, shortener.ErrNotFound) {
+		t.Errorf("GetByCode() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Save(ctx, &shortener.Link{Code: "promo", OriginalURL: "https://example.com"}); !errors.Is(err, shortener.ErrConflict) {
+		t.Errorf("Save() of a deleted link's code error = %v, want ErrConflict", err)
+	}
+	if st, err := repo.Stats(ctx, now); err != nil || st.Total != 5 {
+		t.Errorf("Stats() = %+v, %v, want 5 links", st, err)
+	}
+
+	// Expired links are purged with their items
+	expired := now.Add(-time.Minute)
+	if _, err := repo.Save(ctx, &shortener.Link{OriginalURL: "https://example.com/old", ExpiresAt: &expired}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if n, err := repo.PurgeExpired(ctx, now); err != nil || n != 1 {
+		t.Errorf("PurgeExpired() = %d, %v, want 1", n, err)
+	}
+}
+
+// TestIntegration_DynamoDBAccounts covers users, API keys, quotas and
+// campaigns against localstack.
+func TestIntegration_DynamoDBAccounts(t *testing.T) {
+	repo := setupDynamoDB(t)
+	ctx := context.Background()
+
+	user := &shortener.User{Email: "a@example.com", PasswordHash: "hash", Plan: "free"}
+	if err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	if err := repo.CreateUser(ctx, &shortener.User{Email: "a@example.com"}); !errors.Is(err, shortener.ErrConflict) {
+		t.Errorf("CreateUser() of a taken email error = %v, want ErrConflict", err)
+	}
+	key := &shortener.APIKey{UserID: user.ID, KeyHash: "keyhash", Scopes: []string{shortener.ScopeRead}}
+	if err := repo.CreateAPIKey(ctx, key); err != nil {
+		t.Fatalf("CreateAPIKey() failed: %v", err)
+	}
+	if got, gotKey, err := repo.GetUserByAPIKey(ctx, "keyhash"); err != nil || got.Email != user.Email || gotKey.ID != key.ID || len(gotKey.Scopes) != 1 {
+		t.Errorf("GetUserByAPIKey() = %+v, %+v, %v", got, gotKey, err)
+	}
+	if err := repo.ExpireAPIKey(ctx, "keyhash", time.Now()); err != nil {
+		t.Errorf("ExpireAPIKey() failed: %v", err)
+	}
+	if keys, err := repo.ListAPIKeys(ctx, user.ID); err != nil || len(keys) != 1 || keys[0].ExpiresAt == nil {
+		t.Errorf("ListAPIKeys() = %+v, %v", keys, err)
+	}
+	if err := repo.CreateAPIKey(ctx, &shortener.APIKey{UserID: 999, KeyHash: "other"}); !errors.Is(err, shortener.ErrNotFound) {
+		t.Errorf("CreateAPIKey() of a missing user error = %v, want ErrNotFound", err)
+	}
+
+	campaign := &shortener.Campaign{OwnerID: user.ID, Name: "launch"}
+	if err := repo.CreateCampaign(ctx, campaign); err != nil {
+		t.Fatalf("CreateCampaign() failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		link := &shortener.Link{OriginalURL: "https://example.com", OwnerID: user.ID, CustomAlias: i == 0, CampaignID: campaign.ID}
+		if _, err := repo.Save(ctx, link); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+	}
+	if u, err := repo.UsageByOwner(ctx, user.ID, time.Now().Add(-time.Hour)); err != nil || u.Total != 3 || u.Recent != 3 || u.CustomAliases != 1 {
+		t.Errorf("UsageByOwner() = %+v, %v", u, err)
+	}
+	if links, err := repo.ListByCampaign(ctx, campaign.ID, 0, 10); err != nil || len(links) != 3 {
+		t.Errorf("ListByCampaign() = %d links, %v, want 3", len(links), err)
+	}
+	if err := repo.DeleteCampaign(ctx, campaign); err != nil {
+		t.Fatalf("DeleteCampaign() failed: %v", err)
+	}
+	if links, err := repo.ListByCampaign(ctx, campaign.ID, 0, 10); err != nil || len(links) != 0 {
+		t.Errorf("ListByCampaign() after DeleteCampaign() = %d links, %v, want none", len(links), err)
+	}
+}
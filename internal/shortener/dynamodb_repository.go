@@ -0,0 +1,2275 @@
+package shortener
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dynamoCreateTimeout bounds how long NewDynamoDBRepository waits for a new
+// table to become active.
+const dynamoCreateTimeout = 2 * time.Minute
+
+// Secondary indexes of the DynamoDB table. dynamoListIndex orders the items
+// of a collection (all links, reports, audit entries, or the campaigns,
+// domains, organizations or API keys of a user) by ID under the partition gpk; dynamoOwnerIndex orders
+// the links of each owner under gpk2.
+const (
+	dynamoListIndex  = "gsi1"
+	dynamoOwnerIndex = "gsi2"
+)
+
+// DynamoDBRepository stores everything in a single DynamoDB table, so that
+// the service can run on AWS without a database server. Items are keyed by
+// pk and sk:
+//
+//	link#<id>        link            the link
+//	link#<id>        report#<ip>     an open abuse report
+//	link#<id>        variant#<name>  served experiment variants
+//	link#<id>        hour#<start>    hourly clicks (day#<start> daily)
+//	link#<id>        source#<start>  daily clicks by source dimension and value
+//	code#<code>      code            the ID of the link with a free-form code
+//	counter#<name>   counter         ID sequences of links, users, etc.
+//
+// plus users, emails, API keys, campaigns, domains, organizations with their
+// members and invitations, and audit entries under their own prefixes. IDs
+// come from atomic counters, so short codes stay as compact as with
+// PostgreSQL.
+//
+// Lookups, edits and quota checks read single items or one index partition.
+// Listing all links queries one index partition, which DynamoDB caps at a
+// few thousand writes per second of new links. The admin stats, top links,
+// campaign stats and PurgeExpired scan the table, as DynamoDB cannot
+// aggregate; they cost read capacity in proportion to the table size. Clicks
+// are added to the rollups as they are recorded, so RollupClicks has
+// nothing to do.
+type DynamoDBRepository struct {
+	db    *dynamoClient
+	table string
+}
+
+// NewDynamoDBRepository connects to the table of cfg, creating it with its
+// indexes and on-demand capacity if it does not exist.
+func NewDynamoDBRepository(cfg DynamoDBConfig) (*DynamoDBRepository, error) {
+	db, err := newDynamoClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &DynamoDBRepository{db: db, table: cfg.Table}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dynamoCreateTimeout)
+	defer cancel()
+	if err := r.createTable(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// createTable creates the table unless it exists and waits until it and its
+// indexes are active.
+func (r *DynamoDBRepository) createTable(ctx context.Context) error {
+	for {
+		var out struct {
+			Table struct {
+				TableStatus            string
+				GlobalSecondaryIndexes []struct{ IndexStatus string }
+			}
+		}
+		err := r.db.call(ctx, "DescribeTable", map[string]any{"TableName": r.table}, &out)
+		if dynamoErrorIs(err, "ResourceNotFoundException") {
+			err = r.db.call(ctx, "CreateTable", dynamoTableSchema(r.table), nil)
+			if err != nil && !dynamoErrorIs(err, "ResourceInUseException") {
+				return fmt.Errorf("failed to create table %s: %w", r.table, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to describe table %s: %w", r.table, err)
+		}
+
+		active := out.Table.TableStatus == "ACTIVE"
+		for _, index := range out.Table.GlobalSecondaryIndexes {
+			active = active && index.IndexStatus == "ACTIVE"
+		}
+		if active {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("table %s not active: %w", r.table, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// dynamoTableSchema returns the CreateTable request of the table.
+func dynamoTableSchema(table string) map[string]any {
+	attr := func(name, typ string) map[string]string {
+		return map[string]string{"AttributeName": name, "AttributeType": typ}
+	}
+	key := func(hash, rng string) []map[string]string {
+		return []map[string]string{{"AttributeName": hash, "KeyType": "HASH"}, {"AttributeName": rng, "KeyType": "RANGE"}}
+	}
+	index := func(name, hash, rng string) map[string]any {
+		return map[string]any{"IndexName": name, "KeySchema": key(hash, rng), "Projection": map[string]string{"ProjectionType": "ALL"}}
+	}
+	return map[string]any{
+		"TableName":   table,
+		"BillingMode": "PAY_PER_REQUEST",
+		"AttributeDefinitions": []map[string]string{
+			attr("pk", "S"), attr("sk", "S"), attr("gpk", "S"), attr("gsk", "N"), attr("gpk2", "S"), attr("gsk2", "N"),
+		},
+		"KeySchema": key("pk", "sk"),
+		"GlobalSecondaryIndexes": []map[string]any{
+			index(dynamoListIndex, "gpk", "gsk"),
+			index(dynamoOwnerIndex, "gpk2", "gsk2"),
+		},
+	}
+}
+
+func dynamoKey(pk, sk string) dynamoItem {
+	return dynamoItem{"pk": dynamoS(pk), "sk": dynamoS(sk)}
+}
+
+func linkPK(id uint64) string {
+	return "link#" + strconv.FormatUint(id, 10)
+}
+
+func linkKey(id uint64) dynamoItem {
+	return dynamoKey(linkPK(id), "link")
+}
+
+// bucketSK returns the sort key of the bucket of kind (hour, day or source)
+// starting at start, padded so that keys sort by time.
+func bucketSK(kind string, start time.Time) string {
+	return fmt.Sprintf("%s#%012d", kind, start.Unix())
+}
+
+// listed adds the attributes placing an item with ID id in the collection
+// partition of dynamoListIndex.
+func listed(it dynamoItem, partition string, id uint64) dynamoItem {
+	it["gpk"] = dynamoS(partition)
+	it["gsk"] = dynamoN(int64(id))
+	it["id"] = dynamoN(int64(id))
+	return it
+}
+
+// get reads the item with key, returning ErrNotFound if there is none.
+func (r *DynamoDBRepository) get(ctx context.Context, key dynamoItem) (dynamoItem, error) {
+	var out struct{ Item dynamoItem }
+	if err := r.db.call(ctx, "GetItem", map[string]any{"TableName": r.table, "Key": key, "ConsistentRead": true}, &out); err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, ErrNotFound
+	}
+	return out.Item, nil
+}
+
+// put writes it if condition, if any, holds.
+func (r *DynamoDBRepository) put(ctx context.Context, it dynamoItem, condition string) error {
+	in := map[string]any{"TableName": r.table, "Item": it}
+	if condition != "" {
+		in["ConditionExpression"] = condition
+	}
+	return r.db.call(ctx, "PutItem", in, nil)
+}
+
+// update runs the update expression on the item with key if condition
+// holds, returning ErrNotFound if it does not, and decodes the updated
+// attributes into out, if not nil.
+func (r *DynamoDBRepository) update(ctx context.Context, key dynamoItem, e *dynamoExpr, expr, condition string, out *dynamoItem) error {
+	in := e.apply(map[string]any{"TableName": r.table, "Key": key, "UpdateExpression": expr})
+	if condition != "" {
+		in["ConditionExpression"] = condition
+	}
+	var resp struct{ Attributes dynamoItem }
+	if out != nil {
+		in["ReturnValues"] = "UPDATED_NEW"
+	}
+	err := r.db.call(ctx, "UpdateItem", in, &resp)
+	if dynamoErrorIs(err, "ConditionalCheckFailedException") {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		*out = resp.Attributes
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) delete(ctx context.Context, key dynamoItem) error {
+	return r.db.call(ctx, "DeleteItem", map[string]any{"TableName": r.table, "Key": key}, nil)
+}
+
+// transact runs the actions (Put, Update, Delete or ConditionCheck requests
+// keyed by their kind) in one transaction.
+func (r *DynamoDBRepository) transact(ctx context.Context, actions ...map[string]any) error {
+	items := make([]map[string]any, len(actions))
+	for i, action := range actions {
+		for kind, req := range action {
+			req.(map[string]any)["TableName"] = r.table
+			items[i] = map[string]any{kind: req}
+		}
+	}
+	return r.db.call(ctx, "TransactWriteItems", map[string]any{"TransactItems": items}, nil)
+}
+
+// each runs the Query or Scan op and passes the items to fn, page by page,
+// until they run out or fn returns false.
+func (r *DynamoDBRepository) each(ctx context.Context, op string, in map[string]any, fn func(dynamoItem) bool) error {
+	in["TableName"] = r.table
+	for {
+		var out struct {
+			Items            []dynamoItem
+			LastEvaluatedKey dynamoItem
+		}
+		if err := r.db.call(ctx, op, in, &out); err != nil {
+			return err
+		}
+		for _, it := range out.Items {
+			if !fn(it) {
+				return nil
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		in["ExclusiveStartKey"] = out.LastEvaluatedKey
+	}
+}
+
+// queryPartition passes the items of the partition pk with a sort key
+// beginning with prefix, or all of them if it is "", to fn.
+func (r *DynamoDBRepository) queryPartition(ctx context.Context, pk, prefix string, fn func(dynamoItem) bool) error {
+	e := newDynamoExpr()
+	cond := e.name("pk") + " = " + e.value("pk", dynamoS(pk))
+	if prefix != "" {
+		cond += fmt.Sprintf(" AND begins_with(%s, %s)", e.name("sk"), e.value("prefix", dynamoS(prefix)))
+	}
+	return r.each(ctx, "Query", e.apply(map[string]any{"KeyConditionExpression": cond, "ConsistentRead": true}), fn)
+}
+
+// queryIndex passes the items of partition in index to fn, by descending
+// ID below cursor (if not zero), or ascending if ascending is set. filter,
+// if any, uses placeholders of e.
+func (r *DynamoDBRepository) queryIndex(ctx context.Context, index, partition string, cursor uint64, ascending bool, e *dynamoExpr, filter string, fn func(dynamoItem) bool) error {
+	hash, rng := "gpk", "gsk"
+	if index == dynamoOwnerIndex {
+		hash, rng = "gpk2", "gsk2"
+	}
+	cond := e.name(hash) + " = " + e.value("partition", dynamoS(partition))
+	if cursor != 0 {
+		cond += " AND " + e.name(rng) + " < " + e.value("cursor", dynamoN(int64(cursor)))
+	}
+	in := map[string]any{"IndexName": index, "KeyConditionExpression": cond, "ScanIndexForward": ascending}
+	if filter != "" {
+		in["FilterExpression"] = filter
+	}
+	return r.each(ctx, "Query", e.apply(in), fn)
+}
+
+// nextID increments the counter name by n and returns its new value.
+func (r *DynamoDBRepository) nextID(ctx context.Context, name string, n uint64) (uint64, error) {
+	e := newDynamoExpr()
+	var out dynamoItem
+	expr := "ADD " + e.name("n") + " " + e.value("n", dynamoN(int64(n)))
+	if err := r.update(ctx, dynamoKey("counter#"+name, "counter"), e, expr, "", &out); err != nil {
+		return 0, fmt.Errorf("failed to allocate %s id: %w", name, err)
+	}
+	return uint64(out.num("n")), nil
+}
+
+// linkItem encodes the attributes of link stored by Save.
+func linkItem(link *Link) dynamoItem {
+	it := listed(linkKey(link.ID), "link", link.ID)
+	it["url"] = dynamoS(link.OriginalURL)
+	it["url_lower"] = dynamoS(strings.ToLower(link.OriginalURL))
+	it["created_at"] = dynamoN(link.CreatedAt.UnixMicro())
+	it["clicks"] = dynamoN(0)
+	it["status"] = dynamoS(string(StatusActive))
+	it["custom_alias"] = dynamoBool(link.CustomAlias)
+	if link.Code != "" {
+		it["code"] = dynamoS(link.Code)
+	}
+	if link.OwnerID != 0 {
+		it["owner_id"] = dynamoN(int64(link.OwnerID))
+		it["gpk2"] = dynamoS(ownerPartition(link.OwnerID))
+		it["gsk2"] = dynamoN(int64(link.ID))
+	}
+	if link.MaxClicks > 0 {
+		it["max_clicks"] = dynamoN(link.MaxClicks)
+	}
+	for name, t := range map[string]*time.Time{"expires_at": link.ExpiresAt, "active_from": link.ActiveFrom, "active_until": link.ActiveUntil} {
+		if t != nil {
+			it[name] = dynamoN(t.UnixMicro())
+		}
+	}
+	if link.CampaignID != 0 {
+		it["campaign_id"] = dynamoN(int64(link.CampaignID))
+	}
+	if link.Domain != "" {
+		it["domain"] = dynamoS(link.Domain)
+	}
+	if link.AnalyticsDisabled {
+		it["analytics_disabled"] = dynamoBool(true)
+	}
+	if link.OrgID != 0 {
+		it["org_id"] = dynamoN(int64(link.OrgID))
+	}
+	if link.QueryPassthrough != QueryPassthroughOff {
+		it["query_passthrough"] = dynamoS(string(link.QueryPassthrough))
+	}
+	if link.Mask != MaskOff {
+		it["mask"] = dynamoS(string(link.Mask))
+	}
+	if link.BurnAfterReading {
+		it["burn_after_reading"] = dynamoBool(true)
+	}
+	return it
+}
+
+func ownerPartition(ownerID uint64) string {
+	return "owner#" + strconv.FormatUint(ownerID, 10)
+}
+
+// decodeLink decodes a link item, including its targets, rules, experiment,
+// page and metadata, which are stored as JSON.
+func decodeLink(it dynamoItem) (*Link, error) {
+	link := &Link{
+		ID:               uint64(it.num("id")),
+		Code:             it.str("code"),
+		OriginalURL:      it.str("url"),
+		CreatedAt:        time.UnixMicro(it.num("created_at")).UTC(),
+		ExpiresAt:        it.time("expires_at"),
+		MaxClicks:        it.num("max_clicks"),
+		Clicks:           it.num("clicks"),
+		Status:           Status(it.str("status")),
+		OwnerID:          uint64(it.num("owner_id")),
+		ActiveFrom:       it.time("active_from"),
+		ActiveUntil:      it.time("active_until"),
+		CampaignID:       uint64(it.num("campaign_id")),
+		Domain:           it.str("domain"),
+		OrgID:            uint64(it.num("org_id")),
+		QueryPassthrough: QueryPassthrough(it.str("query_passthrough")),
+		Mask:             Mask(it.str("mask")),
+		ConsumedAt:       it.time("consumed_at"),
+	}
+	if v := it["custom_alias"].BOOL; v != nil {
+		link.CustomAlias = *v
+	}
+	if v := it["analytics_disabled"].BOOL; v != nil {
+		link.AnalyticsDisabled = *v
+	}
+	if v := it["burn_after_reading"].BOOL; v != nil {
+		link.BurnAfterReading = *v
+	}
+	for name, dst := range map[string]any{"targets": &link.Targets, "rules": &link.Rules, "experiment": &link.Experiment, "page": &link.Page, "metadata": &link.Metadata, "access": &link.Access} {
+		if raw := it.str(name); raw != "" {
+			if err := json.Unmarshal([]byte(raw), dst); err != nil {
+				return nil, fmt.Errorf("failed to decode %s of link %d: %w", name, link.ID, err)
+			}
+		}
+	}
+	return link, nil
+}
+
+func (r *DynamoDBRepository) Save(ctx context.Context, link *Link) (uint64, error) {
+	link.CreatedAt = time.Now().UTC()
+	pinned := link.ID != 0
+
+	var err error
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		if !pinned {
+			if link.ID, err = r.nextID(ctx, "links", 1); err != nil {
+				return 0, err
+			}
+		}
+		err = r.insert(ctx, link)
+		if err == nil {
+			link.Status = StatusActive
+			return link.ID, nil
+		}
+		// As with PostgreSQL, a taken generated ID was pinned by a custom
+		// alias; the counter has already moved on
+		if pinned || err != errIDTaken {
+			break
+		}
+	}
+	if !pinned {
+		link.ID = 0
+	}
+	if errors.Is(err, ErrConflict) {
+		return 0, ErrConflict
+	}
+	return 0, fmt.Errorf("failed to save url: %w", err)
+}
+
+// insert writes the link item and, for a free-form code, the code item,
+// returning ErrConflict if either exists. Deleted links keep their items,
+// so their IDs and codes stay reserved.
+func (r *DynamoDBRepository) insert(ctx context.Context, link *Link) error {
+	notExists := "attribute_not_exists(pk)"
+	if link.Code == "" {
+		err := r.put(ctx, linkItem(link), notExists)
+		if dynamoErrorIs(err, "ConditionalCheckFailedException") {
+			return errIDTaken
+		}
+		return err
+	}
+	code := dynamoKey("code#"+link.Code, "code")
+	code["id"] = dynamoN(int64(link.ID))
+	err := r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": linkItem(link), "ConditionExpression": notExists}},
+		map[string]any{"Put": map[string]any{"Item": code, "ConditionExpression": notExists}},
+	)
+	switch {
+	case dynamoCanceled(err, 1):
+		return ErrConflict
+	case dynamoCanceled(err, 0):
+		return errIDTaken
+	}
+	return err
+}
+
+// errIDTaken is returned by insert when the ID of the link is in use, which
+// Save reports as ErrConflict for pinned IDs and retries otherwise.
+var errIDTaken = fmt.Errorf("id taken: %w", ErrConflict)
+
+func (r *DynamoDBRepository) NextID(ctx context.Context) (uint64, error) {
+	return r.nextID(ctx, "links", 1)
+}
+
+func (r *DynamoDBRepository) ReserveIDs(ctx context.Context, n uint64) (uint64, error) {
+	last, err := r.nextID(ctx, "links", n)
+	if err != nil {
+		return 0, err
+	}
+	return last - n + 1, nil
+}
+
+func (r *DynamoDBRepository) Get(ctx context.Context, id uint64) (*Link, error) {
+	it, err := r.get(ctx, linkKey(id))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url for id %d: %w", id, err)
+	}
+	if it.time("deleted_at") != nil {
+		return nil, ErrNotFound
+	}
+	return decodeLink(it)
+}
+
+func (r *DynamoDBRepository) GetByCode(ctx context.Context, code string) (*Link, error) {
+	it, err := r.get(ctx, dynamoKey("code#"+code, "code"))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url for code %s: %w", code, err)
+	}
+	return r.Get(ctx, uint64(it.num("id")))
+}
+
+// listLinks returns up to limit links that are not deleted from partition of
+// index with an ID below cursor, newest first. filter, if any, uses
+// placeholders of e.
+func (r *DynamoDBRepository) listLinks(ctx context.Context, index, partition string, cursor uint64, limit int, e *dynamoExpr, filter string) ([]*Link, error) {
+	live := "attribute_not_exists(" + e.name("deleted_at") + ")"
+	if filter != "" {
+		live += " AND " + filter
+	}
+	links := make([]*Link, 0, limit)
+	var decodeErr error
+	err := r.queryIndex(ctx, index, partition, cursor, false, e, live, func(it dynamoItem) bool {
+		link, err := decodeLink(it)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		links = append(links, link)
+		return len(links) < limit
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls: %w", err)
+	}
+	return links, nil
+}
+
+func (r *DynamoDBRepository) List(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+	return r.listLinks(ctx, dynamoListIndex, "link", cursor, limit, newDynamoExpr(), "")
+}
+
+func (r *DynamoDBRepository) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error) {
+	e := newDynamoExpr()
+	q := e.value("query", dynamoS(strings.ToLower(query)))
+	filter := fmt.Sprintf("(contains(%s, %s) OR contains(%s, %s))", e.name("url_lower"), q, e.name("meta_lower"), q)
+	return r.listLinks(ctx, dynamoListIndex, "link", cursor, limit, e, filter)
+}
+
+func (r *DynamoDBRepository) ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.listLinks(ctx, dynamoOwnerIndex, ownerPartition(ownerID), cursor, limit, newDynamoExpr(), "")
+}
+
+func (r *DynamoDBRepository) ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error) {
+	e := newDynamoExpr()
+	filter := e.name("campaign_id") + " = " + e.value("campaign", dynamoN(int64(campaignID)))
+	return r.listLinks(ctx, dynamoListIndex, "link", cursor, limit, e, filter)
+}
+
+func (r *DynamoDBRepository) ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error) {
+	e := newDynamoExpr()
+	filter := e.name("org_id") + " = " + e.value("org", dynamoN(int64(orgID)))
+	return r.listLinks(ctx, dynamoListIndex, "link", cursor, limit, e, filter)
+}
+
+// updateLink runs the update expression on the link item unless the link is
+// deleted, returning ErrNotFound then.
+func (r *DynamoDBRepository) updateLink(ctx context.Context, link *Link, e *dynamoExpr, expr string) error {
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", e.name("pk"), e.name("deleted_at"))
+	err := r.update(ctx, linkKey(link.ID), e, expr, condition, nil)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to update url %d: %w", link.ID, err)
+	}
+	return err
+}
+
+// assignments builds a SET and REMOVE update expression from attribute
+// values, removing the attributes whose value is nil.
+func assignments(e *dynamoExpr, values map[string]*dynamoValue) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var set, remove []string
+	for _, name := range names {
+		if v := values[name]; v != nil {
+			set = append(set, e.name(name)+" = "+e.value(name, *v))
+		} else {
+			remove = append(remove, e.name(name))
+		}
+	}
+	var expr []string
+	if len(set) > 0 {
+		expr = append(expr, "SET "+strings.Join(set, ", "))
+	}
+	if len(remove) > 0 {
+		expr = append(expr, "REMOVE "+strings.Join(remove, ", "))
+	}
+	return strings.Join(expr, " ")
+}
+
+// optional returns v, or nil if present is false.
+func optional(v dynamoValue, present bool) *dynamoValue {
+	if !present {
+		return nil
+	}
+	return &v
+}
+
+// optionalJSON returns the JSON encoding of v, or nil if present is false.
+func optionalJSON(v any, present bool) (*dynamoValue, error) {
+	if !present {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return optional(dynamoS(string(data)), true), nil
+}
+
+func (r *DynamoDBRepository) Update(ctx context.Context, link *Link) error {
+	var expires dynamoValue
+	if link.ExpiresAt != nil {
+		expires = dynamoN(link.ExpiresAt.UnixMicro())
+	}
+	e := newDynamoExpr()
+	expr := assignments(e, map[string]*dynamoValue{
+		"url":         optional(dynamoS(link.OriginalURL), true),
+		"url_lower":   optional(dynamoS(strings.ToLower(link.OriginalURL)), true),
+		"expires_at":  optional(expires, link.ExpiresAt != nil),
+		"max_clicks":  optional(dynamoN(link.MaxClicks), link.MaxClicks > 0),
+		"campaign_id": optional(dynamoN(int64(link.CampaignID)), link.CampaignID != 0),
+		"domain":      optional(dynamoS(link.Domain), link.Domain != ""),
+		"org_id":      optional(dynamoN(int64(link.OrgID)), link.OrgID != 0),
+		// Absent means tracked, like links stored before the flag existed
+		"analytics_disabled": optional(dynamoBool(true), link.AnalyticsDisabled),
+		"query_passthrough":  optional(dynamoS(string(link.QueryPassthrough)), link.QueryPassthrough != QueryPassthroughOff),
+		"mask":               optional(dynamoS(string(link.Mask)), link.Mask != MaskOff),
+	})
+	return r.updateLink(ctx, link, e, expr)
+}
+
+// setJSON stores v as the JSON attribute name of link, or removes it if
+// present is false.
+func (r *DynamoDBRepository) setJSON(ctx context.Context, link *Link, name string, v any, present bool) error {
+	value, err := optionalJSON(v, present)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	e := newDynamoExpr()
+	return r.updateLink(ctx, link, e, assignments(e, map[string]*dynamoValue{name: value}))
+}
+
+func (r *DynamoDBRepository) SetTargets(ctx context.Context, link *Link, targets []Target) error {
+	return r.setJSON(ctx, link, "targets", targets, len(targets) > 0)
+}
+
+func (r *DynamoDBRepository) SetRules(ctx context.Context, link *Link, rules []Rule) error {
+	return r.setJSON(ctx, link, "rules", rules, len(rules) > 0)
+}
+
+func (r *DynamoDBRepository) SetExperiment(ctx context.Context, link *Link, e *Experiment) error {
+	return r.setJSON(ctx, link, "experiment", e, e != nil)
+}
+
+func (r *DynamoDBRepository) SetPage(ctx context.Context, link *Link, page *PageInfo) error {
+	return r.setJSON(ctx, link, "page", page, page != nil)
+}
+
+// SetMetadata also stores the lowercased title and description in
+// meta_lower, for Search to match.
+func (r *DynamoDBRepository) SetMetadata(ctx context.Context, link *Link, m *Metadata) error {
+	value, err := optionalJSON(m, m != nil)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	var lower string
+	if m != nil {
+		lower = strings.ToLower(m.Title + "\n" + m.Description)
+	}
+	e := newDynamoExpr()
+	return r.updateLink(ctx, link, e, assignments(e, map[string]*dynamoValue{
+		"metadata":   value,
+		"meta_lower": optional(dynamoS(lower), m != nil),
+	}))
+}
+
+func (r *DynamoDBRepository) SetAccess(ctx context.Context, link *Link, a *Access) error {
+	return r.setJSON(ctx, link, "access", a, a != nil)
+}
+
+// add adds n to the counter attribute of the item with key, creating it.
+func (r *DynamoDBRepository) add(ctx context.Context, key dynamoItem, n int64, attrs dynamoItem) error {
+	e := newDynamoExpr()
+	expr := "ADD " + e.name("n") + " " + e.value("n", dynamoN(n))
+	if len(attrs) > 0 {
+		values := make(map[string]*dynamoValue, len(attrs))
+		for name, v := range attrs {
+			values[name] = &v
+		}
+		expr += " " + assignments(e, values)
+	}
+	return r.update(ctx, key, e, expr, "", nil)
+}
+
+func (r *DynamoDBRepository) RecordVariant(ctx context.Context, link *Link, name string) error {
+	if err := r.add(ctx, dynamoKey(linkPK(link.ID), "variant#"+name), 1, dynamoItem{"name": dynamoS(name)}); err != nil {
+		return fmt.Errorf("failed to record variant for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) VariantStats(ctx context.Context, link *Link) (map[string]int64, error) {
+	served := make(map[string]int64)
+	err := r.queryPartition(ctx, linkPK(link.ID), "variant#", func(it dynamoItem) bool {
+		served[it.str("name")] = it.num("n")
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant stats for id %d: %w", link.ID, err)
+	}
+	return served, nil
+}
+
+// healthKey returns the key of the item holding the latest health check of
+// the link id.
+func healthKey(id uint64) dynamoItem {
+	return dynamoKey(linkPK(id), "health")
+}
+
+func (r *DynamoDBRepository) SetHealth(ctx context.Context, link *Link, h *Health) error {
+	it := healthKey(link.ID)
+	it["id"] = dynamoN(int64(link.ID))
+	it["status"] = dynamoS(string(h.Status))
+	it["status_code"] = dynamoN(int64(h.StatusCode))
+	it["failures"] = dynamoN(int64(h.Failures))
+	it["checked_at"] = dynamoN(h.CheckedAt.UnixMicro())
+	if err := r.put(ctx, it, ""); err != nil {
+		return fmt.Errorf("failed to set health for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error) {
+	health := make(map[uint64]*Health, len(links))
+	for _, link := range links {
+		it, err := r.get(ctx, healthKey(link.ID))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get health for id %d: %w", link.ID, err)
+		}
+		health[link.ID] = decodeHealth(it)
+	}
+	return health, nil
+}
+
+// HealthStats scans the link and health items, since only the link items
+// tell which links are deleted.
+func (r *DynamoDBRepository) HealthStats(ctx context.Context) (*HealthCounts, error) {
+	e := newDynamoExpr()
+	filter := e.name("sk") + " = " + e.value("link", dynamoS("link")) + " OR " + e.name("sk") + " = " + e.value("health", dynamoS("health"))
+	statuses := make(map[uint64]HealthStatus)
+	live := make(map[uint64]bool)
+	err := r.each(ctx, "Scan", e.apply(map[string]any{"FilterExpression": filter}), func(it dynamoItem) bool {
+		id := uint64(it.num("id"))
+		if it.str("sk") == "health" {
+			statuses[id] = HealthStatus(it.str("status"))
+		} else if it.time("deleted_at") == nil {
+			live[id] = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute health stats: %w", err)
+	}
+	counts := &HealthCounts{}
+	for id, status := range statuses {
+		if live[id] {
+			counts.add(status, 1)
+		}
+	}
+	return counts, nil
+}
+
+func decodeHealth(it dynamoItem) *Health {
+	h := &Health{
+		Status:     HealthStatus(it.str("status")),
+		StatusCode: int(it.num("status_code")),
+		Failures:   int(it.num("failures")),
+	}
+	if t := it.time("checked_at"); t != nil {
+		h.CheckedAt = *t
+	}
+	return h
+}
+
+func (r *DynamoDBRepository) Delete(ctx context.Context, link *Link) error {
+	e := newDynamoExpr()
+	expr := "SET " + e.name("deleted_at") + " = " + e.value("now", dynamoN(time.Now().UnixMicro()))
+	return r.updateLink(ctx, link, e, expr)
+}
+
+func (r *DynamoDBRepository) Restore(ctx context.Context, link *Link, ownerID uint64) error {
+	id := link.ID
+	if id == 0 {
+		it, err := r.get(ctx, dynamoKey("code#"+link.Code, "code"))
+		if err == ErrNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore url %s: %w", link.Code, err)
+		}
+		id = uint64(it.num("id"))
+	}
+	e := newDynamoExpr()
+	expr := "REMOVE " + e.name("deleted_at")
+	condition := fmt.Sprintf("attribute_exists(%s) AND %s = %s", e.name("deleted_at"), e.name("owner_id"), e.value("owner", dynamoN(int64(ownerID))))
+	err := r.update(ctx, linkKey(id), e, expr, condition, nil)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to restore url %d: %w", id, err)
+	}
+	return err
+}
+
+func (r *DynamoDBRepository) SetStatus(ctx context.Context, link *Link, status Status) error {
+	e := newDynamoExpr()
+	expr := "SET " + e.name("status") + " = " + e.value("status", dynamoS(string(status)))
+	if err := r.updateLink(ctx, link, e, expr); err != nil {
+		return err
+	}
+	link.Status = status
+	return nil
+}
+
+// Invalidate is a no-op: lookups always read the table.
+func (r *DynamoDBRepository) Invalidate(ctx context.Context, link *Link) error {
+	return nil
+}
+
+// scanLinks passes every link item, deleted or not, and every rollup item of
+// kind in [from, to) (none if kind is "") to fn.
+func (r *DynamoDBRepository) scanLinks(ctx context.Context, kind string, from, to time.Time, fn func(dynamoItem) error) error {
+	e := newDynamoExpr()
+	filter := e.name("sk") + " = " + e.value("link", dynamoS("link"))
+	if kind != "" {
+		filter += fmt.Sprintf(" OR (%s >= %s AND %s < %s)", e.name("sk"), e.value("from", dynamoS(bucketSK(kind, from))),
+			e.name("sk"), e.value("to", dynamoS(bucketSK(kind, to))))
+	}
+	var fnErr error
+	err := r.each(ctx, "Scan", e.apply(map[string]any{"FilterExpression": filter}), func(it dynamoItem) bool {
+		fnErr = fn(it)
+		return fnErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func (r *DynamoDBRepository) Stats(ctx context.Context, now time.Time) (*Stats, error) {
+	var st Stats
+	err := r.scanLinks(ctx, "", now, now, func(it dynamoItem) error {
+		if it.time("deleted_at") != nil {
+			return nil
+		}
+		link, err := decodeLink(it)
+		if err != nil {
+			return err
+		}
+		st.Total++
+		st.Clicks += link.Clicks
+		expired := link.IsExpired(now)
+		switch {
+		case link.IsDisabled():
+			st.Disabled++
+		case link.IsFlagged():
+			st.Flagged++
+		case link.IsReported():
+			st.Reported++
+		case !expired:
+			st.Active++
+		}
+		if expired {
+			st.Expired++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	return &st, nil
+}
+
+func (r *DynamoDBRepository) AddReport(ctx context.Context, report *Report) (int64, error) {
+	link, err := r.Get(ctx, report.LinkID)
+	if err != nil {
+		return 0, err
+	}
+	open, err := r.openReports(ctx, report.LinkID)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := r.nextID(ctx, "reports", 1)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	it := listed(dynamoKey(linkPK(report.LinkID), "report#"+report.Reporter), "report", id)
+	it["link_id"] = dynamoN(int64(report.LinkID))
+	it["reporter"] = dynamoS(report.Reporter)
+	it["created_at"] = dynamoN(now.UnixMicro())
+	if report.Reason != "" {
+		it["reason"] = dynamoS(report.Reason)
+	}
+	if link.Code != "" {
+		it["code"] = dynamoS(link.Code)
+	}
+	err = r.put(ctx, it, "attribute_not_exists(pk)")
+	if dynamoErrorIs(err, "ConditionalCheckFailedException") {
+		// The reporter already has an open report for the link
+		return open, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to add report for id %d: %w", report.LinkID, err)
+	}
+	report.ID, report.LinkCode, report.CreatedAt = id, link.Code, now
+	return open + 1, nil
+}
+
+// openReports counts the open reports of the link id.
+func (r *DynamoDBRepository) openReports(ctx context.Context, id uint64) (int64, error) {
+	var open int64
+	err := r.queryPartition(ctx, linkPK(id), "report#", func(dynamoItem) bool {
+		open++
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reports for id %d: %w", id, err)
+	}
+	return open, nil
+}
+
+func (r *DynamoDBRepository) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error) {
+	reports := make([]*Report, 0, limit)
+	err := r.queryIndex(ctx, dynamoListIndex, "report", cursor, false, newDynamoExpr(), "", func(it dynamoItem) bool {
+		reports = append(reports, &Report{
+			ID:        uint64(it.num("id")),
+			LinkID:    uint64(it.num("link_id")),
+			LinkCode:  it.str("code"),
+			Reason:    it.str("reason"),
+			Reporter:  it.str("reporter"),
+			CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+		})
+		return len(reports) < limit
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return reports, nil
+}
+
+// ResolveReports deletes the link's reports: like the in-memory repository,
+// only open reports are kept.
+func (r *DynamoDBRepository) ResolveReports(ctx context.Context, link *Link, now time.Time) error {
+	if err := r.deletePartition(ctx, linkPK(link.ID), "report#"); err != nil {
+		return fmt.Errorf("failed to resolve reports for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+// deletePartition deletes the items of the partition pk with a sort key
+// beginning with prefix.
+func (r *DynamoDBRepository) deletePartition(ctx context.Context, pk, prefix string) error {
+	var keys []dynamoItem
+	err := r.queryPartition(ctx, pk, prefix, func(it dynamoItem) bool {
+		keys = append(keys, dynamoItem{"pk": it["pk"], "sk": it["sk"]})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) AddAudit(ctx context.Context, entry *AuditEntry) error {
+	id, err := r.nextID(ctx, "audit", 1)
+	if err != nil {
+		return err
+	}
+	it := listed(dynamoKey("audit#"+strconv.FormatUint(id, 10), "audit"), "audit", id)
+	it["actor"] = dynamoS(entry.Actor)
+	it["action"] = dynamoS(entry.Action)
+	it["code"] = dynamoS(entry.Code)
+	it["detail"] = dynamoS(entry.Detail)
+	it["created_at"] = dynamoN(entry.CreatedAt.UnixMicro())
+	if err := r.put(ctx, it, ""); err != nil {
+		return fmt.Errorf("failed to add audit entry: %w", err)
+	}
+	entry.ID = id
+	return nil
+}
+
+func (r *DynamoDBRepository) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error) {
+	e := newDynamoExpr()
+	var filters []string
+	if f.Actor != "" {
+		filters = append(filters, e.name("actor")+" = "+e.value("actor", dynamoS(f.Actor)))
+	}
+	if f.Code != "" {
+		filters = append(filters, e.name("code")+" = "+e.value("code", dynamoS(f.Code)))
+	}
+	if !f.Since.IsZero() {
+		filters = append(filters, e.name("created_at")+" >= "+e.value("since", dynamoN(f.Since.UnixMicro())))
+	}
+	if !f.Until.IsZero() {
+		filters = append(filters, e.name("created_at")+" < "+e.value("until", dynamoN(f.Until.UnixMicro())))
+	}
+
+	entries := make([]*AuditEntry, 0, limit)
+	err := r.queryIndex(ctx, dynamoListIndex, "audit", cursor, false, e, strings.Join(filters, " AND "), func(it dynamoItem) bool {
+		entries = append(entries, &AuditEntry{
+			ID:        uint64(it.num("id")),
+			Actor:     it.str("actor"),
+			Action:    it.str("action"),
+			Code:      it.str("code"),
+			Detail:    it.str("detail"),
+			CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+		})
+		return len(entries) < limit
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+// linkChangeKey is the key of a destination change, listed under
+// linkChangesList of its link.
+func linkChangeKey(id uint64) dynamoItem {
+	return dynamoKey("link_change#"+strconv.FormatUint(id, 10), "link_change")
+}
+
+func linkChangesList(linkID uint64) string {
+	return "link_changes#" + strconv.FormatUint(linkID, 10)
+}
+
+func (r *DynamoDBRepository) AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error {
+	id, err := r.nextID(ctx, "link_changes", 1)
+	if err != nil {
+		return err
+	}
+	it := listed(linkChangeKey(id), linkChangesList(link.ID), id)
+	it["url_id"] = dynamoN(int64(link.ID))
+	it["actor"] = dynamoS(change.Actor)
+	it["old_url"] = dynamoS(change.OldURL)
+	it["new_url"] = dynamoS(change.NewURL)
+	it["created_at"] = dynamoN(change.CreatedAt.UnixMicro())
+	if err := r.put(ctx, it, ""); err != nil {
+		return fmt.Errorf("failed to add destination change for id %d: %w", link.ID, err)
+	}
+	change.ID = id
+	return nil
+}
+
+func (r *DynamoDBRepository) ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error) {
+	changes := make([]*LinkChange, 0, limit)
+	err := r.queryIndex(ctx, dynamoListIndex, linkChangesList(link.ID), cursor, false, newDynamoExpr(), "", func(it dynamoItem) bool {
+		changes = append(changes, decodeLinkChange(it))
+		return len(changes) < limit
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination changes for id %d: %w", link.ID, err)
+	}
+	return changes, nil
+}
+
+func (r *DynamoDBRepository) GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error) {
+	it, err := r.get(ctx, linkChangeKey(id))
+	if err == ErrNotFound || (err == nil && uint64(it.num("url_id")) != link.ID) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination change %d: %w", id, err)
+	}
+	return decodeLinkChange(it), nil
+}
+
+func decodeLinkChange(it dynamoItem) *LinkChange {
+	return &LinkChange{
+		ID:        uint64(it.num("id")),
+		Actor:     it.str("actor"),
+		OldURL:    it.str("old_url"),
+		NewURL:    it.str("new_url"),
+		CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+	}
+}
+
+func userKey(id uint64) dynamoItem {
+	return dynamoKey("user#"+strconv.FormatUint(id, 10), "user")
+}
+
+func (r *DynamoDBRepository) CreateUser(ctx context.Context, user *User) error {
+	id, err := r.nextID(ctx, "users", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	it := userKey(id)
+	it["id"] = dynamoN(int64(id))
+	it["email"] = dynamoS(user.Email)
+	it["password_hash"] = dynamoS(user.PasswordHash)
+	it["plan"] = dynamoS(user.Plan)
+	it["role"] = dynamoS(user.Role)
+	it["created_at"] = dynamoN(now.UnixMicro())
+	email := dynamoKey("email#"+user.Email, "email")
+	email["id"] = dynamoN(int64(id))
+
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": email, "ConditionExpression": "attribute_not_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": it}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	user.ID, user.CreatedAt = id, now
+	return nil
+}
+
+// decodeUser decodes a user item. Users created before roles are editors, as
+// in the SQL backends.
+func decodeUser(it dynamoItem) *User {
+	return &User{
+		ID:           uint64(it.num("id")),
+		Email:        it.str("email"),
+		PasswordHash: it.str("password_hash"),
+		Plan:         it.str("plan"),
+		Role:         cmp.Or(it.str("role"), RoleEditor),
+		CreatedAt:    time.UnixMicro(it.num("created_at")).UTC(),
+	}
+}
+
+// userBy returns the user whose ID is held by the item with key.
+func (r *DynamoDBRepository) userBy(ctx context.Context, key dynamoItem) (*User, error) {
+	ref, err := r.get(ctx, key)
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	it, err := r.get(ctx, userKey(uint64(ref.num("id"))))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return decodeUser(it), nil
+}
+
+func (r *DynamoDBRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return r.userBy(ctx, dynamoKey("email#"+email, "email"))
+}
+
+func apiKeyKey(keyHash string) dynamoItem {
+	return dynamoKey("apikey#"+keyHash, "apikey")
+}
+
+func (r *DynamoDBRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	id, err := r.nextID(ctx, "api_keys", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	// id holds the user, as in the items of identities and of keys created
+	// before scopes, which are not listed
+	it := apiKeyKey(key.KeyHash)
+	it["id"] = dynamoN(int64(key.UserID))
+	it["key_id"] = dynamoN(int64(id))
+	it["gpk"] = dynamoS(fmt.Sprintf("apikeys#%d", key.UserID))
+	it["gsk"] = dynamoN(int64(id))
+	it["scopes"] = dynamoS(strings.Join(key.Scopes, ","))
+	it["created_at"] = dynamoN(now.UnixMicro())
+	if key.ExpiresAt != nil {
+		it["expires_at"] = dynamoN(key.ExpiresAt.UnixMicro())
+	}
+	err = r.transact(ctx,
+		map[string]any{"ConditionCheck": map[string]any{"Key": userKey(key.UserID), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": it}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	key.ID, key.CreatedAt = id, now
+	return nil
+}
+
+// decodeAPIKey decodes an API key item. Keys created before scopes have all
+// of them.
+func decodeAPIKey(it dynamoItem) *APIKey {
+	scopes := AllScopes
+	if _, ok := it["scopes"]; ok {
+		scopes = splitScopes(it.str("scopes"))
+	}
+	return &APIKey{
+		ID:         uint64(it.num("key_id")),
+		UserID:     uint64(it.num("id")),
+		KeyHash:    strings.TrimPrefix(it.str("pk"), "apikey#"),
+		Scopes:     slices.Clone(scopes),
+		ExpiresAt:  it.time("expires_at"),
+		LastUsedAt: it.time("last_used_at"),
+		CreatedAt:  time.UnixMicro(it.num("created_at")).UTC(),
+	}
+}
+
+func (r *DynamoDBRepository) GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	it, err := r.get(ctx, apiKeyKey(keyHash))
+	if err == ErrNotFound {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	key := decodeAPIKey(it)
+	user, err := r.get(ctx, userKey(key.UserID))
+	if err == ErrNotFound {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return decodeUser(user), key, nil
+}
+
+func (r *DynamoDBRepository) ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error) {
+	keys := []*APIKey{}
+	err := r.queryIndex(ctx, dynamoListIndex, fmt.Sprintf("apikeys#%d", userID), 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		keys = append(keys, decodeAPIKey(it))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *DynamoDBRepository) TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	err := r.setAPIKeyTime(ctx, keyHash, "last_used_at", at)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to touch api key: %w", err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	err := r.setAPIKeyTime(ctx, keyHash, "expires_at", at)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to expire api key: %w", err)
+	}
+	return err
+}
+
+// setAPIKeyTime sets the time attribute name of the API key with keyHash, or
+// returns ErrNotFound.
+func (r *DynamoDBRepository) setAPIKeyTime(ctx context.Context, keyHash, name string, at time.Time) error {
+	e := newDynamoExpr()
+	expr := "SET " + e.name(name) + " = " + e.value("at", dynamoN(at.UnixMicro()))
+	return r.update(ctx, apiKeyKey(keyHash), e, expr, "attribute_exists("+e.name("pk")+")", nil)
+}
+
+func (r *DynamoDBRepository) DeleteAPIKey(ctx context.Context, keyHash string) error {
+	if err := r.delete(ctx, apiKeyKey(keyHash)); err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error {
+	key := dynamoKey("identity#"+issuer+"#"+subject, "identity")
+	key["id"] = dynamoN(int64(userID))
+	key["created_at"] = dynamoN(time.Now().UnixMicro())
+	err := r.transact(ctx,
+		map[string]any{"ConditionCheck": map[string]any{"Key": userKey(userID), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": key, "ConditionExpression": "attribute_not_exists(pk)"}},
+	)
+	switch {
+	case dynamoCanceled(err, 0):
+		return ErrNotFound
+	case dynamoCanceled(err, 1):
+		return ErrConflict
+	case err != nil:
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) LookupIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	return r.userBy(ctx, dynamoKey("identity#"+issuer+"#"+subject, "identity"))
+}
+
+func (r *DynamoDBRepository) SetUserPlan(ctx context.Context, userID uint64, plan string) error {
+	e := newDynamoExpr()
+	expr := "SET " + e.name("plan") + " = " + e.value("plan", dynamoS(plan))
+	err := r.update(ctx, userKey(userID), e, expr, "attribute_exists("+e.name("pk")+")", nil)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to set plan of user %d: %w", userID, err)
+	}
+	return err
+}
+
+func (r *DynamoDBRepository) SetUserRole(ctx context.Context, userID uint64, role string) error {
+	e := newDynamoExpr()
+	expr := "SET " + e.name("role") + " = " + e.value("role", dynamoS(role))
+	err := r.update(ctx, userKey(userID), e, expr, "attribute_exists("+e.name("pk")+")", nil)
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to set role of user %d: %w", userID, err)
+	}
+	return err
+}
+
+func (r *DynamoDBRepository) UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+	var u Usage
+	err := r.queryIndex(ctx, dynamoOwnerIndex, ownerPartition(ownerID), 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		if time.UnixMicro(it.num("created_at")).After(since) {
+			u.Recent++
+		}
+		if it.time("deleted_at") != nil {
+			return true
+		}
+		u.Total++
+		if v := it["custom_alias"].BOOL; v != nil && *v {
+			u.CustomAliases++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage of user %d: %w", ownerID, err)
+	}
+	return &u, nil
+}
+
+func (r *DynamoDBRepository) IncrementClicks(ctx context.Context, link *Link) (int64, error) {
+	e := newDynamoExpr()
+	var out dynamoItem
+	expr := "ADD " + e.name("clicks") + " " + e.value("one", dynamoN(1))
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s)", e.name("pk"), e.name("deleted_at"))
+	err := r.update(ctx, linkKey(link.ID), e, expr, condition, &out)
+	if err == ErrNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment clicks for id %d: %w", link.ID, err)
+	}
+	return out.num("clicks"), nil
+}
+
+// Consume sets consumed_at on the condition that it is not set yet.
+func (r *DynamoDBRepository) Consume(ctx context.Context, link *Link, at time.Time) (bool, error) {
+	e := newDynamoExpr()
+	expr := "SET " + e.name("consumed_at") + " = " + e.value("at", dynamoN(at.UnixMicro()))
+	condition := fmt.Sprintf("attribute_exists(%s) AND attribute_not_exists(%s) AND attribute_not_exists(%s)", e.name("pk"), e.name("deleted_at"), e.name("consumed_at"))
+	err := r.update(ctx, linkKey(link.ID), e, expr, condition, nil)
+	if err == ErrNotFound {
+		// The condition failed: tell a consumed link from a missing one
+		if _, err := r.Get(ctx, link.ID); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to consume url %d: %w", link.ID, err)
+	}
+	link.ConsumedAt = &at
+	return true, nil
+}
+
+// FlushClicks is a no-op: IncrementClicks writes through.
+func (r *DynamoDBRepository) FlushClicks(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// RecordClick adds the click to the hourly, daily and source rollups of
+// link right away, one conditional-free update each.
+func (r *DynamoDBRepository) RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+	for rollup, counts := range rollupCounts(clickCounts(link.ID, at, src)) {
+		for _, c := range counts {
+			sk, attrs := bucketSK(rollup, c.start), dynamoItem{"start": dynamoN(c.start.Unix())}
+			if rollup == clickSourcesTable {
+				sk = bucketSK("source", c.start) + "#" + c.dimension + "#" + c.value
+				attrs["dimension"], attrs["value"] = dynamoS(c.dimension), dynamoS(c.value)
+			}
+			if err := r.add(ctx, dynamoKey(linkPK(link.ID), sk), c.clicks, attrs); err != nil {
+				return fmt.Errorf("failed to record click for id %d: %w", link.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RollupClicks is a no-op: RecordClick adds clicks to the rollups.
+func (r *DynamoDBRepository) RollupClicks(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (r *DynamoDBRepository) ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	var buckets []ClickBucket
+	err := r.queryBuckets(ctx, link, bucketSK(granularity, from), bucketSK(granularity, to), func(it dynamoItem) {
+		buckets = append(buckets, ClickBucket{Start: time.Unix(it.num("start"), 0).UTC(), Clicks: it.num("n")})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click series for id %d: %w", link.ID, err)
+	}
+	return buckets, nil
+}
+
+func (r *DynamoDBRepository) ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error) {
+	totals := make(map[[2]string]int64)
+	err := r.queryBuckets(ctx, link, bucketSK("source", from), bucketSK("source", to), func(it dynamoItem) {
+		totals[[2]string{it.str("dimension"), it.str("value")}] += it.num("n")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click sources for id %d: %w", link.ID, err)
+	}
+	counts := make([]SourceCount, 0, len(totals))
+	for k, n := range totals {
+		counts = append(counts, SourceCount{Dimension: k[0], Value: k[1], Clicks: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Dimension != b.Dimension {
+			return a.Dimension < b.Dimension
+		}
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.Value < b.Value
+	})
+	return counts, nil
+}
+
+// queryBuckets passes the rollup items of link with a sort key in
+// [from, to) to fn, in order.
+func (r *DynamoDBRepository) queryBuckets(ctx context.Context, link *Link, from, to string, fn func(dynamoItem)) error {
+	e := newDynamoExpr()
+	cond := fmt.Sprintf("%s = %s AND %s BETWEEN %s AND %s", e.name("pk"), e.value("pk", dynamoS(linkPK(link.ID))),
+		e.name("sk"), e.value("from", dynamoS(from)), e.value("to", dynamoS(to)))
+	// BETWEEN includes to, which only a bucket starting at to can equal
+	filter := e.name("sk") + " < " + e.value("to", dynamoS(to))
+	return r.each(ctx, "Query", e.apply(map[string]any{"KeyConditionExpression": cond, "FilterExpression": filter}), func(it dynamoItem) bool {
+		fn(it)
+		return true
+	})
+}
+
+// rankLinks returns the limit most clicked of the links in clicks, breaking
+// ties by ID like the SQL backends.
+func rankLinks(clicks map[uint64]int64, codes map[uint64]string, limit int) []TopLink {
+	ids := make([]uint64, 0, len(clicks))
+	for id := range clicks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if clicks[ids[i]] != clicks[ids[j]] {
+			return clicks[ids[i]] > clicks[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	top := []TopLink{}
+	for _, id := range ids[:min(limit, len(ids))] {
+		top = append(top, TopLink{Code: codes[id], Clicks: clicks[id]})
+	}
+	return top
+}
+
+// rollupScan collects the links and their clicks at granularity in
+// [from, to) in one table scan.
+type rollupScan struct {
+	links  map[uint64]*Link
+	live   map[uint64]bool
+	clicks map[uint64]int64
+}
+
+func (r *DynamoDBRepository) scanRollups(ctx context.Context, granularity string, from, to time.Time) (*rollupScan, error) {
+	s := &rollupScan{links: make(map[uint64]*Link), live: make(map[uint64]bool), clicks: make(map[uint64]int64)}
+	err := r.scanLinks(ctx, granularity, from, to, func(it dynamoItem) error {
+		if it.str("sk") != "link" {
+			id, _ := strconv.ParseUint(strings.TrimPrefix(it.str("pk"), "link#"), 10, 64)
+			s.clicks[id] += it.num("n")
+			return nil
+		}
+		link, err := decodeLink(it)
+		if err != nil {
+			return err
+		}
+		s.links[link.ID] = link
+		s.live[link.ID] = it.time("deleted_at") == nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// PurgeClicks scans the table for rollup items of the granularity, and
+// source items for GranularityDay, that start before before and deletes
+// them one by one.
+func (r *DynamoDBRepository) PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error) {
+	kinds := []string{granularity}
+	if granularity == GranularityDay {
+		kinds = append(kinds, "source")
+	}
+	total := 0
+	for _, kind := range kinds {
+		e := newDynamoExpr()
+		filter := fmt.Sprintf("begins_with(%[1]s, %[2]s) AND %[1]s < %[3]s", e.name("sk"),
+			e.value("kind", dynamoS(kind+"#")), e.value("before", dynamoS(bucketSK(kind, before))))
+		var keys []dynamoItem
+		err := r.each(ctx, "Scan", e.apply(map[string]any{"FilterExpression": filter}), func(it dynamoItem) bool {
+			keys = append(keys, dynamoItem{"pk": it["pk"], "sk": it["sk"]})
+			return true
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to find %s clicks to purge: %w", kind, err)
+		}
+		for _, key := range keys {
+			if err := r.delete(ctx, key); err != nil {
+				return total, fmt.Errorf("failed to purge %s clicks: %w", kind, err)
+			}
+			total++
+		}
+	}
+	return total, nil
+}
+
+// DeleteClicks deletes the rollup and source items in the partition of
+// link.
+func (r *DynamoDBRepository) DeleteClicks(ctx context.Context, link *Link) (int, error) {
+	total := 0
+	for _, kind := range []string{GranularityHour, GranularityDay, "source"} {
+		var keys []dynamoItem
+		err := r.queryPartition(ctx, linkPK(link.ID), kind+"#", func(it dynamoItem) bool {
+			keys = append(keys, dynamoItem{"pk": it["pk"], "sk": it["sk"]})
+			return true
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to find clicks of id %d: %w", link.ID, err)
+		}
+		for _, key := range keys {
+			if err := r.delete(ctx, key); err != nil {
+				return total, fmt.Errorf("failed to delete clicks of id %d: %w", link.ID, err)
+			}
+			total++
+		}
+	}
+	return total, nil
+}
+
+func (r *DynamoDBRepository) TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error) {
+	s, err := r.scanRollups(ctx, granularity, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top stats: %w", err)
+	}
+	var st TopStats
+	clicks, codes := make(map[uint64]int64), make(map[uint64]string)
+	for id, n := range s.clicks {
+		st.Redirects += n
+		if s.live[id] {
+			clicks[id], codes[id] = n, s.links[id].ShortCode()
+		}
+	}
+	for _, link := range s.links {
+		if !link.CreatedAt.Before(from) && link.CreatedAt.Before(to) {
+			st.LinksCreated++
+		}
+	}
+	st.Top = rankLinks(clicks, codes, limit)
+	return &st, nil
+}
+
+func (r *DynamoDBRepository) CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error) {
+	s, err := r.scanRollups(ctx, granularity, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign stats: %w", err)
+	}
+	var st CampaignStats
+	clicks, codes := make(map[uint64]int64), make(map[uint64]string)
+	for id, n := range s.clicks {
+		if link := s.links[id]; link != nil && link.CampaignID == campaignID {
+			st.Clicks += n
+			if s.live[id] {
+				clicks[id], codes[id] = n, link.ShortCode()
+			}
+		}
+	}
+	for id, link := range s.links {
+		if link.CampaignID == campaignID && s.live[id] {
+			st.Links++
+		}
+	}
+	st.Top = rankLinks(clicks, codes, limit)
+	return &st, nil
+}
+
+func campaignKey(id uint64) dynamoItem {
+	return dynamoKey("campaign#"+strconv.FormatUint(id, 10), "campaign")
+}
+
+// campaignNameKey is the key of the item reserving the name of a campaign
+// for its owner.
+func campaignNameKey(ownerID uint64, name string) dynamoItem {
+	return dynamoKey(fmt.Sprintf("campaign_name#%d#%s", ownerID, name), "campaign_name")
+}
+
+func (r *DynamoDBRepository) CreateCampaign(ctx context.Context, c *Campaign) error {
+	id, err := r.nextID(ctx, "campaigns", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	it := listed(campaignKey(id), fmt.Sprintf("campaigns#%d", c.OwnerID), id)
+	it["owner_id"] = dynamoN(int64(c.OwnerID))
+	it["name"] = dynamoS(c.Name)
+	it["created_at"] = dynamoN(now.UnixMicro())
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": campaignNameKey(c.OwnerID, c.Name), "ConditionExpression": "attribute_not_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": it}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create campaign: %w", err)
+	}
+	c.ID, c.CreatedAt = id, now
+	return nil
+}
+
+func decodeCampaign(it dynamoItem) *Campaign {
+	return &Campaign{
+		ID:        uint64(it.num("id")),
+		OwnerID:   uint64(it.num("owner_id")),
+		Name:      it.str("name"),
+		CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+	}
+}
+
+func (r *DynamoDBRepository) GetCampaign(ctx context.Context, id uint64) (*Campaign, error) {
+	it, err := r.get(ctx, campaignKey(id))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign %d: %w", id, err)
+	}
+	return decodeCampaign(it), nil
+}
+
+func (r *DynamoDBRepository) ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error) {
+	campaigns := []*Campaign{}
+	err := r.queryIndex(ctx, dynamoListIndex, fmt.Sprintf("campaigns#%d", ownerID), 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		campaigns = append(campaigns, decodeCampaign(it))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	return campaigns, nil
+}
+
+func (r *DynamoDBRepository) UpdateCampaign(ctx context.Context, c *Campaign) error {
+	stored, err := r.GetCampaign(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	if stored.Name == c.Name {
+		return nil
+	}
+	e := newDynamoExpr()
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": campaignNameKey(stored.OwnerID, c.Name), "ConditionExpression": "attribute_not_exists(pk)"}},
+		map[string]any{"Delete": map[string]any{"Key": campaignNameKey(stored.OwnerID, stored.Name)}},
+		map[string]any{"Update": e.apply(map[string]any{
+			"Key":                 campaignKey(c.ID),
+			"UpdateExpression":    "SET " + e.name("name") + " = " + e.value("name", dynamoS(c.Name)),
+			"ConditionExpression": "attribute_exists(" + e.name("pk") + ")",
+		})},
+	)
+	switch {
+	case dynamoCanceled(err, 0):
+		return ErrConflict
+	case dynamoCanceled(err, 2):
+		return ErrNotFound
+	case err != nil:
+		return fmt.Errorf("failed to update campaign %d: %w", c.ID, err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) DeleteCampaign(ctx context.Context, c *Campaign) error {
+	stored, err := r.GetCampaign(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	err = r.transact(ctx,
+		map[string]any{"Delete": map[string]any{"Key": campaignKey(c.ID), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Delete": map[string]any{"Key": campaignNameKey(stored.OwnerID, stored.Name)}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete campaign %d: %w", c.ID, err)
+	}
+
+	e := newDynamoExpr()
+	filter := e.name("campaign_id") + " = " + e.value("campaign", dynamoN(int64(c.ID)))
+	return r.detachLinks(ctx, dynamoListIndex, "link", e, filter, "campaign_id")
+}
+
+// detachLinks removes the attribute from the links, deleted or not, of
+// partition of index that match filter.
+func (r *DynamoDBRepository) detachLinks(ctx context.Context, index, partition string, e *dynamoExpr, filter, attribute string) error {
+	var ids []uint64
+	err := r.queryIndex(ctx, index, partition, 0, true, e, filter, func(it dynamoItem) bool {
+		ids = append(ids, uint64(it.num("id")))
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find links to detach: %w", err)
+	}
+	for _, id := range ids {
+		e := newDynamoExpr()
+		if err := r.update(ctx, linkKey(id), e, "REMOVE "+e.name(attribute), "", nil); err != nil {
+			return fmt.Errorf("failed to detach url %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func orgKey(id uint64) dynamoItem {
+	return dynamoKey("org#"+strconv.FormatUint(id, 10), "org")
+}
+
+// orgMemberKey is the key of the item recording that userID is a member of
+// the organization orgID. It is listed under the organizations of the user
+// and copies the name of the organization, which cannot change.
+func orgMemberKey(orgID, userID uint64) dynamoItem {
+	return dynamoKey("org#"+strconv.FormatUint(orgID, 10), "member#"+strconv.FormatUint(userID, 10))
+}
+
+func orgMemberItem(o *Org, userID uint64, joined time.Time) dynamoItem {
+	it := listed(orgMemberKey(o.ID, userID), fmt.Sprintf("orgs#%d", userID), o.ID)
+	it["name"] = dynamoS(o.Name)
+	it["created_at"] = dynamoN(o.CreatedAt.UnixMicro())
+	it["user_id"] = dynamoN(int64(userID))
+	it["joined_at"] = dynamoN(joined.UnixMicro())
+	return it
+}
+
+func invitationKey(tokenHash string) dynamoItem {
+	return dynamoKey("invitation#"+tokenHash, "invitation")
+}
+
+func (r *DynamoDBRepository) CreateOrg(ctx context.Context, o *Org, creatorID uint64) error {
+	id, err := r.nextID(ctx, "orgs", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	created := Org{ID: id, Name: o.Name, CreatedAt: now}
+	it := orgKey(id)
+	it["id"] = dynamoN(int64(id))
+	it["name"] = dynamoS(o.Name)
+	it["created_at"] = dynamoN(now.UnixMicro())
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": it}},
+		map[string]any{"Put": map[string]any{"Item": orgMemberItem(&created, creatorID, now)}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+	o.ID, o.CreatedAt = id, now
+	return nil
+}
+
+func decodeOrg(it dynamoItem) *Org {
+	return &Org{
+		ID:        uint64(it.num("id")),
+		Name:      it.str("name"),
+		CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+	}
+}
+
+func (r *DynamoDBRepository) GetOrg(ctx context.Context, id uint64) (*Org, error) {
+	it, err := r.get(ctx, orgKey(id))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization %d: %w", id, err)
+	}
+	return decodeOrg(it), nil
+}
+
+func (r *DynamoDBRepository) ListOrgs(ctx context.Context, userID uint64) ([]*Org, error) {
+	orgs := []*Org{}
+	err := r.queryIndex(ctx, dynamoListIndex, fmt.Sprintf("orgs#%d", userID), 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		orgs = append(orgs, decodeOrg(it))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+func (r *DynamoDBRepository) ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error) {
+	var members []dynamoItem
+	err := r.queryPartition(ctx, "org#"+strconv.FormatUint(orgID, 10), "member#", func(it dynamoItem) bool {
+		members = append(members, it)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of organization %d: %w", orgID, err)
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].num("joined_at") < members[j].num("joined_at") })
+	users := []*User{}
+	for _, m := range members {
+		it, err := r.get(ctx, userKey(uint64(m.num("user_id"))))
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get member of organization %d: %w", orgID, err)
+		}
+		users = append(users, decodeUser(it))
+	}
+	return users, nil
+}
+
+func (r *DynamoDBRepository) IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error) {
+	_, err := r.get(ctx, orgMemberKey(orgID, userID))
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check membership in organization %d: %w", orgID, err)
+	}
+	return true, nil
+}
+
+func (r *DynamoDBRepository) AddOrgMember(ctx context.Context, orgID, userID uint64) error {
+	o, err := r.GetOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	err = r.transact(ctx,
+		map[string]any{"ConditionCheck": map[string]any{"Key": orgKey(orgID), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": orgMemberItem(o, userID, time.Now()), "ConditionExpression": "attribute_not_exists(pk)"}},
+	)
+	switch {
+	case dynamoCanceled(err, 0):
+		return ErrNotFound
+	case dynamoCanceled(err, 1):
+		return ErrConflict
+	case err != nil:
+		return fmt.Errorf("failed to add member to organization %d: %w", orgID, err)
+	}
+	return nil
+}
+
+// deleteExisting deletes the item with key, returning ErrNotFound if there
+// is none.
+func (r *DynamoDBRepository) deleteExisting(ctx context.Context, key dynamoItem) error {
+	err := r.transact(ctx, map[string]any{"Delete": map[string]any{"Key": key, "ConditionExpression": "attribute_exists(pk)"}})
+	if dynamoCanceled(err, 0) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *DynamoDBRepository) RemoveOrgMember(ctx context.Context, orgID, userID uint64) error {
+	err := r.deleteExisting(ctx, orgMemberKey(orgID, userID))
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to remove member from organization %d: %w", orgID, err)
+	}
+	return err
+}
+
+func (r *DynamoDBRepository) AddInvitation(ctx context.Context, inv *Invitation) error {
+	it := invitationKey(inv.TokenHash)
+	it["org_id"] = dynamoN(int64(inv.OrgID))
+	it["email"] = dynamoS(inv.Email)
+	it["inviter_id"] = dynamoN(int64(inv.InviterID))
+	it["expires_at"] = dynamoN(inv.ExpiresAt.UnixMicro())
+	if err := r.put(ctx, it, ""); err != nil {
+		return fmt.Errorf("failed to create invitation to organization %d: %w", inv.OrgID, err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	it, err := r.get(ctx, invitationKey(tokenHash))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	return &Invitation{
+		OrgID:     uint64(it.num("org_id")),
+		Email:     it.str("email"),
+		TokenHash: tokenHash,
+		InviterID: uint64(it.num("inviter_id")),
+		ExpiresAt: time.UnixMicro(it.num("expires_at")).UTC(),
+	}, nil
+}
+
+func (r *DynamoDBRepository) UseInvitation(ctx context.Context, tokenHash string) error {
+	err := r.deleteExisting(ctx, invitationKey(tokenHash))
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+	return err
+}
+
+func domainKey(id uint64) dynamoItem {
+	return dynamoKey("domain#"+strconv.FormatUint(id, 10), "domain")
+}
+
+// domainHostKey is the key of the item reserving a host for one owner, and
+// verifiedHostKey that of the item holding the ID of the one verified
+// domain with a host.
+func domainHostKey(ownerID uint64, host string) dynamoItem {
+	return dynamoKey(fmt.Sprintf("domain_host#%d#%s", ownerID, host), "domain_host")
+}
+
+func verifiedHostKey(host string) dynamoItem {
+	return dynamoKey("verified_host#"+host, "verified_host")
+}
+
+func (r *DynamoDBRepository) CreateDomain(ctx context.Context, d *Domain) error {
+	id, err := r.nextID(ctx, "domains", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	it := listed(domainKey(id), fmt.Sprintf("domains#%d", d.OwnerID), id)
+	it["owner_id"] = dynamoN(int64(d.OwnerID))
+	it["host"] = dynamoS(d.Host)
+	it["token"] = dynamoS(d.Token)
+	it["created_at"] = dynamoN(now.UnixMicro())
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": domainHostKey(d.OwnerID, d.Host), "ConditionExpression": "attribute_not_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": it}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create domain: %w", err)
+	}
+	d.ID, d.CreatedAt = id, now
+	return nil
+}
+
+func decodeDomain(it dynamoItem) *Domain {
+	return &Domain{
+		ID:         uint64(it.num("id")),
+		OwnerID:    uint64(it.num("owner_id")),
+		Host:       it.str("host"),
+		Token:      it.str("token"),
+		VerifiedAt: it.time("verified_at"),
+		CreatedAt:  time.UnixMicro(it.num("created_at")).UTC(),
+	}
+}
+
+func (r *DynamoDBRepository) GetDomain(ctx context.Context, id uint64) (*Domain, error) {
+	it, err := r.get(ctx, domainKey(id))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %d: %w", id, err)
+	}
+	return decodeDomain(it), nil
+}
+
+func (r *DynamoDBRepository) GetDomainByHost(ctx context.Context, host string) (*Domain, error) {
+	it, err := r.get(ctx, verifiedHostKey(host))
+	if err == ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %s: %w", host, err)
+	}
+	return r.GetDomain(ctx, uint64(it.num("id")))
+}
+
+func (r *DynamoDBRepository) ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error) {
+	domains := []*Domain{}
+	err := r.queryIndex(ctx, dynamoListIndex, fmt.Sprintf("domains#%d", ownerID), 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		domains = append(domains, decodeDomain(it))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	return domains, nil
+}
+
+func (r *DynamoDBRepository) UpdateDomain(ctx context.Context, d *Domain) error {
+	stored, err := r.GetDomain(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+	if d.VerifiedAt == nil {
+		e := newDynamoExpr()
+		if err := r.update(ctx, domainKey(d.ID), e, "REMOVE "+e.name("verified_at"), "attribute_exists("+e.name("pk")+")", nil); err != nil {
+			return err
+		}
+		return r.releaseHost(ctx, stored)
+	}
+
+	// The verified host item admits one domain per host, like the partial
+	// unique index of the SQL backends
+	verified := verifiedHostKey(stored.Host)
+	verified["id"] = dynamoN(int64(d.ID))
+	check := newDynamoExpr()
+	e := newDynamoExpr()
+	err = r.transact(ctx,
+		map[string]any{"Put": check.apply(map[string]any{
+			"Item":                verified,
+			"ConditionExpression": fmt.Sprintf("attribute_not_exists(%s) OR %s = %s", check.name("pk"), check.name("id"), check.value("id", dynamoN(int64(d.ID)))),
+		})},
+		map[string]any{"Update": e.apply(map[string]any{
+			"Key":                 domainKey(d.ID),
+			"UpdateExpression":    "SET " + e.name("verified_at") + " = " + e.value("at", dynamoN(d.VerifiedAt.UnixMicro())),
+			"ConditionExpression": "attribute_exists(" + e.name("pk") + ")",
+		})},
+	)
+	switch {
+	case dynamoCanceled(err, 0):
+		return ErrConflict
+	case dynamoCanceled(err, 1):
+		return ErrNotFound
+	case err != nil:
+		return fmt.Errorf("failed to update domain %d: %w", d.ID, err)
+	}
+	return nil
+}
+
+// releaseHost deletes the verified host item of d if d holds it.
+func (r *DynamoDBRepository) releaseHost(ctx context.Context, d *Domain) error {
+	e := newDynamoExpr()
+	in := e.apply(map[string]any{
+		"TableName":           r.table,
+		"Key":                 verifiedHostKey(d.Host),
+		"ConditionExpression": e.name("id") + " = " + e.value("id", dynamoN(int64(d.ID))),
+	})
+	err := r.db.call(ctx, "DeleteItem", in, nil)
+	if err != nil && !dynamoErrorIs(err, "ConditionalCheckFailedException") {
+		return fmt.Errorf("failed to release host %s: %w", d.Host, err)
+	}
+	return nil
+}
+
+func (r *DynamoDBRepository) DeleteDomain(ctx context.Context, d *Domain) error {
+	stored, err := r.GetDomain(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+	err = r.transact(ctx,
+		map[string]any{"Delete": map[string]any{"Key": domainKey(d.ID), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Delete": map[string]any{"Key": domainHostKey(stored.OwnerID, stored.Host)}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete domain %d: %w", d.ID, err)
+	}
+	if err := r.releaseHost(ctx, stored); err != nil {
+		return err
+	}
+
+	e := newDynamoExpr()
+	filter := e.name("domain") + " = " + e.value("host", dynamoS(stored.Host))
+	return r.detachLinks(ctx, dynamoOwnerIndex, ownerPartition(stored.OwnerID), e, filter, "domain")
+}
+
+func domainRuleKey(id uint64) dynamoItem {
+	return dynamoKey("domain_rule#"+strconv.FormatUint(id, 10), "domain_rule")
+}
+
+// SaveAlias checks the alias reservations and then saves link like Save.
+// A reservation added between the two is not seen.
+func (r *DynamoDBRepository) SaveAlias(ctx context.Context, link *Link) (uint64, error) {
+	reservations, err := r.ListAliasReservations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkReservations(reservations, link); err != nil {
+		return 0, err
+	}
+	return r.Save(ctx, link)
+}
+
+func aliasReservationKey(id uint64) dynamoItem {
+	return dynamoKey("alias_reservation#"+strconv.FormatUint(id, 10), "alias_reservation")
+}
+
+// aliasReservationPatternKey is the key of the item reserving a pattern for
+// one alias reservation of its kind.
+func aliasReservationPatternKey(pattern string, prefix bool) dynamoItem {
+	return dynamoKey("alias_reservation_pattern#"+strconv.FormatBool(prefix)+"#"+pattern, "alias_reservation_pattern")
+}
+
+func (r *DynamoDBRepository) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	id, err := r.nextID(ctx, "alias_reservations", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	it := listed(aliasReservationKey(id), "alias_reservations", id)
+	it["pattern"] = dynamoS(res.Pattern)
+	it["prefix"] = dynamoBool(res.Prefix)
+	if res.OrgID != 0 {
+		it["org_id"] = dynamoN(int64(res.OrgID))
+	}
+	it["created_at"] = dynamoN(now.UnixMicro())
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": aliasReservationPatternKey(res.Pattern, res.Prefix), "ConditionExpression": "attribute_not_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": it}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add alias reservation: %w", err)
+	}
+	res.ID, res.CreatedAt = id, now
+	return nil
+}
+
+func (r *DynamoDBRepository) ListAliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	reservations := []*AliasReservation{}
+	err := r.queryIndex(ctx, dynamoListIndex, "alias_reservations", 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		reservations = append(reservations, &AliasReservation{
+			ID:        uint64(it.num("id")),
+			Pattern:   it.str("pattern"),
+			Prefix:    it.bool("prefix"),
+			OrgID:     uint64(it.num("org_id")),
+			CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alias reservations: %w", err)
+	}
+	return reservations, nil
+}
+
+func (r *DynamoDBRepository) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	it, err := r.get(ctx, aliasReservationKey(id))
+	if err == ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get alias reservation %d: %w", id, err)
+	}
+	err = r.transact(ctx,
+		map[string]any{"Delete": map[string]any{"Key": aliasReservationKey(id), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Delete": map[string]any{"Key": aliasReservationPatternKey(it.str("pattern"), it.bool("prefix"))}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete alias reservation %d: %w", id, err)
+	}
+	return nil
+}
+
+// domainRulePatternKey is the key of the item reserving a pattern for one
+// domain rule.
+func domainRulePatternKey(pattern string) dynamoItem {
+	return dynamoKey("domain_rule_pattern#"+pattern, "domain_rule_pattern")
+}
+
+func (r *DynamoDBRepository) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	id, err := r.nextID(ctx, "domain_rules", 1)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	it := listed(domainRuleKey(id), "domain_rules", id)
+	it["pattern"] = dynamoS(rule.Pattern)
+	it["action"] = dynamoS(string(rule.Action))
+	it["reason"] = dynamoS(rule.Reason)
+	it["created_at"] = dynamoN(now.UnixMicro())
+	err = r.transact(ctx,
+		map[string]any{"Put": map[string]any{"Item": domainRulePatternKey(rule.Pattern), "ConditionExpression": "attribute_not_exists(pk)"}},
+		map[string]any{"Put": map[string]any{"Item": it}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add domain rule: %w", err)
+	}
+	rule.ID, rule.CreatedAt = id, now
+	return nil
+}
+
+func (r *DynamoDBRepository) ListDomainRules(ctx context.Context) ([]*DomainRule, error) {
+	rules := []*DomainRule{}
+	err := r.queryIndex(ctx, dynamoListIndex, "domain_rules", 0, true, newDynamoExpr(), "", func(it dynamoItem) bool {
+		rules = append(rules, &DomainRule{
+			ID:        uint64(it.num("id")),
+			Pattern:   it.str("pattern"),
+			Action:    DomainAction(it.str("action")),
+			Reason:    it.str("reason"),
+			CreatedAt: time.UnixMicro(it.num("created_at")).UTC(),
+		})
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain rules: %w", err)
+	}
+	return rules, nil
+}
+
+func (r *DynamoDBRepository) DeleteDomainRule(ctx context.Context, id uint64) error {
+	it, err := r.get(ctx, domainRuleKey(id))
+	if err == ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get domain rule %d: %w", id, err)
+	}
+	err = r.transact(ctx,
+		map[string]any{"Delete": map[string]any{"Key": domainRuleKey(id), "ConditionExpression": "attribute_exists(pk)"}},
+		map[string]any{"Delete": map[string]any{"Key": domainRulePatternKey(it.str("pattern"))}},
+	)
+	if dynamoCanceled(err, 0) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete domain rule %d: %w", id, err)
+	}
+	return nil
+}
+
+// PurgeExpired deletes every item of the expired links: their reports,
+// variant counts and click rollups share the partition of the link.
+func (r *DynamoDBRepository) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	var expired []*Link
+	err := r.scanLinks(ctx, "", now, now, func(it dynamoItem) error {
+		if t := it.time("expires_at"); t != nil && !t.After(now) {
+			expired = append(expired, &Link{ID: uint64(it.num("id")), Code: it.str("code")})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired urls: %w", err)
+	}
+	for i, link := range expired {
+		if err := r.deletePartition(ctx, linkPK(link.ID), ""); err != nil {
+			return i, fmt.Errorf("failed to purge url %d: %w", link.ID, err)
+		}
+		if link.Code != "" {
+			if err := r.delete(ctx, dynamoKey("code#"+link.Code, "code")); err != nil {
+				return i, fmt.Errorf("failed to purge code of url %d: %w", link.ID, err)
+			}
+		}
+	}
+	return len(expired), nil
+}
+
+// Close is a no-op: requests use pooled HTTP connections.
+func (r *DynamoDBRepository) Close() error {
+	return nil
+}
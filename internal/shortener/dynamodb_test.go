@@ -0,0 +1,155 @@
+package shortener
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigningKey(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20150830", "us-east-1", "iam")
+	if got, want := hex.EncodeToString(key), "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9"; got != want {
+		t.Errorf("signingKey() = %s, want %s", got, want)
+	}
+}
+
+// fakeDynamoDB answers DynamoDB operations with the queued responses of
+// each operation, as status and body, and records the requests.
+type fakeDynamoDB struct {
+	t         *testing.T
+	responses map[string][]string
+	requests  map[string][]map[string]any
+}
+
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/dynamodb/aws4_request") ||
+		!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target") {
+		f.t.Errorf("%s Authorization = %q", op, auth)
+	}
+	var req map[string]any
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		f.t.Errorf("%s request body: %v", op, err)
+	}
+	f.requests[op] = append(f.requests[op], req)
+
+	queue := f.responses[op]
+	if len(queue) == 0 {
+		f.t.Errorf("unexpected %s request: %s", op, body)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f.responses[op] = queue[1:]
+	status, resp, _ := strings.Cut(queue[0], " ")
+	if status != "200" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	io.WriteString(w, resp)
+}
+
+func TestDynamoDBRepository(t *testing.T) {
+	fake := &fakeDynamoDB{t: t, requests: make(map[string][]map[string]any), responses: map[string][]string{
+		"DescribeTable": {
+			`400 {"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"not found"}`,
+			`200 {"Table":{"TableStatus":"ACTIVE","GlobalSecondaryIndexes":[{"IndexStatus":"ACTIVE"},{"IndexStatus":"ACTIVE"}]}}`,
+		},
+		"CreateTable": {`200 {}`},
+		"UpdateItem": {
+			`400 {"__type":"com.amazonaws.dynamodb.v20120810#ThrottlingException","message":"slow down"}`,
+			`200 {"Attributes":{"n":{"N":"7"}}}`,
+			`200 {"Attributes":{"n":{"N":"8"}}}`,
+			`400 {"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"failed"}`,
+		},
+		"TransactWriteItems": {
+			`400 {"__type":"com.amazonaws.dynamodb.v20120810#TransactionCanceledException","message":"canceled","CancellationReasons":[{"Code":"None"},{"Code":"ConditionalCheckFailed"}]}`,
+		},
+		"GetItem": {
+			`200 {"Item":{"pk":{"S":"link#7"},"sk":{"S":"link"},"id":{"N":"7"},"url":{"S":"https://example.com"},"created_at":{"N":"1700000000000000"},"status":{"S":"active"},"custom_alias":{"BOOL":true},"rules":{"S":"[{\"device\":\"ios\",\"url\":\"https://apps.apple.com\"}]"}}}`,
+			`200 {"Item":{"pk":{"S":"link#9"},"sk":{"S":"link"},"id":{"N":"9"},"url":{"S":"https://example.com"},"created_at":{"N":"1700000000000000"},"deleted_at":{"N":"1700000000000001"}}}`,
+			`200 {}`,
+		},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	repo, err := NewDynamoDBRepository(DynamoDBConfig{
+		Table: "links", Region: "eu-west-1", Endpoint: srv.URL,
+		AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token",
+		Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewDynamoDBRepository() unexpected error = %v", err)
+	}
+	if got := fake.requests["CreateTable"][0]["TableName"]; got != "links" {
+		t.Errorf("CreateTable TableName = %v, want links", got)
+	}
+	ctx := context.Background()
+
+	// The counter update is retried after throttling
+	first, err := repo.ReserveIDs(ctx, 3)
+	if err != nil || first != 5 {
+		t.Errorf("ReserveIDs() = %d, %v, want 5", first, err)
+	}
+	// A taken code cancels the transaction on its second action
+	link := &Link{OriginalURL: "https://example.com", Code: "promo"}
+	if _, err := repo.Save(ctx, link); !errors.Is(err, ErrConflict) || link.ID != 0 {
+		t.Errorf("Save() of a taken code = %v with ID %d, want ErrConflict and no ID", err, link.ID)
+	}
+	if got := fake.requests["TransactWriteItems"][0]["TransactItems"].([]any); len(got) != 2 {
+		t.Errorf("Save() transaction has %d actions, want 2", len(got))
+	}
+
+	got, err := repo.Get(ctx, 7)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if got.ID != 7 || got.OriginalURL != "https://example.com" || !got.CustomAlias || len(got.Rules) != 1 || got.CreatedAt.Unix() != 1700000000 {
+		t.Errorf("Get() = %+v", got)
+	}
+	for _, id := range []uint64{9, 10} {
+		if _, err := repo.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%d) of a deleted or missing link error = %v, want ErrNotFound", id, err)
+		}
+	}
+	if err := repo.SetStatus(ctx, got, StatusDisabled); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetStatus() failing its condition error = %v, want ErrNotFound", err)
+	}
+	update := fake.requests["UpdateItem"][3]
+	if update["UpdateExpression"] != "SET #status = :status" || update["ConditionExpression"] != "attribute_exists(#pk) AND attribute_not_exists(#deleted_at)" {
+		t.Errorf("SetStatus() request = %v", update)
+	}
+
+	for op, queue := range fake.responses {
+		if len(queue) > 0 {
+			t.Errorf("%d %s responses left", len(queue), op)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	e := newDynamoExpr()
+	expr := assignments(e, map[string]*dynamoValue{
+		"url":        optional(dynamoS("https://example.com"), true),
+		"expires_at": optional(dynamoN(1), false),
+		"domain":     nil,
+	})
+	if want := "SET #url = :url REMOVE #domain, #expires_at"; expr != want {
+		t.Errorf("assignments() = %q, want %q", expr, want)
+	}
+	if len(e.names) != 3 || len(e.values) != 1 {
+		t.Errorf("assignments() placeholders = %v, %v", e.names, e.values)
+	}
+}
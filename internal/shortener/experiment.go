@@ -0,0 +1,248 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	// MaxVariants caps the destinations of a single experiment.
+	MaxVariants = 10
+	// MaxVariantWeight bounds each weight so that totals cannot overflow.
+	MaxVariantWeight = 1000
+	maxVariantName   = 32
+)
+
+var ErrInvalidExperiment = errors.New("invalid experiment")
+
+// Experiment splits the visitors of a link between weighted destinations
+// (A/B testing). It replaces OriginalURL as the default destination; rules
+// and country targets still take precedence.
+type Experiment struct {
+	Variants []Variant `json:"variants"`
+	// Sticky keeps returning visitors on the variant they were first served.
+	Sticky bool `json:"sticky,omitempty"`
+}
+
+// Variant is one destination of an Experiment, served to Weight out of the
+// sum of all weights of visitors.
+type Variant struct {
+	// Name identifies the variant in statistics and sticky cookies.
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Route is where a visitor is sent.
+type Route struct {
+	URL string
+	// Variant is the name of the experiment variant served, or "" if the URL
+	// did not come from an experiment.
+	Variant string
+	// Sticky reports whether the visitor should be kept on Variant.
+	Sticky bool
+	// Untracked reports that the link opted out of analytics, so the click
+	// must not be passed on to anything that tracks it.
+	Untracked bool
+	// Mask is how URL is served instead of redirecting to it; masks that
+	// are not enabled are left off.
+	Mask Mask
+}
+
+// variant returns the variant called name.
+func (e *Experiment) variant(name string) (Variant, bool) {
+	for _, v := range e.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// pick chooses a variant with probability proportional to its weight, using
+// intN to draw a number in [0, n).
+func (e *Experiment) pick(intN func(int) int) Variant {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	n := intN(total)
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1]
+}
+
+func (e *Experiment) validate() error {
+	if len(e.Variants) < 2 || len(e.Variants) > MaxVariants {
+		return fmt.Errorf("%w: between 2 and %d variants are required", ErrInvalidExperiment, MaxVariants)
+	}
+	seen := make(map[string]struct{}, len(e.Variants))
+	for _, v := range e.Variants {
+		if v.Name == "" || len(v.Name) > maxVariantName {
+			return fmt.Errorf("%w: variant names must be 1 to %d characters", ErrInvalidExperiment, maxVariantName)
+		}
+		for i := 0; i < len(v.Name); i++ {
+			if !isAliasChar(v.Name[i]) {
+				return fmt.Errorf("%w: variant name %q may only use a-z, A-Z, 0-9, '-' and '_'", ErrInvalidExperiment, v.Name)
+			}
+		}
+		if _, dup := seen[v.Name]; dup {
+			return fmt.Errorf("%w: variant %s is listed twice", ErrInvalidExperiment, v.Name)
+		}
+		seen[v.Name] = struct{}{}
+		if v.Weight < 1 || v.Weight > MaxVariantWeight {
+			return fmt.Errorf("%w: weight of %s must be between 1 and %d", ErrInvalidExperiment, v.Name, MaxVariantWeight)
+		}
+		if err := ValidateURL(v.URL); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidExperiment, err)
+		}
+	}
+	return nil
+}
+
+// recordVariant records that route, picked by pickRoute for a redirect of
+// link, served its experiment variant, if it has one.
+func (s *Service) recordVariant(ctx context.Context, link *Link, route Route) {
+	if route.Variant == "" {
+		return
+	}
+	// Statistics are best effort: a failure must not break the redirect
+	if err := s.repo.RecordVariant(ctx, link, route.Variant); err != nil {
+		slog.WarnContext(ctx, "failed to record variant", "id", link.ID, "variant", route.Variant, "error", err)
+	}
+}
+
+// pickRoute returns where v is sent when following link, without recording
+// anything.
+func (s *Service) pickRoute(link *Link, v Visitor) Route {
+	route := s.pickDestination(link, v)
+	route.URL = link.QueryPassthrough.Apply(route.URL, v.Query)
+	if s.MaskEnabled(link.Mask) {
+		route.Mask = link.Mask
+	}
+	return route
+}
+
+// pickDestination returns the route of pickRoute before the query of v is
+// passed on.
+func (s *Service) pickDestination(link *Link, v Visitor) Route {
+	if url, ok := link.override(v); ok {
+		return Route{URL: url}
+	}
+	e := link.Experiment
+	if e == nil || len(e.Variants) == 0 {
+		return Route{URL: link.OriginalURL}
+	}
+
+	variant, ok := Variant{}, false
+	if e.Sticky && v.Variant != "" {
+		variant, ok = e.variant(v.Variant)
+	}
+	if !ok {
+		variant = e.pick(s.intN)
+	}
+	return Route{URL: variant.URL, Variant: variant.Name, Sticky: e.Sticky}
+}
+
+// SetExperiment replaces the experiment of the link behind shortCode, which
+// user must own. A nil experiment or one without variants removes it.
+// Returns ErrInvalidExperiment for malformed experiments and ErrUnsafeURL if
+// a variant URL is reported as malicious.
+func (s *Service) SetExperiment(ctx context.Context, user *User, shortCode string, e *Experiment) (*Link, error) {
+	if e != nil && len(e.Variants) == 0 {
+		e = nil
+	}
+	if e != nil {
+		if err := e.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		for _, v := range e.Variants {
+			if err := s.screen(ctx, v.URL); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.repo.SetExperiment(ctx, link, e); err != nil {
+		return nil, fmt.Errorf("failed to set experiment: %w", err)
+	}
+	link.Experiment = e
+	variants := 0
+	if e != nil {
+		variants = len(e.Variants)
+	}
+	s.audit(ctx, UserActor(user), AuditSetExperiment, link.ShortCode(), fmt.Sprintf("%d variants", variants))
+	return link, nil
+}
+
+// ExperimentStats returns how often each variant of the link behind
+// shortCode, which user must own, has been served.
+func (s *Service) ExperimentStats(ctx context.Context, user *User, shortCode string) (*Link, map[string]int64, error) {
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	served, err := s.repo.VariantStats(ctx, link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get variant stats: %w", err)
+	}
+	return link, served, nil
+}
+
+// marshalExperiment encodes e for the experiment column, storing none as NULL.
+func marshalExperiment(e *Experiment) (string, error) {
+	if e == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// unmarshalExperiment decodes the experiment column.
+func unmarshalExperiment(data []byte) (*Experiment, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var e Experiment
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("failed to decode experiment: %w", err)
+	}
+	return &e, nil
+}
+
+// scanVariantStats reads (variant, served) rows and closes them.
+func scanVariantStats(rows *sql.Rows) (map[string]int64, error) {
+	defer rows.Close()
+
+	served := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var n int64
+		if err := rows.Scan(&name, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan variant stats: %w", err)
+		}
+		served[name] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read variant stats: %w", err)
+	}
+	return served, nil
+}
@@ -0,0 +1,142 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+)
+
+func TestExperiment_Pick(t *testing.T) {
+	e := &Experiment{Variants: []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: "https://example.com/b", Weight: 3},
+	}}
+
+	tests := []struct {
+		draw int
+		want string
+	}{
+		{draw: 0, want: "a"},
+		{draw: 1, want: "b"},
+		{draw: 3, want: "b"},
+	}
+	for _, tt := range tests {
+		got := e.pick(func(n int) int {
+			if n != 4 {
+				t.Errorf("pick() drew from [0, %d), want [0, 4)", n)
+			}
+			return tt.draw
+		})
+		if got.Name != tt.want {
+			t.Errorf("pick() with draw %d = %s, want %s", tt.draw, got.Name, tt.want)
+		}
+	}
+}
+
+func TestService_SetExperimentValidation(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	a := Variant{Name: "a", URL: "https://example.com/a", Weight: 1}
+	tests := []struct {
+		name     string
+		variants []Variant
+	}{
+		{name: "single variant", variants: []Variant{a}},
+		{name: "duplicate name", variants: []Variant{a, a}},
+		{name: "bad name", variants: []Variant{a, {Name: "b c", URL: "https://example.com/b", Weight: 1}}},
+		{name: "zero weight", variants: []Variant{a, {Name: "b", URL: "https://example.com/b"}}},
+		{name: "huge weight", variants: []Variant{a, {Name: "b", URL: "https://example.com/b", Weight: MaxVariantWeight + 1}}},
+		{name: "bad url", variants: []Variant{a, {Name: "b", URL: "javascript:alert(1)", Weight: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.SetExperiment(ctx, user, code, &Experiment{Variants: tt.variants})
+			if !errors.Is(err, ErrInvalidExperiment) {
+				t.Errorf("SetExperiment() error = %v, want ErrInvalidExperiment", err)
+			}
+		})
+	}
+}
+
+func TestService_Experiment(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	link, err := service.SetExperiment(ctx, user, code, &Experiment{
+		Variants: []Variant{
+			{Name: "control", URL: "https://example.com/a", Weight: 1},
+			{Name: "treatment", URL: "https://example.com/b", Weight: 1},
+		},
+		Sticky: true,
+	})
+	if err != nil {
+		t.Fatalf("SetExperiment() unexpected error = %v", err)
+	}
+	if link.Experiment == nil || len(link.Experiment.Variants) != 2 {
+		t.Fatalf("SetExperiment() link experiment = %+v", link.Experiment)
+	}
+
+	draw := 0
+	service.intN = func(n int) int { return draw }
+
+	route, err := service.RedirectVisitor(ctx, code, Visitor{})
+	if err != nil {
+		t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+	}
+	if route != (Route{URL: "https://example.com/a", Variant: "control", Sticky: true}) {
+		t.Errorf("RedirectVisitor() = %+v, want control", route)
+	}
+
+	// A returning visitor stays on their variant whatever the draw
+	draw = 1
+	if route, _ := service.RedirectVisitor(ctx, code, Visitor{Variant: "control"}); route.Variant != "control" {
+		t.Errorf("RedirectVisitor() sticky = %+v, want control", route)
+	}
+	// An unknown sticky variant (e.g. removed since) is drawn again
+	if route, _ := service.RedirectVisitor(ctx, code, Visitor{Variant: "retired"}); route.Variant != "treatment" {
+		t.Errorf("RedirectVisitor() stale sticky = %+v, want treatment", route)
+	}
+
+	// Rules take precedence and are not counted as variants
+	if _, err := service.SetRules(ctx, user, code, []Rule{{Device: DeviceIOS, URL: "https://apps.apple.com/app/id1"}}); err != nil {
+		t.Fatalf("SetRules() unexpected error = %v", err)
+	}
+	if route, _ := service.RedirectVisitor(ctx, code, Visitor{Device: DeviceIOS}); route != (Route{URL: "https://apps.apple.com/app/id1"}) {
+		t.Errorf("RedirectVisitor() iOS = %+v, want app store rule", route)
+	}
+
+	_, served, err := service.ExperimentStats(ctx, user, code)
+	if err != nil {
+		t.Fatalf("ExperimentStats() unexpected error = %v", err)
+	}
+	if want := map[string]int64{"control": 2, "treatment": 1}; !maps.Equal(served, want) {
+		t.Errorf("ExperimentStats() = %v, want %v", served, want)
+	}
+
+	// Removing the experiment restores the original destination
+	if _, err := service.SetExperiment(ctx, user, code, &Experiment{}); err != nil {
+		t.Fatalf("SetExperiment() removal unexpected error = %v", err)
+	}
+	if route, _ := service.RedirectVisitor(ctx, code, Visitor{}); route != (Route{URL: "https://example.com"}) {
+		t.Errorf("RedirectVisitor() after removal = %+v", route)
+	}
+}
@@ -0,0 +1,495 @@
+package shortener
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats of link exports.
+const (
+	// ExportNDJSON writes one JSON-encoded Link per line.
+	ExportNDJSON = "ndjson"
+	// ExportCSV writes a header row and one row per link, with targets,
+	// rules, experiment and page as JSON.
+	ExportCSV = "csv"
+)
+
+// exportPageSize is the number of links read from the repository at a time
+// by ExportLinks.
+const exportPageSize = 500
+
+// ErrInvalidImport is returned for import data that cannot be decoded or
+// does not describe a link.
+var ErrInvalidImport = errors.New("invalid import")
+
+// exportColumns are the CSV columns of an export. short_code, created_at
+// and clicks are informational and ignored by imports.
+var exportColumns = []string{
+	"id", "short_code", "code", "original_url", "created_at", "expires_at", "max_clicks", "clicks",
+	"status", "owner_id", "custom_alias", "campaign_id", "domain", "active_from", "active_until",
+	"analytics_disabled", "org_id", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "targets", "rules", "experiment", "page", "metadata", "access",
+}
+
+// LinkEncoder writes links in an export format.
+type LinkEncoder interface {
+	Encode(link *Link) error
+	// Flush writes buffered links to the underlying writer.
+	Flush() error
+}
+
+// LinkDecoder reads links written by a LinkEncoder of the same format.
+type LinkDecoder interface {
+	// Decode returns the next link, or io.EOF after the last one. Malformed
+	// input is reported as ErrInvalidImport with its line number.
+	Decode() (*Link, error)
+}
+
+// NewLinkEncoder returns an encoder writing links to w in format, ExportNDJSON
+// or ExportCSV.
+func NewLinkEncoder(w io.Writer, format string) (LinkEncoder, error) {
+	switch format {
+	case ExportNDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonEncoder{w: bw, enc: json.NewEncoder(bw)}, nil
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{w: cw}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q: must be %s or %s", format, ExportNDJSON, ExportCSV)
+	}
+}
+
+// NewLinkDecoder returns a decoder reading links in format from r.
+func NewLinkDecoder(r io.Reader, format string) (LinkDecoder, error) {
+	switch format {
+	case ExportNDJSON:
+		sc := bufio.NewScanner(r)
+		// Links with many targets or rules make for long lines
+		sc.Buffer(nil, 1<<20)
+		return &ndjsonDecoder{sc: sc}, nil
+	case ExportCSV:
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		return &csvDecoder{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q: must be %s or %s", format, ExportNDJSON, ExportCSV)
+	}
+}
+
+type ndjsonEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(link *Link) error {
+	return e.enc.Encode(link)
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type ndjsonDecoder struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (d *ndjsonDecoder) Decode() (*Link, error) {
+	for d.sc.Scan() {
+		d.line++
+		if len(d.sc.Bytes()) == 0 {
+			continue
+		}
+		var link Link
+		if err := json.Unmarshal(d.sc.Bytes(), &link); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, d.line, err)
+		}
+		return &link, nil
+	}
+	if err := d.sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, d.line+1, err)
+		}
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(link *Link) error {
+	status := link.Status
+	if status == "" {
+		status = StatusActive
+	}
+	row := []string{
+		strconv.FormatUint(link.ID, 10), link.ShortCode(), link.Code, link.OriginalURL,
+		formatTime(&link.CreatedAt), formatTime(link.ExpiresAt),
+		strconv.FormatInt(link.MaxClicks, 10), strconv.FormatInt(link.Clicks, 10), string(status),
+		strconv.FormatUint(link.OwnerID, 10), strconv.FormatBool(link.CustomAlias),
+		strconv.FormatUint(link.CampaignID, 10), link.Domain,
+		formatTime(link.ActiveFrom), formatTime(link.ActiveUntil), strconv.FormatBool(link.AnalyticsDisabled),
+		strconv.FormatUint(link.OrgID, 10), string(link.QueryPassthrough), string(link.Mask),
+		strconv.FormatBool(link.BurnAfterReading), formatTime(link.ConsumedAt),
+	}
+	for _, v := range []any{link.Targets, link.Rules, link.Experiment, link.Page, link.Metadata, link.Access} {
+		col, err := csvJSON(v)
+		if err != nil {
+			return err
+		}
+		row = append(row, col)
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvJSON encodes v as JSON for a CSV column, or "" if v is empty.
+func csvJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if s := string(data); s != "null" && s != "[]" {
+		return s, nil
+	}
+	return "", nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+	// columns are the indexes of the columns by name, read from the header.
+	columns map[string]int
+}
+
+func (d *csvDecoder) Decode() (*Link, error) {
+	if d.columns == nil {
+		header, err := d.r.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		d.columns = make(map[string]int, len(header))
+		for i, name := range header {
+			d.columns[name] = i
+		}
+		if _, ok := d.columns["original_url"]; !ok {
+			return nil, fmt.Errorf("%w: header has no original_url column", ErrInvalidImport)
+		}
+	}
+
+	row, err := d.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	line, _ := d.r.FieldPos(0)
+	link, err := d.decodeRow(row)
+	if err != nil {
+		return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, line, err)
+	}
+	return link, nil
+}
+
+// decodeRow parses the columns of a row present in the header; absent
+// columns leave their fields unset.
+func (d *csvDecoder) decodeRow(row []string) (*Link, error) {
+	col := func(name string) string {
+		if i, ok := d.columns[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+	link := &Link{
+		Code:             col("code"),
+		OriginalURL:      col("original_url"),
+		Status:           Status(col("status")),
+		Domain:           col("domain"),
+		QueryPassthrough: QueryPassthrough(col("query_passthrough")),
+		Mask:             Mask(col("mask")),
+	}
+	for name, dst := range map[string]*uint64{"id": &link.ID, "owner_id": &link.OwnerID, "campaign_id": &link.CampaignID, "org_id": &link.OrgID} {
+		if v := col(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q: not an ID", name, v)
+			}
+			*dst = n
+		}
+	}
+	if v := col("max_clicks"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("max_clicks %q: not a number", v)
+		}
+		link.MaxClicks = n
+	}
+	for name, dst := range map[string]*bool{"custom_alias": &link.CustomAlias, "analytics_disabled": &link.AnalyticsDisabled, "burn_after_reading": &link.BurnAfterReading} {
+		if v := col(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q: not a boolean", name, v)
+			}
+			*dst = b
+		}
+	}
+	for name, dst := range map[string]**time.Time{"expires_at": &link.ExpiresAt, "active_from": &link.ActiveFrom, "active_until": &link.ActiveUntil, "consumed_at": &link.ConsumedAt} {
+		if v := col(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s %q: not an RFC 3339 timestamp", name, v)
+			}
+			*dst = &t
+		}
+	}
+	for name, dst := range map[string]any{"targets": &link.Targets, "rules": &link.Rules, "experiment": &link.Experiment, "page": &link.Page, "metadata": &link.Metadata, "access": &link.Access} {
+		if v := col(name); v != "" {
+			if err := json.Unmarshal([]byte(v), dst); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return link, nil
+}
+
+// ImportOptions control ImportLinks.
+type ImportOptions struct {
+	// KeepOwners keeps the owner, campaign, custom domain and organization
+	// of imported links, which must then exist in the instance. By default
+	// they are dropped, since exports do not contain users, campaigns,
+	// domains or organizations.
+	KeepOwners bool
+}
+
+// ImportResult counts the links processed by ImportLinks.
+type ImportResult struct {
+	Imported int `json:"imported"`
+	// Skipped links have an ID or code that is already taken, e.g. by an
+	// earlier run of the same import.
+	Skipped int `json:"skipped"`
+}
+
+// ExportLinks calls fn with every link that is not deleted, newest first,
+// including disabled, flagged and expired ones. Links are loaded with Get,
+// so that they carry their targets.
+func (s *Service) ExportLinks(ctx context.Context, fn func(*Link) error) error {
+	var cursor uint64
+	for {
+		links, err := s.repo.List(ctx, cursor, exportPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list urls: %w", err)
+		}
+		for _, l := range links {
+			link, err := s.repo.Get(ctx, l.ID)
+			if errors.Is(err, ErrNotFound) {
+				// Deleted since it was listed
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get url %d: %w", l.ID, err)
+			}
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+		if len(links) < exportPageSize {
+			return nil
+		}
+		cursor = links[len(links)-1].ID
+	}
+}
+
+// ImportLinks stores the links returned by next until it returns io.EOF,
+// keeping their IDs and codes, destinations, limits, activation windows,
+// routing and status. Creation times, click counts and click history are
+// not carried over. Links whose ID or code is taken are skipped, so an
+// interrupted import can be run again.
+//
+// With the sequential and block code strategies the ID sequence is then
+// advanced past the highest imported ID, so that new links do not run into
+// imported ones. Other strategies do not draw generated codes from the
+// sequence in a way an import can restore.
+func (s *Service) ImportLinks(ctx context.Context, next func() (*Link, error), opts ImportOptions) (ImportResult, error) {
+	var (
+		res   ImportResult
+		maxID uint64
+		err   error
+	)
+	for {
+		var link *Link
+		if link, err = next(); err != nil {
+			break
+		}
+		if err = s.importLink(ctx, link, opts); errors.Is(err, ErrConflict) {
+			res.Skipped++
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to import %s: %w", link.ShortCode(), err)
+			break
+		}
+		res.Imported++
+		maxID = max(maxID, link.ID)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	switch s.codes.(type) {
+	case SequentialCodes, *BlockCodes:
+		if seqErr := s.advanceSequence(ctx, maxID); seqErr != nil {
+			err = errors.Join(err, seqErr)
+		}
+	}
+	return res, err
+}
+
+// importLink saves a copy of src and applies the settings Save does not
+// store.
+func (s *Service) importLink(ctx context.Context, src *Link, opts ImportOptions) error {
+	if src.OriginalURL == "" {
+		return fmt.Errorf("%w: link without original_url", ErrInvalidImport)
+	}
+	if src.ID == 0 && src.Code == "" {
+		return fmt.Errorf("%w: link without id or code", ErrInvalidImport)
+	}
+	switch src.Status {
+	case "", StatusActive, StatusDisabled, StatusFlagged, StatusReported:
+	default:
+		return fmt.Errorf("%w: %w %q", ErrInvalidImport, ErrInvalidStatus, src.Status)
+	}
+	if _, err := ParseQueryPassthrough(string(src.QueryPassthrough)); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	if err := s.checkMask(src.Mask); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	if src.Metadata != nil {
+		if err := src.Metadata.normalize(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+		if src.Metadata.empty() {
+			src.Metadata = nil
+		}
+	}
+	if src.Access != nil {
+		if err := src.Access.normalize(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+		if src.Access.empty() {
+			src.Access = nil
+		}
+	}
+
+	link := &Link{
+		ID:          src.ID,
+		Code:        src.Code,
+		OriginalURL: src.OriginalURL,
+		ExpiresAt:   src.ExpiresAt,
+		MaxClicks:   src.MaxClicks,
+		CustomAlias: src.CustomAlias,
+		ActiveFrom:  src.ActiveFrom,
+		ActiveUntil: src.ActiveUntil,
+		// Unlike the owner, the opt-out is always kept: it protects visitors
+		AnalyticsDisabled: src.AnalyticsDisabled,
+		QueryPassthrough:  src.QueryPassthrough,
+		Mask:              src.Mask,
+		BurnAfterReading:  src.BurnAfterReading,
+	}
+	if opts.KeepOwners {
+		link.OwnerID, link.CampaignID, link.Domain, link.OrgID = src.OwnerID, src.CampaignID, src.Domain, src.OrgID
+	}
+	if _, err := s.repo.Save(ctx, link); err != nil {
+		return err
+	}
+	src.ID = link.ID
+
+	if len(src.Targets) > 0 {
+		if err := s.repo.SetTargets(ctx, link, src.Targets); err != nil {
+			return fmt.Errorf("failed to set targets: %w", err)
+		}
+	}
+	if len(src.Rules) > 0 {
+		if err := s.repo.SetRules(ctx, link, src.Rules); err != nil {
+			return fmt.Errorf("failed to set rules: %w", err)
+		}
+	}
+	if src.Experiment != nil {
+		if err := s.repo.SetExperiment(ctx, link, src.Experiment); err != nil {
+			return fmt.Errorf("failed to set experiment: %w", err)
+		}
+	}
+	if src.Page != nil {
+		if err := s.repo.SetPage(ctx, link, src.Page); err != nil {
+			return fmt.Errorf("failed to set page: %w", err)
+		}
+	}
+	if src.Metadata != nil {
+		if err := s.repo.SetMetadata(ctx, link, src.Metadata); err != nil {
+			return fmt.Errorf("failed to set metadata: %w", err)
+		}
+	}
+	if src.Access != nil {
+		if err := s.repo.SetAccess(ctx, link, src.Access); err != nil {
+			return fmt.Errorf("failed to set access rules: %w", err)
+		}
+	}
+	if src.Status != "" && src.Status != StatusActive {
+		if err := s.repo.SetStatus(ctx, link, src.Status); err != nil {
+			return fmt.Errorf("failed to set status: %w", err)
+		}
+	}
+	// Consumed links stay used up
+	if src.IsConsumed() {
+		if _, err := s.repo.Consume(ctx, link, *src.ConsumedAt); err != nil {
+			return fmt.Errorf("failed to consume link: %w", err)
+		}
+	}
+	s.audit(ctx, ActorAdmin, AuditImport, link.ShortCode(), "")
+	return nil
+}
+
+// advanceSequence moves the ID sequence past maxID unless it already is.
+func (s *Service) advanceSequence(ctx context.Context, maxID uint64) error {
+	if maxID == 0 {
+		return nil
+	}
+	next, err := s.repo.NextID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read id sequence: %w", err)
+	}
+	// next itself is used up, so the sequence is past maxID once it reached it
+	if next >= maxID {
+		return nil
+	}
+	if _, err := s.repo.ReserveIDs(ctx, maxID-next); err != nil {
+		return fmt.Errorf("failed to advance id sequence: %w", err)
+	}
+	return nil
+}
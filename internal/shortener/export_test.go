@@ -0,0 +1,118 @@
+package shortener
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := NewInMemoryRepository()
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	links := []*Link{
+		{OriginalURL: "https://example.com/a", ExpiresAt: &expires, MaxClicks: 10, OwnerID: 7, CampaignID: 3},
+		{Code: "promo", OriginalURL: "https://example.com/promo", CustomAlias: true, BurnAfterReading: true},
+		{ID: 40, OriginalURL: "https://example.com/pinned", CustomAlias: true},
+	}
+	for _, l := range links {
+		if _, err := src.Save(ctx, l); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+	src.SetTargets(ctx, links[0], []Target{{Country: "JP", URL: "https://example.jp"}})
+	src.SetRules(ctx, links[1], []Rule{{Device: DeviceIOS, URL: "https://apps.apple.com"}})
+	src.SetExperiment(ctx, links[1], &Experiment{Variants: []Variant{{Name: "a", URL: "https://a.example", Weight: 1}}})
+	src.SetPage(ctx, links[2], &PageInfo{Title: "Pinned, \"quoted\""})
+	src.SetStatus(ctx, links[2], StatusDisabled)
+	src.Consume(ctx, links[1], time.Date(2029, 5, 6, 7, 8, 9, 0, time.UTC))
+
+	for _, format := range []string{ExportNDJSON, ExportCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc, err := NewLinkEncoder(&buf, format)
+			if err != nil {
+				t.Fatalf("NewLinkEncoder() unexpected error = %v", err)
+			}
+			if err := NewService(src).ExportLinks(ctx, enc.Encode); err != nil {
+				t.Fatalf("ExportLinks() unexpected error = %v", err)
+			}
+			if err := enc.Flush(); err != nil {
+				t.Fatalf("Flush() unexpected error = %v", err)
+			}
+			data := buf.Bytes()
+
+			dst := NewInMemoryRepository()
+			service := NewService(dst)
+			importAll := func(opts ImportOptions) (ImportResult, error) {
+				dec, err := NewLinkDecoder(bytes.NewReader(data), format)
+				if err != nil {
+					t.Fatalf("NewLinkDecoder() unexpected error = %v", err)
+				}
+				return service.ImportLinks(ctx, dec.Decode, opts)
+			}
+			if res, err := importAll(ImportOptions{}); err != nil || res != (ImportResult{Imported: 3}) {
+				t.Fatalf("ImportLinks() = %+v, %v, want 3 imported", res, err)
+			}
+			// Running it again changes nothing
+			if res, err := importAll(ImportOptions{KeepOwners: true}); err != nil || res != (ImportResult{Skipped: 3}) {
+				t.Errorf("ImportLinks() again = %+v, %v, want 3 skipped", res, err)
+			}
+
+			for _, want := range links {
+				got, err := dst.Get(ctx, want.ID)
+				if err != nil {
+					t.Fatalf("Get(%d) unexpected error = %v", want.ID, err)
+				}
+				orig, _ := src.Get(ctx, want.ID)
+				orig.CreatedAt, got.CreatedAt = time.Time{}, time.Time{}
+				orig.OwnerID, orig.CampaignID = 0, 0
+				if !reflect.DeepEqual(got, orig) {
+					t.Errorf("imported link = %+v, want %+v", got, orig)
+				}
+			}
+			// New links do not run into imported IDs
+			if code, err := service.Shorten(ctx, "https://example.com/new"); err != nil || code != Encode(41) {
+				t.Errorf("Shorten() after import = %q, %v, want %q", code, err, Encode(41))
+			}
+		})
+	}
+}
+
+func TestImportLinks_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		wantErr string
+	}{
+		{"malformed json", ExportNDJSON, "{\"id\":1,\"original_url\":\"https://example.com\"}\n\n{", "line 3"},
+		{"no destination", ExportNDJSON, `{"id":1}`, "original_url"},
+		{"no id or code", ExportNDJSON, `{"original_url":"https://example.com"}`, "id or code"},
+		{"unknown status", ExportNDJSON, `{"id":1,"original_url":"https://example.com","status":"banned"}`, "invalid status"},
+		{"csv without destination", ExportCSV, "id,code\n1,a\n", "original_url column"},
+		{"csv bad id", ExportCSV, "id,original_url\n1,https://example.com\nx,https://example.com\n", `line 3: id "x"`},
+		{"csv bad expiry", ExportCSV, "id,original_url,expires_at\n1,https://example.com,tomorrow\n", "expires_at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := NewInMemoryRepository()
+			dec, err := NewLinkDecoder(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("NewLinkDecoder() unexpected error = %v", err)
+			}
+			_, err = NewService(repo).ImportLinks(context.Background(), dec.Decode, ImportOptions{})
+			if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ImportLinks() error = %v, want ErrInvalidImport containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewLinkDecoder(strings.NewReader(""), "xml"); err == nil {
+		t.Error("NewLinkDecoder() of an unknown format succeeded, want an error")
+	}
+}
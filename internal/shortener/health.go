@@ -0,0 +1,288 @@
+package shortener
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthStatus is what checking the destination of a link found.
+type HealthStatus string
+
+const (
+	// HealthAlive destinations answered with a success or redirect, or
+	// turned the checker away (401, 403, 429) as sites guarding against
+	// bots do.
+	HealthAlive HealthStatus = "alive"
+	// HealthNotFound destinations answered 404 or 410.
+	HealthNotFound HealthStatus = "not_found"
+	// HealthHTTPError destinations answered with another error status.
+	HealthHTTPError HealthStatus = "http_error"
+	// HealthTimeout destinations did not answer in time.
+	HealthTimeout HealthStatus = "timeout"
+	// HealthSSLError destinations failed the TLS handshake, e.g. with an
+	// expired or mismatched certificate.
+	HealthSSLError HealthStatus = "ssl_error"
+	// HealthUnreachable destinations could not be connected to: the domain
+	// does not resolve, the connection was refused or the address is not
+	// public.
+	HealthUnreachable HealthStatus = "unreachable"
+)
+
+// healthBatchSize is the number of links CheckHealth takes per run.
+const healthBatchSize = 100
+
+// Health is the outcome of the latest health check of a link.
+type Health struct {
+	Status HealthStatus `json:"status"`
+	// StatusCode is the HTTP status the destination answered with, if it
+	// answered.
+	StatusCode int `json:"status_code,omitempty"`
+	// Failures is the number of consecutive checks, up to this one, that
+	// did not find the destination alive.
+	Failures  int       `json:"consecutive_failures"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthCounts counts links by the status of their latest health check.
+type HealthCounts struct {
+	Alive       int64 `json:"alive"`
+	NotFound    int64 `json:"not_found"`
+	HTTPError   int64 `json:"http_error"`
+	Timeout     int64 `json:"timeout"`
+	SSLError    int64 `json:"ssl_error"`
+	Unreachable int64 `json:"unreachable"`
+}
+
+// add counts n more links with status.
+func (c *HealthCounts) add(status HealthStatus, n int64) {
+	switch status {
+	case HealthAlive:
+		c.Alive += n
+	case HealthNotFound:
+		c.NotFound += n
+	case HealthHTTPError:
+		c.HTTPError += n
+	case HealthTimeout:
+		c.Timeout += n
+	case HealthSSLError:
+		c.SSLError += n
+	case HealthUnreachable:
+		c.Unreachable += n
+	}
+}
+
+// HealthProber requests destinations for CheckHealth. Destinations are
+// user-supplied, so implementations must guard against SSRF.
+type HealthProber interface {
+	// Probe requests rawURL, following redirects, and returns the HTTP
+	// status of the final response.
+	Probe(ctx context.Context, rawURL string) (int, error)
+}
+
+// HealthCheckPolicy configures CheckHealth.
+type HealthCheckPolicy struct {
+	// Concurrency is the number of destinations probed at once; less than
+	// one probes one at a time.
+	Concurrency int
+	// DisableAfter is the number of consecutive failed checks that disables
+	// a link; zero never disables links.
+	DisableAfter int
+}
+
+// WithHealthChecks lets CheckHealth probe destinations with p under policy,
+// and adds the outcome of the latest check to listed links.
+func WithHealthChecks(p HealthProber, policy HealthCheckPolicy) Option {
+	return func(s *Service) {
+		s.prober = p
+		s.healthPolicy = policy
+	}
+}
+
+// CheckHealth probes the destinations of the next batch of links that
+// redirect and records whether they are still alive, disabling those that
+// failed HealthCheckPolicy.DisableAfter checks in a row. Like Rescan, each
+// call continues where the previous one stopped and wraps around after the
+// oldest link. It returns the number of links checked.
+func (s *Service) CheckHealth(ctx context.Context) (int, error) {
+	if s.prober == nil {
+		return 0, nil
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	links, err := s.repo.List(ctx, s.healthCursor, healthBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list urls: %w", err)
+	}
+	next := uint64(0)
+	if len(links) == healthBatchSize {
+		next = links[len(links)-1].ID
+	}
+
+	now := s.now()
+	candidates := make([]*Link, 0, len(links))
+	for _, link := range links {
+		// Probing the one-time pages links that burn after reading may lead
+		// to would use them up
+		if link.IsDisabled() || link.IsFlagged() || link.IsReported() || link.IsExpired(now) || link.BurnAfterReading {
+			continue
+		}
+		candidates = append(candidates, link)
+	}
+	if len(candidates) == 0 {
+		s.healthCursor = next
+		return 0, nil
+	}
+	previous, err := s.repo.ListHealth(ctx, candidates)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list health: %w", err)
+	}
+
+	results := s.probeAll(ctx, candidates)
+	if err := ctx.Err(); err != nil {
+		// Probes cut short say nothing about the destinations; keep the
+		// cursor so the batch is checked again
+		return 0, fmt.Errorf("health check interrupted: %w", err)
+	}
+
+	for i, link := range candidates {
+		h := results[i]
+		h.CheckedAt = now
+		if h.Status != HealthAlive {
+			h.Failures = 1
+			if prev, ok := previous[link.ID]; ok {
+				h.Failures += prev.Failures
+			}
+		}
+		if err := s.repo.SetHealth(ctx, link, &h); err != nil {
+			return i, fmt.Errorf("failed to set health of url %d: %w", link.ID, err)
+		}
+		if s.healthPolicy.DisableAfter > 0 && h.Failures >= s.healthPolicy.DisableAfter {
+			if err := s.repo.SetStatus(ctx, link, StatusDisabled); err != nil {
+				return i, fmt.Errorf("failed to disable url %d: %w", link.ID, err)
+			}
+			detail := fmt.Sprintf("destination %s in %d checks", h.Status, h.Failures)
+			slog.WarnContext(ctx, "disabled link with dead destination", "short_code", link.ShortCode(), "health", h.Status, "failures", h.Failures)
+			s.audit(ctx, ActorSystem, AuditDisable, link.ShortCode(), detail)
+		}
+	}
+	s.healthCursor = next
+	return len(candidates), nil
+}
+
+// probeAll probes the destinations of links, HealthCheckPolicy.Concurrency
+// at a time, and returns what each probe found in the order of links.
+func (s *Service) probeAll(ctx context.Context, links []*Link) []Health {
+	results := make([]Health, len(links))
+	sem := make(chan struct{}, max(s.healthPolicy.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, link := range links {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			code, err := s.prober.Probe(ctx, link.OriginalURL)
+			results[i] = Health{Status: classifyProbe(code, err), StatusCode: code}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// classifyProbe returns the health status of a destination that answered
+// code to a probe, or failed with err.
+func classifyProbe(code int, err error) HealthStatus {
+	if err != nil {
+		var netErr net.Error
+		var certErr *tls.CertificateVerificationError
+		var headerErr tls.RecordHeaderError
+		var hostErr x509.HostnameError
+		var authorityErr x509.UnknownAuthorityError
+		var invalidErr x509.CertificateInvalidError
+		switch {
+		case errors.As(err, &certErr), errors.As(err, &headerErr), errors.As(err, &hostErr),
+			errors.As(err, &authorityErr), errors.As(err, &invalidErr):
+			return HealthSSLError
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			return HealthTimeout
+		}
+		return HealthUnreachable
+	}
+	switch {
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return HealthNotFound
+	case code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusTooManyRequests:
+		return HealthAlive
+	case code >= 400:
+		return HealthHTTPError
+	}
+	return HealthAlive
+}
+
+// attachHealth sets the Health of links to the outcome of their latest
+// check when health checks are enabled.
+func (s *Service) attachHealth(ctx context.Context, links []*Link) error {
+	if s.prober == nil || len(links) == 0 {
+		return nil
+	}
+	health, err := s.repo.ListHealth(ctx, links)
+	if err != nil {
+		return fmt.Errorf("failed to list health: %w", err)
+	}
+	for _, link := range links {
+		link.Health = health[link.ID]
+	}
+	return nil
+}
+
+// scanHealth scans and closes rows selected with healthColumns.
+func scanHealth(rows *sql.Rows) (map[uint64]*Health, error) {
+	defer rows.Close()
+	health := make(map[uint64]*Health)
+	for rows.Next() {
+		var id uint64
+		var h Health
+		if err := rows.Scan(&id, &h.Status, &h.StatusCode, &h.Failures, &h.CheckedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan health: %w", err)
+		}
+		health[id] = &h
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read health: %w", err)
+	}
+	return health, nil
+}
+
+// healthColumns are the columns scanned by scanHealth.
+const healthColumns = `url_id, status, status_code, failures, checked_at`
+
+// scanHealthStats scans and closes rows of statuses and counts.
+func scanHealthStats(rows *sql.Rows) (*HealthCounts, error) {
+	defer rows.Close()
+	counts := &HealthCounts{}
+	for rows.Next() {
+		var status HealthStatus
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan health stats: %w", err)
+		}
+		counts.add(status, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read health stats: %w", err)
+	}
+	return counts, nil
+}
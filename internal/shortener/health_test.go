@@ -0,0 +1,181 @@
+package shortener
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// stubProber answers probes of the URLs in codes with their status, and of
+// those in errs with their error. It records the most probes in flight.
+type stubProber struct {
+	codes map[string]int
+	errs  map[string]error
+
+	mu       sync.Mutex
+	inFlight int
+	most     int
+}
+
+func (p *stubProber) Probe(ctx context.Context, rawURL string) (int, error) {
+	p.mu.Lock()
+	p.inFlight++
+	p.most = max(p.most, p.inFlight)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+	}()
+
+	if err, ok := p.errs[rawURL]; ok {
+		return 0, err
+	}
+	return p.codes[rawURL], nil
+}
+
+func TestClassifyProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		err  error
+		want HealthStatus
+	}{
+		{"ok", http.StatusOK, nil, HealthAlive},
+		{"redirect", http.StatusFound, nil, HealthAlive},
+		{"bot protection", http.StatusForbidden, nil, HealthAlive},
+		{"rate limited", http.StatusTooManyRequests, nil, HealthAlive},
+		{"not found", http.StatusNotFound, nil, HealthNotFound},
+		{"gone", http.StatusGone, nil, HealthNotFound},
+		{"server error", http.StatusBadGateway, nil, HealthHTTPError},
+		{"deadline", 0, fmt.Errorf("failed to probe: %w", context.DeadlineExceeded), HealthTimeout},
+		{"dial timeout", 0, &net.OpError{Op: "dial", Err: timeoutError{}}, HealthTimeout},
+		{"expired certificate", 0, fmt.Errorf("failed to probe: %w", x509.CertificateInvalidError{Reason: x509.Expired}), HealthSSLError},
+		{"wrong host", 0, x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}, HealthSSLError},
+		{"refused", 0, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, HealthUnreachable},
+		{"no such host", 0, &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, HealthUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyProbe(tt.code, tt.err); got != tt.want {
+				t.Errorf("classifyProbe(%d, %v) = %q, want %q", tt.code, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestService_CheckHealth(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			prober := &stubProber{
+				codes: map[string]int{"https://example.com/": http.StatusOK, "https://example.com/gone": http.StatusNotFound},
+				errs:  map[string]error{"https://down.example/": &net.OpError{Op: "dial", Err: timeoutError{}}},
+			}
+			svc := NewService(newRepo(t), WithHealthChecks(prober, HealthCheckPolicy{Concurrency: 2, DisableAfter: 2}))
+
+			codes := map[string]string{}
+			for _, url := range []string{"https://example.com/", "https://example.com/gone", "https://down.example/"} {
+				code, err := svc.Shorten(ctx, url)
+				if err != nil {
+					t.Fatalf("Shorten() error = %v", err)
+				}
+				codes[url] = code
+			}
+			disabled, err := svc.Shorten(ctx, "https://disabled.example/")
+			if err != nil {
+				t.Fatalf("Shorten() error = %v", err)
+			}
+			if _, err := svc.SetStatus(ctx, disabled, StatusDisabled); err != nil {
+				t.Fatalf("SetStatus() error = %v", err)
+			}
+
+			if n, err := svc.CheckHealth(ctx); err != nil || n != 3 {
+				t.Fatalf("CheckHealth() = %d, %v, want 3 links checked", n, err)
+			}
+			if prober.most > 2 {
+				t.Errorf("CheckHealth() probed %d destinations at once, want at most 2", prober.most)
+			}
+
+			links, _, err := svc.List(ctx, 0, 10)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			want := map[string]struct {
+				status   HealthStatus
+				code     int
+				failures int
+			}{
+				"https://example.com/":     {HealthAlive, http.StatusOK, 0},
+				"https://example.com/gone": {HealthNotFound, http.StatusNotFound, 1},
+				"https://down.example/":    {HealthTimeout, 0, 1},
+			}
+			for _, link := range links {
+				w, checked := want[link.OriginalURL]
+				if !checked {
+					if link.Health != nil {
+						t.Errorf("List() health of %s = %+v, want none", link.OriginalURL, link.Health)
+					}
+					continue
+				}
+				if h := link.Health; h == nil || h.Status != w.status || h.StatusCode != w.code || h.Failures != w.failures || h.CheckedAt.IsZero() {
+					t.Errorf("List() health of %s = %+v, want %v", link.OriginalURL, h, w)
+				}
+			}
+
+			// The destination comes back before the second check; the others
+			// fail again and reach DisableAfter
+			prober.codes["https://down.example/"] = http.StatusOK
+			delete(prober.errs, "https://down.example/")
+			if _, err := svc.CheckHealth(ctx); err != nil {
+				t.Fatalf("CheckHealth() error = %v", err)
+			}
+			if _, err := svc.Redirect(ctx, codes["https://example.com/gone"]); !errors.Is(err, ErrDisabled) {
+				t.Errorf("Redirect() of a dead link error = %v, want ErrDisabled", err)
+			}
+			if _, err := svc.Redirect(ctx, codes["https://down.example/"]); err != nil {
+				t.Errorf("Redirect() of a revived link error = %v", err)
+			}
+			entries, _, err := svc.ListAudit(ctx, AuditFilter{Actor: ActorSystem}, 0, 10)
+			if err != nil || len(entries) != 1 || entries[0].Action != AuditDisable || entries[0].Code != codes["https://example.com/gone"] {
+				t.Errorf("ListAudit() = %+v, %v, want the dead link disabled", entries, err)
+			}
+
+			stats, err := svc.Stats(ctx)
+			if err != nil {
+				t.Fatalf("Stats() error = %v", err)
+			}
+			if want := (HealthCounts{Alive: 2, NotFound: 1}); stats.Health == nil || *stats.Health != want {
+				t.Errorf("Stats() health = %+v, want %+v", stats.Health, want)
+			}
+		})
+	}
+}
+
+func TestService_CheckHealthDisabled(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	if _, err := svc.Shorten(ctx, "https://example.com/"); err != nil {
+		t.Fatalf("Shorten() error = %v", err)
+	}
+	if n, err := svc.CheckHealth(ctx); err != nil || n != 0 {
+		t.Errorf("CheckHealth() without a prober = %d, %v, want nothing checked", n, err)
+	}
+	stats, err := svc.Stats(ctx)
+	if err != nil || stats.Health != nil {
+		t.Errorf("Stats() health = %+v, %v, want none", stats.Health, err)
+	}
+}
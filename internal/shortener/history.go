@@ -0,0 +1,86 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LinkChange records that Actor changed the destination of a link from
+// OldURL to NewURL. Changes are kept as long as their link.
+type LinkChange struct {
+	ID        uint64    `json:"id"`
+	Actor     string    `json:"actor"`
+	OldURL    string    `json:"old_url"`
+	NewURL    string    `json:"new_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordChange stores the change of the destination of link from oldURL.
+// Failures are logged rather than returned because the change itself has
+// already been made, as with audit entries.
+func (s *Service) recordChange(ctx context.Context, link *Link, actor, oldURL string) {
+	change := &LinkChange{Actor: actor, OldURL: oldURL, NewURL: link.OriginalURL, CreatedAt: s.now()}
+	if err := s.repo.AddLinkChange(ctx, link, change); err != nil {
+		slog.ErrorContext(ctx, "failed to record destination change", "short_code", link.ShortCode(), "error", err)
+	}
+}
+
+// LinkHistory returns a page of the destination changes of the link behind
+// shortCode, which user must own or manage through an organization, newest
+// first, in the same way as List.
+func (s *Service) LinkHistory(ctx context.Context, user *User, shortCode string, cursor uint64, limit int) ([]*LinkChange, uint64, error) {
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, 0, err
+	}
+	changes, next, err := paginate(limit, func(n int) ([]*LinkChange, error) {
+		return s.repo.ListLinkChanges(ctx, link, cursor, n)
+	}, func(c *LinkChange) uint64 { return c.ID })
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list destination changes: %w", err)
+	}
+	return changes, next, nil
+}
+
+// RollbackLink sets the destination of the link behind shortCode back to
+// the one it had before the change with ID changeID, like UpdateLink does
+// and with the same errors. The rollback is recorded as a change of its
+// own. Returns ErrNotFound if the link has no such change.
+func (s *Service) RollbackLink(ctx context.Context, user *User, shortCode string, changeID uint64) (*Link, error) {
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	change, err := s.repo.GetLinkChange(ctx, link, changeID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get destination change: %w", err)
+	}
+	return s.UpdateLink(ctx, user, shortCode, LinkUpdate{URL: change.OldURL})
+}
+
+// scanLinkChanges scans and closes rows selected with linkChangeColumns.
+func scanLinkChanges(rows *sql.Rows) ([]*LinkChange, error) {
+	defer rows.Close()
+	changes := []*LinkChange{}
+	for rows.Next() {
+		var c LinkChange
+		if err := rows.Scan(&c.ID, &c.Actor, &c.OldURL, &c.NewURL, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan destination change: %w", err)
+		}
+		changes = append(changes, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read destination changes: %w", err)
+	}
+	return changes, nil
+}
+
+// linkChangeColumns are the columns scanned by scanLinkChanges.
+const linkChangeColumns = `id, actor, old_url, new_url, created_at`
@@ -0,0 +1,81 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestService_LinkHistory(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	bob, _, err := svc.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/v1", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	// Only changes of the destination are kept
+	maxClicks := int64(10)
+	for _, u := range []LinkUpdate{
+		{URL: "https://example.com/v2"},
+		{MaxClicks: &maxClicks},
+		{URL: "https://example.com/v2"},
+		{URL: "https://example.com/v3"},
+	} {
+		if _, err := svc.UpdateLink(ctx, alice, code, u); err != nil {
+			t.Fatalf("UpdateLink(%+v) error = %v", u, err)
+		}
+	}
+	changes, next, err := svc.LinkHistory(ctx, alice, code, 0, 1)
+	if err != nil {
+		t.Fatalf("LinkHistory() error = %v", err)
+	}
+	if len(changes) != 1 || next == 0 || changes[0].OldURL != "https://example.com/v2" || changes[0].NewURL != "https://example.com/v3" || changes[0].Actor != UserActor(alice) {
+		t.Fatalf("LinkHistory() = %+v, %d, want the latest change and a cursor", changes, next)
+	}
+	older, next, err := svc.LinkHistory(ctx, alice, code, next, 10)
+	if err != nil || len(older) != 1 || next != 0 || older[0].OldURL != "https://example.com/v1" {
+		t.Fatalf("LinkHistory() second page = %+v, %d, %v, want the first change", older, next, err)
+	}
+
+	tests := []struct {
+		name     string
+		user     *User
+		changeID uint64
+		wantURL  string
+		wantErr  error
+	}{
+		{name: "another user", user: bob, changeID: older[0].ID, wantErr: ErrForbidden},
+		{name: "unknown change", user: alice, changeID: 999, wantErr: ErrNotFound},
+		{name: "before the first change", user: alice, changeID: older[0].ID, wantURL: "https://example.com/v1"},
+		{name: "before the latest change", user: alice, changeID: changes[0].ID, wantURL: "https://example.com/v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := svc.RollbackLink(ctx, tt.user, code, tt.changeID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RollbackLink() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && link.OriginalURL != tt.wantURL {
+				t.Errorf("RollbackLink() URL = %q, want %q", link.OriginalURL, tt.wantURL)
+			}
+		})
+	}
+
+	// Rollbacks are changes too
+	changes, _, err = svc.LinkHistory(ctx, alice, code, 0, 10)
+	if err != nil || len(changes) != 4 || changes[0].OldURL != "https://example.com/v1" || changes[0].NewURL != "https://example.com/v2" {
+		t.Errorf("LinkHistory() after rollbacks = %+v, %v", changes, err)
+	}
+	if _, _, err := svc.LinkHistory(ctx, bob, code, 0, 10); !errors.Is(err, ErrForbidden) {
+		t.Errorf("LinkHistory() of another user error = %v, want ErrForbidden", err)
+	}
+}
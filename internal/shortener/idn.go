@@ -0,0 +1,213 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	// ErrInvalidHost is returned for destinations whose host is not a valid
+	// internationalized domain name. It wraps ErrInvalidURL.
+	ErrInvalidHost = fmt.Errorf("%w: invalid domain name", ErrInvalidURL)
+	// ErrHomograph is returned under HomographBlock for destinations whose
+	// domain looks like another one, as phishing domains do.
+	ErrHomograph = errors.New("domain mixes scripts like a homograph")
+)
+
+// HomographPolicy is what happens to links whose destination domain is a
+// likely homograph (see IsHomograph).
+type HomographPolicy string
+
+const (
+	// HomographAllow creates such links like any other.
+	HomographAllow HomographPolicy = ""
+	// HomographFlag creates such links suspended (StatusReported), with a
+	// report queueing them for administrator review.
+	HomographFlag HomographPolicy = "flag"
+	// HomographBlock rejects such links with ErrHomograph.
+	HomographBlock HomographPolicy = "block"
+)
+
+// homographReporter reports the links flagged under HomographFlag.
+const homographReporter = "system"
+
+// WithHomographPolicy sets what happens to links to likely homograph
+// domains. The default is HomographAllow.
+func WithHomographPolicy(p HomographPolicy) Option {
+	return func(s *Service) {
+		s.homographs = p
+	}
+}
+
+// scriptSets are the sets of scripts a domain label may mix, as in the
+// "highly restrictive" level of Unicode TS #39: Latin with the scripts
+// written together in Chinese, Japanese and Korean.
+var scriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// latinLookalikes are the letters of scripts other than Latin that look
+// like Latin ones. Labels written only with them, such as Cyrillic "аре",
+// pass for Latin words.
+var latinLookalikes = map[string]string{
+	"Cyrillic": "аԁеһіјӏорԛѕԝхуьсԍ",
+	"Greek":    "αοιυνκρτχ",
+}
+
+// checkHost validates the host of rawURL if it is an internationalized
+// domain name, given in Unicode or in punycode, and returns rawURL with the
+// host in punycode for storage. homograph is that host if the domain is a
+// likely homograph, or "". Hosts that are not domain names are left alone.
+func checkHost(rawURL string) (checked, homograph string, err error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL, "", nil
+	}
+	host := parsed.Hostname()
+	if net.ParseIP(host) != nil || (isASCII(host) && !strings.Contains(strings.ToLower(host), "xn--")) {
+		return rawURL, "", nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidHost, err)
+	}
+	unicodeHost, err := idna.Lookup.ToUnicode(ascii)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidHost, err)
+	}
+	if port := parsed.Port(); port != "" {
+		parsed.Host = net.JoinHostPort(ascii, port)
+	} else {
+		parsed.Host = ascii
+	}
+	if IsHomograph(unicodeHost) {
+		homograph = ascii
+	}
+	return parsed.String(), homograph, nil
+}
+
+// checkHomograph checks the host of rawURL with checkHost, rejecting likely
+// homographs under HomographBlock.
+func (s *Service) checkHomograph(rawURL string) (checked, homograph string, err error) {
+	checked, homograph, err = checkHost(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	if homograph != "" && s.homographs == HomographBlock {
+		return "", "", fmt.Errorf("%w: %s", ErrHomograph, homograph)
+	}
+	return checked, homograph, nil
+}
+
+// IsHomograph reports whether the domain name host, in Unicode, is likely
+// to be mistaken for another: a label mixes scripts that are not written
+// together (e.g. "pаypal" with a Cyrillic "а"), or is written in another
+// script with letters that all look Latin.
+func IsHomograph(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		scripts := labelScripts(label)
+		if len(scripts) > 1 && !allowedMix(scripts) {
+			return true
+		}
+		if len(scripts) == 1 && looksLatin(label, scripts[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelScripts returns the scripts of the letters of label, leaving out
+// digits, hyphens and marks shared by all scripts.
+func labelScripts(label string) []string {
+	var scripts []string
+	for _, r := range label {
+		script := scriptOf(r)
+		if script != "" && !slices.Contains(scripts, script) {
+			scripts = append(scripts, script)
+		}
+	}
+	return scripts
+}
+
+// scriptOf returns the name of the script of r, or "" for characters
+// common to all scripts.
+func scriptOf(r rune) string {
+	if r < unicode.MaxASCII {
+		if unicode.IsLetter(r) {
+			return "Latin"
+		}
+		return ""
+	}
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// allowedMix reports whether scripts are all in one of scriptSets.
+func allowedMix(scripts []string) bool {
+	for _, set := range scriptSets {
+		allowed := true
+		for _, script := range scripts {
+			if !slices.Contains(set, script) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLatin reports whether every letter of label, written in script, is
+// one of its Latin lookalikes.
+func looksLatin(label, script string) bool {
+	lookalikes, ok := latinLookalikes[script]
+	if !ok {
+		return false
+	}
+	for _, r := range label {
+		if scriptOf(r) != "" && !strings.ContainsRune(lookalikes, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// holdHomograph suspends the link behind shortCode, which points at a
+// likely homograph, and reports it so that an administrator reviews it in
+// the abuse report queue. Failures are logged: the link is already created.
+func (s *Service) holdHomograph(ctx context.Context, shortCode, host string) {
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to flag homograph link", "short_code", shortCode, "error", err)
+		return
+	}
+	reason := "homograph domain " + host
+	if _, err := s.repo.AddReport(ctx, &Report{LinkID: link.ID, Reason: reason, Reporter: homographReporter}); err != nil {
+		slog.ErrorContext(ctx, "failed to report homograph link", "short_code", shortCode, "error", err)
+		return
+	}
+	if err := s.repo.SetStatus(ctx, link, StatusReported); err != nil {
+		slog.ErrorContext(ctx, "failed to suspend homograph link", "short_code", shortCode, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "suspended link to homograph domain", "short_code", shortCode)
+	s.audit(ctx, ActorSystem, AuditSuspend, shortCode, reason)
+}
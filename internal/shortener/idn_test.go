@@ -0,0 +1,115 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestIsHomograph(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+		want bool
+	}{
+		{"ASCII", "paypal.com", false},
+		{"Latin with diacritics", "bücher.example", false},
+		{"Japanese", "日本語.jp", false},
+		{"Latin and Japanese", "abcひらがな.jp", false},
+		{"Cyrillic", "пример.рф", false},
+		{"Cyrillic a in Latin", "pаypal.com", true},
+		{"Greek o in Latin", "gοogle.com", true},
+		{"Cyrillic looking Latin", "аре.com", true},
+		{"Latin and Cyrillic in separate labels", "пример.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsHomograph(tt.host); got != tt.want {
+				t.Errorf("IsHomograph(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		name          string
+		rawURL        string
+		want          string
+		wantHomograph string
+		wantErr       error
+	}{
+		{"ASCII", "https://Example.com/a", "https://Example.com/a", "", nil},
+		{"IP address", "http://192.0.2.1:8080/", "http://192.0.2.1:8080/", "", nil},
+		{"unicode", "https://bücher.example/a", "https://xn--bcher-kva.example/a", "", nil},
+		{"unicode with port", "https://bücher.example:8443/", "https://xn--bcher-kva.example:8443/", "", nil},
+		{"punycode", "https://xn--bcher-kva.example/", "https://xn--bcher-kva.example/", "", nil},
+		{"homograph", "https://pаypal.com/login", "https://xn--pypal-4ve.com/login", "xn--pypal-4ve.com", nil},
+		{"homograph in punycode", "https://xn--pypal-4ve.com/", "https://xn--pypal-4ve.com/", "xn--pypal-4ve.com", nil},
+		{"invalid punycode", "https://xn--a.example/", "", "", ErrInvalidHost},
+		{"invalid character", "https://exa\u200dmple.com/", "", "", ErrInvalidHost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, homograph, err := checkHost(tt.rawURL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkHost(%q) error = %v, want %v", tt.rawURL, err, tt.wantErr)
+			}
+			if got != tt.want || homograph != tt.wantHomograph {
+				t.Errorf("checkHost(%q) = %q, %q, want %q, %q", tt.rawURL, got, homograph, tt.want, tt.wantHomograph)
+			}
+		})
+	}
+
+	if !errors.Is(ErrInvalidHost, ErrInvalidURL) {
+		t.Error("ErrInvalidHost does not wrap ErrInvalidURL")
+	}
+}
+
+func TestService_HomographPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      HomographPolicy
+		url         string
+		wantErr     error
+		wantStatus  Status
+		wantReports int
+	}{
+		{"allow", HomographAllow, "https://pаypal.com/", nil, "", 0},
+		{"flag", HomographFlag, "https://pаypal.com/", nil, StatusReported, 1},
+		{"flag leaves other domains", HomographFlag, "https://bücher.example/", nil, "", 0},
+		{"block", HomographBlock, "https://pаypal.com/", ErrHomograph, "", 0},
+		{"block leaves other domains", HomographBlock, "https://paypal.com/", nil, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc := NewService(NewInMemoryRepository(), WithHomographPolicy(tt.policy))
+			code, err := svc.Shorten(ctx, tt.url)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Shorten(%q) error = %v, want %v", tt.url, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			link, err := svc.Resolve(ctx, code)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if status := link.Status; status != tt.wantStatus && !(tt.wantStatus == "" && status == StatusActive) {
+				t.Errorf("status = %q, want %q", status, tt.wantStatus)
+			}
+			reports, _, err := svc.ListReports(ctx, 0, 10)
+			if err != nil {
+				t.Fatalf("ListReports() error = %v", err)
+			}
+			if len(reports) != tt.wantReports {
+				t.Errorf("ListReports() = %d reports, want %d", len(reports), tt.wantReports)
+			}
+		})
+	}
+}
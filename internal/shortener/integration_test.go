@@ -5,6 +5,8 @@ package shortener_test
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -21,7 +23,7 @@ import (
 
 // setupTestContainers initializes PostgreSQL and Redis test containers
 // Returns: db connection, redis client, cleanup function, error
-func setupTestContainers(t *testing.T) (*sql.DB, *redis.Client, func(), error) {
+func setupTestContainers(t testing.TB) (*sql.DB, *redis.Client, func(), error) {
 	ctx := context.Background()
 
 	// Start PostgreSQL container
@@ -150,7 +152,7 @@ func TestIntegration_ReadThroughCache(t *testing.T) {
 	testURL := "https://github.com/testcontainers"
 
 	// Save URL to get ID
-	id, err := repo.Save(ctx, testURL)
+	id, err := repo.Save(ctx, &shortener.Link{OriginalURL: testURL})
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
@@ -165,17 +167,17 @@ func TestIntegration_ReadThroughCache(t *testing.T) {
 
 	// First Get - Should trigger Cache Miss → DB query → Cache update
 	t.Run("First Get - Cache Miss", func(t *testing.T) {
-		url, err := repo.Get(ctx, id)
+		link, err := repo.Get(ctx, id)
 		if err != nil {
 			t.Fatalf("Get() failed: %v", err)
 		}
 
-		if url != testURL {
-			t.Errorf("Get() returned %s, want %s", url, testURL)
+		if link.OriginalURL != testURL {
+			t.Errorf("Get() returned %s, want %s", link.OriginalURL, testURL)
 		}
 
 		// Verify cache is now populated
-		cachedURL, err := redisClient.Get(ctx, cacheKey).Result()
+		cachedURL, err := cachedOriginalURL(ctx, redisClient, cacheKey)
 		if err != nil {
 			t.Fatalf("Cache should be populated after first Get: %v", err)
 		}
@@ -184,34 +186,35 @@ func TestIntegration_ReadThroughCache(t *testing.T) {
 			t.Errorf("Cached value = %s, want %s", cachedURL, testURL)
 		}
 
-		// Verify TTL is set (should be close to 24 hours)
+		// Verify TTL is set (should be 24 hours ±10% jitter)
 		ttl, err := redisClient.TTL(ctx, cacheKey).Result()
 		if err != nil {
 			t.Fatalf("Failed to get TTL: %v", err)
 		}
 
-		expectedTTL := 24 * time.Hour
+		expectedTTL := shortener.DefaultCacheTTL
+		jitter := time.Duration(float64(expectedTTL) * shortener.DefaultCacheJitter)
 		// Allow 1 minute tolerance for test execution time
-		if ttl < expectedTTL-time.Minute || ttl > expectedTTL {
-			t.Errorf("TTL = %v, want ~%v", ttl, expectedTTL)
+		if ttl < expectedTTL-jitter-time.Minute || ttl > expectedTTL+jitter {
+			t.Errorf("TTL = %v, want %v ±%v", ttl, expectedTTL, jitter)
 		}
 	})
 
 	// Second Get - Should hit cache (no DB query)
 	t.Run("Second Get - Cache Hit", func(t *testing.T) {
-		url, err := repo.Get(ctx, id)
+		link, err := repo.Get(ctx, id)
 		if err != nil {
 			t.Fatalf("Get() failed: %v", err)
 		}
 
-		if url != testURL {
-			t.Errorf("Get() returned %s, want %s", url, testURL)
+		if link.OriginalURL != testURL {
+			t.Errorf("Get() returned %s, want %s", link.OriginalURL, testURL)
 		}
 
 		// Performance validation: Cache hit should be fast
 		// Note: This is a basic validation. In production, use detailed metrics.
 		start := time.Now()
-		url, err = repo.Get(ctx, id)
+		_, err = repo.Get(ctx, id)
 		elapsed := time.Since(start)
 
 		if err != nil {
@@ -259,7 +262,7 @@ func TestIntegration_ConcurrentWrites(t *testing.T) {
 			defer wg.Done()
 
 			url := fmt.Sprintf("https://example.com/concurrent/%d", n)
-			id, err := repo.Save(ctx, url)
+			id, err := repo.Save(ctx, &shortener.Link{OriginalURL: url})
 			if err != nil {
 				errors <- err
 				return
@@ -328,7 +331,7 @@ func TestIntegration_CacheExpiration(t *testing.T) {
 	testURL := "https://example.com/ttl-test"
 
 	// Save URL
-	id, err := repo.Save(ctx, testURL)
+	id, err := repo.Save(ctx, &shortener.Link{OriginalURL: testURL})
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
@@ -364,17 +367,17 @@ func TestIntegration_CacheExpiration(t *testing.T) {
 	}
 
 	// Get should still work (DB fallback)
-	url, err := repo.Get(ctx, id)
+	link, err := repo.Get(ctx, id)
 	if err != nil {
 		t.Fatalf("Get() after expiration failed: %v", err)
 	}
 
-	if url != testURL {
-		t.Errorf("Get() = %s, want %s", url, testURL)
+	if link.OriginalURL != testURL {
+		t.Errorf("Get() = %s, want %s", link.OriginalURL, testURL)
 	}
 
 	// Verify cache is re-populated
-	cachedURL, err := redisClient.Get(ctx, cacheKey).Result()
+	cachedURL, err := cachedOriginalURL(ctx, redisClient, cacheKey)
 	if err != nil {
 		t.Fatalf("Cache should be re-populated: %v", err)
 	}
@@ -383,3 +386,198 @@ func TestIntegration_CacheExpiration(t *testing.T) {
 		t.Errorf("Re-cached value = %s, want %s", cachedURL, testURL)
 	}
 }
+
+// TestIntegration_CustomAliases validates both alias namespaces against a real
+// schema: canonical Base62 aliases pin their decoded ID (so the sequence must
+// skip it), while free-form aliases rely on the unique code index.
+func TestIntegration_CustomAliases(t *testing.T) {
+	db, redisClient, cleanup, err := setupTestContainers(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test containers: %v", err)
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	service := shortener.NewService(shortener.NewPostgresRedisRepository(db, redisClient))
+
+	// Pin ID 3 ("3") ahead of the sequence, then generate codes past it
+	if _, err := db.ExecContext(ctx, `INSERT INTO urls (id, original_url) VALUES (3, 'https://example.com/pinned')`); err != nil {
+		t.Fatalf("Failed to pin ID: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		code, err := service.Shorten(ctx, fmt.Sprintf("https://example.com/%d", i))
+		if err != nil {
+			t.Fatalf("Shorten() failed: %v", err)
+		}
+		if code == "3" {
+			t.Errorf("Shorten() reused pinned code %q", code)
+		}
+	}
+
+	for _, alias := range []string{"promo", "spring-sale"} {
+		code, err := service.ShortenWithOptions(ctx, "https://example.com/"+alias,
+			shortener.ShortenOptions{CustomAlias: alias})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions(%q) failed: %v", alias, err)
+		}
+		if code != alias {
+			t.Errorf("ShortenWithOptions() = %s, want %s", code, alias)
+		}
+
+		_, err = service.ShortenWithOptions(ctx, "https://example.com/other",
+			shortener.ShortenOptions{CustomAlias: alias})
+		if !errors.Is(err, shortener.ErrAliasTaken) {
+			t.Errorf("duplicate alias %q: error = %v, want ErrAliasTaken", alias, err)
+		}
+
+		url, err := service.Redirect(ctx, alias)
+		if err != nil {
+			t.Fatalf("Redirect(%q) failed: %v", alias, err)
+		}
+		if url != "https://example.com/"+alias {
+			t.Errorf("Redirect(%q) = %s", alias, url)
+		}
+	}
+}
+
+// TestIntegration_PurgeExpired validates that the reaper query removes only
+// expired rows and evicts their cache entries.
+func TestIntegration_PurgeExpired(t *testing.T) {
+	db, redisClient, cleanup, err := setupTestContainers(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test containers: %v", err)
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	repo := shortener.NewPostgresRedisRepository(db, redisClient)
+
+	soon := time.Now().Add(2 * time.Second)
+	expiringID, err := repo.Save(ctx, &shortener.Link{OriginalURL: "https://example.com/expiring", ExpiresAt: &soon})
+	if err != nil {
+		t.Fatalf("Failed to save expiring URL: %v", err)
+	}
+	liveID, err := repo.Save(ctx, &shortener.Link{OriginalURL: "https://example.com/live"})
+	if err != nil {
+		t.Fatalf("Failed to save live URL: %v", err)
+	}
+
+	// Populate cache for the expiring link
+	if _, err := repo.Get(ctx, expiringID); err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	n, err := repo.PurgeExpired(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("PurgeExpired() failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("PurgeExpired() = %d, want 1", n)
+	}
+
+	if _, err := repo.Get(ctx, expiringID); !errors.Is(err, shortener.ErrNotFound) {
+		t.Errorf("Get(expired) error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Get(ctx, liveID); err != nil {
+		t.Errorf("Get(live) failed: %v", err)
+	}
+}
+
+// TestIntegration_ClickLimit validates that a click-limited link stops
+// redirecting after max_clicks and that counters are flushed to PostgreSQL.
+func TestIntegration_ClickLimit(t *testing.T) {
+	db, redisClient, cleanup, err := setupTestContainers(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test containers: %v", err)
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	service := shortener.NewService(shortener.NewPostgresRedisRepository(db, redisClient))
+
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/invite",
+		shortener.ShortenOptions{MaxClicks: 2})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() failed: %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := service.Redirect(ctx, code); err != nil {
+			t.Fatalf("Redirect() #%d failed: %v", i, err)
+		}
+	}
+	if _, err := service.Redirect(ctx, code); !errors.Is(err, shortener.ErrClickLimitReached) {
+		t.Errorf("Redirect() over limit error = %v, want ErrClickLimitReached", err)
+	}
+
+	if _, err := service.FlushClicks(ctx); err != nil {
+		t.Fatalf("FlushClicks() failed: %v", err)
+	}
+	id, err := shortener.Decode(code)
+	if err != nil {
+		t.Fatalf("Decode(%q) failed: %v", code, err)
+	}
+	// Rejected attempts are counted too
+	var clicks int64
+	if err := db.QueryRowContext(ctx, `SELECT click_count FROM urls WHERE id = $1`, id).Scan(&clicks); err != nil {
+		t.Fatalf("Failed to read click_count: %v", err)
+	}
+	if clicks != 3 {
+		t.Errorf("click_count = %d, want 3", clicks)
+	}
+}
+
+// cachedOriginalURL reads a cached link entry and returns its destination.
+// BenchmarkIntegration_GetFromDatabase measures cache misses, which query
+// PostgreSQL on every call, with and without prepared statements. Run with
+//
+//	go test -tags=integration -run '^$' -bench GetFromDatabase ./internal/shortener/
+func BenchmarkIntegration_GetFromDatabase(b *testing.B) {
+	db, _, cleanup, err := setupTestContainers(b)
+	if err != nil {
+		b.Fatalf("Failed to setup test containers: %v", err)
+	}
+	defer cleanup()
+
+	ctx := context.Background()
+	id, err := shortener.NewPostgresRedisRepository(db, nil).Save(ctx, &shortener.Link{OriginalURL: "https://example.com/bench"})
+	if err != nil {
+		b.Fatalf("Failed to save URL: %v", err)
+	}
+
+	for _, bm := range []struct {
+		name string
+		opts []shortener.PostgresOption
+	}{
+		{"prepared", nil},
+		{"unprepared", []shortener.PostgresOption{shortener.WithoutPreparedStatements()}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			// Without Redis every Get is a cache miss.
+			repo := shortener.NewPostgresRedisRepository(db, nil, bm.opts...)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := repo.Get(ctx, id); err != nil {
+						b.Errorf("Get() failed: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func cachedOriginalURL(ctx context.Context, client *redis.Client, key string) (string, error) {
+	val, err := client.Get(ctx, key).Bytes()
+	if err != nil {
+		return "", err
+	}
+	var link shortener.Link
+	if err := json.Unmarshal(val, &link); err != nil {
+		return "", err
+	}
+	return link.OriginalURL, nil
+}
@@ -0,0 +1,44 @@
+package shortener
+
+import (
+	"github.com/hszk-dev/url-shortener/internal/jobs"
+)
+
+// Kinds of background jobs run by the service.
+const (
+	// JobFetchPage fetches the destination page of a new or changed link.
+	JobFetchPage = "fetch_page"
+	// JobCheckURLs screens a batch of stored links queued by Rescan.
+	JobCheckURLs = "check_urls"
+)
+
+// checkJobAttempts is how many times a JobCheckURLs job is tried: checker
+// outages are retried for about twenty minutes before the batch is left to
+// the next pass of Rescan.
+const checkJobAttempts = 12
+
+// WithJobStore keeps the background jobs of the service in store instead
+// of in memory, so that they are shared by the instances using it and
+// survive restarts.
+func WithJobStore(store jobs.Store) Option {
+	return func(s *Service) {
+		s.queue = jobs.New(store)
+	}
+}
+
+// Jobs returns the queue of the background jobs of the service, whose
+// workers the caller runs.
+func (s *Service) Jobs() *jobs.Queue {
+	return s.queue
+}
+
+// handleJobs registers the handlers of the jobs the configured
+// dependencies need.
+func (s *Service) handleJobs() {
+	if s.pages != nil {
+		s.queue.Handle(JobFetchPage, s.runPageJob, jobs.Workers(s.pageWorkers))
+	}
+	if s.checker != nil {
+		s.queue.Handle(JobCheckURLs, s.runCheckJob, jobs.MaxAttempts(checkJobAttempts))
+	}
+}
@@ -0,0 +1,153 @@
+package shortener
+
+import (
+	"strings"
+	"time"
+)
+
+// Status is the administrative state of a link.
+type Status string
+
+const (
+	StatusActive Status = "active"
+	// StatusDisabled links are kept but no longer redirect (soft ban).
+	StatusDisabled Status = "disabled"
+	// StatusFlagged links were reported as malicious by a URLChecker after
+	// they were created and no longer redirect.
+	StatusFlagged Status = "flagged"
+	// StatusReported links were suspended automatically, after reaching the
+	// abuse report threshold or for pointing at a homograph domain, and
+	// await administrator review.
+	StatusReported Status = "reported"
+)
+
+// Link is a stored short link together with its per-link attributes.
+// It is also the value cached in Redis (JSON-encoded), so the redirect path
+// can enforce attributes such as expiry without a database round-trip.
+type Link struct {
+	ID uint64 `json:"id"`
+	// Code is the free-form custom alias, if any. ID-derived codes
+	// (generated or pinned aliases) leave it empty; see ShortCode.
+	Code        string     `json:"code,omitempty"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// MaxClicks is the number of redirects after which the link stops
+	// working. Zero means unlimited.
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// Clicks is the persisted click count. It may lag behind the live
+	// counter kept by the repository (see Repository.IncrementClicks).
+	Clicks int64 `json:"clicks,omitempty"`
+	// Status is empty for links cached before statuses existed, which
+	// is equivalent to StatusActive.
+	Status Status `json:"status,omitempty"`
+	// OwnerID is the ID of the user who created the link, or zero for
+	// anonymous links, which nobody can edit or delete.
+	OwnerID uint64 `json:"owner_id,omitempty"`
+	// CustomAlias is set for links created with a user-chosen short code
+	// (whether stored in Code or pinned to its ID), which count against the
+	// owner's alias allowance.
+	CustomAlias bool `json:"custom_alias,omitempty"`
+	// Targets are alternative destinations by visitor country (see
+	// Destination). Only Get and GetByCode are guaranteed to load them.
+	Targets []Target `json:"targets,omitempty"`
+	// Rules route visitors by device and take precedence over Targets.
+	Rules []Rule `json:"rules,omitempty"`
+	// Experiment splits visitors not matched by Rules or Targets between
+	// weighted destinations.
+	Experiment *Experiment `json:"experiment,omitempty"`
+	// ActiveFrom and ActiveUntil bound the window in which the link
+	// redirects. Unlike ExpiresAt, a link past ActiveUntil is kept rather
+	// than purged. Nil means unbounded.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// Page describes the destination page. It is filled in the background
+	// after the link is created, so it is nil at first and whenever the page
+	// could not be fetched.
+	Page *PageInfo `json:"page,omitempty"`
+	// CampaignID is the campaign of the owner the link belongs to, or zero.
+	CampaignID uint64 `json:"campaign_id,omitempty"`
+	// Domain is the verified custom domain of the owner the link is served
+	// on, or "" for links served on any host.
+	Domain string `json:"domain,omitempty"`
+	// AnalyticsDisabled opts the link out of click tracking: its redirects
+	// are counted towards MaxClicks but leave no analytics behind.
+	AnalyticsDisabled bool `json:"analytics_disabled,omitempty"`
+	// OrgID is the organization the link belongs to, or zero. All members
+	// of the organization manage the link like its owner.
+	OrgID uint64 `json:"org_id,omitempty"`
+	// Metadata are the notes of the owner about the link, or nil.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// QueryPassthrough carries the query string of redirect requests over
+	// to the destination.
+	QueryPassthrough QueryPassthrough `json:"query_passthrough,omitempty"`
+	// Mask hides the destination from visitors instead of redirecting them.
+	Mask Mask `json:"mask,omitempty"`
+	// BurnAfterReading makes the link redirect once: the first redirect
+	// consumes it (see Repository.Consume).
+	BurnAfterReading bool `json:"burn_after_reading,omitempty"`
+	// ConsumedAt is when a link that burns after reading was followed, or
+	// nil if it has not been yet.
+	ConsumedAt *time.Time `json:"consumed_at,omitempty"`
+	// Access restricts who the link redirects, or is nil.
+	Access *Access `json:"access,omitempty"`
+	// Health is the outcome of the latest health check of the destination.
+	// It is not stored with the link: the Service fills it in when listing
+	// links with health checks enabled.
+	Health *Health `json:"health,omitempty"`
+}
+
+// ShortCode returns the public short code of the link.
+func (l *Link) ShortCode() string {
+	if l.Code != "" {
+		return l.Code
+	}
+	return Encode(l.ID)
+}
+
+// IsDisabled reports whether the link has been disabled by an administrator.
+func (l *Link) IsDisabled() bool {
+	return l.Status == StatusDisabled
+}
+
+// IsFlagged reports whether the destination was found to be malicious.
+func (l *Link) IsFlagged() bool {
+	return l.Status == StatusFlagged
+}
+
+// IsReported reports whether the link is suspended pending abuse review.
+func (l *Link) IsReported() bool {
+	return l.Status == StatusReported
+}
+
+// HasClickLimit reports whether the link stops working after MaxClicks redirects.
+func (l *Link) HasClickLimit() bool {
+	return l.MaxClicks > 0
+}
+
+// IsConsumed reports whether the link burns after reading and has been
+// followed.
+func (l *Link) IsConsumed() bool {
+	return l.BurnAfterReading && l.ConsumedAt != nil
+}
+
+// IsExpired reports whether the link has an expiry at or before now.
+func (l *Link) IsExpired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// IsPending reports whether the activation window of the link starts after now.
+func (l *Link) IsPending(now time.Time) bool {
+	return l.ActiveFrom != nil && now.Before(*l.ActiveFrom)
+}
+
+// IsEnded reports whether the activation window of the link ended at or before now.
+func (l *Link) IsEnded(now time.Time) bool {
+	return l.ActiveUntil != nil && !now.Before(*l.ActiveUntil)
+}
+
+// ServedOn reports whether the link answers requests for host, a hostname
+// without port. Links bound to a custom domain answer only on that domain.
+func (l *Link) ServedOn(host string) bool {
+	return l.Domain == "" || strings.EqualFold(l.Domain, host)
+}
@@ -0,0 +1,62 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Mask tells how a link hides its destination, keeping the short URL in the
+// address bar of the visitor instead of redirecting. Masking lends itself to
+// phishing, so it is off unless enabled with WithMasking.
+type Mask string
+
+const (
+	// MaskOff redirects visitors to the destination.
+	MaskOff Mask = ""
+	// MaskFrame serves a page that shows the destination in a frame.
+	// Destinations that refuse to be framed show nothing.
+	MaskFrame Mask = "frame"
+	// MaskProxy serves the destination itself, fetched on each visit.
+	MaskProxy Mask = "proxy"
+)
+
+var ErrInvalidMask = errors.New("invalid mask")
+
+// ParseMask returns the mask called name, "" being off. Returns
+// ErrInvalidMask for unknown names.
+func ParseMask(name string) (Mask, error) {
+	switch m := Mask(name); m {
+	case MaskOff, MaskFrame, MaskProxy:
+		return m, nil
+	}
+	return "", fmt.Errorf("%w: %q must be %s or %s", ErrInvalidMask, name, MaskFrame, MaskProxy)
+}
+
+// WithMasking lets links be masked with masks. Links keep masks that are
+// later disabled, but are redirected as if they had none.
+func WithMasking(masks ...Mask) Option {
+	return func(s *Service) {
+		s.masks = masks
+	}
+}
+
+// MaskEnabled reports whether links may be masked with m.
+func (s *Service) MaskEnabled(m Mask) bool {
+	for _, enabled := range s.masks {
+		if m == enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMask returns ErrInvalidMask if links may not be set to m.
+func (s *Service) checkMask(m Mask) error {
+	if _, err := ParseMask(string(m)); err != nil {
+		return err
+	}
+	if m != MaskOff && !s.MaskEnabled(m) {
+		return fmt.Errorf("%w: %s masking is not enabled", ErrInvalidMask, m)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseMask(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Mask
+		wantErr bool
+	}{
+		{name: "", want: MaskOff},
+		{name: "frame", want: MaskFrame},
+		{name: "proxy", want: MaskProxy},
+		{name: "Frame", wantErr: true},
+		{name: "cloak", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMask(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMask) {
+					t.Errorf("ParseMask(%q) error = %v, want ErrInvalidMask", tt.name, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseMask(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Mask(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	svc := NewService(repo, WithMasking(MaskFrame))
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		mask    Mask
+		wantErr error
+	}{
+		{name: "off", mask: MaskOff},
+		{name: "enabled", mask: MaskFrame},
+		{name: "not enabled", mask: MaskProxy, wantErr: ErrInvalidMask},
+		{name: "unknown", mask: "cloak", wantErr: ErrInvalidMask},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Mask: tt.mask})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ShortenWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Owner: alice, Mask: MaskFrame})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	route, err := svc.RedirectVisitor(ctx, code, Visitor{})
+	if err != nil || route.Mask != MaskFrame || route.URL != "https://example.com/" {
+		t.Errorf("RedirectVisitor() = %+v, %v, want the destination framed", route, err)
+	}
+
+	// Links keep masks that are disabled later, but redirect
+	route, err = NewService(repo).Peek(ctx, code, Visitor{})
+	if err != nil || route.Mask != MaskOff {
+		t.Errorf("Peek() without masking = %+v, %v, want no mask", route, err)
+	}
+
+	proxy, off := MaskProxy, MaskOff
+	if _, err := svc.UpdateLink(ctx, alice, code, LinkUpdate{Mask: &proxy}); !errors.Is(err, ErrInvalidMask) {
+		t.Errorf("UpdateLink() error = %v, want ErrInvalidMask", err)
+	}
+	link, err := svc.UpdateLink(ctx, alice, code, LinkUpdate{Mask: &off})
+	if err != nil || link.Mask != MaskOff {
+		t.Fatalf("UpdateLink() = %+v, %v, want no mask", link, err)
+	}
+	if route, err := svc.RedirectVisitor(ctx, code, Visitor{}); err != nil || route.Mask != MaskOff {
+		t.Errorf("RedirectVisitor() = %+v, %v, want no mask", route, err)
+	}
+}
@@ -0,0 +1,1489 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InMemoryRepository keeps links in process memory. Nothing is persisted, so
+// it is intended for local development and demos (`go run .` with
+// STORAGE_BACKEND=memory) rather than production.
+type InMemoryRepository struct {
+	mu     sync.RWMutex
+	byID   map[uint64]*Link
+	byCode map[string]uint64
+	// nextID mimics the PostgreSQL sequence: it only moves forward and is
+	// not affected by pinned IDs.
+	nextID atomic.Uint64
+	// reports holds open abuse reports in insertion (ID) order.
+	reports      []*Report
+	nextReportID uint64
+	// users and apiKeys are keyed by ID and key hash respectively,
+	// identities by identityKey.
+	users        map[uint64]*User
+	apiKeys      map[string]*APIKey
+	identities   map[string]uint64
+	nextUserID   uint64
+	nextAPIKeyID uint64
+	// variants counts served experiment variants by link ID and name.
+	variants map[uint64]map[string]int64
+	// health holds the latest health check of links by ID.
+	health map[uint64]*Health
+	// deleted holds soft-deleted links by ID. Their codes stay in byCode.
+	deleted map[uint64]*Link
+	// audit holds the audit log in insertion (ID) order.
+	audit []*AuditEntry
+	// history holds the destination changes of links by link ID, in
+	// insertion (ID) order.
+	history          map[uint64][]*LinkChange
+	nextLinkChangeID uint64
+	// pendingClicks counts clicks and their sources per hour that have not
+	// been rolled up; rollups holds the rolled up counts by granularity and
+	// sources those by day and source.
+	pendingClicks map[sourceKey]int64
+	rollups       map[string]map[bucketKey]int64
+	sources       map[sourceKey]int64
+	// campaigns are keyed by ID.
+	campaigns      map[uint64]*Campaign
+	nextCampaignID uint64
+	// domains are keyed by ID.
+	domains      map[uint64]*Domain
+	nextDomainID uint64
+	// domainRules holds the domain rules in insertion (ID) order.
+	domainRules      []*DomainRule
+	nextDomainRuleID uint64
+	// aliasReservations holds the alias reservations in insertion (ID) order.
+	aliasReservations      []*AliasReservation
+	nextAliasReservationID uint64
+	// orgs are keyed by ID, orgMembers holds the member IDs of each in the
+	// order they joined and invitations are keyed by token hash.
+	orgs        map[uint64]*Org
+	orgMembers  map[uint64][]uint64
+	invitations map[string]*Invitation
+	nextOrgID   uint64
+}
+
+// bucketKey identifies the clicks of a link in the bucket starting at the
+// Unix time start.
+type bucketKey struct {
+	linkID uint64
+	start  int64
+}
+
+// sourceKey identifies the clicks of a link in a bucket from one value of
+// a source dimension, or all of them if dimension is "".
+type sourceKey struct {
+	bucketKey
+	dimension, value string
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{
+		byID:       make(map[uint64]*Link),
+		byCode:     make(map[string]uint64),
+		users:      make(map[uint64]*User),
+		apiKeys:    make(map[string]*APIKey),
+		identities: make(map[string]uint64),
+		variants:   make(map[uint64]map[string]int64),
+		health:     make(map[uint64]*Health),
+		history:    make(map[uint64][]*LinkChange),
+		deleted:    make(map[uint64]*Link),
+
+		pendingClicks: make(map[sourceKey]int64),
+		rollups: map[string]map[bucketKey]int64{
+			GranularityHour: make(map[bucketKey]int64),
+			GranularityDay:  make(map[bucketKey]int64),
+		},
+		sources:     make(map[sourceKey]int64),
+		campaigns:   make(map[uint64]*Campaign),
+		domains:     make(map[uint64]*Domain),
+		orgs:        make(map[uint64]*Org),
+		orgMembers:  make(map[uint64][]uint64),
+		invitations: make(map[string]*Invitation),
+	}
+}
+
+func (r *InMemoryRepository) Save(ctx context.Context, link *Link) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.save(link)
+}
+
+func (r *InMemoryRepository) SaveAlias(ctx context.Context, link *Link) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := checkReservations(r.aliasReservations, link); err != nil {
+		return 0, err
+	}
+	return r.save(link)
+}
+
+// save stores link; r.mu must be held.
+func (r *InMemoryRepository) save(link *Link) (uint64, error) {
+	if link.Code != "" {
+		if _, taken := r.byCode[link.Code]; taken {
+			return 0, ErrConflict
+		}
+	}
+
+	id := link.ID
+	if id == 0 {
+		// Skip IDs already pinned by custom aliases
+		for {
+			id = r.nextID.Add(1)
+			if !r.taken(id) {
+				break
+			}
+		}
+	} else if r.taken(id) {
+		return 0, ErrConflict
+	}
+
+	link.ID = id
+	link.CreatedAt = time.Now()
+	link.Status = StatusActive
+
+	stored := *link
+	r.byID[id] = &stored
+	if link.Code != "" {
+		r.byCode[link.Code] = id
+	}
+	return id, nil
+}
+
+func (r *InMemoryRepository) NextID(ctx context.Context) (uint64, error) {
+	return r.nextID.Add(1), nil
+}
+
+func (r *InMemoryRepository) ReserveIDs(ctx context.Context, n uint64) (uint64, error) {
+	return r.nextID.Add(n) - n + 1, nil
+}
+
+func (r *InMemoryRepository) Get(ctx context.Context, id uint64) (*Link, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	link, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *link
+	return &copied, nil
+}
+
+func (r *InMemoryRepository) GetByCode(ctx context.Context, code string) (*Link, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	link, ok := r.byID[r.byCode[code]]
+	if !ok || link.Code != code {
+		return nil, ErrNotFound
+	}
+	copied := *link
+	return &copied, nil
+}
+
+// taken reports whether id belongs to a link, deleted or not. Callers must
+// hold r.mu.
+func (r *InMemoryRepository) taken(id uint64) bool {
+	_, live := r.byID[id]
+	_, deleted := r.deleted[id]
+	return live || deleted
+}
+
+// List scans and sorts all IDs on every call, which is fine for the data
+// volumes this repository is meant for.
+func (r *InMemoryRepository) List(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+	return r.filter(cursor, limit, func(*Link) bool { return true }), nil
+}
+
+func (r *InMemoryRepository) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error) {
+	query = strings.ToLower(query)
+	return r.filter(cursor, limit, func(link *Link) bool {
+		return strings.Contains(strings.ToLower(link.OriginalURL), query) || matchesMetadata(link.Metadata, query)
+	}), nil
+}
+
+func (r *InMemoryRepository) ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.filter(cursor, limit, func(link *Link) bool { return link.OwnerID == ownerID }), nil
+}
+
+// filter returns copies of up to limit links below cursor that match keep,
+// newest first.
+func (r *InMemoryRepository) filter(cursor uint64, limit int, keep func(*Link) bool) []*Link {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]uint64, 0, len(r.byID))
+	for id, link := range r.byID {
+		if (cursor == 0 || id < cursor) && keep(link) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	links := make([]*Link, 0, len(ids))
+	for _, id := range ids {
+		copied := *r.byID[id]
+		links = append(links, &copied)
+	}
+	return links
+}
+
+func (r *InMemoryRepository) Update(ctx context.Context, link *Link) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Soft-deleted links are moved to r.deleted, so they are not found here,
+	// like rows with deleted_at set in the SQL backends
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.OriginalURL = link.OriginalURL
+	stored.ExpiresAt = link.ExpiresAt
+	stored.MaxClicks = link.MaxClicks
+	stored.CampaignID = link.CampaignID
+	stored.Domain = link.Domain
+	stored.AnalyticsDisabled = link.AnalyticsDisabled
+	stored.OrgID = link.OrgID
+	stored.QueryPassthrough = link.QueryPassthrough
+	stored.Mask = link.Mask
+	return nil
+}
+
+func (r *InMemoryRepository) SetTargets(ctx context.Context, link *Link, targets []Target) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Targets = slices.Clone(targets)
+	return nil
+}
+
+func (r *InMemoryRepository) SetRules(ctx context.Context, link *Link, rules []Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Rules = slices.Clone(rules)
+	return nil
+}
+
+func (r *InMemoryRepository) SetExperiment(ctx context.Context, link *Link, e *Experiment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if e != nil {
+		copied := *e
+		copied.Variants = slices.Clone(e.Variants)
+		e = &copied
+	}
+	stored.Experiment = e
+	return nil
+}
+
+func (r *InMemoryRepository) SetPage(ctx context.Context, link *Link, page *PageInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if page != nil {
+		copied := *page
+		page = &copied
+	}
+	stored.Page = page
+	return nil
+}
+
+func (r *InMemoryRepository) SetMetadata(ctx context.Context, link *Link, m *Metadata) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if m != nil {
+		copied := *m
+		copied.Attributes = append(json.RawMessage(nil), m.Attributes...)
+		m = &copied
+	}
+	stored.Metadata = m
+	return nil
+}
+
+func (r *InMemoryRepository) SetAccess(ctx context.Context, link *Link, a *Access) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if a != nil {
+		copied := *a
+		copied.Referrers = append([]string(nil), a.Referrers...)
+		copied.Networks = append([]string(nil), a.Networks...)
+		copied.DeniedNetworks = append([]string(nil), a.DeniedNetworks...)
+		copied.Countries = append([]string(nil), a.Countries...)
+		copied.DeniedCountries = append([]string(nil), a.DeniedCountries...)
+		a = &copied
+	}
+	stored.Access = a
+	return nil
+}
+
+func (r *InMemoryRepository) RecordVariant(ctx context.Context, link *Link, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.variants[link.ID] == nil {
+		r.variants[link.ID] = make(map[string]int64)
+	}
+	r.variants[link.ID][name]++
+	return nil
+}
+
+func (r *InMemoryRepository) VariantStats(ctx context.Context, link *Link) (map[string]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	served := make(map[string]int64, len(r.variants[link.ID]))
+	for name, n := range r.variants[link.ID] {
+		served[name] = n
+	}
+	return served, nil
+}
+
+func (r *InMemoryRepository) SetHealth(ctx context.Context, link *Link, h *Health) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *h
+	r.health[link.ID] = &copied
+	return nil
+}
+
+func (r *InMemoryRepository) ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	health := make(map[uint64]*Health, len(links))
+	for _, link := range links {
+		if h, ok := r.health[link.ID]; ok {
+			copied := *h
+			health[link.ID] = &copied
+		}
+	}
+	return health, nil
+}
+
+func (r *InMemoryRepository) HealthStats(ctx context.Context) (*HealthCounts, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := &HealthCounts{}
+	for id, h := range r.health {
+		if _, ok := r.byID[id]; ok {
+			counts.add(h.Status, 1)
+		}
+	}
+	return counts, nil
+}
+
+func (r *InMemoryRepository) Delete(ctx context.Context, link *Link) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.byID, link.ID)
+	r.deleted[link.ID] = stored
+	return nil
+}
+
+func (r *InMemoryRepository) Restore(ctx context.Context, link *Link, ownerID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := link.ID
+	if id == 0 {
+		id = r.byCode[link.Code]
+	}
+	stored, ok := r.deleted[id]
+	if !ok || stored.OwnerID != ownerID || (link.ID == 0 && stored.Code != link.Code) {
+		return ErrNotFound
+	}
+	delete(r.deleted, id)
+	r.byID[id] = stored
+	return nil
+}
+
+// remove deletes link, its reports and variant statistics, whether or not it
+// was soft-deleted. Callers must hold r.mu.
+func (r *InMemoryRepository) remove(link *Link) {
+	delete(r.byID, link.ID)
+	delete(r.deleted, link.ID)
+	delete(r.variants, link.ID)
+	delete(r.health, link.ID)
+	delete(r.history, link.ID)
+	for _, counts := range r.rollups {
+		for k := range counts {
+			if k.linkID == link.ID {
+				delete(counts, k)
+			}
+		}
+	}
+	for k := range r.sources {
+		if k.linkID == link.ID {
+			delete(r.sources, k)
+		}
+	}
+	if link.Code != "" {
+		delete(r.byCode, link.Code)
+	}
+	r.reports = slices.DeleteFunc(r.reports, func(report *Report) bool {
+		return report.LinkID == link.ID
+	})
+}
+
+func (r *InMemoryRepository) SetStatus(ctx context.Context, link *Link, status Status) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = status
+	link.Status = status
+	return nil
+}
+
+// Invalidate is a no-op: lookups always read the map.
+func (r *InMemoryRepository) Invalidate(ctx context.Context, link *Link) error {
+	return nil
+}
+
+func (r *InMemoryRepository) Stats(ctx context.Context, now time.Time) (*Stats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var st Stats
+	for _, link := range r.byID {
+		st.Total++
+		st.Clicks += link.Clicks
+		expired := link.IsExpired(now)
+		switch {
+		case link.IsDisabled():
+			st.Disabled++
+		case link.IsFlagged():
+			st.Flagged++
+		case link.IsReported():
+			st.Reported++
+		case !expired:
+			st.Active++
+		}
+		if expired {
+			st.Expired++
+		}
+	}
+	return &st, nil
+}
+
+func (r *InMemoryRepository) AddReport(ctx context.Context, report *Report) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.byID[report.LinkID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	var open int64
+	duplicate := false
+	for _, existing := range r.reports {
+		if existing.LinkID == report.LinkID {
+			open++
+			duplicate = duplicate || existing.Reporter == report.Reporter
+		}
+	}
+	if duplicate {
+		return open, nil
+	}
+
+	r.nextReportID++
+	stored := *report
+	stored.ID = r.nextReportID
+	stored.LinkCode = link.Code
+	stored.CreatedAt = time.Now()
+	r.reports = append(r.reports, &stored)
+	return open + 1, nil
+}
+
+func (r *InMemoryRepository) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reports := make([]*Report, 0, limit)
+	for i := len(r.reports) - 1; i >= 0 && len(reports) < limit; i-- {
+		if cursor == 0 || r.reports[i].ID < cursor {
+			copied := *r.reports[i]
+			reports = append(reports, &copied)
+		}
+	}
+	return reports, nil
+}
+
+// ResolveReports drops the link's reports: only open reports are kept in memory.
+func (r *InMemoryRepository) ResolveReports(ctx context.Context, link *Link, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reports = slices.DeleteFunc(r.reports, func(report *Report) bool {
+		return report.LinkID == link.ID
+	})
+	return nil
+}
+
+func (r *InMemoryRepository) AddAudit(ctx context.Context, entry *AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *entry
+	stored.ID = uint64(len(r.audit)) + 1
+	r.audit = append(r.audit, &stored)
+	entry.ID = stored.ID
+	return nil
+}
+
+func (r *InMemoryRepository) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]*AuditEntry, 0, limit)
+	for i := len(r.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := r.audit[i]
+		if (cursor != 0 && e.ID >= cursor) ||
+			(f.Actor != "" && e.Actor != f.Actor) ||
+			(f.Code != "" && e.Code != f.Code) ||
+			(!f.Since.IsZero() && e.CreatedAt.Before(f.Since)) ||
+			(!f.Until.IsZero() && !e.CreatedAt.Before(f.Until)) {
+			continue
+		}
+		copied := *e
+		entries = append(entries, &copied)
+	}
+	return entries, nil
+}
+
+func (r *InMemoryRepository) AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byID[link.ID]; !ok {
+		return ErrNotFound
+	}
+	r.nextLinkChangeID++
+	change.ID = r.nextLinkChangeID
+	stored := *change
+	r.history[link.ID] = append(r.history[link.ID], &stored)
+	return nil
+}
+
+func (r *InMemoryRepository) ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := r.history[link.ID]
+	changes := make([]*LinkChange, 0, min(limit, len(history)))
+	for i := len(history) - 1; i >= 0 && len(changes) < limit; i-- {
+		if cursor != 0 && history[i].ID >= cursor {
+			continue
+		}
+		copied := *history[i]
+		changes = append(changes, &copied)
+	}
+	return changes, nil
+}
+
+func (r *InMemoryRepository) GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.history[link.ID] {
+		if c.ID == id {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryRepository) CreateUser(ctx context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return ErrConflict
+		}
+	}
+	r.nextUserID++
+	user.ID = r.nextUserID
+	user.CreatedAt = time.Now()
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *InMemoryRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[key.UserID]; !ok {
+		return ErrNotFound
+	}
+	r.nextAPIKeyID++
+	key.ID, key.CreatedAt = r.nextAPIKeyID, time.Now().UTC()
+	stored := *key
+	r.apiKeys[key.KeyHash] = &stored
+	return nil
+}
+
+// copyAPIKey returns a copy of key that shares nothing mutable with it.
+func copyAPIKey(key *APIKey) *APIKey {
+	copied := *key
+	copied.Scopes = slices.Clone(key.Scopes)
+	return &copied
+}
+
+func (r *InMemoryRepository) GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.apiKeys[keyHash]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	copied := *r.users[key.UserID]
+	return &copied, copyAPIKey(key), nil
+}
+
+func (r *InMemoryRepository) ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []*APIKey{}
+	for _, key := range r.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (r *InMemoryRepository) TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key, ok := r.apiKeys[keyHash]; ok {
+		key.LastUsedAt = &at
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.apiKeys[keyHash]
+	if !ok {
+		return ErrNotFound
+	}
+	key.ExpiresAt = &at
+	return nil
+}
+
+func (r *InMemoryRepository) DeleteAPIKey(ctx context.Context, keyHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.apiKeys, keyHash)
+	return nil
+}
+
+// identityKey returns the key of the subject of issuer in identities.
+func identityKey(issuer, subject string) string {
+	return issuer + "\x00" + subject
+}
+
+func (r *InMemoryRepository) CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[userID]; !ok {
+		return ErrNotFound
+	}
+	key := identityKey(issuer, subject)
+	if _, ok := r.identities[key]; ok {
+		return ErrConflict
+	}
+	r.identities[key] = userID
+	return nil
+}
+
+func (r *InMemoryRepository) LookupIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	userID, ok := r.identities[identityKey(issuer, subject)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *r.users[userID]
+	return &copied, nil
+}
+
+func (r *InMemoryRepository) SetUserPlan(ctx context.Context, userID uint64, plan string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Plan = plan
+	return nil
+}
+
+func (r *InMemoryRepository) SetUserRole(ctx context.Context, userID uint64, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Role = role
+	return nil
+}
+
+func (r *InMemoryRepository) UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var u Usage
+	for _, link := range r.all() {
+		if link.OwnerID != ownerID {
+			continue
+		}
+		if link.CreatedAt.After(since) {
+			u.Recent++
+		}
+		if _, deleted := r.deleted[link.ID]; deleted {
+			continue
+		}
+		u.Total++
+		if link.CustomAlias {
+			u.CustomAliases++
+		}
+	}
+	return &u, nil
+}
+
+func (r *InMemoryRepository) IncrementClicks(ctx context.Context, link *Link) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	stored.Clicks++
+	return stored.Clicks, nil
+}
+
+func (r *InMemoryRepository) Consume(ctx context.Context, link *Link, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.byID[link.ID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if stored.ConsumedAt != nil {
+		return false, nil
+	}
+	stored.ConsumedAt = &at
+	link.ConsumedAt = &at
+	return true, nil
+}
+
+// FlushClicks is a no-op: counts are updated in place.
+func (r *InMemoryRepository) FlushClicks(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (r *InMemoryRepository) RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range clickCounts(link.ID, at, src) {
+		r.pendingClicks[sourceKey{bucketKey{c.linkID, truncateBucket(c.start, GranularityHour).Unix()}, c.dimension, c.value}] += c.clicks
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) RollupClicks(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clicks := make([]clickCount, 0, len(r.pendingClicks))
+	for k, n := range r.pendingClicks {
+		if r.taken(k.linkID) {
+			clicks = append(clicks, clickCount{linkID: k.linkID, start: time.Unix(k.start, 0), dimension: k.dimension, value: k.value, clicks: n})
+		}
+	}
+	total := 0
+	for rollup, counts := range rollupCounts(clicks) {
+		for _, c := range counts {
+			key := bucketKey{c.linkID, c.start.Unix()}
+			if rollup == clickSourcesTable {
+				r.sources[sourceKey{key, c.dimension, c.value}] += c.clicks
+				continue
+			}
+			r.rollups[rollup][key] += c.clicks
+			if rollup == GranularityHour {
+				total += int(c.clicks)
+			}
+		}
+	}
+	clear(r.pendingClicks)
+	return total, nil
+}
+
+func (r *InMemoryRepository) ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var buckets []ClickBucket
+	for k, n := range r.rollups[granularity] {
+		start := time.Unix(k.start, 0).UTC()
+		if k.linkID == link.ID && !start.Before(from) && start.Before(to) {
+			buckets = append(buckets, ClickBucket{Start: start, Clicks: n})
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+func (r *InMemoryRepository) ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	totals := make(map[[2]string]int64)
+	for k, n := range r.sources {
+		start := time.Unix(k.start, 0)
+		if k.linkID == link.ID && !start.Before(from) && start.Before(to) {
+			totals[[2]string{k.dimension, k.value}] += n
+		}
+	}
+	counts := make([]SourceCount, 0, len(totals))
+	for k, n := range totals {
+		counts = append(counts, SourceCount{Dimension: k[0], Value: k[1], Clicks: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Dimension != b.Dimension {
+			return a.Dimension < b.Dimension
+		}
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.Value < b.Value
+	})
+	return counts, nil
+}
+
+func (r *InMemoryRepository) PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for k := range r.rollups[granularity] {
+		if k.start < before.Unix() {
+			delete(r.rollups[granularity], k)
+			purged++
+		}
+	}
+	if granularity == GranularityDay {
+		for k := range r.sources {
+			if k.start < before.Unix() {
+				delete(r.sources, k)
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
+
+func (r *InMemoryRepository) DeleteClicks(ctx context.Context, link *Link) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for _, counts := range r.rollups {
+		for k := range counts {
+			if k.linkID == link.ID {
+				delete(counts, k)
+				deleted++
+			}
+		}
+	}
+	for k := range r.sources {
+		if k.linkID == link.ID {
+			delete(r.sources, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *InMemoryRepository) TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	st := TopStats{Top: []TopLink{}}
+	clicks := make(map[uint64]int64)
+	for k, n := range r.rollups[granularity] {
+		start := time.Unix(k.start, 0)
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+		st.Redirects += n
+		if _, ok := r.byID[k.linkID]; ok {
+			clicks[k.linkID] += n
+		}
+	}
+	for _, link := range r.all() {
+		if !link.CreatedAt.Before(from) && link.CreatedAt.Before(to) {
+			st.LinksCreated++
+		}
+	}
+
+	ids := make([]uint64, 0, len(clicks))
+	for id := range clicks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if clicks[ids[i]] != clicks[ids[j]] {
+			return clicks[ids[i]] > clicks[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids[:min(limit, len(ids))] {
+		st.Top = append(st.Top, TopLink{Code: r.byID[id].ShortCode(), Clicks: clicks[id]})
+	}
+	return &st, nil
+}
+
+func (r *InMemoryRepository) CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	st := CampaignStats{Top: []TopLink{}}
+	inCampaign := func(id uint64) bool {
+		link, ok := r.byID[id]
+		if !ok {
+			link, ok = r.deleted[id]
+		}
+		return ok && link.CampaignID == campaignID
+	}
+	clicks := make(map[uint64]int64)
+	for k, n := range r.rollups[granularity] {
+		start := time.Unix(k.start, 0)
+		if start.Before(from) || !start.Before(to) || !inCampaign(k.linkID) {
+			continue
+		}
+		st.Clicks += n
+		if _, ok := r.byID[k.linkID]; ok {
+			clicks[k.linkID] += n
+		}
+	}
+	for _, link := range r.byID {
+		if link.CampaignID == campaignID {
+			st.Links++
+		}
+	}
+
+	ids := make([]uint64, 0, len(clicks))
+	for id := range clicks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if clicks[ids[i]] != clicks[ids[j]] {
+			return clicks[ids[i]] > clicks[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids[:min(limit, len(ids))] {
+		st.Top = append(st.Top, TopLink{Code: r.byID[id].ShortCode(), Clicks: clicks[id]})
+	}
+	return &st, nil
+}
+
+func (r *InMemoryRepository) CreateCampaign(ctx context.Context, c *Campaign) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.campaigns {
+		if existing.OwnerID == c.OwnerID && existing.Name == c.Name {
+			return ErrConflict
+		}
+	}
+	r.nextCampaignID++
+	c.ID = r.nextCampaignID
+	c.CreatedAt = time.Now()
+	stored := *c
+	r.campaigns[c.ID] = &stored
+	return nil
+}
+
+func (r *InMemoryRepository) GetCampaign(ctx context.Context, id uint64) (*Campaign, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.campaigns[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r *InMemoryRepository) ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	campaigns := []*Campaign{}
+	for _, c := range r.campaigns {
+		if c.OwnerID == ownerID {
+			copied := *c
+			campaigns = append(campaigns, &copied)
+		}
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].ID < campaigns[j].ID })
+	return campaigns, nil
+}
+
+func (r *InMemoryRepository) UpdateCampaign(ctx context.Context, c *Campaign) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.campaigns[c.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, existing := range r.campaigns {
+		if existing.ID != c.ID && existing.OwnerID == stored.OwnerID && existing.Name == c.Name {
+			return ErrConflict
+		}
+	}
+	stored.Name = c.Name
+	return nil
+}
+
+func (r *InMemoryRepository) DeleteCampaign(ctx context.Context, c *Campaign) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.campaigns[c.ID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.campaigns, c.ID)
+	for _, links := range []map[uint64]*Link{r.byID, r.deleted} {
+		for _, link := range links {
+			if link.CampaignID == c.ID {
+				link.CampaignID = 0
+			}
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.filter(cursor, limit, func(link *Link) bool { return link.CampaignID == campaignID }), nil
+}
+
+func (r *InMemoryRepository) CreateOrg(ctx context.Context, o *Org, creatorID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextOrgID++
+	o.ID = r.nextOrgID
+	o.CreatedAt = time.Now()
+	stored := *o
+	r.orgs[o.ID] = &stored
+	r.orgMembers[o.ID] = []uint64{creatorID}
+	return nil
+}
+
+func (r *InMemoryRepository) GetOrg(ctx context.Context, id uint64) (*Org, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	o, ok := r.orgs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *o
+	return &copied, nil
+}
+
+func (r *InMemoryRepository) ListOrgs(ctx context.Context, userID uint64) ([]*Org, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orgs := []*Org{}
+	for id, members := range r.orgMembers {
+		if slices.Contains(members, userID) {
+			copied := *r.orgs[id]
+			orgs = append(orgs, &copied)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].ID < orgs[j].ID })
+	return orgs, nil
+}
+
+func (r *InMemoryRepository) ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := []*User{}
+	for _, id := range r.orgMembers[orgID] {
+		if user, ok := r.users[id]; ok {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (r *InMemoryRepository) IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Contains(r.orgMembers[orgID], userID), nil
+}
+
+func (r *InMemoryRepository) AddOrgMember(ctx context.Context, orgID, userID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.orgs[orgID]; !ok {
+		return ErrNotFound
+	}
+	if slices.Contains(r.orgMembers[orgID], userID) {
+		return ErrConflict
+	}
+	r.orgMembers[orgID] = append(r.orgMembers[orgID], userID)
+	return nil
+}
+
+func (r *InMemoryRepository) RemoveOrgMember(ctx context.Context, orgID, userID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members := r.orgMembers[orgID]
+	i := slices.Index(members, userID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.orgMembers[orgID] = slices.Delete(members, i, i+1)
+	return nil
+}
+
+func (r *InMemoryRepository) AddInvitation(ctx context.Context, inv *Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *inv
+	r.invitations[inv.TokenHash] = &stored
+	return nil
+}
+
+func (r *InMemoryRepository) GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	inv, ok := r.invitations[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *inv
+	return &copied, nil
+}
+
+func (r *InMemoryRepository) UseInvitation(ctx context.Context, tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.invitations[tokenHash]; !ok {
+		return ErrNotFound
+	}
+	delete(r.invitations, tokenHash)
+	return nil
+}
+
+func (r *InMemoryRepository) ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.filter(cursor, limit, func(link *Link) bool { return link.OrgID == orgID }), nil
+}
+
+func (r *InMemoryRepository) CreateDomain(ctx context.Context, d *Domain) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.domains {
+		if existing.OwnerID == d.OwnerID && existing.Host == d.Host {
+			return ErrConflict
+		}
+	}
+	r.nextDomainID++
+	d.ID = r.nextDomainID
+	d.CreatedAt = time.Now()
+	stored := *d
+	r.domains[d.ID] = &stored
+	return nil
+}
+
+func (r *InMemoryRepository) GetDomain(ctx context.Context, id uint64) (*Domain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.domains[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *d
+	return &copied, nil
+}
+
+func (r *InMemoryRepository) GetDomainByHost(ctx context.Context, host string) (*Domain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, d := range r.domains {
+		if d.Host == host && d.VerifiedAt != nil {
+			copied := *d
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryRepository) ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	domains := []*Domain{}
+	for _, d := range r.domains {
+		if d.OwnerID == ownerID {
+			copied := *d
+			domains = append(domains, &copied)
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].ID < domains[j].ID })
+	return domains, nil
+}
+
+func (r *InMemoryRepository) UpdateDomain(ctx context.Context, d *Domain) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.domains[d.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if d.VerifiedAt != nil {
+		for _, existing := range r.domains {
+			if existing.ID != d.ID && existing.Host == stored.Host && existing.VerifiedAt != nil {
+				return ErrConflict
+			}
+		}
+	}
+	stored.VerifiedAt = d.VerifiedAt
+	return nil
+}
+
+func (r *InMemoryRepository) DeleteDomain(ctx context.Context, d *Domain) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.domains[d.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.domains, d.ID)
+	for _, links := range []map[uint64]*Link{r.byID, r.deleted} {
+		for _, link := range links {
+			if link.OwnerID == stored.OwnerID && link.Domain == stored.Host {
+				link.Domain = ""
+			}
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.domainRules {
+		if existing.Pattern == rule.Pattern {
+			return ErrConflict
+		}
+	}
+	r.nextDomainRuleID++
+	rule.ID = r.nextDomainRuleID
+	rule.CreatedAt = time.Now()
+	stored := *rule
+	r.domainRules = append(r.domainRules, &stored)
+	return nil
+}
+
+func (r *InMemoryRepository) ListDomainRules(ctx context.Context) ([]*DomainRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rules := make([]*DomainRule, 0, len(r.domainRules))
+	for _, rule := range r.domainRules {
+		copied := *rule
+		rules = append(rules, &copied)
+	}
+	return rules, nil
+}
+
+func (r *InMemoryRepository) DeleteDomainRule(ctx context.Context, id uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rule := range r.domainRules {
+		if rule.ID == id {
+			r.domainRules = slices.Delete(r.domainRules, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *InMemoryRepository) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.aliasReservations {
+		if existing.Pattern == res.Pattern && existing.Prefix == res.Prefix {
+			return ErrConflict
+		}
+	}
+	r.nextAliasReservationID++
+	res.ID = r.nextAliasReservationID
+	res.CreatedAt = time.Now()
+	stored := *res
+	r.aliasReservations = append(r.aliasReservations, &stored)
+	return nil
+}
+
+func (r *InMemoryRepository) ListAliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reservations := make([]*AliasReservation, 0, len(r.aliasReservations))
+	for _, res := range r.aliasReservations {
+		copied := *res
+		reservations = append(reservations, &copied)
+	}
+	return reservations, nil
+}
+
+func (r *InMemoryRepository) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, res := range r.aliasReservations {
+		if res.ID == id {
+			r.aliasReservations = slices.Delete(r.aliasReservations, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *InMemoryRepository) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for _, link := range r.all() {
+		if link.IsExpired(now) {
+			r.remove(link)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// all returns every link, including soft-deleted ones. Callers must hold r.mu.
+func (r *InMemoryRepository) all() []*Link {
+	links := make([]*Link, 0, len(r.byID)+len(r.deleted))
+	for _, link := range r.byID {
+		links = append(links, link)
+	}
+	for _, link := range r.deleted {
+		links = append(links, link)
+	}
+	return links
+}
+
+func (r *InMemoryRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,295 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryRepository_SaveAndGet(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	// Pin ID 2 ahead of the counter
+	if _, err := repo.Save(ctx, &Link{ID: 2, OriginalURL: "https://example.com/pinned"}); err != nil {
+		t.Fatalf("Save() pinned unexpected error = %v", err)
+	}
+	if _, err := repo.Save(ctx, &Link{ID: 2, OriginalURL: "https://example.com/dup"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() duplicate pinned id error = %v, want ErrConflict", err)
+	}
+
+	var ids []uint64
+	for i := 0; i < 3; i++ {
+		id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/gen"})
+		if err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != 1 || ids[1] != 3 || ids[2] != 4 {
+		t.Errorf("generated ids = %v, want [1 3 4]", ids)
+	}
+
+	if _, err := repo.Save(ctx, &Link{Code: "spring-sale", OriginalURL: "https://example.com/sale"}); err != nil {
+		t.Fatalf("Save() with code unexpected error = %v", err)
+	}
+	if _, err := repo.Save(ctx, &Link{Code: "spring-sale", OriginalURL: "https://example.com/other"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() duplicate code error = %v, want ErrConflict", err)
+	}
+
+	got, err := repo.GetByCode(ctx, "spring-sale")
+	if err != nil {
+		t.Fatalf("GetByCode() unexpected error = %v", err)
+	}
+	if got.OriginalURL != "https://example.com/sale" || got.CreatedAt.IsZero() {
+		t.Errorf("GetByCode() = %+v", got)
+	}
+
+	// Returned links are copies
+	got.OriginalURL = "https://evil.example"
+	if again, _ := repo.GetByCode(ctx, "spring-sale"); again.OriginalURL != "https://example.com/sale" {
+		t.Error("mutating a returned link changed the stored link")
+	}
+
+	if _, err := repo.Get(ctx, 999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() missing id error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetByCode(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByCode() missing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInMemoryRepository_ConcurrentSave(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	const n = 100
+	ids := make(chan uint64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"})
+			if err != nil {
+				t.Errorf("Save() unexpected error = %v", err)
+				return
+			}
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[uint64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("duplicate id %d", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestInMemoryRepository_ListAndPurge(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"}); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+	if _, err := repo.Save(ctx, &Link{Code: "old-promo", OriginalURL: "https://example.com/old", ExpiresAt: &past}); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	links, err := repo.List(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("List() unexpected error = %v", err)
+	}
+	if len(links) != 2 || links[0].ID != 4 || links[1].ID != 3 {
+		t.Errorf("List() first page = %+v, want ids [4 3]", links)
+	}
+	links, _ = repo.List(ctx, 3, 10)
+	if len(links) != 2 || links[0].ID != 2 || links[1].ID != 1 {
+		t.Errorf("List() second page = %+v, want ids [2 1]", links)
+	}
+
+	n, err := repo.PurgeExpired(ctx, now)
+	if err != nil {
+		t.Fatalf("PurgeExpired() unexpected error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("PurgeExpired() = %d, want 1", n)
+	}
+	if _, err := repo.GetByCode(ctx, "old-promo"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired link still present: err = %v", err)
+	}
+}
+
+func TestInMemoryRepository_IncrementClicks(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com", MaxClicks: 5}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	for want := int64(1); want <= 2; want++ {
+		if n, err := repo.IncrementClicks(ctx, link); err != nil || n != want {
+			t.Errorf("IncrementClicks() = %d, %v, want %d", n, err, want)
+		}
+	}
+	if got, _ := repo.Get(ctx, link.ID); got.Clicks != 2 {
+		t.Errorf("Get() Clicks = %d, want 2", got.Clicks)
+	}
+	if _, err := repo.IncrementClicks(ctx, &Link{ID: 999}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("IncrementClicks() missing link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInMemoryRepository_SearchStatusAndStats(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	links := []*Link{
+		{OriginalURL: "https://Example.com/sale"},
+		{OriginalURL: "https://other.test/"},
+		{OriginalURL: "https://example.com/old", ExpiresAt: &past},
+	}
+	for _, link := range links {
+		if _, err := repo.Save(ctx, link); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+
+	found, err := repo.Search(ctx, "EXAMPLE.com", 0, 10)
+	if err != nil {
+		t.Fatalf("Search() unexpected error = %v", err)
+	}
+	if len(found) != 2 || found[0].ID != 3 || found[1].ID != 1 {
+		t.Errorf("Search() = %+v, want ids [3 1]", found)
+	}
+
+	if err := repo.SetStatus(ctx, links[0], StatusDisabled); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+	if got, _ := repo.Get(ctx, links[0].ID); !got.IsDisabled() {
+		t.Errorf("Get() status = %q, want disabled", got.Status)
+	}
+	if err := repo.SetStatus(ctx, &Link{ID: 999}, StatusDisabled); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetStatus() missing link error = %v, want ErrNotFound", err)
+	}
+
+	stats, err := repo.Stats(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("Stats() unexpected error = %v", err)
+	}
+	want := Stats{Total: 3, Active: 1, Disabled: 1, Expired: 1}
+	if *stats != want {
+		t.Errorf("Stats() = %+v, want %+v", *stats, want)
+	}
+}
+
+func TestInMemoryRepository_Reports(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	link := &Link{Code: "phish", OriginalURL: "https://example.com/login"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	for i, reporter := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		open, err := repo.AddReport(ctx, &Report{LinkID: link.ID, Reporter: reporter})
+		if err != nil {
+			t.Fatalf("AddReport() unexpected error = %v", err)
+		}
+		if want := []int64{1, 1, 2}[i]; open != want {
+			t.Errorf("AddReport() #%d open = %d, want %d", i, open, want)
+		}
+	}
+	if _, err := repo.AddReport(ctx, &Report{LinkID: 999, Reporter: "192.0.2.1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddReport() missing link error = %v, want ErrNotFound", err)
+	}
+
+	reports, err := repo.ListReports(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("ListReports() unexpected error = %v", err)
+	}
+	if len(reports) != 1 || reports[0].ID != 2 || reports[0].ShortCode() != "phish" {
+		t.Errorf("ListReports() first page = %+v", reports)
+	}
+	if reports, _ := repo.ListReports(ctx, 2, 1); len(reports) != 1 || reports[0].ID != 1 {
+		t.Errorf("ListReports() second page = %+v", reports)
+	}
+
+	if err := repo.ResolveReports(ctx, link, time.Now()); err != nil {
+		t.Fatalf("ResolveReports() unexpected error = %v", err)
+	}
+	if reports, _ := repo.ListReports(ctx, 0, 10); len(reports) != 0 {
+		t.Errorf("ListReports() after resolve = %+v, want none", reports)
+	}
+}
+
+func TestInMemoryRepository_UsersAndOwnership(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	user := &User{Email: "alice@example.com", PasswordHash: "hash"}
+	if err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser() unexpected error = %v", err)
+	}
+	if err := repo.CreateUser(ctx, &User{Email: "alice@example.com"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateUser() duplicate email error = %v, want ErrConflict", err)
+	}
+	if err := repo.CreateAPIKey(ctx, &APIKey{UserID: user.ID, KeyHash: "keyhash", Scopes: []string{ScopeRead}}); err != nil {
+		t.Fatalf("CreateAPIKey() unexpected error = %v", err)
+	}
+	if got, key, err := repo.GetUserByAPIKey(ctx, "keyhash"); err != nil || got.Email != "alice@example.com" || key.ID == 0 || !slices.Equal(key.Scopes, []string{ScopeRead}) {
+		t.Errorf("GetUserByAPIKey() = %+v, %+v, %v", got, key, err)
+	}
+
+	owned := &Link{Code: "mine", OriginalURL: "https://example.com/a", OwnerID: user.ID}
+	if _, err := repo.Save(ctx, owned); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if _, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/anonymous"}); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if links, _ := repo.ListByOwner(ctx, user.ID, 0, 10); len(links) != 1 || links[0].ID != owned.ID {
+		t.Errorf("ListByOwner() = %+v, want only link %d", links, owned.ID)
+	}
+
+	if err := repo.Delete(ctx, owned); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	if _, err := repo.GetByCode(ctx, "mine"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByCode() after delete error = %v, want ErrNotFound", err)
+	}
+	if links, _ := repo.ListByOwner(ctx, user.ID, 0, 10); len(links) != 0 {
+		t.Errorf("ListByOwner() after delete = %+v, want none", links)
+	}
+	// The alias stays reserved so that the link can be restored
+	if _, err := repo.Save(ctx, &Link{Code: "mine", OriginalURL: "https://example.com/b"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() reusing deleted alias error = %v, want ErrConflict", err)
+	}
+	if err := repo.Restore(ctx, &Link{Code: "mine"}, user.ID+1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() by another owner error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Restore(ctx, &Link{Code: "mine"}, user.ID); err != nil {
+		t.Fatalf("Restore() unexpected error = %v", err)
+	}
+	if got, err := repo.GetByCode(ctx, "mine"); err != nil || got.ID != owned.ID {
+		t.Errorf("GetByCode() after restore = %+v, %v", got, err)
+	}
+	if err := repo.Restore(ctx, owned, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() of live link error = %v, want ErrNotFound", err)
+	}
+}
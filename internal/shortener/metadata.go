@@ -0,0 +1,125 @@
+package shortener
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of the metadata of a link.
+const (
+	MaxMetadataTitle       = 200
+	MaxMetadataDescription = 2000
+	// MaxMetadataAttributes caps the encoded size of the attributes in bytes.
+	MaxMetadataAttributes = 4096
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// Metadata are notes kept with a link by its owner. They are never shown
+// to visitors. Title and Description are plain text and matched by Search;
+// Attributes is a JSON object of the owner's choosing, stored as is.
+type Metadata struct {
+	Title       string          `json:"title,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Attributes  json.RawMessage `json:"attributes,omitempty"`
+}
+
+// empty reports whether m holds nothing worth storing.
+func (m *Metadata) empty() bool {
+	return m.Title == "" && m.Description == "" && len(m.Attributes) == 0
+}
+
+// normalize trims the title and description of m, compacts its attributes
+// and checks them against the limits.
+func (m *Metadata) normalize() error {
+	m.Title = strings.TrimSpace(m.Title)
+	m.Description = strings.TrimSpace(m.Description)
+	if n := utf8.RuneCountInString(m.Title); n > MaxMetadataTitle {
+		return fmt.Errorf("%w: title is %d characters, at most %d are allowed", ErrInvalidMetadata, n, MaxMetadataTitle)
+	}
+	if n := utf8.RuneCountInString(m.Description); n > MaxMetadataDescription {
+		return fmt.Errorf("%w: description is %d characters, at most %d are allowed", ErrInvalidMetadata, n, MaxMetadataDescription)
+	}
+	if len(m.Attributes) == 0 || bytes.Equal(m.Attributes, []byte("null")) {
+		m.Attributes = nil
+		return nil
+	}
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(m.Attributes, &attrs); err != nil {
+		return fmt.Errorf("%w: attributes must be a JSON object", ErrInvalidMetadata)
+	}
+	if len(attrs) == 0 {
+		m.Attributes = nil
+		return nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, m.Attributes); err != nil {
+		return fmt.Errorf("%w: attributes must be a JSON object", ErrInvalidMetadata)
+	}
+	if compact.Len() > MaxMetadataAttributes {
+		return fmt.Errorf("%w: attributes are %d bytes, at most %d are allowed", ErrInvalidMetadata, compact.Len(), MaxMetadataAttributes)
+	}
+	m.Attributes = compact.Bytes()
+	return nil
+}
+
+// SetMetadata replaces the metadata of the link behind shortCode, which user
+// must own or manage through an organization. Empty metadata removes it.
+// Returns ErrInvalidMetadata for a title or description that is too long
+// and for attributes that are not a JSON object or too large.
+func (s *Service) SetMetadata(ctx context.Context, user *User, shortCode string, m *Metadata) (*Link, error) {
+	if m != nil {
+		if err := m.normalize(); err != nil {
+			return nil, err
+		}
+		if m.empty() {
+			m = nil
+		}
+	}
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetMetadata(ctx, link, m); err != nil {
+		return nil, fmt.Errorf("failed to set metadata: %w", err)
+	}
+	link.Metadata = m
+	s.audit(ctx, UserActor(user), AuditSetMetadata, link.ShortCode(), "")
+	return link, nil
+}
+
+// matchesMetadata reports whether the title or description of m contains
+// query, which must be lowercase. It is how repositories without a query
+// language search metadata.
+func matchesMetadata(m *Metadata, query string) bool {
+	return m != nil && (strings.Contains(strings.ToLower(m.Title), query) || strings.Contains(strings.ToLower(m.Description), query))
+}
+
+// marshalMetadata encodes m for the metadata column; nil is stored as NULL.
+func marshalMetadata(m *Metadata) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// unmarshalMetadata decodes the metadata column.
+func unmarshalMetadata(data []byte) (*Metadata, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return &m, nil
+}
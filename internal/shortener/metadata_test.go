@@ -0,0 +1,117 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestService_SetMetadata(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	bob, _, err := svc.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/sale", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     *User
+		metadata *Metadata
+		want     *Metadata
+		wantErr  error
+	}{
+		{
+			name:     "title, description and attributes",
+			user:     alice,
+			metadata: &Metadata{Title: " Spring sale ", Description: "Banner of the home page", Attributes: []byte(`{ "team": "growth", "budget": 1200 }`)},
+			want:     &Metadata{Title: "Spring sale", Description: "Banner of the home page", Attributes: []byte(`{"team":"growth","budget":1200}`)},
+		},
+		{name: "attributes that are not an object", user: alice, metadata: &Metadata{Attributes: []byte(`["growth"]`)}, wantErr: ErrInvalidMetadata},
+		{name: "title too long", user: alice, metadata: &Metadata{Title: strings.Repeat("é", MaxMetadataTitle+1)}, wantErr: ErrInvalidMetadata},
+		{name: "attributes too large", user: alice, metadata: &Metadata{Attributes: []byte(`{"notes":"` + strings.Repeat("a", MaxMetadataAttributes) + `"}`)}, wantErr: ErrInvalidMetadata},
+		{name: "another user", user: bob, metadata: &Metadata{Title: "Mine"}, wantErr: ErrForbidden},
+		{name: "empty attributes remove the metadata", user: alice, metadata: &Metadata{Title: "  ", Attributes: []byte(`{}`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link, err := svc.SetMetadata(ctx, tt.user, code, tt.metadata)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetMetadata() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !sameMetadata(link.Metadata, tt.want) {
+				t.Errorf("SetMetadata() metadata = %+v, want %+v", link.Metadata, tt.want)
+			}
+			stored, err := svc.OwnedLink(ctx, alice, code)
+			if err != nil || !sameMetadata(stored.Metadata, tt.want) {
+				t.Errorf("stored metadata = %+v, %v, want %+v", stored.Metadata, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_SearchMetadata(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	for _, alias := range []string{"sale", "docs-link", "plain"} {
+		if _, err := svc.ShortenWithOptions(ctx, "https://example.com/"+alias, ShortenOptions{Owner: alice, CustomAlias: alias}); err != nil {
+			t.Fatalf("ShortenWithOptions() error = %v", err)
+		}
+	}
+	if _, err := svc.SetMetadata(ctx, alice, "sale", &Metadata{Title: "Spring Campaign"}); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	if _, err := svc.SetMetadata(ctx, alice, "docs-link", &Metadata{Description: "Shared in the spring newsletter", Attributes: []byte(`{"spring":true}`)}); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "SPRING", want: []string{"docs-link", "sale"}},
+		{query: "newsletter", want: []string{"docs-link"}},
+		{query: "plain", want: []string{"plain"}},
+		{query: "true", want: nil},
+	}
+	for _, tt := range tests {
+		links, _, err := svc.Search(ctx, tt.query, 0, 10)
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", tt.query, err)
+		}
+		var got []string
+		for _, link := range links {
+			got = append(got, link.ShortCode())
+		}
+		// Pinned aliases do not take IDs in creation order
+		slices.Sort(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// sameMetadata compares metadata field by field, attributes as bytes.
+func sameMetadata(a, b *Metadata) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Title == b.Title && a.Description == b.Description && string(a.Attributes) == string(b.Attributes)
+}
@@ -0,0 +1,73 @@
+package shortener
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics published on the admin metrics endpoint.
+var (
+	// cacheMetrics describes the Redis link cache. Dividing
+	// ttl_seconds_total by sets gives the mean effective TTL after jitter
+	// and capping at link expiry.
+	cacheMetrics = expvar.NewMap("cache")
+	// clickMetrics describes click counting: clicks lost because they
+	// could not be recorded, clicks buffered in memory, and the seconds
+	// since buffered clicks and counters were last written to the database.
+	clickMetrics = expvar.NewMap("clicks")
+	// retryMetrics counts retries of transient errors by backend, and as
+	// <backend>_exhausted the operations that failed on the last attempt.
+	retryMetrics = expvar.NewMap("retries")
+)
+
+// degradedSince is the Unix time since which link lookups have been served
+// from the stale cache because the database failed, or zero.
+var degradedSince atomic.Int64
+
+// lastRollup and lastFlush are the Unix times of the last successful
+// RollupClicks and FlushClicks, or zero before the first.
+var lastRollup, lastFlush atomic.Int64
+
+// lastWarm is the Unix time of the last complete WarmCache pass, or zero.
+var lastWarm atomic.Int64
+
+func init() {
+	clickMetrics.Set("rollup_lag_seconds", lagSince(&lastRollup))
+	clickMetrics.Set("flush_lag_seconds", lagSince(&lastFlush))
+	cacheMetrics.Set("warm_lag_seconds", lagSince(&lastWarm))
+	cacheMetrics.Set("degraded", expvar.Func(func() any { return degradedSince.Load() != 0 }))
+}
+
+// lagSince reports the seconds since the time in last, or -1 if unset.
+func lagSince(last *atomic.Int64) expvar.Func {
+	return func() any {
+		t := last.Load()
+		if t == 0 {
+			return -1
+		}
+		return time.Now().Unix() - t
+	}
+}
+
+// PublishRepositoryMetrics publishes the cache TTL and jitter of repos,
+// which share one cache configuration, and the clicks buffered in memory
+// across all of them. It is called once with every repository serving
+// links, such as the shards of a ShardedRepository.
+func PublishRepositoryMetrics(repos ...*PostgresRedisRepository) {
+	if len(repos) == 0 {
+		return
+	}
+	ttl, jitter := new(expvar.Float), new(expvar.Float)
+	ttl.Set(repos[0].cacheTTL.Seconds())
+	jitter.Set(repos[0].cacheJitter)
+	cacheMetrics.Set("ttl_seconds", ttl)
+	cacheMetrics.Set("ttl_jitter", jitter)
+	clickMetrics.Set("buffered", expvar.Func(func() any {
+		n := 0
+		for _, r := range repos {
+			n += r.clicks.len()
+		}
+		return n
+	}))
+}
@@ -0,0 +1,30 @@
+package shortener
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestPublishRepositoryMetrics(t *testing.T) {
+	hour := time.Now().Truncate(time.Hour)
+	shards := []*PostgresRedisRepository{
+		NewPostgresRedisRepository(nil, nil, WithCacheTTL(time.Hour, 0.1)),
+		NewPostgresRedisRepository(nil, nil, WithCacheTTL(time.Hour, 0.1)),
+	}
+	shards[0].clicks.add([]clickCount{{linkID: 1, start: hour, clicks: 1}, {linkID: 2, start: hour, clicks: 3}})
+	shards[1].clicks.add([]clickCount{{linkID: 3, start: hour, clicks: 2}})
+	PublishRepositoryMetrics(shards...)
+
+	if got := cacheMetrics.Get("ttl_seconds").(*expvar.Float).Value(); got != 3600 {
+		t.Errorf("ttl_seconds = %v, want 3600", got)
+	}
+	if got := cacheMetrics.Get("ttl_jitter").(*expvar.Float).Value(); got != 0.1 {
+		t.Errorf("ttl_jitter = %v, want 0.1", got)
+	}
+	// A repository built later, such as by a tool, leaves the metrics alone
+	NewPostgresRedisRepository(nil, nil)
+	if got := clickMetrics.Get("buffered").(expvar.Func)(); got != 3 {
+		t.Errorf("buffered = %v, want 3 across shards", got)
+	}
+}
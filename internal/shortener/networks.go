@@ -0,0 +1,89 @@
+package shortener
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// addrRange is the inclusive range of addresses of an IP network.
+type addrRange struct {
+	first, last netip.Addr
+}
+
+// networkSet is a set of IP networks merged into sorted, non-overlapping
+// ranges, so that an address is matched with a binary search however many
+// networks there are.
+type networkSet []addrRange
+
+// parseNetwork parses s as a network in CIDR notation or a single address,
+// which stands for a network of its own. IPv4-mapped IPv6 networks are
+// converted to IPv4.
+func parseNetwork(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(s)
+		if addrErr != nil {
+			return netip.Prefix{}, err
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// newNetworkSet compiles networks, skipping any that do not parse. It
+// returns nil for no networks.
+func newNetworkSet(networks []string) networkSet {
+	var set networkSet
+	for _, n := range networks {
+		prefix, err := parseNetwork(n)
+		if err != nil {
+			continue
+		}
+		set = append(set, addrRange{first: prefix.Addr(), last: lastAddr(prefix)})
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	sort.Slice(set, func(i, j int) bool {
+		return set[i].first.Less(set[j].first)
+	})
+	// Networks either nest or are disjoint, so each range starting within
+	// the previous one is merged into it
+	merged := set[:1]
+	for _, r := range set[1:] {
+		prev := &merged[len(merged)-1]
+		if r.first.BitLen() == prev.first.BitLen() && !prev.last.Less(r.first) {
+			if prev.last.Less(r.last) {
+				prev.last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// contains reports whether addr is in one of the networks of s.
+func (s networkSet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	// Find the last range starting at or before addr
+	i := sort.Search(len(s), func(i int) bool {
+		return addr.Less(s[i].first)
+	}) - 1
+	return i >= 0 && !s[i].last.Less(addr)
+}
+
+// lastAddr returns the highest address in the masked prefix p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for bit := p.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
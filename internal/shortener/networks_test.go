@@ -0,0 +1,55 @@
+package shortener
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func TestNetworkSet(t *testing.T) {
+	set := newNetworkSet([]string{"10.0.0.0/8", "10.20.0.0/16", "192.0.2.7", "2001:db8::/48", "not a network", "0.0.0.0/0x"})
+	if len(set) != 3 {
+		t.Errorf("newNetworkSet() = %d ranges, want the nested network merged and invalid ones skipped: %v", len(set), set)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "10.0.0.0", want: true},
+		{addr: "10.255.255.255", want: true},
+		{addr: "10.20.1.1", want: true},
+		{addr: "11.0.0.0", want: false},
+		{addr: "9.255.255.255", want: false},
+		{addr: "192.0.2.7", want: true},
+		{addr: "192.0.2.8", want: false},
+		{addr: "::ffff:10.1.1.1", want: true},
+		{addr: "2001:db8:0:ffff::1", want: true},
+		{addr: "2001:db8:1::1", want: false},
+		{addr: "::a00:1", want: false},
+	}
+	for _, tt := range tests {
+		if got := set.contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if set.contains(netip.Addr{}) {
+		t.Error("contains() of an unknown address = true, want false")
+	}
+	if networkSet(nil).contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("contains() of an empty set = true, want false")
+	}
+}
+
+func BenchmarkNetworkSet(b *testing.B) {
+	networks := make([]string, MaxAccessNetworks)
+	for i := range networks {
+		networks[i] = fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)
+	}
+	set := newNetworkSet(networks)
+	addr := netip.MustParseAddr("10.3.200.17")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.contains(addr)
+	}
+}
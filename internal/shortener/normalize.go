@@ -0,0 +1,227 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	ErrInvalidURL = errors.New("invalid url")
+)
+
+// trackingParams are query parameters that only carry click/campaign
+// attribution and never affect the resource being addressed.
+var trackingParams = map[string]struct{}{
+	"fbclid":  {},
+	"gclid":   {},
+	"dclid":   {},
+	"msclkid": {},
+	"mc_cid":  {},
+	"mc_eid":  {},
+	"igshid":  {},
+	"yclid":   {},
+	"_ga":     {},
+}
+
+// isTrackingParam reports whether a query key is a known tracking parameter.
+// All utm_* keys are treated as tracking parameters.
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasPrefix(key, "utm_") {
+		return true
+	}
+	_, ok := trackingParams[key]
+	return ok
+}
+
+// ValidateURL checks that rawURL is an absolute http:// or https:// URL with a host.
+// It returns an error wrapping ErrInvalidURL describing the first problem found.
+func ValidateURL(rawURL string) error {
+	if rawURL == "" {
+		return fmt.Errorf("%w: url is empty", ErrInvalidURL)
+	}
+
+	parsed, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrInvalidURL)
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("%w: host is empty", ErrInvalidURL)
+	}
+
+	return nil
+}
+
+// URLNormalization selects the rules applied to destinations before they
+// are stored, so that equivalent URLs are stored, deduplicated and grouped
+// in analytics alike. Each rule is optional, as some servers tell apart
+// what the rules consider equivalent.
+type URLNormalization struct {
+	// Host lowercases the scheme and host and converts internationalized
+	// domain names to punycode (IDNA 2008).
+	Host bool
+	// DefaultPort removes :80 from http and :443 from https URLs.
+	DefaultPort bool
+	// Fragment removes the fragment, which single-page apps may route on.
+	Fragment bool
+	// SortQuery orders query parameters by name, keeping the order of
+	// repeated ones, which some servers read in order.
+	SortQuery bool
+}
+
+// DefaultURLNormalization is what NewService applies: the rules that never
+// change which resource a URL addresses.
+var DefaultURLNormalization = URLNormalization{Host: true, DefaultPort: true}
+
+// Apply returns rawURL normalized by the rules of n. URLs that do not parse
+// are returned unchanged, for validation to reject.
+func (n URLNormalization) Apply(rawURL string) (string, error) {
+	if n == (URLNormalization{}) {
+		return rawURL, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL, nil
+	}
+	if err := n.apply(parsed); err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+func (n URLNormalization) apply(parsed *url.URL) error {
+	host := parsed.Hostname()
+	if n.Host {
+		parsed.Scheme = strings.ToLower(parsed.Scheme)
+		host = strings.ToLower(host)
+		// ASCII hosts are left to DNS, which allows more than IDNA does,
+		// such as underscores
+		if !isASCII(host) {
+			ascii, err := idna.Lookup.ToASCII(host)
+			if err != nil {
+				return fmt.Errorf("%w: invalid host: %v", ErrInvalidURL, err)
+			}
+			host = ascii
+		}
+	}
+
+	port := parsed.Port()
+	if n.DefaultPort && ((parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443")) {
+		port = ""
+	}
+	if port != "" {
+		parsed.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		parsed.Host = "[" + host + "]" // IPv6 literal
+	} else {
+		parsed.Host = host
+	}
+
+	if n.Fragment {
+		parsed.Fragment = ""
+		parsed.RawFragment = ""
+	}
+	if n.SortQuery {
+		parsed.RawQuery = sortQuery(parsed.RawQuery)
+	}
+	return nil
+}
+
+// isASCII reports whether s consists of ASCII characters only.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeURL validates rawURL and returns its canonical form.
+//
+// Canonicalization rules:
+//   - Scheme and host are lowercased
+//   - Internationalized domain names are converted to punycode (IDNA 2008)
+//   - Default ports (:80 for http, :443 for https) are removed
+//   - An empty path becomes "/"
+//   - Tracking parameters (utm_*, fbclid, gclid, ...) are removed; the
+//     relative order of the remaining parameters is preserved
+//
+// Equivalent URLs normalize to the same string, which makes the result
+// suitable for deduplication and analytics grouping.
+func NormalizeURL(rawURL string) (string, error) {
+	if err := ValidateURL(rawURL); err != nil {
+		return "", err
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if err := DefaultURLNormalization.apply(parsed); err != nil {
+		return "", err
+	}
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	parsed.RawQuery = stripTrackingParams(parsed.RawQuery)
+
+	return parsed.String(), nil
+}
+
+// sortQuery orders the parameters of a raw query string by their unescaped
+// name. Like stripTrackingParams it works on the text, keeping the encoding
+// of each parameter.
+func sortQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	pairs := strings.Split(rawQuery, "&")
+	slices.SortStableFunc(pairs, func(a, b string) int {
+		return strings.Compare(queryKey(a), queryKey(b))
+	})
+	return strings.Join(pairs, "&")
+}
+
+// queryKey returns the unescaped name of a query parameter pair.
+func queryKey(pair string) string {
+	key, _, _ := strings.Cut(pair, "=")
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+// stripTrackingParams removes tracking parameters from a raw query string.
+// The query is filtered textually (rather than via url.Values) so that the
+// original ordering and encoding of the remaining parameters survive.
+func stripTrackingParams(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	pairs := strings.Split(rawQuery, "&")
+	kept := pairs[:0]
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		if isTrackingParam(queryKey(pair)) {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+
+	return strings.Join(kept, "&")
+}
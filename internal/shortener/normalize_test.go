@@ -0,0 +1,201 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawURL  string
+		wantErr bool
+	}{
+		{name: "http URL", rawURL: "http://example.com", wantErr: false},
+		{name: "https URL with path", rawURL: "https://example.com/a/b?c=d", wantErr: false},
+		{name: "empty string", rawURL: "", wantErr: true},
+		{name: "no scheme", rawURL: "www.google.com", wantErr: true},
+		{name: "ftp scheme", rawURL: "ftp://example.com", wantErr: true},
+		{name: "missing host", rawURL: "https://", wantErr: true},
+		{name: "javascript scheme", rawURL: "javascript:alert(1)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateURL(tt.rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateURL(%q) error = %v, wantErr %v", tt.rawURL, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidURL) {
+				t.Errorf("ValidateURL(%q) error = %v, want ErrInvalidURL", tt.rawURL, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawURL  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "already canonical",
+			rawURL: "https://example.com/path",
+			want:   "https://example.com/path",
+		},
+		{
+			name:   "mixed-case scheme and host",
+			rawURL: "HTTPS://WWW.Example.COM/Path",
+			want:   "https://www.example.com/Path",
+		},
+		{
+			name:   "empty path becomes slash",
+			rawURL: "https://example.com",
+			want:   "https://example.com/",
+		},
+		{
+			name:   "default https port removed",
+			rawURL: "https://example.com:443/a",
+			want:   "https://example.com/a",
+		},
+		{
+			name:   "default http port removed",
+			rawURL: "http://example.com:80/a",
+			want:   "http://example.com/a",
+		},
+		{
+			name:   "non-default port kept",
+			rawURL: "http://example.com:8080/a",
+			want:   "http://example.com:8080/a",
+		},
+		{
+			name:   "unicode domain converted to punycode",
+			rawURL: "https://bücher.example/katalog",
+			want:   "https://xn--bcher-kva.example/katalog",
+		},
+		{
+			name:   "unicode uppercase domain",
+			rawURL: "https://MÜNCHEN.de/",
+			want:   "https://xn--mnchen-3ya.de/",
+		},
+		{
+			name:   "utm parameters stripped",
+			rawURL: "https://example.com/p?utm_source=news&id=42&utm_campaign=spring",
+			want:   "https://example.com/p?id=42",
+		},
+		{
+			name:   "click identifiers stripped",
+			rawURL: "https://example.com/?fbclid=abc&gclid=def",
+			want:   "https://example.com/",
+		},
+		{
+			name:   "non-tracking parameter order preserved",
+			rawURL: "https://example.com/?b=2&a=1&UTM_Medium=email",
+			want:   "https://example.com/?b=2&a=1",
+		},
+		{
+			name:   "fragment preserved",
+			rawURL: "https://example.com/doc#section",
+			want:   "https://example.com/doc#section",
+		},
+		{
+			name:   "IPv6 host",
+			rawURL: "http://[::1]:80/",
+			want:   "http://[::1]/",
+		},
+		{
+			name:    "invalid scheme",
+			rawURL:  "ftp://example.com",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			rawURL:  "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeURL(tt.rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeURL(%q) error = %v, wantErr %v", tt.rawURL, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeURL(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLNormalization_Apply(t *testing.T) {
+	all := URLNormalization{Host: true, DefaultPort: true, Fragment: true, SortQuery: true}
+	tests := []struct {
+		name   string
+		rules  URLNormalization
+		rawURL string
+		want   string
+	}{
+		{"no rules", URLNormalization{}, "HTTPS://Example.COM:443/a#top", "HTTPS://Example.COM:443/a#top"},
+		{"default rules", DefaultURLNormalization, "HTTPS://Example.COM:443/a?b=2&a=1#top", "https://example.com/a?b=2&a=1#top"},
+		{"host only", URLNormalization{Host: true}, "https://Example.COM:443/Path", "https://example.com:443/Path"},
+		{"port only", URLNormalization{DefaultPort: true}, "http://Example.COM:80/", "http://Example.COM/"},
+		{"unicode host", URLNormalization{Host: true}, "https://Bücher.example/", "https://xn--bcher-kva.example/"},
+		{"underscore host", URLNormalization{Host: true}, "https://My_Site.example.com/", "https://my_site.example.com/"},
+		{"fragment removed", URLNormalization{Fragment: true}, "https://example.com/doc#section", "https://example.com/doc"},
+		{"query sorted", URLNormalization{SortQuery: true}, "https://example.com/?b=2&a=1&c=%20&a=0", "https://example.com/?a=1&a=0&b=2&c=%20"},
+		{"tracking parameters kept", all, "https://example.com/?utm_source=x&id=1", "https://example.com/?id=1&utm_source=x"},
+		{"empty path kept", all, "https://example.com", "https://example.com"},
+		{"IPv6 host", all, "http://[::1]:80/", "http://[::1]/"},
+		{"not a URL", all, "example.com/a", "example.com/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rules.Apply(tt.rawURL)
+			if err != nil {
+				t.Fatalf("Apply(%q) error = %v", tt.rawURL, err)
+			}
+			if got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := DefaultURLNormalization.Apply("https://exa\u200dmple.com/"); !errors.Is(err, ErrInvalidURL) {
+		t.Errorf("Apply() of an invalid host error = %v, want ErrInvalidURL", err)
+	}
+}
+
+func TestService_ShortenNormalizesURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		input string
+		want  string
+	}{
+		{"default rules", nil, "HTTPS://Example.COM:443/a?b=2&a=1#top", "https://example.com/a?b=2&a=1#top"},
+		{"configured rules", []Option{WithURLNormalization(URLNormalization{Fragment: true, SortQuery: true})}, "HTTPS://Example.COM:443/a?b=2&a=1#top", "https://Example.COM:443/a?a=1&b=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc := NewService(NewInMemoryRepository(), tt.opts...)
+			code, err := svc.Shorten(ctx, tt.input)
+			if err != nil {
+				t.Fatalf("Shorten() error = %v", err)
+			}
+			link, err := svc.Resolve(ctx, code)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if link.OriginalURL != tt.want {
+				t.Errorf("stored URL = %q, want %q", link.OriginalURL, tt.want)
+			}
+		})
+	}
+}
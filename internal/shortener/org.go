@@ -0,0 +1,287 @@
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxOrgNameLength caps organization names, in characters.
+	MaxOrgNameLength = 100
+	// InvitationTTL is how long an invitation to an organization can be
+	// accepted.
+	InvitationTTL = 7 * 24 * time.Hour
+
+	// invitationPrefix makes invitation tokens recognizable, like API keys.
+	invitationPrefix = "inv_"
+)
+
+var (
+	// ErrInvalidOrg is returned for organization names that are empty or
+	// too long, and for links assigned to an organization their owner is
+	// not a member of.
+	ErrInvalidOrg = errors.New("invalid organization")
+	// ErrInvalidInvitation is returned for invitation tokens that are
+	// unknown, expired or meant for another email.
+	ErrInvalidInvitation = errors.New("invalid invitation")
+	// ErrAlreadyMember is returned when inviting a member of the
+	// organization.
+	ErrAlreadyMember = errors.New("already a member of the organization")
+	// ErrLastMember is returned when removing the last member of an
+	// organization, whose links nobody could manage any more.
+	ErrLastMember = errors.New("cannot remove the last member of the organization")
+)
+
+// Org is an organization whose members all manage the links it owns, so that
+// links outlive the membership of the teammate who created them.
+type Org struct {
+	ID        uint64    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation lets the user with Email join the organization OrgID until
+// ExpiresAt. Only the hash of its token is stored.
+type Invitation struct {
+	OrgID     uint64    `json:"org_id"`
+	Email     string    `json:"email"`
+	TokenHash string    `json:"-"`
+	InviterID uint64    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// validOrgName returns name without surrounding space, or ErrInvalidOrg.
+func validOrgName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxOrgNameLength {
+		return "", fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidOrg, MaxOrgNameLength)
+	}
+	return name, nil
+}
+
+// CreateOrg creates an organization with user as its first member. Returns
+// ErrInvalidOrg for an invalid name; names need not be unique.
+func (s *Service) CreateOrg(ctx context.Context, user *User, name string) (*Org, error) {
+	name, err := validOrgName(name)
+	if err != nil {
+		return nil, err
+	}
+	org := &Org{Name: name}
+	if err := s.repo.CreateOrg(ctx, org, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+	return org, nil
+}
+
+// Orgs returns the organizations user is a member of, oldest first.
+func (s *Service) Orgs(ctx context.Context, user *User) ([]*Org, error) {
+	orgs, err := s.repo.ListOrgs(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// Org returns the organization with ID id, which user must be a member of.
+// Other organizations are reported as ErrNotFound.
+func (s *Service) Org(ctx context.Context, user *User, id uint64) (*Org, error) {
+	if err := s.checkMember(ctx, user, id); err != nil {
+		return nil, err
+	}
+	return s.repo.GetOrg(ctx, id)
+}
+
+// OrgMembers returns the members of the organization with ID id, which user
+// must be a member of, oldest member first.
+func (s *Service) OrgMembers(ctx context.Context, user *User, id uint64) ([]*User, error) {
+	if err := s.checkMember(ctx, user, id); err != nil {
+		return nil, err
+	}
+	members, err := s.repo.ListOrgMembers(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	return members, nil
+}
+
+// Invite invites email to the organization with ID id, which user must be a
+// member of, and returns the invitation with its token, which is valid for
+// InvitationTTL. Returns ErrInvalidEmail for a malformed email and
+// ErrAlreadyMember if email is a member already.
+func (s *Service) Invite(ctx context.Context, user *User, id uint64, email string) (*Invitation, string, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.checkMember(ctx, user, id); err != nil {
+		return nil, "", err
+	}
+	if invitee, err := s.repo.GetUserByEmail(ctx, email); err == nil {
+		if member, err := s.repo.IsOrgMember(ctx, id, invitee.ID); err != nil {
+			return nil, "", fmt.Errorf("failed to check membership: %w", err)
+		} else if member {
+			return nil, "", ErrAlreadyMember
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, "", fmt.Errorf("failed to get user: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("failed to generate invitation: %w", err)
+	}
+	token := invitationPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	inv := &Invitation{
+		OrgID:     id,
+		Email:     email,
+		TokenHash: hashAPIKey(token),
+		InviterID: user.ID,
+		ExpiresAt: s.now().Add(InvitationTTL).UTC(),
+	}
+	if err := s.repo.AddInvitation(ctx, inv); err != nil {
+		return nil, "", fmt.Errorf("failed to store invitation: %w", err)
+	}
+	return inv, token, nil
+}
+
+// AcceptInvitation makes user a member of the organization token invites
+// them to and returns it. The invitation must be meant for the email of
+// user; it can be used once.
+func (s *Service) AcceptInvitation(ctx context.Context, user *User, token string) (*Org, error) {
+	if !strings.HasPrefix(token, invitationPrefix) {
+		return nil, ErrInvalidInvitation
+	}
+	hash := hashAPIKey(token)
+	inv, err := s.repo.GetInvitation(ctx, hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	if !s.now().Before(inv.ExpiresAt) || inv.Email != user.Email {
+		return nil, ErrInvalidInvitation
+	}
+
+	// Using up the invitation first keeps it from being accepted twice
+	if err := s.repo.UseInvitation(ctx, hash); errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidInvitation
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to use invitation: %w", err)
+	}
+	if err := s.repo.AddOrgMember(ctx, inv.OrgID, user.ID); err != nil && !errors.Is(err, ErrConflict) {
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+	return s.repo.GetOrg(ctx, inv.OrgID)
+}
+
+// RemoveOrgMember removes the user memberID from the organization with ID
+// id, which user must be a member of; members may remove themselves to
+// leave. The links memberID created for the organization stay with it.
+// Returns ErrNotFound if memberID is not a member and ErrLastMember for the
+// last one.
+func (s *Service) RemoveOrgMember(ctx context.Context, user *User, id, memberID uint64) error {
+	members, err := s.OrgMembers(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	if len(members) == 1 && members[0].ID == memberID {
+		return ErrLastMember
+	}
+	if err := s.repo.RemoveOrgMember(ctx, id, memberID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	return nil
+}
+
+// OrgLinks returns a page of the links of the organization with ID id, which
+// user must be a member of, in the same way as ListOwned.
+func (s *Service) OrgLinks(ctx context.Context, user *User, id uint64, cursor uint64, limit int) ([]*Link, uint64, error) {
+	if err := s.checkMember(ctx, user, id); err != nil {
+		return nil, 0, err
+	}
+	links, next, err := paginate(limit, func(n int) ([]*Link, error) {
+		return s.repo.ListByOrg(ctx, id, cursor, n)
+	}, linkID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list organization urls: %w", err)
+	}
+	if err := s.attachHealth(ctx, links); err != nil {
+		return nil, 0, err
+	}
+	return links, next, nil
+}
+
+// checkMember returns ErrNotFound unless user is a member of the
+// organization with ID id, so that outsiders cannot tell which exist.
+func (s *Service) checkMember(ctx context.Context, user *User, id uint64) error {
+	member, err := s.repo.IsOrgMember(ctx, id, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check membership: %w", err)
+	}
+	if !member {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// checkOrg returns ErrInvalidOrg unless owner is a member of the
+// organization with ID id.
+func (s *Service) checkOrg(ctx context.Context, owner *User, id uint64) error {
+	if owner == nil {
+		return fmt.Errorf("%w: anonymous links cannot belong to an organization", ErrInvalidOrg)
+	}
+	if err := s.checkMember(ctx, owner, id); errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: not a member of organization %d", ErrInvalidOrg, id)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// scanOrgs scans and closes rows selected with orgColumns.
+func scanOrgs(rows *sql.Rows) ([]*Org, error) {
+	defer rows.Close()
+	orgs := []*Org{}
+	for rows.Next() {
+		var o Org
+		if err := rows.Scan(&o.ID, &o.Name, &o.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// orgColumns are the columns scanned by scanOrgs.
+const orgColumns = `o.id, o.name, o.created_at`
+
+// scanUsers scans and closes rows selected with userColumns.
+func scanUsers(rows *sql.Rows) ([]*User, error) {
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,147 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_Orgs(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(newRepo(t))
+			ctx := context.Background()
+			now := time.Now()
+			service.now = func() time.Time { return now }
+
+			signup := func(email string) *User {
+				t.Helper()
+				user, _, err := service.Signup(ctx, email, "password1")
+				if err != nil {
+					t.Fatalf("Signup() unexpected error = %v", err)
+				}
+				return user
+			}
+			alice, bob, carol := signup("alice@example.com"), signup("bob@example.com"), signup("carol@example.com")
+
+			org, err := service.CreateOrg(ctx, alice, "  Marketing ")
+			if err != nil {
+				t.Fatalf("CreateOrg() unexpected error = %v", err)
+			}
+			if org.ID == 0 || org.Name != "Marketing" || org.CreatedAt.IsZero() {
+				t.Errorf("CreateOrg() = %+v, want a stored organization named Marketing", org)
+			}
+			for _, name := range []string{"", "   ", strings.Repeat("x", MaxOrgNameLength+1)} {
+				if _, err := service.CreateOrg(ctx, alice, name); !errors.Is(err, ErrInvalidOrg) {
+					t.Errorf("CreateOrg(%q) error = %v, want ErrInvalidOrg", name, err)
+				}
+			}
+			if _, err := service.Org(ctx, bob, org.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Org() by an outsider error = %v, want ErrNotFound", err)
+			}
+			if _, _, err := service.Invite(ctx, bob, org.ID, "carol@example.com"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Invite() by an outsider error = %v, want ErrNotFound", err)
+			}
+
+			// Links of the organization are managed by its members only
+			code, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: alice, OrgID: org.ID})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			for _, opts := range []ShortenOptions{
+				{Owner: bob, OrgID: org.ID},
+				{OrgID: org.ID},
+				{Owner: alice, OrgID: 999},
+			} {
+				if _, err := service.ShortenWithOptions(ctx, "https://example.com", opts); !errors.Is(err, ErrInvalidOrg) {
+					t.Errorf("ShortenWithOptions(%+v) error = %v, want ErrInvalidOrg", opts, err)
+				}
+			}
+			repoint := "https://example.com/b"
+			if _, err := service.UpdateLink(ctx, bob, code, LinkUpdate{URL: repoint}); !errors.Is(err, ErrForbidden) {
+				t.Errorf("UpdateLink() by an outsider error = %v, want ErrForbidden", err)
+			}
+
+			// Invitations are for one email, used once and expire
+			inv, token, err := service.Invite(ctx, alice, org.ID, " Bob@Example.com ")
+			if err != nil {
+				t.Fatalf("Invite() unexpected error = %v", err)
+			}
+			if !strings.HasPrefix(token, invitationPrefix) || inv.Email != "bob@example.com" || inv.TokenHash == token {
+				t.Errorf("Invite() = %+v, %q", inv, token)
+			}
+			if _, _, err := service.Invite(ctx, alice, org.ID, "alice@example.com"); !errors.Is(err, ErrAlreadyMember) {
+				t.Errorf("Invite() of a member error = %v, want ErrAlreadyMember", err)
+			}
+			if _, _, err := service.Invite(ctx, alice, org.ID, "not an email"); !errors.Is(err, ErrInvalidEmail) {
+				t.Errorf("Invite() of a malformed email error = %v, want ErrInvalidEmail", err)
+			}
+			for _, tt := range []struct {
+				user  *User
+				token string
+			}{{carol, token}, {bob, "inv_unknown"}, {bob, token[len(invitationPrefix):]}} {
+				if _, err := service.AcceptInvitation(ctx, tt.user, tt.token); !errors.Is(err, ErrInvalidInvitation) {
+					t.Errorf("AcceptInvitation(%s, %q) error = %v, want ErrInvalidInvitation", tt.user.Email, tt.token, err)
+				}
+			}
+			joined, err := service.AcceptInvitation(ctx, bob, token)
+			if err != nil || joined.ID != org.ID {
+				t.Fatalf("AcceptInvitation() = %+v, %v", joined, err)
+			}
+			if _, err := service.AcceptInvitation(ctx, bob, token); !errors.Is(err, ErrInvalidInvitation) {
+				t.Errorf("AcceptInvitation() twice error = %v, want ErrInvalidInvitation", err)
+			}
+			_, expired, err := service.Invite(ctx, alice, org.ID, "carol@example.com")
+			if err != nil {
+				t.Fatalf("Invite() unexpected error = %v", err)
+			}
+			now = now.Add(InvitationTTL)
+			if _, err := service.AcceptInvitation(ctx, carol, expired); !errors.Is(err, ErrInvalidInvitation) {
+				t.Errorf("AcceptInvitation() after expiry error = %v, want ErrInvalidInvitation", err)
+			}
+
+			members, err := service.OrgMembers(ctx, bob, org.ID)
+			if err != nil || len(members) != 2 || members[0].ID != alice.ID || members[1].ID != bob.ID {
+				t.Fatalf("OrgMembers() = %+v, %v, want alice and bob", members, err)
+			}
+			if orgs, err := service.Orgs(ctx, bob); err != nil || len(orgs) != 1 || orgs[0].ID != org.ID {
+				t.Errorf("Orgs() = %+v, %v", orgs, err)
+			}
+
+			// Members edit each other's links, which outlive their creator's membership
+			if _, err := service.UpdateLink(ctx, bob, code, LinkUpdate{URL: repoint}); err != nil {
+				t.Fatalf("UpdateLink() by a member unexpected error = %v", err)
+			}
+			if err := service.RemoveOrgMember(ctx, bob, org.ID, carol.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("RemoveOrgMember() of an outsider error = %v, want ErrNotFound", err)
+			}
+			if err := service.RemoveOrgMember(ctx, alice, org.ID, alice.ID); err != nil {
+				t.Fatalf("RemoveOrgMember() unexpected error = %v", err)
+			}
+			if err := service.RemoveOrgMember(ctx, bob, org.ID, bob.ID); !errors.Is(err, ErrLastMember) {
+				t.Errorf("RemoveOrgMember() of the last member error = %v, want ErrLastMember", err)
+			}
+			if _, err := service.UpdateLink(ctx, alice, code, LinkUpdate{URL: repoint}); !errors.Is(err, ErrForbidden) {
+				t.Errorf("UpdateLink() by a former member error = %v, want ErrForbidden", err)
+			}
+			links, next, err := service.OrgLinks(ctx, bob, org.ID, 0, 0)
+			if err != nil || len(links) != 1 || links[0].ShortCode() != code || links[0].OriginalURL != repoint || next != 0 {
+				t.Errorf("OrgLinks() = %+v, %d, %v, want the repointed link", links, next, err)
+			}
+
+			// Moving the link out gives it back to its creator alone
+			none := uint64(0)
+			if _, err := service.UpdateLink(ctx, bob, code, LinkUpdate{OrgID: &none}); err != nil {
+				t.Fatalf("UpdateLink() out of the organization unexpected error = %v", err)
+			}
+			if _, err := service.UpdateLink(ctx, bob, code, LinkUpdate{URL: repoint}); !errors.Is(err, ErrForbidden) {
+				t.Errorf("UpdateLink() of a link moved out error = %v, want ErrForbidden", err)
+			}
+			if _, err := service.UpdateLink(ctx, alice, code, LinkUpdate{URL: repoint}); err != nil {
+				t.Errorf("UpdateLink() by the creator unexpected error = %v", err)
+			}
+		})
+	}
+}
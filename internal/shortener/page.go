@@ -0,0 +1,113 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/hszk-dev/url-shortener/internal/jobs"
+)
+
+// PageInfo describes the destination page of a link, as fetched in the
+// background after the link was created or its destination changed.
+type PageInfo struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	FaviconURL  string `json:"favicon_url,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// PageFetcher retrieves information about destination pages. Destinations
+// are user-supplied, so implementations must guard against SSRF.
+type PageFetcher interface {
+	FetchPage(ctx context.Context, rawURL string) (*PageInfo, error)
+}
+
+// WithPageFetcher fetches the page of new and changed destinations with f.
+// Fetching is queued as JobFetchPage jobs, run by workers at a time on each
+// instance.
+func WithPageFetcher(f PageFetcher, workers int) Option {
+	return func(s *Service) {
+		s.pages = f
+		s.pageWorkers = workers
+	}
+}
+
+// pageJob is the payload of a JobFetchPage job.
+type pageJob struct {
+	ID uint64 `json:"id"`
+}
+
+// queuePage schedules fetching the page of link without waiting: creating
+// and editing links must not depend on the destination being reachable.
+// Links that burn after reading may lead to one-time pages, which fetching
+// would use up, so theirs are not fetched.
+func (s *Service) queuePage(ctx context.Context, link *Link) {
+	if s.pages == nil || link.BurnAfterReading {
+		return
+	}
+	if err := s.queue.Enqueue(ctx, JobFetchPage, pageJob{ID: link.ID}); err != nil {
+		slog.WarnContext(ctx, "failed to queue page fetch, skipping", "id", link.ID, "error", err)
+	}
+}
+
+// runPageJob fetches the page of the link of a JobFetchPage job. The link is
+// loaded again, so that the page of its current destination is fetched.
+func (s *Service) runPageJob(ctx context.Context, payload json.RawMessage) error {
+	var job pageJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return jobs.Permanent(fmt.Errorf("failed to decode page job: %w", err))
+	}
+	link, err := s.repo.Get(ctx, job.ID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get url %d: %w", job.ID, err)
+	}
+	return s.fetchPage(ctx, link)
+}
+
+// fetchPage stores the page of link, or clears it if the page cannot be
+// fetched so that a changed destination does not keep the old title. Pages
+// that cannot be fetched are not retried; failing to store them is.
+func (s *Service) fetchPage(ctx context.Context, link *Link) error {
+	page, err := s.pages.FetchPage(ctx, link.OriginalURL)
+	if err != nil {
+		slog.DebugContext(ctx, "page fetch failed", "id", link.ID, "error", err)
+		page = nil
+		if link.Page == nil {
+			return nil
+		}
+	}
+	if err := s.repo.SetPage(ctx, link, page); err != nil {
+		return fmt.Errorf("failed to store page of url %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+// marshalPage encodes page for the page column; nil is stored as NULL.
+func marshalPage(page *PageInfo) (string, error) {
+	if page == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// unmarshalPage decodes the page column.
+func unmarshalPage(data []byte) (*PageInfo, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var page PageInfo
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("failed to decode page: %w", err)
+	}
+	return &page, nil
+}
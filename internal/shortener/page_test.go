@@ -0,0 +1,146 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubPageFetcher returns page, or err if set.
+type stubPageFetcher struct {
+	page *PageInfo
+	err  error
+}
+
+func (f *stubPageFetcher) FetchPage(ctx context.Context, rawURL string) (*PageInfo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.page, nil
+}
+
+func TestService_FetchPage(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	fetcher := &stubPageFetcher{page: &PageInfo{Title: "Example", FaviconURL: "https://example.com/favicon.ico"}}
+	service := NewService(repo, WithPageFetcher(fetcher, 1))
+
+	code, err := service.Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+
+	// Shortening only queues the fetch
+	link, err := service.Resolve(ctx, code)
+	if err != nil {
+		t.Fatalf("Resolve() unexpected error = %v", err)
+	}
+	if link.Page != nil {
+		t.Errorf("Page = %+v right after Shorten, want nil", link.Page)
+	}
+	drainJobs(t, service)
+	if link, _ = service.Resolve(ctx, code); link.Page == nil || link.Page.Title != "Example" {
+		t.Errorf("Page = %+v after fetch, want title Example", link.Page)
+	}
+
+	// A destination that cannot be fetched drops the old page
+	fetcher.err = errors.New("connection refused")
+	user := &User{ID: 1}
+	owned, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	fetcher.err = nil
+	drainJobs(t, service)
+	fetcher.err = errors.New("connection refused")
+	if _, err := service.UpdateDestination(ctx, user, owned, "https://example.org"); err != nil {
+		t.Fatalf("UpdateDestination() unexpected error = %v", err)
+	}
+	drainJobs(t, service)
+	if link, _ := service.Resolve(ctx, owned); link.Page != nil {
+		t.Errorf("Page = %+v after failed fetch of new destination, want nil", link.Page)
+	}
+}
+
+func TestService_FetchPageRetry(t *testing.T) {
+	ctx := context.Background()
+	var failSetPage bool
+	var stored *PageInfo
+	repo := &MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			if id == 2 {
+				return nil, ErrNotFound
+			}
+			return &Link{ID: id, OriginalURL: "https://example.com"}, nil
+		},
+		SetPageFunc: func(ctx context.Context, link *Link, page *PageInfo) error {
+			if failSetPage {
+				return errors.New("connection reset")
+			}
+			stored = page
+			return nil
+		},
+	}
+	service := NewService(repo, WithPageFetcher(&stubPageFetcher{page: &PageInfo{Title: "Example"}}, 1))
+
+	// Links deleted before their page is fetched are skipped, pages that
+	// cannot be stored are retried
+	failSetPage = true
+	service.queuePage(ctx, &Link{ID: 1})
+	service.queuePage(ctx, &Link{ID: 2})
+	drainJobs(t, service)
+	status, err := service.Jobs().Status(ctx)
+	if err != nil || len(status) != 1 || status[0].Kind != JobFetchPage || status[0].Queued != 1 || status[0].Retried != 1 || status[0].Succeeded != 1 {
+		t.Fatalf("Jobs().Status() = %+v, %v, want the page of link 1 retried", status, err)
+	}
+	if stored != nil {
+		t.Errorf("stored page %+v, want none", stored)
+	}
+}
+
+func TestService_Jobs(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo, WithPageFetcher(&stubPageFetcher{page: &PageInfo{Title: "Example"}}, 2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Jobs().Run(ctx)
+		close(done)
+	}()
+
+	code, err := service.Shorten(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		link, err := service.Resolve(context.Background(), code)
+		if err != nil {
+			t.Fatalf("Resolve() unexpected error = %v", err)
+		}
+		if link.Page != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("page was not fetched in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Jobs().Run did not return after cancel")
+	}
+}
+
+// drainJobs runs the background jobs queued by service.
+func drainJobs(t *testing.T, service *Service) {
+	t.Helper()
+	if _, err := service.Jobs().Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() unexpected error = %v", err)
+	}
+}
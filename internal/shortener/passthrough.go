@@ -0,0 +1,74 @@
+package shortener
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// QueryPassthrough tells how the query string of a redirect request is
+// carried over to the destination, so that parameters such as the click
+// IDs added by ad platforms survive the hop.
+type QueryPassthrough string
+
+const (
+	// QueryPassthroughOff leaves the destination as it is.
+	QueryPassthroughOff QueryPassthrough = ""
+	// QueryPassthroughMerge adds the parameters of the request the
+	// destination does not have; those it has keep their values.
+	QueryPassthroughMerge QueryPassthrough = "merge"
+	// QueryPassthroughOverride adds all the parameters of the request,
+	// replacing those of the destination with the same name.
+	QueryPassthroughOverride QueryPassthrough = "override"
+)
+
+var ErrInvalidQueryPassthrough = errors.New("invalid query passthrough")
+
+// ParseQueryPassthrough returns the passthrough called name, "" being off.
+// Returns ErrInvalidQueryPassthrough for unknown names.
+func ParseQueryPassthrough(name string) (QueryPassthrough, error) {
+	switch p := QueryPassthrough(name); p {
+	case QueryPassthroughOff, QueryPassthroughMerge, QueryPassthroughOverride:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %q must be %s or %s", ErrInvalidQueryPassthrough, name, QueryPassthroughMerge, QueryPassthroughOverride)
+}
+
+// Apply returns destination with the parameters of query carried over.
+// The query of the destination is kept as it is written, the parameters
+// of query are appended to it in their encoded order. Destinations that do
+// not parse are returned unchanged.
+func (p QueryPassthrough) Apply(destination string, query url.Values) string {
+	if p == QueryPassthroughOff || len(query) == 0 {
+		return destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	kept := slices.DeleteFunc(strings.Split(u.RawQuery, "&"), func(pair string) bool { return pair == "" })
+	add := url.Values{}
+	switch p {
+	case QueryPassthroughMerge:
+		have := u.Query()
+		for key, values := range query {
+			if _, ok := have[key]; !ok {
+				add[key] = values
+			}
+		}
+	case QueryPassthroughOverride:
+		add = query
+		kept = slices.DeleteFunc(kept, func(pair string) bool {
+			key, _, _ := strings.Cut(pair, "=")
+			key, err := url.QueryUnescape(key)
+			return err == nil && query.Has(key)
+		})
+	}
+	if len(add) == 0 {
+		return destination
+	}
+	u.RawQuery = strings.Join(append(kept, add.Encode()), "&")
+	return u.String()
+}
@@ -0,0 +1,117 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseQueryPassthrough(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    QueryPassthrough
+		wantErr bool
+	}{
+		{name: "", want: QueryPassthroughOff},
+		{name: "merge", want: QueryPassthroughMerge},
+		{name: "override", want: QueryPassthroughOverride},
+		{name: "Merge", wantErr: true},
+		{name: "append", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQueryPassthrough(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidQueryPassthrough) {
+					t.Errorf("ParseQueryPassthrough(%q) error = %v, want ErrInvalidQueryPassthrough", tt.name, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseQueryPassthrough(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPassthrough_Apply(t *testing.T) {
+	tests := []struct {
+		name        string
+		passthrough QueryPassthrough
+		destination string
+		query       string
+		want        string
+	}{
+		{name: "off", destination: "https://example.com/", query: "gclid=abc", want: "https://example.com/"},
+		{name: "no query", passthrough: QueryPassthroughMerge, destination: "https://example.com/", want: "https://example.com/"},
+		{name: "merge into no query", passthrough: QueryPassthroughMerge, destination: "https://example.com/sale", query: "gclid=abc&fbclid=x", want: "https://example.com/sale?fbclid=x&gclid=abc"},
+		{name: "merge keeps the destination values", passthrough: QueryPassthroughMerge, destination: "https://example.com/?utm_source=news&ref", query: "utm_source=ads&gclid=abc", want: "https://example.com/?utm_source=news&ref&gclid=abc"},
+		{name: "merge adds nothing", passthrough: QueryPassthroughMerge, destination: "https://example.com/?utm_source=news", query: "utm_source=ads", want: "https://example.com/?utm_source=news"},
+		{name: "override replaces the destination values", passthrough: QueryPassthroughOverride, destination: "https://example.com/?utm_source=news&page=2", query: "utm_source=ads&utm_source=cpc", want: "https://example.com/?page=2&utm_source=ads&utm_source=cpc"},
+		{name: "override of encoded keys", passthrough: QueryPassthroughOverride, destination: "https://example.com/?a%20b=1", query: "a+b=2", want: "https://example.com/?a+b=2"},
+		{name: "fragment stays last", passthrough: QueryPassthroughMerge, destination: "https://example.com/docs#intro", query: "gclid=abc", want: "https://example.com/docs?gclid=abc#intro"},
+		{name: "special characters are encoded", passthrough: QueryPassthroughMerge, destination: "https://example.com/", query: "q=a%26b", want: "https://example.com/?q=a%26b"},
+		{name: "unparsable destination", passthrough: QueryPassthroughMerge, destination: "https://example.com/%zz", query: "gclid=abc", want: "https://example.com/%zz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error = %v", tt.query, err)
+			}
+			if got := tt.passthrough.Apply(tt.destination, query); got != tt.want {
+				t.Errorf("Apply(%q, %q) = %q, want %q", tt.destination, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_QueryPassthrough(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+
+	if _, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{QueryPassthrough: "append"}); !errors.Is(err, ErrInvalidQueryPassthrough) {
+		t.Errorf("ShortenWithOptions() error = %v, want ErrInvalidQueryPassthrough", err)
+	}
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/sale?utm_source=news", ShortenOptions{Owner: alice, QueryPassthrough: QueryPassthroughOverride})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	visitor := Visitor{Query: url.Values{"utm_source": {"ads"}}}
+	merge, off, invalid := QueryPassthroughMerge, QueryPassthroughOff, QueryPassthrough("append")
+
+	steps := []struct {
+		name        string
+		passthrough *QueryPassthrough
+		want        string
+	}{
+		{name: "override", want: "https://example.com/sale?utm_source=ads"},
+		{name: "merge", passthrough: &merge, want: "https://example.com/sale?utm_source=news"},
+		{name: "off", passthrough: &off, want: "https://example.com/sale?utm_source=news"},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.passthrough != nil {
+				if _, err := svc.UpdateLink(ctx, alice, code, LinkUpdate{QueryPassthrough: step.passthrough}); err != nil {
+					t.Fatalf("UpdateLink() error = %v", err)
+				}
+			}
+			route, err := svc.RedirectVisitor(ctx, code, visitor)
+			if err != nil {
+				t.Fatalf("RedirectVisitor() error = %v", err)
+			}
+			if route.URL != step.want {
+				t.Errorf("RedirectVisitor() = %q, want %q", route.URL, step.want)
+			}
+		})
+	}
+
+	if _, err := svc.UpdateLink(ctx, alice, code, LinkUpdate{QueryPassthrough: &invalid}); !errors.Is(err, ErrInvalidQueryPassthrough) {
+		t.Errorf("UpdateLink() error = %v, want ErrInvalidQueryPassthrough", err)
+	}
+}
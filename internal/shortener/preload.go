@@ -0,0 +1,150 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// hotLinksKeyPrefix prefixes the sorted sets scoring link IDs by their
+	// clicks on one UTC day (see hotLinksKey).
+	hotLinksKeyPrefix = "shorturl:hot:"
+	// hotLinksTTL keeps the clicks of a day until the end of the next, so
+	// that PreloadCache looks back at least one full day.
+	hotLinksTTL = 48 * time.Hour
+)
+
+// hotLinksKey returns the key of the sorted set counting clicks on the UTC
+// day of t.
+func hotLinksKey(t time.Time) string {
+	return hotLinksKeyPrefix + t.UTC().Format("20060102")
+}
+
+// WithCachePreload counts the clicks of each link in Redis sorted sets, so
+// that PreloadCache can load the n most clicked links into the caches when
+// an instance starts.
+func WithCachePreload(n int) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.preloadTop = n
+	}
+}
+
+// PreloadCache loads the links clicked most today and yesterday, up to the
+// number set with WithCachePreload, into the local cache and, for those
+// Redis no longer holds, into Redis from the database, and returns how many
+// it loaded. Run before serving, it keeps a cold restart at peak traffic
+// from sending the redirects of the hottest links to the database at once.
+// Deleted and expired links are skipped.
+func (r *PostgresRedisRepository) PreloadCache(ctx context.Context) (int, error) {
+	if r.redis == nil || r.preloadTop <= 0 {
+		return 0, nil
+	}
+	ids, err := r.hotLinkIDs(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Links Redis still holds only need copying into the local cache
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = idCacheKey(id)
+	}
+	var vals []any
+	err = r.retry.do(ctx, "redis", transientRedis, func() error {
+		var err error
+		vals, err = r.redis.MGet(ctx, keys...).Result()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get cached hot links: %w", err)
+	}
+	loaded := 0
+	missing := make([]int64, 0, len(ids))
+	for i, v := range vals {
+		val, ok := v.(string)
+		var link Link
+		if !ok || json.Unmarshal([]byte(val), &link) != nil {
+			missing = append(missing, int64(ids[i]))
+			continue
+		}
+		r.localSet(keys[i], []byte(val), &link)
+		if link.Code != "" {
+			r.localSet(codeCacheKey(link.Code), []byte(val), &link)
+		}
+		loaded++
+	}
+	if len(missing) == 0 {
+		return loaded, nil
+	}
+
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE id = ANY($1) AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > $2)`
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(missing), time.Now())
+	if err != nil {
+		return loaded, fmt.Errorf("failed to load hot urls: %w", err)
+	}
+	links, err := scanLinks(rows, len(missing))
+	if err != nil {
+		return loaded, err
+	}
+	if len(links) == 0 {
+		return loaded, nil
+	}
+	if err := r.loadTargets(ctx, links); err != nil {
+		return loaded, err
+	}
+	// Jittered TTLs, so that the links loaded together do not all expire
+	// together and bring the herd back
+	if err := r.cacheLinks(ctx, links, r.jitteredTTL); err != nil {
+		return loaded, err
+	}
+	for _, link := range links {
+		val, err := json.Marshal(link)
+		if err != nil {
+			return loaded, fmt.Errorf("failed to encode link %d: %w", link.ID, err)
+		}
+		r.localSet(idCacheKey(link.ID), val, link)
+		if link.Code != "" {
+			r.localSet(codeCacheKey(link.Code), val, link)
+		}
+	}
+	cacheMetrics.Add("preloaded", int64(loaded+len(links)))
+	return loaded + len(links), nil
+}
+
+// hotLinkIDs returns the IDs of the links clicked most on the UTC day of
+// now and the day before, most clicked first.
+func (r *PostgresRedisRepository) hotLinkIDs(ctx context.Context, now time.Time) ([]uint64, error) {
+	// The union is stored under a key of its own and dropped in the same
+	// transaction, so that instances starting together do not see each
+	// other's
+	union := hotLinksKeyPrefix + "preload"
+	var top *redis.StringSliceCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(ctx, union, &redis.ZStore{Keys: []string{hotLinksKey(now), hotLinksKey(now.AddDate(0, 0, -1))}})
+		top = pipe.ZRevRange(ctx, union, 0, int64(r.preloadTop-1))
+		pipe.Del(ctx, union)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank hot links: %w", err)
+	}
+	ids := make([]uint64, 0, len(top.Val()))
+	for _, member := range top.Val() {
+		id, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			r.logger.WarnContext(ctx, "invalid hot link id", "id", member, "error", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,146 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultPlan is the plan assigned to new users unless WithPlans names another.
+const DefaultPlan = "free"
+
+// quotaWindow is the period over which Plan.LinksPerDay is counted.
+const quotaWindow = 24 * time.Hour
+
+// Machine-readable quota codes reported by QuotaError.
+const (
+	QuotaDailyLinks    = "daily_link_limit"
+	QuotaTotalLinks    = "total_link_limit"
+	QuotaCustomAliases = "custom_alias_limit"
+)
+
+var (
+	// ErrQuotaExceeded matches every *QuotaError.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrUnknownPlan   = errors.New("unknown plan")
+)
+
+// Plan is a tier of per-user limits. Zero limits are unlimited.
+type Plan struct {
+	// LinksPerDay caps links created in the last 24 hours.
+	LinksPerDay int64
+	// TotalLinks caps the links a user owns at any time.
+	TotalLinks int64
+	// CustomAliases caps the owned links with a user-chosen alias.
+	CustomAliases int64
+}
+
+// Usage is what a user's links count against their plan.
+type Usage struct {
+	Total int64
+	// Recent counts links created since the start of the quota window.
+	Recent        int64
+	CustomAliases int64
+}
+
+// QuotaError reports which plan limit a user has reached.
+type QuotaError struct {
+	// Code is one of the Quota* constants.
+	Code  string
+	Limit int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s of %d reached", e.Code, e.Limit)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// WithPlans enforces the named plans for links created by users; new users
+// get defaultPlan. Users on a plan that is not in plans are held to
+// defaultPlan. Without this option users are unlimited.
+func WithPlans(plans map[string]Plan, defaultPlan string) Option {
+	return func(s *Service) {
+		s.plans = plans
+		s.defaultPlan = defaultPlan
+	}
+}
+
+// plan returns the limits that apply to user.
+func (s *Service) plan(user *User) (Plan, bool) {
+	if plan, ok := s.plans[user.Plan]; ok {
+		return plan, true
+	}
+	plan, ok := s.plans[s.defaultPlan]
+	return plan, ok
+}
+
+// checkQuota returns a *QuotaError if owner may not create another link
+// (with a custom alias if customAlias is set). The check and the following
+// insert are not atomic, so concurrent requests may overshoot a limit slightly.
+func (s *Service) checkQuota(ctx context.Context, owner *User, customAlias bool) error {
+	plan, ok := s.plan(owner)
+	if !ok || plan == (Plan{}) {
+		return nil
+	}
+
+	usage, err := s.repo.UsageByOwner(ctx, owner.ID, s.now().Add(-quotaWindow))
+	if err != nil {
+		return fmt.Errorf("failed to check quota: %w", err)
+	}
+	return plan.check(usage, customAlias)
+}
+
+// check returns a *QuotaError if another link (with a custom alias if
+// customAlias is set) would take usage over a limit of p.
+func (p Plan) check(usage *Usage, customAlias bool) error {
+	switch {
+	case p.TotalLinks > 0 && usage.Total >= p.TotalLinks:
+		return &QuotaError{Code: QuotaTotalLinks, Limit: p.TotalLinks}
+	case p.LinksPerDay > 0 && usage.Recent >= p.LinksPerDay:
+		return &QuotaError{Code: QuotaDailyLinks, Limit: p.LinksPerDay}
+	case customAlias && p.CustomAliases > 0 && usage.CustomAliases >= p.CustomAliases:
+		return &QuotaError{Code: QuotaCustomAliases, Limit: p.CustomAliases}
+	}
+	return nil
+}
+
+// checkRestoreQuota is checkQuota for bringing back a deleted link: the link
+// counts towards the total (and the custom aliases if customAlias is set)
+// again, but was already counted against the daily allowance when created.
+func (s *Service) checkRestoreQuota(ctx context.Context, owner *User, customAlias bool) error {
+	plan, ok := s.plan(owner)
+	if !ok || plan == (Plan{}) {
+		return nil
+	}
+
+	usage, err := s.repo.UsageByOwner(ctx, owner.ID, s.now().Add(-quotaWindow))
+	if err != nil {
+		return fmt.Errorf("failed to check quota: %w", err)
+	}
+	switch {
+	case plan.TotalLinks > 0 && usage.Total >= plan.TotalLinks:
+		return &QuotaError{Code: QuotaTotalLinks, Limit: plan.TotalLinks}
+	case customAlias && plan.CustomAliases > 0 && usage.CustomAliases >= plan.CustomAliases:
+		return &QuotaError{Code: QuotaCustomAliases, Limit: plan.CustomAliases}
+	}
+	return nil
+}
+
+// SetPlan moves the user userID to plan. Returns ErrUnknownPlan if plans are
+// enforced and plan is not one of them, or ErrNotFound for unknown users.
+func (s *Service) SetPlan(ctx context.Context, userID uint64, plan string) error {
+	if _, ok := s.plans[plan]; !ok && (len(s.plans) > 0 || plan == "") {
+		return ErrUnknownPlan
+	}
+	if err := s.repo.SetUserPlan(ctx, userID, plan); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to set plan: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestService_Quotas(t *testing.T) {
+	plans := map[string]Plan{
+		"free": {LinksPerDay: 2, TotalLinks: 3, CustomAliases: 1},
+		"pro":  {},
+	}
+
+	tests := []struct {
+		name string
+		// aliases are the custom aliases of the links created in order ("" for
+		// a generated code); wantCode is the quota code expected for the last
+		// one, or "" if it should succeed.
+		aliases  []string
+		wantCode string
+	}{
+		{name: "within limits", aliases: []string{"", "spring-sale"}},
+		{name: "daily limit", aliases: []string{"", "", ""}, wantCode: QuotaDailyLinks},
+		{name: "alias allowance", aliases: []string{"spring-sale", "summer-sale"}, wantCode: QuotaCustomAliases},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(NewInMemoryRepository(), WithPlans(plans, "free"))
+			ctx := context.Background()
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			if user.Plan != "free" {
+				t.Errorf("Signup() plan = %q, want free", user.Plan)
+			}
+
+			var lastErr error
+			for i, alias := range tt.aliases {
+				_, lastErr = service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{CustomAlias: alias, Owner: user})
+				if i < len(tt.aliases)-1 && lastErr != nil {
+					t.Fatalf("ShortenWithOptions() #%d unexpected error = %v", i, lastErr)
+				}
+			}
+
+			var quotaErr *QuotaError
+			switch {
+			case tt.wantCode == "" && lastErr != nil:
+				t.Errorf("ShortenWithOptions() unexpected error = %v", lastErr)
+			case tt.wantCode != "" && (!errors.As(lastErr, &quotaErr) || quotaErr.Code != tt.wantCode):
+				t.Errorf("ShortenWithOptions() error = %v, want %s", lastErr, tt.wantCode)
+			case tt.wantCode != "" && !errors.Is(lastErr, ErrQuotaExceeded):
+				t.Errorf("ShortenWithOptions() error = %v does not match ErrQuotaExceeded", lastErr)
+			}
+		})
+	}
+}
+
+func TestService_QuotaWindowAndTotal(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo, WithPlans(map[string]Plan{"free": {LinksPerDay: 2, TotalLinks: 3}}, "free"))
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user}); err != nil {
+			t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+		}
+	}
+
+	// A day later the daily allowance is back but the total limit applies
+	service.now = func() time.Time { return time.Now().Add(quotaWindow + time.Minute) }
+	if _, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user}); err != nil {
+		t.Fatalf("ShortenWithOptions() next day unexpected error = %v", err)
+	}
+	var quotaErr *QuotaError
+	if _, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user}); !errors.As(err, &quotaErr) || quotaErr.Code != QuotaTotalLinks || quotaErr.Limit != 3 {
+		t.Errorf("ShortenWithOptions() error = %v, want %s of 3", err, QuotaTotalLinks)
+	}
+
+	// Anonymous links are not subject to plans
+	if _, err := service.Shorten(ctx, "https://example.com"); err != nil {
+		t.Errorf("Shorten() anonymous unexpected error = %v", err)
+	}
+
+	// Upgrading lifts the limit; unknown plans are rejected
+	if err := service.SetPlan(ctx, user.ID, "enterprise"); !errors.Is(err, ErrUnknownPlan) {
+		t.Errorf("SetPlan() unknown plan error = %v, want ErrUnknownPlan", err)
+	}
+	service.plans["unlimited"] = Plan{}
+	if err := service.SetPlan(ctx, user.ID, "unlimited"); err != nil {
+		t.Fatalf("SetPlan() unexpected error = %v", err)
+	}
+	user, err = repo.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail() unexpected error = %v", err)
+	}
+	if _, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user}); err != nil {
+		t.Errorf("ShortenWithOptions() after upgrade unexpected error = %v", err)
+	}
+	if err := service.SetPlan(ctx, 999, "unlimited"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetPlan() unknown user error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestService_QuotaUnknownPlanFallsBackToDefault(t *testing.T) {
+	repo := &MockRepository{
+		UsageByOwnerFunc: func(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+			return &Usage{Total: 1}, nil
+		},
+	}
+	service := NewService(repo, WithPlans(map[string]Plan{"free": {TotalLinks: 1}}, "free"))
+
+	_, err := service.ShortenWithOptions(context.Background(), "https://example.com", ShortenOptions{Owner: &User{ID: 1, Plan: "retired"}})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("ShortenWithOptions() error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestService_QuotaDeletedLinks(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			plans := map[string]Plan{
+				"free":  {TotalLinks: 2, CustomAliases: 1},
+				"daily": {LinksPerDay: 1},
+			}
+			service := NewService(newRepo(t), WithPlans(plans, "free"))
+			ctx := context.Background()
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			shorten := func(alias string) (string, error) {
+				return service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{CustomAlias: alias, Owner: user})
+			}
+			wantQuota := func(op string, err error, code string) {
+				t.Helper()
+				var quotaErr *QuotaError
+				if !errors.As(err, &quotaErr) || quotaErr.Code != code {
+					t.Errorf("%s error = %v, want %s", op, err, code)
+				}
+			}
+
+			if _, err := shorten("spring-sale"); err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			generated, err := shorten("")
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+			_, err = shorten("")
+			wantQuota("ShortenWithOptions() over the total", err, QuotaTotalLinks)
+
+			// Deleted links free their slot, for links and aliases alike
+			if err := service.Delete(ctx, user, generated); err != nil {
+				t.Fatalf("Delete() unexpected error = %v", err)
+			}
+			if err := service.Delete(ctx, user, "spring-sale"); err != nil {
+				t.Fatalf("Delete() unexpected error = %v", err)
+			}
+			if _, err := shorten("summer-sale"); err != nil {
+				t.Fatalf("ShortenWithOptions() after Delete() unexpected error = %v", err)
+			}
+
+			// Restoring takes the slot back, so it must fit in the plan
+			_, err = service.Restore(ctx, user, "spring-sale")
+			wantQuota("Restore() over the alias allowance", err, QuotaCustomAliases)
+			if _, err := service.Restore(ctx, user, generated); err != nil {
+				t.Fatalf("Restore() unexpected error = %v", err)
+			}
+			if err := service.Delete(ctx, user, "summer-sale"); err != nil {
+				t.Fatalf("Delete() unexpected error = %v", err)
+			}
+			if _, err := service.Restore(ctx, user, "spring-sale"); err != nil {
+				t.Errorf("Restore() within the plan unexpected error = %v", err)
+			}
+			_, err = service.Restore(ctx, user, "summer-sale")
+			wantQuota("Restore() over the total", err, QuotaTotalLinks)
+
+			// Deleting does not give back the day's allowance
+			if err := service.SetPlan(ctx, user.ID, "daily"); err != nil {
+				t.Fatalf("SetPlan() unexpected error = %v", err)
+			}
+			user.Plan = "daily"
+			_, err = shorten("")
+			wantQuota("ShortenWithOptions() over the daily allowance", err, QuotaDailyLinks)
+		})
+	}
+}
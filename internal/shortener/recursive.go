@@ -0,0 +1,123 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrRecursiveURL is returned for destinations that are themselves short
+	// links, of this service or of a known shortener.
+	ErrRecursiveURL = errors.New("destination is a short link")
+	// ErrRedirectLoop is returned when following a link leads back to it
+	// through other links of this service, or through more than
+	// MaxRedirectHops of them.
+	ErrRedirectLoop = errors.New("redirect loop")
+)
+
+// MaxRedirectHops is the number of links of this service a redirect may go
+// through after the first before it is taken for a loop.
+const MaxRedirectHops = 5
+
+// WithBaseURL sets the URL short codes are served under, so that links to
+// it are refused as destinations and redirect chains through it are
+// followed for loops.
+func WithBaseURL(baseURL string) Option {
+	return func(s *Service) {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Host == "" {
+			return
+		}
+		s.baseHost = strings.ToLower(u.Hostname())
+		s.basePath = strings.TrimSuffix(u.Path, "/") + "/"
+	}
+}
+
+// WithKnownShorteners refuses destinations on hosts, other URL shorteners,
+// or their subdomains, whose links could hide where a link leads.
+func WithKnownShorteners(hosts []string) Option {
+	return func(s *Service) {
+		s.shorteners = make([]string, 0, len(hosts))
+		for _, h := range hosts {
+			s.shorteners = append(s.shorteners, strings.TrimSuffix(strings.ToLower(h), "."))
+		}
+	}
+}
+
+// checkRecursive rejects rawURL with ErrRecursiveURL if its host is the
+// base URL of the service, one of its verified custom domains or a known
+// shortener. URLs that do not parse are left to the other checks.
+func (s *Service) checkRecursive(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if host == s.baseHost {
+		return fmt.Errorf("%w: %s is this service", ErrRecursiveURL, host)
+	}
+	for _, k := range s.shorteners {
+		if host == k || strings.HasSuffix(host, "."+k) {
+			return fmt.Errorf("%w: %s is a URL shortener", ErrRecursiveURL, host)
+		}
+	}
+	_, err = s.repo.GetDomainByHost(ctx, host)
+	if err == nil {
+		return fmt.Errorf("%w: %s is a custom domain of this service", ErrRecursiveURL, host)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to look up domain: %w", err)
+	}
+	return nil
+}
+
+// ownCode returns the short code rawURL points at if it is a short link on
+// the base URL of the service.
+func (s *Service) ownCode(rawURL string) (string, bool) {
+	if s.baseHost == "" {
+		return "", false
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".") != s.baseHost {
+		return "", false
+	}
+	code, ok := strings.CutPrefix(parsed.Path, s.basePath)
+	if !ok || code == "" || strings.Contains(code, "/") {
+		return "", false
+	}
+	return code, true
+}
+
+// checkChain follows route, where v is sent by link at shortCode, through the links of
+// the service it points at, as v would be sent, and returns ErrRedirectLoop
+// if that comes back to a link already passed or goes through more than
+// MaxRedirectHops. Links created before their destination was refused, or
+// under another base URL, can form such chains. Chains through custom
+// domains are not followed, as that would cost a lookup on every redirect.
+func (s *Service) checkChain(ctx context.Context, shortCode string, link *Link, route Route, v Visitor) error {
+	seen := map[uint64]bool{link.ID: true}
+	for hops := 0; ; hops++ {
+		code, ok := s.ownCode(route.URL)
+		if !ok {
+			return nil
+		}
+		next, err := s.Resolve(ctx, code)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidShortCode) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to follow redirect chain: %w", err)
+		}
+		if seen[next.ID] {
+			return fmt.Errorf("%w: %s comes back through %s", ErrRedirectLoop, shortCode, code)
+		}
+		if hops >= MaxRedirectHops {
+			return fmt.Errorf("%w: %s goes through more than %d links", ErrRedirectLoop, shortCode, MaxRedirectHops)
+		}
+		seen[next.ID] = true
+		route = s.pickRoute(next, v)
+	}
+}
@@ -0,0 +1,178 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestService_RecursiveURL(t *testing.T) {
+	ctx := context.Background()
+	resolver := stubResolver{}
+	svc := NewService(NewInMemoryRepository(), WithTXTResolver(resolver),
+		WithBaseURL("https://sho.rt"), WithKnownShorteners([]string{"bit.ly", "T.co"}))
+
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	d, err := svc.AddDomain(ctx, alice, "go.example.com")
+	if err != nil {
+		t.Fatalf("AddDomain() error = %v", err)
+	}
+	name, value := d.VerificationRecord()
+	resolver[name] = []string{value}
+	if _, err := svc.VerifyDomain(ctx, alice, d.ID); err != nil {
+		t.Fatalf("VerifyDomain() error = %v", err)
+	}
+	if _, err := svc.AddDomain(ctx, alice, "unverified.example.com"); err != nil {
+		t.Fatalf("AddDomain() error = %v", err)
+	}
+
+	tests := []struct {
+		url       string
+		recursive bool
+	}{
+		{"https://sho.rt/abc", true},
+		{"http://SHO.RT.:8080/abc", true},
+		{"https://bit.ly/xyz", true},
+		{"https://t.co/xyz", true},
+		{"https://www.bit.ly/xyz", true},
+		{"https://go.example.com/abc", true},
+		{"https://unverified.example.com/abc", false},
+		{"https://notbit.ly/xyz", false},
+		{"https://sho.rt.example.com/", false},
+		{"https://example.com/?next=https://bit.ly/xyz", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, err := svc.Shorten(ctx, tt.url)
+			if recursive := errors.Is(err, ErrRecursiveURL); recursive != tt.recursive {
+				t.Errorf("Shorten(%q) error = %v, want recursive %v", tt.url, err, tt.recursive)
+			}
+			if !tt.recursive && err != nil {
+				t.Errorf("Shorten(%q) unexpected error = %v", tt.url, err)
+			}
+		})
+	}
+
+	// Changed destinations are checked like new ones
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := svc.UpdateLink(ctx, alice, code, LinkUpdate{URL: "https://sho.rt/" + code}); !errors.Is(err, ErrRecursiveURL) {
+		t.Errorf("UpdateLink() error = %v, want ErrRecursiveURL", err)
+	}
+}
+
+func TestService_RedirectChain(t *testing.T) {
+	tests := []struct {
+		name string
+		// links is the number of links chained, each pointing at the next
+		links int
+		// loop points the last link back at the first
+		loop bool
+		// missing points the last link at a code that does not exist
+		missing bool
+		wantErr bool
+	}{
+		{name: "single link", links: 1},
+		{name: "short chain", links: 3},
+		{name: "longest chain", links: MaxRedirectHops + 1},
+		{name: "too long chain", links: MaxRedirectHops + 2, wantErr: true},
+		{name: "self loop", links: 1, loop: true, wantErr: true},
+		{name: "loop", links: 3, loop: true, wantErr: true},
+		{name: "dangling", links: 2, missing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := NewInMemoryRepository()
+			// Links created before the base URL was configured may point
+			// at each other
+			creator := NewService(repo)
+			svc := NewService(repo, WithBaseURL("https://sho.rt/s/"))
+
+			alice, _, err := creator.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() error = %v", err)
+			}
+			codes := make([]string, tt.links)
+			for i := range codes {
+				codes[i], err = creator.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Owner: alice})
+				if err != nil {
+					t.Fatalf("ShortenWithOptions() error = %v", err)
+				}
+			}
+			for i, code := range codes[:len(codes)-1] {
+				if _, err := creator.UpdateLink(ctx, alice, code, LinkUpdate{URL: "https://sho.rt/s/" + codes[i+1]}); err != nil {
+					t.Fatalf("UpdateLink() error = %v", err)
+				}
+			}
+			switch {
+			case tt.loop:
+				_, err = creator.UpdateLink(ctx, alice, codes[len(codes)-1], LinkUpdate{URL: "https://sho.rt/s/" + codes[0]})
+			case tt.missing:
+				_, err = creator.UpdateLink(ctx, alice, codes[len(codes)-1], LinkUpdate{URL: "https://sho.rt/s/zzzzzz"})
+			}
+			if err != nil {
+				t.Fatalf("UpdateLink() error = %v", err)
+			}
+
+			route, err := svc.RedirectVisitor(ctx, codes[0], Visitor{})
+			if tt.wantErr {
+				if !errors.Is(err, ErrRedirectLoop) {
+					t.Errorf("RedirectVisitor() error = %v, want ErrRedirectLoop", err)
+				}
+				if _, err := svc.Peek(ctx, codes[0], Visitor{}); !errors.Is(err, ErrRedirectLoop) {
+					t.Errorf("Peek() error = %v, want ErrRedirectLoop", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+			}
+			if tt.links > 1 && !strings.HasPrefix(route.URL, "https://sho.rt/s/") {
+				t.Errorf("RedirectVisitor() = %q, want the next link of the chain", route.URL)
+			}
+		})
+	}
+}
+
+func TestService_RedirectLoopTakesNoClick(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	creator := NewService(repo)
+	svc := NewService(repo, WithBaseURL("https://sho.rt/s/"))
+	alice, _, err := creator.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	code, err := creator.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{Owner: alice, MaxClicks: 1})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := creator.UpdateLink(ctx, alice, code, LinkUpdate{URL: "https://sho.rt/s/" + code}); err != nil {
+		t.Fatalf("UpdateLink() error = %v", err)
+	}
+	for range 3 {
+		if _, err := svc.RedirectVisitor(ctx, code, Visitor{}); !errors.Is(err, ErrRedirectLoop) {
+			t.Fatalf("RedirectVisitor() error = %v, want ErrRedirectLoop", err)
+		}
+	}
+
+	// The loop fixed, the single click of the link is still there
+	if _, err := creator.UpdateLink(ctx, alice, code, LinkUpdate{URL: "https://example.com/fixed"}); err != nil {
+		t.Fatalf("UpdateLink() error = %v", err)
+	}
+	if route, err := svc.RedirectVisitor(ctx, code, Visitor{}); err != nil || route.URL != "https://example.com/fixed" {
+		t.Errorf("RedirectVisitor() = %+v, %v, want the fixed destination", route, err)
+	}
+	if _, err := svc.RedirectVisitor(ctx, code, Visitor{}); !errors.Is(err, ErrClickLimitReached) {
+		t.Errorf("RedirectVisitor() error = %v, want ErrClickLimitReached", err)
+	}
+}
@@ -0,0 +1,115 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxReportReasonLength caps the free-text reason of an abuse report (in runes).
+const MaxReportReasonLength = 500
+
+// ErrReasonTooLong is returned by Report for reasons over MaxReportReasonLength.
+var ErrReasonTooLong = fmt.Errorf("reason must be at most %d characters", MaxReportReasonLength)
+
+// reportColumns is the column list scanned by scanReport. Queries alias
+// abuse_reports as r and join urls as u for the link's custom alias.
+const reportColumns = `r.id, r.url_id, u.code, r.reason, r.reporter, r.created_at`
+
+// Report is an end-user abuse report against a link.
+type Report struct {
+	ID     uint64 `json:"id"`
+	LinkID uint64 `json:"link_id"`
+	// LinkCode is the custom alias of the reported link, if any; see ShortCode.
+	LinkCode string `json:"-"`
+	Reason   string `json:"reason,omitempty"`
+	// Reporter identifies the reporting client (its IP address) so that
+	// repeated reports from one client count once.
+	Reporter  string    `json:"reporter"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShortCode returns the public short code of the reported link.
+func (r *Report) ShortCode() string {
+	if r.LinkCode != "" {
+		return r.LinkCode
+	}
+	return Encode(r.LinkID)
+}
+
+// WithReportThreshold suspends links (StatusReported) once they have n open
+// abuse reports from distinct reporters. Zero disables automatic suspension.
+func WithReportThreshold(n int64) Option {
+	return func(s *Service) {
+		s.reportThreshold = n
+	}
+}
+
+// Report records an abuse report from reporter against the link behind
+// shortCode. If the link reaches the report threshold it is suspended until
+// an administrator reviews it with SetStatus.
+func (s *Service) Report(ctx context.Context, shortCode, reason, reporter string) error {
+	if utf8.RuneCountInString(reason) > MaxReportReasonLength {
+		return ErrReasonTooLong
+	}
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return err
+	}
+
+	open, err := s.repo.AddReport(ctx, &Report{LinkID: link.ID, Reason: reason, Reporter: reporter})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to save report: %w", err)
+	}
+
+	// Only suspend links that are live; disabled or flagged links already
+	// do not redirect and reported ones are already queued for review.
+	if s.reportThreshold <= 0 || open < s.reportThreshold || (link.Status != "" && link.Status != StatusActive) {
+		return nil
+	}
+	if err := s.repo.SetStatus(ctx, link, StatusReported); err != nil {
+		return fmt.Errorf("failed to suspend reported url: %w", err)
+	}
+	slog.WarnContext(ctx, "suspended reported link", "short_code", link.ShortCode(), "reports", open)
+	s.audit(ctx, ActorSystem, AuditSuspend, link.ShortCode(), fmt.Sprintf("%d open reports", open))
+	return nil
+}
+
+// ListReports returns a page of open abuse reports, newest first, in the
+// same way as List.
+func (s *Service) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, uint64, error) {
+	reports, next, err := paginate(limit, func(n int) ([]*Report, error) {
+		return s.repo.ListReports(ctx, cursor, n)
+	}, func(r *Report) uint64 { return r.ID })
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return reports, next, nil
+}
+
+// scanReports scans and closes rows selected with reportColumns.
+func scanReports(rows *sql.Rows, limit int) ([]*Report, error) {
+	defer rows.Close()
+
+	reports := make([]*Report, 0, limit)
+	for rows.Next() {
+		var report Report
+		var code sql.NullString
+		if err := rows.Scan(&report.ID, &report.LinkID, &code, &report.Reason, &report.Reporter, &report.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan report: %w", err)
+		}
+		report.LinkCode = code.String
+		reports = append(reports, &report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reports: %w", err)
+	}
+	return reports, nil
+}
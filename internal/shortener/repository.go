@@ -3,53 +3,760 @@ package shortener
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hszk-dev/url-shortener/internal/lru"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 )
 
 var (
 	ErrNotFound = errors.New("url not found")
+	ErrConflict = errors.New("short code already exists")
 )
 
+// maxSaveAttempts bounds how many sequence values Save will skip when an ID
+// has already been claimed by a pinned custom alias.
+const maxSaveAttempts = 5
+
+// purgeBatchSize limits how many expired rows PurgeExpired deletes per statement
+// so that a large backlog does not hold locks for long.
+const purgeBatchSize = 1000
+
+// flushBatchSize limits how many dirty click counters FlushClicks pops at a time.
+const flushBatchSize = 500
+
+// rollupBatchSize limits how many raw clicks RollupClicks folds per
+// transaction in backends that store them.
+const rollupBatchSize = 5000
+
+const (
+	// clicksKeyPrefix prefixes the live click counter of a link (see clicksKey).
+	clicksKeyPrefix = "shorturl:clicks:"
+	// dirtyClicksKey is the set of link IDs whose counter has not been flushed.
+	dirtyClicksKey = "shorturl:clicks:dirty"
+	// pendingClicksKey is a hash of click counts by "<id>:<unix hour>", and
+	// of their sources by "<id>:<unix hour>:<dimension>:<value>", that have
+	// not been rolled up; RollupClicks renames it to rollupClicksKey while
+	// processing it.
+	pendingClicksKey = "shorturl:clicks:pending"
+	rollupClicksKey  = "shorturl:clicks:rollup"
+)
+
+// incrementClicksScript seeds the counter from the persisted count if it does
+// not exist yet, increments it and marks the link for flushing, atomically.
+var incrementClicksScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+local n = redis.call("INCR", KEYS[1])
+redis.call("SADD", KEYS[2], ARGV[2])
+return n
+`)
+
+// consumedKeyPrefix prefixes the marker of a link that burns after reading
+// and has been followed (see consumedKey).
+const consumedKeyPrefix = "shorturl:consumed:"
+
+// consumeScript sets the consumed marker of a link, which expires after
+// ARGV[2] milliseconds, and reports whether it was not set already.
+var consumeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+const (
+	// DefaultCacheTTL is the default lifetime of a link cached in Redis.
+	DefaultCacheTTL = 24 * time.Hour
+	// DefaultCacheJitter is the default fraction by which cache TTLs are
+	// randomly shortened or lengthened.
+	DefaultCacheJitter = 0.1
+)
+
+// idBlockLockKey is the advisory lock serializing ReserveIDs.
+const idBlockLockKey = 0x75726c73 // "urls"
+
+const (
+	// pgUniqueViolation is the PostgreSQL SQLSTATE for unique constraint violations.
+	pgUniqueViolation = "23505"
+	// pgForeignKeyViolation is the PostgreSQL SQLSTATE for foreign key violations.
+	pgForeignKeyViolation = "23503"
+	// pgPrimaryKeyConstraint is the default name of the urls primary key.
+	pgPrimaryKeyConstraint = "urls_pkey"
+)
+
+// linkColumns is the column list scanned by scanLink.
+const linkColumns = `id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access`
+
 type Repository interface {
-	Save(ctx context.Context, originalURL string) (uint64, error)
-	Get(ctx context.Context, id uint64) (string, error)
+	// Save stores link and returns its ID.
+	// If link.ID is non-zero the row is stored under that ID, and if link.Code
+	// is set it is stored in the unique code column.
+	// Returns ErrConflict if the explicit ID or code is already in use.
+	// On success link.ID and link.CreatedAt are populated.
+	Save(ctx context.Context, link *Link) (uint64, error)
+	// SaveAlias is Save for a link with a custom alias, in a transaction
+	// that first checks the short code of link against the alias
+	// reservations. Returns ErrAliasReserved if a reservation that is not
+	// held by the organization of link matches it. Backends without
+	// transactions check the reservations right before saving.
+	SaveAlias(ctx context.Context, link *Link) (uint64, error)
+	// NextID allocates the next sequential ID without storing a link, for
+	// code strategies that derive a pinned ID from it.
+	NextID(ctx context.Context) (uint64, error)
+	// ReserveIDs allocates n consecutive sequential IDs [first, first+n)
+	// without storing links, for code strategies that hand out IDs from
+	// blocks reserved up front.
+	ReserveIDs(ctx context.Context, n uint64) (first uint64, err error)
+	Get(ctx context.Context, id uint64) (*Link, error)
+	GetByCode(ctx context.Context, code string) (*Link, error)
+	// List returns up to limit links with an ID lower than cursor, newest
+	// (highest ID) first. A zero cursor starts from the most recent link.
+	List(ctx context.Context, cursor uint64, limit int) ([]*Link, error)
+	// Search is List restricted to links whose destination contains query
+	// (case-insensitive).
+	Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error)
+	// ListByOwner is List restricted to links owned by the user ownerID.
+	ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error)
+	// Update persists the destination, expiry, click limit, campaign, domain
+	// and analytics opt-out of link and invalidates its cache entries. Returns ErrNotFound if
+	// the link no longer exists.
+	Update(ctx context.Context, link *Link) error
+	// SetTargets replaces the country targets of link and invalidates its
+	// cache entries.
+	SetTargets(ctx context.Context, link *Link, targets []Target) error
+	// SetRules replaces the routing rules of link and invalidates its cache
+	// entries. Returns ErrNotFound if the link no longer exists.
+	SetRules(ctx context.Context, link *Link, rules []Rule) error
+	// SetExperiment replaces the experiment of link (nil removes it) and
+	// invalidates its cache entries. Returns ErrNotFound if the link no
+	// longer exists.
+	SetExperiment(ctx context.Context, link *Link, e *Experiment) error
+	// SetPage replaces the destination page information of link (nil
+	// removes it) and invalidates its cache entries. Returns ErrNotFound if
+	// the link no longer exists.
+	SetPage(ctx context.Context, link *Link, page *PageInfo) error
+	// SetMetadata replaces the metadata of link (nil removes it).
+	SetMetadata(ctx context.Context, link *Link, m *Metadata) error
+	// SetAccess replaces the access rules of link (nil removes them) and
+	// invalidates its cache entries. Returns ErrNotFound if the link no
+	// longer exists.
+	SetAccess(ctx context.Context, link *Link, a *Access) error
+	// RecordVariant counts that the experiment variant name of link was served.
+	RecordVariant(ctx context.Context, link *Link, name string) error
+	// VariantStats returns how often each variant of link has been served.
+	// Variants never served are absent.
+	VariantStats(ctx context.Context, link *Link) (map[string]int64, error)
+	// SetHealth stores h as the outcome of the latest health check of link.
+	SetHealth(ctx context.Context, link *Link, h *Health) error
+	// ListHealth returns the latest health check of each of links, by link
+	// ID. Links never checked are absent.
+	ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error)
+	// HealthStats counts the links that are not deleted by the status of
+	// their latest health check.
+	HealthStats(ctx context.Context) (*HealthCounts, error)
+	// Delete soft-deletes link and drops its cache entries: lookups, lists
+	// and stats treat it as gone but its code stays reserved. Returns
+	// ErrNotFound if the link does not exist or is already deleted.
+	Delete(ctx context.Context, link *Link) error
+	// Restore undoes the deletion of link, identified by ID or, if that is
+	// zero, by Code. Returns ErrNotFound unless it is a deleted link owned
+	// by ownerID.
+	Restore(ctx context.Context, link *Link, ownerID uint64) error
+	// SetStatus changes the status of link and invalidates its cache entries.
+	// Returns ErrNotFound if the link no longer exists.
+	SetStatus(ctx context.Context, link *Link, status Status) error
+	// Invalidate drops any cached copies of link so the next lookup reads
+	// the database.
+	Invalidate(ctx context.Context, link *Link) error
+	// Stats returns aggregate counts over all links, treating links that
+	// expired at or before now as expired.
+	Stats(ctx context.Context, now time.Time) (*Stats, error)
+	// AddReport stores an abuse report against report.LinkID unless the same
+	// reporter already has an open report for that link, and returns the
+	// number of open reports for the link. Returns ErrNotFound if the link
+	// does not exist.
+	AddReport(ctx context.Context, report *Report) (int64, error)
+	// ListReports returns up to limit open reports with an ID lower than
+	// cursor, newest first. A zero cursor starts from the most recent report.
+	ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error)
+	// ResolveReports closes all open reports for link as of now.
+	ResolveReports(ctx context.Context, link *Link, now time.Time) error
+	// AddAudit appends entry to the audit log and populates its ID.
+	AddAudit(ctx context.Context, entry *AuditEntry) error
+	// ListAudit returns up to limit audit entries matching f with an ID
+	// lower than cursor, newest first. A zero cursor starts from the most
+	// recent entry.
+	ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error)
+	// AddLinkChange records a change of the destination of link and
+	// populates the ID of change.
+	AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error
+	// ListLinkChanges returns up to limit destination changes of link with
+	// an ID below cursor (all if cursor is 0), newest first.
+	ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error)
+	// GetLinkChange returns the destination change of link with ID id.
+	// Returns ErrNotFound if link has no such change.
+	GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error)
+	// CreateUser stores user and populates its ID and CreatedAt. Returns
+	// ErrConflict if the email is already registered.
+	CreateUser(ctx context.Context, user *User) error
+	// GetUserByEmail returns the user registered with email, or ErrNotFound.
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// CreateAPIKey stores key, whose KeyHash is set, for the user
+	// key.UserID and sets its ID and CreatedAt.
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	// GetUserByAPIKey returns the user holding the API key with keyHash and
+	// the key, expired or not, or ErrNotFound.
+	GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error)
+	// ListAPIKeys returns the API keys of the user userID, expired or not,
+	// oldest first.
+	ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error)
+	// TouchAPIKey records that the API key with keyHash was used at at.
+	// Unknown keys are ignored.
+	TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error
+	// ExpireAPIKey makes the API key with keyHash expire at at, or returns
+	// ErrNotFound.
+	ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error
+	// DeleteAPIKey revokes the API key with keyHash. Unknown keys are ignored.
+	DeleteAPIKey(ctx context.Context, keyHash string) error
+	// CreateIdentity links the subject of an external identity provider,
+	// identified by its issuer, to the user userID. Returns ErrConflict if
+	// the subject is already linked.
+	CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error
+	// LookupIdentity returns the user the subject of issuer is linked to, or
+	// ErrNotFound.
+	LookupIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	// SetUserPlan moves the user userID to plan. Returns ErrNotFound if the
+	// user does not exist.
+	SetUserPlan(ctx context.Context, userID uint64, plan string) error
+	// SetUserRole gives the user userID role. Returns ErrNotFound if the
+	// user does not exist.
+	SetUserRole(ctx context.Context, userID uint64, role string) error
+	// UsageByOwner counts the links owned by ownerID, counting links created
+	// after since as recent. Deleted links do not count towards the total or
+	// the custom aliases, but do count as recent, so that deleting a link does
+	// not give back the day's allowance.
+	UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error)
+	// IncrementClicks atomically counts a redirect of link and returns the
+	// new total. It is only used for links with a click limit.
+	IncrementClicks(ctx context.Context, link *Link) (int64, error)
+	// Consume marks link, which burns after reading, as followed at the
+	// given time. It reports true to exactly one caller, and false once link
+	// has been consumed. Returns ErrNotFound if link no longer exists.
+	Consume(ctx context.Context, link *Link, at time.Time) (bool, error)
+	// FlushClicks persists click counts that are only held in memory or
+	// cache, returning the number of links updated. Backends that write
+	// counts through on every increment return 0.
+	FlushClicks(ctx context.Context) (int, error)
+	// RecordClick counts a redirect of link from src at the given time for
+	// its click time series and source breakdown. Clicks may be buffered
+	// until RollupClicks.
+	RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error
+	// RollupClicks adds the clicks recorded since the last call to the
+	// hourly and daily rollups and returns how many it processed.
+	RollupClicks(ctx context.Context) (int, error)
+	// ClickSeries returns the rollup buckets of link at granularity that
+	// start in [from, to) and have clicks, oldest first.
+	ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error)
+	// ClickSources returns the clicks of link per source dimension and value
+	// in the days starting in [from, to), ordered by dimension and then by
+	// clicks, most first.
+	ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error)
+	// PurgeClicks deletes the buckets of the rollup at granularity that
+	// start before before, together with the source counts of those days
+	// for GranularityDay, and returns the number of rows removed.
+	PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error)
+	// DeleteClicks deletes the rollup buckets and source counts of link and
+	// returns the number of rows removed. Clicks not rolled up yet are kept.
+	DeleteClicks(ctx context.Context, link *Link) (int, error)
+	// TopStats returns the clicks in the rollup at granularity for buckets
+	// starting in [from, to), the links created in that range and the limit
+	// most clicked links that are not deleted. From and To are left unset.
+	TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error)
+	// CreateCampaign stores c and populates its ID and CreatedAt. Returns
+	// ErrConflict if the owner already has a campaign with the name.
+	CreateCampaign(ctx context.Context, c *Campaign) error
+	// GetCampaign returns the campaign with ID id, or ErrNotFound.
+	GetCampaign(ctx context.Context, id uint64) (*Campaign, error)
+	// ListCampaigns returns the campaigns of ownerID, oldest first.
+	ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error)
+	// UpdateCampaign persists the name of c. Returns ErrConflict if the
+	// owner already has a campaign with the name and ErrNotFound if c no
+	// longer exists.
+	UpdateCampaign(ctx context.Context, c *Campaign) error
+	// DeleteCampaign deletes c and takes its links out of it. Returns
+	// ErrNotFound if c no longer exists.
+	DeleteCampaign(ctx context.Context, c *Campaign) error
+	// ListByCampaign returns up to limit links of the campaign with ID
+	// campaignID, paginated like List.
+	ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error)
+	// CampaignStats returns the number of links in the campaign with ID
+	// campaignID, their clicks in the rollup at granularity for buckets
+	// starting in [from, to) and the limit most clicked of those that are
+	// not deleted. Campaign, From and To are left unset.
+	CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error)
+	// CreateDomain stores d and populates its ID and CreatedAt. Returns
+	// ErrConflict if the owner already added the host.
+	CreateDomain(ctx context.Context, d *Domain) error
+	// GetDomain returns the domain with ID id, or ErrNotFound.
+	GetDomain(ctx context.Context, id uint64) (*Domain, error)
+	// GetDomainByHost returns the verified domain with host, or
+	// ErrNotFound.
+	GetDomainByHost(ctx context.Context, host string) (*Domain, error)
+	// ListDomains returns the domains of ownerID, oldest first.
+	ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error)
+	// UpdateDomain persists the verification time of d. Returns ErrConflict
+	// if another user verified the host and ErrNotFound if d no longer
+	// exists.
+	UpdateDomain(ctx context.Context, d *Domain) error
+	// DeleteDomain deletes d and unbinds the links of its owner from the
+	// host. Returns ErrNotFound if d no longer exists.
+	DeleteDomain(ctx context.Context, d *Domain) error
+	// AddDomainRule stores rule and populates its ID and CreatedAt. Returns
+	// ErrConflict if a rule already has the pattern.
+	AddDomainRule(ctx context.Context, rule *DomainRule) error
+	// ListDomainRules returns all domain rules, oldest first. Every
+	// destination is checked against them, so backends with a cache serve
+	// them from it.
+	ListDomainRules(ctx context.Context) ([]*DomainRule, error)
+	// DeleteDomainRule deletes the domain rule with ID id. Returns
+	// ErrNotFound if there is none.
+	DeleteDomainRule(ctx context.Context, id uint64) error
+	// AddAliasReservation stores res and populates its ID and CreatedAt.
+	// Returns ErrConflict if a reservation of the same kind already has the
+	// pattern.
+	AddAliasReservation(ctx context.Context, res *AliasReservation) error
+	// ListAliasReservations returns all alias reservations, oldest first.
+	ListAliasReservations(ctx context.Context) ([]*AliasReservation, error)
+	// DeleteAliasReservation deletes the alias reservation with ID id.
+	// Returns ErrNotFound if there is none.
+	DeleteAliasReservation(ctx context.Context, id uint64) error
+	// CreateOrg stores o with creatorID as its first member and populates
+	// its ID and CreatedAt.
+	CreateOrg(ctx context.Context, o *Org, creatorID uint64) error
+	// GetOrg returns the organization with ID id, or ErrNotFound.
+	GetOrg(ctx context.Context, id uint64) (*Org, error)
+	// ListOrgs returns the organizations userID is a member of, oldest
+	// first.
+	ListOrgs(ctx context.Context, userID uint64) ([]*Org, error)
+	// ListOrgMembers returns the members of the organization with ID orgID
+	// in the order they joined.
+	ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error)
+	// IsOrgMember reports whether userID is a member of the organization
+	// with ID orgID.
+	IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error)
+	// AddOrgMember makes userID a member of the organization with ID orgID.
+	// Returns ErrConflict if it already is one.
+	AddOrgMember(ctx context.Context, orgID, userID uint64) error
+	// RemoveOrgMember removes userID from the organization with ID orgID.
+	// Returns ErrNotFound if it is not a member.
+	RemoveOrgMember(ctx context.Context, orgID, userID uint64) error
+	// AddInvitation stores inv.
+	AddInvitation(ctx context.Context, inv *Invitation) error
+	// GetInvitation returns the invitation whose token hashes to tokenHash,
+	// or ErrNotFound.
+	GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error)
+	// UseInvitation deletes the invitation whose token hashes to tokenHash.
+	// Returns ErrNotFound if it was used already, so that only one caller
+	// can use it.
+	UseInvitation(ctx context.Context, tokenHash string) error
+	// ListByOrg returns up to limit links of the organization with ID
+	// orgID, paginated like List.
+	ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error)
+	// PurgeExpired deletes links that expired at or before now, together with
+	// their cache entries, and returns the number of links removed.
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
 	Close() error
 }
 
+// Stats are aggregate link counts for administrators.
+type Stats struct {
+	Total int64 `json:"total"`
+	// Active links redirect: not disabled and not expired.
+	Active   int64 `json:"active"`
+	Disabled int64 `json:"disabled"`
+	Flagged  int64 `json:"flagged"`
+	Reported int64 `json:"reported"`
+	Expired  int64 `json:"expired"`
+	// Clicks is the persisted click total, which may lag behind live counters.
+	Clicks int64 `json:"clicks"`
+	// Health counts the links by the status of their latest health check,
+	// when health checks are enabled.
+	Health *HealthCounts `json:"health,omitempty"`
+}
+
 type PostgresRedisRepository struct {
 	db     *sql.DB
 	redis  *redis.Client
-	logger *log.Logger
+	logger *slog.Logger
+	// local caches encoded links in process in front of Redis; nil if
+	// disabled. Entries are copied out by decoding, so callers cannot
+	// modify a cached link.
+	local *lru.Cache[[]byte]
+	// cacheTTL is the lifetime of links cached in Redis, varied by up to
+	// cacheJitter times itself. Zero means DefaultCacheTTL.
+	cacheTTL    time.Duration
+	cacheJitter float64
+	// stmts holds the prepared hot-path queries; nil runs every query
+	// unprepared.
+	stmts *stmtCache
+	// clicks buffers the clicks RecordClick cannot add to Redis; nil
+	// writes them to the database right away.
+	clicks *clickBuffer
+	// staleTTL keeps a backup copy of cached links in Redis for this long,
+	// served when the database fails on a cache miss; zero disables it.
+	staleTTL time.Duration
+	// queueSuffix tells the Redis click queues of the shards of a
+	// ShardedRepository apart, as they share one Redis instance.
+	queueSuffix string
+	// warmTTL is the lifetime of entries cached by WarmCache; zero uses
+	// the regular cache TTL.
+	warmTTL time.Duration
+	// preloadTop is the number of most clicked links PreloadCache loads;
+	// when positive, RecordClick counts clicks in the hot link sets.
+	preloadTop int
+	// retry repeats link lookups and cache reads and writes that fail
+	// transiently, and inserts that are known not to have happened.
+	retry RetryPolicy
+}
+
+// PostgresOption configures a PostgresRedisRepository.
+type PostgresOption func(*PostgresRedisRepository)
+
+// WithLocalCache keeps up to maxEntries links in process for at most ttl,
+// saving the Redis round trip for the hottest codes. Invalidate publishes the
+// change to every instance, whose ListenInvalidations drops the entry when
+// the message arrives; ttl only bounds how long a changed link is served by
+// an instance that is not subscribed or missed the message.
+func WithLocalCache(maxEntries int, ttl time.Duration) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.local = lru.New[[]byte](maxEntries, ttl)
+	}
+}
+
+// WithCacheTTL caches links in Redis for ttl, varied randomly by up to
+// jitter times ttl (e.g. 0.1 for ±10%) so that keys cached together do not
+// all expire together.
+func WithCacheTTL(ttl time.Duration, jitter float64) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.cacheTTL, r.cacheJitter = ttl, jitter
+	}
+}
+
+// WithStaleCache keeps a copy of every link cached in Redis under a backup
+// key that lives for ttl, typically days. When the database fails on a cache
+// miss, Get and GetByCode return the backup instead of the error, so that
+// redirects keep working through a database incident; the repository then
+// reports itself degraded in the cache metrics until a lookup succeeds again.
+func WithStaleCache(ttl time.Duration) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.staleTTL = ttl
+	}
+}
+
+// WithShardIndex makes the repository shard i of a ShardedRepository, whose
+// shards share one Redis instance: its queues of clicks to flush and roll up
+// get their own keys, so that each shard only writes the clicks of its own
+// links. Shard 0 keeps the keys of an unsharded repository, so a single
+// database can become the primary without losing queued clicks.
+func WithShardIndex(i int) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		if i > 0 {
+			r.queueSuffix = fmt.Sprintf(":shard%d", i)
+		}
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy for transient Redis and
+// PostgreSQL errors.
+func WithRetryPolicy(p RetryPolicy) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.retry = p
+	}
+}
+
+// WithoutPreparedStatements runs every query unprepared, as required behind
+// connection poolers that do not support prepared statements, such as
+// PgBouncer in transaction mode.
+func WithoutPreparedStatements() PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.stmts = nil
+	}
 }
 
-func NewPostgresRedisRepository(db *sql.DB, redisClient *redis.Client) *PostgresRedisRepository {
-	return &PostgresRedisRepository{
-		db:     db,
-		redis:  redisClient,
-		logger: log.New(os.Stderr, "[repository] ", log.LstdFlags),
+// NewPostgresRedisRepository returns a repository storing links in db and
+// caching them in redisClient, which may be nil. The queries of the
+// redirect and shorten paths are prepared on first use and reused.
+func NewPostgresRedisRepository(db *sql.DB, redisClient *redis.Client, opts ...PostgresOption) *PostgresRedisRepository {
+	r := &PostgresRedisRepository{
+		db:          db,
+		redis:       redisClient,
+		logger:      slog.Default().With("component", "repository"),
+		cacheTTL:    DefaultCacheTTL,
+		cacheJitter: DefaultCacheJitter,
+		clicks:      newClickBuffer(maxBufferedClicks),
+		retry:       DefaultRetryPolicy,
+	}
+	if db != nil {
+		r.stmts = newStmtCache(db)
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
-func (r *PostgresRedisRepository) Save(ctx context.Context, originalURL string) (uint64, error) {
+func (r *PostgresRedisRepository) Save(ctx context.Context, link *Link) (uint64, error) {
+	if link.ID != 0 {
+		return r.saveWithID(ctx, link)
+	}
+
 	// Simple INSERT returning ID.
-	// In a real distributed system, we might use a dedicated ID generator (Snowflake).
-	// For this scope, Postgres SERIAL/BIGSERIAL is sufficient and robust.
-	var id uint64
-	query := `INSERT INTO urls (original_url) VALUES ($1) RETURNING id`
-	err := r.db.QueryRowContext(ctx, query, originalURL).Scan(&id)
+	// Strategies that need IDs independent of the database, such as
+	// SnowflakeCodes, pin link.ID and take the saveWithID path instead.
+	//
+	// Custom aliases may pin IDs ahead of the sequence. A primary key violation
+	// means the sequence reached such an ID; nextval() has already advanced, so
+	// simply retrying yields a fresh ID.
+	query := `INSERT INTO urls (code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id, created_at`
+	var err error
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		err = r.retry.do(ctx, "postgres", retryableWrite, func() error {
+			return r.queryRow(ctx, query, nullString(link.Code), link.OriginalURL, link.ExpiresAt, nullInt64(link.MaxClicks), nullID(link.OwnerID), link.CustomAlias, link.ActiveFrom, link.ActiveUntil, nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), link.BurnAfterReading).
+				Scan(&link.ID, &link.CreatedAt)
+		})
+		if err == nil {
+			return link.ID, nil
+		}
+
+		constraint, unique := uniqueViolation(err)
+		if !unique {
+			break
+		}
+		if constraint != pgPrimaryKeyConstraint {
+			return 0, ErrConflict
+		}
+	}
+	return 0, fmt.Errorf("failed to save url: %w", err)
+}
+
+func (r *PostgresRedisRepository) saveWithID(ctx context.Context, link *Link) (uint64, error) {
+	query := `INSERT INTO urls (id, code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING created_at`
+	err := r.retry.do(ctx, "postgres", retryableWrite, func() error {
+		return r.queryRow(ctx, query, link.ID, nullString(link.Code), link.OriginalURL, link.ExpiresAt, nullInt64(link.MaxClicks), nullID(link.OwnerID), link.CustomAlias, link.ActiveFrom, link.ActiveUntil, nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), link.BurnAfterReading).
+			Scan(&link.CreatedAt)
+	})
+	if _, unique := uniqueViolation(err); unique {
+		return 0, ErrConflict
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to save url with id %d: %w", link.ID, err)
+	}
+	return link.ID, nil
+}
+
+// SaveAlias locks alias_reservations against changes, checks the
+// reservations matching the short code of link and inserts link, in one
+// transaction.
+func (r *PostgresRedisRepository) SaveAlias(ctx context.Context, link *Link) (uint64, error) {
+	pinned := link.ID != 0
+	var err error
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		err = r.retry.do(ctx, "postgres", retryableWrite, func() error {
+			return r.saveAlias(ctx, link, pinned)
+		})
+		if err == nil {
+			return link.ID, nil
+		}
+		if errors.Is(err, ErrAliasReserved) {
+			return 0, err
+		}
+		constraint, unique := uniqueViolation(err)
+		if !unique {
+			break
+		}
+		if pinned || constraint != pgPrimaryKeyConstraint {
+			return 0, ErrConflict
+		}
+	}
+	return 0, fmt.Errorf("failed to save url: %w", err)
+}
+
+func (r *PostgresRedisRepository) saveAlias(ctx context.Context, link *Link, pinned bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE alias_reservations IN SHARE MODE`); err != nil {
+		return fmt.Errorf("failed to lock alias reservations: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT `+aliasReservationColumns+` FROM alias_reservations WHERE pattern = lower($1) OR (prefix AND starts_with(lower($1), pattern))`, link.ShortCode())
+	if err != nil {
+		return fmt.Errorf("failed to check alias reservations: %w", err)
+	}
+	reservations, err := scanAliasReservations(rows)
+	if err != nil {
+		return err
+	}
+	if err := checkReservations(reservations, link); err != nil {
+		return err
+	}
+
+	if pinned {
+		err = tx.QueryRowContext(ctx, `INSERT INTO urls (id, code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING created_at`,
+			link.ID, nullString(link.Code), link.OriginalURL, link.ExpiresAt, nullInt64(link.MaxClicks), nullID(link.OwnerID), link.CustomAlias, link.ActiveFrom, link.ActiveUntil, nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), link.BurnAfterReading).
+			Scan(&link.CreatedAt)
+	} else {
+		var id uint64
+		err = tx.QueryRowContext(ctx, `INSERT INTO urls (code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id, created_at`,
+			nullString(link.Code), link.OriginalURL, link.ExpiresAt, nullInt64(link.MaxClicks), nullID(link.OwnerID), link.CustomAlias, link.ActiveFrom, link.ActiveUntil, nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), link.BurnAfterReading).
+			Scan(&id, &link.CreatedAt)
+		if err == nil {
+			link.ID = id
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveBatch saves links with a single multi-row INSERT, after drawing the
+// IDs of those without one from the sequence. Rows that conflict with an
+// existing ID or code, or with an earlier link of the batch, are skipped
+// and reported as ErrConflict; links drawn an ID get it back as zero.
+func (r *PostgresRedisRepository) SaveBatch(ctx context.Context, links []*Link) ([]error, error) {
+	var drawn []*Link
+	for _, link := range links {
+		if link.ID == 0 {
+			drawn = append(drawn, link)
+		}
+	}
+	defer func() {
+		for _, link := range drawn {
+			if link.CreatedAt.IsZero() {
+				link.ID = 0
+			}
+		}
+	}()
+	if len(drawn) > 0 {
+		rows, err := r.db.QueryContext(ctx, `SELECT nextval(pg_get_serial_sequence('urls', 'id')) FROM generate_series(1, $1)`, len(drawn))
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate ids: %w", err)
+		}
+		defer rows.Close()
+		for i := 0; rows.Next(); i++ {
+			if i < len(drawn) {
+				if err := rows.Scan(&drawn[i].ID); err != nil {
+					return nil, fmt.Errorf("failed to scan id: %w", err)
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to allocate ids: %w", err)
+		}
+	}
+
+	const columns = 16
+	var query strings.Builder
+	query.WriteString(`INSERT INTO urls (id, code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading) VALUES `)
+	args := make([]any, 0, len(links)*columns)
+	for i, link := range links {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteByte('(')
+		for c := range columns {
+			if c > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*columns+c+1)
+		}
+		query.WriteByte(')')
+		args = append(args, link.ID, nullString(link.Code), link.OriginalURL, link.ExpiresAt, nullInt64(link.MaxClicks), nullID(link.OwnerID), link.CustomAlias, link.ActiveFrom, link.ActiveUntil, nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), link.BurnAfterReading)
+	}
+	query.WriteString(` ON CONFLICT DO NOTHING RETURNING id, created_at`)
+
+	rows, err := r.db.QueryContext(ctx, query.String(), args...)
 	if err != nil {
-		return 0, fmt.Errorf("failed to save url: %w", err)
+		return nil, fmt.Errorf("failed to save urls: %w", err)
+	}
+	defer rows.Close()
+	saved := make(map[uint64]time.Time, len(links))
+	for rows.Next() {
+		var (
+			id        uint64
+			createdAt time.Time
+		)
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan url: %w", err)
+		}
+		saved[id] = createdAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to save urls: %w", err)
+	}
+
+	errs := make([]error, len(links))
+	for i, link := range links {
+		createdAt, ok := saved[link.ID]
+		if !ok {
+			errs[i] = ErrConflict
+			continue
+		}
+		// Only the first link of the batch with an ID was stored under it
+		delete(saved, link.ID)
+		link.CreatedAt = createdAt
+	}
+	return errs, nil
+}
+
+func (r *PostgresRedisRepository) NextID(ctx context.Context) (uint64, error) {
+	var id uint64
+	if err := r.db.QueryRowContext(ctx, `SELECT nextval(pg_get_serial_sequence('urls', 'id'))`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to allocate id: %w", err)
 	}
 	return id, nil
 }
 
-// Get retrieves the original URL for a given ID using Read-Through caching.
+// ReserveIDs advances the urls sequence by n under a transaction-scoped
+// advisory lock, so that concurrent reservations never overlap. Plain
+// nextval calls are not blocked; an ID they take from a reserved block is
+// caught as a conflict when the block's owner saves it.
+func (r *PostgresRedisRepository) ReserveIDs(ctx context.Context, n uint64) (uint64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, idBlockLockKey); err != nil {
+		return 0, fmt.Errorf("failed to lock id sequence: %w", err)
+	}
+	var last uint64
+	query := `SELECT setval(pg_get_serial_sequence('urls', 'id'), nextval(pg_get_serial_sequence('urls', 'id')) + $1 - 1)`
+	if err := tx.QueryRowContext(ctx, query, n).Scan(&last); err != nil {
+		return 0, fmt.Errorf("failed to reserve %d ids: %w", n, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit id reservation: %w", err)
+	}
+	return last - n + 1, nil
+}
+
+// Get retrieves the link for a given ID using Read-Through caching.
 //
 // The caller should set an appropriate timeout on ctx. Recommended: 3-5 seconds.
 // This allows time for Redis lookup (~100ms) and DB query (~3s) with buffer for retries.
@@ -58,74 +765,1778 @@ func (r *PostgresRedisRepository) Save(ctx context.Context, originalURL string)
 //
 //	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 //	defer cancel()
-//	url, err := repo.Get(ctx, id)
+//	link, err := repo.Get(ctx, id)
 //
 // Performance: Redis cache hit returns in <1ms. Cache miss requires DB query (~10-50ms).
 //
 // Future Improvement: Consider using golang.org/x/sync/singleflight to prevent
 // cache stampede (multiple concurrent requests for the same expired cache entry
 // all hitting the database simultaneously).
-func (r *PostgresRedisRepository) Get(ctx context.Context, id uint64) (string, error) {
-	cacheKey := fmt.Sprintf("shorturl:id:%d", id)
+func (r *PostgresRedisRepository) Get(ctx context.Context, id uint64) (*Link, error) {
+	cacheKey := idCacheKey(id)
 
-	// 1. Check Redis (Read-Through Cache) - skip if redis is nil (e.g., in tests)
-	if r.redis != nil {
-		val, err := r.redis.Get(ctx, cacheKey).Result()
-		if err == nil {
-			return val, nil // Cache Hit
-		}
-		if err != redis.Nil {
-			// Log error but proceed to DB (graceful degradation)
-			r.logger.Printf("redis get failed for key=%s: %v", cacheKey, err)
-		}
+	// 1. Check Redis (Read-Through Cache)
+	if link, ok := r.getCached(ctx, cacheKey); ok {
+		return link, nil // Cache Hit
 	}
 
 	// 2. Check Database (Cache Miss)
-	var originalURL string
-	query := `SELECT original_url FROM urls WHERE id = $1`
-	err := r.db.QueryRowContext(ctx, query, id).Scan(&originalURL)
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE id = $1 AND deleted_at IS NULL`
+	link, err := r.queryLink(ctx, query, id)
 	if err == sql.ErrNoRows {
-		return "", ErrNotFound
+		return nil, ErrNotFound
 	}
 	if err != nil {
-		return "", fmt.Errorf("failed to get url for id %d: %w", id, err)
+		if link, ok := r.getStale(ctx, cacheKey, err); ok {
+			return link, nil
+		}
+		return nil, fmt.Errorf("failed to get url for id %d: %w", id, err)
+	}
+	if link.Targets, err = r.targets(ctx, link.ID); err != nil {
+		return nil, err
 	}
 
-	// 3. Update Redis - skip if redis is nil
-	if r.redis != nil {
-		// Set with expiration (24 hours) to manage memory with LRU eviction
-		err = r.redis.Set(ctx, cacheKey, originalURL, 24*time.Hour).Err()
-		if err != nil {
-			r.logger.Printf("redis set failed for key=%s: %v", cacheKey, err)
+	// 3. Update Redis
+	r.setCached(ctx, cacheKey, link)
+
+	return link, nil
+}
+
+// GetByCode retrieves the link for a free-form short code using the
+// same Read-Through caching strategy as Get.
+func (r *PostgresRedisRepository) GetByCode(ctx context.Context, code string) (*Link, error) {
+	cacheKey := codeCacheKey(code)
+
+	if link, ok := r.getCached(ctx, cacheKey); ok {
+		return link, nil
+	}
+
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE code = $1 AND deleted_at IS NULL`
+	link, err := r.queryLink(ctx, query, code)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		if link, ok := r.getStale(ctx, cacheKey, err); ok {
+			return link, nil
 		}
+		return nil, fmt.Errorf("failed to get url for code %s: %w", code, err)
 	}
+	if link.Targets, err = r.targets(ctx, link.ID); err != nil {
+		return nil, err
+	}
+
+	r.setCached(ctx, cacheKey, link)
 
-	return originalURL, nil
+	return link, nil
 }
 
-// Close closes both database and Redis connections.
-// Returns an error if either close operation fails.
-func (r *PostgresRedisRepository) Close() error {
-	var dbErr, redisErr error
+// queryLink scans the link selected by query, retrying transient errors.
+func (r *PostgresRedisRepository) queryLink(ctx context.Context, query string, arg any) (*Link, error) {
+	var link *Link
+	err := r.retry.do(ctx, "postgres", transientPostgres, func() error {
+		var err error
+		link, err = scanLink(r.queryRow(ctx, query, arg))
+		return err
+	})
+	if err == nil || err == sql.ErrNoRows {
+		degradedSince.Store(0)
+	}
+	return link, err
+}
 
-	if r.db != nil {
-		dbErr = r.db.Close()
+// getStale returns the backup copy of the link cached under key, if
+// WithStaleCache is enabled and there is one, after the database failed with
+// dbErr.
+func (r *PostgresRedisRepository) getStale(ctx context.Context, key string, dbErr error) (*Link, bool) {
+	if r.staleTTL <= 0 || r.redis == nil || errors.Is(dbErr, context.Canceled) {
+		return nil, false
+	}
+	val, err := r.redis.Get(ctx, staleCacheKey(key)).Bytes()
+	if err != nil {
+		return nil, false
 	}
+	var link Link
+	if err := json.Unmarshal(val, &link); err != nil {
+		r.logger.WarnContext(ctx, "invalid stale cache entry", "key", key, "error", err)
+		return nil, false
+	}
+	degradedSince.CompareAndSwap(0, time.Now().Unix())
+	cacheMetrics.Add("stale_served", 1)
+	r.logger.WarnContext(ctx, "database unavailable, serving stale link", "key", key, "degraded", true, "error", dbErr)
+	return &link, true
+}
 
-	if r.redis != nil {
-		redisErr = r.redis.Close()
+// List uses keyset pagination on the primary key, so each page costs an
+// index range scan regardless of how deep the client has paged (unlike OFFSET).
+func (r *PostgresRedisRepository) List(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE deleted_at IS NULL ORDER BY id DESC LIMIT $1`
+		rows, err = r.db.QueryContext(ctx, query, limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE id < $1 AND deleted_at IS NULL ORDER BY id DESC LIMIT $2`
+		rows, err = r.db.QueryContext(ctx, query, cursor, limit)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls: %w", err)
+	}
+	return scanLinks(rows, limit)
+}
 
-	// Return first error encountered, or combine errors if both fail
-	if dbErr != nil && redisErr != nil {
-		return fmt.Errorf("failed to close connections: db=%v, redis=%v", dbErr, redisErr)
+// ListByOwner uses the (owner_id, id) index for the same keyset pagination as List.
+func (r *PostgresRedisRepository) ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE owner_id = $1 AND deleted_at IS NULL ORDER BY id DESC LIMIT $2`
+		rows, err = r.db.QueryContext(ctx, query, ownerID, limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE owner_id = $1 AND id < $2 AND deleted_at IS NULL ORDER BY id DESC LIMIT $3`
+		rows, err = r.db.QueryContext(ctx, query, ownerID, cursor, limit)
 	}
-	if dbErr != nil {
-		return fmt.Errorf("failed to close database: %w", dbErr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls for owner %d: %w", ownerID, err)
 	}
-	if redisErr != nil {
-		return fmt.Errorf("failed to close redis: %w", redisErr)
+	return scanLinks(rows, limit)
+}
+
+func (r *PostgresRedisRepository) Update(ctx context.Context, link *Link) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET original_url = $2, expires_at = $3, max_clicks = $4, campaign_id = $5, domain = $6, analytics_disabled = $7, org_id = $8, query_passthrough = $9, mask = $10 WHERE id = $1 AND deleted_at IS NULL`,
+		link.ID, link.OriginalURL, link.ExpiresAt, nullInt64(link.MaxClicks), nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)))
+	if err != nil {
+		return fmt.Errorf("failed to update url for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) SetTargets(ctx context.Context, link *Link, targets []Target) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_targets WHERE url_id = $1`, link.ID); err != nil {
+		return fmt.Errorf("failed to clear targets for id %d: %w", link.ID, err)
+	}
+	for _, t := range targets {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO link_targets (url_id, country, url) VALUES ($1, $2, $3)`,
+			link.ID, t.Country, t.URL); err != nil {
+			return fmt.Errorf("failed to save target for id %d: %w", link.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit targets for id %d: %w", link.ID, err)
+	}
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) SetRules(ctx context.Context, link *Link, rules []Rule) error {
+	data, err := marshalRules(rules)
+	if err != nil {
+		return err
+	}
+	// Sent as text: lib/pq would encode []byte as bytea, which jsonb rejects
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET rules = $2 WHERE id = $1 AND deleted_at IS NULL`, link.ID, nullString(string(data)))
+	if err != nil {
+		return fmt.Errorf("failed to set rules for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) SetExperiment(ctx context.Context, link *Link, e *Experiment) error {
+	data, err := marshalExperiment(e)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET experiment = $2 WHERE id = $1 AND deleted_at IS NULL`, link.ID, nullString(data))
+	if err != nil {
+		return fmt.Errorf("failed to set experiment for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) SetPage(ctx context.Context, link *Link, page *PageInfo) error {
+	data, err := marshalPage(page)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET page = $2 WHERE id = $1 AND deleted_at IS NULL`, link.ID, nullString(data))
+	if err != nil {
+		return fmt.Errorf("failed to set page for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) SetMetadata(ctx context.Context, link *Link, m *Metadata) error {
+	data, err := marshalMetadata(m)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET metadata = $2 WHERE id = $1 AND deleted_at IS NULL`, link.ID, nullString(data))
+	if err != nil {
+		return fmt.Errorf("failed to set metadata for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) SetAccess(ctx context.Context, link *Link, a *Access) error {
+	data, err := marshalAccess(a)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET access = $2 WHERE id = $1 AND deleted_at IS NULL`, link.ID, nullString(data))
+	if err != nil {
+		return fmt.Errorf("failed to set access rules for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+// RecordVariant writes to PostgreSQL on every redirect of an experiment,
+// which is acceptable for the small share of links running one.
+func (r *PostgresRedisRepository) RecordVariant(ctx context.Context, link *Link, name string) error {
+	query := `INSERT INTO variant_clicks (url_id, variant, served) VALUES ($1, $2, 1)
+		ON CONFLICT (url_id, variant) DO UPDATE SET served = variant_clicks.served + 1`
+	if _, err := r.db.ExecContext(ctx, query, link.ID, name); err != nil {
+		return fmt.Errorf("failed to record variant for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) VariantStats(ctx context.Context, link *Link) (map[string]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT variant, served FROM variant_clicks WHERE url_id = $1`, link.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant stats for id %d: %w", link.ID, err)
+	}
+	return scanVariantStats(rows)
+}
+
+// targets loads the country targets of the link id.
+func (r *PostgresRedisRepository) targets(ctx context.Context, id uint64) ([]Target, error) {
+	rows, err := r.query(ctx, `SELECT country, url FROM link_targets WHERE url_id = $1 ORDER BY country`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get targets for id %d: %w", id, err)
+	}
+	return scanTargets(rows)
+}
+
+// Delete keeps the row, and its code, so that Restore can bring the link back.
+// Unflushed clicks stay in Redis for the same reason.
+func (r *PostgresRedisRepository) Delete(ctx context.Context, link *Link) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, link.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete url for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.Invalidate(ctx, link)
+}
+
+// Restore needs no cache invalidation because lookups of deleted links are
+// not cached.
+func (r *PostgresRedisRepository) Restore(ctx context.Context, link *Link, ownerID uint64) error {
+	var res sql.Result
+	var err error
+	if link.ID != 0 {
+		res, err = r.db.ExecContext(ctx, `UPDATE urls SET deleted_at = NULL WHERE id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL`, link.ID, ownerID)
+	} else {
+		res, err = r.db.ExecContext(ctx, `UPDATE urls SET deleted_at = NULL WHERE code = $1 AND owner_id = $2 AND deleted_at IS NOT NULL`, link.Code, ownerID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore url %s: %w", link.ShortCode(), err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
 	}
+	return nil
+}
 
+// SetHealth keeps one row per link in link_health, overwritten by each check.
+func (r *PostgresRedisRepository) SetHealth(ctx context.Context, link *Link, h *Health) error {
+	query := `INSERT INTO link_health (url_id, status, status_code, failures, checked_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (url_id) DO UPDATE SET status = $2, status_code = $3, failures = $4, checked_at = $5`
+	if _, err := r.db.ExecContext(ctx, query, link.ID, h.Status, h.StatusCode, h.Failures, h.CheckedAt); err != nil {
+		return fmt.Errorf("failed to set health for id %d: %w", link.ID, err)
+	}
 	return nil
 }
+
+func (r *PostgresRedisRepository) ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error) {
+	ids := make([]int64, len(links))
+	for i, link := range links {
+		ids[i] = int64(link.ID)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+healthColumns+` FROM link_health WHERE url_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list health: %w", err)
+	}
+	return scanHealth(rows)
+}
+
+func (r *PostgresRedisRepository) HealthStats(ctx context.Context) (*HealthCounts, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT h.status, COUNT(*) FROM link_health h
+		JOIN urls u ON u.id = h.url_id WHERE u.deleted_at IS NULL GROUP BY h.status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute health stats: %w", err)
+	}
+	return scanHealthStats(rows)
+}
+
+func (r *PostgresRedisRepository) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	query := `DELETE FROM urls WHERE id IN (
+		SELECT id FROM urls WHERE expires_at <= $1 ORDER BY expires_at LIMIT $2
+	) RETURNING id, code`
+
+	total := 0
+	for {
+		rows, err := r.db.QueryContext(ctx, query, now, purgeBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to purge expired urls: %w", err)
+		}
+
+		var cacheKeys []string
+		purged := 0
+		for rows.Next() {
+			var id uint64
+			var code sql.NullString
+			if err := rows.Scan(&id, &code); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("failed to scan purged url: %w", err)
+			}
+			cacheKeys = append(cacheKeys, idCacheKey(id), clicksKey(id))
+			if code.Valid {
+				cacheKeys = append(cacheKeys, codeCacheKey(code.String))
+			}
+			purged++
+		}
+		total += purged
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return total, fmt.Errorf("failed to read purged urls: %w", err)
+		}
+		if err := rows.Close(); err != nil {
+			return total, fmt.Errorf("failed to read purged urls: %w", err)
+		}
+
+		// Cached entries already expire with the link (see setCached), but
+		// deleting them eagerly frees memory sooner.
+		if r.local != nil {
+			r.local.Delete(cacheKeys...)
+		}
+		if r.redis != nil && len(cacheKeys) > 0 {
+			if err := r.redis.Del(ctx, cacheKeys...).Err(); err != nil {
+				r.logger.WarnContext(ctx, "redis del failed for purged keys", "keys", len(cacheKeys), "error", err)
+			}
+		}
+
+		if purged < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// Search filters with ILIKE, which cannot use an index for infix matches.
+// It is meant for occasional admin use; keyset pagination still bounds
+// the work per page. The metadata title and description are matched too.
+func (r *PostgresRedisRepository) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		q := `SELECT ` + linkColumns + ` FROM urls WHERE (original_url ILIKE $1 OR metadata->>'title' ILIKE $1 OR metadata->>'description' ILIKE $1) AND deleted_at IS NULL ORDER BY id DESC LIMIT $2`
+		rows, err = r.db.QueryContext(ctx, q, pattern, limit)
+	} else {
+		q := `SELECT ` + linkColumns + ` FROM urls WHERE (original_url ILIKE $1 OR metadata->>'title' ILIKE $1 OR metadata->>'description' ILIKE $1) AND id < $2 AND deleted_at IS NULL ORDER BY id DESC LIMIT $3`
+		rows, err = r.db.QueryContext(ctx, q, pattern, cursor, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search urls: %w", err)
+	}
+	return scanLinks(rows, limit)
+}
+
+func (r *PostgresRedisRepository) SetStatus(ctx context.Context, link *Link, status Status) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET status = $1 WHERE id = $2 AND deleted_at IS NULL`, string(status), link.ID)
+	if err != nil {
+		return fmt.Errorf("failed to set status for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	link.Status = status
+	return r.Invalidate(ctx, link)
+}
+
+func (r *PostgresRedisRepository) Invalidate(ctx context.Context, link *Link) error {
+	keys := []string{idCacheKey(link.ID)}
+	if link.Code != "" {
+		keys = append(keys, codeCacheKey(link.Code))
+	}
+	if r.local != nil {
+		r.local.Delete(keys...)
+	}
+	if r.redis == nil {
+		return nil
+	}
+	// Other instances drop their local copies when they receive the
+	// message (see ListenInvalidations).
+	_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		if r.staleTTL > 0 {
+			for _, key := range keys {
+				pipe.Del(ctx, staleCacheKey(key))
+			}
+		}
+		pipe.Publish(ctx, invalidationChannel, strings.Join(keys, " "))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invalidate cache for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+// ListenInvalidations drops the links invalidated by any instance from the
+// local cache until ctx is cancelled. Messages published while the
+// subscription reconnects are lost, so the local cache TTL still bounds
+// staleness. It returns immediately without Redis or a local cache.
+func (r *PostgresRedisRepository) ListenInvalidations(ctx context.Context) {
+	if r.redis == nil || r.local == nil {
+		return
+	}
+	sub := r.redis.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			r.local.Delete(strings.Fields(msg.Payload)...)
+		}
+	}
+}
+
+func (r *PostgresRedisRepository) Stats(ctx context.Context, now time.Time) (*Stats, error) {
+	query := `SELECT
+		COUNT(*),
+		COUNT(*) FILTER (WHERE status = 'active' AND (expires_at IS NULL OR expires_at > $1)),
+		COUNT(*) FILTER (WHERE status = 'disabled'),
+		COUNT(*) FILTER (WHERE status = 'flagged'),
+		COUNT(*) FILTER (WHERE status = 'reported'),
+		COUNT(*) FILTER (WHERE expires_at <= $1),
+		COALESCE(SUM(click_count), 0)
+	FROM urls WHERE deleted_at IS NULL`
+	var st Stats
+	err := r.db.QueryRowContext(ctx, query, now).Scan(&st.Total, &st.Active, &st.Disabled, &st.Flagged, &st.Reported, &st.Expired, &st.Clicks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	return &st, nil
+}
+
+func (r *PostgresRedisRepository) AddReport(ctx context.Context, report *Report) (int64, error) {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO abuse_reports (url_id, reason, reporter) VALUES ($1, $2, $3)
+		ON CONFLICT (url_id, reporter) WHERE resolved_at IS NULL DO NOTHING`,
+		report.LinkID, report.Reason, report.Reporter)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgForeignKeyViolation {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to save report for id %d: %w", report.LinkID, err)
+	}
+
+	var open int64
+	err = r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM abuse_reports WHERE url_id = $1 AND resolved_at IS NULL`, report.LinkID).Scan(&open)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reports for id %d: %w", report.LinkID, err)
+	}
+	return open, nil
+}
+
+func (r *PostgresRedisRepository) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		q := `SELECT ` + reportColumns + ` FROM abuse_reports r JOIN urls u ON u.id = r.url_id
+			WHERE r.resolved_at IS NULL ORDER BY r.id DESC LIMIT $1`
+		rows, err = r.db.QueryContext(ctx, q, limit)
+	} else {
+		q := `SELECT ` + reportColumns + ` FROM abuse_reports r JOIN urls u ON u.id = r.url_id
+			WHERE r.resolved_at IS NULL AND r.id < $1 ORDER BY r.id DESC LIMIT $2`
+		rows, err = r.db.QueryContext(ctx, q, cursor, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return scanReports(rows, limit)
+}
+
+func (r *PostgresRedisRepository) ResolveReports(ctx context.Context, link *Link, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE abuse_reports SET resolved_at = $2 WHERE url_id = $1 AND resolved_at IS NULL`, link.ID, now)
+	if err != nil {
+		return fmt.Errorf("failed to resolve reports for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) AddAudit(ctx context.Context, entry *AuditEntry) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO audit_log (actor, action, code, detail, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		entry.Actor, entry.Action, entry.Code, entry.Detail, entry.CreatedAt).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save audit entry: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error) {
+	var args []any
+	query := auditQuery(f, cursor, limit, func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	})
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	return scanAuditEntries(rows, limit)
+}
+
+func (r *PostgresRedisRepository) AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO link_history (url_id, actor, old_url, new_url, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		link.ID, change.Actor, change.OldURL, change.NewURL, change.CreatedAt).Scan(&change.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add destination change for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+linkChangeColumns+` FROM link_history WHERE url_id = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`,
+		link.ID, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination changes for id %d: %w", link.ID, err)
+	}
+	return scanLinkChanges(rows)
+}
+
+func (r *PostgresRedisRepository) GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error) {
+	var c LinkChange
+	err := r.db.QueryRowContext(ctx, `SELECT `+linkChangeColumns+` FROM link_history WHERE id = $1 AND url_id = $2`, id, link.ID).
+		Scan(&c.ID, &c.Actor, &c.OldURL, &c.NewURL, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination change %d: %w", id, err)
+	}
+	return &c, nil
+}
+
+func (r *PostgresRedisRepository) CreateUser(ctx context.Context, user *User) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO users (email, password_hash, plan, role) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		user.Email, user.PasswordHash, user.Plan, user.Role).Scan(&user.ID, &user.CreatedAt)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users u WHERE u.email = $1`, email))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *PostgresRedisRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO api_keys (user_id, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		key.UserID, key.KeyHash, strings.Join(key.Scopes, ","), key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create api key for user %d: %w", key.UserID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	query := `SELECT ` + userColumns + `, ` + apiKeyColumns + ` FROM users u JOIN api_keys k ON k.user_id = u.id WHERE k.key_hash = $1`
+	user, key, err := scanUserAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user by api key: %w", err)
+	}
+	return user, key, nil
+}
+
+func (r *PostgresRedisRepository) ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys k WHERE k.user_id = $1 ORDER BY k.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return scanAPIKeys(rows)
+}
+
+func (r *PostgresRedisRepository) TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE key_hash = $1`, keyHash, at); err != nil {
+		return fmt.Errorf("failed to touch api key: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE api_keys SET expires_at = $2 WHERE key_hash = $1`, keyHash, at)
+	if err != nil {
+		return fmt.Errorf("failed to expire api key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) DeleteAPIKey(ctx context.Context, keyHash string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM api_keys WHERE key_hash = $1`, keyHash); err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)`, issuer, subject, userID)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create identity for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) LookupIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u JOIN user_identities i ON i.user_id = u.id WHERE i.issuer = $1 AND i.subject = $2`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, issuer, subject))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by identity: %w", err)
+	}
+	return user, nil
+}
+
+func (r *PostgresRedisRepository) SetUserPlan(ctx context.Context, userID uint64, plan string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET plan = $2 WHERE id = $1`, userID, plan)
+	if err != nil {
+		return fmt.Errorf("failed to set plan for user %d: %w", userID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) SetUserRole(ctx context.Context, userID uint64, role string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET role = $2 WHERE id = $1`, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to set role for user %d: %w", userID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+	query := `SELECT
+		COUNT(*) FILTER (WHERE deleted_at IS NULL),
+		COUNT(*) FILTER (WHERE created_at > $2),
+		COUNT(*) FILTER (WHERE custom_alias AND deleted_at IS NULL)
+	FROM urls WHERE owner_id = $1`
+	var u Usage
+	if err := r.db.QueryRowContext(ctx, query, ownerID, since).Scan(&u.Total, &u.Recent, &u.CustomAliases); err != nil {
+		return nil, fmt.Errorf("failed to count urls for owner %d: %w", ownerID, err)
+	}
+	return &u, nil
+}
+
+// IncrementClicks counts in Redis so that the redirect hot path does not
+// write to PostgreSQL; FlushClicks later persists the counters. The counter
+// is seeded from the persisted click_count when it is first created (or after
+// eviction). Without Redis, or if Redis fails, the row is updated directly.
+func (r *PostgresRedisRepository) IncrementClicks(ctx context.Context, link *Link) (int64, error) {
+	if r.redis != nil {
+		n, err := incrementClicksScript.Run(ctx, r.redis,
+			[]string{clicksKey(link.ID), r.queueKey(dirtyClicksKey)}, link.Clicks, link.ID).Int64()
+		if err == nil {
+			return n, nil
+		}
+		r.logger.WarnContext(ctx, "redis click increment failed, falling back to database", "id", link.ID, "error", err)
+	}
+
+	var n int64
+	query := `UPDATE urls SET click_count = click_count + 1 WHERE id = $1 RETURNING click_count`
+	err := r.db.QueryRowContext(ctx, query, link.ID).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment clicks for id %d: %w", link.ID, err)
+	}
+	return n, nil
+}
+
+// Consume claims link in Redis first, so that repeated requests for a
+// consumed link are turned away without writing to PostgreSQL, then records
+// the consumption in the row. The marker outlives the cached copies of link
+// from before it was consumed; after that, consumed_at tells. Without Redis,
+// or if Redis fails, the conditional update of the row alone decides.
+func (r *PostgresRedisRepository) Consume(ctx context.Context, link *Link, at time.Time) (bool, error) {
+	claimed := false
+	if r.redis != nil {
+		n, err := consumeScript.Run(ctx, r.redis, []string{consumedKey(link.ID)}, at.Unix(), r.maxCacheTTL().Milliseconds()).Int64()
+		switch {
+		case err != nil:
+			r.logger.WarnContext(ctx, "redis consume failed, falling back to database", "id", link.ID, "error", err)
+		case n == 0:
+			return false, nil
+		default:
+			claimed = true
+		}
+	}
+
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET consumed_at = $2 WHERE id = $1 AND consumed_at IS NULL AND deleted_at IS NULL`, link.ID, at)
+	if err != nil {
+		if claimed {
+			// Let the link be followed again rather than lose it
+			if delErr := r.redis.Del(ctx, consumedKey(link.ID)).Err(); delErr != nil {
+				r.logger.ErrorContext(ctx, "failed to release consumed link", "id", link.ID, "error", delErr)
+			}
+		}
+		return false, fmt.Errorf("failed to consume url %d: %w", link.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume url %d: %w", link.ID, err)
+	}
+	if n == 0 {
+		// Consumed before the marker was set, or deleted
+		if _, err := r.Get(ctx, link.ID); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	link.ConsumedAt = &at
+	return true, r.Invalidate(ctx, link)
+}
+
+// FlushClicks writes the Redis counters of links clicked since the last
+// flush to PostgreSQL. GREATEST keeps the stored count monotonic even if a
+// counter was evicted and re-seeded from a stale value.
+func (r *PostgresRedisRepository) FlushClicks(ctx context.Context) (int, error) {
+	if r.redis == nil {
+		return 0, nil
+	}
+
+	query := `UPDATE urls SET click_count = GREATEST(click_count, $2) WHERE id = $1`
+	flushed := 0
+	for {
+		ids, err := r.redis.SPopN(ctx, r.queueKey(dirtyClicksKey), flushBatchSize).Result()
+		if err != nil {
+			return flushed, fmt.Errorf("failed to pop dirty click counters: %w", err)
+		}
+		if len(ids) == 0 {
+			return flushed, nil
+		}
+
+		for i, id := range ids {
+			n, err := r.redis.Get(ctx, clicksKeyPrefix+id).Int64()
+			if err == redis.Nil {
+				continue // link purged since it was clicked
+			}
+			if err == nil {
+				_, err = r.db.ExecContext(ctx, query, id, n)
+			}
+			if err != nil {
+				// Re-queue the unflushed IDs for the next run
+				rest := make([]any, 0, len(ids)-i)
+				for _, id := range ids[i:] {
+					rest = append(rest, id)
+				}
+				if addErr := r.redis.SAdd(ctx, r.queueKey(dirtyClicksKey), rest...).Err(); addErr != nil {
+					r.logger.ErrorContext(ctx, "failed to re-queue dirty click counters", "count", len(rest), "error", addErr)
+				}
+				return flushed, fmt.Errorf("failed to flush clicks for id %s: %w", id, err)
+			}
+			flushed++
+		}
+	}
+}
+
+// RecordClick counts in a Redis hash of hourly buckets so that the redirect
+// hot path does not write to PostgreSQL: the click under "id:hour" and each
+// of its sources under "id:hour:dimension:value", and with WithCachePreload
+// the link in the hot links of the day. Without Redis clicks are buffered in
+// memory, and only once the buffer is full added to the rollups directly.
+func (r *PostgresRedisRepository) RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+	counts := clickCounts(link.ID, at, src)
+	if r.redis != nil {
+		pipe := r.redis.Pipeline()
+		for _, c := range counts {
+			field := fmt.Sprintf("%d:%d", c.linkID, c.start.Truncate(time.Hour).Unix())
+			if c.dimension != "" {
+				field += ":" + c.dimension + ":" + c.value
+			}
+			pipe.HIncrBy(ctx, r.queueKey(pendingClicksKey), field, c.clicks)
+		}
+		if r.preloadTop > 0 {
+			key := hotLinksKey(at)
+			pipe.ZIncrBy(ctx, key, 1, strconv.FormatUint(link.ID, 10))
+			pipe.Expire(ctx, key, hotLinksTTL)
+		}
+		_, err := pipe.Exec(ctx)
+		if err == nil {
+			return nil
+		}
+		r.logger.WarnContext(ctx, "redis click recording failed, buffering in memory", "id", link.ID, "error", err)
+	}
+	if r.clicks != nil && r.clicks.add(counts) {
+		return nil
+	}
+	_, err := addClickCounts(ctx, r.db, counts, postgresClickUpsert)
+	return err
+}
+
+// RollupClicks renames the pending hash so that clicks recorded meanwhile
+// start a new one, then adds its counts to the rollups in one transaction.
+// A hash left behind by a failed run is processed first. If deleting it
+// fails after the commit, its clicks are counted again on the next run.
+// RollupClicks adds the clicks buffered in memory and then those pending in
+// Redis to the rollups.
+func (r *PostgresRedisRepository) RollupClicks(ctx context.Context) (int, error) {
+	n, err := r.rollupBuffered(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m, err := r.rollupPending(ctx)
+	return n + m, err
+}
+
+// rollupBuffered adds the clicks buffered in memory to the rollups, putting
+// them back if that fails.
+func (r *PostgresRedisRepository) rollupBuffered(ctx context.Context) (int, error) {
+	if r.clicks == nil {
+		return 0, nil
+	}
+	clicks := r.clicks.take()
+	if len(clicks) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.clicks.restore(clicks)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	total, err := addClickCounts(ctx, tx, clicks, postgresClickUpsert)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		r.clicks.restore(clicks)
+		return 0, fmt.Errorf("failed to roll up buffered clicks: %w", err)
+	}
+	return total, nil
+}
+
+// rollupPending adds the clicks pending in Redis to the rollups.
+func (r *PostgresRedisRepository) rollupPending(ctx context.Context) (int, error) {
+	if r.redis == nil {
+		return 0, nil
+	}
+
+	n, err := r.redis.Exists(ctx, r.queueKey(rollupClicksKey)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check pending clicks: %w", err)
+	}
+	if n == 0 {
+		if err := r.redis.Rename(ctx, r.queueKey(pendingClicksKey), r.queueKey(rollupClicksKey)).Err(); err != nil {
+			if strings.Contains(err.Error(), "no such key") {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("failed to take pending clicks: %w", err)
+		}
+	}
+	fields, err := r.redis.HGetAll(ctx, r.queueKey(rollupClicksKey)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pending clicks: %w", err)
+	}
+
+	clicks := make([]clickCount, 0, len(fields))
+	for field, value := range fields {
+		c, ok := parsePendingClicks(field, value)
+		if !ok {
+			r.logger.WarnContext(ctx, "skipping malformed pending click count", "field", field, "value", value)
+			continue
+		}
+		clicks = append(clicks, c)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	total, err := addClickCounts(ctx, tx, clicks, postgresClickUpsert)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit click rollup: %w", err)
+	}
+	if err := r.redis.Del(ctx, r.queueKey(rollupClicksKey)).Err(); err != nil {
+		return total, fmt.Errorf("failed to clear rolled up clicks: %w", err)
+	}
+	return total, nil
+}
+
+// parsePendingClicks parses a field of the pending clicks hash and its
+// value.
+func parsePendingClicks(field, value string) (clickCount, bool) {
+	parts := strings.SplitN(field, ":", 4)
+	if len(parts) != 2 && len(parts) != 4 {
+		return clickCount{}, false
+	}
+	linkID, idErr := strconv.ParseUint(parts[0], 10, 64)
+	unix, hourErr := strconv.ParseInt(parts[1], 10, 64)
+	count, countErr := strconv.ParseInt(value, 10, 64)
+	if idErr != nil || hourErr != nil || countErr != nil {
+		return clickCount{}, false
+	}
+	c := clickCount{linkID: linkID, start: time.Unix(unix, 0), clicks: count}
+	if len(parts) == 4 {
+		c.dimension, c.value = parts[2], parts[3]
+	}
+	return c, true
+}
+
+func (r *PostgresRedisRepository) ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	query := fmt.Sprintf(`SELECT bucket, clicks FROM %s WHERE url_id = $1 AND bucket >= $2 AND bucket < $3 ORDER BY bucket`, rollupTables[granularity])
+	rows, err := r.db.QueryContext(ctx, query, link.ID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click series for id %d: %w", link.ID, err)
+	}
+	return scanClickBuckets(rows)
+}
+
+func (r *PostgresRedisRepository) ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT dimension, value, SUM(clicks) AS total FROM click_sources
+		WHERE url_id = $1 AND bucket >= $2 AND bucket < $3
+		GROUP BY dimension, value ORDER BY dimension, total DESC, value`, link.ID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click sources for id %d: %w", link.ID, err)
+	}
+	return scanSourceCounts(rows)
+}
+
+func (r *PostgresRedisRepository) PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error) {
+	return purgeClickRows(ctx, r.db, granularity, "ctid", func(n int) string { return "$" + strconv.Itoa(n) }, before)
+}
+
+func (r *PostgresRedisRepository) DeleteClicks(ctx context.Context, link *Link) (int, error) {
+	return deleteClickRows(ctx, r.db, "$1", link)
+}
+
+func (r *PostgresRedisRepository) TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error) {
+	return queryTopStats(ctx, r.db, rollupTables[granularity], func(n int) string { return "$" + strconv.Itoa(n) }, from, to, limit)
+}
+
+func (r *PostgresRedisRepository) CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error) {
+	return queryCampaignStats(ctx, r.db, rollupTables[granularity], func(n int) string { return "$" + strconv.Itoa(n) }, campaignID, from, to, limit)
+}
+
+func (r *PostgresRedisRepository) CreateCampaign(ctx context.Context, c *Campaign) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO campaigns (owner_id, name) VALUES ($1, $2) RETURNING id, created_at`,
+		c.OwnerID, c.Name).Scan(&c.ID, &c.CreatedAt)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create campaign: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) GetCampaign(ctx context.Context, id uint64) (*Campaign, error) {
+	var c Campaign
+	err := r.db.QueryRowContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE id = $1`, id).
+		Scan(&c.ID, &c.OwnerID, &c.Name, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign %d: %w", id, err)
+	}
+	return &c, nil
+}
+
+func (r *PostgresRedisRepository) ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE owner_id = $1 ORDER BY id`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns for owner %d: %w", ownerID, err)
+	}
+	return scanCampaigns(rows)
+}
+
+func (r *PostgresRedisRepository) UpdateCampaign(ctx context.Context, c *Campaign) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE campaigns SET name = $2 WHERE id = $1`, c.ID, c.Name)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update campaign %d: %w", c.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteCampaign takes the links out of the campaign itself rather than
+// relying on ON DELETE SET NULL, to learn which cache entries to drop.
+func (r *PostgresRedisRepository) DeleteCampaign(ctx context.Context, c *Campaign) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `UPDATE urls SET campaign_id = NULL WHERE campaign_id = $1 RETURNING id, code`, c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to clear campaign %d: %w", c.ID, err)
+	}
+	links, err := scanLinkKeys(rows)
+	if err != nil {
+		return fmt.Errorf("failed to clear campaign %d: %w", c.ID, err)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM campaigns WHERE id = $1`, c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete campaign %d: %w", c.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit campaign deletion %d: %w", c.ID, err)
+	}
+	for _, link := range links {
+		if err := r.Invalidate(ctx, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE campaign_id = $1 AND deleted_at IS NULL ORDER BY id DESC LIMIT $2`
+		rows, err = r.db.QueryContext(ctx, query, campaignID, limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE campaign_id = $1 AND id < $2 AND deleted_at IS NULL ORDER BY id DESC LIMIT $3`
+		rows, err = r.db.QueryContext(ctx, query, campaignID, cursor, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls for campaign %d: %w", campaignID, err)
+	}
+	return scanLinks(rows, limit)
+}
+
+func (r *PostgresRedisRepository) CreateDomain(ctx context.Context, d *Domain) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO domains (owner_id, host, token) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		d.OwnerID, d.Host, d.Token).Scan(&d.ID, &d.CreatedAt)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create domain: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) GetDomain(ctx context.Context, id uint64) (*Domain, error) {
+	d, err := scanDomain(r.db.QueryRowContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %d: %w", id, err)
+	}
+	return d, nil
+}
+
+func (r *PostgresRedisRepository) GetDomainByHost(ctx context.Context, host string) (*Domain, error) {
+	d, err := scanDomain(r.db.QueryRowContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE host = $1 AND verified_at IS NOT NULL`, host))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %s: %w", host, err)
+	}
+	return d, nil
+}
+
+func (r *PostgresRedisRepository) ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE owner_id = $1 ORDER BY id`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains for owner %d: %w", ownerID, err)
+	}
+	return scanDomains(rows)
+}
+
+func (r *PostgresRedisRepository) UpdateDomain(ctx context.Context, d *Domain) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE domains SET verified_at = $2 WHERE id = $1`, d.ID, d.VerifiedAt)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update domain %d: %w", d.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) DeleteDomain(ctx context.Context, d *Domain) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `UPDATE urls SET domain = NULL WHERE owner_id = $1 AND domain = $2 RETURNING id, code`, d.OwnerID, d.Host)
+	if err != nil {
+		return fmt.Errorf("failed to unbind domain %d: %w", d.ID, err)
+	}
+	links, err := scanLinkKeys(rows)
+	if err != nil {
+		return fmt.Errorf("failed to unbind domain %d: %w", d.ID, err)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM domains WHERE id = $1`, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete domain %d: %w", d.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit domain deletion %d: %w", d.ID, err)
+	}
+	for _, link := range links {
+		if err := r.Invalidate(ctx, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO domain_rules (pattern, action, reason) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		rule.Pattern, rule.Action, rule.Reason).Scan(&rule.ID, &rule.CreatedAt)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add domain rule: %w", err)
+	}
+	return r.invalidateDomainRules(ctx)
+}
+
+// ListDomainRules serves the rules from Redis, where they are cached until
+// they change, and falls back to the database on a miss or a Redis error.
+func (r *PostgresRedisRepository) ListDomainRules(ctx context.Context) ([]*DomainRule, error) {
+	if r.redis != nil {
+		val, err := r.redis.Get(ctx, domainRulesCacheKey).Bytes()
+		if err != nil && err != redis.Nil {
+			r.logger.WarnContext(ctx, "redis get failed", "key", domainRulesCacheKey, "error", err)
+		}
+		var rules []*DomainRule
+		if err == nil {
+			if err := json.Unmarshal(val, &rules); err == nil {
+				return rules, nil
+			}
+			r.logger.WarnContext(ctx, "invalid cache entry", "key", domainRulesCacheKey, "error", err)
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+domainRuleColumns+` FROM domain_rules ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain rules: %w", err)
+	}
+	rules, err := scanDomainRules(rows)
+	if err != nil {
+		return nil, err
+	}
+	if r.redis != nil {
+		val, err := json.Marshal(rules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode domain rules: %w", err)
+		}
+		if err := r.redis.Set(ctx, domainRulesCacheKey, val, r.jitteredTTL()).Err(); err != nil {
+			r.logger.WarnContext(ctx, "redis set failed", "key", domainRulesCacheKey, "error", err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *PostgresRedisRepository) DeleteDomainRule(ctx context.Context, id uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM domain_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete domain rule %d: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return r.invalidateDomainRules(ctx)
+}
+
+func (r *PostgresRedisRepository) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO alias_reservations (pattern, prefix, org_id) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		res.Pattern, res.Prefix, nullID(res.OrgID)).Scan(&res.ID, &res.CreatedAt)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add alias reservation: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) ListAliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+aliasReservationColumns+` FROM alias_reservations ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alias reservations: %w", err)
+	}
+	return scanAliasReservations(rows)
+}
+
+func (r *PostgresRedisRepository) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM alias_reservations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete alias reservation %d: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// invalidateDomainRules drops the cached domain rules so that every
+// instance reads the changed rules from the database.
+func (r *PostgresRedisRepository) invalidateDomainRules(ctx context.Context) error {
+	if r.redis == nil {
+		return nil
+	}
+	if err := r.redis.Del(ctx, domainRulesCacheKey).Err(); err != nil {
+		return fmt.Errorf("failed to invalidate domain rules: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) CreateOrg(ctx context.Context, o *Org, creatorID uint64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `INSERT INTO orgs (name) VALUES ($1) RETURNING id, created_at`, o.Name).Scan(&o.ID, &o.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO org_members (org_id, user_id) VALUES ($1, $2)`, o.ID, creatorID); err != nil {
+		return fmt.Errorf("failed to add member to organization %d: %w", o.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit organization %d: %w", o.ID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) GetOrg(ctx context.Context, id uint64) (*Org, error) {
+	var o Org
+	err := r.db.QueryRowContext(ctx, `SELECT `+orgColumns+` FROM orgs o WHERE o.id = $1`, id).Scan(&o.ID, &o.Name, &o.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization %d: %w", id, err)
+	}
+	return &o, nil
+}
+
+func (r *PostgresRedisRepository) ListOrgs(ctx context.Context, userID uint64) ([]*Org, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+orgColumns+` FROM orgs o JOIN org_members m ON m.org_id = o.id WHERE m.user_id = $1 ORDER BY o.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations of user %d: %w", userID, err)
+	}
+	return scanOrgs(rows)
+}
+
+func (r *PostgresRedisRepository) ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users u JOIN org_members m ON m.user_id = u.id WHERE m.org_id = $1 ORDER BY m.created_at, u.id`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of organization %d: %w", orgID, err)
+	}
+	return scanUsers(rows)
+}
+
+func (r *PostgresRedisRepository) IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error) {
+	var member bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM org_members WHERE org_id = $1 AND user_id = $2)`, orgID, userID).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("failed to check membership in organization %d: %w", orgID, err)
+	}
+	return member, nil
+}
+
+func (r *PostgresRedisRepository) AddOrgMember(ctx context.Context, orgID, userID uint64) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO org_members (org_id, user_id) VALUES ($1, $2)`, orgID, userID)
+	if _, unique := uniqueViolation(err); unique {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add member to organization %d: %w", orgID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) RemoveOrgMember(ctx context.Context, orgID, userID uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member from organization %d: %w", orgID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) AddInvitation(ctx context.Context, inv *Invitation) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO org_invitations (token_hash, org_id, email, inviter_id, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		inv.TokenHash, inv.OrgID, inv.Email, nullID(inv.InviterID), inv.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create invitation to organization %d: %w", inv.OrgID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	inv := Invitation{TokenHash: tokenHash}
+	var inviterID sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `SELECT org_id, email, inviter_id, expires_at FROM org_invitations WHERE token_hash = $1`, tokenHash).
+		Scan(&inv.OrgID, &inv.Email, &inviterID, &inv.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	inv.InviterID = uint64(inviterID.Int64)
+	return &inv, nil
+}
+
+func (r *PostgresRedisRepository) UseInvitation(ctx context.Context, tokenHash string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM org_invitations WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRedisRepository) ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE org_id = $1 AND deleted_at IS NULL ORDER BY id DESC LIMIT $2`
+		rows, err = r.db.QueryContext(ctx, query, orgID, limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE org_id = $1 AND id < $2 AND deleted_at IS NULL ORDER BY id DESC LIMIT $3`
+		rows, err = r.db.QueryContext(ctx, query, orgID, cursor, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls for organization %d: %w", orgID, err)
+	}
+	return scanLinks(rows, limit)
+}
+
+// postgresClickUpsert adds a click count to a rollup table, whose key
+// columns after url_id and bucket are bound after the count. Selecting from
+// urls skips links purged since they were clicked.
+func postgresClickUpsert(table string, keys ...string) string {
+	var columns, values string
+	for i, key := range keys {
+		columns += ", " + key
+		values += fmt.Sprintf(", $%d", i+4)
+	}
+	return fmt.Sprintf(`INSERT INTO %[1]s (url_id, bucket, clicks%[2]s) SELECT id, $2, $3%[3]s FROM urls WHERE id = $1
+		ON CONFLICT (url_id, bucket%[2]s) DO UPDATE SET clicks = %[1]s.clicks + EXCLUDED.clicks`, table, columns, values)
+}
+
+// Close closes both database and Redis connections.
+// Returns an error if either close operation fails.
+func (r *PostgresRedisRepository) Close() error {
+	var dbErr, redisErr error
+
+	if r.stmts != nil {
+		if err := r.stmts.Close(); err != nil {
+			r.logger.Warn("failed to close prepared statements", "error", err)
+		}
+	}
+	if r.db != nil {
+		dbErr = r.db.Close()
+	}
+
+	if r.redis != nil {
+		redisErr = r.redis.Close()
+	}
+
+	// Return first error encountered, or combine errors if both fail
+	if dbErr != nil && redisErr != nil {
+		return fmt.Errorf("failed to close connections: db=%v, redis=%v", dbErr, redisErr)
+	}
+	if dbErr != nil {
+		return fmt.Errorf("failed to close database: %w", dbErr)
+	}
+	if redisErr != nil {
+		return fmt.Errorf("failed to close redis: %w", redisErr)
+	}
+
+	return nil
+}
+
+// getCached returns the cached link for key from the local cache or Redis.
+// Redis errors are logged and treated as a miss (graceful degradation).
+// Redis is skipped if nil (e.g., in tests).
+func (r *PostgresRedisRepository) getCached(ctx context.Context, key string) (*Link, bool) {
+	val, ok := r.localGet(key)
+	if !ok {
+		if r.redis == nil {
+			return nil, false
+		}
+		err := r.retry.do(ctx, "redis", transientRedis, func() error {
+			var err error
+			val, err = r.redis.Get(ctx, key).Bytes()
+			return err
+		})
+		if err != nil {
+			if err != redis.Nil {
+				r.logger.WarnContext(ctx, "redis get failed", "key", key, "error", err)
+			}
+			return nil, false
+		}
+	}
+
+	var link Link
+	if err := json.Unmarshal(val, &link); err != nil {
+		r.logger.WarnContext(ctx, "invalid cache entry", "key", key, "error", err)
+		return nil, false
+	}
+	if !ok {
+		r.localSet(key, val, &link)
+	}
+	return &link, true
+}
+
+func (r *PostgresRedisRepository) localGet(key string) ([]byte, bool) {
+	if r.local == nil {
+		return nil, false
+	}
+	return r.local.Get(key)
+}
+
+// localSet stores the encoded link under key in the local cache, for no
+// longer than the link lives.
+func (r *PostgresRedisRepository) localSet(key string, val []byte, link *Link) {
+	if r.local == nil {
+		return
+	}
+	var ttl time.Duration
+	if link.ExpiresAt != nil {
+		if ttl = time.Until(*link.ExpiresAt); ttl <= 0 {
+			return
+		}
+	}
+	r.local.Set(key, val, ttl)
+}
+
+// setCached stores link under key. The TTL never outlives the link's expiry,
+// so the cache cannot keep serving a link after it has expired.
+func (r *PostgresRedisRepository) setCached(ctx context.Context, key string, link *Link) {
+	if r.redis == nil && r.local == nil {
+		return
+	}
+
+	// Set with expiration to manage memory with LRU eviction
+	ttl := r.cappedTTL(r.jitteredTTL(), link)
+	if ttl <= 0 {
+		return
+	}
+
+	val, err := json.Marshal(link)
+	if err != nil {
+		r.logger.ErrorContext(ctx, "failed to encode cache entry", "key", key, "error", err)
+		return
+	}
+	r.localSet(key, val, link)
+	if r.redis == nil {
+		return
+	}
+	err = r.retry.do(ctx, "redis", transientRedis, func() error {
+		_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, val, ttl)
+			if r.staleTTL > 0 {
+				pipe.Set(ctx, staleCacheKey(key), val, r.cappedTTL(r.staleTTL, link))
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		r.logger.WarnContext(ctx, "redis set failed", "key", key, "error", err)
+		return
+	}
+	cacheMetrics.Add("sets", 1)
+	cacheMetrics.AddFloat("ttl_seconds_total", ttl.Seconds())
+}
+
+// queueKey returns the name of the click queue key on this shard.
+func (r *PostgresRedisRepository) queueKey(key string) string {
+	return key + r.queueSuffix
+}
+
+// cappedTTL returns ttl, or less if link expires earlier.
+func (r *PostgresRedisRepository) cappedTTL(ttl time.Duration, link *Link) time.Duration {
+	if link.ExpiresAt != nil {
+		if remaining := time.Until(*link.ExpiresAt); remaining < ttl {
+			return remaining
+		}
+	}
+	return ttl
+}
+
+// jitteredTTL returns the cache TTL varied uniformly by up to cacheJitter
+// times itself in either direction.
+func (r *PostgresRedisRepository) jitteredTTL() time.Duration {
+	ttl := r.cacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if r.cacheJitter <= 0 {
+		return ttl
+	}
+	return time.Duration(float64(ttl) * (1 + r.cacheJitter*(2*rand.Float64()-1)))
+}
+
+// maxCacheTTL returns the longest TTL jitteredTTL returns.
+func (r *PostgresRedisRepository) maxCacheTTL() time.Duration {
+	ttl := r.cacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return time.Duration(float64(ttl) * (1 + max(r.cacheJitter, 0)))
+}
+
+// invalidationChannel carries the space-separated cache keys of
+// invalidated links.
+const invalidationChannel = "shorturl:invalidate"
+
+// domainRulesCacheKey caches the domain rules, which every destination is
+// checked against.
+const domainRulesCacheKey = "shorturl:domain_rules"
+
+func idCacheKey(id uint64) string {
+	return fmt.Sprintf("shorturl:id:%d", id)
+}
+
+func codeCacheKey(code string) string {
+	return fmt.Sprintf("shorturl:code:%s", code)
+}
+
+// staleCacheKey is the backup key of the link cached under key, e.g.
+// shorturl:stale:id:42.
+func staleCacheKey(key string) string {
+	return "shorturl:stale:" + strings.TrimPrefix(key, "shorturl:")
+}
+
+func clicksKey(id uint64) string {
+	return fmt.Sprintf("%s%d", clicksKeyPrefix, id)
+}
+
+func consumedKey(id uint64) string {
+	return fmt.Sprintf("%s%d", consumedKeyPrefix, id)
+}
+
+// scanLinkKeys scans and closes rows of link IDs and codes, enough to
+// invalidate the cache entries of the links.
+func scanLinkKeys(rows *sql.Rows) ([]*Link, error) {
+	defer rows.Close()
+	var links []*Link
+	for rows.Next() {
+		var link Link
+		var code sql.NullString
+		if err := rows.Scan(&link.ID, &code); err != nil {
+			return nil, err
+		}
+		link.Code = code.String
+		links = append(links, &link)
+	}
+	return links, rows.Err()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanLink scans a row selected with linkColumns.
+func scanLink(row rowScanner) (*Link, error) {
+	var link Link
+	var code, domain, queryPassthrough, mask sql.NullString
+	var expiresAt, activeFrom, activeUntil, consumedAt sql.NullTime
+	var maxClicks, ownerID, campaignID, orgID sql.NullInt64
+	var rules, experiment, page, metadata, access []byte
+	if err := row.Scan(&link.ID, &code, &link.OriginalURL, &link.CreatedAt, &expiresAt, &maxClicks, &link.Clicks, &link.Status, &ownerID, &link.CustomAlias, &rules, &experiment, &activeFrom, &activeUntil, &page, &campaignID, &domain, &link.AnalyticsDisabled, &orgID, &metadata, &queryPassthrough, &mask, &link.BurnAfterReading, &consumedAt, &access); err != nil {
+		return nil, err
+	}
+	var err error
+	if link.Rules, err = unmarshalRules(rules); err != nil {
+		return nil, err
+	}
+	if link.Experiment, err = unmarshalExperiment(experiment); err != nil {
+		return nil, err
+	}
+	if link.Page, err = unmarshalPage(page); err != nil {
+		return nil, err
+	}
+	if link.Metadata, err = unmarshalMetadata(metadata); err != nil {
+		return nil, err
+	}
+	if link.Access, err = unmarshalAccess(access); err != nil {
+		return nil, err
+	}
+	link.MaxClicks = maxClicks.Int64
+	link.OwnerID = uint64(ownerID.Int64)
+	link.CampaignID = uint64(campaignID.Int64)
+	link.OrgID = uint64(orgID.Int64)
+	link.Code = code.String
+	link.Domain = domain.String
+	link.QueryPassthrough = QueryPassthrough(queryPassthrough.String)
+	link.Mask = Mask(mask.String)
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if activeFrom.Valid {
+		link.ActiveFrom = &activeFrom.Time
+	}
+	if activeUntil.Valid {
+		link.ActiveUntil = &activeUntil.Time
+	}
+	if consumedAt.Valid {
+		link.ConsumedAt = &consumedAt.Time
+	}
+	return &link, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// scanLinks scans and closes rows selected with linkColumns.
+func scanLinks(rows *sql.Rows, limit int) ([]*Link, error) {
+	defer rows.Close()
+
+	links := make([]*Link, 0, limit)
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan url: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read urls: %w", err)
+	}
+	return links, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+// (backslash is the default escape character in PostgreSQL).
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func nullInt64(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
+
+// nullID stores a zero ID (e.g. no owner) as NULL.
+func nullID(id uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// uniqueViolation reports whether err is a PostgreSQL unique constraint
+// violation and, if so, the name of the violated constraint.
+func uniqueViolation(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation {
+		return pqErr.Constraint, true
+	}
+	return "", false
+}
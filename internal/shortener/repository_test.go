@@ -3,11 +3,18 @@ package shortener
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"log/slog"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/alicebob/miniredis/v2"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -30,9 +37,9 @@ func TestPostgresRedisRepository_Save(t *testing.T) {
 			originalURL: "https://www.google.com",
 			wantID:      1,
 			setupMock: func(m sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id"}).AddRow(1)
-				m.ExpectQuery(`INSERT INTO urls \(original_url\) VALUES \(\$1\) RETURNING id`).
-					WithArgs("https://www.google.com").
+				rows := sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now())
+				m.ExpectQuery(`INSERT INTO urls \(code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11, \$12, \$13, \$14, \$15\) RETURNING id, created_at`).
+					WithArgs(nil, "https://www.google.com", nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false).
 					WillReturnRows(rows)
 			},
 			wantErr: false,
@@ -42,8 +49,8 @@ func TestPostgresRedisRepository_Save(t *testing.T) {
 			originalURL: "https://example.com",
 			wantID:      0,
 			setupMock: func(m sqlmock.Sqlmock) {
-				m.ExpectQuery(`INSERT INTO urls \(original_url\) VALUES \(\$1\) RETURNING id`).
-					WithArgs("https://example.com").
+				m.ExpectQuery(`INSERT INTO urls \(code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11, \$12, \$13, \$14, \$15\) RETURNING id, created_at`).
+					WithArgs(nil, "https://example.com", nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false).
 					WillReturnError(sql.ErrConnDone)
 			},
 			wantErr: true,
@@ -61,7 +68,7 @@ func TestPostgresRedisRepository_Save(t *testing.T) {
 			}
 
 			ctx := context.Background()
-			gotID, err := repo.Save(ctx, tt.originalURL)
+			gotID, err := repo.Save(ctx, &Link{OriginalURL: tt.originalURL})
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Save() error = %v, wantErr %v", err, tt.wantErr)
@@ -79,6 +86,168 @@ func TestPostgresRedisRepository_Save(t *testing.T) {
 	}
 }
 
+func TestPostgresRedisRepository_SaveAlias(t *testing.T) {
+	const (
+		selectReservations = `SELECT id, pattern, prefix, org_id, created_at FROM alias_reservations WHERE pattern = lower\(\$1\) OR \(prefix AND starts_with\(lower\(\$1\), pattern\)\)`
+		insertCode         = `INSERT INTO urls \(code, .*\) RETURNING id, created_at`
+	)
+	reservationColumns := []string{"id", "pattern", "prefix", "org_id", "created_at"}
+
+	tests := []struct {
+		name      string
+		orgID     uint64
+		setupMock func(sqlmock.Sqlmock)
+		wantID    uint64
+		wantErr   error
+	}{
+		{
+			name: "free alias",
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(`LOCK TABLE alias_reservations IN SHARE MODE`).WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectQuery(selectReservations).WithArgs("acme-sale").WillReturnRows(sqlmock.NewRows(reservationColumns))
+				m.ExpectQuery(insertCode).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+				m.ExpectCommit()
+			},
+			wantID: 7,
+		},
+		{
+			name:  "prefix of the organization",
+			orgID: 3,
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(`LOCK TABLE alias_reservations`).WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectQuery(selectReservations).WithArgs("acme-sale").
+					WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(1, "acme-", true, 3, time.Now()))
+				m.ExpectQuery(insertCode).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(8, time.Now()))
+				m.ExpectCommit()
+			},
+			wantID: 8,
+		},
+		{
+			name:  "prefix of another organization",
+			orgID: 4,
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(`LOCK TABLE alias_reservations`).WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectQuery(selectReservations).WithArgs("acme-sale").
+					WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(1, "acme-", true, 3, time.Now()))
+				m.ExpectRollback()
+			},
+			wantErr: ErrAliasReserved,
+		},
+		{
+			name: "taken alias",
+			setupMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(`LOCK TABLE alias_reservations`).WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectQuery(selectReservations).WithArgs("acme-sale").WillReturnRows(sqlmock.NewRows(reservationColumns))
+				m.ExpectQuery(insertCode).WillReturnError(&pq.Error{Code: pgUniqueViolation, Constraint: "urls_code_key"})
+				m.ExpectRollback()
+			},
+			wantErr: ErrConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create mock: %v", err)
+			}
+			defer db.Close()
+			tt.setupMock(mock)
+
+			repo := &PostgresRedisRepository{db: db}
+			id, err := repo.SaveAlias(context.Background(), &Link{Code: "acme-sale", OriginalURL: "https://example.com", CustomAlias: true, OrgID: tt.orgID})
+			if !errors.Is(err, tt.wantErr) || id != tt.wantID {
+				t.Errorf("SaveAlias() = %d, %v, want %d, %v", id, err, tt.wantID, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPostgresRedisRepository_SaveBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+	repo := &PostgresRedisRepository{db: db}
+
+	// The links without an ID draw 7 to 9. The second promo link and the
+	// link pinned to ID 3 conflict
+	links := []*Link{
+		{OriginalURL: "https://example.com/a"},
+		{Code: "promo", OriginalURL: "https://example.com/b", CustomAlias: true},
+		{ID: 3, OriginalURL: "https://example.com/c", CustomAlias: true},
+		{Code: "promo", OriginalURL: "https://example.com/d", CustomAlias: true},
+	}
+	mock.ExpectQuery(`SELECT nextval\(pg_get_serial_sequence\('urls', 'id'\)\) FROM generate_series\(1, \$1\)`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(7).AddRow(8).AddRow(9))
+	now := time.Now()
+	mock.ExpectQuery(`INSERT INTO urls \(id, code, .*\) VALUES \(\$1, .*, \$16\), \(\$17, .*\), \(\$33, .*\), \(\$49, .*, \$64\) ON CONFLICT DO NOTHING RETURNING id, created_at`).
+		WithArgs(
+			uint64(7), nil, "https://example.com/a", nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false,
+			uint64(8), "promo", "https://example.com/b", nil, nil, nil, true, nil, nil, nil, nil, false, nil, nil, nil, false,
+			uint64(3), nil, "https://example.com/c", nil, nil, nil, true, nil, nil, nil, nil, false, nil, nil, nil, false,
+			uint64(9), "promo", "https://example.com/d", nil, nil, nil, true, nil, nil, nil, nil, false, nil, nil, nil, false,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now).AddRow(8, now))
+
+	errs, err := repo.SaveBatch(context.Background(), links)
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+	want := []error{nil, nil, ErrConflict, ErrConflict}
+	for i := range links {
+		if !errors.Is(errs[i], want[i]) {
+			t.Errorf("SaveBatch() error of link %d = %v, want %v", i, errs[i], want[i])
+		}
+	}
+	if links[0].ID != 7 || links[1].ID != 8 || links[0].CreatedAt.IsZero() {
+		t.Errorf("saved links = %+v, %+v, want IDs 7 and 8 with creation times", links[0], links[1])
+	}
+	if links[2].ID != 3 || links[3].ID != 0 {
+		t.Errorf("conflicting links = %+v, %+v, want the pinned ID kept and the drawn one cleared", links[2], links[3])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_ReserveIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+		WithArgs(idBlockLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT setval\(pg_get_serial_sequence\('urls', 'id'\), nextval\(pg_get_serial_sequence\('urls', 'id'\)\) \+ \$1 - 1\)`).
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"setval"}).AddRow(599))
+	mock.ExpectCommit()
+
+	repo := &PostgresRedisRepository{db: db}
+	first, err := repo.ReserveIDs(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("ReserveIDs() unexpected error = %v", err)
+	}
+	if first != 500 {
+		t.Errorf("ReserveIDs() = %d, want 500", first)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
 func TestPostgresRedisRepository_Get_CacheHit(t *testing.T) {
 	mr := miniredis.RunT(t)
 	redisClient := redis.NewClient(&redis.Options{
@@ -116,7 +285,11 @@ func TestPostgresRedisRepository_Get_CacheHit(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Pre-populate Redis cache
 			cacheKey := fmt.Sprintf("shorturl:id:%d", tt.id)
-			if err := mr.Set(cacheKey, tt.cachedURL); err != nil {
+			cached, err := json.Marshal(&Link{ID: tt.id, OriginalURL: tt.cachedURL})
+			if err != nil {
+				t.Fatalf("Failed to encode test cache entry: %v", err)
+			}
+			if err := mr.Set(cacheKey, string(cached)); err != nil {
 				t.Fatalf("Failed to setup test cache: %v", err)
 			}
 
@@ -129,15 +302,15 @@ func TestPostgresRedisRepository_Get_CacheHit(t *testing.T) {
 			}
 
 			ctx := context.Background()
-			gotURL, err := repo.Get(ctx, tt.id)
+			gotLink, err := repo.Get(ctx, tt.id)
 
 			if err != nil {
 				t.Errorf("Get() unexpected error = %v", err)
 				return
 			}
 
-			if gotURL != tt.cachedURL {
-				t.Errorf("Get() = %s, want %s", gotURL, tt.cachedURL)
+			if gotLink.OriginalURL != tt.cachedURL {
+				t.Errorf("Get() = %s, want %s", gotLink.OriginalURL, tt.cachedURL)
 			}
 
 			// Verify no DB queries were executed
@@ -151,6 +324,124 @@ func TestPostgresRedisRepository_Get_CacheHit(t *testing.T) {
 	}
 }
 
+func TestPostgresRedisRepository_Get_LocalCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	cached, err := json.Marshal(&Link{ID: 7, OriginalURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Failed to encode test cache entry: %v", err)
+	}
+	if err := mr.Set("shorturl:id:7", string(cached)); err != nil {
+		t.Fatalf("Failed to setup test cache: %v", err)
+	}
+
+	repo := NewPostgresRedisRepository(db, redisClient, WithLocalCache(100, time.Minute))
+	ctx := context.Background()
+
+	link, err := repo.Get(ctx, 7)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	link.OriginalURL = "https://modified.example.com"
+
+	// Served from the local cache without Redis or the database.
+	mr.FlushAll()
+	link, err = repo.Get(ctx, 7)
+	if err != nil {
+		t.Fatalf("Get() from local cache unexpected error = %v", err)
+	}
+	if link.OriginalURL != "https://example.com" {
+		t.Errorf("Get() from local cache = %s, want https://example.com", link.OriginalURL)
+	}
+
+	if err := repo.Invalidate(ctx, link); err != nil {
+		t.Fatalf("Invalidate() unexpected error = %v", err)
+	}
+	mock.ExpectQuery(`SELECT .+ FROM urls WHERE id = \$1`).
+		WithArgs(int64(7)).
+		WillReturnError(sql.ErrNoRows)
+	if _, err := repo.Get(ctx, 7); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Invalidate error = %v, want ErrNotFound", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_ListenInvalidations(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	listener := NewPostgresRedisRepository(db, redisClient, WithLocalCache(100, time.Minute))
+	editor := NewPostgresRedisRepository(db, redisClient, WithLocalCache(100, time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go listener.ListenInvalidations(ctx)
+
+	link := &Link{ID: 7, Code: "promo", OriginalURL: "https://example.com"}
+	listener.setCached(ctx, idCacheKey(link.ID), link)
+	listener.setCached(ctx, codeCacheKey(link.Code), link)
+
+	// The subscription becomes active asynchronously, so repeat the
+	// invalidation until it arrives.
+	deadline := time.Now().Add(2 * time.Second)
+	for listener.local.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("local cache still holds %d entries after invalidation", listener.local.Len())
+		}
+		if err := editor.Invalidate(ctx, link); err != nil {
+			t.Fatalf("Invalidate() unexpected error = %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mr.Exists(idCacheKey(link.ID)) || mr.Exists(codeCacheKey(link.Code)) {
+		t.Error("Invalidate() left Redis entries behind")
+	}
+}
+
+func TestPostgresRedisRepository_CacheTTLJitter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	repo := NewPostgresRedisRepository(nil, redisClient, WithCacheTTL(time.Hour, 0.1))
+	ctx := context.Background()
+
+	ttls := make(map[time.Duration]bool)
+	for id := uint64(1); id <= 20; id++ {
+		repo.setCached(ctx, idCacheKey(id), &Link{ID: id, OriginalURL: "https://example.com"})
+		ttl := mr.TTL(idCacheKey(id))
+		if ttl < 54*time.Minute || ttl > 66*time.Minute {
+			t.Errorf("TTL = %v, want 1h ±10%%", ttl)
+		}
+		ttls[ttl] = true
+	}
+	if len(ttls) < 2 {
+		t.Errorf("all 20 entries got the same TTL %v, want jitter", ttls)
+	}
+
+	repo = NewPostgresRedisRepository(nil, redisClient, WithCacheTTL(time.Hour, 0))
+	repo.setCached(ctx, idCacheKey(99), &Link{ID: 99, OriginalURL: "https://example.com"})
+	if ttl := mr.TTL(idCacheKey(99)); ttl != time.Hour {
+		t.Errorf("TTL without jitter = %v, want 1h", ttl)
+	}
+}
+
 func TestPostgresRedisRepository_Get_CacheMiss(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -169,11 +460,14 @@ func TestPostgresRedisRepository_Get_CacheMiss(t *testing.T) {
 			name: "successful cache miss and DB retrieval",
 			id:   1,
 			setupMock: func(m sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"original_url"}).
-					AddRow("https://www.google.com")
-				m.ExpectQuery(`SELECT original_url FROM urls WHERE id = \$1`).
+				rows := sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+					AddRow(1, nil, "https://www.google.com", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil)
+				m.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE id = \$1`).
 					WithArgs(int64(1)).
 					WillReturnRows(rows)
+				m.ExpectQuery(`SELECT country, url FROM link_targets WHERE url_id = \$1 ORDER BY country`).
+					WithArgs(int64(1)).
+					WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
 			},
 			wantURL: "https://www.google.com",
 			wantErr: nil,
@@ -182,7 +476,7 @@ func TestPostgresRedisRepository_Get_CacheMiss(t *testing.T) {
 			name: "URL not found in database",
 			id:   999,
 			setupMock: func(m sqlmock.Sqlmock) {
-				m.ExpectQuery(`SELECT original_url FROM urls WHERE id = \$1`).
+				m.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE id = \$1`).
 					WithArgs(int64(999)).
 					WillReturnError(sql.ErrNoRows)
 			},
@@ -204,15 +498,135 @@ func TestPostgresRedisRepository_Get_CacheMiss(t *testing.T) {
 
 			ctx := context.Background()
 
-			gotURL, err := repo.Get(ctx, tt.id)
+			gotLink, err := repo.Get(ctx, tt.id)
 
 			if err != tt.wantErr {
 				t.Errorf("Get() error = %v, want %v", err, tt.wantErr)
 				return
 			}
 
-			if gotURL != tt.wantURL {
-				t.Errorf("Get() = %s, want %s", gotURL, tt.wantURL)
+			if err == nil && gotLink.OriginalURL != tt.wantURL {
+				t.Errorf("Get() = %s, want %s", gotLink.OriginalURL, tt.wantURL)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPostgresRedisRepository_Save_SkipsPinnedIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// First sequence value collides with a pinned alias; the retry succeeds
+	mock.ExpectQuery(`INSERT INTO urls \(code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11, \$12, \$13, \$14, \$15\) RETURNING id, created_at`).
+		WithArgs(nil, "https://example.com", nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false).
+		WillReturnError(&pq.Error{Code: pgUniqueViolation, Constraint: pgPrimaryKeyConstraint})
+	mock.ExpectQuery(`INSERT INTO urls \(code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11, \$12, \$13, \$14, \$15\) RETURNING id, created_at`).
+		WithArgs(nil, "https://example.com", nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(8, time.Now()))
+
+	repo := &PostgresRedisRepository{db: db}
+
+	gotID, err := repo.Save(context.Background(), &Link{OriginalURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if gotID != 8 {
+		t.Errorf("Save() = %d, want 8", gotID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_Save_ExplicitID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name    string
+		dbErr   error
+		wantErr error
+	}{
+		{name: "successful save", dbErr: nil, wantErr: nil},
+		{name: "id already taken", dbErr: &pq.Error{Code: pgUniqueViolation, Constraint: pgPrimaryKeyConstraint}, wantErr: ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := mock.ExpectQuery(`INSERT INTO urls \(id, code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11, \$12, \$13, \$14, \$15, \$16\) RETURNING created_at`).
+				WithArgs(int64(42), nil, "https://example.com", nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false)
+			if tt.dbErr != nil {
+				exp.WillReturnError(tt.dbErr)
+			} else {
+				exp.WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+			}
+
+			repo := &PostgresRedisRepository{db: db}
+
+			_, err := repo.Save(context.Background(), &Link{ID: 42, OriginalURL: "https://example.com"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Save() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPostgresRedisRepository_Save_WithCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		dbErr   error
+		wantID  uint64
+		wantErr error
+	}{
+		{name: "successful save", wantID: 7},
+		{name: "code already taken", dbErr: &pq.Error{Code: pgUniqueViolation, Constraint: "idx_urls_code"}, wantErr: ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := mock.ExpectQuery(`INSERT INTO urls \(code, original_url, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9, \$10, \$11, \$12, \$13, \$14, \$15\) RETURNING id, created_at`).
+				WithArgs("spring-sale", "https://example.com", expiresAt, nil, nil, false, nil, nil, nil, nil, false, nil, nil, nil, false)
+			if tt.dbErr != nil {
+				exp.WillReturnError(tt.dbErr)
+			} else {
+				exp.WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(tt.wantID, time.Now()))
+			}
+
+			repo := &PostgresRedisRepository{db: db}
+
+			gotID, err := repo.Save(context.Background(), &Link{
+				Code:        "spring-sale",
+				OriginalURL: "https://example.com",
+				ExpiresAt:   &expiresAt,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Save() error = %v, want %v", err, tt.wantErr)
+			}
+			if gotID != tt.wantID {
+				t.Errorf("Save() = %d, want %d", gotID, tt.wantID)
 			}
 
 			if err := mock.ExpectationsWereMet(); err != nil {
@@ -221,3 +635,1341 @@ func TestPostgresRedisRepository_Get_CacheMiss(t *testing.T) {
 		})
 	}
 }
+
+func TestPostgresRedisRepository_GetByCode(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE code = \$1`).
+		WithArgs("spring-sale").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+			AddRow(7, "spring-sale", "https://example.com/sale", time.Now(), nil, nil, 0, "active", nil, false, []byte(`[{"device":"ios","url":"https://apps.apple.com/app/id1"}]`), nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+	mock.ExpectQuery(`SELECT country, url FROM link_targets WHERE url_id = \$1 ORDER BY country`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"country", "url"}).AddRow("US", "https://example.com/us/sale"))
+
+	repo := &PostgresRedisRepository{
+		db:    db,
+		redis: redisClient,
+	}
+	ctx := context.Background()
+
+	// First call misses the cache and populates it
+	gotLink, err := repo.GetByCode(ctx, "spring-sale")
+	if err != nil {
+		t.Fatalf("GetByCode() unexpected error = %v", err)
+	}
+	if gotLink.OriginalURL != "https://example.com/sale" || gotLink.ShortCode() != "spring-sale" {
+		t.Errorf("GetByCode() = %+v, want spring-sale -> https://example.com/sale", gotLink)
+	}
+	if gotLink.Destination(Visitor{Country: "US"}) != "https://example.com/us/sale" {
+		t.Errorf("GetByCode() Targets = %+v, want US target", gotLink.Targets)
+	}
+	if gotLink.Destination(Visitor{Device: DeviceIOS}) != "https://apps.apple.com/app/id1" {
+		t.Errorf("GetByCode() Rules = %+v, want iOS rule", gotLink.Rules)
+	}
+	if !mr.Exists("shorturl:code:spring-sale") {
+		t.Error("expected cache to be populated after miss")
+	}
+
+	// Second call is served from cache (sqlmock fails on unexpected queries)
+	cached, err := repo.GetByCode(ctx, "spring-sale")
+	if err != nil {
+		t.Fatalf("GetByCode() cache hit unexpected error = %v", err)
+	}
+	if len(cached.Targets) != 1 {
+		t.Errorf("GetByCode() cached Targets = %+v, want 1 target", cached.Targets)
+	}
+
+	mock.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE code = \$1`).
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+	if _, err := repo.GetByCode(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByCode() error = %v, want ErrNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_Get_CacheTTLCappedByExpiry(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name      string
+		id        uint64
+		expiresAt *time.Time
+		wantTTL   time.Duration // 0 = expect no cache entry
+	}{
+		{name: "no expiry uses default TTL", id: 1, expiresAt: nil, wantTTL: 24 * time.Hour},
+		{name: "expiry sooner than default TTL", id: 2, expiresAt: timePtr(time.Now().Add(time.Hour)), wantTTL: time.Hour},
+		{name: "already expired is not cached", id: 3, expiresAt: timePtr(time.Now().Add(-time.Hour)), wantTTL: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE id = \$1`).
+				WithArgs(int64(tt.id)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+					AddRow(tt.id, nil, "https://example.com", time.Now(), tt.expiresAt, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+			mock.ExpectQuery(`SELECT country, url FROM link_targets WHERE url_id = \$1 ORDER BY country`).
+				WithArgs(int64(tt.id)).
+				WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
+
+			repo := &PostgresRedisRepository{
+				db:    db,
+				redis: redisClient,
+			}
+
+			if _, err := repo.Get(context.Background(), tt.id); err != nil {
+				t.Fatalf("Get() unexpected error = %v", err)
+			}
+
+			ttl := mr.TTL(fmt.Sprintf("shorturl:id:%d", tt.id))
+			if tt.wantTTL == 0 {
+				if mr.Exists(fmt.Sprintf("shorturl:id:%d", tt.id)) {
+					t.Errorf("expected no cache entry, got TTL %v", ttl)
+				}
+				return
+			}
+			// Allow 1 minute tolerance for test execution time
+			if ttl < tt.wantTTL-time.Minute || ttl > tt.wantTTL {
+				t.Errorf("TTL = %v, want ~%v", ttl, tt.wantTTL)
+			}
+		})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_PurgeExpired(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mr.Set("shorturl:id:5", "{}")
+	mr.Set("shorturl:code:old-promo", "{}")
+	mr.Set("shorturl:id:6", "{}")
+
+	mock.ExpectQuery(`DELETE FROM urls WHERE id IN`).
+		WithArgs(now, purgeBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code"}).
+			AddRow(5, nil).
+			AddRow(9, "old-promo"))
+
+	repo := &PostgresRedisRepository{
+		db:    db,
+		redis: redisClient,
+	}
+
+	n, err := repo.PurgeExpired(context.Background(), now)
+	if err != nil {
+		t.Fatalf("PurgeExpired() unexpected error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("PurgeExpired() = %d, want 2", n)
+	}
+
+	if mr.Exists("shorturl:id:5") || mr.Exists("shorturl:code:old-promo") {
+		t.Error("expected cache entries of purged links to be deleted")
+	}
+	if !mr.Exists("shorturl:id:6") {
+		t.Error("cache entries of live links must be kept")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestPostgresRedisRepository_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}
+
+	mock.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE deleted_at IS NULL ORDER BY id DESC LIMIT \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(9, "spring-sale", "https://example.com/sale", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil).
+			AddRow(8, nil, "https://example.com/8", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+	mock.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE id < \$1 AND deleted_at IS NULL ORDER BY id DESC LIMIT \$2`).
+		WithArgs(int64(8), 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, nil, "https://example.com/3", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+
+	repo := &PostgresRedisRepository{db: db}
+	ctx := context.Background()
+
+	links, err := repo.List(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("List() unexpected error = %v", err)
+	}
+	if len(links) != 2 || links[0].ShortCode() != "spring-sale" || links[1].ID != 8 {
+		t.Errorf("List() first page = %+v, want [spring-sale, 8]", links)
+	}
+
+	links, err = repo.List(ctx, 8, 2)
+	if err != nil {
+		t.Fatalf("List() unexpected error = %v", err)
+	}
+	if len(links) != 1 || links[0].ID != 3 {
+		t.Errorf("List() second page = %+v, want [3]", links)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_IncrementAndFlushClicks(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{
+		db:    db,
+		redis: redisClient,
+	}
+	ctx := context.Background()
+
+	// The counter is seeded from the persisted count on first use
+	link := &Link{ID: 5, MaxClicks: 10, Clicks: 3}
+	for want := int64(4); want <= 5; want++ {
+		n, err := repo.IncrementClicks(ctx, link)
+		if err != nil {
+			t.Fatalf("IncrementClicks() unexpected error = %v", err)
+		}
+		if n != want {
+			t.Errorf("IncrementClicks() = %d, want %d", n, want)
+		}
+	}
+
+	mock.ExpectExec(`UPDATE urls SET click_count = GREATEST\(click_count, \$2\) WHERE id = \$1`).
+		WithArgs("5", int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := repo.FlushClicks(ctx)
+	if err != nil {
+		t.Fatalf("FlushClicks() unexpected error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("FlushClicks() = %d, want 1", n)
+	}
+
+	// Nothing left to flush (sqlmock fails on unexpected queries)
+	if n, err := repo.FlushClicks(ctx); err != nil || n != 0 {
+		t.Errorf("second FlushClicks() = %d, %v, want 0, nil", n, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_FlushClicks_RequeuesOnError(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{
+		db:     db,
+		redis:  redisClient,
+		logger: slog.Default(),
+	}
+	ctx := context.Background()
+
+	if _, err := repo.IncrementClicks(ctx, &Link{ID: 5, MaxClicks: 10}); err != nil {
+		t.Fatalf("IncrementClicks() unexpected error = %v", err)
+	}
+
+	mock.ExpectExec(`UPDATE urls SET click_count`).WillReturnError(errors.New("connection reset"))
+
+	if _, err := repo.FlushClicks(ctx); err == nil {
+		t.Fatal("FlushClicks() expected error")
+	}
+	if ok, _ := mr.SIsMember(dirtyClicksKey, "5"); !ok {
+		t.Error("expected unflushed counter to be re-queued")
+	}
+}
+
+func TestPostgresRedisRepository_IncrementClicks_WithoutRedis(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`UPDATE urls SET click_count = click_count \+ 1 WHERE id = \$1 RETURNING click_count`).
+		WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"click_count"}).AddRow(4))
+
+	repo := &PostgresRedisRepository{db: db}
+
+	n, err := repo.IncrementClicks(context.Background(), &Link{ID: 5, MaxClicks: 10})
+	if err != nil {
+		t.Fatalf("IncrementClicks() unexpected error = %v", err)
+	}
+	if n != 4 {
+		t.Errorf("IncrementClicks() = %d, want 4", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_Consume(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{
+		db:     db,
+		redis:  redisClient,
+		logger: slog.Default(),
+	}
+	ctx := context.Background()
+	at := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	consume := `UPDATE urls SET consumed_at = \$2 WHERE id = \$1 AND consumed_at IS NULL AND deleted_at IS NULL`
+
+	// A failed update releases the claim, so the link can be retried
+	mock.ExpectExec(consume).WithArgs(int64(5), at).WillReturnError(sql.ErrConnDone)
+	if _, err := repo.Consume(ctx, &Link{ID: 5}, at); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("Consume() error = %v, want %v", err, sql.ErrConnDone)
+	}
+	if mr.Exists(consumedKey(5)) {
+		t.Errorf("Consume() kept the claim of a link it failed to consume")
+	}
+
+	link := &Link{ID: 5}
+	mock.ExpectExec(consume).WithArgs(int64(5), at).WillReturnResult(sqlmock.NewResult(0, 1))
+	if ok, err := repo.Consume(ctx, link, at); err != nil || !ok {
+		t.Fatalf("Consume() = %t, %v, want true", ok, err)
+	}
+	if link.ConsumedAt == nil || !link.ConsumedAt.Equal(at) {
+		t.Errorf("Consume() ConsumedAt = %v, want %v", link.ConsumedAt, at)
+	}
+	if ttl := mr.TTL(consumedKey(5)); ttl != DefaultCacheTTL {
+		t.Errorf("consumed marker TTL = %v, want %v", ttl, DefaultCacheTTL)
+	}
+
+	// Repeats are turned away by Redis (sqlmock fails on unexpected queries)
+	if ok, err := repo.Consume(ctx, &Link{ID: 5}, at.Add(time.Minute)); err != nil || ok {
+		t.Errorf("Consume() of a consumed link = %t, %v, want false", ok, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_RecordAndRollupClicks(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	repo := &PostgresRedisRepository{
+		db:     db,
+		redis:  redisClient,
+		logger: slog.Default(),
+	}
+	ctx := context.Background()
+
+	// Clicks only touch Redis (sqlmock fails on unexpected queries)
+	link := &Link{ID: 5}
+	hour := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	tweet := ClickSource{Referrer: "t.co", UTM: UTM{Source: "twitter"}}
+	for _, c := range []struct {
+		at  time.Time
+		src ClickSource
+	}{
+		{hour.Add(5 * time.Minute), tweet},
+		{hour.Add(50 * time.Minute), tweet},
+		{hour.Add(70 * time.Minute), ClickSource{}},
+	} {
+		if err := repo.RecordClick(ctx, link, c.at, c.src); err != nil {
+			t.Fatalf("RecordClick() unexpected error = %v", err)
+		}
+	}
+
+	upsert := func(table string) string {
+		return `INSERT INTO ` + table + ` \(url_id, bucket, clicks\) SELECT id, \$2, \$3 FROM urls WHERE id = \$1`
+	}
+	sourceUpsert := `INSERT INTO click_sources \(url_id, bucket, clicks, dimension, value\) SELECT id, \$2, \$3, \$4, \$5 FROM urls WHERE id = \$1\s+ON CONFLICT \(url_id, bucket, dimension, value\)`
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec(upsert("clicks_hourly")).WithArgs(int64(5), hour, int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(upsert("clicks_hourly")).WithArgs(int64(5), hour.Add(time.Hour), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(upsert("clicks_daily")).WithArgs(int64(5), day, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sourceUpsert).WithArgs(int64(5), day, int64(2), SourceReferrer, "t.co").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sourceUpsert).WithArgs(int64(5), day, int64(2), SourceUTMSource, "twitter").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sourceUpsert).WithArgs(int64(5), day, int64(1), SourceReferrer, DirectReferrer).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := repo.RollupClicks(ctx)
+	if err != nil {
+		t.Fatalf("RollupClicks() unexpected error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("RollupClicks() = %d, want 3", n)
+	}
+	if mr.Exists(pendingClicksKey) || mr.Exists(rollupClicksKey) {
+		t.Error("rolled up clicks left in Redis")
+	}
+
+	// Nothing left to roll up
+	if n, err := repo.RollupClicks(ctx); err != nil || n != 0 {
+		t.Errorf("second RollupClicks() = %d, %v, want 0, nil", n, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_RollupClicks_RetriesFailedBatch(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	// Rollups are upserted in no particular order
+	mock.MatchExpectationsInOrder(false)
+
+	repo := &PostgresRedisRepository{
+		db:     db,
+		redis:  redisClient,
+		logger: slog.Default(),
+	}
+	ctx := context.Background()
+	hour := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	if err := repo.RecordClick(ctx, &Link{ID: 5}, hour, ClickSource{}); err != nil {
+		t.Fatalf("RecordClick() unexpected error = %v", err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO click`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	if _, err := repo.RollupClicks(ctx); err == nil {
+		t.Fatal("RollupClicks() expected error, got nil")
+	}
+
+	// A click recorded after the failure waits for the run after the retry
+	if err := repo.RecordClick(ctx, &Link{ID: 6}, hour, ClickSource{}); err != nil {
+		t.Fatalf("RecordClick() unexpected error = %v", err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO clicks_`).WithArgs(int64(5), sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO clicks_`).WithArgs(int64(5), sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO click_sources`).WithArgs(int64(5), sqlmock.AnyArg(), int64(1), SourceReferrer, DirectReferrer).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if n, err := repo.RollupClicks(ctx); err != nil || n != 1 {
+		t.Errorf("retried RollupClicks() = %d, %v, want 1, nil", n, err)
+	}
+	if got := mr.HGet(pendingClicksKey, fmt.Sprintf("6:%d", hour.Unix())); got != "1" {
+		t.Errorf("pending clicks of id 6 = %q, want 1", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_RecordClick_BuffersWithoutRedis(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	repo := &PostgresRedisRepository{db: db, logger: slog.Default(), clicks: newClickBuffer(3)}
+	ctx := context.Background()
+	hour := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// Two clicks of link 5 share a click and a referrer count; link 6 no
+	// longer fits the buffer and is written right away
+	for _, at := range []time.Time{hour, hour.Add(time.Minute)} {
+		if err := repo.RecordClick(ctx, &Link{ID: 5}, at, ClickSource{}); err != nil {
+			t.Fatalf("RecordClick() unexpected error = %v", err)
+		}
+	}
+	mock.ExpectExec(`INSERT INTO clicks_`).WithArgs(int64(6), sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO clicks_`).WithArgs(int64(6), sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO click_sources`).WithArgs(int64(6), sqlmock.AnyArg(), int64(1), SourceReferrer, DirectReferrer).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.RecordClick(ctx, &Link{ID: 6}, hour, ClickSource{}); err != nil {
+		t.Fatalf("RecordClick() of a full buffer unexpected error = %v", err)
+	}
+	if n := repo.clicks.len(); n != 2 {
+		t.Errorf("buffered %d counts, want 2", n)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO clicks_`).WithArgs(int64(5), sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO clicks_`).WithArgs(int64(5), sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO click_sources`).WithArgs(int64(5), sqlmock.AnyArg(), int64(2), SourceReferrer, DirectReferrer).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if n, err := repo.RollupClicks(ctx); err != nil || n != 2 {
+		t.Errorf("RollupClicks() = %d, %v, want 2, nil", n, err)
+	}
+	if n := repo.clicks.len(); n != 0 {
+		t.Errorf("buffered %d counts after RollupClicks, want 0", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_RollupClicks_RestoresBufferOnFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{db: db, logger: slog.Default(), clicks: newClickBuffer(maxBufferedClicks)}
+	ctx := context.Background()
+	if err := repo.RecordClick(ctx, &Link{ID: 5}, time.Now(), ClickSource{}); err != nil {
+		t.Fatalf("RecordClick() unexpected error = %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO click`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	if _, err := repo.RollupClicks(ctx); err == nil {
+		t.Fatal("RollupClicks() expected error, got nil")
+	}
+	if n := repo.clicks.len(); n != 2 {
+		t.Errorf("buffered %d counts after failed RollupClicks, want 2", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_ClickSeries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	mock.ExpectQuery(`SELECT bucket, clicks FROM clicks_daily WHERE url_id = \$1 AND bucket >= \$2 AND bucket < \$3 ORDER BY bucket`).
+		WithArgs(int64(5), from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "clicks"}).AddRow(from.AddDate(0, 0, 2), 4))
+
+	repo := &PostgresRedisRepository{db: db}
+	got, err := repo.ClickSeries(context.Background(), &Link{ID: 5}, GranularityDay, from, to)
+	if err != nil {
+		t.Fatalf("ClickSeries() unexpected error = %v", err)
+	}
+	if len(got) != 1 || !got[0].Start.Equal(from.AddDate(0, 0, 2)) || got[0].Clicks != 4 {
+		t.Errorf("ClickSeries() = %+v", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_ClickSources(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	mock.ExpectQuery(`SELECT dimension, value, SUM\(clicks\) AS total FROM click_sources\s+WHERE url_id = \$1 AND bucket >= \$2 AND bucket < \$3\s+GROUP BY dimension, value ORDER BY dimension, total DESC, value`).
+		WithArgs(int64(5), from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"dimension", "value", "total"}).
+			AddRow(SourceReferrer, "t.co", 4).
+			AddRow(SourceUTMSource, "twitter", 3))
+
+	repo := &PostgresRedisRepository{db: db}
+	got, err := repo.ClickSources(context.Background(), &Link{ID: 5}, from, to)
+	if err != nil {
+		t.Fatalf("ClickSources() unexpected error = %v", err)
+	}
+	want := []SourceCount{{SourceReferrer, "t.co", 4}, {SourceUTMSource, "twitter", 3}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("ClickSources() = %+v, want %+v", got, want)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_TopStats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(clicks\), 0\) FROM clicks_daily WHERE bucket >= \$1 AND bucket < \$2`).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(12))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM urls WHERE created_at >= \$1 AND created_at < \$2`).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`SELECT u.id, u.code, SUM\(c.clicks\) AS total FROM clicks_daily c JOIN urls u ON u.id = c.url_id\s+WHERE c.bucket >= \$1 AND c.bucket < \$2 AND u.deleted_at IS NULL\s+GROUP BY u.id, u.code ORDER BY total DESC, u.id LIMIT \$3`).
+		WithArgs(from, to, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "total"}).AddRow(7, "spring-sale", 8).AddRow(125, nil, 3))
+
+	repo := &PostgresRedisRepository{db: db}
+	got, err := repo.TopStats(context.Background(), GranularityDay, from, to, 2)
+	if err != nil {
+		t.Fatalf("TopStats() unexpected error = %v", err)
+	}
+	want := []TopLink{{Code: "spring-sale", Clicks: 8}, {Code: (&Link{ID: 125}).ShortCode(), Clicks: 3}}
+	if got.Redirects != 12 || got.LinksCreated != 3 || len(got.Top) != 2 || got.Top[0] != want[0] || got.Top[1] != want[1] {
+		t.Errorf("TopStats() = %+v, want 12 redirects, 3 created and top %+v", got, want)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_SetStatus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{
+		db:    db,
+		redis: redisClient,
+	}
+	ctx := context.Background()
+
+	link := &Link{ID: 7, Code: "spring-sale", OriginalURL: "https://example.com/sale"}
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mr.Set(codeCacheKey("spring-sale"), `{"id":7}`)
+
+	mock.ExpectExec(`UPDATE urls SET status = \$1 WHERE id = \$2`).
+		WithArgs("disabled", int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE urls SET status = \$1 WHERE id = \$2`).
+		WithArgs("disabled", int64(999)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := repo.SetStatus(ctx, link, StatusDisabled); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+	if link.Status != StatusDisabled {
+		t.Errorf("link.Status = %q, want disabled", link.Status)
+	}
+	if mr.Exists(idCacheKey(7)) || mr.Exists(codeCacheKey("spring-sale")) {
+		t.Error("SetStatus() did not invalidate the cache")
+	}
+
+	if err := repo.SetStatus(ctx, &Link{ID: 999}, StatusDisabled); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetStatus() missing link error = %v, want ErrNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_Search(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}
+
+	// LIKE wildcards in the query are escaped
+	mock.ExpectQuery(`SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE \(original_url ILIKE \$1 OR metadata->>'title' ILIKE \$1 OR metadata->>'description' ILIKE \$1\) AND id < \$2 AND deleted_at IS NULL ORDER BY id DESC LIMIT \$3`).
+		WithArgs(`%100\%\_off%`, int64(10), 5).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(4, nil, "https://example.com/100%_off", time.Now(), nil, nil, 2, "disabled", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+
+	repo := &PostgresRedisRepository{db: db}
+
+	links, err := repo.Search(context.Background(), "100%_off", 10, 5)
+	if err != nil {
+		t.Fatalf("Search() unexpected error = %v", err)
+	}
+	if len(links) != 1 || links[0].ID != 4 || !links[0].IsDisabled() || links[0].Clicks != 2 {
+		t.Errorf("Search() = %+v", links)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_AddReport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`INSERT INTO abuse_reports \(url_id, reason, reporter\) VALUES \(\$1, \$2, \$3\)\s+ON CONFLICT \(url_id, reporter\) WHERE resolved_at IS NULL DO NOTHING`).
+		WithArgs(int64(7), "phishing", "192.0.2.1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM abuse_reports WHERE url_id = \$1 AND resolved_at IS NULL`).
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectExec(`INSERT INTO abuse_reports`).
+		WillReturnError(&pq.Error{Code: pgForeignKeyViolation})
+
+	repo := &PostgresRedisRepository{db: db}
+	ctx := context.Background()
+
+	open, err := repo.AddReport(ctx, &Report{LinkID: 7, Reason: "phishing", Reporter: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("AddReport() unexpected error = %v", err)
+	}
+	if open != 3 {
+		t.Errorf("AddReport() = %d, want 3", open)
+	}
+
+	if _, err := repo.AddReport(ctx, &Report{LinkID: 999, Reporter: "192.0.2.1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddReport() missing link error = %v, want ErrNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_DomainRulesCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	columns := []string{"id", "pattern", "action", "reason", "created_at"}
+	mock.ExpectQuery(`SELECT id, pattern, action, reason, created_at FROM domain_rules ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "spam.example", "block", "link farm", now))
+	mock.ExpectQuery(`INSERT INTO domain_rules \(pattern, action, reason\) VALUES \(\$1, \$2, \$3\) RETURNING id, created_at`).
+		WithArgs("*.spam.example", DomainBlock, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, now))
+	mock.ExpectQuery(`SELECT id, pattern, action, reason, created_at FROM domain_rules ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "spam.example", "block", "link farm", now).AddRow(2, "*.spam.example", "block", "", now))
+
+	repo := NewPostgresRedisRepository(db, redisClient)
+	ctx := context.Background()
+
+	// The second read is served from Redis
+	for range 2 {
+		rules, err := repo.ListDomainRules(ctx)
+		if err != nil {
+			t.Fatalf("ListDomainRules() unexpected error = %v", err)
+		}
+		if len(rules) != 1 || rules[0].Pattern != "spam.example" || rules[0].Action != DomainBlock || rules[0].Reason != "link farm" {
+			t.Errorf("ListDomainRules() = %+v", rules)
+		}
+	}
+
+	// Adding a rule drops the cached rules
+	if err := repo.AddDomainRule(ctx, &DomainRule{Pattern: "*.spam.example", Action: DomainBlock}); err != nil {
+		t.Fatalf("AddDomainRule() unexpected error = %v", err)
+	}
+	if mr.Exists(domainRulesCacheKey) {
+		t.Error("domain rules still cached after AddDomainRule()")
+	}
+	if rules, err := repo.ListDomainRules(ctx); err != nil || len(rules) != 2 {
+		t.Errorf("ListDomainRules() after add = %+v, %v, want 2 rules", rules, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_Audit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	createdAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`INSERT INTO audit_log \(actor, action, code, detail, created_at\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id`).
+		WithArgs("user:3", AuditCreate, "b", "https://example.com", createdAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+
+	// Placeholders are numbered in the order the filters are applied
+	since := createdAt.Add(-time.Hour)
+	mock.ExpectQuery(`SELECT id, actor, action, code, detail, created_at FROM audit_log WHERE actor = \$1 AND created_at >= \$2 AND id < \$3 ORDER BY id DESC LIMIT \$4`).
+		WithArgs("user:3", since, int64(20), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "action", "code", "detail", "created_at"}).
+			AddRow(11, "user:3", AuditCreate, "b", "https://example.com", createdAt))
+
+	repo := &PostgresRedisRepository{db: db}
+	ctx := context.Background()
+
+	entry := &AuditEntry{Actor: "user:3", Action: AuditCreate, Code: "b", Detail: "https://example.com", CreatedAt: createdAt}
+	if err := repo.AddAudit(ctx, entry); err != nil {
+		t.Fatalf("AddAudit() unexpected error = %v", err)
+	}
+	if entry.ID != 11 {
+		t.Errorf("AddAudit() ID = %d, want 11", entry.ID)
+	}
+
+	entries, err := repo.ListAudit(ctx, AuditFilter{Actor: "user:3", Since: since}, 20, 5)
+	if err != nil {
+		t.Fatalf("ListAudit() unexpected error = %v", err)
+	}
+	if len(entries) != 1 || *entries[0] != *entry {
+		t.Errorf("ListAudit() = %+v, want [%+v]", entries, entry)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_DeleteCampaign(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{
+		db:     db,
+		redis:  redisClient,
+		logger: slog.Default(),
+	}
+	ctx := context.Background()
+
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mr.Set(codeCacheKey("spring-sale"), `{"id":9}`)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE urls SET campaign_id = NULL WHERE campaign_id = \$1 RETURNING id, code`).
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code"}).AddRow(7, nil).AddRow(9, "spring-sale"))
+	mock.ExpectExec(`DELETE FROM campaigns WHERE id = \$1`).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := repo.DeleteCampaign(ctx, &Campaign{ID: 3}); err != nil {
+		t.Fatalf("DeleteCampaign() unexpected error = %v", err)
+	}
+	// Cached links must not keep pointing at the deleted campaign
+	if mr.Exists(idCacheKey(7)) || mr.Exists(codeCacheKey("spring-sale")) {
+		t.Error("DeleteCampaign() did not invalidate the cache")
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE urls SET campaign_id = NULL`).
+		WithArgs(int64(4)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code"}))
+	mock.ExpectExec(`DELETE FROM campaigns WHERE id = \$1`).
+		WithArgs(int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := repo.DeleteCampaign(ctx, &Campaign{ID: 4}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteCampaign() unknown campaign error = %v, want ErrNotFound", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_UpdateAndDelete(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := &PostgresRedisRepository{
+		db:     db,
+		redis:  redisClient,
+		logger: slog.Default(),
+	}
+	ctx := context.Background()
+
+	link := &Link{ID: 7, OriginalURL: "https://example.com/new"}
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mr.Set(clicksKey(7), "3")
+
+	mock.ExpectExec(`UPDATE urls SET original_url = \$2, expires_at = \$3, max_clicks = \$4, campaign_id = \$5, domain = \$6, analytics_disabled = \$7, org_id = \$8, query_passthrough = \$9, mask = \$10 WHERE id = \$1`).
+		WithArgs(int64(7), "https://example.com/new", nil, nil, nil, nil, false, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.Update(ctx, link); err != nil {
+		t.Fatalf("Update() unexpected error = %v", err)
+	}
+	if mr.Exists(idCacheKey(7)) {
+		t.Error("Update() did not invalidate the cache")
+	}
+
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM link_targets WHERE url_id = \$1`).
+		WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO link_targets \(url_id, country, url\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(int64(7), "US", "https://example.com/us").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := repo.SetTargets(ctx, link, []Target{{Country: "US", URL: "https://example.com/us"}}); err != nil {
+		t.Fatalf("SetTargets() unexpected error = %v", err)
+	}
+	if mr.Exists(idCacheKey(7)) {
+		t.Error("SetTargets() did not invalidate the cache")
+	}
+
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mock.ExpectExec(`UPDATE urls SET rules = \$2 WHERE id = \$1`).
+		WithArgs(int64(7), `[{"device":"android","url":"https://play.google.com/store"}]`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.SetRules(ctx, link, []Rule{{Device: DeviceAndroid, URL: "https://play.google.com/store"}}); err != nil {
+		t.Fatalf("SetRules() unexpected error = %v", err)
+	}
+	if mr.Exists(idCacheKey(7)) {
+		t.Error("SetRules() did not invalidate the cache")
+	}
+
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mock.ExpectExec(`UPDATE urls SET experiment = \$2 WHERE id = \$1`).
+		WithArgs(int64(7), `{"variants":[{"name":"a","url":"https://example.com/a","weight":1},{"name":"b","url":"https://example.com/b","weight":1}]}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	experiment := &Experiment{Variants: []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: "https://example.com/b", Weight: 1},
+	}}
+	if err := repo.SetExperiment(ctx, link, experiment); err != nil {
+		t.Fatalf("SetExperiment() unexpected error = %v", err)
+	}
+	if mr.Exists(idCacheKey(7)) {
+		t.Error("SetExperiment() did not invalidate the cache")
+	}
+
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mock.ExpectExec(`UPDATE urls SET page = \$2 WHERE id = \$1`).
+		WithArgs(int64(7), `{"title":"Example"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.SetPage(ctx, link, &PageInfo{Title: "Example"}); err != nil {
+		t.Fatalf("SetPage() unexpected error = %v", err)
+	}
+	if mr.Exists(idCacheKey(7)) {
+		t.Error("SetPage() did not invalidate the cache")
+	}
+
+	mock.ExpectExec(`INSERT INTO variant_clicks \(url_id, variant, served\) VALUES \(\$1, \$2, 1\)`).
+		WithArgs(int64(7), "b").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.RecordVariant(ctx, link, "b"); err != nil {
+		t.Fatalf("RecordVariant() unexpected error = %v", err)
+	}
+
+	mr.Set(idCacheKey(7), `{"id":7}`)
+	mock.ExpectExec(`UPDATE urls SET deleted_at = NOW\(\) WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE urls SET deleted_at = NOW\(\) WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := repo.Delete(ctx, link); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	if mr.Exists(idCacheKey(7)) {
+		t.Error("Delete() did not invalidate the cache")
+	}
+	// Unflushed clicks are kept in case the link is restored
+	if !mr.Exists(clicksKey(7)) {
+		t.Error("Delete() dropped the click counter")
+	}
+	if err := repo.Delete(ctx, link); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() missing link error = %v, want ErrNotFound", err)
+	}
+
+	mock.ExpectExec(`UPDATE urls SET deleted_at = NULL WHERE id = \$1 AND owner_id = \$2 AND deleted_at IS NOT NULL`).
+		WithArgs(int64(7), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE urls SET deleted_at = NULL WHERE code = \$1 AND owner_id = \$2 AND deleted_at IS NOT NULL`).
+		WithArgs("spring-sale", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := repo.Restore(ctx, link, 3); err != nil {
+		t.Fatalf("Restore() unexpected error = %v", err)
+	}
+	if err := repo.Restore(ctx, &Link{Code: "spring-sale"}, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() of live link error = %v, want ErrNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRepository_UpdateDeletedLink(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("postgres", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("failed to create mock: %v", err)
+		}
+		defer db.Close()
+
+		// Soft-deleted rows are left out by deleted_at IS NULL
+		mock.ExpectExec(`UPDATE urls SET .+ WHERE id = \$1 AND deleted_at IS NULL`).
+			WithArgs(int64(7), "https://example.com/new", nil, nil, nil, nil, false, nil, nil, nil).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		repo := &PostgresRedisRepository{db: db}
+		if err := repo.Update(ctx, &Link{ID: 7, OriginalURL: "https://example.com/new"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update() of a deleted link error = %v, want ErrNotFound", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unfulfilled expectations: %v", err)
+		}
+	})
+
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			repo := newRepo(t)
+			link := &Link{OriginalURL: "https://example.com/old"}
+			if _, err := repo.Save(ctx, link); err != nil {
+				t.Fatalf("Save() unexpected error = %v", err)
+			}
+			if err := repo.Delete(ctx, link); err != nil {
+				t.Fatalf("Delete() unexpected error = %v", err)
+			}
+
+			link.OriginalURL = "https://example.com/new"
+			if err := repo.Update(ctx, link); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Update() of a deleted link error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestPostgresRedisRepository_StaleCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	query := `SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE id = \$1`
+	linkRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+			AddRow(1, nil, "https://example.com", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil)
+	}
+	repo := NewPostgresRedisRepository(db, redisClient, WithoutPreparedStatements(), WithStaleCache(7*24*time.Hour),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	ctx := context.Background()
+	degraded := func() bool { return cacheMetrics.Get("degraded").(expvar.Func)().(bool) }
+
+	// Caching a link also stores the backup copy
+	mock.ExpectQuery(query).WithArgs(int64(1)).WillReturnRows(linkRow())
+	mock.ExpectQuery(`SELECT country, url FROM link_targets`).WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
+	if _, err := repo.Get(ctx, 1); err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if ttl := mr.TTL("shorturl:stale:id:1"); ttl != 7*24*time.Hour {
+		t.Errorf("stale copy TTL = %v, want 168h", ttl)
+	}
+
+	// Once the regular entry expired, a database failure serves the copy
+	mr.Del("shorturl:id:1")
+	mock.ExpectQuery(query).WithArgs(int64(1)).WillReturnError(sql.ErrConnDone)
+	link, err := repo.Get(ctx, 1)
+	if err != nil || link.OriginalURL != "https://example.com" {
+		t.Fatalf("Get() with the database down = %+v, %v, want the stale link", link, err)
+	}
+	if !degraded() {
+		t.Error("degraded = false after serving a stale link, want true")
+	}
+
+	// Recovering clears the flag
+	mock.ExpectQuery(query).WithArgs(int64(1)).WillReturnRows(linkRow())
+	mock.ExpectQuery(`SELECT country, url FROM link_targets`).WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
+	if _, err := repo.Get(ctx, 1); err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if degraded() {
+		t.Error("degraded = true after the database recovered, want false")
+	}
+
+	// Invalidated links are not served stale, e.g. once disabled
+	if err := repo.Invalidate(ctx, &Link{ID: 1}); err != nil {
+		t.Fatalf("Invalidate() unexpected error = %v", err)
+	}
+	mock.ExpectQuery(query).WithArgs(int64(1)).WillReturnError(sql.ErrConnDone)
+	if _, err := repo.Get(ctx, 1); !errors.Is(err, sql.ErrConnDone) {
+		t.Errorf("Get() of an invalidated link with the database down error = %v, want the database error", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_CleanCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+	for _, key := range []string{
+		"shorturl:id:1", "shorturl:clicks:1", // live
+		"shorturl:id:2", "shorturl:stale:id:2", "shorturl:clicks:2", // deleted
+		"shorturl:code:promo",                            // live
+		"shorturl:code:gone", "shorturl:stale:code:gone", // deleted
+		"shorturl:clicks:dirty", "shorturl:visitors:2026-01-01", // not per link
+	} {
+		mr.Set(key, "x")
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(`SELECT id, code FROM urls WHERE deleted_at IS NULL AND \(id = ANY\(\$1\) OR code = ANY\(\$2\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code"}).AddRow(1, nil).AddRow(3, "promo"))
+
+	repo := NewPostgresRedisRepository(db, redisClient, WithoutPreparedStatements())
+	n, err := repo.CleanCache(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("CleanCache() = %d, %v, want 5", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+	want := []string{"shorturl:clicks:1", "shorturl:clicks:dirty", "shorturl:code:promo", "shorturl:id:1", "shorturl:visitors:2026-01-01"}
+	if got := mr.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys left = %v, want %v", got, want)
+	}
+}
+
+func TestPostgresRedisRepository_WarmCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	expiresAt := time.Now().Add(time.Minute)
+	mock.ExpectQuery(`SELECT .* FROM urls WHERE id > \$1 AND deleted_at IS NULL`).WithArgs(int64(0), sqlmock.AnyArg(), warmBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+			AddRow(1, nil, "https://example.com", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil).
+			AddRow(2, "promo", "https://example.org", time.Now(), expiresAt, nil, 0, "disabled", nil, true, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+	mock.ExpectQuery(`SELECT url_id, country, url FROM link_targets WHERE url_id = ANY`).
+		WillReturnRows(sqlmock.NewRows([]string{"url_id", "country", "url"}).AddRow(1, "JP", "https://example.jp"))
+
+	repo := NewPostgresRedisRepository(db, redisClient, WithoutPreparedStatements(), WithCacheWarming(10*time.Minute))
+	ctx := context.Background()
+	n, err := repo.WarmCache(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("WarmCache() = %d, %v, want 2", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+
+	if ttl := mr.TTL("shorturl:id:1"); ttl != 20*time.Minute {
+		t.Errorf("warmed TTL = %v, want two intervals", ttl)
+	}
+	if ttl := mr.TTL("shorturl:code:promo"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("warmed TTL of an expiring link = %v, want its remaining lifetime", ttl)
+	}
+
+	// Redirects are then served without the database
+	link, err := repo.Get(ctx, 1)
+	if err != nil || len(link.Targets) != 1 || link.Targets[0].URL != "https://example.jp" {
+		t.Errorf("Get() = %+v, %v, want the warmed link with its targets", link, err)
+	}
+	if link, err := repo.GetByCode(ctx, "promo"); err != nil || link.Status != StatusDisabled {
+		t.Errorf("GetByCode() = %+v, %v, want the warmed disabled link", link, err)
+	}
+}
+
+func TestPostgresRedisRepository_PreloadCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer redisClient.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewPostgresRedisRepository(db, redisClient, WithoutPreparedStatements(),
+		WithLocalCache(100, time.Minute), WithCachePreload(2))
+	ctx := context.Background()
+	now := time.Now()
+	clicks := []struct {
+		id uint64
+		at time.Time
+		n  int
+	}{
+		{1, now, 2},
+		{1, now.Add(-24 * time.Hour), 1},
+		{2, now.Add(-24 * time.Hour), 1},
+		{3, now, 2},
+		{4, now.Add(-72 * time.Hour), 5},
+	}
+	for _, c := range clicks {
+		for range c.n {
+			if err := repo.RecordClick(ctx, &Link{ID: c.id}, c.at, ClickSource{}); err != nil {
+				t.Fatalf("RecordClick() error = %v", err)
+			}
+		}
+	}
+	if ttl := mr.TTL(hotLinksKey(now)); ttl != hotLinksTTL {
+		t.Errorf("hot links TTL = %v, want %v", ttl, hotLinksTTL)
+	}
+
+	// Link 3 is still in Redis; only link 1 is loaded from the database.
+	// Link 4 was clicked most, but too long ago.
+	cached, err := json.Marshal(&Link{ID: 3, OriginalURL: "https://example.net"})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	mr.Set("shorturl:id:3", string(cached))
+	mock.ExpectQuery(`SELECT .* FROM urls WHERE id = ANY\(\$1\) AND deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+			AddRow(1, "promo", "https://example.com", time.Now(), nil, nil, 0, "active", nil, true, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+	mock.ExpectQuery(`SELECT url_id, country, url FROM link_targets WHERE url_id = ANY`).
+		WillReturnRows(sqlmock.NewRows([]string{"url_id", "country", "url"}))
+
+	n, err := repo.PreloadCache(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("PreloadCache() = %d, %v, want 2", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+	if ttl := mr.TTL("shorturl:code:promo"); ttl <= 0 {
+		t.Errorf("preloaded TTL = %v, want the link cached in Redis", ttl)
+	}
+	if mr.Exists(hotLinksKeyPrefix + "preload") {
+		t.Error("PreloadCache() left the union of hot links behind")
+	}
+
+	// Both are then served from the local cache, without Redis or the
+	// database
+	mr.FlushAll()
+	if link, err := repo.Get(ctx, 3); err != nil || link.OriginalURL != "https://example.net" {
+		t.Errorf("Get() = %+v, %v, want the preloaded link", link, err)
+	}
+	if link, err := repo.GetByCode(ctx, "promo"); err != nil || link.ID != 1 {
+		t.Errorf("GetByCode() = %+v, %v, want the preloaded link", link, err)
+	}
+}
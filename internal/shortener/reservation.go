@@ -0,0 +1,146 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAliasReserved is returned for custom aliases matching an alias
+	// reservation that is not held by the organization of the link.
+	ErrAliasReserved = errors.New("custom alias is reserved")
+	// ErrInvalidReservation is returned for alias reservations with an
+	// invalid pattern or organization.
+	ErrInvalidReservation = errors.New("invalid alias reservation")
+	// ErrReservationExists is returned when a reservation already has the
+	// pattern and kind.
+	ErrReservationExists = errors.New("alias reservation already exists")
+)
+
+// maxReservationPrefixLength is the longest reserved prefix: one that long
+// leaves room for a single character of the minimum alias length.
+const maxReservationPrefixLength = maxAliasLength - 1
+
+// AliasReservation keeps custom aliases matching Pattern for the links of
+// the organization OrgID, or for nobody if OrgID is zero. A reserved word
+// matches the alias equal to it and a reserved prefix every alias starting
+// with it, whatever the case. Reservations only apply to aliases chosen
+// after they were added; links already using a matching alias keep it.
+type AliasReservation struct {
+	ID        uint64    `json:"id"`
+	Pattern   string    `json:"pattern"`
+	Prefix    bool      `json:"prefix"`
+	OrgID     uint64    `json:"org_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches reports whether alias is kept by the reservation.
+func (r *AliasReservation) Matches(alias string) bool {
+	alias = strings.ToLower(alias)
+	if r.Prefix {
+		return strings.HasPrefix(alias, r.Pattern)
+	}
+	return alias == r.Pattern
+}
+
+// checkReservations returns ErrAliasReserved if one of reservations keeps
+// the short code of link from the organization of link.
+func checkReservations(reservations []*AliasReservation, link *Link) error {
+	code := link.ShortCode()
+	for _, r := range reservations {
+		if r.Matches(code) && (r.OrgID == 0 || r.OrgID != link.OrgID) {
+			return fmt.Errorf("%w: %q", ErrAliasReserved, code)
+		}
+	}
+	return nil
+}
+
+// aliasChars reports whether s is only made of the characters allowed in
+// custom aliases.
+func aliasChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isAliasChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// AliasReservations returns the alias reservations, oldest first.
+func (s *Service) AliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	reservations, err := s.repo.ListAliasReservations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alias reservations: %w", err)
+	}
+	return reservations, nil
+}
+
+// AddAliasReservation stores res with its pattern lowercased. Returns
+// ErrInvalidReservation for a pattern that is not made of alias characters
+// or too long for a word or prefix, or for an organization that does not
+// exist, and ErrReservationExists if a reservation of the same kind
+// already has the pattern.
+func (s *Service) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	pattern := strings.ToLower(strings.TrimSpace(res.Pattern))
+	if res.Prefix {
+		if pattern == "" || len(pattern) > maxReservationPrefixLength || !aliasChars(pattern) {
+			return fmt.Errorf("%w: a prefix must be 1 to %d alias characters", ErrInvalidReservation, maxReservationPrefixLength)
+		}
+	} else if err := ValidateAlias(pattern); errors.Is(err, ErrInvalidAlias) {
+		return fmt.Errorf("%w: %v", ErrInvalidReservation, err)
+	}
+	if res.OrgID != 0 {
+		if _, err := s.repo.GetOrg(ctx, res.OrgID); errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: organization %d does not exist", ErrInvalidReservation, res.OrgID)
+		} else if err != nil {
+			return fmt.Errorf("failed to get organization: %w", err)
+		}
+	}
+	res.Pattern = pattern
+	if err := s.repo.AddAliasReservation(ctx, res); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return ErrReservationExists
+		}
+		return fmt.Errorf("failed to add alias reservation: %w", err)
+	}
+	return nil
+}
+
+// DeleteAliasReservation removes the alias reservation with ID id. Returns
+// ErrNotFound if there is none.
+func (s *Service) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	if err := s.repo.DeleteAliasReservation(ctx, id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to delete alias reservation: %w", err)
+	}
+	return nil
+}
+
+// scanAliasReservations scans and closes rows selected with
+// aliasReservationColumns.
+func scanAliasReservations(rows *sql.Rows) ([]*AliasReservation, error) {
+	defer rows.Close()
+	reservations := []*AliasReservation{}
+	for rows.Next() {
+		var r AliasReservation
+		var orgID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Pattern, &r.Prefix, &orgID, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan alias reservation: %w", err)
+		}
+		r.OrgID = uint64(orgID.Int64)
+		reservations = append(reservations, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read alias reservations: %w", err)
+	}
+	return reservations, nil
+}
+
+// aliasReservationColumns are the columns scanned by scanAliasReservations.
+const aliasReservationColumns = `id, pattern, prefix, org_id, created_at`
@@ -0,0 +1,106 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestService_AliasReservations(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	bob, _, err := svc.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	acme, err := svc.CreateOrg(ctx, alice, "Acme")
+	if err != nil {
+		t.Fatalf("CreateOrg() error = %v", err)
+	}
+
+	for _, res := range []*AliasReservation{
+		{Pattern: "Login"},
+		{Pattern: "acme-", Prefix: true, OrgID: acme.ID},
+		{Pattern: "zz", Prefix: true},
+	} {
+		if err := svc.AddAliasReservation(ctx, res); err != nil {
+			t.Fatalf("AddAliasReservation(%q) error = %v", res.Pattern, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		alias   string
+		owner   *User
+		orgID   uint64
+		wantErr error
+	}{
+		{name: "reserved word", alias: "login", owner: bob, wantErr: ErrAliasReserved},
+		{name: "reserved word in another case", alias: "LOGIN", owner: bob, wantErr: ErrAliasReserved},
+		{name: "longer than a reserved word", alias: "login2", owner: bob},
+		{name: "reserved prefix", alias: "zz-top", owner: bob, wantErr: ErrAliasReserved},
+		{name: "reserved prefix for everybody", alias: "zzz", owner: alice, orgID: acme.ID, wantErr: ErrAliasReserved},
+		{name: "prefix claimed by another organization", alias: "acme-launch", owner: bob, wantErr: ErrAliasReserved},
+		{name: "prefix claimed by the organization", alias: "acme-launch", owner: alice, orgID: acme.ID},
+		{name: "prefix claimed by the organization of the owner", alias: "acme-sale", owner: alice, wantErr: ErrAliasReserved},
+		{name: "reserved alias already taken", alias: "acme-launch", owner: alice, orgID: acme.ID, wantErr: ErrAliasTaken},
+		{name: "built-in route", alias: "docs", owner: bob, wantErr: ErrAliasReserved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{CustomAlias: tt.alias, Owner: tt.owner, OrgID: tt.orgID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ShortenWithOptions(%q) error = %v, want %v", tt.alias, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_AddAliasReservation(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+
+	res := &AliasReservation{Pattern: " Promo- ", Prefix: true}
+	if err := svc.AddAliasReservation(ctx, res); err != nil {
+		t.Fatalf("AddAliasReservation() error = %v", err)
+	}
+	if res.ID == 0 || res.Pattern != "promo-" || res.CreatedAt.IsZero() {
+		t.Errorf("AddAliasReservation() reservation = %+v", res)
+	}
+
+	tests := []struct {
+		name    string
+		res     *AliasReservation
+		wantErr error
+	}{
+		{name: "same prefix", res: &AliasReservation{Pattern: "PROMO-", Prefix: true}, wantErr: ErrReservationExists},
+		{name: "word of a prefix", res: &AliasReservation{Pattern: "promo-"}},
+		{name: "short word", res: &AliasReservation{Pattern: "ab"}, wantErr: ErrInvalidReservation},
+		{name: "word with a slash", res: &AliasReservation{Pattern: "a/b"}, wantErr: ErrInvalidReservation},
+		{name: "empty prefix", res: &AliasReservation{Pattern: "", Prefix: true}, wantErr: ErrInvalidReservation},
+		{name: "one character prefix", res: &AliasReservation{Pattern: "x", Prefix: true}},
+		{name: "unknown organization", res: &AliasReservation{Pattern: "acme", OrgID: 42}, wantErr: ErrInvalidReservation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.AddAliasReservation(ctx, tt.res); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddAliasReservation(%+v) error = %v, want %v", tt.res, err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := svc.DeleteAliasReservation(ctx, res.ID); err != nil {
+		t.Fatalf("DeleteAliasReservation() error = %v", err)
+	}
+	if err := svc.DeleteAliasReservation(ctx, res.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteAliasReservation() twice error = %v, want ErrNotFound", err)
+	}
+	reservations, err := svc.AliasReservations(ctx)
+	if err != nil || len(reservations) != 2 {
+		t.Errorf("AliasReservations() = %+v, %v, want the word and the one character prefix", reservations, err)
+	}
+}
@@ -0,0 +1,127 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// reshardBatchSize is the number of links Reshard moves per transaction.
+const reshardBatchSize = 500
+
+// linkTables are the tables holding the rows of a link, urls first, with the
+// column referencing the link. Columns lists the columns to copy of tables
+// whose IDs come from a sequence of each shard, leaving the IDs to the
+// destination; the other tables are copied whole.
+var linkTables = []struct {
+	name, key, columns string
+}{
+	{name: "urls", key: "id"},
+	{name: "link_targets", key: "url_id"},
+	{name: "variant_clicks", key: "url_id"},
+	{name: "link_health", key: "url_id"},
+	{name: "clicks_hourly", key: "url_id"},
+	{name: "clicks_daily", key: "url_id"},
+	{name: "click_sources", key: "url_id"},
+	{name: "abuse_reports", key: "url_id", columns: "url_id, reason, reporter, created_at, resolved_at"},
+}
+
+// Reshard moves links between the PostgreSQL shard databases dbs so that
+// each ends up on the shard to assigns it, and returns how many it moved.
+// The first to.Len() databases are the shards of to, primary first; any
+// further ones are being retired and are emptied. Links with free-form
+// codes stay on the primary.
+//
+// Each batch is copied in one transaction on the destination, with its
+// targets, click rollups, variant counts, health checks and abuse reports,
+// and then deleted from the source, so an interrupted run is completed by
+// running it again.
+// Instances must be stopped meanwhile: they would route lookups with the old
+// map and write clicks to the old shards.
+func Reshard(ctx context.Context, dbs []*sql.DB, to ShardMap) (int, error) {
+	if len(dbs) < to.Len() {
+		return 0, fmt.Errorf("shard map has %d shards, got %d databases", to.Len(), len(dbs))
+	}
+
+	moved := 0
+	for src, db := range dbs {
+		var cursor int64
+		for {
+			rows, err := db.QueryContext(ctx, `SELECT id FROM urls WHERE id > $1 AND code IS NULL ORDER BY id LIMIT $2`, cursor, reshardBatchSize)
+			if err != nil {
+				return moved, fmt.Errorf("failed to list links of shard %d: %w", src, err)
+			}
+			var ids []int64
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return moved, fmt.Errorf("failed to scan link of shard %d: %w", src, err)
+				}
+				ids = append(ids, id)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return moved, fmt.Errorf("failed to list links of shard %d: %w", src, err)
+			}
+			if len(ids) == 0 {
+				break
+			}
+			cursor = ids[len(ids)-1]
+
+			byDst := make(map[int][]int64)
+			for _, id := range ids {
+				if dst := to.Shard(uint64(id)); dst != src {
+					byDst[dst] = append(byDst[dst], id)
+				}
+			}
+			for dst, batch := range byDst {
+				if err := moveLinks(ctx, db, dbs[dst], batch); err != nil {
+					return moved, fmt.Errorf("failed to move links from shard %d to %d: %w", src, dst, err)
+				}
+				moved += len(batch)
+			}
+			if len(ids) < reshardBatchSize {
+				break
+			}
+		}
+	}
+	return moved, nil
+}
+
+// moveLinks copies the links ids with all their rows from src to dst and
+// then deletes them from src, whose foreign keys cascade the deletion. Rows
+// travel as JSON so that PostgreSQL converts every column type itself; rows
+// already on dst, left by an interrupted run, are kept.
+func moveLinks(ctx context.Context, src, dst *sql.DB, ids []int64) error {
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, t := range linkTables {
+		var rows []byte
+		query := fmt.Sprintf(`SELECT COALESCE(json_agg(t), '[]') FROM %s t WHERE %s = ANY($1)`, t.name, t.key)
+		if err := src.QueryRowContext(ctx, query, pq.Array(ids)).Scan(&rows); err != nil {
+			return fmt.Errorf("failed to read %s: %w", t.name, err)
+		}
+		insert := fmt.Sprintf(`INSERT INTO %[1]s SELECT * FROM json_populate_recordset(NULL::%[1]s, $1) ON CONFLICT DO NOTHING`, t.name)
+		if t.columns != "" {
+			insert = fmt.Sprintf(`INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM json_populate_recordset(NULL::%[1]s, $1) ON CONFLICT DO NOTHING`, t.name, t.columns)
+		}
+		if _, err := tx.ExecContext(ctx, insert, string(rows)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", t.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+
+	if _, err := src.ExecContext(ctx, `DELETE FROM urls WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to delete moved links: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package shortener
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// RetryPolicy retries operations failing with transient errors, waiting a
+// random time up to an exponentially growing bound between attempts ("full
+// jitter"), so that instances recovering from the same outage do not retry
+// in lockstep.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt; 1 or less disables retries.
+	MaxAttempts int
+	// BaseDelay bounds the wait before the first retry, doubling for each
+	// further one up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy retries twice, waiting 60ms at most in total, which the
+// redirect path can afford.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 200 * time.Millisecond}
+
+// do runs fn until it succeeds, fails with an error transient rejects, the
+// attempts are used up or ctx is done. backend labels the metrics.
+func (p RetryPolicy) do(ctx context.Context, backend string, transient func(error) bool, fn func() error) error {
+	err := fn()
+	for attempt := 1; err != nil && transient(err); attempt++ {
+		if attempt >= p.MaxAttempts {
+			retryMetrics.Add(backend+"_exhausted", 1)
+			return err
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		retryMetrics.Add(backend, 1)
+		err = fn()
+	}
+	return err
+}
+
+// backoff returns the wait before retry number attempt (from 1).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	bound := p.BaseDelay << (attempt - 1)
+	if bound <= 0 || bound > p.MaxDelay {
+		bound = p.MaxDelay
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound + 1)
+}
+
+// transientPostgres reports whether err may go away by itself: lost or
+// refused connections, serialization failures and deadlocks, and a server
+// that is shutting down or out of connections. The statement is known not to
+// have taken effect for the PostgreSQL errors only, so writes should use
+// retryableWrite instead.
+func transientPostgres(err error) bool {
+	if retryableWrite(err) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08: connection exceptions
+		return pqErr.Code.Class() == "08"
+	}
+	return transientNetwork(err)
+}
+
+// retryableWrite reports whether err aborted a statement that can be run
+// again as is: serialization failures and deadlocks roll back the
+// transaction, and errors before a statement was sent leave nothing behind.
+func retryableWrite(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", "57P01", "57P03", "53300":
+			// serialization_failure, deadlock_detected, admin_shutdown,
+			// cannot_connect_now, too_many_connections
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// transientRedis reports whether a Redis command may succeed if repeated:
+// network errors, and servers loading their data set, failing over or asking
+// to try again. redis.Nil (a missing key) is not an error worth retrying.
+func transientRedis(err error) bool {
+	if errors.Is(err, redis.Nil) {
+		return false
+	}
+	for _, prefix := range []string{"LOADING ", "READONLY ", "TRYAGAIN ", "CLUSTERDOWN ", "MASTERDOWN "} {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return true
+		}
+	}
+	return transientNetwork(err)
+}
+
+// transientNetwork reports whether err is a network failure, such as a
+// timeout or a connection reset by a restarting server. Cancellation of the
+// caller's context is not.
+func transientNetwork(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,142 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	transientErr := errors.New("transient")
+	isTransient := func(err error) bool { return errors.Is(err, transientErr) }
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "succeeds at once", errs: []error{nil}, wantCalls: 1},
+		{name: "succeeds on retry", errs: []error{transientErr, transientErr, nil}, wantCalls: 3},
+		{name: "gives up", errs: []error{transientErr, transientErr, transientErr, nil}, wantCalls: 3, wantErr: transientErr},
+		{name: "permanent error", errs: []error{sql.ErrNoRows, nil}, wantCalls: 1, wantErr: sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := policy.do(context.Background(), "test", isTransient, func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if !errors.Is(err, tt.wantErr) || calls != tt.wantCalls {
+				t.Errorf("do() = %v after %d calls, want %v after %d", err, calls, tt.wantErr, tt.wantCalls)
+			}
+		})
+	}
+
+	// A cancelled context stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	slow := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	if err := slow.do(ctx, "test", isTransient, func() error { calls++; return transientErr }); !errors.Is(err, transientErr) || calls != 1 {
+		t.Errorf("do() with a cancelled context = %v after %d calls, want the first error", err, calls)
+	}
+
+	for attempt := 1; attempt < 10; attempt++ {
+		if d := policy.backoff(attempt); d < 0 || d > policy.MaxDelay {
+			t.Errorf("backoff(%d) = %v, want up to %v", attempt, d, policy.MaxDelay)
+		}
+	}
+}
+
+func TestTransientErrors(t *testing.T) {
+	tests := []struct {
+		err                       error
+		postgres, write, redisErr bool
+	}{
+		{err: &pq.Error{Code: "40001"}, postgres: true, write: true},
+		{err: &pq.Error{Code: "08006"}, postgres: true},
+		{err: &pq.Error{Code: "23505"}},
+		{err: driver.ErrBadConn, postgres: true, write: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), postgres: true, redisErr: true},
+		{err: io.EOF, postgres: true, redisErr: true},
+		{err: redis.Nil},
+		{err: errors.New("LOADING Redis is loading the dataset in memory"), redisErr: true},
+		{err: context.DeadlineExceeded},
+		{err: sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		if got := transientPostgres(tt.err); got != tt.postgres {
+			t.Errorf("transientPostgres(%v) = %v, want %v", tt.err, got, tt.postgres)
+		}
+		if got := retryableWrite(tt.err); got != tt.write {
+			t.Errorf("retryableWrite(%v) = %v, want %v", tt.err, got, tt.write)
+		}
+		if got := transientRedis(tt.err); got != tt.redisErr {
+			t.Errorf("transientRedis(%v) = %v, want %v", tt.err, got, tt.redisErr)
+		}
+	}
+}
+
+func TestPostgresRedisRepository_RetriesTransientErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	select1 := `SELECT id, code, original_url, created_at, expires_at, max_clicks, click_count, status, owner_id, custom_alias, rules, experiment, active_from, active_until, page, campaign_id, domain, analytics_disabled, org_id, metadata, query_passthrough, mask, burn_after_reading, consumed_at, access FROM urls WHERE id = \$1`
+	mock.ExpectQuery(select1).WithArgs(int64(1)).WillReturnError(&pq.Error{Code: "57P01"})
+	mock.ExpectQuery(select1).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}).
+			AddRow(1, nil, "https://example.com", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+	mock.ExpectQuery(`SELECT country, url FROM link_targets`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
+	// A connection lost after an INSERT was sent may have stored the link,
+	// so it is not retried
+	insert := `INSERT INTO urls`
+	mock.ExpectQuery(insert).WillReturnError(fmt.Errorf("write: %w", syscall.ECONNRESET))
+	mock.ExpectQuery(insert).WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectQuery(insert).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+
+	repo := NewPostgresRedisRepository(db, nil, WithoutPreparedStatements(),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}))
+	ctx := context.Background()
+	retries := func() int64 {
+		n, _ := retryMetrics.Get("postgres").(*expvar.Int)
+		if n == nil {
+			return 0
+		}
+		return n.Value()
+	}
+	before := retries()
+
+	if link, err := repo.Get(ctx, 1); err != nil || link.OriginalURL != "https://example.com" {
+		t.Errorf("Get() = %+v, %v, want the link after a retry", link, err)
+	}
+	if _, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"}); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("Save() error = %v, want the connection error", err)
+	}
+	if id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"}); err != nil || id != 2 {
+		t.Errorf("Save() = %d, %v, want 2 after retrying a serialization failure", id, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+	if got := retries() - before; got != 2 {
+		t.Errorf("retries metric grew by %d, want 2", got)
+	}
+}
@@ -0,0 +1,44 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Roles of a user, from least to most privileged. Each role may do what the
+// ones before it may.
+const (
+	// RoleViewer may list its links and read their stats.
+	RoleViewer = "viewer"
+	// RoleEditor may also create and edit links. New users are editors.
+	RoleEditor = "editor"
+	// RoleAdmin may also delete links and use the admin API.
+	RoleAdmin = "admin"
+)
+
+// ErrUnknownRole is returned when setting a role that is not one of the Role
+// constants.
+var ErrUnknownRole = errors.New("unknown role")
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// HasRole reports whether u may do what role may.
+func (u *User) HasRole(role string) bool {
+	return roleRanks[u.Role] >= roleRanks[role] && roleRanks[u.Role] > 0
+}
+
+// SetRole gives the user userID role. Returns ErrUnknownRole for roles that
+// are not one of the Role constants, or ErrNotFound for unknown users.
+func (s *Service) SetRole(ctx context.Context, userID uint64, role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return ErrUnknownRole
+	}
+	if err := s.repo.SetUserRole(ctx, userID, role); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	return nil
+}
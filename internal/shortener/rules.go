@@ -0,0 +1,129 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxRules caps the routing rules of a single link.
+const MaxRules = 10
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Device is the kind of client a visitor uses, as far as the User-Agent
+// tells.
+type Device string
+
+const (
+	DeviceIOS     Device = "ios"
+	DeviceAndroid Device = "android"
+	DeviceDesktop Device = "desktop"
+)
+
+// ParseDevice classifies a User-Agent header. Mobile clients other than iOS
+// and Android, and empty User-Agents, are unknown (""). iPads in desktop
+// mode report a macOS User-Agent and are classified as desktop.
+func ParseDevice(userAgent string) Device {
+	switch {
+	case userAgent == "":
+		return ""
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return DeviceIOS
+	case strings.Contains(userAgent, "Android"):
+		return DeviceAndroid
+	case strings.Contains(userAgent, "Mobile"):
+		return ""
+	default:
+		return DeviceDesktop
+	}
+}
+
+// Rule sends visitors matching all of its conditions to URL. At least one
+// condition must be set.
+type Rule struct {
+	Device Device `json:"device,omitempty"`
+	URL    string `json:"url"`
+}
+
+// Matches reports whether v satisfies the conditions of r.
+func (r Rule) Matches(v Visitor) bool {
+	return r.Device != "" && r.Device == v.Device
+}
+
+func (r Rule) validate() error {
+	switch r.Device {
+	case DeviceIOS, DeviceAndroid, DeviceDesktop:
+	case "":
+		return fmt.Errorf("%w: a condition is required", ErrInvalidRule)
+	default:
+		return fmt.Errorf("%w: unknown device %q (use ios, android or desktop)", ErrInvalidRule, r.Device)
+	}
+	if err := ValidateURL(r.URL); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRule, err)
+	}
+	return nil
+}
+
+// SetRules replaces the routing rules of the link behind shortCode, which
+// user must own. Returns ErrInvalidRule for malformed or duplicate rules and
+// ErrUnsafeURL if a rule URL is reported as malicious.
+func (s *Service) SetRules(ctx context.Context, user *User, shortCode string, rules []Rule) (*Link, error) {
+	if len(rules) > MaxRules {
+		return nil, fmt.Errorf("%w: at most %d rules are allowed", ErrInvalidRule, MaxRules)
+	}
+	seen := make(map[Rule]struct{}, len(rules))
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		// A second rule with the same conditions could never match
+		conditions := Rule{Device: r.Device}
+		if _, dup := seen[conditions]; dup {
+			return nil, fmt.Errorf("%w: device %s is listed twice", ErrInvalidRule, r.Device)
+		}
+		seen[conditions] = struct{}{}
+	}
+
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if err := s.screen(ctx, r.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rules) == 0 {
+		rules = nil
+	}
+	if err := s.repo.SetRules(ctx, link, rules); err != nil {
+		return nil, fmt.Errorf("failed to set rules: %w", err)
+	}
+	link.Rules = rules
+	s.audit(ctx, UserActor(user), AuditSetRules, link.ShortCode(), fmt.Sprintf("%d rules", len(rules)))
+	return link, nil
+}
+
+// marshalRules encodes rules for the rules column, storing none as NULL.
+func marshalRules(rules []Rule) ([]byte, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(rules)
+}
+
+// unmarshalRules decodes the rules column.
+func unmarshalRules(data []byte) ([]Rule, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode rules: %w", err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,94 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      Device
+	}{
+		{name: "empty", userAgent: "", want: ""},
+		{name: "iPhone", userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", want: DeviceIOS},
+		{name: "iPad", userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", want: DeviceIOS},
+		{name: "Android", userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36", want: DeviceAndroid},
+		{name: "other mobile", userAgent: "Mozilla/5.0 (Mobile; rv:48.0) Gecko/48.0 Firefox/48.0 KAIOS/2.5", want: ""},
+		{name: "Windows", userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", want: DeviceDesktop},
+		{name: "macOS", userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15", want: DeviceDesktop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseDevice(tt.userAgent); got != tt.want {
+				t.Errorf("ParseDevice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_SetRules(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/app", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	invalid := [][]Rule{
+		{{URL: "https://example.com/any"}},
+		{{Device: "windows-phone", URL: "https://example.com/wp"}},
+		{{Device: DeviceIOS, URL: "itms-apps://apps.apple.com/app/id1"}},
+		{{Device: DeviceIOS, URL: "https://example.com/1"}, {Device: DeviceIOS, URL: "https://example.com/2"}},
+	}
+	for _, rules := range invalid {
+		if _, err := service.SetRules(ctx, user, code, rules); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("SetRules(%+v) error = %v, want ErrInvalidRule", rules, err)
+		}
+	}
+
+	if _, err := service.SetRules(ctx, user, code, []Rule{
+		{Device: DeviceIOS, URL: "https://apps.apple.com/app/id1"},
+		{Device: DeviceAndroid, URL: "https://play.google.com/store/apps/details?id=com.example"},
+	}); err != nil {
+		t.Fatalf("SetRules() unexpected error = %v", err)
+	}
+	if _, err := service.SetTargets(ctx, user, code, []Target{{Country: "US", URL: "https://example.com/us"}}); err != nil {
+		t.Fatalf("SetTargets() unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		visitor Visitor
+		want    string
+	}{
+		{visitor: Visitor{Device: DeviceIOS}, want: "https://apps.apple.com/app/id1"},
+		{visitor: Visitor{Device: DeviceAndroid, Country: "US"}, want: "https://play.google.com/store/apps/details?id=com.example"},
+		{visitor: Visitor{Device: DeviceDesktop, Country: "US"}, want: "https://example.com/us"},
+		{visitor: Visitor{Device: DeviceDesktop}, want: "https://example.com/app"},
+		{visitor: Visitor{}, want: "https://example.com/app"},
+	}
+	for _, tt := range tests {
+		got, err := service.RedirectVisitor(ctx, code, tt.visitor)
+		if err != nil {
+			t.Fatalf("RedirectVisitor(%+v) unexpected error = %v", tt.visitor, err)
+		}
+		if got.URL != tt.want {
+			t.Errorf("RedirectVisitor(%+v) = %q, want %q", tt.visitor, got.URL, tt.want)
+		}
+	}
+
+	other, _, err := service.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	if _, err := service.SetRules(ctx, other, code, nil); !errors.Is(err, ErrForbidden) {
+		t.Errorf("SetRules() by another user error = %v, want ErrForbidden", err)
+	}
+}
@@ -4,47 +4,765 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hszk-dev/url-shortener/internal/jobs"
+)
+
+const (
+	// DefaultListLimit is the page size used when the caller does not specify one.
+	DefaultListLimit = 20
+	// MaxListLimit caps the page size to keep responses and queries bounded.
+	MaxListLimit = 100
+	// DefaultMaxURLLength is the longest destination accepted unless
+	// WithMaxURLLength says otherwise. Longer URLs are not reliably
+	// followed by browsers and crawlers.
+	DefaultMaxURLLength = 2048
 )
 
 var (
 	ErrInvalidShortCode = errors.New("invalid short code")
+	ErrInvalidExpiry    = errors.New("expiry must be in the future")
+	ErrExpired          = errors.New("url has expired")
+	ErrInvalidMaxClicks = errors.New("max clicks must be positive")
+	// ErrClickLimitReached is returned by Redirect once a link has been
+	// followed MaxClicks times.
+	ErrClickLimitReached = errors.New("url has reached its click limit")
+	// ErrConsumed is returned for links that burn after reading once they
+	// have been followed.
+	ErrConsumed = errors.New("url has already been used")
+	// ErrBurnAfterReading is returned by Preview for links that burn after
+	// reading, whose destination only the redirect consuming them reveals.
+	ErrBurnAfterReading = errors.New("url can only be followed once")
+	// ErrDisabled is returned for links an administrator has disabled and
+	// for links suspended pending abuse review.
+	ErrDisabled      = errors.New("url has been disabled")
+	ErrInvalidStatus = errors.New("invalid status")
+	// ErrNotYetActive and ErrNoLongerActive are returned for links followed
+	// before or after their activation window.
+	ErrNotYetActive        = errors.New("url is not yet active")
+	ErrNoLongerActive      = errors.New("url is no longer active")
+	ErrInvalidActiveWindow = errors.New("active_until must be after active_from and in the future")
+	// ErrURLTooLong is returned for destinations longer than the maximum
+	// URL length.
+	ErrURLTooLong = errors.New("url is too long")
 )
 
 type Service struct {
-	repo Repository
+	repo    Repository
+	now     func() time.Time
+	checker URLChecker
+	codes   CodeStrategy
+	// alphabet writes generated codes; nil means Base62.
+	alphabet *Alphabet
+	// codePolicy constrains the codes of new links.
+	codePolicy CodePolicy
+	// visitors counts unique visitors for TopStats; nil disables counting.
+	visitors VisitorCounter
+	// reportThreshold is the number of open abuse reports that suspends a
+	// link; zero disables suspension.
+	reportThreshold int64
+	// plans are the quota tiers by name (see WithPlans); defaultPlan is
+	// assigned to new users.
+	plans       map[string]Plan
+	defaultPlan string
+	// intN draws the random numbers that pick experiment variants.
+	intN func(n int) int
+	// pages fetches destination pages on pageWorkers workers; nil disables
+	// fetching.
+	pages       PageFetcher
+	pageWorkers int
+	// queue runs the background jobs of the service.
+	queue *jobs.Queue
+	// maxURLLength is the longest destination accepted, in bytes.
+	maxURLLength int
+	// normalization is applied to destinations before they are stored.
+	normalization URLNormalization
+	// homographs is what happens to links to likely homograph domains.
+	homographs HomographPolicy
+	// domainLists are the configured domain rules.
+	domainLists DomainLists
+	// baseHost and basePath locate the short links of the service, with
+	// basePath ending in a slash; an empty baseHost knows none.
+	baseHost string
+	basePath string
+	// shorteners are the hosts of other URL shorteners.
+	shorteners []string
+	// resolver verifies custom domains; nil means net.DefaultResolver.
+	resolver TXTResolver
+	// hourlyRetention and dailyRetention are how long PurgeClicks keeps
+	// each rollup; zero keeps it forever.
+	hourlyRetention time.Duration
+	dailyRetention  time.Duration
+	// signingSecret derives the secrets API keys sign requests with; empty
+	// disables signing.
+	signingSecret []byte
+	// auditListener is told about audit entries once they are recorded;
+	// nil means nobody listens.
+	auditListener func(ctx context.Context, e AuditEntry)
+	// masks are the masks links may have; none are enabled by default.
+	masks []Mask
+
+	// prober checks destinations for CheckHealth under healthPolicy; nil
+	// disables health checks.
+	prober       HealthProber
+	healthPolicy HealthCheckPolicy
+
+	// rescanMu guards rescanCursor, the ID below which Rescan continues.
+	rescanMu     sync.Mutex
+	rescanCursor uint64
+	// healthMu guards healthCursor, the ID below which CheckHealth
+	// continues.
+	healthMu     sync.Mutex
+	healthCursor uint64
+	// importsMu guards imports, the URL imports by ID.
+	importsMu sync.Mutex
+	imports   map[string]*ImportStatus
+}
+
+// Option configures optional Service dependencies.
+type Option func(*Service)
+
+// WithCodeStrategy sets how codes are assigned to links created without a
+// custom alias. The default is SequentialCodes.
+func WithCodeStrategy(c CodeStrategy) Option {
+	return func(s *Service) {
+		s.codes = c
+	}
+}
+
+// WithAlphabet writes generated codes with a instead of Base62, for links
+// that do not pick an alphabet of their own. a must map to 63-bit IDs under
+// the code strategy: Snowflake IDs are too large for anything but Base62.
+func WithAlphabet(a *Alphabet) Option {
+	return func(s *Service) {
+		s.alphabet = a
+	}
+}
+
+// WithURLChecker screens destinations with c when shortening and in Rescan.
+func WithURLChecker(c URLChecker) Option {
+	return func(s *Service) {
+		s.checker = c
+	}
 }
 
-func NewService(repo Repository) *Service {
-	return &Service{
-		repo: repo,
+// WithVisitorCounter counts the unique visitors of redirects with c.
+func WithVisitorCounter(c VisitorCounter) Option {
+	return func(s *Service) {
+		s.visitors = c
 	}
 }
 
+// WithMaxURLLength sets the longest destination accepted, in bytes. The
+// default is DefaultMaxURLLength.
+func WithMaxURLLength(n int) Option {
+	return func(s *Service) {
+		s.maxURLLength = n
+	}
+}
+
+// WithURLNormalization normalizes destinations with n before they are
+// stored. The default is DefaultURLNormalization.
+func WithURLNormalization(n URLNormalization) Option {
+	return func(s *Service) {
+		s.normalization = n
+	}
+}
+
+// WithClickRetention sets how long PurgeClicks keeps hourly and daily click
+// rollups. Zero keeps a rollup forever.
+func WithClickRetention(hourly, daily time.Duration) Option {
+	return func(s *Service) {
+		s.hourlyRetention = hourly
+		s.dailyRetention = daily
+	}
+}
+
+// WithAuditListener calls f with every audit entry, after it is recorded
+// and even if recording failed, so that changes to links can be published
+// as they happen. f must not block.
+func WithAuditListener(f func(ctx context.Context, e AuditEntry)) Option {
+	return func(s *Service) {
+		s.auditListener = f
+	}
+}
+
+func NewService(repo Repository, opts ...Option) *Service {
+	s := &Service{
+		repo:          repo,
+		now:           time.Now,
+		codes:         SequentialCodes{},
+		defaultPlan:   DefaultPlan,
+		intN:          rand.IntN,
+		maxURLLength:  DefaultMaxURLLength,
+		normalization: DefaultURLNormalization,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.queue == nil {
+		s.queue = jobs.New(jobs.NewMemoryStore())
+	}
+	s.handleJobs()
+	return s
+}
+
+// ShortenOptions carries optional per-link settings for ShortenWithOptions.
+type ShortenOptions struct {
+	// CustomAlias is a user-chosen short code. Empty means generate one from the ID.
+	CustomAlias string
+	// SlugFrom is a title whose slug becomes the custom alias, numbered if
+	// it is taken. It cannot be combined with CustomAlias.
+	SlugFrom string
+	// ExpiresAt is the instant after which the link stops redirecting. Nil means never.
+	ExpiresAt *time.Time
+	// MaxClicks is the number of redirects the link allows. Zero means unlimited.
+	MaxClicks int64
+	// Owner is the user creating the link, whose plan limits apply. Nil
+	// means anonymous.
+	Owner *User
+	// UTM tags are appended to the destination before it is stored.
+	UTM UTM
+	// ActiveFrom and ActiveUntil bound when the link redirects. Nil means
+	// unbounded.
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// Alphabet writes the generated code. Nil means the service default;
+	// it cannot be combined with CustomAlias or SlugFrom.
+	Alphabet *Alphabet
+	// CampaignID adds the link to a campaign of Owner. Zero means none.
+	CampaignID uint64
+	// Domain binds the link to a verified custom domain of Owner. Empty
+	// means the link is served on any host.
+	Domain string
+	// DisableAnalytics opts the link out of click tracking.
+	DisableAnalytics bool
+	// OrgID gives the link to an organization of Owner, whose members all
+	// manage it. Zero means none.
+	OrgID uint64
+	// QueryPassthrough carries the query string of redirect requests over
+	// to the destination. Empty means off.
+	QueryPassthrough QueryPassthrough
+	// Mask hides the destination from visitors; it must be enabled with
+	// WithMasking. Empty means off.
+	Mask Mask
+	// BurnAfterReading makes the link redirect once.
+	BurnAfterReading bool
+}
+
 func (s *Service) Shorten(ctx context.Context, originalURL string) (string, error) {
-	// 1. Save to DB to get unique ID
-	id, err := s.repo.Save(ctx, originalURL)
+	return s.ShortenWithOptions(ctx, originalURL, ShortenOptions{})
+}
+
+// ShortenWithOptions creates a short code for originalURL honoring opts.
+// Returns ErrInvalidAlias, ErrAliasReserved or ErrAliasTaken for rejected
+// custom aliases or slugs and
+// ErrInvalidExpiry if opts.ExpiresAt is not in the future,
+// ErrInvalidMaxClicks if opts.MaxClicks is negative, ErrInvalidActiveWindow
+// for an empty or past activation window, ErrURLTooLong if originalURL (with
+// its UTM tags) exceeds the maximum length and ErrUnsafeURL if the URL checker
+// reports originalURL as malicious. ErrInvalidHost is returned for invalid
+// internationalized domain names and ErrHomograph for likely homographs under
+// HomographBlock. ErrInvalidAlphabet is returned if opts.Alphabet is set
+// with opts.CustomAlias or opts.SlugFrom, ErrInvalidCampaign if
+// opts.CampaignID is not a campaign of opts.Owner and ErrInvalidDomain if
+// opts.Domain is not a verified domain of opts.Owner, and ErrInvalidOrg if
+// opts.Owner is not a member of opts.OrgID, ErrInvalidQueryPassthrough
+// for an unknown opts.QueryPassthrough and ErrInvalidMask for an unknown or
+// disabled opts.Mask. A *QuotaError is returned if
+// opts.Owner has reached a limit of their plan.
+func (s *Service) ShortenWithOptions(ctx context.Context, originalURL string, opts ShortenOptions) (string, error) {
+	link, homograph, err := s.prepareLink(ctx, originalURL, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var code string
+	switch {
+	case opts.CustomAlias != "":
+		code, err = s.shortenWithAlias(ctx, link, opts.CustomAlias)
+	case opts.SlugFrom != "":
+		code, err = s.shortenWithSlug(ctx, link, opts.SlugFrom)
+	default:
+		code, err = s.shortenGenerated(ctx, link, opts.Alphabet)
+	}
+	if err != nil {
+		return "", err
+	}
+	s.linkCreated(ctx, link, code, opts.Owner, homograph)
+	return code, nil
+}
+
+// prepareLink checks originalURL and opts as documented on
+// ShortenWithOptions and returns the link to save, without its ID or code,
+// and the homograph host of its destination if it has one.
+func (s *Service) prepareLink(ctx context.Context, originalURL string, opts ShortenOptions) (*Link, string, error) {
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(s.now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+	if opts.MaxClicks < 0 {
+		return nil, "", ErrInvalidMaxClicks
+	}
+	if opts.ActiveUntil != nil && (!opts.ActiveUntil.After(s.now()) ||
+		opts.ActiveFrom != nil && !opts.ActiveUntil.After(*opts.ActiveFrom)) {
+		return nil, "", ErrInvalidActiveWindow
+	}
+	if opts.CustomAlias != "" && opts.SlugFrom != "" {
+		return nil, "", fmt.Errorf("%w: a custom alias cannot also be made from a slug", ErrInvalidAlias)
+	}
+	if (opts.CustomAlias != "" || opts.SlugFrom != "") && opts.Alphabet != nil {
+		return nil, "", fmt.Errorf("%w: custom aliases have no alphabet", ErrInvalidAlphabet)
+	}
+	if _, err := ParseQueryPassthrough(string(opts.QueryPassthrough)); err != nil {
+		return nil, "", err
+	}
+	if err := s.checkMask(opts.Mask); err != nil {
+		return nil, "", err
+	}
+	if opts.CampaignID != 0 {
+		if err := s.checkCampaign(ctx, opts.Owner, opts.CampaignID); err != nil {
+			return nil, "", err
+		}
+	}
+	if opts.OrgID != 0 {
+		if err := s.checkOrg(ctx, opts.Owner, opts.OrgID); err != nil {
+			return nil, "", err
+		}
+	}
+	if opts.Domain != "" {
+		domain, err := s.checkDomain(ctx, opts.Owner, opts.Domain)
+		if err != nil {
+			return nil, "", err
+		}
+		opts.Domain = domain
+	}
+	if opts.Owner != nil {
+		if err := s.checkQuota(ctx, opts.Owner, opts.CustomAlias != "" || opts.SlugFrom != ""); err != nil {
+			return nil, "", err
+		}
+	}
+	originalURL, err := AddUTM(originalURL, opts.UTM)
+	if err != nil {
+		return nil, "", err
+	}
+	originalURL, homograph, err := s.checkHomograph(originalURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if originalURL, err = s.normalization.Apply(originalURL); err != nil {
+		return nil, "", err
+	}
+	if err := s.screen(ctx, originalURL); err != nil {
+		return nil, "", err
+	}
+
+	link := &Link{
+		OriginalURL:       originalURL,
+		ExpiresAt:         opts.ExpiresAt,
+		MaxClicks:         opts.MaxClicks,
+		ActiveFrom:        opts.ActiveFrom,
+		ActiveUntil:       opts.ActiveUntil,
+		CampaignID:        opts.CampaignID,
+		Domain:            opts.Domain,
+		AnalyticsDisabled: opts.DisableAnalytics,
+		OrgID:             opts.OrgID,
+		QueryPassthrough:  opts.QueryPassthrough,
+		Mask:              opts.Mask,
+		BurnAfterReading:  opts.BurnAfterReading,
+	}
+	if opts.Owner != nil {
+		link.OwnerID = opts.Owner.ID
+	}
+	return link, homograph, nil
+}
+
+// linkCreated audits the creation of link under code by owner and queues
+// the follow-up work on it.
+func (s *Service) linkCreated(ctx context.Context, link *Link, code string, owner *User, homograph string) {
+	s.audit(ctx, UserActor(owner), AuditCreate, code, link.OriginalURL)
+	if homograph != "" && s.homographs == HomographFlag {
+		s.holdHomograph(ctx, code, homograph)
+	}
+	s.queuePage(ctx, link)
+}
+
+// shortenGenerated saves link under a code chosen by the code strategy and
+// written with alpha (or the service default), retrying with a fresh code if
+// the chosen one is already taken.
+func (s *Service) shortenGenerated(ctx context.Context, link *Link, alpha *Alphabet) (string, error) {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		if err := s.assignCode(ctx, link, alpha); err != nil {
+			return "", err
+		}
+		pinned := link.ID != 0
+
+		// 1. Save to DB (allocating a sequential ID unless one was pinned)
+		id, err := s.repo.Save(ctx, link)
+		if pinned && errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to save url: %w", err)
+		}
+
+		// 2. Encode ID to Base62, unless it has the prefix of its
+		// organization
+		if link.Code != "" {
+			return link.Code, nil
+		}
+		return Encode(id), nil
+	}
+	return "", fmt.Errorf("failed to save url: no free code after %d attempts", maxGenerateAttempts)
+}
+
+func (s *Service) shortenWithAlias(ctx context.Context, link *Link, alias string) (string, error) {
+	if err := pinAlias(link, alias); err != nil {
+		return "", err
+	}
+	if err := s.codePolicy.checkAlias(alias, link.OrgID); err != nil {
+		return "", err
+	}
+	_, err := s.repo.SaveAlias(ctx, link)
+	if errors.Is(err, ErrConflict) {
+		return "", ErrAliasTaken
+	}
+	if errors.Is(err, ErrAliasReserved) {
+		return "", err
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to save url: %w", err)
 	}
+	return alias, nil
+}
 
-	// 2. Encode ID to Base62
-	shortCode := Encode(id)
+// pinAlias validates alias and sets the ID or code link is saved under to it.
+func pinAlias(link *Link, alias string) error {
+	if err := ValidateAlias(alias); err != nil {
+		return err
+	}
+	// Canonical Base62 aliases are pinned to their decoded ID so that they
+	// share the primary key namespace with generated codes. Anything else
+	// goes into the unique code column.
+	if id, ok := idFromCode(alias); ok {
+		link.ID = id
+	} else {
+		link.Code = alias
+	}
+	link.CustomAlias = true
+	return nil
+}
 
-	return shortCode, nil
+// Resolve looks up the link behind shortCode without applying any access
+// rules. Base32 codes are found whatever case they are typed in.
+func (s *Service) Resolve(ctx context.Context, shortCode string) (*Link, error) {
+	link, err := s.resolve(ctx, shortCode)
+	if errors.Is(err, ErrNotFound) {
+		if lower := strings.ToLower(shortCode); lower != shortCode {
+			if _, decodeErr := Base32.Decode(lower); decodeErr == nil {
+				return s.resolve(ctx, lower)
+			}
+		}
+	}
+	return link, err
 }
 
+func (s *Service) resolve(ctx context.Context, shortCode string) (*Link, error) {
+	// ID-derived codes (generated or pinned aliases) resolve by primary key
+	if id, ok := idFromCode(shortCode); ok {
+		return s.repo.Get(ctx, id)
+	}
+
+	// Anything else must be a free-form custom alias
+	if ValidateAlias(shortCode) != nil {
+		return nil, ErrInvalidShortCode
+	}
+	return s.repo.GetByCode(ctx, shortCode)
+}
+
+// Preview returns the link behind shortCode for display without following
+// it: expiry and status are enforced but clicks are not counted. Links that
+// burn after reading are not displayed: ErrConsumed or ErrBurnAfterReading
+// is returned for them.
+func (s *Service) Preview(ctx context.Context, shortCode string) (*Link, error) {
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if link.IsDisabled() || link.IsReported() {
+		return nil, ErrDisabled
+	}
+	if link.IsFlagged() {
+		return nil, ErrUnsafeURL
+	}
+	if err := s.checkActive(link); err != nil {
+		return nil, err
+	}
+	if link.IsConsumed() {
+		return nil, ErrConsumed
+	}
+	if link.BurnAfterReading {
+		return nil, ErrBurnAfterReading
+	}
+	return link, nil
+}
+
+// Redirect returns the destination of shortCode for an unknown visitor.
 func (s *Service) Redirect(ctx context.Context, shortCode string) (string, error) {
-	// 1. Decode Base62 to ID
-	id, err := Decode(shortCode)
+	route, err := s.RedirectVisitor(ctx, shortCode, Visitor{})
+	return route.URL, err
+}
+
+// RedirectVisitor returns where v is sent by shortCode, counting the click
+// against the link's click limit. The click is recorded for analytics unless
+// the link opted out, in which case the route is marked Untracked.
+func (s *Service) RedirectVisitor(ctx context.Context, shortCode string, v Visitor) (Route, error) {
+	// 1. Get Link from Repo (Redis/DB)
+	link, err := s.Resolve(ctx, shortCode)
 	if err != nil {
-		return "", ErrInvalidShortCode
+		return Route{}, err // Pass through ErrNotFound or other errors
+	}
+
+	// 2. Enforce status and expiry
+	if link.IsDisabled() || link.IsReported() {
+		return Route{}, ErrDisabled
+	}
+	if link.IsFlagged() {
+		return Route{}, ErrUnsafeURL
+	}
+	if err := s.checkActive(link); err != nil {
+		return Route{}, err
+	}
+	if v.Host != "" && !link.ServedOn(v.Host) {
+		return Route{}, ErrNotFound
+	}
+	if err := link.Access.check(v); err != nil {
+		return Route{}, err
+	}
+	if link.IsConsumed() {
+		return Route{}, ErrConsumed
+	}
+
+	// 3. Pick the destination and refuse redirect loops before the click
+	// is counted, so that requests turned away take no click
+	route := s.pickRoute(link, v)
+	if err := s.checkChain(ctx, shortCode, link, route, v); err != nil {
+		return Route{}, err
 	}
 
-	// 2. Get Original URL from Repo (Redis/DB)
-	originalURL, err := s.repo.Get(ctx, id)
+	// 4. Enforce click limit. The counter is incremented atomically before
+	// redirecting, so exactly MaxClicks requests get through.
+	if link.HasClickLimit() {
+		clicks, err := s.repo.IncrementClicks(ctx, link)
+		if err != nil {
+			return Route{}, fmt.Errorf("failed to count click: %w", err)
+		}
+		if clicks > link.MaxClicks {
+			return Route{}, ErrClickLimitReached
+		}
+	}
+
+	// 5. Burn after reading: only the request that consumes the link is
+	// redirected, however many raced for it.
+	if link.BurnAfterReading {
+		consumed, err := s.repo.Consume(ctx, link, s.now())
+		if err != nil {
+			return Route{}, fmt.Errorf("failed to consume link: %w", err)
+		}
+		if !consumed {
+			return Route{}, ErrConsumed
+		}
+	}
+	s.recordVariant(ctx, link, route)
+	if link.AnalyticsDisabled {
+		route.Untracked = true
+	} else {
+		s.recordClick(ctx, link, v)
+	}
+	return route, nil
+}
+
+// Peek returns where v would be sent by shortCode, for HEAD requests from
+// link checkers: the link is checked like a redirect, but no click is counted
+// or recorded. A link that has used up its click limit reports
+// ErrClickLimitReached, as far as its stored click count tells. Links that
+// burn after reading are neither consumed nor revealed (see Preview).
+func (s *Service) Peek(ctx context.Context, shortCode string, v Visitor) (Route, error) {
+	link, err := s.Preview(ctx, shortCode)
 	if err != nil {
-		return "", err // Pass through ErrNotFound or other errors
+		return Route{}, err
 	}
+	if v.Host != "" && !link.ServedOn(v.Host) {
+		return Route{}, ErrNotFound
+	}
+	if err := link.Access.check(v); err != nil {
+		return Route{}, err
+	}
+	if link.HasClickLimit() && link.Clicks >= link.MaxClicks {
+		return Route{}, ErrClickLimitReached
+	}
+	route := s.pickRoute(link, v)
+	if err := s.checkChain(ctx, shortCode, link, route, v); err != nil {
+		return Route{}, err
+	}
+	return route, nil
+}
 
-	return originalURL, nil
+// checkActive returns ErrExpired, ErrNotYetActive or ErrNoLongerActive if
+// link may not be followed at this time.
+func (s *Service) checkActive(link *Link) error {
+	now := s.now()
+	switch {
+	case link.IsExpired(now):
+		return ErrExpired
+	case link.IsPending(now):
+		return ErrNotYetActive
+	case link.IsEnded(now):
+		return ErrNoLongerActive
+	}
+	return nil
+}
+
+// List returns a page of links, newest first, starting after cursor.
+// The returned next cursor is zero when there are no more pages.
+// A limit outside (0, MaxListLimit] falls back to DefaultListLimit or MaxListLimit.
+func (s *Service) List(ctx context.Context, cursor uint64, limit int) ([]*Link, uint64, error) {
+	links, next, err := paginate(limit, func(n int) ([]*Link, error) {
+		return s.repo.List(ctx, cursor, n)
+	}, linkID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list urls: %w", err)
+	}
+	if err := s.attachHealth(ctx, links); err != nil {
+		return nil, 0, err
+	}
+	return links, next, nil
+}
+
+// Search is like List but only returns links whose destination, metadata
+// title or metadata description contains query, case-insensitively.
+func (s *Service) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, uint64, error) {
+	links, next, err := paginate(limit, func(n int) ([]*Link, error) {
+		return s.repo.Search(ctx, query, cursor, n)
+	}, linkID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search urls: %w", err)
+	}
+	if err := s.attachHealth(ctx, links); err != nil {
+		return nil, 0, err
+	}
+	return links, next, nil
+}
+
+// paginate normalizes limit and calls fetch with one extra row to learn
+// whether another page exists. id returns the keyset cursor of an item.
+func paginate[T any](limit int, fetch func(n int) ([]T, error), id func(T) uint64) ([]T, uint64, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	items, err := fetch(limit + 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint64
+	if len(items) > limit {
+		items = items[:limit]
+		next = id(items[limit-1])
+	}
+	return items, next, nil
+}
+
+func linkID(l *Link) uint64 { return l.ID }
+
+// SetStatus enables or disables the link behind shortCode. Cached copies are
+// dropped so the change takes effect immediately. Setting a status is an
+// administrator decision, so open abuse reports for the link are resolved.
+func (s *Service) SetStatus(ctx context.Context, shortCode string, status Status) (*Link, error) {
+	if status != StatusActive && status != StatusDisabled {
+		return nil, ErrInvalidStatus
+	}
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetStatus(ctx, link, status); err != nil {
+		return nil, fmt.Errorf("failed to set status: %w", err)
+	}
+	if err := s.repo.ResolveReports(ctx, link, s.now()); err != nil {
+		return nil, fmt.Errorf("failed to resolve reports: %w", err)
+	}
+	action := AuditEnable
+	if status == StatusDisabled {
+		action = AuditDisable
+	}
+	s.audit(ctx, ActorAdmin, action, link.ShortCode(), "")
+	return link, nil
+}
+
+// Invalidate drops any cached copy of the link behind shortCode.
+func (s *Service) Invalidate(ctx context.Context, shortCode string) error {
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Invalidate(ctx, link); err != nil {
+		return fmt.Errorf("failed to invalidate cache: %w", err)
+	}
+	return nil
+}
+
+// AdminDelete soft-deletes the link behind shortCode whoever owns it, for
+// operators removing links outside the owner's account. Owners can restore
+// it like any link they deleted themselves.
+func (s *Service) AdminDelete(ctx context.Context, shortCode string) error {
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, link); err != nil {
+		return fmt.Errorf("failed to delete url: %w", err)
+	}
+	s.audit(ctx, ActorAdmin, AuditDelete, link.ShortCode(), "")
+	return nil
+}
+
+// Stats returns aggregate link counts as of now.
+func (s *Service) Stats(ctx context.Context) (*Stats, error) {
+	st, err := s.repo.Stats(ctx, s.now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	if s.prober != nil {
+		if st.Health, err = s.repo.HealthStats(ctx); err != nil {
+			return nil, fmt.Errorf("failed to compute health stats: %w", err)
+		}
+	}
+	return st, nil
+}
+
+// FlushClicks persists buffered click counts.
+func (s *Service) FlushClicks(ctx context.Context) (int, error) {
+	n, err := s.repo.FlushClicks(ctx)
+	if err != nil {
+		return n, fmt.Errorf("failed to flush clicks: %w", err)
+	}
+	lastFlush.Store(time.Now().Unix())
+	return n, nil
+}
+
+// PurgeExpired removes all links that have expired as of now.
+func (s *Service) PurgeExpired(ctx context.Context) (int, error) {
+	n, err := s.repo.PurgeExpired(ctx, s.now())
+	if err != nil {
+		return n, fmt.Errorf("failed to purge expired urls: %w", err)
+	}
+	return n, nil
 }
@@ -3,7 +3,10 @@ package shortener
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestService_Shorten(t *testing.T) {
@@ -48,21 +51,28 @@ func TestService_Shorten(t *testing.T) {
 			wantErr:     false,
 		},
 		{
-			name:        "very long URL",
-			originalURL: "https://example.com/" + string(make([]byte, 10000)),
+			name:        "URL of maximum length",
+			originalURL: "https://example.com/" + strings.Repeat("a", DefaultMaxURLLength-20),
 			savedID:     999,
 			saveError:   nil,
 			wantCode:    "g7",
 			wantErr:     false,
 		},
+		{
+			name:        "very long URL",
+			originalURL: "https://example.com/" + string(make([]byte, 10000)),
+			savedID:     999,
+			saveError:   nil,
+			wantErr:     true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := &MockRepository{
-				SaveFunc: func(ctx context.Context, url string) (uint64, error) {
-					if url != tt.originalURL {
-						t.Errorf("Save() called with wrong URL: got %s, want %s", url, tt.originalURL)
+				SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+					if link.OriginalURL != tt.originalURL {
+						t.Errorf("Save() called with wrong URL: got %s, want %s", link.OriginalURL, tt.originalURL)
 					}
 					return tt.savedID, tt.saveError
 				},
@@ -85,6 +95,34 @@ func TestService_Shorten(t *testing.T) {
 	}
 }
 
+func TestService_MaxURLLength(t *testing.T) {
+	service := NewService(NewInMemoryRepository(), WithMaxURLLength(30))
+	ctx := context.Background()
+
+	if _, err := service.Shorten(ctx, "https://example.com/a"); err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	long := "https://example.com/" + strings.Repeat("a", 11)
+	if _, err := service.Shorten(ctx, long); !errors.Is(err, ErrURLTooLong) {
+		t.Errorf("Shorten() error = %v, want ErrURLTooLong", err)
+	}
+
+	// UTM tags count towards the limit
+	opts := ShortenOptions{UTM: UTM{Source: "newsletter"}}
+	if _, err := service.ShortenWithOptions(ctx, "https://example.com/a", opts); !errors.Is(err, ErrURLTooLong) {
+		t.Errorf("ShortenWithOptions() with UTM error = %v, want ErrURLTooLong", err)
+	}
+
+	user := &User{ID: 1}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/b", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	if _, err := service.UpdateDestination(ctx, user, code, long); !errors.Is(err, ErrURLTooLong) {
+		t.Errorf("UpdateDestination() error = %v, want ErrURLTooLong", err)
+	}
+}
+
 func TestService_Redirect(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -148,8 +186,11 @@ func TestService_Redirect(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := &MockRepository{
-				GetFunc: func(ctx context.Context, id uint64) (string, error) {
-					return tt.storedURL, tt.getError
+				GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+					if tt.getError != nil {
+						return nil, tt.getError
+					}
+					return &Link{ID: id, OriginalURL: tt.storedURL}, nil
 				},
 			}
 
@@ -191,15 +232,15 @@ func TestService_RoundTrip(t *testing.T) {
 	var savedID uint64
 
 	mockRepo := &MockRepository{
-		SaveFunc: func(ctx context.Context, url string) (uint64, error) {
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
 			savedID = 42
 			return savedID, nil
 		},
-		GetFunc: func(ctx context.Context, id uint64) (string, error) {
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
 			if id == savedID {
-				return originalURL, nil
+				return &Link{ID: id, OriginalURL: originalURL}, nil
 			}
-			return "", ErrNotFound
+			return nil, ErrNotFound
 		},
 	}
 
@@ -222,3 +263,636 @@ func TestService_RoundTrip(t *testing.T) {
 		t.Errorf("Round trip failed: got %s, want %s", retrievedURL, originalURL)
 	}
 }
+
+func TestService_ShortenWithAlias(t *testing.T) {
+	tests := []struct {
+		name       string
+		alias      string
+		saveErr    error
+		wantCode   string
+		wantID     uint64 // Expected pinned ID (0 = stored by code)
+		wantStored bool
+		wantErr    error
+	}{
+		{
+			name:       "base62 alias is pinned to its ID",
+			alias:      "promo",
+			wantCode:   "promo",
+			wantID:     mustDecode(t, "promo"),
+			wantStored: true,
+		},
+		{
+			name:       "hyphenated alias stored by code",
+			alias:      "spring-sale",
+			wantCode:   "spring-sale",
+			wantStored: true,
+		},
+		{
+			name:       "pinned ID already taken",
+			alias:      "promo",
+			saveErr:    ErrConflict,
+			wantID:     mustDecode(t, "promo"),
+			wantStored: true,
+			wantErr:    ErrAliasTaken,
+		},
+		{
+			name:       "code already taken",
+			alias:      "spring-sale",
+			saveErr:    ErrConflict,
+			wantStored: true,
+			wantErr:    ErrAliasTaken,
+		},
+		{
+			name:    "invalid characters",
+			alias:   "bad alias!",
+			wantErr: ErrInvalidAlias,
+		},
+		{
+			name:    "reserved alias",
+			alias:   "docs",
+			wantErr: ErrAliasReserved,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := false
+			mockRepo := &MockRepository{
+				SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+					stored = true
+					if link.ID != tt.wantID {
+						t.Errorf("Save() link.ID = %d, want %d", link.ID, tt.wantID)
+					}
+					wantCode := ""
+					if tt.wantID == 0 {
+						wantCode = tt.alias
+					}
+					if link.Code != wantCode {
+						t.Errorf("Save() link.Code = %q, want %q", link.Code, wantCode)
+					}
+					return link.ID, tt.saveErr
+				},
+			}
+
+			service := NewService(mockRepo)
+			gotCode, err := service.ShortenWithOptions(context.Background(), "https://example.com",
+				ShortenOptions{CustomAlias: tt.alias})
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ShortenWithOptions() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+
+			if gotCode != tt.wantCode {
+				t.Errorf("ShortenWithOptions() = %s, want %s", gotCode, tt.wantCode)
+			}
+			// Invalid aliases must be rejected before reaching the repository
+			if stored != tt.wantStored {
+				t.Errorf("Save() called = %v, want %v", stored, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestService_RedirectByCode(t *testing.T) {
+	mockRepo := &MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			t.Errorf("Get() must not be called for free-form codes, got id %d", id)
+			return nil, ErrNotFound
+		},
+		GetByCodeFunc: func(ctx context.Context, code string) (*Link, error) {
+			if code == "spring-sale" {
+				return &Link{ID: 9, Code: code, OriginalURL: "https://example.com/sale"}, nil
+			}
+			return nil, ErrNotFound
+		},
+	}
+
+	service := NewService(mockRepo)
+	ctx := context.Background()
+
+	gotURL, err := service.Redirect(ctx, "spring-sale")
+	if err != nil {
+		t.Fatalf("Redirect() unexpected error = %v", err)
+	}
+	if gotURL != "https://example.com/sale" {
+		t.Errorf("Redirect() = %s, want https://example.com/sale", gotURL)
+	}
+
+	if _, err := service.Redirect(ctx, "other-code"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Redirect() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestService_ShortenWithExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		wantErr   error
+	}{
+		{name: "no expiry", expiresAt: nil},
+		{name: "future expiry", expiresAt: &future},
+		{name: "past expiry rejected", expiresAt: &past, wantErr: ErrInvalidExpiry},
+		{name: "expiry equal to now rejected", expiresAt: &now, wantErr: ErrInvalidExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockRepository{
+				SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+					if link.ExpiresAt != tt.expiresAt {
+						t.Errorf("Save() link.ExpiresAt = %v, want %v", link.ExpiresAt, tt.expiresAt)
+					}
+					return 1, nil
+				},
+			}
+
+			service := NewService(mockRepo)
+			service.now = func() time.Time { return now }
+
+			_, err := service.ShortenWithOptions(context.Background(), "https://example.com",
+				ShortenOptions{ExpiresAt: tt.expiresAt})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ShortenWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_RedirectExpired(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		wantErr   error
+	}{
+		{name: "not yet expired", expiresAt: now.Add(time.Second), wantErr: nil},
+		{name: "expired exactly now", expiresAt: now, wantErr: ErrExpired},
+		{name: "expired in the past", expiresAt: now.Add(-time.Hour), wantErr: ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+					expiresAt := tt.expiresAt
+					return &Link{ID: id, OriginalURL: "https://example.com", ExpiresAt: &expiresAt}, nil
+				},
+			}
+
+			service := NewService(mockRepo)
+			service.now = func() time.Time { return now }
+
+			_, err := service.Redirect(context.Background(), "b")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Redirect() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_RedirectActiveWindow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		activeFrom  *time.Time
+		activeUntil *time.Time
+		wantErr     error
+	}{
+		{name: "no window", wantErr: nil},
+		{name: "inside window", activeFrom: &before, activeUntil: &after, wantErr: nil},
+		{name: "starts exactly now", activeFrom: &now, wantErr: nil},
+		{name: "not yet active", activeFrom: &after, wantErr: ErrNotYetActive},
+		{name: "ends exactly now", activeUntil: &now, wantErr: ErrNoLongerActive},
+		{name: "no longer active", activeFrom: &before, activeUntil: &before, wantErr: ErrNoLongerActive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+					return &Link{ID: id, OriginalURL: "https://example.com", ActiveFrom: tt.activeFrom, ActiveUntil: tt.activeUntil}, nil
+				},
+			}
+
+			service := NewService(mockRepo)
+			service.now = func() time.Time { return now }
+
+			if _, err := service.Redirect(context.Background(), "b"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Redirect() error = %v, want %v", err, tt.wantErr)
+			}
+			// The destination of an embargoed link must not leak through previews
+			if _, err := service.Preview(context.Background(), "b"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Preview() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_ShortenWithActiveWindow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	from, until := now.Add(time.Hour), now.Add(2*time.Hour)
+
+	tests := []struct {
+		name    string
+		from    *time.Time
+		until   *time.Time
+		wantErr error
+	}{
+		{name: "future window", from: &from, until: &until},
+		{name: "open-ended", from: &from},
+		{name: "until in the past", until: &now, wantErr: ErrInvalidActiveWindow},
+		{name: "until before from", from: &until, until: &from, wantErr: ErrInvalidActiveWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *Link
+			mockRepo := &MockRepository{
+				SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+					saved = link
+					return 1, nil
+				},
+			}
+			service := NewService(mockRepo)
+			service.now = func() time.Time { return now }
+
+			_, err := service.ShortenWithOptions(context.Background(), "https://example.com", ShortenOptions{ActiveFrom: tt.from, ActiveUntil: tt.until})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ShortenWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (saved.ActiveFrom != tt.from || saved.ActiveUntil != tt.until) {
+				t.Errorf("saved window = %v..%v, want %v..%v", saved.ActiveFrom, saved.ActiveUntil, tt.from, tt.until)
+			}
+		})
+	}
+}
+
+func TestService_List(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		stored    int
+		wantLimit int // limit passed to the repository
+		wantLen   int
+		wantNext  uint64
+	}{
+		{name: "default limit", limit: 0, stored: 5, wantLimit: DefaultListLimit + 1, wantLen: 5, wantNext: 0},
+		{name: "limit capped", limit: 1000, stored: 5, wantLimit: MaxListLimit + 1, wantLen: 5, wantNext: 0},
+		{name: "more pages", limit: 2, stored: 5, wantLimit: 3, wantLen: 2, wantNext: 4},
+		{name: "exact page", limit: 5, stored: 5, wantLimit: 6, wantLen: 5, wantNext: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLimit int
+			mockRepo := &MockRepository{
+				ListFunc: func(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+					gotLimit = limit
+					// Links with IDs stored..1, newest first
+					var links []*Link
+					for id := uint64(tt.stored); id > 0 && len(links) < limit; id-- {
+						links = append(links, &Link{ID: id})
+					}
+					return links, nil
+				},
+			}
+
+			service := NewService(mockRepo)
+			links, next, err := service.List(context.Background(), 0, tt.limit)
+			if err != nil {
+				t.Fatalf("List() unexpected error = %v", err)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Errorf("repository limit = %d, want %d", gotLimit, tt.wantLimit)
+			}
+			if len(links) != tt.wantLen {
+				t.Errorf("List() returned %d links, want %d", len(links), tt.wantLen)
+			}
+			if next != tt.wantNext {
+				t.Errorf("List() next cursor = %d, want %d", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestService_RedirectClickLimit(t *testing.T) {
+	var clicks int64
+	mockRepo := &MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			return &Link{ID: id, OriginalURL: "https://example.com", MaxClicks: 2}, nil
+		},
+		IncrementClicksFunc: func(ctx context.Context, link *Link) (int64, error) {
+			clicks++
+			return clicks, nil
+		},
+	}
+	service := NewService(mockRepo)
+
+	for i := 1; i <= 2; i++ {
+		if _, err := service.Redirect(context.Background(), "b"); err != nil {
+			t.Fatalf("Redirect() #%d unexpected error = %v", i, err)
+		}
+	}
+	if _, err := service.Redirect(context.Background(), "b"); !errors.Is(err, ErrClickLimitReached) {
+		t.Errorf("Redirect() over limit error = %v, want ErrClickLimitReached", err)
+	}
+
+	// Links without a limit are not counted
+	mockRepo.GetFunc = func(ctx context.Context, id uint64) (*Link, error) {
+		return &Link{ID: id, OriginalURL: "https://example.com"}, nil
+	}
+	mockRepo.IncrementClicksFunc = func(ctx context.Context, link *Link) (int64, error) {
+		t.Error("IncrementClicks called for link without click limit")
+		return 0, nil
+	}
+	if _, err := service.Redirect(context.Background(), "b"); err != nil {
+		t.Errorf("Redirect() unexpected error = %v", err)
+	}
+}
+
+func TestService_Peek(t *testing.T) {
+	link := &Link{ID: 11, OriginalURL: "https://example.com", MaxClicks: 2, Clicks: 1}
+	mockRepo := &MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			return link, nil
+		},
+		IncrementClicksFunc: func(ctx context.Context, link *Link) (int64, error) {
+			t.Error("IncrementClicks called by Peek")
+			return 0, nil
+		},
+		RecordClickFunc: func(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+			t.Error("RecordClick called by Peek")
+			return nil
+		},
+		RecordVariantFunc: func(ctx context.Context, link *Link, name string) error {
+			t.Error("RecordVariant called by Peek")
+			return nil
+		},
+	}
+	service := NewService(mockRepo)
+
+	route, err := service.Peek(context.Background(), "b", Visitor{})
+	if err != nil {
+		t.Fatalf("Peek() unexpected error = %v", err)
+	}
+	if route.URL != "https://example.com" {
+		t.Errorf("Peek() URL = %q, want https://example.com", route.URL)
+	}
+
+	link.Experiment = &Experiment{Variants: []Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}}}
+	route, err = service.Peek(context.Background(), "b", Visitor{})
+	if err != nil {
+		t.Fatalf("Peek() unexpected error = %v", err)
+	}
+	if route.URL != "https://example.com/a" {
+		t.Errorf("Peek() URL = %q, want https://example.com/a", route.URL)
+	}
+
+	link.Clicks = 2
+	if _, err := service.Peek(context.Background(), "b", Visitor{}); !errors.Is(err, ErrClickLimitReached) {
+		t.Errorf("Peek() over limit error = %v, want ErrClickLimitReached", err)
+	}
+
+	link.Clicks, link.Status = 0, StatusDisabled
+	if _, err := service.Peek(context.Background(), "b", Visitor{}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Peek() disabled error = %v, want ErrDisabled", err)
+	}
+}
+
+func TestService_ShortenWithMaxClicks(t *testing.T) {
+	var saved *Link
+	mockRepo := &MockRepository{
+		SaveFunc: func(ctx context.Context, link *Link) (uint64, error) {
+			saved = link
+			return 1, nil
+		},
+	}
+	service := NewService(mockRepo)
+
+	if _, err := service.ShortenWithOptions(context.Background(), "https://example.com", ShortenOptions{MaxClicks: -1}); !errors.Is(err, ErrInvalidMaxClicks) {
+		t.Errorf("ShortenWithOptions() error = %v, want ErrInvalidMaxClicks", err)
+	}
+	if _, err := service.ShortenWithOptions(context.Background(), "https://example.com", ShortenOptions{MaxClicks: 3}); err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	if saved.MaxClicks != 3 {
+		t.Errorf("saved MaxClicks = %d, want 3", saved.MaxClicks)
+	}
+}
+
+func TestService_Disabled(t *testing.T) {
+	var setTo Status
+	mockRepo := &MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			return &Link{ID: id, OriginalURL: "https://example.com", Status: StatusDisabled}, nil
+		},
+		SetStatusFunc: func(ctx context.Context, link *Link, status Status) error {
+			setTo = status
+			return nil
+		},
+	}
+	service := NewService(mockRepo)
+	ctx := context.Background()
+
+	if _, err := service.Redirect(ctx, "b"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Redirect() error = %v, want ErrDisabled", err)
+	}
+	if _, err := service.Preview(ctx, "b"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Preview() error = %v, want ErrDisabled", err)
+	}
+
+	if _, err := service.SetStatus(ctx, "b", StatusActive); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+	if setTo != StatusActive {
+		t.Errorf("repository status = %q, want active", setTo)
+	}
+	if _, err := service.SetStatus(ctx, "b", "banned"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("SetStatus() unknown status error = %v, want ErrInvalidStatus", err)
+	}
+	if _, err := service.SetStatus(ctx, "!!", StatusActive); !errors.Is(err, ErrInvalidShortCode) {
+		t.Errorf("SetStatus() invalid code error = %v, want ErrInvalidShortCode", err)
+	}
+}
+
+type stubChecker struct {
+	threats map[string]string
+	err     error
+	calls   [][]string
+}
+
+func (c *stubChecker) Check(ctx context.Context, urls []string) (map[string]string, error) {
+	c.calls = append(c.calls, urls)
+	return c.threats, c.err
+}
+
+func TestService_ShortenScreensURL(t *testing.T) {
+	checker := &stubChecker{threats: map[string]string{"https://malware.test/": "MALWARE"}}
+	service := NewService(&MockRepository{}, WithURLChecker(checker))
+	ctx := context.Background()
+
+	if _, err := service.Shorten(ctx, "https://malware.test/"); !errors.Is(err, ErrUnsafeURL) {
+		t.Errorf("Shorten() unsafe url error = %v, want ErrUnsafeURL", err)
+	}
+	if _, err := service.Shorten(ctx, "https://example.com/"); err != nil {
+		t.Errorf("Shorten() safe url unexpected error = %v", err)
+	}
+
+	// Checker outages do not block link creation
+	checker.err = errors.New("quota exceeded")
+	if _, err := service.Shorten(ctx, "https://malware.test/"); err != nil {
+		t.Errorf("Shorten() with failing checker unexpected error = %v", err)
+	}
+}
+
+func TestService_Rescan(t *testing.T) {
+	stored := make([]*Link, 0, rescanBatchSize+2)
+	for id := uint64(rescanBatchSize + 2); id >= 1; id-- {
+		stored = append(stored, &Link{ID: id, OriginalURL: fmt.Sprintf("https://example.com/%d", id)})
+	}
+	stored[0].OriginalURL = "https://malware.test/"
+	stored[1].Status = StatusFlagged
+
+	flagged := map[uint64]bool{}
+	mockRepo := &MockRepository{
+		ListFunc: func(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+			var links []*Link
+			for _, link := range stored {
+				if (cursor == 0 || link.ID < cursor) && len(links) < limit {
+					links = append(links, link)
+				}
+			}
+			return links, nil
+		},
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			for _, link := range stored {
+				if link.ID == id {
+					return link, nil
+				}
+			}
+			return nil, ErrNotFound
+		},
+		SetStatusFunc: func(ctx context.Context, link *Link, status Status) error {
+			if status != StatusFlagged {
+				t.Errorf("SetStatus() status = %q, want flagged", status)
+			}
+			flagged[link.ID] = true
+			return nil
+		},
+	}
+	checker := &stubChecker{threats: map[string]string{"https://malware.test/": "MALWARE"}}
+	service := NewService(mockRepo, WithURLChecker(checker))
+	ctx := context.Background()
+
+	// Already flagged links are not queued again, the others are checked
+	// by the job
+	n, err := service.Rescan(ctx)
+	if err != nil {
+		t.Fatalf("Rescan() unexpected error = %v", err)
+	}
+	if n != rescanBatchSize-1 || len(checker.calls) != 0 {
+		t.Errorf("Rescan() queued %d links and checked %d batches, want %d queued", n, len(checker.calls), rescanBatchSize-1)
+	}
+	drainJobs(t, service)
+	if !flagged[rescanBatchSize+2] || len(flagged) != 1 {
+		t.Errorf("flagged %v, want the malware link", flagged)
+	}
+	if got := len(checker.calls[0]); got != rescanBatchSize-1 {
+		t.Errorf("checked %d urls, want %d", got, rescanBatchSize-1)
+	}
+
+	// The second call picks up the remaining links, the third starts over
+	if _, err := service.Rescan(ctx); err != nil {
+		t.Fatalf("Rescan() unexpected error = %v", err)
+	}
+	drainJobs(t, service)
+	if got := checker.calls[1]; len(got) != 2 || got[0] != "https://example.com/2" {
+		t.Errorf("second batch = %v, want the two oldest links", got)
+	}
+	if _, err := service.Rescan(ctx); err != nil {
+		t.Fatalf("Rescan() unexpected error = %v", err)
+	}
+	drainJobs(t, service)
+	if got := checker.calls[2]; got[0] != "https://malware.test/" {
+		t.Errorf("third batch starts at %q, want the newest link", got[0])
+	}
+
+	// A checker outage is retried with the job
+	checker.err = errors.New("quota exceeded")
+	if _, err := service.Rescan(ctx); err != nil {
+		t.Fatalf("Rescan() unexpected error = %v", err)
+	}
+	drainJobs(t, service)
+	status, err := service.Jobs().Status(ctx)
+	if err != nil || len(status) != 1 || status[0].Kind != JobCheckURLs || status[0].Queued != 1 || status[0].Retried != 1 {
+		t.Errorf("Jobs().Status() = %+v, %v, want the check retried", status, err)
+	}
+}
+
+func TestService_Report(t *testing.T) {
+	var open int64
+	var status Status
+	var resolved bool
+	mockRepo := &MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*Link, error) {
+			return &Link{ID: id, OriginalURL: "https://example.com", Status: status}, nil
+		},
+		AddReportFunc: func(ctx context.Context, report *Report) (int64, error) {
+			if report.Reporter != "192.0.2.1" || report.Reason != "phishing" {
+				t.Errorf("AddReport() report = %+v", report)
+			}
+			open++
+			return open, nil
+		},
+		SetStatusFunc: func(ctx context.Context, link *Link, s Status) error {
+			status = s
+			return nil
+		},
+		ResolveReportsFunc: func(ctx context.Context, link *Link, now time.Time) error {
+			resolved = true
+			open = 0
+			return nil
+		},
+	}
+	service := NewService(mockRepo, WithReportThreshold(2))
+	ctx := context.Background()
+
+	if err := service.Report(ctx, "b", "phishing", "192.0.2.1"); err != nil {
+		t.Fatalf("Report() unexpected error = %v", err)
+	}
+	if status != "" {
+		t.Errorf("status after one report = %q, want unchanged", status)
+	}
+	if err := service.Report(ctx, "b", "phishing", "192.0.2.1"); err != nil {
+		t.Fatalf("Report() unexpected error = %v", err)
+	}
+	if status != StatusReported {
+		t.Errorf("status after threshold = %q, want reported", status)
+	}
+	if _, err := service.Redirect(ctx, "b"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Redirect() of reported link error = %v, want ErrDisabled", err)
+	}
+
+	// Re-enabling the link is the admin review and resolves the reports
+	if _, err := service.SetStatus(ctx, "b", StatusActive); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+	if !resolved || open != 0 {
+		t.Error("SetStatus() did not resolve open reports")
+	}
+
+	if err := service.Report(ctx, "b", strings.Repeat("x", MaxReportReasonLength+1), "192.0.2.1"); !errors.Is(err, ErrReasonTooLong) {
+		t.Errorf("Report() long reason error = %v, want ErrReasonTooLong", err)
+	}
+}
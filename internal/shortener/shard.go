@@ -0,0 +1,742 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ShardMap assigns link IDs to shards, either by ID range or by hash.
+//
+// Ranges keep adding capacity cheap: a new shard takes every ID above the
+// last bound, so existing links never move, but all new links are written to
+// the newest shard. Hashing spreads writes evenly over all shards, but
+// changing the number of shards moves most links (see Reshard).
+type ShardMap struct {
+	// bounds are the exclusive upper ID bounds of every shard but the last,
+	// in range mode.
+	bounds []uint64
+	// hashed is the number of shards in hash mode, or zero.
+	hashed int
+}
+
+// RangeShards returns a ShardMap of len(bounds)+1 shards: shard i holds the
+// IDs below bounds[i] (and not below bounds[i-1]), the last one all others.
+// Bounds must be increasing.
+func RangeShards(bounds ...uint64) (ShardMap, error) {
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return ShardMap{}, fmt.Errorf("shard bounds must be increasing, got %d after %d", bounds[i], bounds[i-1])
+		}
+	}
+	return ShardMap{bounds: bounds}, nil
+}
+
+// HashShards returns a ShardMap spreading IDs over n shards by hash.
+func HashShards(n int) (ShardMap, error) {
+	if n < 1 {
+		return ShardMap{}, fmt.Errorf("need at least one shard, got %d", n)
+	}
+	return ShardMap{hashed: n}, nil
+}
+
+// Len returns the number of shards.
+func (m ShardMap) Len() int {
+	if m.hashed > 0 {
+		return m.hashed
+	}
+	return len(m.bounds) + 1
+}
+
+// Shard returns the index of the shard holding id.
+func (m ShardMap) Shard(id uint64) int {
+	if m.hashed > 0 {
+		return int(mix64(id) % uint64(m.hashed))
+	}
+	i, _ := slices.BinarySearch(m.bounds, id+1)
+	return i
+}
+
+// mix64 is the SplitMix64 finalizer, which scatters sequential IDs evenly.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ShardedRepository spreads links over several repositories, typically
+// PostgreSQL databases, for installs beyond the write capacity of one.
+//
+// Links are routed by ID through a ShardMap, with one exception: links with
+// a free-form code (custom aliases that are not ID-derived) stay on the
+// first shard, the primary, whose unique index keeps codes unique and which
+// GetByCode can query alone. The primary also holds everything that is not a
+// link (users, API keys, campaigns, domains, the audit log) and allocates
+// all IDs from its sequence, so they are unique across shards.
+//
+// Listings query every shard and merge the pages, which keyset pagination
+// by ID makes exact. Report IDs are local to each shard, so they are
+// interleaved as local*shards+shard to keep them unique and ordered.
+type ShardedRepository struct {
+	shards []Repository
+	m      ShardMap
+}
+
+var _ Repository = (*ShardedRepository)(nil)
+
+// NewShardedRepository routes links over shards, the first of which is the
+// primary, with m, which must have as many shards.
+//
+// Every shard needs the full schema, but shards other than the primary hold
+// no users or campaigns, so their urls table must not have foreign keys to
+// them (see shard.sql).
+func NewShardedRepository(m ShardMap, shards ...Repository) (*ShardedRepository, error) {
+	if len(shards) != m.Len() {
+		return nil, fmt.Errorf("shard map has %d shards, got %d repositories", m.Len(), len(shards))
+	}
+	return &ShardedRepository{shards: shards, m: m}, nil
+}
+
+// Shards returns the shards, primary first.
+func (r *ShardedRepository) Shards() []Repository {
+	return r.shards
+}
+
+func (r *ShardedRepository) primary() Repository {
+	return r.shards[0]
+}
+
+// shardOf returns the shard holding link.
+func (r *ShardedRepository) shardOf(link *Link) Repository {
+	if link.Code != "" {
+		return r.primary()
+	}
+	return r.shards[r.m.Shard(link.ID)]
+}
+
+// Save allocates an ID from the primary unless link has one, then stores
+// link on its shard. Links with free-form codes take their IDs from the same
+// sequence as the others but live on the primary, so IDs are checked against
+// the shard the ID alone would route to, which may hold a random ID pinned
+// earlier.
+func (r *ShardedRepository) Save(ctx context.Context, link *Link) (uint64, error) {
+	if link.ID != 0 {
+		if r.shardOf(link) != r.primary() && r.exists(ctx, r.primary(), link.ID) {
+			return 0, ErrConflict
+		}
+		return r.shardOf(link).Save(ctx, link)
+	}
+
+	var err error
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		if link.ID, err = r.primary().NextID(ctx); err != nil {
+			break
+		}
+		shard := r.shardOf(link)
+		if byID := r.shards[r.m.Shard(link.ID)]; byID != shard && r.exists(ctx, byID, link.ID) {
+			err = ErrConflict
+			continue
+		}
+		var id uint64
+		if id, err = shard.Save(ctx, link); err == nil {
+			return id, nil
+		}
+		// Only a taken ID is worth another one: a free-form code stays taken
+		if !errors.Is(err, ErrConflict) || link.Code != "" {
+			break
+		}
+	}
+	link.ID = 0
+	return 0, err
+}
+
+// exists reports whether shard has a link with id.
+func (r *ShardedRepository) exists(ctx context.Context, shard Repository, id uint64) bool {
+	_, err := shard.Get(ctx, id)
+	return err == nil
+}
+
+func (r *ShardedRepository) NextID(ctx context.Context) (uint64, error) {
+	return r.primary().NextID(ctx)
+}
+
+func (r *ShardedRepository) ReserveIDs(ctx context.Context, n uint64) (uint64, error) {
+	return r.primary().ReserveIDs(ctx, n)
+}
+
+// Get looks up id on its shard, and then on the primary in case it belongs
+// to a link with a free-form code.
+func (r *ShardedRepository) Get(ctx context.Context, id uint64) (*Link, error) {
+	shard := r.shards[r.m.Shard(id)]
+	link, err := shard.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) && shard != r.primary() {
+		return r.primary().Get(ctx, id)
+	}
+	return link, err
+}
+
+func (r *ShardedRepository) GetByCode(ctx context.Context, code string) (*Link, error) {
+	return r.primary().GetByCode(ctx, code)
+}
+
+func (r *ShardedRepository) List(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+	return r.mergeLinks(limit, func(shard Repository) ([]*Link, error) {
+		return shard.List(ctx, cursor, limit)
+	})
+}
+
+func (r *ShardedRepository) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error) {
+	return r.mergeLinks(limit, func(shard Repository) ([]*Link, error) {
+		return shard.Search(ctx, query, cursor, limit)
+	})
+}
+
+func (r *ShardedRepository) ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.mergeLinks(limit, func(shard Repository) ([]*Link, error) {
+		return shard.ListByOwner(ctx, ownerID, cursor, limit)
+	})
+}
+
+func (r *ShardedRepository) ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.mergeLinks(limit, func(shard Repository) ([]*Link, error) {
+		return shard.ListByCampaign(ctx, campaignID, cursor, limit)
+	})
+}
+
+// mergeLinks collects a page from every shard with list and keeps the limit
+// links with the highest IDs, highest first.
+func (r *ShardedRepository) mergeLinks(limit int, list func(Repository) ([]*Link, error)) ([]*Link, error) {
+	var links []*Link
+	for _, shard := range r.shards {
+		page, err := list(shard)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, page...)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID > links[j].ID })
+	if len(links) > limit {
+		links = links[:limit]
+	}
+	return links, nil
+}
+
+func (r *ShardedRepository) Update(ctx context.Context, link *Link) error {
+	return r.shardOf(link).Update(ctx, link)
+}
+
+func (r *ShardedRepository) SetTargets(ctx context.Context, link *Link, targets []Target) error {
+	return r.shardOf(link).SetTargets(ctx, link, targets)
+}
+
+func (r *ShardedRepository) SetRules(ctx context.Context, link *Link, rules []Rule) error {
+	return r.shardOf(link).SetRules(ctx, link, rules)
+}
+
+func (r *ShardedRepository) SetExperiment(ctx context.Context, link *Link, e *Experiment) error {
+	return r.shardOf(link).SetExperiment(ctx, link, e)
+}
+
+func (r *ShardedRepository) SetPage(ctx context.Context, link *Link, page *PageInfo) error {
+	return r.shardOf(link).SetPage(ctx, link, page)
+}
+
+func (r *ShardedRepository) SetMetadata(ctx context.Context, link *Link, m *Metadata) error {
+	return r.shardOf(link).SetMetadata(ctx, link, m)
+}
+
+func (r *ShardedRepository) SetAccess(ctx context.Context, link *Link, a *Access) error {
+	return r.shardOf(link).SetAccess(ctx, link, a)
+}
+
+func (r *ShardedRepository) RecordVariant(ctx context.Context, link *Link, name string) error {
+	return r.shardOf(link).RecordVariant(ctx, link, name)
+}
+
+func (r *ShardedRepository) VariantStats(ctx context.Context, link *Link) (map[string]int64, error) {
+	return r.shardOf(link).VariantStats(ctx, link)
+}
+
+func (r *ShardedRepository) SetHealth(ctx context.Context, link *Link, h *Health) error {
+	return r.shardOf(link).SetHealth(ctx, link, h)
+}
+
+// ListHealth asks each shard for the health of its links.
+func (r *ShardedRepository) ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error) {
+	byShard := make(map[Repository][]*Link)
+	for _, link := range links {
+		shard := r.shardOf(link)
+		byShard[shard] = append(byShard[shard], link)
+	}
+	health := make(map[uint64]*Health, len(links))
+	for shard, links := range byShard {
+		found, err := shard.ListHealth(ctx, links)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(health, found)
+	}
+	return health, nil
+}
+
+func (r *ShardedRepository) HealthStats(ctx context.Context) (*HealthCounts, error) {
+	counts := &HealthCounts{}
+	for _, shard := range r.shards {
+		found, err := shard.HealthStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		counts.Alive += found.Alive
+		counts.NotFound += found.NotFound
+		counts.HTTPError += found.HTTPError
+		counts.Timeout += found.Timeout
+		counts.SSLError += found.SSLError
+		counts.Unreachable += found.Unreachable
+	}
+	return counts, nil
+}
+
+func (r *ShardedRepository) Delete(ctx context.Context, link *Link) error {
+	return r.shardOf(link).Delete(ctx, link)
+}
+
+func (r *ShardedRepository) Restore(ctx context.Context, link *Link, ownerID uint64) error {
+	return r.shardOf(link).Restore(ctx, link, ownerID)
+}
+
+func (r *ShardedRepository) SetStatus(ctx context.Context, link *Link, status Status) error {
+	return r.shardOf(link).SetStatus(ctx, link, status)
+}
+
+func (r *ShardedRepository) Invalidate(ctx context.Context, link *Link) error {
+	return r.shardOf(link).Invalidate(ctx, link)
+}
+
+func (r *ShardedRepository) Stats(ctx context.Context, now time.Time) (*Stats, error) {
+	var total Stats
+	for _, shard := range r.shards {
+		st, err := shard.Stats(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		total.Total += st.Total
+		total.Active += st.Active
+		total.Disabled += st.Disabled
+		total.Flagged += st.Flagged
+		total.Reported += st.Reported
+		total.Expired += st.Expired
+		total.Clicks += st.Clicks
+	}
+	return &total, nil
+}
+
+// AddReport tries the shard of report.LinkID, and then the primary in case
+// the link has a free-form code.
+func (r *ShardedRepository) AddReport(ctx context.Context, report *Report) (int64, error) {
+	shard := r.shards[r.m.Shard(report.LinkID)]
+	open, err := shard.AddReport(ctx, report)
+	if errors.Is(err, ErrNotFound) && shard != r.primary() {
+		return r.primary().AddReport(ctx, report)
+	}
+	return open, err
+}
+
+func (r *ShardedRepository) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error) {
+	n := uint64(len(r.shards))
+	var reports []*Report
+	for i, shard := range r.shards {
+		// The local IDs below the cursor are those with
+		// local*n+i < cursor, i.e. local <= (cursor-i-1)/n
+		var local uint64
+		if cursor != 0 {
+			if cursor <= uint64(i) {
+				continue
+			}
+			local = (cursor-uint64(i)-1)/n + 1
+		}
+		page, err := shard.ListReports(ctx, local, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, report := range page {
+			report.ID = report.ID*n + uint64(i)
+		}
+		reports = append(reports, page...)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID > reports[j].ID })
+	if len(reports) > limit {
+		reports = reports[:limit]
+	}
+	return reports, nil
+}
+
+func (r *ShardedRepository) ResolveReports(ctx context.Context, link *Link, now time.Time) error {
+	return r.shardOf(link).ResolveReports(ctx, link, now)
+}
+
+func (r *ShardedRepository) AddAudit(ctx context.Context, entry *AuditEntry) error {
+	return r.primary().AddAudit(ctx, entry)
+}
+
+func (r *ShardedRepository) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error) {
+	return r.primary().ListAudit(ctx, f, cursor, limit)
+}
+
+func (r *ShardedRepository) AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error {
+	return r.shardOf(link).AddLinkChange(ctx, link, change)
+}
+
+func (r *ShardedRepository) ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error) {
+	return r.shardOf(link).ListLinkChanges(ctx, link, cursor, limit)
+}
+
+func (r *ShardedRepository) GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error) {
+	return r.shardOf(link).GetLinkChange(ctx, link, id)
+}
+
+func (r *ShardedRepository) CreateUser(ctx context.Context, user *User) error {
+	return r.primary().CreateUser(ctx, user)
+}
+
+func (r *ShardedRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return r.primary().GetUserByEmail(ctx, email)
+}
+
+func (r *ShardedRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	return r.primary().CreateAPIKey(ctx, key)
+}
+
+func (r *ShardedRepository) GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	return r.primary().GetUserByAPIKey(ctx, keyHash)
+}
+
+func (r *ShardedRepository) ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error) {
+	return r.primary().ListAPIKeys(ctx, userID)
+}
+
+func (r *ShardedRepository) TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	return r.primary().TouchAPIKey(ctx, keyHash, at)
+}
+
+func (r *ShardedRepository) ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	return r.primary().ExpireAPIKey(ctx, keyHash, at)
+}
+
+func (r *ShardedRepository) DeleteAPIKey(ctx context.Context, keyHash string) error {
+	return r.primary().DeleteAPIKey(ctx, keyHash)
+}
+
+func (r *ShardedRepository) CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error {
+	return r.primary().CreateIdentity(ctx, userID, issuer, subject)
+}
+
+func (r *ShardedRepository) LookupIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	return r.primary().LookupIdentity(ctx, issuer, subject)
+}
+
+func (r *ShardedRepository) SetUserPlan(ctx context.Context, userID uint64, plan string) error {
+	return r.primary().SetUserPlan(ctx, userID, plan)
+}
+
+func (r *ShardedRepository) SetUserRole(ctx context.Context, userID uint64, role string) error {
+	return r.primary().SetUserRole(ctx, userID, role)
+}
+
+func (r *ShardedRepository) UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+	var total Usage
+	for _, shard := range r.shards {
+		u, err := shard.UsageByOwner(ctx, ownerID, since)
+		if err != nil {
+			return nil, err
+		}
+		total.Total += u.Total
+		total.Recent += u.Recent
+		total.CustomAliases += u.CustomAliases
+	}
+	return &total, nil
+}
+
+func (r *ShardedRepository) IncrementClicks(ctx context.Context, link *Link) (int64, error) {
+	return r.shardOf(link).IncrementClicks(ctx, link)
+}
+
+func (r *ShardedRepository) Consume(ctx context.Context, link *Link, at time.Time) (bool, error) {
+	return r.shardOf(link).Consume(ctx, link, at)
+}
+
+func (r *ShardedRepository) FlushClicks(ctx context.Context) (int, error) {
+	return r.sum(func(shard Repository) (int, error) { return shard.FlushClicks(ctx) })
+}
+
+func (r *ShardedRepository) RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+	return r.shardOf(link).RecordClick(ctx, link, at, src)
+}
+
+func (r *ShardedRepository) RollupClicks(ctx context.Context) (int, error) {
+	return r.sum(func(shard Repository) (int, error) { return shard.RollupClicks(ctx) })
+}
+
+func (r *ShardedRepository) ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	return r.shardOf(link).ClickSeries(ctx, link, granularity, from, to)
+}
+
+func (r *ShardedRepository) ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error) {
+	return r.shardOf(link).ClickSources(ctx, link, from, to)
+}
+
+func (r *ShardedRepository) PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error) {
+	return r.sum(func(shard Repository) (int, error) { return shard.PurgeClicks(ctx, granularity, before) })
+}
+
+func (r *ShardedRepository) DeleteClicks(ctx context.Context, link *Link) (int, error) {
+	return r.shardOf(link).DeleteClicks(ctx, link)
+}
+
+func (r *ShardedRepository) TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error) {
+	var total TopStats
+	for _, shard := range r.shards {
+		st, err := shard.TopStats(ctx, granularity, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		total.Redirects += st.Redirects
+		total.LinksCreated += st.LinksCreated
+		total.Top = append(total.Top, st.Top...)
+	}
+	total.Top = topLinks(total.Top, limit)
+	return &total, nil
+}
+
+func (r *ShardedRepository) CreateCampaign(ctx context.Context, c *Campaign) error {
+	return r.primary().CreateCampaign(ctx, c)
+}
+
+func (r *ShardedRepository) GetCampaign(ctx context.Context, id uint64) (*Campaign, error) {
+	return r.primary().GetCampaign(ctx, id)
+}
+
+func (r *ShardedRepository) ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error) {
+	return r.primary().ListCampaigns(ctx, ownerID)
+}
+
+func (r *ShardedRepository) UpdateCampaign(ctx context.Context, c *Campaign) error {
+	return r.primary().UpdateCampaign(ctx, c)
+}
+
+// DeleteCampaign takes the links on the other shards out of c before
+// deleting it on the primary, which does the same for its own links.
+func (r *ShardedRepository) DeleteCampaign(ctx context.Context, c *Campaign) error {
+	for _, shard := range r.shards[1:] {
+		var cursor uint64
+		for {
+			links, err := shard.ListByCampaign(ctx, c.ID, cursor, MaxListLimit)
+			if err != nil {
+				return err
+			}
+			for _, link := range links {
+				link.CampaignID = 0
+				if err := shard.Update(ctx, link); err != nil && !errors.Is(err, ErrNotFound) {
+					return err
+				}
+			}
+			if len(links) < MaxListLimit {
+				break
+			}
+			cursor = links[len(links)-1].ID
+		}
+	}
+	return r.primary().DeleteCampaign(ctx, c)
+}
+
+func (r *ShardedRepository) CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error) {
+	var total CampaignStats
+	for _, shard := range r.shards {
+		st, err := shard.CampaignStats(ctx, campaignID, granularity, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		total.Links += st.Links
+		total.Clicks += st.Clicks
+		total.Top = append(total.Top, st.Top...)
+	}
+	total.Top = topLinks(total.Top, limit)
+	return &total, nil
+}
+
+// topLinks keeps the limit most clicked of top.
+func topLinks(top []TopLink, limit int) []TopLink {
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Clicks > top[j].Clicks })
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+func (r *ShardedRepository) CreateDomain(ctx context.Context, d *Domain) error {
+	return r.primary().CreateDomain(ctx, d)
+}
+
+func (r *ShardedRepository) GetDomain(ctx context.Context, id uint64) (*Domain, error) {
+	return r.primary().GetDomain(ctx, id)
+}
+
+func (r *ShardedRepository) GetDomainByHost(ctx context.Context, host string) (*Domain, error) {
+	return r.primary().GetDomainByHost(ctx, host)
+}
+
+func (r *ShardedRepository) ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error) {
+	return r.primary().ListDomains(ctx, ownerID)
+}
+
+func (r *ShardedRepository) UpdateDomain(ctx context.Context, d *Domain) error {
+	return r.primary().UpdateDomain(ctx, d)
+}
+
+// SaveAlias checks the reservations of the primary and then saves link
+// like Save. The check and the insert may be on different databases, so a
+// reservation added in between is not seen.
+func (r *ShardedRepository) SaveAlias(ctx context.Context, link *Link) (uint64, error) {
+	reservations, err := r.primary().ListAliasReservations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkReservations(reservations, link); err != nil {
+		return 0, err
+	}
+	return r.Save(ctx, link)
+}
+
+func (r *ShardedRepository) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	return r.primary().AddAliasReservation(ctx, res)
+}
+
+func (r *ShardedRepository) ListAliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	return r.primary().ListAliasReservations(ctx)
+}
+
+func (r *ShardedRepository) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	return r.primary().DeleteAliasReservation(ctx, id)
+}
+
+func (r *ShardedRepository) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	return r.primary().AddDomainRule(ctx, rule)
+}
+
+func (r *ShardedRepository) ListDomainRules(ctx context.Context) ([]*DomainRule, error) {
+	return r.primary().ListDomainRules(ctx)
+}
+
+func (r *ShardedRepository) DeleteDomainRule(ctx context.Context, id uint64) error {
+	return r.primary().DeleteDomainRule(ctx, id)
+}
+
+// DeleteDomain unbinds the links of the owner of d on the other shards from
+// its host before deleting it on the primary, which does the same for its
+// own links.
+func (r *ShardedRepository) DeleteDomain(ctx context.Context, d *Domain) error {
+	for _, shard := range r.shards[1:] {
+		var cursor uint64
+		for {
+			links, err := shard.ListByOwner(ctx, d.OwnerID, cursor, MaxListLimit)
+			if err != nil {
+				return err
+			}
+			for _, link := range links {
+				if link.Domain != d.Host {
+					continue
+				}
+				link.Domain = ""
+				if err := shard.Update(ctx, link); err != nil && !errors.Is(err, ErrNotFound) {
+					return err
+				}
+			}
+			if len(links) < MaxListLimit {
+				break
+			}
+			cursor = links[len(links)-1].ID
+		}
+	}
+	return r.primary().DeleteDomain(ctx, d)
+}
+
+func (r *ShardedRepository) CreateOrg(ctx context.Context, o *Org, creatorID uint64) error {
+	return r.primary().CreateOrg(ctx, o, creatorID)
+}
+
+func (r *ShardedRepository) GetOrg(ctx context.Context, id uint64) (*Org, error) {
+	return r.primary().GetOrg(ctx, id)
+}
+
+func (r *ShardedRepository) ListOrgs(ctx context.Context, userID uint64) ([]*Org, error) {
+	return r.primary().ListOrgs(ctx, userID)
+}
+
+func (r *ShardedRepository) ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error) {
+	return r.primary().ListOrgMembers(ctx, orgID)
+}
+
+func (r *ShardedRepository) IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error) {
+	return r.primary().IsOrgMember(ctx, orgID, userID)
+}
+
+func (r *ShardedRepository) AddOrgMember(ctx context.Context, orgID, userID uint64) error {
+	return r.primary().AddOrgMember(ctx, orgID, userID)
+}
+
+func (r *ShardedRepository) RemoveOrgMember(ctx context.Context, orgID, userID uint64) error {
+	return r.primary().RemoveOrgMember(ctx, orgID, userID)
+}
+
+func (r *ShardedRepository) AddInvitation(ctx context.Context, inv *Invitation) error {
+	return r.primary().AddInvitation(ctx, inv)
+}
+
+func (r *ShardedRepository) GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	return r.primary().GetInvitation(ctx, tokenHash)
+}
+
+func (r *ShardedRepository) UseInvitation(ctx context.Context, tokenHash string) error {
+	return r.primary().UseInvitation(ctx, tokenHash)
+}
+
+func (r *ShardedRepository) ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error) {
+	return r.mergeLinks(limit, func(shard Repository) ([]*Link, error) {
+		return shard.ListByOrg(ctx, orgID, cursor, limit)
+	})
+}
+
+func (r *ShardedRepository) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	return r.sum(func(shard Repository) (int, error) { return shard.PurgeExpired(ctx, now) })
+}
+
+// sum runs task on every shard, even after one fails, and adds up the
+// counts.
+func (r *ShardedRepository) sum(task func(Repository) (int, error)) (int, error) {
+	total := 0
+	var errs []error
+	for _, shard := range r.shards {
+		n, err := task(shard)
+		total += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+func (r *ShardedRepository) Close() error {
+	var errs []error
+	for _, shard := range r.shards {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
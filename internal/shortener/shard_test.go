@@ -0,0 +1,201 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestShardMap(t *testing.T) {
+	ranges, err := RangeShards(100, 1000)
+	if err != nil {
+		t.Fatalf("RangeShards() unexpected error = %v", err)
+	}
+	for id, want := range map[uint64]int{1: 0, 99: 0, 100: 1, 999: 1, 1000: 2, 1 << 60: 2} {
+		if got := ranges.Shard(id); got != want {
+			t.Errorf("range Shard(%d) = %d, want %d", id, got, want)
+		}
+	}
+	if _, err := RangeShards(1000, 100); err == nil {
+		t.Error("RangeShards() with decreasing bounds succeeded, want an error")
+	}
+
+	hashed, err := HashShards(4)
+	if err != nil {
+		t.Fatalf("HashShards() unexpected error = %v", err)
+	}
+	counts := make([]int, hashed.Len())
+	for id := uint64(1); id <= 4000; id++ {
+		counts[hashed.Shard(id)]++
+	}
+	for i, n := range counts {
+		if n < 800 || n > 1200 {
+			t.Errorf("hash shard %d got %d of 4000 sequential IDs, want about 1000", i, n)
+		}
+	}
+}
+
+func TestShardedRepository(t *testing.T) {
+	ctx := context.Background()
+	shards := []*InMemoryRepository{NewInMemoryRepository(), NewInMemoryRepository(), NewInMemoryRepository()}
+	m, _ := RangeShards(3, 6)
+	repo, err := NewShardedRepository(m, shards[0], shards[1], shards[2])
+	if err != nil {
+		t.Fatalf("NewShardedRepository() unexpected error = %v", err)
+	}
+	if _, err := NewShardedRepository(m, shards[0]); err == nil {
+		t.Error("NewShardedRepository() with too few shards succeeded, want an error")
+	}
+
+	// IDs come from the primary and route by range; free-form codes stay
+	// on the primary
+	campaign := &Campaign{OwnerID: 1, Name: "launch"}
+	if err := repo.CreateCampaign(ctx, campaign); err != nil {
+		t.Fatalf("CreateCampaign() unexpected error = %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		link := &Link{OriginalURL: "https://example.com", OwnerID: 1, CampaignID: campaign.ID}
+		if i == 4 {
+			link.Code = "promo"
+		}
+		if _, err := repo.Save(ctx, link); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+	for id, want := range map[uint64]int{1: 0, 2: 0, 3: 1, 4: 1, 5: 0, 6: 2, 7: 2} {
+		if _, err := shards[want].Get(ctx, id); err != nil {
+			t.Errorf("link %d not on shard %d: %v", id, want, err)
+		}
+		if link, err := repo.Get(ctx, id); err != nil || link.ID != id {
+			t.Errorf("Get(%d) = %+v, %v", id, link, err)
+		}
+	}
+	if link, err := repo.GetByCode(ctx, "promo"); err != nil || link.ID != 5 {
+		t.Errorf("GetByCode() = %+v, %v, want link 5", link, err)
+	}
+	if _, err := repo.Save(ctx, &Link{Code: "promo", OriginalURL: "https://example.com"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() of a taken code error = %v, want ErrConflict", err)
+	}
+	// A pinned ID routed away from the primary must not reuse the ID of a
+	// link with a free-form code
+	if _, err := repo.Save(ctx, &Link{ID: 5, OriginalURL: "https://example.com"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() of the pinned ID of a link with a code error = %v, want ErrConflict", err)
+	}
+	if _, err := repo.Save(ctx, &Link{ID: 100, OriginalURL: "https://example.com"}); err != nil {
+		t.Fatalf("Save() of a pinned ID unexpected error = %v", err)
+	}
+	if _, err := shards[2].Get(ctx, 100); err != nil {
+		t.Errorf("pinned link not on shard 2: %v", err)
+	}
+
+	// Listings merge the shards by ID
+	var ids []uint64
+	for cursor := uint64(0); ; {
+		page, err := repo.List(ctx, cursor, 3)
+		if err != nil {
+			t.Fatalf("List() unexpected error = %v", err)
+		}
+		for _, link := range page {
+			ids = append(ids, link.ID)
+		}
+		if len(page) < 3 {
+			break
+		}
+		cursor = page[len(page)-1].ID
+	}
+	if len(ids) != 8 || ids[0] != 100 || ids[1] != 7 || ids[len(ids)-1] != 1 {
+		t.Errorf("List() pages = %v, want 100 and 7 down to 1", ids)
+	}
+	if st, err := repo.Stats(ctx, time.Now()); err != nil || st.Total != 8 {
+		t.Errorf("Stats() = %+v, %v, want 8 links", st, err)
+	}
+	if u, err := repo.UsageByOwner(ctx, 1, time.Time{}); err != nil || u.Total != 7 {
+		t.Errorf("UsageByOwner() = %+v, %v, want 7 links", u, err)
+	}
+
+	// Reports are found on the shard of the link and numbered across shards
+	for _, id := range []uint64{1, 3, 5, 6} {
+		if _, err := repo.AddReport(ctx, &Report{LinkID: id, Reporter: "a"}); err != nil {
+			t.Fatalf("AddReport(%d) unexpected error = %v", id, err)
+		}
+	}
+	if _, err := repo.AddReport(ctx, &Report{LinkID: 42, Reporter: "a"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddReport() of a missing link error = %v, want ErrNotFound", err)
+	}
+	seen := make(map[uint64]bool)
+	for cursor := uint64(0); ; {
+		page, err := repo.ListReports(ctx, cursor, 1)
+		if err != nil {
+			t.Fatalf("ListReports() unexpected error = %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if seen[page[0].LinkID] {
+			t.Fatalf("ListReports() returned link %d twice", page[0].LinkID)
+		}
+		seen[page[0].LinkID] = true
+		cursor = page[0].ID
+	}
+	if len(seen) != 4 {
+		t.Errorf("ListReports() pages covered %d reports, want 4", len(seen))
+	}
+
+	// Deleting a campaign takes the links on every shard out of it
+	if err := repo.DeleteCampaign(ctx, campaign); err != nil {
+		t.Fatalf("DeleteCampaign() unexpected error = %v", err)
+	}
+	for _, shard := range shards {
+		if links, err := shard.ListByCampaign(ctx, campaign.ID, 0, 10); err != nil || len(links) != 0 {
+			t.Errorf("links still in the deleted campaign: %d, %v", len(links), err)
+		}
+	}
+}
+
+func TestReshard(t *testing.T) {
+	src, srcMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer src.Close()
+	dst, dstMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer dst.Close()
+
+	// Link 12 moves from the primary to the second shard; link 3 stays
+	m, _ := RangeShards(10)
+	srcMock.ExpectQuery(`SELECT id FROM urls WHERE id > \$1 AND code IS NULL`).WithArgs(int64(0), reshardBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(12))
+	dstMock.ExpectBegin()
+	for _, table := range linkTables {
+		srcMock.ExpectQuery(`SELECT COALESCE\(json_agg\(t\), '\[\]'\) FROM ` + table.name).
+			WillReturnRows(sqlmock.NewRows([]string{"rows"}).AddRow([]byte(`[]`)))
+		dstMock.ExpectExec(`INSERT INTO ` + table.name + ` .*json_populate_recordset`).WithArgs("[]").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	dstMock.ExpectCommit()
+	srcMock.ExpectExec(`DELETE FROM urls WHERE id = ANY`).WillReturnResult(sqlmock.NewResult(0, 1))
+	dstMock.ExpectQuery(`SELECT id FROM urls WHERE id > \$1 AND code IS NULL`).WithArgs(int64(0), reshardBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	moved, err := Reshard(context.Background(), []*sql.DB{src, dst}, m)
+	if err != nil || moved != 1 {
+		t.Errorf("Reshard() = %d, %v, want 1 link moved", moved, err)
+	}
+	if err := srcMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled source expectations: %v", err)
+	}
+	if err := dstMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled destination expectations: %v", err)
+	}
+
+	if _, err := Reshard(context.Background(), []*sql.DB{src}, m); err == nil {
+		t.Error("Reshard() with too few databases succeeded, want an error")
+	}
+}
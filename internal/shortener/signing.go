@@ -0,0 +1,66 @@
+package shortener
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// signingKeyIDPrefix marks the key IDs that identify an API key in signed
+// requests.
+const signingKeyIDPrefix = "hk_"
+
+// ErrSigningDisabled is returned for signing credentials when no signing
+// secret is configured.
+var ErrSigningDisabled = errors.New("request signing is not enabled")
+
+// WithRequestSigning lets API keys sign requests instead of sending their
+// secret (see SigningCredential). The signing secrets of keys are derived
+// from secret, so that only the hashes of keys need to be stored; changing
+// secret invalidates all of them.
+func WithRequestSigning(secret []byte) Option {
+	return func(s *Service) {
+		s.signingSecret = secret
+	}
+}
+
+// SigningEnabled reports whether API keys may sign requests.
+func (s *Service) SigningEnabled() bool {
+	return len(s.signingSecret) > 0
+}
+
+// SigningCredential returns the key ID and secret with which requests are
+// signed on behalf of key. Callers that only sign requests never need the
+// secret of key itself. Returns ErrSigningDisabled without a signing
+// secret.
+func (s *Service) SigningCredential(key *APIKey) (keyID, secret string, err error) {
+	if !s.SigningEnabled() {
+		return "", "", ErrSigningDisabled
+	}
+	return signingKeyIDPrefix + key.KeyHash, s.signingKey(key.KeyHash), nil
+}
+
+// AuthenticateSigningKey resolves the key ID of a signed request to its
+// user, as Authenticate does for API keys, and returns the secret the
+// request must be signed with.
+func (s *Service) AuthenticateSigningKey(ctx context.Context, keyID string) (*User, string, error) {
+	hash, ok := strings.CutPrefix(keyID, signingKeyIDPrefix)
+	if !ok || !s.SigningEnabled() {
+		return nil, "", ErrInvalidCredentials
+	}
+	user, err := s.authenticateKey(ctx, hash)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, s.signingKey(hash), nil
+}
+
+// signingKey derives the signing secret of the API key with keyHash.
+func (s *Service) signingKey(keyHash string) string {
+	mac := hmac.New(sha256.New, s.signingSecret)
+	mac.Write([]byte("signing:" + keyHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
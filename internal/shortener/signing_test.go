@@ -0,0 +1,58 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestService_SigningCredential(t *testing.T) {
+	repo := NewInMemoryRepository()
+	ctx := context.Background()
+
+	disabled := NewService(repo)
+	user, login, err := disabled.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	keys, err := disabled.APIKeys(ctx, user)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("APIKeys() = %+v, %v", keys, err)
+	}
+	if _, _, err := disabled.SigningCredential(keys[0]); !errors.Is(err, ErrSigningDisabled) {
+		t.Errorf("SigningCredential() without a secret error = %v, want ErrSigningDisabled", err)
+	}
+
+	service := NewService(repo, WithRequestSigning([]byte("0123456789abcdef0123456789abcdef")))
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	keyID, secret, err := service.SigningCredential(keys[0])
+	if err != nil {
+		t.Fatalf("SigningCredential() unexpected error = %v", err)
+	}
+	signer, got, err := service.AuthenticateSigningKey(ctx, keyID)
+	if err != nil || signer.ID != user.ID || got != secret {
+		t.Errorf("AuthenticateSigningKey() = %+v, %q, %v, want alice with the secret", signer, got, err)
+	}
+	if secret == login || keyID == login {
+		t.Errorf("SigningCredential() = %q, %q, must not reveal the key", keyID, secret)
+	}
+
+	// The credential is only as valid as its key
+	other := NewService(repo, WithRequestSigning([]byte("another secret of at least 32 bytes")))
+	if _, otherSecret, _ := other.SigningCredential(keys[0]); otherSecret == secret {
+		t.Error("SigningCredential() is the same under another signing secret")
+	}
+	for _, id := range []string{login, "hk_unknown", keyID[3:]} {
+		if _, _, err := service.AuthenticateSigningKey(ctx, id); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("AuthenticateSigningKey(%q) error = %v, want ErrInvalidCredentials", id, err)
+		}
+	}
+	if err := service.RevokeAPIKey(ctx, user, keys[0].ID); err != nil {
+		t.Fatalf("RevokeAPIKey() unexpected error = %v", err)
+	}
+	if _, _, err := service.AuthenticateSigningKey(ctx, keyID); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("AuthenticateSigningKey() of a revoked key error = %v, want ErrInvalidCredentials", err)
+	}
+}
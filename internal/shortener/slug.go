@@ -0,0 +1,84 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxSlugSuffix is the highest number appended to a slug that is taken.
+	maxSlugSuffix = 99
+	// maxSlugLength leaves room in an alias for the suffix.
+	maxSlugLength = maxAliasLength - len("-99")
+)
+
+// slugLetters spells accented Latin letters without their accents.
+var slugLetters = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i",
+	'î': "i", 'ï': "i", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o",
+	'ö': "o", 'ø': "o", 'œ': "oe", 'ß': "ss", 'ù': "u", 'ú': "u", 'û': "u",
+	'ü': "u", 'ý': "y", 'ÿ': "y",
+}
+
+// Slugify turns title into a slug: its letters and digits in lowercase,
+// words separated by '-', e.g. "spring-sale-24" for "Spring Sale 24".
+// Accented Latin letters lose their accents and other characters separate
+// words. Slugs are cut at a word boundary to leave room for the numeric
+// suffix of a taken slug. Returns "" if title has no letters or digits.
+func Slugify(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r <= unicode.MaxASCII && isAliasChar(byte(r)) && r != '-' && r != '_':
+			b.WriteRune(r)
+		case slugLetters[r] != "":
+			b.WriteString(slugLetters[r])
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	return cutSlug(b.String(), maxSlugLength)
+}
+
+// cutSlug cuts slug to at most n bytes, at the end of a word if it has to.
+func cutSlug(slug string, n int) string {
+	if len(slug) > n {
+		slug = slug[:n+1]
+		if i := strings.LastIndexByte(slug, '-'); i > 0 {
+			slug = slug[:i]
+		} else {
+			slug = slug[:n]
+		}
+	}
+	return strings.TrimSuffix(slug, "-")
+}
+
+// shortenWithSlug saves link under the slug of title as a custom alias,
+// with the prefix of its organization under the code policy. If the slug
+// is taken it tries slug-2, slug-3 and so on up to maxSlugSuffix.
+func (s *Service) shortenWithSlug(ctx context.Context, link *Link, title string) (string, error) {
+	slug := Slugify(title)
+	if slug == "" {
+		return "", fmt.Errorf("%w: %q has no letters or digits to make a slug of", ErrInvalidAlias, title)
+	}
+	if prefix := s.codePolicy.OrgPrefixes[link.OrgID]; link.OrgID != 0 && prefix != "" {
+		slug = prefix + cutSlug(slug, maxSlugLength-len(prefix))
+	}
+	for n := 1; n <= maxSlugSuffix; n++ {
+		alias := slug
+		if n > 1 {
+			alias += "-" + strconv.Itoa(n)
+		}
+		link.ID, link.Code = 0, ""
+		code, err := s.shortenWithAlias(ctx, link, alias)
+		if !errors.Is(err, ErrAliasTaken) {
+			return code, err
+		}
+	}
+	return "", fmt.Errorf("%w: %q and its first %d numbered variants are taken", ErrAliasTaken, slug, maxSlugSuffix-1)
+}
@@ -0,0 +1,99 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{title: "Spring Sale 24", want: "spring-sale-24"},
+		{title: "  Spring   Sale -- 24!  ", want: "spring-sale-24"},
+		{title: "Crème Brûlée & Café", want: "creme-brulee-cafe"},
+		{title: "Straße", want: "strasse"},
+		{title: "snake_case title", want: "snake-case-title"},
+		{title: "日本 Sale", want: "sale"},
+		{title: "!!!", want: ""},
+		{title: "The quick brown fox jumps over the lazy dog", want: "the-quick-brown-fox-jumps"},
+		{title: "Supercalifragilisticexpialidocious!", want: "supercalifragilisticexpialido"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			got := Slugify(tt.title)
+			if got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+			if len(got) > maxSlugLength {
+				t.Errorf("Slugify(%q) = %q, longer than %d", tt.title, got, maxSlugLength)
+			}
+		})
+	}
+}
+
+func TestService_ShortenWithSlug(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+
+	for _, want := range []string{"spring-sale-24", "spring-sale-24-2", "spring-sale-24-3"} {
+		code, err := svc.ShortenWithOptions(ctx, "https://example.com/"+want, ShortenOptions{SlugFrom: "Spring Sale 24"})
+		if err != nil {
+			t.Fatalf("ShortenWithOptions() error = %v", err)
+		}
+		if code != want {
+			t.Errorf("ShortenWithOptions() = %q, want %q", code, want)
+		}
+		link, err := svc.Resolve(ctx, code)
+		if err != nil || !link.CustomAlias || link.OriginalURL != "https://example.com/"+want {
+			t.Errorf("Resolve(%q) = %+v, %v", code, link, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    ShortenOptions
+		wantErr error
+	}{
+		{name: "no letters", opts: ShortenOptions{SlugFrom: "!?"}, wantErr: ErrInvalidAlias},
+		{name: "too short", opts: ShortenOptions{SlugFrom: "Go"}, wantErr: ErrInvalidAlias},
+		{name: "route of the service", opts: ShortenOptions{SlugFrom: "Docs"}, wantErr: ErrAliasReserved},
+		{name: "with a custom alias", opts: ShortenOptions{SlugFrom: "Spring", CustomAlias: "spring"}, wantErr: ErrInvalidAlias},
+		{name: "with an alphabet", opts: ShortenOptions{SlugFrom: "Spring", Alphabet: Base58}, wantErr: ErrInvalidAlphabet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.ShortenWithOptions(ctx, "https://example.com/", tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ShortenWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_ShortenWithSlugOrgPrefix(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewInMemoryRepository())
+	alice, _, err := svc.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	acme, err := svc.CreateOrg(ctx, alice, "Acme")
+	if err != nil {
+		t.Fatalf("CreateOrg() error = %v", err)
+	}
+	policy, err := NewCodePolicy(0, map[uint64]string{acme.ID: "acme-"}, nil)
+	if err != nil {
+		t.Fatalf("NewCodePolicy() error = %v", err)
+	}
+	WithCodePolicy(policy)(svc)
+
+	code, err := svc.ShortenWithOptions(ctx, "https://example.com/", ShortenOptions{SlugFrom: "Spring Sale", Owner: alice, OrgID: acme.ID})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if code != "acme-spring-sale" {
+		t.Errorf("ShortenWithOptions() = %q, want acme-spring-sale", code)
+	}
+}
@@ -0,0 +1,182 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Dimensions of the traffic source breakdown.
+const (
+	SourceReferrer    = "referrer"
+	SourceUTMSource   = "utm_source"
+	SourceUTMMedium   = "utm_medium"
+	SourceUTMCampaign = "utm_campaign"
+)
+
+const (
+	// DirectReferrer is the referrer of clicks without a usable Referer
+	// header, e.g. from apps, bookmarks or typed URLs.
+	DirectReferrer = "(direct)"
+	// UntrackedReferrer is the referrer of clicks from clients that asked
+	// not to be tracked, whose source is not recorded.
+	UntrackedReferrer = "(untracked)"
+	// maxSourceValueLength caps referrer domains and UTM values, which come
+	// straight from clients.
+	maxSourceValueLength = 100
+
+	DefaultSourceLimit = 10
+	MaxSourceLimit     = 100
+)
+
+// clickSourcesTable holds the daily clicks per link, dimension and value.
+const clickSourcesTable = "click_sources"
+
+// ClickSource is where a click came from: the domain of the referring page
+// and the UTM parameters of the short URL.
+type ClickSource struct {
+	Referrer string
+	UTM      UTM
+}
+
+// ParseClickSource extracts the ClickSource of a redirect from its Referer
+// header and query. Values are lowercased so that "Twitter" and "twitter"
+// add up, and "www." is dropped from referrer domains.
+func ParseClickSource(referer string, query url.Values) ClickSource {
+	src := ClickSource{
+		Referrer: DirectReferrer,
+		UTM: UTM{
+			Source:   sourceValue(query.Get(SourceUTMSource)),
+			Medium:   sourceValue(query.Get(SourceUTMMedium)),
+			Campaign: sourceValue(query.Get(SourceUTMCampaign)),
+		},
+	}
+	if u, err := url.Parse(referer); err == nil && u.Hostname() != "" {
+		src.Referrer = sourceValue(strings.TrimPrefix(u.Hostname(), "www."))
+	}
+	return src
+}
+
+// sourceValue normalizes a dimension value and cuts it to
+// maxSourceValueLength characters.
+func sourceValue(v string) string {
+	v = strings.ToValidUTF8(strings.ToLower(strings.TrimSpace(v)), "")
+	if utf8.RuneCountInString(v) > maxSourceValueLength {
+		v = string([]rune(v)[:maxSourceValueLength])
+	}
+	return v
+}
+
+// dimensions returns the dimension and value pairs src is counted under.
+// The referrer is always counted, UTM parameters only when set.
+func (src ClickSource) dimensions() [][2]string {
+	referrer := src.Referrer
+	if referrer == "" {
+		referrer = DirectReferrer
+	}
+	dims := [][2]string{{SourceReferrer, referrer}}
+	for _, d := range src.UTM.params() {
+		if d[1] != "" {
+			dims = append(dims, d)
+		}
+	}
+	return dims
+}
+
+// clickCounts returns the counts a click of the link with ID linkID at the
+// given time adds: the click itself and one per dimension of src.
+func clickCounts(linkID uint64, at time.Time, src ClickSource) []clickCount {
+	counts := []clickCount{{linkID: linkID, start: at, clicks: 1}}
+	for _, d := range src.dimensions() {
+		counts = append(counts, clickCount{linkID: linkID, start: at, dimension: d[0], value: d[1], clicks: 1})
+	}
+	return counts
+}
+
+// SourceCount is the number of clicks of a link from one value of a
+// dimension.
+type SourceCount struct {
+	Dimension string `json:"-"`
+	Value     string `json:"value"`
+	Clicks    int64  `json:"clicks"`
+}
+
+// ClickBreakdown is the number of clicks of a link in [From, To) per
+// referrer domain and UTM parameter value, most clicks first.
+type ClickBreakdown struct {
+	Code         string        `json:"short_code"`
+	From         time.Time     `json:"from"`
+	To           time.Time     `json:"to"`
+	Referrers    []SourceCount `json:"referrers"`
+	UTMSources   []SourceCount `json:"utm_sources"`
+	UTMMediums   []SourceCount `json:"utm_mediums"`
+	UTMCampaigns []SourceCount `json:"utm_campaigns"`
+	// AnalyticsDisabled reports that the link opted out of click tracking:
+	// the counts only hold clicks from before.
+	AnalyticsDisabled bool `json:"analytics_disabled"`
+}
+
+// ClickBreakdown returns the clicks of the link behind shortCode, which user
+// must own, by traffic source for the UTC days starting in [from, to), with
+// at most limit values per dimension. Zero from and to default like a daily
+// ClickSeries. Clicks only appear once RollupClicks has run.
+// Returns ErrInvalidSeries for a range that is empty or longer than
+// MaxSeriesBuckets days.
+func (s *Service) ClickBreakdown(ctx context.Context, user *User, shortCode string, from, to time.Time, limit int) (*ClickBreakdown, error) {
+	toNow := to.IsZero()
+	if toNow {
+		to = s.now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultDailyRange)
+	}
+	from, to = truncateBucket(from, GranularityDay), to.UTC()
+	if toNow {
+		to = ceilBucket(to, GranularityDay)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidSeries)
+	}
+	if to.Sub(from) > MaxSeriesBuckets*24*time.Hour {
+		return nil, fmt.Errorf("%w: range exceeds %d days", ErrInvalidSeries, MaxSeriesBuckets)
+	}
+	if limit <= 0 {
+		limit = DefaultSourceLimit
+	}
+	limit = min(limit, MaxSourceLimit)
+
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.repo.ClickSources(ctx, link, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click sources: %w", err)
+	}
+
+	b := &ClickBreakdown{
+		Code:              shortCode,
+		From:              from,
+		To:                to,
+		Referrers:         []SourceCount{},
+		UTMSources:        []SourceCount{},
+		UTMMediums:        []SourceCount{},
+		UTMCampaigns:      []SourceCount{},
+		AnalyticsDisabled: link.AnalyticsDisabled,
+	}
+	lists := map[string]*[]SourceCount{
+		SourceReferrer:    &b.Referrers,
+		SourceUTMSource:   &b.UTMSources,
+		SourceUTMMedium:   &b.UTMMediums,
+		SourceUTMCampaign: &b.UTMCampaigns,
+	}
+	for _, c := range counts {
+		if list, ok := lists[c.Dimension]; ok && len(*list) < limit {
+			*list = append(*list, c)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,129 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClickSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		referer string
+		query   string
+		want    ClickSource
+	}{
+		{"direct", "", "", ClickSource{Referrer: DirectReferrer}},
+		{"referrer domain", "https://www.Example.com:8443/post/1?x=y", "", ClickSource{Referrer: "example.com"}},
+		{"relative referer", "/somewhere", "", ClickSource{Referrer: DirectReferrer}},
+		{
+			"utm",
+			"https://t.co/abc",
+			"utm_source=Twitter&utm_medium=%20social%20&utm_campaign=spring-sale",
+			ClickSource{Referrer: "t.co", UTM: UTM{Source: "twitter", Medium: "social", Campaign: "spring-sale"}},
+		},
+		{"long values", "", "utm_campaign=" + strings.Repeat("é", 150), ClickSource{Referrer: DirectReferrer, UTM: UTM{Campaign: strings.Repeat("é", maxSourceValueLength)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery() unexpected error = %v", err)
+			}
+			if got := ParseClickSource(tt.referer, query); got != tt.want {
+				t.Errorf("ParseClickSource() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_ClickBreakdown(t *testing.T) {
+	for name, newRepo := range analyticsRepos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(newRepo(t))
+			ctx := context.Background()
+
+			user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+			if err != nil {
+				t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+			}
+
+			day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			newsletter := ClickSource{Referrer: "mail.example.net", UTM: UTM{Source: "newsletter", Medium: "email", Campaign: "spring"}}
+			tweet := ClickSource{Referrer: "t.co", UTM: UTM{Source: "twitter", Campaign: "spring"}}
+			for _, c := range []struct {
+				at  time.Time
+				src ClickSource
+			}{
+				{day.Add(time.Hour), tweet},
+				{day.Add(2 * time.Hour), tweet},
+				{day.Add(26 * time.Hour), tweet},
+				{day.Add(3 * time.Hour), newsletter},
+				{day.Add(4 * time.Hour), ClickSource{}},
+				{day.AddDate(0, 0, -3), newsletter},
+			} {
+				service.now = func() time.Time { return c.at }
+				if _, err := service.RedirectVisitor(ctx, code, Visitor{Source: c.src}); err != nil {
+					t.Fatalf("RedirectVisitor() unexpected error = %v", err)
+				}
+			}
+			if n, err := service.RollupClicks(ctx); err != nil || n != 6 {
+				t.Fatalf("RollupClicks() = %d, %v, want 6, nil", n, err)
+			}
+
+			b, err := service.ClickBreakdown(ctx, user, code, day.Add(12*time.Hour), day.AddDate(0, 0, 2), 0)
+			if err != nil {
+				t.Fatalf("ClickBreakdown() unexpected error = %v", err)
+			}
+			if !b.From.Equal(day) || !b.To.Equal(day.AddDate(0, 0, 2)) || b.Code != code {
+				t.Errorf("ClickBreakdown() = %+v, want 2 days from %v", b, day)
+			}
+			for _, tt := range []struct {
+				name string
+				got  []SourceCount
+				want []SourceCount
+			}{
+				{"referrers", b.Referrers, []SourceCount{{SourceReferrer, "t.co", 3}, {SourceReferrer, DirectReferrer, 1}, {SourceReferrer, "mail.example.net", 1}}},
+				{"utm sources", b.UTMSources, []SourceCount{{SourceUTMSource, "twitter", 3}, {SourceUTMSource, "newsletter", 1}}},
+				{"utm mediums", b.UTMMediums, []SourceCount{{SourceUTMMedium, "email", 1}}},
+				{"utm campaigns", b.UTMCampaigns, []SourceCount{{SourceUTMCampaign, "spring", 4}}},
+			} {
+				if !slices.Equal(tt.got, tt.want) {
+					t.Errorf("%s = %+v, want %+v", tt.name, tt.got, tt.want)
+				}
+			}
+
+			b, err = service.ClickBreakdown(ctx, user, code, day.AddDate(0, 0, -7), day.AddDate(0, 0, 7), 1)
+			if err != nil {
+				t.Fatalf("ClickBreakdown() unexpected error = %v", err)
+			}
+			if want := []SourceCount{{SourceUTMSource, "twitter", 3}}; !slices.Equal(b.UTMSources, want) {
+				t.Errorf("limited utm sources = %+v, want %+v", b.UTMSources, want)
+			}
+			if want := []SourceCount{{SourceUTMMedium, "email", 2}}; !slices.Equal(b.UTMMediums, want) {
+				t.Errorf("utm mediums = %+v, want %+v", b.UTMMediums, want)
+			}
+
+			for _, r := range [][2]time.Time{{day, day}, {day, day.AddDate(0, 0, MaxSeriesBuckets+1)}} {
+				if _, err := service.ClickBreakdown(ctx, user, code, r[0], r[1], 0); !errors.Is(err, ErrInvalidSeries) {
+					t.Errorf("ClickBreakdown(%v, %v) error = %v, want ErrInvalidSeries", r[0], r[1], err)
+				}
+			}
+			other, _, err := service.Signup(ctx, "bob@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+			if _, err := service.ClickBreakdown(ctx, other, code, time.Time{}, time.Time{}, 0); !errors.Is(err, ErrForbidden) {
+				t.Errorf("ClickBreakdown() by another user error = %v, want ErrForbidden", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1671 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteSchema mirrors init.sql for SQLite. url_sequence plays the role of the
+// PostgreSQL BIGSERIAL sequence: it hands out IDs independently of the urls
+// table, so pinned custom alias IDs do not move generated IDs forward.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS url_sequence (
+	id INTEGER PRIMARY KEY AUTOINCREMENT
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	plan TEXT NOT NULL DEFAULT 'free',
+	role TEXT NOT NULL DEFAULT 'editor',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT 'shorten,read,delete',
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id, id);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS campaigns (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (owner_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS orgs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+	org_id INTEGER NOT NULL REFERENCES orgs (id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user ON org_members (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+	token_hash TEXT PRIMARY KEY,
+	org_id INTEGER NOT NULL REFERENCES orgs (id) ON DELETE CASCADE,
+	email TEXT NOT NULL,
+	inviter_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS domains (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	host TEXT NOT NULL,
+	token TEXT NOT NULL,
+	verified_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (owner_id, host)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_verified_host ON domains (host) WHERE verified_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS domain_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	pattern TEXT NOT NULL UNIQUE,
+	action TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS alias_reservations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	pattern TEXT NOT NULL,
+	prefix BOOLEAN NOT NULL DEFAULT FALSE,
+	org_id INTEGER REFERENCES orgs (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (pattern, prefix)
+);
+
+CREATE TABLE IF NOT EXISTS urls (
+	id INTEGER PRIMARY KEY,
+	code TEXT UNIQUE,
+	original_url TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP,
+	max_clicks INTEGER,
+	click_count INTEGER NOT NULL DEFAULT 0,
+	status TEXT NOT NULL DEFAULT 'active',
+	owner_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+	custom_alias BOOLEAN NOT NULL DEFAULT FALSE,
+	rules TEXT,
+	experiment TEXT,
+	active_from TIMESTAMP,
+	active_until TIMESTAMP,
+	deleted_at TIMESTAMP,
+	page TEXT,
+	campaign_id INTEGER REFERENCES campaigns (id) ON DELETE SET NULL,
+	domain TEXT,
+	analytics_disabled BOOLEAN NOT NULL DEFAULT FALSE,
+	org_id INTEGER REFERENCES orgs (id) ON DELETE SET NULL,
+	metadata TEXT,
+	query_passthrough TEXT,
+	mask TEXT,
+	burn_after_reading BOOLEAN NOT NULL DEFAULT FALSE,
+	consumed_at TIMESTAMP,
+	access TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_urls_expires_at ON urls (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_created_at ON urls (created_at);
+
+CREATE TABLE IF NOT EXISTS abuse_reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	reason TEXT NOT NULL DEFAULT '',
+	reporter TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	resolved_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_abuse_reports_open ON abuse_reports (url_id, reporter) WHERE resolved_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	code TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_code ON audit_log (code, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, id);
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TABLE IF NOT EXISTS link_targets (
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	country TEXT NOT NULL,
+	url TEXT NOT NULL,
+	PRIMARY KEY (url_id, country)
+);
+
+CREATE TABLE IF NOT EXISTS link_health (
+	url_id INTEGER PRIMARY KEY REFERENCES urls (id) ON DELETE CASCADE,
+	status TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	failures INTEGER NOT NULL DEFAULT 0,
+	checked_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS link_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	actor TEXT NOT NULL,
+	old_url TEXT NOT NULL,
+	new_url TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_history_url ON link_history (url_id, id);
+
+CREATE TABLE IF NOT EXISTS variant_clicks (
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	variant TEXT NOT NULL,
+	served INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (url_id, variant)
+);
+
+CREATE TABLE IF NOT EXISTS clicks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	clicked_at TIMESTAMP NOT NULL,
+	referrer TEXT NOT NULL DEFAULT '',
+	utm_source TEXT NOT NULL DEFAULT '',
+	utm_medium TEXT NOT NULL DEFAULT '',
+	utm_campaign TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS clicks_hourly (
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	bucket TIMESTAMP NOT NULL,
+	clicks INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (url_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS clicks_daily (
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	bucket TIMESTAMP NOT NULL,
+	clicks INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (url_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS click_sources (
+	url_id INTEGER NOT NULL REFERENCES urls (id) ON DELETE CASCADE,
+	bucket TIMESTAMP NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	clicks INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (url_id, bucket, dimension, value)
+);
+`
+
+// SQLiteRepository stores links in a local SQLite database file. It has no
+// cache layer and needs no external services, which makes it suitable for
+// single-binary demos and edge deployments.
+//
+// Timestamps are stored in UTC so that their text representation sorts
+// chronologically (PurgeExpired relies on this).
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository opens (creating if necessary) the database at path and
+// applies the schema.
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma":      {"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)"},
+		"_time_format": {"sqlite"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+	// SQLite allows a single writer; serializing access through one
+	// connection avoids SQLITE_BUSY errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to apply sqlite schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteRepository{db: db}, nil
+}
+
+func (r *SQLiteRepository) Save(ctx context.Context, link *Link) (uint64, error) {
+	link.CreatedAt = time.Now().UTC()
+
+	if link.ID != 0 {
+		err := r.insert(ctx, link)
+		if isSQLiteConstraint(err) {
+			return 0, ErrConflict
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to save url with id %d: %w", link.ID, err)
+		}
+		return link.ID, nil
+	}
+
+	// As with PostgreSQL, a primary key violation means the sequence reached
+	// an ID pinned by a custom alias; the sequence has already advanced, so
+	// retrying yields a fresh ID.
+	var err error
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		link.ID, err = r.nextID(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to allocate id: %w", err)
+		}
+
+		err = r.insert(ctx, link)
+		if err == nil {
+			return link.ID, nil
+		}
+
+		code, _ := sqliteErrorCode(err)
+		if code == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+			link.ID = 0
+			return 0, ErrConflict
+		}
+		if code != sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			break
+		}
+	}
+	link.ID = 0
+	return 0, fmt.Errorf("failed to save url: %w", err)
+}
+
+// SaveAlias allocates an ID like Save unless link has one, then checks the
+// alias reservations and inserts link in one transaction.
+func (r *SQLiteRepository) SaveAlias(ctx context.Context, link *Link) (uint64, error) {
+	link.CreatedAt = time.Now().UTC()
+	pinned := link.ID != 0
+	var err error
+	for attempt := 0; attempt < maxSaveAttempts; attempt++ {
+		if !pinned {
+			if link.ID, err = r.nextID(ctx); err != nil {
+				link.ID = 0
+				return 0, fmt.Errorf("failed to allocate id: %w", err)
+			}
+		}
+		err = r.saveAlias(ctx, link)
+		if err == nil {
+			return link.ID, nil
+		}
+		code, _ := sqliteErrorCode(err)
+		if pinned || code != sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			break
+		}
+	}
+	if !pinned {
+		link.ID = 0
+	}
+	if errors.Is(err, ErrAliasReserved) {
+		return 0, err
+	}
+	if isSQLiteConstraint(err) {
+		return 0, ErrConflict
+	}
+	return 0, fmt.Errorf("failed to save url: %w", err)
+}
+
+func (r *SQLiteRepository) saveAlias(ctx context.Context, link *Link) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+aliasReservationColumns+` FROM alias_reservations WHERE pattern = lower(?1) OR (prefix AND substr(lower(?1), 1, length(pattern)) = pattern)`, link.ShortCode())
+	if err != nil {
+		return fmt.Errorf("failed to check alias reservations: %w", err)
+	}
+	reservations, err := scanAliasReservations(rows)
+	if err != nil {
+		return err
+	}
+	if err := checkReservations(reservations, link); err != nil {
+		return err
+	}
+	if err := insertSQLite(ctx, tx, link); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// nextID allocates an ID from url_sequence using last_insert_rowid.
+// AUTOINCREMENT never reuses IDs, so the allocated row can be deleted
+// straight away to keep the table empty.
+func (r *SQLiteRepository) nextID(ctx context.Context) (uint64, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO url_sequence DEFAULT VALUES`)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM url_sequence WHERE id = ?`, id); err != nil {
+		return 0, err
+	}
+	return uint64(id), nil
+}
+
+func (r *SQLiteRepository) NextID(ctx context.Context) (uint64, error) {
+	id, err := r.nextID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate id: %w", err)
+	}
+	return id, nil
+}
+
+// ReserveIDs allocates one ID like nextID and then moves url_sequence past
+// the rest of the block, in one transaction.
+func (r *SQLiteRepository) ReserveIDs(ctx context.Context, n uint64) (uint64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO url_sequence DEFAULT VALUES`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve %d ids: %w", n, err)
+	}
+	first, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve %d ids: %w", n, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM url_sequence WHERE id = ?`, first); err != nil {
+		return 0, fmt.Errorf("failed to reserve %d ids: %w", n, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sqlite_sequence SET seq = ? WHERE name = 'url_sequence'`, first+int64(n)-1); err != nil {
+		return 0, fmt.Errorf("failed to reserve %d ids: %w", n, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit id reservation: %w", err)
+	}
+	return uint64(first), nil
+}
+
+func (r *SQLiteRepository) insert(ctx context.Context, link *Link) error {
+	return insertSQLite(ctx, r.db, link)
+}
+
+// insertSQLite inserts link into urls with db, which may be a transaction.
+func insertSQLite(ctx context.Context, db sqlExecer, link *Link) error {
+	query := `INSERT INTO urls (id, code, original_url, created_at, expires_at, max_clicks, owner_id, custom_alias, active_from, active_until, campaign_id, domain, analytics_disabled, org_id, query_passthrough, mask, burn_after_reading) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.ExecContext(ctx, query,
+		int64(link.ID), nullString(link.Code), link.OriginalURL, link.CreatedAt, utcTime(link.ExpiresAt), nullInt64(link.MaxClicks), nullID(link.OwnerID), link.CustomAlias,
+		utcTime(link.ActiveFrom), utcTime(link.ActiveUntil), nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), link.BurnAfterReading)
+	return err
+}
+
+func (r *SQLiteRepository) Get(ctx context.Context, id uint64) (*Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE id = ? AND deleted_at IS NULL`
+	link, err := scanLink(r.db.QueryRowContext(ctx, query, int64(id)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url for id %d: %w", id, err)
+	}
+	if link.Targets, err = r.targets(ctx, link.ID); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (r *SQLiteRepository) GetByCode(ctx context.Context, code string) (*Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE code = ? AND deleted_at IS NULL`
+	link, err := scanLink(r.db.QueryRowContext(ctx, query, code))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get url for code %s: %w", code, err)
+	}
+	if link.Targets, err = r.targets(ctx, link.ID); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (r *SQLiteRepository) List(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE id < ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(cursor), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls: %w", err)
+	}
+	return scanLinks(rows, limit)
+}
+
+// Search uses LIKE, which is case-insensitive for ASCII in SQLite.
+func (r *SQLiteRepository) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		q := `SELECT ` + linkColumns + ` FROM urls WHERE (original_url LIKE ?1 ESCAPE '\' OR json_extract(metadata, '$.title') LIKE ?1 ESCAPE '\' OR json_extract(metadata, '$.description') LIKE ?1 ESCAPE '\') AND deleted_at IS NULL ORDER BY id DESC LIMIT ?2`
+		rows, err = r.db.QueryContext(ctx, q, pattern, limit)
+	} else {
+		q := `SELECT ` + linkColumns + ` FROM urls WHERE (original_url LIKE ?1 ESCAPE '\' OR json_extract(metadata, '$.title') LIKE ?1 ESCAPE '\' OR json_extract(metadata, '$.description') LIKE ?1 ESCAPE '\') AND id < ?2 AND deleted_at IS NULL ORDER BY id DESC LIMIT ?3`
+		rows, err = r.db.QueryContext(ctx, q, pattern, int64(cursor), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search urls: %w", err)
+	}
+	return scanLinks(rows, limit)
+}
+
+func (r *SQLiteRepository) ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE owner_id = ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(ownerID), limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE owner_id = ? AND id < ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(ownerID), int64(cursor), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls for owner %d: %w", ownerID, err)
+	}
+	return scanLinks(rows, limit)
+}
+
+func (r *SQLiteRepository) Update(ctx context.Context, link *Link) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET original_url = ?, expires_at = ?, max_clicks = ?, campaign_id = ?, domain = ?, analytics_disabled = ?, org_id = ?, query_passthrough = ?, mask = ? WHERE id = ? AND deleted_at IS NULL`,
+		link.OriginalURL, utcTime(link.ExpiresAt), nullInt64(link.MaxClicks), nullID(link.CampaignID), nullString(link.Domain), link.AnalyticsDisabled, nullID(link.OrgID), nullString(string(link.QueryPassthrough)), nullString(string(link.Mask)), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to update url for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetTargets(ctx context.Context, link *Link, targets []Target) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_targets WHERE url_id = ?`, int64(link.ID)); err != nil {
+		return fmt.Errorf("failed to clear targets for id %d: %w", link.ID, err)
+	}
+	for _, t := range targets {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO link_targets (url_id, country, url) VALUES (?, ?, ?)`,
+			int64(link.ID), t.Country, t.URL); err != nil {
+			return fmt.Errorf("failed to save target for id %d: %w", link.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit targets for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetRules(ctx context.Context, link *Link, rules []Rule) error {
+	data, err := marshalRules(rules)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET rules = ? WHERE id = ? AND deleted_at IS NULL`, nullString(string(data)), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to set rules for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetExperiment(ctx context.Context, link *Link, e *Experiment) error {
+	data, err := marshalExperiment(e)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET experiment = ? WHERE id = ? AND deleted_at IS NULL`, nullString(data), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to set experiment for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetPage(ctx context.Context, link *Link, page *PageInfo) error {
+	data, err := marshalPage(page)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET page = ? WHERE id = ? AND deleted_at IS NULL`, nullString(data), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to set page for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetMetadata(ctx context.Context, link *Link, m *Metadata) error {
+	data, err := marshalMetadata(m)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET metadata = ? WHERE id = ? AND deleted_at IS NULL`, nullString(data), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to set metadata for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetAccess(ctx context.Context, link *Link, a *Access) error {
+	data, err := marshalAccess(a)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET access = ? WHERE id = ? AND deleted_at IS NULL`, nullString(data), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to set access rules for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) RecordVariant(ctx context.Context, link *Link, name string) error {
+	query := `INSERT INTO variant_clicks (url_id, variant, served) VALUES (?, ?, 1)
+		ON CONFLICT (url_id, variant) DO UPDATE SET served = served + 1`
+	if _, err := r.db.ExecContext(ctx, query, int64(link.ID), name); err != nil {
+		return fmt.Errorf("failed to record variant for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) VariantStats(ctx context.Context, link *Link) (map[string]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT variant, served FROM variant_clicks WHERE url_id = ?`, int64(link.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get variant stats for id %d: %w", link.ID, err)
+	}
+	return scanVariantStats(rows)
+}
+
+// targets loads the country targets of the link id.
+func (r *SQLiteRepository) targets(ctx context.Context, id uint64) ([]Target, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT country, url FROM link_targets WHERE url_id = ? ORDER BY country`, int64(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get targets for id %d: %w", id, err)
+	}
+	return scanTargets(rows)
+}
+
+func (r *SQLiteRepository) SetHealth(ctx context.Context, link *Link, h *Health) error {
+	query := `INSERT INTO link_health (url_id, status, status_code, failures, checked_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (url_id) DO UPDATE SET status = excluded.status, status_code = excluded.status_code,
+		failures = excluded.failures, checked_at = excluded.checked_at`
+	if _, err := r.db.ExecContext(ctx, query, int64(link.ID), h.Status, h.StatusCode, h.Failures, h.CheckedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to set health for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error) {
+	if len(links) == 0 {
+		return map[uint64]*Health{}, nil
+	}
+	placeholders := strings.Repeat(", ?", len(links))[2:]
+	args := make([]any, len(links))
+	for i, link := range links {
+		args[i] = int64(link.ID)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+healthColumns+` FROM link_health WHERE url_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list health: %w", err)
+	}
+	return scanHealth(rows)
+}
+
+func (r *SQLiteRepository) HealthStats(ctx context.Context) (*HealthCounts, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT h.status, COUNT(*) FROM link_health h
+		JOIN urls u ON u.id = h.url_id WHERE u.deleted_at IS NULL GROUP BY h.status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute health stats: %w", err)
+	}
+	return scanHealthStats(rows)
+}
+
+func (r *SQLiteRepository) Delete(ctx context.Context, link *Link) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now().UTC(), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to delete url for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) Restore(ctx context.Context, link *Link, ownerID uint64) error {
+	var res sql.Result
+	var err error
+	if link.ID != 0 {
+		res, err = r.db.ExecContext(ctx, `UPDATE urls SET deleted_at = NULL WHERE id = ? AND owner_id = ? AND deleted_at IS NOT NULL`, int64(link.ID), int64(ownerID))
+	} else {
+		res, err = r.db.ExecContext(ctx, `UPDATE urls SET deleted_at = NULL WHERE code = ? AND owner_id = ? AND deleted_at IS NOT NULL`, link.Code, int64(ownerID))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore url %s: %w", link.ShortCode(), err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetStatus(ctx context.Context, link *Link, status Status) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET status = ? WHERE id = ? AND deleted_at IS NULL`, string(status), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to set status for id %d: %w", link.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	link.Status = status
+	return nil
+}
+
+// Invalidate is a no-op: there is no cache in front of SQLite.
+func (r *SQLiteRepository) Invalidate(ctx context.Context, link *Link) error {
+	return nil
+}
+
+func (r *SQLiteRepository) Stats(ctx context.Context, now time.Time) (*Stats, error) {
+	query := `SELECT
+		COUNT(*),
+		COALESCE(SUM(CASE WHEN status = 'active' AND (expires_at IS NULL OR expires_at > ?1) THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN status = 'disabled' THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN status = 'flagged' THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN status = 'reported' THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN expires_at <= ?1 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(click_count), 0)
+	FROM urls WHERE deleted_at IS NULL`
+	var st Stats
+	err := r.db.QueryRowContext(ctx, query, now.UTC()).Scan(&st.Total, &st.Active, &st.Disabled, &st.Flagged, &st.Reported, &st.Expired, &st.Clicks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	return &st, nil
+}
+
+func (r *SQLiteRepository) AddReport(ctx context.Context, report *Report) (int64, error) {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO abuse_reports (url_id, reason, reporter, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (url_id, reporter) WHERE resolved_at IS NULL DO NOTHING`,
+		int64(report.LinkID), report.Reason, report.Reporter, time.Now().UTC())
+	if err != nil {
+		if code, _ := sqliteErrorCode(err); code == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY {
+			return 0, ErrNotFound
+		}
+		return 0, fmt.Errorf("failed to save report for id %d: %w", report.LinkID, err)
+	}
+
+	var open int64
+	err = r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM abuse_reports WHERE url_id = ? AND resolved_at IS NULL`, int64(report.LinkID)).Scan(&open)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reports for id %d: %w", report.LinkID, err)
+	}
+	return open, nil
+}
+
+func (r *SQLiteRepository) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		q := `SELECT ` + reportColumns + ` FROM abuse_reports r JOIN urls u ON u.id = r.url_id
+			WHERE r.resolved_at IS NULL ORDER BY r.id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, q, limit)
+	} else {
+		q := `SELECT ` + reportColumns + ` FROM abuse_reports r JOIN urls u ON u.id = r.url_id
+			WHERE r.resolved_at IS NULL AND r.id < ? ORDER BY r.id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, q, int64(cursor), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return scanReports(rows, limit)
+}
+
+func (r *SQLiteRepository) ResolveReports(ctx context.Context, link *Link, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE abuse_reports SET resolved_at = ? WHERE url_id = ? AND resolved_at IS NULL`, now.UTC(), int64(link.ID))
+	if err != nil {
+		return fmt.Errorf("failed to resolve reports for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) AddAudit(ctx context.Context, entry *AuditEntry) error {
+	err := r.db.QueryRowContext(ctx, `INSERT INTO audit_log (actor, action, code, detail, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		entry.Actor, entry.Action, entry.Code, entry.Detail, entry.CreatedAt.UTC()).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save audit entry: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error) {
+	var args []any
+	query := auditQuery(f, cursor, limit, func(v any) string {
+		args = append(args, v)
+		return "?"
+	})
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	return scanAuditEntries(rows, limit)
+}
+
+func (r *SQLiteRepository) AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO link_history (url_id, actor, old_url, new_url, created_at) VALUES (?, ?, ?, ?, ?)`,
+		int64(link.ID), change.Actor, change.OldURL, change.NewURL, change.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to add destination change for id %d: %w", link.ID, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read destination change id: %w", err)
+	}
+	change.ID = uint64(id)
+	return nil
+}
+
+func (r *SQLiteRepository) ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+linkChangeColumns+` FROM link_history WHERE url_id = ?1 AND (?2 = 0 OR id < ?2) ORDER BY id DESC LIMIT ?3`,
+		int64(link.ID), int64(cursor), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination changes for id %d: %w", link.ID, err)
+	}
+	return scanLinkChanges(rows)
+}
+
+func (r *SQLiteRepository) GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error) {
+	var c LinkChange
+	err := r.db.QueryRowContext(ctx, `SELECT `+linkChangeColumns+` FROM link_history WHERE id = ? AND url_id = ?`, int64(id), int64(link.ID)).
+		Scan(&c.ID, &c.Actor, &c.OldURL, &c.NewURL, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination change %d: %w", id, err)
+	}
+	return &c, nil
+}
+
+func (r *SQLiteRepository) CreateUser(ctx context.Context, user *User) error {
+	user.CreatedAt = time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `INSERT INTO users (email, password_hash, plan, role, created_at) VALUES (?, ?, ?, ?, ?)`,
+		user.Email, user.PasswordHash, user.Plan, user.Role, user.CreatedAt)
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	user.ID = uint64(id)
+	return nil
+}
+
+func (r *SQLiteRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := scanUser(r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users u WHERE u.email = ?`, email))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *SQLiteRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `INSERT INTO api_keys (user_id, key_hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		int64(key.UserID), key.KeyHash, strings.Join(key.Scopes, ","), key.ExpiresAt, now)
+	if err != nil {
+		return fmt.Errorf("failed to create api key for user %d: %w", key.UserID, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to create api key for user %d: %w", key.UserID, err)
+	}
+	key.ID, key.CreatedAt = uint64(id), now
+	return nil
+}
+
+func (r *SQLiteRepository) GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	query := `SELECT ` + userColumns + `, ` + apiKeyColumns + ` FROM users u JOIN api_keys k ON k.user_id = u.id WHERE k.key_hash = ?`
+	user, key, err := scanUserAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user by api key: %w", err)
+	}
+	return user, key, nil
+}
+
+func (r *SQLiteRepository) ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys k WHERE k.user_id = ? ORDER BY k.id`, int64(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return scanAPIKeys(rows)
+}
+
+func (r *SQLiteRepository) TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE key_hash = ?`, at, keyHash); err != nil {
+		return fmt.Errorf("failed to touch api key: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE api_keys SET expires_at = ? WHERE key_hash = ?`, at, keyHash)
+	if err != nil {
+		return fmt.Errorf("failed to expire api key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) DeleteAPIKey(ctx context.Context, keyHash string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM api_keys WHERE key_hash = ?`, keyHash); err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO user_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		issuer, subject, int64(userID), time.Now().UTC())
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create identity for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) LookupIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u JOIN user_identities i ON i.user_id = u.id WHERE i.issuer = ? AND i.subject = ?`
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, issuer, subject))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by identity: %w", err)
+	}
+	return user, nil
+}
+
+func (r *SQLiteRepository) SetUserPlan(ctx context.Context, userID uint64, plan string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET plan = ? WHERE id = ?`, plan, int64(userID))
+	if err != nil {
+		return fmt.Errorf("failed to set plan for user %d: %w", userID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) SetUserRole(ctx context.Context, userID uint64, role string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, role, int64(userID))
+	if err != nil {
+		return fmt.Errorf("failed to set role for user %d: %w", userID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+	query := `SELECT
+		COALESCE(SUM(CASE WHEN deleted_at IS NULL THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN created_at > ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN custom_alias AND deleted_at IS NULL THEN 1 ELSE 0 END), 0)
+	FROM urls WHERE owner_id = ?`
+	var u Usage
+	if err := r.db.QueryRowContext(ctx, query, since.UTC(), int64(ownerID)).Scan(&u.Total, &u.Recent, &u.CustomAliases); err != nil {
+		return nil, fmt.Errorf("failed to count urls for owner %d: %w", ownerID, err)
+	}
+	return &u, nil
+}
+
+func (r *SQLiteRepository) IncrementClicks(ctx context.Context, link *Link) (int64, error) {
+	var n int64
+	query := `UPDATE urls SET click_count = click_count + 1 WHERE id = ? RETURNING click_count`
+	err := r.db.QueryRowContext(ctx, query, int64(link.ID)).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment clicks for id %d: %w", link.ID, err)
+	}
+	return n, nil
+}
+
+func (r *SQLiteRepository) Consume(ctx context.Context, link *Link, at time.Time) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE urls SET consumed_at = ? WHERE id = ? AND consumed_at IS NULL AND deleted_at IS NULL`, at.UTC(), int64(link.ID))
+	if err != nil {
+		return false, fmt.Errorf("failed to consume url %d: %w", link.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume url %d: %w", link.ID, err)
+	}
+	if n == 0 {
+		if _, err := r.Get(ctx, link.ID); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	link.ConsumedAt = &at
+	return true, nil
+}
+
+// FlushClicks is a no-op: IncrementClicks writes through.
+func (r *SQLiteRepository) FlushClicks(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// RecordClick inserts a raw click row, which RollupClicks later folds into
+// the rollups.
+func (r *SQLiteRepository) RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO clicks (url_id, clicked_at, referrer, utm_source, utm_medium, utm_campaign) VALUES (?, ?, ?, ?, ?, ?)`,
+		int64(link.ID), at.UTC(), src.Referrer, src.UTM.Source, src.UTM.Medium, src.UTM.Campaign); err != nil {
+		return fmt.Errorf("failed to record click for id %d: %w", link.ID, err)
+	}
+	return nil
+}
+
+// RollupClicks folds raw click rows into the rollups and deletes them, one
+// batch per transaction.
+func (r *SQLiteRepository) RollupClicks(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.rollupClickBatch(ctx)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+func (r *SQLiteRepository) rollupClickBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, url_id, clicked_at, referrer, utm_source, utm_medium, utm_campaign FROM clicks ORDER BY id LIMIT ?`, rollupBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read clicks: %w", err)
+	}
+	var clicks []clickCount
+	var lastID int64
+	for rows.Next() {
+		var linkID int64
+		var at time.Time
+		var src ClickSource
+		if err := rows.Scan(&lastID, &linkID, &at, &src.Referrer, &src.UTM.Source, &src.UTM.Medium, &src.UTM.Campaign); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan click: %w", err)
+		}
+		clicks = append(clicks, clickCounts(uint64(linkID), at, src)...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate clicks: %w", err)
+	}
+	if len(clicks) == 0 {
+		return 0, nil
+	}
+
+	n, err := addClickCounts(ctx, tx, clicks, sqliteClickUpsert)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM clicks WHERE id <= ?`, lastID); err != nil {
+		return 0, fmt.Errorf("failed to delete rolled up clicks: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit click rollup: %w", err)
+	}
+	return n, nil
+}
+
+func (r *SQLiteRepository) ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	query := fmt.Sprintf(`SELECT bucket, clicks FROM %s WHERE url_id = ? AND bucket >= ? AND bucket < ? ORDER BY bucket`, rollupTables[granularity])
+	rows, err := r.db.QueryContext(ctx, query, int64(link.ID), from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click series for id %d: %w", link.ID, err)
+	}
+	return scanClickBuckets(rows)
+}
+
+func (r *SQLiteRepository) ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT dimension, value, SUM(clicks) AS total FROM click_sources
+		WHERE url_id = ? AND bucket >= ? AND bucket < ?
+		GROUP BY dimension, value ORDER BY dimension, total DESC, value`, int64(link.ID), from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get click sources for id %d: %w", link.ID, err)
+	}
+	return scanSourceCounts(rows)
+}
+
+func (r *SQLiteRepository) PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error) {
+	return purgeClickRows(ctx, r.db, granularity, "rowid", func(int) string { return "?" }, before.UTC())
+}
+
+func (r *SQLiteRepository) DeleteClicks(ctx context.Context, link *Link) (int, error) {
+	return deleteClickRows(ctx, r.db, "?", link)
+}
+
+func (r *SQLiteRepository) TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error) {
+	return queryTopStats(ctx, r.db, rollupTables[granularity], func(int) string { return "?" }, from.UTC(), to.UTC(), limit)
+}
+
+func (r *SQLiteRepository) CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error) {
+	return queryCampaignStats(ctx, r.db, rollupTables[granularity], func(int) string { return "?" }, campaignID, from.UTC(), to.UTC(), limit)
+}
+
+func (r *SQLiteRepository) CreateCampaign(ctx context.Context, c *Campaign) error {
+	c.CreatedAt = time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `INSERT INTO campaigns (owner_id, name, created_at) VALUES (?, ?, ?)`,
+		int64(c.OwnerID), c.Name, c.CreatedAt)
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create campaign: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read campaign id: %w", err)
+	}
+	c.ID = uint64(id)
+	return nil
+}
+
+func (r *SQLiteRepository) GetCampaign(ctx context.Context, id uint64) (*Campaign, error) {
+	var c Campaign
+	err := r.db.QueryRowContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE id = ?`, int64(id)).
+		Scan(&c.ID, &c.OwnerID, &c.Name, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign %d: %w", id, err)
+	}
+	return &c, nil
+}
+
+func (r *SQLiteRepository) ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+campaignColumns+` FROM campaigns WHERE owner_id = ? ORDER BY id`, int64(ownerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns for owner %d: %w", ownerID, err)
+	}
+	return scanCampaigns(rows)
+}
+
+func (r *SQLiteRepository) UpdateCampaign(ctx context.Context, c *Campaign) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE campaigns SET name = ? WHERE id = ?`, c.Name, int64(c.ID))
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update campaign %d: %w", c.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) DeleteCampaign(ctx context.Context, c *Campaign) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM campaigns WHERE id = ?`, int64(c.ID))
+	if err != nil {
+		return fmt.Errorf("failed to delete campaign %d: %w", c.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE campaign_id = ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(campaignID), limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE campaign_id = ? AND id < ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(campaignID), int64(cursor), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls for campaign %d: %w", campaignID, err)
+	}
+	return scanLinks(rows, limit)
+}
+
+func (r *SQLiteRepository) CreateOrg(ctx context.Context, o *Org, creatorID uint64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	o.CreatedAt = time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `INSERT INTO orgs (name, created_at) VALUES (?, ?)`, o.Name, o.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read organization id: %w", err)
+	}
+	o.ID = uint64(id)
+	if _, err := tx.ExecContext(ctx, `INSERT INTO org_members (org_id, user_id, created_at) VALUES (?, ?, ?)`, id, int64(creatorID), o.CreatedAt); err != nil {
+		return fmt.Errorf("failed to add member to organization %d: %w", o.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit organization %d: %w", o.ID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) GetOrg(ctx context.Context, id uint64) (*Org, error) {
+	var o Org
+	err := r.db.QueryRowContext(ctx, `SELECT `+orgColumns+` FROM orgs o WHERE o.id = ?`, int64(id)).Scan(&o.ID, &o.Name, &o.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization %d: %w", id, err)
+	}
+	return &o, nil
+}
+
+func (r *SQLiteRepository) ListOrgs(ctx context.Context, userID uint64) ([]*Org, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+orgColumns+` FROM orgs o JOIN org_members m ON m.org_id = o.id WHERE m.user_id = ? ORDER BY o.id`, int64(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations of user %d: %w", userID, err)
+	}
+	return scanOrgs(rows)
+}
+
+func (r *SQLiteRepository) ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users u JOIN org_members m ON m.user_id = u.id WHERE m.org_id = ? ORDER BY m.created_at, u.id`, int64(orgID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of organization %d: %w", orgID, err)
+	}
+	return scanUsers(rows)
+}
+
+func (r *SQLiteRepository) IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error) {
+	var member bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM org_members WHERE org_id = ? AND user_id = ?)`, int64(orgID), int64(userID)).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("failed to check membership in organization %d: %w", orgID, err)
+	}
+	return member, nil
+}
+
+func (r *SQLiteRepository) AddOrgMember(ctx context.Context, orgID, userID uint64) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO org_members (org_id, user_id, created_at) VALUES (?, ?, ?)`, int64(orgID), int64(userID), time.Now().UTC())
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add member to organization %d: %w", orgID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) RemoveOrgMember(ctx context.Context, orgID, userID uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM org_members WHERE org_id = ? AND user_id = ?`, int64(orgID), int64(userID))
+	if err != nil {
+		return fmt.Errorf("failed to remove member from organization %d: %w", orgID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) AddInvitation(ctx context.Context, inv *Invitation) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO org_invitations (token_hash, org_id, email, inviter_id, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		inv.TokenHash, int64(inv.OrgID), inv.Email, nullID(inv.InviterID), inv.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to create invitation to organization %d: %w", inv.OrgID, err)
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	inv := Invitation{TokenHash: tokenHash}
+	var inviterID sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `SELECT org_id, email, inviter_id, expires_at FROM org_invitations WHERE token_hash = ?`, tokenHash).
+		Scan(&inv.OrgID, &inv.Email, &inviterID, &inv.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	inv.InviterID = uint64(inviterID.Int64)
+	return &inv, nil
+}
+
+func (r *SQLiteRepository) UseInvitation(ctx context.Context, tokenHash string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM org_invitations WHERE token_hash = ?`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error) {
+	var rows *sql.Rows
+	var err error
+	if cursor == 0 {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE org_id = ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(orgID), limit)
+	} else {
+		query := `SELECT ` + linkColumns + ` FROM urls WHERE org_id = ? AND id < ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ?`
+		rows, err = r.db.QueryContext(ctx, query, int64(orgID), int64(cursor), limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list urls for organization %d: %w", orgID, err)
+	}
+	return scanLinks(rows, limit)
+}
+
+func (r *SQLiteRepository) CreateDomain(ctx context.Context, d *Domain) error {
+	d.CreatedAt = time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `INSERT INTO domains (owner_id, host, token, created_at) VALUES (?, ?, ?, ?)`,
+		int64(d.OwnerID), d.Host, d.Token, d.CreatedAt)
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create domain: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read domain id: %w", err)
+	}
+	d.ID = uint64(id)
+	return nil
+}
+
+func (r *SQLiteRepository) GetDomain(ctx context.Context, id uint64) (*Domain, error) {
+	d, err := scanDomain(r.db.QueryRowContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE id = ?`, int64(id)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %d: %w", id, err)
+	}
+	return d, nil
+}
+
+func (r *SQLiteRepository) GetDomainByHost(ctx context.Context, host string) (*Domain, error) {
+	d, err := scanDomain(r.db.QueryRowContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE host = ? AND verified_at IS NOT NULL`, host))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain %s: %w", host, err)
+	}
+	return d, nil
+}
+
+func (r *SQLiteRepository) ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE owner_id = ? ORDER BY id`, int64(ownerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains for owner %d: %w", ownerID, err)
+	}
+	return scanDomains(rows)
+}
+
+func (r *SQLiteRepository) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	rule.CreatedAt = time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `INSERT INTO domain_rules (pattern, action, reason, created_at) VALUES (?, ?, ?, ?)`,
+		rule.Pattern, rule.Action, rule.Reason, rule.CreatedAt)
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add domain rule: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read domain rule id: %w", err)
+	}
+	rule.ID = uint64(id)
+	return nil
+}
+
+func (r *SQLiteRepository) ListDomainRules(ctx context.Context) ([]*DomainRule, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+domainRuleColumns+` FROM domain_rules ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain rules: %w", err)
+	}
+	return scanDomainRules(rows)
+}
+
+func (r *SQLiteRepository) DeleteDomainRule(ctx context.Context, id uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM domain_rules WHERE id = ?`, int64(id))
+	if err != nil {
+		return fmt.Errorf("failed to delete domain rule %d: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	res.CreatedAt = time.Now().UTC()
+	result, err := r.db.ExecContext(ctx, `INSERT INTO alias_reservations (pattern, prefix, org_id, created_at) VALUES (?, ?, ?, ?)`,
+		res.Pattern, res.Prefix, nullID(res.OrgID), res.CreatedAt)
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add alias reservation: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read alias reservation id: %w", err)
+	}
+	res.ID = uint64(id)
+	return nil
+}
+
+func (r *SQLiteRepository) ListAliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+aliasReservationColumns+` FROM alias_reservations ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alias reservations: %w", err)
+	}
+	return scanAliasReservations(rows)
+}
+
+func (r *SQLiteRepository) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM alias_reservations WHERE id = ?`, int64(id))
+	if err != nil {
+		return fmt.Errorf("failed to delete alias reservation %d: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) UpdateDomain(ctx context.Context, d *Domain) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE domains SET verified_at = ? WHERE id = ?`, utcTime(d.VerifiedAt), int64(d.ID))
+	if isSQLiteConstraint(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update domain %d: %w", d.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) DeleteDomain(ctx context.Context, d *Domain) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE urls SET domain = NULL WHERE owner_id = ? AND domain = ?`, int64(d.OwnerID), d.Host); err != nil {
+		return fmt.Errorf("failed to unbind domain %d: %w", d.ID, err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM domains WHERE id = ?`, int64(d.ID))
+	if err != nil {
+		return fmt.Errorf("failed to delete domain %d: %w", d.ID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit domain deletion %d: %w", d.ID, err)
+	}
+	return nil
+}
+
+// sqliteClickUpsert is the SQLite version of postgresClickUpsert.
+func sqliteClickUpsert(table string, keys ...string) string {
+	var columns, values string
+	for i, key := range keys {
+		columns += ", " + key
+		values += fmt.Sprintf(", ?%d", i+4)
+	}
+	return fmt.Sprintf(`INSERT INTO %[1]s (url_id, bucket, clicks%[2]s) SELECT id, ?2, ?3%[3]s FROM urls WHERE id = ?1
+		ON CONFLICT (url_id, bucket%[2]s) DO UPDATE SET clicks = clicks + excluded.clicks`, table, columns, values)
+}
+
+func (r *SQLiteRepository) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM urls WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired urls: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged urls: %w", err)
+	}
+	return int(n), nil
+}
+
+func (r *SQLiteRepository) Close() error {
+	if err := r.db.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	return nil
+}
+
+// sqliteColumns lists columns added to tables after their initial release, so
+// that databases created by older versions are upgraded on open. SQLite has no
+// ADD COLUMN IF NOT EXISTS, hence the explicit check in migrateSQLite.
+var sqliteColumns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{"urls", "max_clicks", "INTEGER"},
+	{"urls", "click_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"urls", "status", "TEXT NOT NULL DEFAULT 'active'"},
+	{"urls", "owner_id", "INTEGER REFERENCES users (id) ON DELETE SET NULL"},
+	{"urls", "custom_alias", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"urls", "rules", "TEXT"},
+	{"urls", "experiment", "TEXT"},
+	{"urls", "active_from", "TIMESTAMP"},
+	{"urls", "active_until", "TIMESTAMP"},
+	{"urls", "deleted_at", "TIMESTAMP"},
+	{"urls", "page", "TEXT"},
+	{"urls", "campaign_id", "INTEGER REFERENCES campaigns (id) ON DELETE SET NULL"},
+	{"urls", "domain", "TEXT"},
+	{"urls", "analytics_disabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"urls", "org_id", "INTEGER REFERENCES orgs (id) ON DELETE SET NULL"},
+	{"urls", "metadata", "TEXT"},
+	{"urls", "query_passthrough", "TEXT"},
+	{"urls", "mask", "TEXT"},
+	{"urls", "burn_after_reading", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"urls", "consumed_at", "TIMESTAMP"},
+	{"urls", "access", "TEXT"},
+	{"users", "plan", "TEXT NOT NULL DEFAULT 'free'"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'editor'"},
+	{"api_keys", "scopes", "TEXT NOT NULL DEFAULT 'shorten,read,delete'"},
+	{"api_keys", "expires_at", "TIMESTAMP"},
+	{"api_keys", "last_used_at", "TIMESTAMP"},
+	{"clicks", "referrer", "TEXT NOT NULL DEFAULT ''"},
+	{"clicks", "utm_source", "TEXT NOT NULL DEFAULT ''"},
+	{"clicks", "utm_medium", "TEXT NOT NULL DEFAULT ''"},
+	{"clicks", "utm_campaign", "TEXT NOT NULL DEFAULT ''"},
+}
+
+func migrateSQLite(db *sql.DB) error {
+	existing := make(map[string]map[string]bool)
+	for _, col := range sqliteColumns {
+		if existing[col.table] == nil {
+			names, err := sqliteTableColumns(db, col.table)
+			if err != nil {
+				return err
+			}
+			existing[col.table] = names
+		}
+		if existing[col.table][col.name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + col.table + ` ADD COLUMN ` + col.name + ` ` + col.definition); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", col.table, col.name, err)
+		}
+	}
+
+	// Created here rather than in sqliteSchema because owner_id and org_id
+	// may only exist after the migration above.
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_urls_owner ON urls (owner_id, id) WHERE owner_id IS NOT NULL`); err != nil {
+		return fmt.Errorf("failed to create owner index: %w", err)
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_urls_org ON urls (org_id, id) WHERE org_id IS NOT NULL`); err != nil {
+		return fmt.Errorf("failed to create organization index: %w", err)
+	}
+	return nil
+}
+
+// sqliteTableColumns returns the names of the columns of table.
+func sqliteTableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect sqlite schema: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to inspect sqlite schema: %w", err)
+		}
+		names[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to inspect sqlite schema: %w", err)
+	}
+	return names, nil
+}
+
+// utcTime converts t to UTC for storage, preserving nil.
+func utcTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// sqliteErrorCode returns the extended SQLite result code of err, if any.
+func sqliteErrorCode(err error) (int, bool) {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code(), true
+	}
+	return 0, false
+}
+
+// isSQLiteConstraint reports whether err is a primary key or unique constraint violation.
+func isSQLiteConstraint(err error) bool {
+	code, ok := sqliteErrorCode(err)
+	return ok && (code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE)
+}
@@ -0,0 +1,1034 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteRepository(t *testing.T) *SQLiteRepository {
+	t.Helper()
+
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "shortener.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() unexpected error = %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestSQLiteRepository_SaveAndGet(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(time.Hour)
+	id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/a", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if id != 1 {
+		t.Errorf("Save() id = %d, want 1", id)
+	}
+
+	got, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if got.OriginalURL != "https://example.com/a" {
+		t.Errorf("Get() OriginalURL = %s", got.OriginalURL)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Error("Get() CreatedAt not populated")
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Get() ExpiresAt = %v, want %v", got.ExpiresAt, expiresAt)
+	}
+
+	if _, err := repo.Get(ctx, 999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() missing id error = %v, want ErrNotFound", err)
+	}
+
+	masked := &Link{OriginalURL: "https://example.com/b", QueryPassthrough: QueryPassthroughMerge, Mask: MaskFrame}
+	if masked.ID, err = repo.Save(ctx, masked); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if got, err := repo.Get(ctx, masked.ID); err != nil || got.QueryPassthrough != QueryPassthroughMerge || got.Mask != MaskFrame {
+		t.Errorf("Get() = %+v, %v, want merge passthrough and frame mask", got, err)
+	}
+	masked.QueryPassthrough, masked.Mask = QueryPassthroughOff, MaskOff
+	if err := repo.Update(ctx, masked); err != nil {
+		t.Fatalf("Update() unexpected error = %v", err)
+	}
+	if got, err := repo.Get(ctx, masked.ID); err != nil || got.QueryPassthrough != QueryPassthroughOff || got.Mask != MaskOff {
+		t.Errorf("Get() after Update() = %+v, %v, want neither", got, err)
+	}
+}
+
+func TestSQLiteRepository_PinnedIDsAndCodes(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	// Pin ID 2 ahead of the sequence
+	if _, err := repo.Save(ctx, &Link{ID: 2, OriginalURL: "https://example.com/pinned"}); err != nil {
+		t.Fatalf("Save() pinned unexpected error = %v", err)
+	}
+	if _, err := repo.Save(ctx, &Link{ID: 2, OriginalURL: "https://example.com/dup"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() duplicate pinned id error = %v, want ErrConflict", err)
+	}
+
+	// Generated IDs skip the pinned one
+	var ids []uint64
+	for i := 0; i < 3; i++ {
+		id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/gen"})
+		if err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != 1 || ids[1] != 3 || ids[2] != 4 {
+		t.Errorf("generated ids = %v, want [1 3 4]", ids)
+	}
+
+	// Free-form codes
+	if _, err := repo.Save(ctx, &Link{Code: "spring-sale", OriginalURL: "https://example.com/sale"}); err != nil {
+		t.Fatalf("Save() with code unexpected error = %v", err)
+	}
+	if _, err := repo.Save(ctx, &Link{Code: "spring-sale", OriginalURL: "https://example.com/other"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Save() duplicate code error = %v, want ErrConflict", err)
+	}
+	got, err := repo.GetByCode(ctx, "spring-sale")
+	if err != nil {
+		t.Fatalf("GetByCode() unexpected error = %v", err)
+	}
+	if got.OriginalURL != "https://example.com/sale" || got.ShortCode() != "spring-sale" {
+		t.Errorf("GetByCode() = %+v", got)
+	}
+	if _, err := repo.GetByCode(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByCode() missing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_List(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"}); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+
+	links, err := repo.List(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("List() unexpected error = %v", err)
+	}
+	if len(links) != 2 || links[0].ID != 3 || links[1].ID != 2 {
+		t.Errorf("List() first page = %+v, want ids [3 2]", links)
+	}
+
+	links, err = repo.List(ctx, 2, 2)
+	if err != nil {
+		t.Fatalf("List() unexpected error = %v", err)
+	}
+	if len(links) != 1 || links[0].ID != 1 {
+		t.Errorf("List() second page = %+v, want ids [1]", links)
+	}
+}
+
+func TestSQLiteRepository_PurgeExpired(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	expiredID, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/old", ExpiresAt: &past})
+	if err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	liveID, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/live", ExpiresAt: &future})
+	if err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	n, err := repo.PurgeExpired(ctx, now)
+	if err != nil {
+		t.Fatalf("PurgeExpired() unexpected error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("PurgeExpired() = %d, want 1", n)
+	}
+	if _, err := repo.Get(ctx, expiredID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired link still present: err = %v", err)
+	}
+	if _, err := repo.Get(ctx, liveID); err != nil {
+		t.Errorf("live link was purged: err = %v", err)
+	}
+}
+
+func TestSQLiteRepository_IncrementClicks(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com", MaxClicks: 2}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		n, err := repo.IncrementClicks(ctx, link)
+		if err != nil {
+			t.Fatalf("IncrementClicks() unexpected error = %v", err)
+		}
+		if n != want {
+			t.Errorf("IncrementClicks() = %d, want %d", n, want)
+		}
+	}
+
+	got, err := repo.Get(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if got.MaxClicks != 2 || got.Clicks != 3 {
+		t.Errorf("Get() MaxClicks/Clicks = %d/%d, want 2/3", got.MaxClicks, got.Clicks)
+	}
+
+	if _, err := repo.IncrementClicks(ctx, &Link{ID: 999}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("IncrementClicks() missing link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_Consume(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com/secret", BurnAfterReading: true}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	at := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, false} {
+		ok, err := repo.Consume(ctx, link, at.Add(time.Duration(i)*time.Minute))
+		if err != nil || ok != want {
+			t.Errorf("Consume() #%d = %t, %v, want %t", i+1, ok, err, want)
+		}
+	}
+
+	got, err := repo.Get(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if !got.BurnAfterReading || got.ConsumedAt == nil || !got.ConsumedAt.Equal(at) || !got.IsConsumed() {
+		t.Errorf("Get() = %+v, want consumed at %v", got, at)
+	}
+
+	if _, err := repo.Consume(ctx, &Link{ID: 999}, at); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Consume() missing link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNewSQLiteRepository_MigratesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// Database created before click limits existed
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE urls (id INTEGER PRIMARY KEY, code TEXT UNIQUE, original_url TEXT NOT NULL, created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP)`); err != nil {
+		t.Fatalf("failed to create old schema: %v", err)
+	}
+	db.Close()
+
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository() unexpected error = %v", err)
+	}
+	defer repo.Close()
+
+	if _, err := repo.Save(context.Background(), &Link{OriginalURL: "https://example.com", MaxClicks: 1}); err != nil {
+		t.Errorf("Save() after migration unexpected error = %v", err)
+	}
+}
+
+func TestSQLiteRepository_SearchStatusAndStats(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	links := []*Link{
+		{OriginalURL: "https://Example.com/sale"},
+		{OriginalURL: "https://other.test/100%_off"},
+		{OriginalURL: "https://example.com/old", ExpiresAt: &past},
+	}
+	for _, link := range links {
+		if _, err := repo.Save(ctx, link); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+
+	found, err := repo.Search(ctx, "EXAMPLE.com", 0, 10)
+	if err != nil {
+		t.Fatalf("Search() unexpected error = %v", err)
+	}
+	if len(found) != 2 || found[0].ID != 3 || found[1].ID != 1 {
+		t.Errorf("Search() = %+v, want ids [3 1]", found)
+	}
+
+	// LIKE wildcards in the query match literally
+	found, err = repo.Search(ctx, "%_", 0, 10)
+	if err != nil {
+		t.Fatalf("Search() unexpected error = %v", err)
+	}
+	if len(found) != 1 || found[0].ID != 2 {
+		t.Errorf("Search(%%_) = %+v, want ids [2]", found)
+	}
+
+	if err := repo.SetStatus(ctx, links[0], StatusDisabled); err != nil {
+		t.Fatalf("SetStatus() unexpected error = %v", err)
+	}
+	got, err := repo.Get(ctx, links[0].ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if !got.IsDisabled() {
+		t.Errorf("Get() status = %q, want disabled", got.Status)
+	}
+	if err := repo.SetStatus(ctx, &Link{ID: 999}, StatusDisabled); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetStatus() missing link error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := repo.IncrementClicks(ctx, links[1]); err != nil {
+		t.Fatalf("IncrementClicks() unexpected error = %v", err)
+	}
+
+	stats, err := repo.Stats(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("Stats() unexpected error = %v", err)
+	}
+	want := Stats{Total: 3, Active: 1, Disabled: 1, Expired: 1, Clicks: 1}
+	if *stats != want {
+		t.Errorf("Stats() = %+v, want %+v", *stats, want)
+	}
+}
+
+func TestSQLiteRepository_Reports(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	link := &Link{Code: "phish", OriginalURL: "https://example.com/login"}
+	expired := &Link{OriginalURL: "https://example.com/old", ExpiresAt: &past}
+	for _, l := range []*Link{link, expired} {
+		if _, err := repo.Save(ctx, l); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+
+	for i, reporter := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		open, err := repo.AddReport(ctx, &Report{LinkID: link.ID, Reason: "phishing", Reporter: reporter})
+		if err != nil {
+			t.Fatalf("AddReport() unexpected error = %v", err)
+		}
+		// The duplicate from 192.0.2.1 is not counted
+		if want := []int64{1, 1, 2}[i]; open != want {
+			t.Errorf("AddReport() #%d open = %d, want %d", i, open, want)
+		}
+	}
+	if _, err := repo.AddReport(ctx, &Report{LinkID: expired.ID, Reporter: "192.0.2.1"}); err != nil {
+		t.Fatalf("AddReport() unexpected error = %v", err)
+	}
+	if _, err := repo.AddReport(ctx, &Report{LinkID: 999, Reporter: "192.0.2.1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddReport() missing link error = %v, want ErrNotFound", err)
+	}
+
+	reports, err := repo.ListReports(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListReports() unexpected error = %v", err)
+	}
+	if len(reports) != 3 || reports[1].ShortCode() != "phish" || reports[1].Reporter != "192.0.2.2" || reports[1].CreatedAt.IsZero() {
+		t.Errorf("ListReports() = %+v", reports)
+	}
+
+	// Reports are removed together with purged links
+	if _, err := repo.PurgeExpired(ctx, time.Now()); err != nil {
+		t.Fatalf("PurgeExpired() unexpected error = %v", err)
+	}
+	if err := repo.ResolveReports(ctx, link, time.Now()); err != nil {
+		t.Fatalf("ResolveReports() unexpected error = %v", err)
+	}
+	if reports, _ := repo.ListReports(ctx, 0, 10); len(reports) != 0 {
+		t.Errorf("ListReports() after resolve = %+v, want none", reports)
+	}
+
+	// Resolved reports no longer block the same reporter
+	if open, err := repo.AddReport(ctx, &Report{LinkID: link.ID, Reporter: "192.0.2.1"}); err != nil || open != 1 {
+		t.Errorf("AddReport() after resolve = %d, %v, want 1, nil", open, err)
+	}
+}
+
+func TestSQLiteRepository_DomainRules(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	block := &DomainRule{Pattern: "spam.example", Action: DomainBlock, Reason: "link farm"}
+	allow := &DomainRule{Pattern: "*.example.com", Action: DomainAllow}
+	for _, rule := range []*DomainRule{block, allow} {
+		if err := repo.AddDomainRule(ctx, rule); err != nil {
+			t.Fatalf("AddDomainRule() unexpected error = %v", err)
+		}
+	}
+	if err := repo.AddDomainRule(ctx, &DomainRule{Pattern: "spam.example", Action: DomainAllow}); !errors.Is(err, ErrConflict) {
+		t.Errorf("AddDomainRule() duplicate error = %v, want ErrConflict", err)
+	}
+
+	rules, err := repo.ListDomainRules(ctx)
+	if err != nil {
+		t.Fatalf("ListDomainRules() unexpected error = %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != block.ID || rules[0].Reason != "link farm" || rules[1].Action != DomainAllow || rules[1].CreatedAt.IsZero() {
+		t.Errorf("ListDomainRules() = %+v", rules)
+	}
+
+	if err := repo.DeleteDomainRule(ctx, block.ID); err != nil {
+		t.Fatalf("DeleteDomainRule() unexpected error = %v", err)
+	}
+	if err := repo.DeleteDomainRule(ctx, block.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDomainRule() twice error = %v, want ErrNotFound", err)
+	}
+	if rules, _ := repo.ListDomainRules(ctx); len(rules) != 1 || rules[0].ID != allow.ID {
+		t.Errorf("ListDomainRules() after delete = %+v", rules)
+	}
+}
+
+func TestSQLiteRepository_SaveAlias(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	user := &User{Email: "alice@example.com", PasswordHash: "x"}
+	if err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser() unexpected error = %v", err)
+	}
+	org := &Org{Name: "Acme"}
+	if err := repo.CreateOrg(ctx, org, user.ID); err != nil {
+		t.Fatalf("CreateOrg() unexpected error = %v", err)
+	}
+	for _, res := range []*AliasReservation{{Pattern: "login"}, {Pattern: "acme-", Prefix: true, OrgID: org.ID}} {
+		if err := repo.AddAliasReservation(ctx, res); err != nil {
+			t.Fatalf("AddAliasReservation() unexpected error = %v", err)
+		}
+	}
+	if err := repo.AddAliasReservation(ctx, &AliasReservation{Pattern: "login"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("AddAliasReservation() duplicate error = %v, want ErrConflict", err)
+	}
+
+	tests := []struct {
+		name    string
+		link    *Link
+		wantErr error
+	}{
+		{name: "free alias", link: &Link{Code: "launch-2024"}},
+		{name: "taken alias", link: &Link{Code: "launch-2024"}, wantErr: ErrConflict},
+		{name: "reserved word", link: &Link{Code: "LogIn"}, wantErr: ErrAliasReserved},
+		{name: "prefix of another organization", link: &Link{Code: "acme-sale"}, wantErr: ErrAliasReserved},
+		{name: "prefix of the organization", link: &Link{Code: "acme-sale", OrgID: org.ID}},
+		{name: "alias pinned to an ID", link: &Link{ID: 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.link.OriginalURL = "https://example.com"
+			tt.link.CustomAlias = true
+			id, err := repo.SaveAlias(ctx, tt.link)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SaveAlias() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, err := repo.Get(ctx, id); err != nil {
+				t.Errorf("Get(%d) after SaveAlias() error = %v", id, err)
+			}
+		})
+	}
+
+	reservations, err := repo.ListAliasReservations(ctx)
+	if err != nil || len(reservations) != 2 || !reservations[1].Prefix || reservations[1].OrgID != org.ID {
+		t.Fatalf("ListAliasReservations() = %+v, %v", reservations, err)
+	}
+	if err := repo.DeleteAliasReservation(ctx, reservations[0].ID); err != nil {
+		t.Fatalf("DeleteAliasReservation() unexpected error = %v", err)
+	}
+	if err := repo.DeleteAliasReservation(ctx, reservations[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteAliasReservation() twice error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.SaveAlias(ctx, &Link{Code: "login", OriginalURL: "https://example.com", CustomAlias: true}); err != nil {
+		t.Errorf("SaveAlias() after release error = %v", err)
+	}
+}
+
+func TestSQLiteRepository_LinkChanges(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com/v1"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	other := &Link{OriginalURL: "https://example.com/other"}
+	if _, err := repo.Save(ctx, other); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	var ids []uint64
+	for _, c := range []*LinkChange{
+		{Actor: "user:1", OldURL: "https://example.com/v1", NewURL: "https://example.com/v2", CreatedAt: time.Now()},
+		{Actor: "user:2", OldURL: "https://example.com/v2", NewURL: "https://example.com/v3", CreatedAt: time.Now()},
+	} {
+		if err := repo.AddLinkChange(ctx, link, c); err != nil {
+			t.Fatalf("AddLinkChange() unexpected error = %v", err)
+		}
+		ids = append(ids, c.ID)
+	}
+
+	changes, err := repo.ListLinkChanges(ctx, link, 0, 10)
+	if err != nil || len(changes) != 2 || changes[0].ID != ids[1] || changes[0].Actor != "user:2" || changes[1].NewURL != "https://example.com/v2" {
+		t.Errorf("ListLinkChanges() = %+v, %v, want newest first", changes, err)
+	}
+	if changes, err := repo.ListLinkChanges(ctx, link, ids[1], 10); err != nil || len(changes) != 1 || changes[0].ID != ids[0] {
+		t.Errorf("ListLinkChanges() after cursor = %+v, %v", changes, err)
+	}
+	if changes, err := repo.ListLinkChanges(ctx, other, 0, 10); err != nil || len(changes) != 0 {
+		t.Errorf("ListLinkChanges() of another link = %+v, %v, want none", changes, err)
+	}
+
+	if c, err := repo.GetLinkChange(ctx, link, ids[0]); err != nil || c.OldURL != "https://example.com/v1" {
+		t.Errorf("GetLinkChange() = %+v, %v", c, err)
+	}
+	if _, err := repo.GetLinkChange(ctx, other, ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetLinkChange() of another link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_Metadata(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	var links []*Link
+	for _, u := range []string{"https://example.com/a", "https://example.com/b"} {
+		link := &Link{OriginalURL: u}
+		if _, err := repo.Save(ctx, link); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+		links = append(links, link)
+	}
+	m := &Metadata{Title: "Spring_Sale", Description: "Home page banner", Attributes: []byte(`{"team":"growth"}`)}
+	if err := repo.SetMetadata(ctx, links[0], m); err != nil {
+		t.Fatalf("SetMetadata() unexpected error = %v", err)
+	}
+	got, err := repo.Get(ctx, links[0].ID)
+	if err != nil || got.Metadata == nil || got.Metadata.Title != "Spring_Sale" || string(got.Metadata.Attributes) != `{"team":"growth"}` {
+		t.Errorf("Get() metadata = %+v, %v", got.Metadata, err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "spring_sale", want: 1},
+		{query: "BANNER", want: 1},
+		{query: "e_b", want: 0},
+		{query: "growth", want: 0},
+		{query: "example.com", want: 2},
+	}
+	for _, tt := range tests {
+		if found, err := repo.Search(ctx, tt.query, 0, 10); err != nil || len(found) != tt.want {
+			t.Errorf("Search(%q) = %d links, %v, want %d", tt.query, len(found), err, tt.want)
+		}
+	}
+
+	if err := repo.SetMetadata(ctx, links[0], nil); err != nil {
+		t.Fatalf("SetMetadata(nil) unexpected error = %v", err)
+	}
+	if got, err := repo.Get(ctx, links[0].ID); err != nil || got.Metadata != nil {
+		t.Errorf("Get() after removal metadata = %+v, %v, want none", got.Metadata, err)
+	}
+	if err := repo.SetMetadata(ctx, &Link{ID: 99}, m); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetMetadata() of a missing link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_NextID(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	for want := uint64(1); want <= 2; want++ {
+		id, err := repo.NextID(ctx)
+		if err != nil {
+			t.Fatalf("NextID() unexpected error = %v", err)
+		}
+		if id != want {
+			t.Errorf("NextID() = %d, want %d", id, want)
+		}
+	}
+
+	// Allocated IDs are not handed out again
+	id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if id != 3 {
+		t.Errorf("Save() id = %d, want 3", id)
+	}
+}
+
+func TestSQLiteRepository_ReserveIDs(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	if id, err := repo.NextID(ctx); err != nil || id != 1 {
+		t.Fatalf("NextID() = %d, %v, want 1, nil", id, err)
+	}
+	for _, want := range []uint64{2, 12} {
+		first, err := repo.ReserveIDs(ctx, 10)
+		if err != nil {
+			t.Fatalf("ReserveIDs() unexpected error = %v", err)
+		}
+		if first != want {
+			t.Errorf("ReserveIDs() = %d, want %d", first, want)
+		}
+	}
+
+	// Reserved IDs are not handed out again
+	id, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if id != 22 {
+		t.Errorf("Save() id = %d, want 22", id)
+	}
+}
+
+func TestSQLiteRepository_UsersAndOwnership(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	user := &User{Email: "alice@example.com", PasswordHash: "hash"}
+	if err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser() unexpected error = %v", err)
+	}
+	if user.ID == 0 || user.CreatedAt.IsZero() {
+		t.Errorf("CreateUser() did not populate user: %+v", user)
+	}
+	if err := repo.CreateUser(ctx, &User{Email: "alice@example.com", PasswordHash: "x"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateUser() duplicate email error = %v, want ErrConflict", err)
+	}
+	if got, err := repo.GetUserByEmail(ctx, "alice@example.com"); err != nil || got.ID != user.ID || got.PasswordHash != "hash" {
+		t.Errorf("GetUserByEmail() = %+v, %v", got, err)
+	}
+
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := &APIKey{UserID: user.ID, KeyHash: "keyhash", Scopes: []string{ScopeShorten, ScopeRead}, ExpiresAt: &expiresAt}
+	if err := repo.CreateAPIKey(ctx, key); err != nil {
+		t.Fatalf("CreateAPIKey() unexpected error = %v", err)
+	}
+	got, gotKey, err := repo.GetUserByAPIKey(ctx, "keyhash")
+	if err != nil || got.ID != user.ID {
+		t.Fatalf("GetUserByAPIKey() = %+v, %v", got, err)
+	}
+	if gotKey.ID != key.ID || !slices.Equal(gotKey.Scopes, key.Scopes) || !gotKey.ExpiresAt.Equal(expiresAt) || gotKey.LastUsedAt != nil {
+		t.Errorf("GetUserByAPIKey() key = %+v, want %+v", gotKey, key)
+	}
+	usedAt := expiresAt.Add(-time.Hour)
+	if err := repo.TouchAPIKey(ctx, "keyhash", usedAt); err != nil {
+		t.Fatalf("TouchAPIKey() unexpected error = %v", err)
+	}
+	if err := repo.ExpireAPIKey(ctx, "keyhash", usedAt); err != nil {
+		t.Fatalf("ExpireAPIKey() unexpected error = %v", err)
+	}
+	if err := repo.ExpireAPIKey(ctx, "unknown", usedAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ExpireAPIKey() of an unknown key error = %v, want ErrNotFound", err)
+	}
+	keys, err := repo.ListAPIKeys(ctx, user.ID)
+	if err != nil || len(keys) != 1 || keys[0].KeyHash != "keyhash" || !keys[0].LastUsedAt.Equal(usedAt) || !keys[0].ExpiresAt.Equal(usedAt) {
+		t.Errorf("ListAPIKeys() = %+v, %v", keys, err)
+	}
+	if err := repo.DeleteAPIKey(ctx, "keyhash"); err != nil {
+		t.Fatalf("DeleteAPIKey() unexpected error = %v", err)
+	}
+	if _, _, err := repo.GetUserByAPIKey(ctx, "keyhash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUserByAPIKey() after delete error = %v, want ErrNotFound", err)
+	}
+
+	owned := &Link{OriginalURL: "https://example.com/a", OwnerID: user.ID}
+	if _, err := repo.Save(ctx, owned); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if _, err := repo.Save(ctx, &Link{OriginalURL: "https://example.com/anonymous"}); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	links, err := repo.ListByOwner(ctx, user.ID, 0, 10)
+	if err != nil {
+		t.Fatalf("ListByOwner() unexpected error = %v", err)
+	}
+	if len(links) != 1 || links[0].ID != owned.ID || links[0].OwnerID != user.ID {
+		t.Errorf("ListByOwner() = %+v, want only link %d", links, owned.ID)
+	}
+
+	owned.OriginalURL = "https://example.com/b"
+	if err := repo.Update(ctx, owned); err != nil {
+		t.Fatalf("Update() unexpected error = %v", err)
+	}
+	if got, _ := repo.Get(ctx, owned.ID); got.OriginalURL != "https://example.com/b" {
+		t.Errorf("Get() after update OriginalURL = %q", got.OriginalURL)
+	}
+
+	if err := repo.Delete(ctx, owned); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	if _, err := repo.Get(ctx, owned.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after delete error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(ctx, owned); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() missing link error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Update(ctx, owned); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update() missing link error = %v, want ErrNotFound", err)
+	}
+	if links, _ := repo.ListByOwner(ctx, user.ID, 0, 10); len(links) != 0 {
+		t.Errorf("ListByOwner() after delete = %+v, want none", links)
+	}
+
+	if err := repo.Restore(ctx, &Link{ID: owned.ID}, user.ID+1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() by another owner error = %v, want ErrNotFound", err)
+	}
+	if err := repo.Restore(ctx, &Link{ID: owned.ID}, user.ID); err != nil {
+		t.Fatalf("Restore() unexpected error = %v", err)
+	}
+	if got, err := repo.Get(ctx, owned.ID); err != nil || got.OriginalURL != "https://example.com/b" {
+		t.Errorf("Get() after restore = %+v, %v", got, err)
+	}
+	if err := repo.Restore(ctx, &Link{ID: owned.ID}, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() of live link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_UsageAndPlans(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	user := &User{Email: "alice@example.com", PasswordHash: "hash", Plan: "free"}
+	if err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser() unexpected error = %v", err)
+	}
+	for _, link := range []*Link{
+		{OriginalURL: "https://example.com/a", OwnerID: user.ID},
+		{Code: "spring-sale", OriginalURL: "https://example.com/b", OwnerID: user.ID, CustomAlias: true},
+		{OriginalURL: "https://example.com/anonymous"},
+	} {
+		if _, err := repo.Save(ctx, link); err != nil {
+			t.Fatalf("Save() unexpected error = %v", err)
+		}
+	}
+
+	usage, err := repo.UsageByOwner(ctx, user.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("UsageByOwner() unexpected error = %v", err)
+	}
+	if *usage != (Usage{Total: 2, Recent: 2, CustomAliases: 1}) {
+		t.Errorf("UsageByOwner() = %+v, want 2/2/1", usage)
+	}
+	if usage, _ := repo.UsageByOwner(ctx, user.ID, time.Now().Add(time.Hour)); usage.Recent != 0 {
+		t.Errorf("UsageByOwner() since the future Recent = %d, want 0", usage.Recent)
+	}
+	if got, _ := repo.GetByCode(ctx, "spring-sale"); !got.CustomAlias {
+		t.Error("GetByCode() CustomAlias = false, want true")
+	}
+
+	if err := repo.SetUserPlan(ctx, user.ID, "pro"); err != nil {
+		t.Fatalf("SetUserPlan() unexpected error = %v", err)
+	}
+	if got, _ := repo.GetUserByEmail(ctx, "alice@example.com"); got.Plan != "pro" {
+		t.Errorf("GetUserByEmail() Plan = %q, want pro", got.Plan)
+	}
+	if err := repo.SetUserPlan(ctx, 999, "pro"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetUserPlan() unknown user error = %v, want ErrNotFound", err)
+	}
+
+	if err := repo.SetUserRole(ctx, user.ID, RoleViewer); err != nil {
+		t.Fatalf("SetUserRole() unexpected error = %v", err)
+	}
+	if got, _ := repo.GetUserByEmail(ctx, "alice@example.com"); got.Role != RoleViewer || got.HasRole(RoleEditor) || !got.HasRole(RoleViewer) {
+		t.Errorf("GetUserByEmail() Role = %q, want a viewer", got.Role)
+	}
+	if err := repo.SetUserRole(ctx, 999, RoleAdmin); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetUserRole() unknown user error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_Targets(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{Code: "spring-sale", OriginalURL: "https://example.com/sale"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	targets := []Target{{Country: "US", URL: "https://example.com/us"}, {Country: "JP", URL: "https://example.com/jp"}}
+	if err := repo.SetTargets(ctx, link, targets); err != nil {
+		t.Fatalf("SetTargets() unexpected error = %v", err)
+	}
+	got, err := repo.GetByCode(ctx, "spring-sale")
+	if err != nil {
+		t.Fatalf("GetByCode() unexpected error = %v", err)
+	}
+	want := []Target{{Country: "JP", URL: "https://example.com/jp"}, {Country: "US", URL: "https://example.com/us"}}
+	if !slices.Equal(got.Targets, want) {
+		t.Errorf("GetByCode() Targets = %+v, want %+v", got.Targets, want)
+	}
+
+	// Replacing drops targets that are no longer listed
+	if err := repo.SetTargets(ctx, link, targets[:1]); err != nil {
+		t.Fatalf("SetTargets() unexpected error = %v", err)
+	}
+	if got, _ := repo.Get(ctx, link.ID); len(got.Targets) != 1 || got.Targets[0].Country != "US" {
+		t.Errorf("Get() Targets = %+v, want only US", got.Targets)
+	}
+
+	// Targets go with their link once it is purged
+	expired := time.Now().Add(-time.Minute)
+	link.ExpiresAt = &expired
+	if err := repo.Update(ctx, link); err != nil {
+		t.Fatalf("Update() unexpected error = %v", err)
+	}
+	if _, err := repo.PurgeExpired(ctx, time.Now()); err != nil {
+		t.Fatalf("PurgeExpired() unexpected error = %v", err)
+	}
+	var n int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM link_targets`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("link_targets rows after PurgeExpired() = %d, %v, want 0", n, err)
+	}
+}
+
+func TestSQLiteRepository_Rules(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com/app"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	rules := []Rule{{Device: DeviceIOS, URL: "https://apps.apple.com/app/id1"}, {Device: DeviceAndroid, URL: "https://play.google.com/store"}}
+	if err := repo.SetRules(ctx, link, rules); err != nil {
+		t.Fatalf("SetRules() unexpected error = %v", err)
+	}
+	got, err := repo.Get(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if !slices.Equal(got.Rules, rules) {
+		t.Errorf("Get() Rules = %+v, want %+v", got.Rules, rules)
+	}
+
+	if err := repo.SetRules(ctx, link, nil); err != nil {
+		t.Fatalf("SetRules() unexpected error = %v", err)
+	}
+	if got, _ := repo.Get(ctx, link.ID); got.Rules != nil {
+		t.Errorf("Get() Rules after clearing = %+v, want nil", got.Rules)
+	}
+	if err := repo.SetRules(ctx, &Link{ID: 999}, rules); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetRules() unknown link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_ActiveWindow(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	from := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	until := from.Add(48 * time.Hour)
+	link := &Link{OriginalURL: "https://example.com/launch", ActiveFrom: &from, ActiveUntil: &until}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	got, err := repo.Get(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if got.ActiveFrom == nil || !got.ActiveFrom.Equal(from) {
+		t.Errorf("Get() ActiveFrom = %v, want %v", got.ActiveFrom, from)
+	}
+	if got.ActiveUntil == nil || !got.ActiveUntil.Equal(until) {
+		t.Errorf("Get() ActiveUntil = %v, want %v", got.ActiveUntil, until)
+	}
+}
+
+func TestSQLiteRepository_Experiment(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	experiment := &Experiment{
+		Variants: []Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 2},
+		},
+		Sticky: true,
+	}
+	if err := repo.SetExperiment(ctx, link, experiment); err != nil {
+		t.Fatalf("SetExperiment() unexpected error = %v", err)
+	}
+	got, err := repo.Get(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if got.Experiment == nil || !got.Experiment.Sticky || !slices.Equal(got.Experiment.Variants, experiment.Variants) {
+		t.Errorf("Get() Experiment = %+v, want %+v", got.Experiment, experiment)
+	}
+
+	for _, name := range []string{"a", "b", "b"} {
+		if err := repo.RecordVariant(ctx, link, name); err != nil {
+			t.Fatalf("RecordVariant() unexpected error = %v", err)
+		}
+	}
+	served, err := repo.VariantStats(ctx, link)
+	if err != nil {
+		t.Fatalf("VariantStats() unexpected error = %v", err)
+	}
+	if served["a"] != 1 || served["b"] != 2 || len(served) != 2 {
+		t.Errorf("VariantStats() = %v, want a:1 b:2", served)
+	}
+
+	if err := repo.SetExperiment(ctx, link, nil); err != nil {
+		t.Fatalf("SetExperiment() unexpected error = %v", err)
+	}
+	if got, _ := repo.Get(ctx, link.ID); got.Experiment != nil {
+		t.Errorf("Get() Experiment after removal = %+v, want nil", got.Experiment)
+	}
+	if err := repo.SetExperiment(ctx, &Link{ID: 999}, experiment); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetExperiment() unknown link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_Page(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+
+	page := &PageInfo{Title: "Example", Description: "An example", FaviconURL: "https://example.com/favicon.ico"}
+	if err := repo.SetPage(ctx, link, page); err != nil {
+		t.Fatalf("SetPage() unexpected error = %v", err)
+	}
+	got, err := repo.Get(ctx, link.ID)
+	if err != nil {
+		t.Fatalf("Get() unexpected error = %v", err)
+	}
+	if got.Page == nil || *got.Page != *page {
+		t.Errorf("Get() Page = %+v, want %+v", got.Page, page)
+	}
+
+	if err := repo.SetPage(ctx, link, nil); err != nil {
+		t.Fatalf("SetPage() unexpected error = %v", err)
+	}
+	if got, _ := repo.Get(ctx, link.ID); got.Page != nil {
+		t.Errorf("Get() Page after removal = %+v, want nil", got.Page)
+	}
+	if err := repo.SetPage(ctx, &Link{ID: 999}, page); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetPage() unknown link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteRepository_Audit(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*AuditEntry{
+		{Actor: "user:1", Action: AuditCreate, Code: "b", Detail: "https://example.com", CreatedAt: start},
+		{Actor: "user:1", Action: AuditUpdate, Code: "b", Detail: "https://example.com/new", CreatedAt: start.Add(time.Hour)},
+		{Actor: ActorAdmin, Action: AuditDisable, Code: "b", CreatedAt: start.Add(2 * time.Hour)},
+		{Actor: "user:2", Action: AuditCreate, Code: "c", CreatedAt: start.Add(3 * time.Hour)},
+	}
+	for _, e := range entries {
+		if err := repo.AddAudit(ctx, e); err != nil {
+			t.Fatalf("AddAudit() unexpected error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filter  AuditFilter
+		cursor  uint64
+		wantIDs []uint64
+	}{
+		{name: "all", wantIDs: []uint64{4, 3, 2, 1}},
+		{name: "by actor", filter: AuditFilter{Actor: "user:1"}, wantIDs: []uint64{2, 1}},
+		{name: "by code", filter: AuditFilter{Code: "b"}, wantIDs: []uint64{3, 2, 1}},
+		{name: "time range", filter: AuditFilter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, wantIDs: []uint64{3, 2}},
+		{name: "cursor", filter: AuditFilter{Code: "b"}, cursor: 3, wantIDs: []uint64{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.ListAudit(ctx, tt.filter, tt.cursor, 10)
+			if err != nil {
+				t.Fatalf("ListAudit() unexpected error = %v", err)
+			}
+			ids := make([]uint64, 0, len(got))
+			for _, e := range got {
+				ids = append(ids, e.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("ListAudit() IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	// The log is append-only
+	if _, err := repo.db.Exec(`UPDATE audit_log SET actor = 'someone else'`); err == nil {
+		t.Error("updating audit_log succeeded, want error")
+	}
+	if _, err := repo.db.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Error("deleting from audit_log succeeded, want error")
+	}
+}
+
+func TestSQLiteRepository_Access(t *testing.T) {
+	repo := newTestSQLiteRepository(t)
+	ctx := context.Background()
+
+	link := &Link{OriginalURL: "https://example.com/offer"}
+	if _, err := repo.Save(ctx, link); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	a := &Access{Referrers: []string{"news.example.com"}}
+	if err := repo.SetAccess(ctx, link, a); err != nil {
+		t.Fatalf("SetAccess() unexpected error = %v", err)
+	}
+	if got, err := repo.Get(ctx, link.ID); err != nil || got.Access == nil || !slices.Equal(got.Access.Referrers, a.Referrers) {
+		t.Errorf("Get() access = %+v, %v, want %+v", got.Access, err, a)
+	}
+	if err := repo.SetAccess(ctx, link, nil); err != nil {
+		t.Fatalf("SetAccess(nil) unexpected error = %v", err)
+	}
+	if got, err := repo.Get(ctx, link.ID); err != nil || got.Access != nil {
+		t.Errorf("Get() after removal access = %+v, %v, want none", got.Access, err)
+	}
+	if err := repo.SetAccess(ctx, &Link{ID: 99}, a); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetAccess() of a missing link error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,76 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// stmtCache prepares each distinct query once and reuses the statement, so
+// that PostgreSQL parses and plans hot queries once per connection rather
+// than on every call.
+type stmtCache struct {
+	db    *sql.DB
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// get returns the prepared statement for query, preparing it on first use.
+func (c *stmtCache) get(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt, ok := c.stmts[query]
+	c.mu.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Close closes all prepared statements.
+func (c *stmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for query, stmt := range c.stmts {
+		errs = append(errs, stmt.Close())
+		delete(c.stmts, query)
+	}
+	return errors.Join(errs...)
+}
+
+// queryRow runs query as a cached prepared statement. If the statement
+// cannot be prepared, the query runs unprepared and reports the error.
+func (r *PostgresRedisRepository) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	if r.stmts != nil {
+		if stmt, err := r.stmts.get(ctx, query); err == nil {
+			return stmt.QueryRowContext(ctx, args...)
+		}
+	}
+	return r.db.QueryRowContext(ctx, query, args...)
+}
+
+// query is the multi-row counterpart of queryRow.
+func (r *PostgresRedisRepository) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if r.stmts != nil {
+		if stmt, err := r.stmts.get(ctx, query); err == nil {
+			return stmt.QueryContext(ctx, args...)
+		}
+	}
+	return r.db.QueryContext(ctx, query, args...)
+}
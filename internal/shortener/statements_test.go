@@ -0,0 +1,67 @@
+package shortener
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPostgresRedisRepository_PreparedStatements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	repo := NewPostgresRedisRepository(db, nil)
+	ctx := context.Background()
+
+	columns := []string{"id", "code", "original_url", "created_at", "expires_at", "max_clicks", "click_count", "status", "owner_id", "custom_alias", "rules", "experiment", "active_from", "active_until", "page", "campaign_id", "domain", "analytics_disabled", "org_id", "metadata", "query_passthrough", "mask", "burn_after_reading", "consumed_at", "access"}
+	var get, targets *sqlmock.ExpectedPrepare
+	for _, id := range []int64{1, 2} {
+		if get == nil {
+			get = mock.ExpectPrepare(`SELECT .+ FROM urls WHERE id = \$1`).WillBeClosed()
+		}
+		get.ExpectQuery().WithArgs(id).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(id, nil, "https://example.com", time.Now(), nil, nil, 0, "active", nil, false, nil, nil, nil, nil, nil, nil, nil, false, nil, nil, nil, nil, false, nil, nil))
+		if targets == nil {
+			targets = mock.ExpectPrepare(`SELECT country, url FROM link_targets`).WillBeClosed()
+		}
+		targets.ExpectQuery().WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"country", "url"}))
+	}
+	mock.ExpectClose()
+
+	// Both lookups reuse the statements prepared by the first.
+	for _, id := range []uint64{1, 2} {
+		if _, err := repo.Get(ctx, id); err != nil {
+			t.Fatalf("Get(%d) unexpected error = %v", id, err)
+		}
+	}
+	if n := len(repo.stmts.stmts); n != 2 {
+		t.Errorf("prepared %d statements, want 2", n)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() unexpected error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresRedisRepository_WithoutPreparedStatements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create mock: %v", err)
+	}
+	defer db.Close()
+	repo := NewPostgresRedisRepository(db, nil, WithoutPreparedStatements())
+
+	mock.ExpectQuery(`INSERT INTO urls`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	if _, err := repo.Save(context.Background(), &Link{OriginalURL: "https://example.com"}); err != nil {
+		t.Fatalf("Save() unexpected error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
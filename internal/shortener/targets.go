@@ -0,0 +1,148 @@
+package shortener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// MaxTargets caps the alternative destinations of a single link.
+const MaxTargets = 50
+
+var ErrInvalidTarget = errors.New("invalid target")
+
+// Visitor describes the client following a link, for links whose
+// destination depends on who follows them.
+type Visitor struct {
+	// Country is the ISO 3166-1 alpha-2 code the client is located in, or ""
+	// if it is unknown.
+	Country string
+	// Device is the client's device (see ParseDevice), or "" if unknown.
+	Device Device
+	// Variant is the experiment variant the visitor was served before (e.g.
+	// from a sticky cookie), or "".
+	Variant string
+	// ID identifies the client when counting unique visitors (see
+	// VisitorID), or "" if unknown.
+	ID string
+	// Source is where the click came from, for the traffic source
+	// breakdown (see ParseClickSource).
+	Source ClickSource
+	// Host is the hostname the link was requested on, without port. Links
+	// bound to another custom domain are not found; "" skips the check.
+	Host string
+	// Query is the query string of the request, passed on to the
+	// destination of links with a QueryPassthrough.
+	Query url.Values
+	// Referrer is the lowercased hostname of the Referer of the request,
+	// or "", checked against the Access rules of links. Unlike Source it
+	// is kept under Do Not Track, as it is never stored.
+	Referrer string
+	// Addr is the address of the client, or the zero Addr if it is
+	// unknown, checked against the Access rules of links. Like Referrer it
+	// is kept under Do Not Track.
+	Addr netip.Addr
+}
+
+// Target is an alternative destination for visitors from Country.
+type Target struct {
+	Country string `json:"country"`
+	URL     string `json:"url"`
+}
+
+// Destination returns the URL v should be redirected to, leaving any
+// experiment aside: that of the first rule v matches, else the target for
+// v's country, else OriginalURL.
+func (l *Link) Destination(v Visitor) string {
+	if url, ok := l.override(v); ok {
+		return url
+	}
+	return l.OriginalURL
+}
+
+// override returns the URL of the first rule v matches or the target for
+// v's country, which take precedence over the default destination.
+func (l *Link) override(v Visitor) (string, bool) {
+	for _, r := range l.Rules {
+		if r.Matches(v) {
+			return r.URL, true
+		}
+	}
+	if v.Country != "" {
+		for _, t := range l.Targets {
+			if t.Country == v.Country {
+				return t.URL, true
+			}
+		}
+	}
+	return "", false
+}
+
+// SetTargets replaces the country targets of the link behind shortCode,
+// which user must own. Country codes are upper-cased. Returns
+// ErrInvalidTarget for malformed or duplicate entries and ErrUnsafeURL if a
+// target URL is reported as malicious.
+func (s *Service) SetTargets(ctx context.Context, user *User, shortCode string, targets []Target) (*Link, error) {
+	if len(targets) > MaxTargets {
+		return nil, fmt.Errorf("%w: at most %d targets are allowed", ErrInvalidTarget, MaxTargets)
+	}
+	normalized := make([]Target, 0, len(targets))
+	seen := make(map[string]struct{}, len(targets))
+	for _, t := range targets {
+		country := strings.ToUpper(t.Country)
+		if !isCountryCode(country) {
+			return nil, fmt.Errorf("%w: %q is not a two-letter country code", ErrInvalidTarget, t.Country)
+		}
+		if _, dup := seen[country]; dup {
+			return nil, fmt.Errorf("%w: %s is listed twice", ErrInvalidTarget, country)
+		}
+		seen[country] = struct{}{}
+		if err := ValidateURL(t.URL); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTarget, err)
+		}
+		normalized = append(normalized, Target{Country: country, URL: t.URL})
+	}
+
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range normalized {
+		if err := s.screen(ctx, t.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.SetTargets(ctx, link, normalized); err != nil {
+		return nil, fmt.Errorf("failed to set targets: %w", err)
+	}
+	link.Targets = normalized
+	s.audit(ctx, UserActor(user), AuditSetTargets, link.ShortCode(), fmt.Sprintf("%d targets", len(normalized)))
+	return link, nil
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// scanTargets reads (country, url) rows and closes them.
+func scanTargets(rows *sql.Rows) ([]Target, error) {
+	defer rows.Close()
+
+	var targets []Target
+	for rows.Next() {
+		var t Target
+		if err := rows.Scan(&t.Country, &t.URL); err != nil {
+			return nil, fmt.Errorf("failed to scan target: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read targets: %w", err)
+	}
+	return targets, nil
+}
@@ -0,0 +1,81 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestService_SetTargets(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	bob, _, err := service.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		user    *User
+		targets []Target
+		wantErr error
+	}{
+		{name: "not owner", user: bob, targets: []Target{{Country: "US", URL: "https://example.com/us"}}, wantErr: ErrForbidden},
+		{name: "bad country", user: alice, targets: []Target{{Country: "USA", URL: "https://example.com/us"}}, wantErr: ErrInvalidTarget},
+		{name: "duplicate country", user: alice, targets: []Target{{Country: "us", URL: "https://example.com/1"}, {Country: "US", URL: "https://example.com/2"}}, wantErr: ErrInvalidTarget},
+		{name: "bad url", user: alice, targets: []Target{{Country: "US", URL: "ftp://example.com"}}, wantErr: ErrInvalidTarget},
+		{name: "valid", user: alice, targets: []Target{{Country: "us", URL: "https://example.com/us"}, {Country: "JP", URL: "https://example.com/jp"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.SetTargets(ctx, tt.user, code, tt.targets)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("SetTargets() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	for country, want := range map[string]string{
+		"US": "https://example.com/us",
+		"JP": "https://example.com/jp",
+		"DE": "https://example.com",
+		"":   "https://example.com",
+	} {
+		got, err := service.RedirectVisitor(ctx, code, Visitor{Country: country})
+		if err != nil {
+			t.Fatalf("RedirectVisitor(%q) unexpected error = %v", country, err)
+		}
+		if got.URL != want {
+			t.Errorf("RedirectVisitor(%q) = %q, want %q", country, got.URL, want)
+		}
+	}
+}
+
+func TestService_SetTargetsScreensURLs(t *testing.T) {
+	checker := &stubChecker{threats: map[string]string{"https://malware.example/": "MALWARE"}}
+	service := NewService(NewInMemoryRepository(), WithURLChecker(checker))
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	_, err = service.SetTargets(ctx, user, code, []Target{{Country: "US", URL: "https://malware.example/"}})
+	if !errors.Is(err, ErrUnsafeURL) {
+		t.Errorf("SetTargets() error = %v, want ErrUnsafeURL", err)
+	}
+}
@@ -1,27 +1,721 @@
 package shortener
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // MockRepository is a mock implementation of Repository for testing.
 // This mock is exported to allow usage in tests across multiple packages.
 type MockRepository struct {
-	SaveFunc  func(ctx context.Context, originalURL string) (uint64, error)
-	GetFunc   func(ctx context.Context, id uint64) (string, error)
-	CloseFunc func() error
+	SaveFunc                   func(ctx context.Context, link *Link) (uint64, error)
+	SaveAliasFunc              func(ctx context.Context, link *Link) (uint64, error)
+	GetFunc                    func(ctx context.Context, id uint64) (*Link, error)
+	GetByCodeFunc              func(ctx context.Context, code string) (*Link, error)
+	ListFunc                   func(ctx context.Context, cursor uint64, limit int) ([]*Link, error)
+	SearchFunc                 func(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error)
+	ListByOwnerFunc            func(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error)
+	UpdateFunc                 func(ctx context.Context, link *Link) error
+	SetTargetsFunc             func(ctx context.Context, link *Link, targets []Target) error
+	SetRulesFunc               func(ctx context.Context, link *Link, rules []Rule) error
+	SetExperimentFunc          func(ctx context.Context, link *Link, e *Experiment) error
+	SetPageFunc                func(ctx context.Context, link *Link, page *PageInfo) error
+	SetMetadataFunc            func(ctx context.Context, link *Link, m *Metadata) error
+	SetAccessFunc              func(ctx context.Context, link *Link, a *Access) error
+	RecordVariantFunc          func(ctx context.Context, link *Link, name string) error
+	VariantStatsFunc           func(ctx context.Context, link *Link) (map[string]int64, error)
+	SetHealthFunc              func(ctx context.Context, link *Link, h *Health) error
+	ListHealthFunc             func(ctx context.Context, links []*Link) (map[uint64]*Health, error)
+	HealthStatsFunc            func(ctx context.Context) (*HealthCounts, error)
+	DeleteFunc                 func(ctx context.Context, link *Link) error
+	RestoreFunc                func(ctx context.Context, link *Link, ownerID uint64) error
+	SetStatusFunc              func(ctx context.Context, link *Link, status Status) error
+	InvalidateFunc             func(ctx context.Context, link *Link) error
+	StatsFunc                  func(ctx context.Context, now time.Time) (*Stats, error)
+	AddReportFunc              func(ctx context.Context, report *Report) (int64, error)
+	ListReportsFunc            func(ctx context.Context, cursor uint64, limit int) ([]*Report, error)
+	ResolveReportsFunc         func(ctx context.Context, link *Link, now time.Time) error
+	AddAuditFunc               func(ctx context.Context, entry *AuditEntry) error
+	ListAuditFunc              func(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error)
+	AddLinkChangeFunc          func(ctx context.Context, link *Link, change *LinkChange) error
+	ListLinkChangesFunc        func(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error)
+	GetLinkChangeFunc          func(ctx context.Context, link *Link, id uint64) (*LinkChange, error)
+	NextIDFunc                 func(ctx context.Context) (uint64, error)
+	ReserveIDsFunc             func(ctx context.Context, n uint64) (uint64, error)
+	CreateUserFunc             func(ctx context.Context, user *User) error
+	GetUserByEmailFunc         func(ctx context.Context, email string) (*User, error)
+	CreateAPIKeyFunc           func(ctx context.Context, key *APIKey) error
+	GetUserByAPIKeyFunc        func(ctx context.Context, keyHash string) (*User, *APIKey, error)
+	ListAPIKeysFunc            func(ctx context.Context, userID uint64) ([]*APIKey, error)
+	TouchAPIKeyFunc            func(ctx context.Context, keyHash string, at time.Time) error
+	ExpireAPIKeyFunc           func(ctx context.Context, keyHash string, at time.Time) error
+	DeleteAPIKeyFunc           func(ctx context.Context, keyHash string) error
+	CreateIdentityFunc         func(ctx context.Context, userID uint64, issuer, subject string) error
+	LookupIdentityFunc         func(ctx context.Context, issuer, subject string) (*User, error)
+	SetUserPlanFunc            func(ctx context.Context, userID uint64, plan string) error
+	SetUserRoleFunc            func(ctx context.Context, userID uint64, role string) error
+	UsageByOwnerFunc           func(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error)
+	IncrementClicksFunc        func(ctx context.Context, link *Link) (int64, error)
+	ConsumeFunc                func(ctx context.Context, link *Link, at time.Time) (bool, error)
+	FlushClicksFunc            func(ctx context.Context) (int, error)
+	RecordClickFunc            func(ctx context.Context, link *Link, at time.Time, src ClickSource) error
+	RollupClicksFunc           func(ctx context.Context) (int, error)
+	ClickSeriesFunc            func(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error)
+	ClickSourcesFunc           func(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error)
+	PurgeClicksFunc            func(ctx context.Context, granularity string, before time.Time) (int, error)
+	DeleteClicksFunc           func(ctx context.Context, link *Link) (int, error)
+	TopStatsFunc               func(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error)
+	CreateCampaignFunc         func(ctx context.Context, c *Campaign) error
+	GetCampaignFunc            func(ctx context.Context, id uint64) (*Campaign, error)
+	ListCampaignsFunc          func(ctx context.Context, ownerID uint64) ([]*Campaign, error)
+	UpdateCampaignFunc         func(ctx context.Context, c *Campaign) error
+	DeleteCampaignFunc         func(ctx context.Context, c *Campaign) error
+	ListByCampaignFunc         func(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error)
+	CampaignStatsFunc          func(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error)
+	CreateDomainFunc           func(ctx context.Context, d *Domain) error
+	GetDomainFunc              func(ctx context.Context, id uint64) (*Domain, error)
+	GetDomainByHostFunc        func(ctx context.Context, host string) (*Domain, error)
+	ListDomainsFunc            func(ctx context.Context, ownerID uint64) ([]*Domain, error)
+	UpdateDomainFunc           func(ctx context.Context, d *Domain) error
+	DeleteDomainFunc           func(ctx context.Context, d *Domain) error
+	AddDomainRuleFunc          func(ctx context.Context, rule *DomainRule) error
+	ListDomainRulesFunc        func(ctx context.Context) ([]*DomainRule, error)
+	DeleteDomainRuleFunc       func(ctx context.Context, id uint64) error
+	AddAliasReservationFunc    func(ctx context.Context, res *AliasReservation) error
+	ListAliasReservationsFunc  func(ctx context.Context) ([]*AliasReservation, error)
+	DeleteAliasReservationFunc func(ctx context.Context, id uint64) error
+	CreateOrgFunc              func(ctx context.Context, o *Org, creatorID uint64) error
+	GetOrgFunc                 func(ctx context.Context, id uint64) (*Org, error)
+	ListOrgsFunc               func(ctx context.Context, userID uint64) ([]*Org, error)
+	ListOrgMembersFunc         func(ctx context.Context, orgID uint64) ([]*User, error)
+	IsOrgMemberFunc            func(ctx context.Context, orgID, userID uint64) (bool, error)
+	AddOrgMemberFunc           func(ctx context.Context, orgID, userID uint64) error
+	RemoveOrgMemberFunc        func(ctx context.Context, orgID, userID uint64) error
+	AddInvitationFunc          func(ctx context.Context, inv *Invitation) error
+	GetInvitationFunc          func(ctx context.Context, tokenHash string) (*Invitation, error)
+	UseInvitationFunc          func(ctx context.Context, tokenHash string) error
+	ListByOrgFunc              func(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error)
+	PurgeExpiredFunc           func(ctx context.Context, now time.Time) (int, error)
+	CloseFunc                  func() error
 }
 
-func (m *MockRepository) Save(ctx context.Context, originalURL string) (uint64, error) {
+func (m *MockRepository) Save(ctx context.Context, link *Link) (uint64, error) {
 	if m.SaveFunc != nil {
-		return m.SaveFunc(ctx, originalURL)
+		return m.SaveFunc(ctx, link)
 	}
 	return 0, nil
 }
 
-func (m *MockRepository) Get(ctx context.Context, id uint64) (string, error) {
+// SaveAlias falls back to Save, so that tests stubbing SaveFunc cover
+// custom aliases too.
+func (m *MockRepository) SaveAlias(ctx context.Context, link *Link) (uint64, error) {
+	if m.SaveAliasFunc != nil {
+		return m.SaveAliasFunc(ctx, link)
+	}
+	return m.Save(ctx, link)
+}
+
+func (m *MockRepository) Get(ctx context.Context, id uint64) (*Link, error) {
 	if m.GetFunc != nil {
 		return m.GetFunc(ctx, id)
 	}
-	return "", nil
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) GetByCode(ctx context.Context, code string) (*Link, error) {
+	if m.GetByCodeFunc != nil {
+		return m.GetByCodeFunc(ctx, code)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) List(ctx context.Context, cursor uint64, limit int) ([]*Link, error) {
+	if m.ListFunc != nil {
+		return m.ListFunc(ctx, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) Search(ctx context.Context, query string, cursor uint64, limit int) ([]*Link, error) {
+	if m.SearchFunc != nil {
+		return m.SearchFunc(ctx, query, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) ListByOwner(ctx context.Context, ownerID uint64, cursor uint64, limit int) ([]*Link, error) {
+	if m.ListByOwnerFunc != nil {
+		return m.ListByOwnerFunc(ctx, ownerID, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) Update(ctx context.Context, link *Link) error {
+	if m.UpdateFunc != nil {
+		return m.UpdateFunc(ctx, link)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetTargets(ctx context.Context, link *Link, targets []Target) error {
+	if m.SetTargetsFunc != nil {
+		return m.SetTargetsFunc(ctx, link, targets)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetRules(ctx context.Context, link *Link, rules []Rule) error {
+	if m.SetRulesFunc != nil {
+		return m.SetRulesFunc(ctx, link, rules)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetExperiment(ctx context.Context, link *Link, e *Experiment) error {
+	if m.SetExperimentFunc != nil {
+		return m.SetExperimentFunc(ctx, link, e)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetPage(ctx context.Context, link *Link, page *PageInfo) error {
+	if m.SetPageFunc != nil {
+		return m.SetPageFunc(ctx, link, page)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetMetadata(ctx context.Context, link *Link, md *Metadata) error {
+	if m.SetMetadataFunc != nil {
+		return m.SetMetadataFunc(ctx, link, md)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetAccess(ctx context.Context, link *Link, a *Access) error {
+	if m.SetAccessFunc != nil {
+		return m.SetAccessFunc(ctx, link, a)
+	}
+	return nil
+}
+
+func (m *MockRepository) RecordVariant(ctx context.Context, link *Link, name string) error {
+	if m.RecordVariantFunc != nil {
+		return m.RecordVariantFunc(ctx, link, name)
+	}
+	return nil
+}
+
+func (m *MockRepository) VariantStats(ctx context.Context, link *Link) (map[string]int64, error) {
+	if m.VariantStatsFunc != nil {
+		return m.VariantStatsFunc(ctx, link)
+	}
+	return map[string]int64{}, nil
+}
+
+func (m *MockRepository) SetHealth(ctx context.Context, link *Link, h *Health) error {
+	if m.SetHealthFunc != nil {
+		return m.SetHealthFunc(ctx, link, h)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListHealth(ctx context.Context, links []*Link) (map[uint64]*Health, error) {
+	if m.ListHealthFunc != nil {
+		return m.ListHealthFunc(ctx, links)
+	}
+	return map[uint64]*Health{}, nil
+}
+
+func (m *MockRepository) HealthStats(ctx context.Context) (*HealthCounts, error) {
+	if m.HealthStatsFunc != nil {
+		return m.HealthStatsFunc(ctx)
+	}
+	return &HealthCounts{}, nil
+}
+
+func (m *MockRepository) Delete(ctx context.Context, link *Link) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, link)
+	}
+	return nil
+}
+
+func (m *MockRepository) Restore(ctx context.Context, link *Link, ownerID uint64) error {
+	if m.RestoreFunc != nil {
+		return m.RestoreFunc(ctx, link, ownerID)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetStatus(ctx context.Context, link *Link, status Status) error {
+	if m.SetStatusFunc != nil {
+		return m.SetStatusFunc(ctx, link, status)
+	}
+	return nil
+}
+
+func (m *MockRepository) Invalidate(ctx context.Context, link *Link) error {
+	if m.InvalidateFunc != nil {
+		return m.InvalidateFunc(ctx, link)
+	}
+	return nil
+}
+
+func (m *MockRepository) Stats(ctx context.Context, now time.Time) (*Stats, error) {
+	if m.StatsFunc != nil {
+		return m.StatsFunc(ctx, now)
+	}
+	return &Stats{}, nil
+}
+
+func (m *MockRepository) AddReport(ctx context.Context, report *Report) (int64, error) {
+	if m.AddReportFunc != nil {
+		return m.AddReportFunc(ctx, report)
+	}
+	return 1, nil
+}
+
+func (m *MockRepository) ListReports(ctx context.Context, cursor uint64, limit int) ([]*Report, error) {
+	if m.ListReportsFunc != nil {
+		return m.ListReportsFunc(ctx, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) ResolveReports(ctx context.Context, link *Link, now time.Time) error {
+	if m.ResolveReportsFunc != nil {
+		return m.ResolveReportsFunc(ctx, link, now)
+	}
+	return nil
+}
+
+func (m *MockRepository) AddAudit(ctx context.Context, entry *AuditEntry) error {
+	if m.AddAuditFunc != nil {
+		return m.AddAuditFunc(ctx, entry)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListAudit(ctx context.Context, f AuditFilter, cursor uint64, limit int) ([]*AuditEntry, error) {
+	if m.ListAuditFunc != nil {
+		return m.ListAuditFunc(ctx, f, cursor, limit)
+	}
+	return []*AuditEntry{}, nil
+}
+
+func (m *MockRepository) AddLinkChange(ctx context.Context, link *Link, change *LinkChange) error {
+	if m.AddLinkChangeFunc != nil {
+		return m.AddLinkChangeFunc(ctx, link, change)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListLinkChanges(ctx context.Context, link *Link, cursor uint64, limit int) ([]*LinkChange, error) {
+	if m.ListLinkChangesFunc != nil {
+		return m.ListLinkChangesFunc(ctx, link, cursor, limit)
+	}
+	return []*LinkChange{}, nil
+}
+
+func (m *MockRepository) GetLinkChange(ctx context.Context, link *Link, id uint64) (*LinkChange, error) {
+	if m.GetLinkChangeFunc != nil {
+		return m.GetLinkChangeFunc(ctx, link, id)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) NextID(ctx context.Context) (uint64, error) {
+	if m.NextIDFunc != nil {
+		return m.NextIDFunc(ctx)
+	}
+	return 1, nil
+}
+
+func (m *MockRepository) ReserveIDs(ctx context.Context, n uint64) (uint64, error) {
+	if m.ReserveIDsFunc != nil {
+		return m.ReserveIDsFunc(ctx, n)
+	}
+	return 1, nil
+}
+
+func (m *MockRepository) CreateUser(ctx context.Context, user *User) error {
+	if m.CreateUserFunc != nil {
+		return m.CreateUserFunc(ctx, user)
+	}
+	user.ID = 1
+	return nil
+}
+
+func (m *MockRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if m.GetUserByEmailFunc != nil {
+		return m.GetUserByEmailFunc(ctx, email)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	if m.CreateAPIKeyFunc != nil {
+		return m.CreateAPIKeyFunc(ctx, key)
+	}
+	return nil
+}
+
+func (m *MockRepository) GetUserByAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	if m.GetUserByAPIKeyFunc != nil {
+		return m.GetUserByAPIKeyFunc(ctx, keyHash)
+	}
+	return nil, nil, ErrNotFound
+}
+
+func (m *MockRepository) ListAPIKeys(ctx context.Context, userID uint64) ([]*APIKey, error) {
+	if m.ListAPIKeysFunc != nil {
+		return m.ListAPIKeysFunc(ctx, userID)
+	}
+	return []*APIKey{}, nil
+}
+
+func (m *MockRepository) TouchAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	if m.TouchAPIKeyFunc != nil {
+		return m.TouchAPIKeyFunc(ctx, keyHash, at)
+	}
+	return nil
+}
+
+func (m *MockRepository) ExpireAPIKey(ctx context.Context, keyHash string, at time.Time) error {
+	if m.ExpireAPIKeyFunc != nil {
+		return m.ExpireAPIKeyFunc(ctx, keyHash, at)
+	}
+	return ErrNotFound
+}
+
+func (m *MockRepository) DeleteAPIKey(ctx context.Context, keyHash string) error {
+	if m.DeleteAPIKeyFunc != nil {
+		return m.DeleteAPIKeyFunc(ctx, keyHash)
+	}
+	return nil
+}
+
+func (m *MockRepository) CreateIdentity(ctx context.Context, userID uint64, issuer, subject string) error {
+	if m.CreateIdentityFunc != nil {
+		return m.CreateIdentityFunc(ctx, userID, issuer, subject)
+	}
+	return nil
+}
+
+func (m *MockRepository) LookupIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	if m.LookupIdentityFunc != nil {
+		return m.LookupIdentityFunc(ctx, issuer, subject)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) SetUserPlan(ctx context.Context, userID uint64, plan string) error {
+	if m.SetUserPlanFunc != nil {
+		return m.SetUserPlanFunc(ctx, userID, plan)
+	}
+	return nil
+}
+
+func (m *MockRepository) SetUserRole(ctx context.Context, userID uint64, role string) error {
+	if m.SetUserRoleFunc != nil {
+		return m.SetUserRoleFunc(ctx, userID, role)
+	}
+	return nil
+}
+
+func (m *MockRepository) UsageByOwner(ctx context.Context, ownerID uint64, since time.Time) (*Usage, error) {
+	if m.UsageByOwnerFunc != nil {
+		return m.UsageByOwnerFunc(ctx, ownerID, since)
+	}
+	return &Usage{}, nil
+}
+
+func (m *MockRepository) IncrementClicks(ctx context.Context, link *Link) (int64, error) {
+	if m.IncrementClicksFunc != nil {
+		return m.IncrementClicksFunc(ctx, link)
+	}
+	return 1, nil
+}
+
+func (m *MockRepository) Consume(ctx context.Context, link *Link, at time.Time) (bool, error) {
+	if m.ConsumeFunc != nil {
+		return m.ConsumeFunc(ctx, link, at)
+	}
+	return true, nil
+}
+
+func (m *MockRepository) FlushClicks(ctx context.Context) (int, error) {
+	if m.FlushClicksFunc != nil {
+		return m.FlushClicksFunc(ctx)
+	}
+	return 0, nil
+}
+
+func (m *MockRepository) RecordClick(ctx context.Context, link *Link, at time.Time, src ClickSource) error {
+	if m.RecordClickFunc != nil {
+		return m.RecordClickFunc(ctx, link, at, src)
+	}
+	return nil
+}
+
+func (m *MockRepository) RollupClicks(ctx context.Context) (int, error) {
+	if m.RollupClicksFunc != nil {
+		return m.RollupClicksFunc(ctx)
+	}
+	return 0, nil
+}
+
+func (m *MockRepository) ClickSeries(ctx context.Context, link *Link, granularity string, from, to time.Time) ([]ClickBucket, error) {
+	if m.ClickSeriesFunc != nil {
+		return m.ClickSeriesFunc(ctx, link, granularity, from, to)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) ClickSources(ctx context.Context, link *Link, from, to time.Time) ([]SourceCount, error) {
+	if m.ClickSourcesFunc != nil {
+		return m.ClickSourcesFunc(ctx, link, from, to)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) PurgeClicks(ctx context.Context, granularity string, before time.Time) (int, error) {
+	if m.PurgeClicksFunc != nil {
+		return m.PurgeClicksFunc(ctx, granularity, before)
+	}
+	return 0, nil
+}
+
+func (m *MockRepository) DeleteClicks(ctx context.Context, link *Link) (int, error) {
+	if m.DeleteClicksFunc != nil {
+		return m.DeleteClicksFunc(ctx, link)
+	}
+	return 0, nil
+}
+
+func (m *MockRepository) TopStats(ctx context.Context, granularity string, from, to time.Time, limit int) (*TopStats, error) {
+	if m.TopStatsFunc != nil {
+		return m.TopStatsFunc(ctx, granularity, from, to, limit)
+	}
+	return &TopStats{Top: []TopLink{}}, nil
+}
+
+func (m *MockRepository) CreateCampaign(ctx context.Context, c *Campaign) error {
+	if m.CreateCampaignFunc != nil {
+		return m.CreateCampaignFunc(ctx, c)
+	}
+	return nil
+}
+
+func (m *MockRepository) GetCampaign(ctx context.Context, id uint64) (*Campaign, error) {
+	if m.GetCampaignFunc != nil {
+		return m.GetCampaignFunc(ctx, id)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) ListCampaigns(ctx context.Context, ownerID uint64) ([]*Campaign, error) {
+	if m.ListCampaignsFunc != nil {
+		return m.ListCampaignsFunc(ctx, ownerID)
+	}
+	return []*Campaign{}, nil
+}
+
+func (m *MockRepository) UpdateCampaign(ctx context.Context, c *Campaign) error {
+	if m.UpdateCampaignFunc != nil {
+		return m.UpdateCampaignFunc(ctx, c)
+	}
+	return nil
+}
+
+func (m *MockRepository) DeleteCampaign(ctx context.Context, c *Campaign) error {
+	if m.DeleteCampaignFunc != nil {
+		return m.DeleteCampaignFunc(ctx, c)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListByCampaign(ctx context.Context, campaignID uint64, cursor uint64, limit int) ([]*Link, error) {
+	if m.ListByCampaignFunc != nil {
+		return m.ListByCampaignFunc(ctx, campaignID, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) CampaignStats(ctx context.Context, campaignID uint64, granularity string, from, to time.Time, limit int) (*CampaignStats, error) {
+	if m.CampaignStatsFunc != nil {
+		return m.CampaignStatsFunc(ctx, campaignID, granularity, from, to, limit)
+	}
+	return &CampaignStats{Top: []TopLink{}}, nil
+}
+
+func (m *MockRepository) CreateDomain(ctx context.Context, d *Domain) error {
+	if m.CreateDomainFunc != nil {
+		return m.CreateDomainFunc(ctx, d)
+	}
+	return nil
+}
+
+func (m *MockRepository) GetDomain(ctx context.Context, id uint64) (*Domain, error) {
+	if m.GetDomainFunc != nil {
+		return m.GetDomainFunc(ctx, id)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) GetDomainByHost(ctx context.Context, host string) (*Domain, error) {
+	if m.GetDomainByHostFunc != nil {
+		return m.GetDomainByHostFunc(ctx, host)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) ListDomains(ctx context.Context, ownerID uint64) ([]*Domain, error) {
+	if m.ListDomainsFunc != nil {
+		return m.ListDomainsFunc(ctx, ownerID)
+	}
+	return []*Domain{}, nil
+}
+
+func (m *MockRepository) UpdateDomain(ctx context.Context, d *Domain) error {
+	if m.UpdateDomainFunc != nil {
+		return m.UpdateDomainFunc(ctx, d)
+	}
+	return nil
+}
+
+func (m *MockRepository) DeleteDomain(ctx context.Context, d *Domain) error {
+	if m.DeleteDomainFunc != nil {
+		return m.DeleteDomainFunc(ctx, d)
+	}
+	return nil
+}
+
+func (m *MockRepository) AddDomainRule(ctx context.Context, rule *DomainRule) error {
+	if m.AddDomainRuleFunc != nil {
+		return m.AddDomainRuleFunc(ctx, rule)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListDomainRules(ctx context.Context) ([]*DomainRule, error) {
+	if m.ListDomainRulesFunc != nil {
+		return m.ListDomainRulesFunc(ctx)
+	}
+	return []*DomainRule{}, nil
+}
+
+func (m *MockRepository) DeleteDomainRule(ctx context.Context, id uint64) error {
+	if m.DeleteDomainRuleFunc != nil {
+		return m.DeleteDomainRuleFunc(ctx, id)
+	}
+	return ErrNotFound
+}
+
+func (m *MockRepository) AddAliasReservation(ctx context.Context, res *AliasReservation) error {
+	if m.AddAliasReservationFunc != nil {
+		return m.AddAliasReservationFunc(ctx, res)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListAliasReservations(ctx context.Context) ([]*AliasReservation, error) {
+	if m.ListAliasReservationsFunc != nil {
+		return m.ListAliasReservationsFunc(ctx)
+	}
+	return []*AliasReservation{}, nil
+}
+
+func (m *MockRepository) DeleteAliasReservation(ctx context.Context, id uint64) error {
+	if m.DeleteAliasReservationFunc != nil {
+		return m.DeleteAliasReservationFunc(ctx, id)
+	}
+	return ErrNotFound
+}
+
+func (m *MockRepository) CreateOrg(ctx context.Context, o *Org, creatorID uint64) error {
+	if m.CreateOrgFunc != nil {
+		return m.CreateOrgFunc(ctx, o, creatorID)
+	}
+	return nil
+}
+
+func (m *MockRepository) GetOrg(ctx context.Context, id uint64) (*Org, error) {
+	if m.GetOrgFunc != nil {
+		return m.GetOrgFunc(ctx, id)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) ListOrgs(ctx context.Context, userID uint64) ([]*Org, error) {
+	if m.ListOrgsFunc != nil {
+		return m.ListOrgsFunc(ctx, userID)
+	}
+	return []*Org{}, nil
+}
+
+func (m *MockRepository) ListOrgMembers(ctx context.Context, orgID uint64) ([]*User, error) {
+	if m.ListOrgMembersFunc != nil {
+		return m.ListOrgMembersFunc(ctx, orgID)
+	}
+	return []*User{}, nil
+}
+
+func (m *MockRepository) IsOrgMember(ctx context.Context, orgID, userID uint64) (bool, error) {
+	if m.IsOrgMemberFunc != nil {
+		return m.IsOrgMemberFunc(ctx, orgID, userID)
+	}
+	return false, nil
+}
+
+func (m *MockRepository) AddOrgMember(ctx context.Context, orgID, userID uint64) error {
+	if m.AddOrgMemberFunc != nil {
+		return m.AddOrgMemberFunc(ctx, orgID, userID)
+	}
+	return nil
+}
+
+func (m *MockRepository) RemoveOrgMember(ctx context.Context, orgID, userID uint64) error {
+	if m.RemoveOrgMemberFunc != nil {
+		return m.RemoveOrgMemberFunc(ctx, orgID, userID)
+	}
+	return nil
+}
+
+func (m *MockRepository) AddInvitation(ctx context.Context, inv *Invitation) error {
+	if m.AddInvitationFunc != nil {
+		return m.AddInvitationFunc(ctx, inv)
+	}
+	return nil
+}
+
+func (m *MockRepository) GetInvitation(ctx context.Context, tokenHash string) (*Invitation, error) {
+	if m.GetInvitationFunc != nil {
+		return m.GetInvitationFunc(ctx, tokenHash)
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockRepository) UseInvitation(ctx context.Context, tokenHash string) error {
+	if m.UseInvitationFunc != nil {
+		return m.UseInvitationFunc(ctx, tokenHash)
+	}
+	return nil
+}
+
+func (m *MockRepository) ListByOrg(ctx context.Context, orgID uint64, cursor uint64, limit int) ([]*Link, error) {
+	if m.ListByOrgFunc != nil {
+		return m.ListByOrgFunc(ctx, orgID, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *MockRepository) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	if m.PurgeExpiredFunc != nil {
+		return m.PurgeExpiredFunc(ctx, now)
+	}
+	return 0, nil
 }
 
 func (m *MockRepository) Close() error {
@@ -0,0 +1,496 @@
+package shortener
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// urlImportBatchSize is the number of lines URLImport.Run saves at a time.
+	urlImportBatchSize = 100
+	// urlImportRetention is how long the status of a finished URL import is
+	// kept for URLImportStatus.
+	urlImportRetention = 24 * time.Hour
+)
+
+// States of a URL import.
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ImportRow is a line of a URL import: a destination to shorten and the
+// settings of its link.
+type ImportRow struct {
+	// Line is the line of the row in the import, counting from 1.
+	Line int    `json:"-"`
+	URL  string `json:"url"`
+	// OriginalURL is read when URL is empty, so that exports of this
+	// service can be imported as well.
+	OriginalURL string     `json:"original_url"`
+	CustomAlias string     `json:"custom_alias"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	MaxClicks   int64      `json:"max_clicks"`
+	// Err is why the line could not be read, wrapping ErrInvalidImport.
+	Err error `json:"-"`
+}
+
+// URLDecoder reads the rows of a URL import.
+type URLDecoder interface {
+	// Decode returns the next row, or io.EOF after the last one. A line that
+	// cannot be read is returned as a row with Err set; an error is only
+	// returned if reading cannot go on.
+	Decode() (*ImportRow, error)
+}
+
+// NewURLDecoder returns a decoder reading a URL import in format from r:
+// ExportNDJSON, one object per line with the fields of ImportRow, or
+// ExportCSV, a header row naming the same columns and one row per link.
+// Other fields and columns are ignored.
+func NewURLDecoder(r io.Reader, format string) (URLDecoder, error) {
+	switch format {
+	case ExportNDJSON:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 1<<20)
+		return &ndjsonURLDecoder{sc: sc}, nil
+	case ExportCSV:
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		// Short rows leave their missing columns empty
+		cr.FieldsPerRecord = -1
+		return &csvURLDecoder{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("unknown import format %q: must be %s or %s", format, ExportNDJSON, ExportCSV)
+	}
+}
+
+type ndjsonURLDecoder struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (d *ndjsonURLDecoder) Decode() (*ImportRow, error) {
+	for d.sc.Scan() {
+		d.line++
+		if len(d.sc.Bytes()) == 0 {
+			continue
+		}
+		row := &ImportRow{}
+		if err := json.Unmarshal(d.sc.Bytes(), row); err != nil {
+			row = &ImportRow{Err: fmt.Errorf("%w: %v", ErrInvalidImport, err)}
+		}
+		row.Line = d.line
+		return row, nil
+	}
+	if err := d.sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, d.line+1, err)
+		}
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type csvURLDecoder struct {
+	r *csv.Reader
+	// columns are the indexes of the columns by name, read from the header.
+	columns map[string]int
+}
+
+func (d *csvURLDecoder) Decode() (*ImportRow, error) {
+	if d.columns == nil {
+		header, err := d.r.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		d.columns = make(map[string]int, len(header))
+		for i, name := range header {
+			d.columns[name] = i
+		}
+		_, hasURL := d.columns["url"]
+		_, hasOriginal := d.columns["original_url"]
+		if !hasURL && !hasOriginal {
+			return nil, fmt.Errorf("%w: header has no url column", ErrInvalidImport)
+		}
+	}
+
+	record, err := d.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		// The reader carries on with the next line
+		return &ImportRow{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", ErrInvalidImport, parseErr.Err)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	line, _ := d.r.FieldPos(0)
+	row, err := d.decodeRecord(record)
+	if err != nil {
+		row = &ImportRow{Err: fmt.Errorf("%w: %v", ErrInvalidImport, err)}
+	}
+	row.Line = line
+	return row, nil
+}
+
+// decodeRecord parses the columns of a record present in the header.
+func (d *csvURLDecoder) decodeRecord(record []string) (*ImportRow, error) {
+	col := func(name string) string {
+		if i, ok := d.columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	row := &ImportRow{URL: col("url"), OriginalURL: col("original_url"), CustomAlias: col("custom_alias")}
+	if v := col("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("expires_at %q: not an RFC 3339 timestamp", v)
+		}
+		row.ExpiresAt = &t
+	}
+	if v := col("max_clicks"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("max_clicks %q: not a number", v)
+		}
+		row.MaxClicks = n
+	}
+	return row, nil
+}
+
+// ImportLineResult is the outcome of a line of a URL import.
+type ImportLineResult struct {
+	Line int
+	// Link is the link created for the line, nil if Err is set.
+	Link *Link
+	// Err is why no link was created for the line: an error of the row or
+	// one ShortenWithOptions returns for its settings.
+	Err error
+}
+
+// ImportStatus is the progress of a URL import.
+type ImportStatus struct {
+	ID string `json:"id"`
+	// State is ImportRunning, ImportDone or ImportFailed.
+	State string `json:"state"`
+	// Lines counts the lines processed so far, Created and Failed those
+	// that did and did not get a link.
+	Lines   int `json:"lines"`
+	Created int `json:"created"`
+	Failed  int `json:"failed"`
+	// Error is why a failed import stopped: the malformed input, or
+	// "internal error".
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	ownerID uint64
+}
+
+// URLImport shortens the URLs of a file on behalf of a user. Its progress
+// is available through URLImportStatus while it runs and for a day after.
+type URLImport struct {
+	s     *Service
+	owner *User
+	// status is guarded by s.importsMu.
+	status *ImportStatus
+}
+
+// batchSaver is implemented by repositories that save many links in one
+// round trip.
+type batchSaver interface {
+	// SaveBatch saves links as Save does and returns the error of each,
+	// nil or ErrConflict. An error is returned if none could be saved.
+	SaveBatch(ctx context.Context, links []*Link) ([]error, error)
+}
+
+// NewURLImport registers an import of URLs by owner.
+func (s *Service) NewURLImport(owner *User) (*URLImport, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate import id: %w", err)
+	}
+	status := &ImportStatus{ID: hex.EncodeToString(id), State: ImportRunning, StartedAt: s.now(), ownerID: owner.ID}
+
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	if s.imports == nil {
+		s.imports = make(map[string]*ImportStatus)
+	}
+	for id, st := range s.imports {
+		if st.FinishedAt != nil && s.now().Sub(*st.FinishedAt) > urlImportRetention {
+			delete(s.imports, id)
+		}
+	}
+	s.imports[status.ID] = status
+	return &URLImport{s: s, owner: owner, status: status}, nil
+}
+
+// URLImportStatus returns the progress of the import id of user, or
+// ErrNotFound. Imports are only known to the instance running them.
+func (s *Service) URLImportStatus(user *User, id string) (ImportStatus, error) {
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	status, ok := s.imports[id]
+	if !ok || status.ownerID != user.ID {
+		return ImportStatus{}, ErrNotFound
+	}
+	return *status, nil
+}
+
+// ID returns the ID the import is known by to URLImportStatus.
+func (imp *URLImport) ID() string {
+	return imp.status.ID
+}
+
+// Run shortens the URLs of the rows returned by next until it returns
+// io.EOF, owned by the user of the import, and passes the outcome of every
+// line to emit, a batch of lines at a time. Lines are checked as by
+// ShortenWithOptions and a line that fails does not stop the import;
+// other errors, including those of emit, do. The links of a batch are saved
+// together where the repository supports it.
+func (imp *URLImport) Run(ctx context.Context, next func() (*ImportRow, error), emit func([]ImportLineResult) error) (ImportStatus, error) {
+	err := imp.run(ctx, next, emit)
+
+	s := imp.s
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	now := s.now()
+	imp.status.FinishedAt = &now
+	imp.status.State = ImportDone
+	if err != nil {
+		imp.status.State = ImportFailed
+		// Only malformed input is the business of the importing user
+		imp.status.Error = "internal error"
+		if errors.Is(err, ErrInvalidImport) {
+			imp.status.Error = err.Error()
+		}
+	}
+	return *imp.status, err
+}
+
+func (imp *URLImport) run(ctx context.Context, next func() (*ImportRow, error), emit func([]ImportLineResult) error) error {
+	rows := make([]*ImportRow, 0, urlImportBatchSize)
+	for {
+		row, err := next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if row != nil {
+			rows = append(rows, row)
+		}
+		if len(rows) == urlImportBatchSize || err == io.EOF && len(rows) > 0 {
+			results, batchErr := imp.importBatch(ctx, rows)
+			if batchErr != nil {
+				return batchErr
+			}
+			imp.count(results)
+			if err := emit(results); err != nil {
+				return err
+			}
+			rows = rows[:0]
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// count adds results to the status of the import.
+func (imp *URLImport) count(results []ImportLineResult) {
+	imp.s.importsMu.Lock()
+	defer imp.s.importsMu.Unlock()
+	for _, r := range results {
+		imp.status.Lines++
+		if r.Err != nil {
+			imp.status.Failed++
+		} else {
+			imp.status.Created++
+		}
+	}
+}
+
+// importBatch checks rows and saves the links of those that pass. Quotas are
+// counted across the batch, since its links are only saved at the end.
+func (imp *URLImport) importBatch(ctx context.Context, rows []*ImportRow) ([]ImportLineResult, error) {
+	s := imp.s
+	plan, hasPlan := s.plan(imp.owner)
+	var usage *Usage
+	if hasPlan && plan != (Plan{}) {
+		var err error
+		if usage, err = s.repo.UsageByOwner(ctx, imp.owner.ID, s.now().Add(-quotaWindow)); err != nil {
+			return nil, fmt.Errorf("failed to check quota: %w", err)
+		}
+	}
+
+	results := make([]ImportLineResult, len(rows))
+	links := make([]*Link, 0, len(rows))
+	pending := make([]int, 0, len(rows))
+	homographs := make(map[int]string)
+	for i, row := range rows {
+		results[i].Line = row.Line
+		link, homograph, err := imp.prepare(ctx, row, plan, usage)
+		if err != nil {
+			if !isLineError(err) {
+				return nil, fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			results[i].Err = err
+			continue
+		}
+		if usage != nil {
+			usage.Total++
+			usage.Recent++
+			if link.CustomAlias {
+				usage.CustomAliases++
+			}
+		}
+		links = append(links, link)
+		pending = append(pending, i)
+		if homograph != "" {
+			homographs[i] = homograph
+		}
+	}
+	if len(links) == 0 {
+		return results, nil
+	}
+
+	// Custom aliases are checked against the alias reservations as they are
+	// saved, one at a time; generated codes are saved together
+	errs := make([]error, len(links))
+	var batch []*Link
+	var batched []int
+	for j, link := range links {
+		if !link.CustomAlias {
+			batch = append(batch, link)
+			batched = append(batched, j)
+			continue
+		}
+		_, err := s.repo.SaveAlias(ctx, link)
+		if err != nil && !errors.Is(err, ErrConflict) && !errors.Is(err, ErrAliasReserved) {
+			return nil, fmt.Errorf("line %d: failed to save url: %w", results[pending[j]].Line, err)
+		}
+		errs[j] = err
+	}
+	if len(batch) > 0 {
+		batchErrs, err := s.saveBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for k, j := range batched {
+			errs[j] = batchErrs[k]
+		}
+	}
+	for j, i := range pending {
+		link := links[j]
+		if errors.Is(errs[j], ErrAliasReserved) {
+			results[i].Err = errs[j]
+			continue
+		}
+		if errors.Is(errs[j], ErrConflict) {
+			if link.CustomAlias {
+				results[i].Err = ErrAliasTaken
+				continue
+			}
+			// The code strategy drew a taken ID: draw again, one at a time
+			if _, err := s.shortenGenerated(ctx, link, nil); err != nil {
+				return nil, fmt.Errorf("line %d: %w", results[i].Line, err)
+			}
+		}
+		results[i].Link = link
+		s.linkCreated(ctx, link, link.ShortCode(), imp.owner, homographs[i])
+	}
+	return results, nil
+}
+
+// prepare checks row and returns its link, with its alias or generated code
+// assigned, and the homograph host of its destination if it has one.
+func (imp *URLImport) prepare(ctx context.Context, row *ImportRow, plan Plan, usage *Usage) (*Link, string, error) {
+	if row.Err != nil {
+		return nil, "", row.Err
+	}
+	rawURL := row.URL
+	if rawURL == "" {
+		rawURL = row.OriginalURL
+	}
+	if err := ValidateURL(rawURL); err != nil {
+		return nil, "", err
+	}
+	if usage != nil {
+		if err := plan.check(usage, row.CustomAlias != ""); err != nil {
+			return nil, "", err
+		}
+	}
+	// The quota is checked above, against the links of the batch as well
+	link, homograph, err := imp.s.prepareLink(ctx, rawURL, ShortenOptions{ExpiresAt: row.ExpiresAt, MaxClicks: row.MaxClicks})
+	if err != nil {
+		return nil, "", err
+	}
+	link.OwnerID = imp.owner.ID
+	if row.CustomAlias != "" {
+		if err := pinAlias(link, row.CustomAlias); err != nil {
+			return nil, "", err
+		}
+		if err := imp.s.codePolicy.checkAlias(row.CustomAlias, link.OrgID); err != nil {
+			return nil, "", err
+		}
+	} else if err := imp.s.assignCode(ctx, link, nil); err != nil {
+		return nil, "", err
+	}
+	return link, homograph, nil
+}
+
+// isLineError reports whether err, returned for a line of an import, is
+// caused by the line rather than by the service, so that the import goes
+// on with the next line.
+func isLineError(err error) bool {
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return true
+	}
+	for _, lineErr := range []error{
+		ErrInvalidImport, ErrInvalidURL, ErrInvalidHost, ErrURLTooLong, ErrUnsafeURL, ErrHomograph,
+		ErrDomainBlocked, ErrRecursiveURL, ErrInvalidAlias, ErrAliasReserved, ErrInvalidExpiry, ErrInvalidMaxClicks,
+	} {
+		if errors.Is(err, lineErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveBatch saves links, together if the repository supports it, and
+// returns the error of each, nil or ErrConflict.
+func (s *Service) saveBatch(ctx context.Context, links []*Link) ([]error, error) {
+	if b, ok := s.repo.(batchSaver); ok {
+		errs, err := b.SaveBatch(ctx, links)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save urls: %w", err)
+		}
+		return errs, nil
+	}
+	errs := make([]error, len(links))
+	for i, link := range links {
+		_, err := s.repo.Save(ctx, link)
+		if err != nil && !errors.Is(err, ErrConflict) {
+			return nil, fmt.Errorf("failed to save url: %w", err)
+		}
+		errs[i] = err
+	}
+	return errs, nil
+}
@@ -0,0 +1,251 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewURLDecoder(t *testing.T) {
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    []ImportRow
+		wantErr string
+	}{
+		{
+			name:   "ndjson",
+			format: ExportNDJSON,
+			input: `{"url":"https://example.com/a","custom_alias":"promo","expires_at":"2030-01-02T03:04:05Z","max_clicks":5}
+
+{"original_url":"https://example.com/b","clicks":12}
+{"url":`,
+			want: []ImportRow{
+				{Line: 1, URL: "https://example.com/a", CustomAlias: "promo", ExpiresAt: &expiry, MaxClicks: 5},
+				{Line: 3, OriginalURL: "https://example.com/b"},
+				{Line: 4, Err: ErrInvalidImport},
+			},
+		},
+		{
+			name:   "csv",
+			format: ExportCSV,
+			input: `clicks,url,custom_alias,expires_at,max_clicks
+3,https://example.com/a,promo,2030-01-02T03:04:05Z,5
+0,https://example.com/b
+1,https://example.com/c,,tomorrow
+2,"https://example.com/d
+`,
+			want: []ImportRow{
+				{Line: 2, URL: "https://example.com/a", CustomAlias: "promo", ExpiresAt: &expiry, MaxClicks: 5},
+				{Line: 3, URL: "https://example.com/b"},
+				{Line: 4, Err: ErrInvalidImport},
+				{Line: 5, Err: ErrInvalidImport},
+			},
+		},
+		{
+			name:    "csv without url column",
+			format:  ExportCSV,
+			input:   "link,alias\nhttps://example.com/a,promo\n",
+			wantErr: "no url column",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := NewURLDecoder(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("NewURLDecoder() error = %v", err)
+			}
+			var got []ImportRow
+			for {
+				row, err := dec.Decode()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("Decode() error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				got = append(got, *row)
+			}
+			if tt.wantErr != "" {
+				t.Fatalf("Decode() = %+v, want error %q", got, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Decode() = %+v, want %d rows", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				row := got[i]
+				if row.Line != want.Line || row.URL != want.URL || row.OriginalURL != want.OriginalURL || row.CustomAlias != want.CustomAlias ||
+					row.MaxClicks != want.MaxClicks || !equalTime(row.ExpiresAt, want.ExpiresAt) || !errors.Is(row.Err, want.Err) {
+					t.Errorf("row %d = %+v, want %+v", i, row, want)
+				}
+			}
+		})
+	}
+
+	if _, err := NewURLDecoder(strings.NewReader(""), "xml"); err == nil {
+		t.Error("NewURLDecoder(xml) error = nil, want an unknown format")
+	}
+}
+
+func equalTime(a, b *time.Time) bool {
+	return a == nil && b == nil || a != nil && b != nil && a.Equal(*b)
+}
+
+func TestURLImport_Run(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepository()
+	service := NewService(repo, WithPlans(map[string]Plan{"free": {TotalLinks: 250}}, "free"))
+	owner, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() error = %v", err)
+	}
+	if _, err := service.ShortenWithOptions(ctx, "https://example.com/taken", ShortenOptions{CustomAlias: "taken"}); err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+
+	// 300 lines over three batches: the owner's quota runs out at line 254,
+	// after the 250 links of the other lines that pass
+	var input strings.Builder
+	for i := 1; i <= 300; i++ {
+		switch i {
+		case 2:
+			input.WriteString(`{"url":"ftp://example.com/file"}` + "\n")
+		case 3:
+			input.WriteString(`{"url":"https://example.com/3","custom_alias":"taken"}` + "\n")
+		case 4, 5:
+			input.WriteString(`{"url":"https://example.com/dup","custom_alias":"dup"}` + "\n")
+		case 6:
+			input.WriteString(`not json` + "\n")
+		default:
+			fmt.Fprintf(&input, `{"url":"https://example.com/%d"}`+"\n", i)
+		}
+	}
+	dec, err := NewURLDecoder(strings.NewReader(input.String()), ExportNDJSON)
+	if err != nil {
+		t.Fatalf("NewURLDecoder() error = %v", err)
+	}
+	imp, err := service.NewURLImport(owner)
+	if err != nil {
+		t.Fatalf("NewURLImport() error = %v", err)
+	}
+
+	var results []ImportLineResult
+	batches := 0
+	status, err := imp.Run(ctx, dec.Decode, func(batch []ImportLineResult) error {
+		batches++
+		results = append(results, batch...)
+		// Progress is visible while the import runs
+		if st, err := service.URLImportStatus(owner, imp.ID()); err != nil || st.State != ImportRunning || st.Lines != len(results) {
+			t.Errorf("URLImportStatus() during the import = %+v, %v, want %d lines running", st, err, len(results))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if batches != 3 || len(results) != 300 {
+		t.Fatalf("Run() emitted %d results in %d batches, want 300 in 3", len(results), batches)
+	}
+
+	wantErrs := map[int]error{2: ErrInvalidURL, 3: ErrAliasTaken, 5: ErrAliasTaken, 6: ErrInvalidImport}
+	var quotaErr *QuotaError
+	for i, res := range results {
+		line := i + 1
+		switch want := wantErrs[line]; {
+		case res.Line != line:
+			t.Errorf("result %d is for line %d", i, res.Line)
+		case want != nil:
+			if !errors.Is(res.Err, want) || res.Link != nil {
+				t.Errorf("line %d = %+v, want %v", line, res, want)
+			}
+		case line > 254:
+			if !errors.As(res.Err, &quotaErr) || quotaErr.Code != QuotaTotalLinks {
+				t.Errorf("line %d = %+v, want the total links quota reached", line, res)
+			}
+		default:
+			if res.Err != nil || res.Link == nil || res.Link.OwnerID != owner.ID {
+				t.Fatalf("line %d = %+v, want a link of the owner", line, res)
+			}
+			wantURL := fmt.Sprintf("https://example.com/%d", line)
+			if line == 4 {
+				wantURL = "https://example.com/dup"
+			}
+			if link, err := service.Resolve(ctx, res.Link.ShortCode()); err != nil || link.OriginalURL != wantURL {
+				t.Errorf("Resolve(%s) = %+v, %v, want %s", res.Link.ShortCode(), link, err, wantURL)
+			}
+		}
+	}
+
+	if status.State != ImportDone || status.Lines != 300 || status.Created != 250 || status.Failed != 50 || status.FinishedAt == nil {
+		t.Errorf("Run() status = %+v, want 250 created and 50 failed", status)
+	}
+	if got, err := service.URLImportStatus(owner, imp.ID()); err != nil || got.State != ImportDone || got.Created != 250 {
+		t.Errorf("URLImportStatus() = %+v, %v, want the finished import", got, err)
+	}
+	if _, err := service.URLImportStatus(&User{ID: owner.ID + 1}, imp.ID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("URLImportStatus() of another user error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestURLImport_RunFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		next      func() (*ImportRow, error)
+		emit      error
+		wantError string
+	}{
+		{
+			name:      "malformed input",
+			next:      func() (*ImportRow, error) { return nil, fmt.Errorf("%w: line 7: token too long", ErrInvalidImport) },
+			wantError: "invalid import: line 7: token too long",
+		},
+		{
+			name:      "read error",
+			next:      func() (*ImportRow, error) { return nil, errors.New("connection reset") },
+			wantError: "internal error",
+		},
+		{
+			name: "emit error",
+			next: func() func() (*ImportRow, error) {
+				done := false
+				return func() (*ImportRow, error) {
+					if done {
+						return nil, io.EOF
+					}
+					done = true
+					return &ImportRow{Line: 1, URL: "https://example.com/"}, nil
+				}
+			}(),
+			emit:      errors.New("client gone"),
+			wantError: "internal error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(NewInMemoryRepository())
+			owner := &User{ID: 1}
+			imp, err := service.NewURLImport(owner)
+			if err != nil {
+				t.Fatalf("NewURLImport() error = %v", err)
+			}
+			status, err := imp.Run(context.Background(), tt.next, func([]ImportLineResult) error { return tt.emit })
+			if err == nil {
+				t.Fatal("Run() error = nil, want the failure")
+			}
+			if status.State != ImportFailed || status.Error != tt.wantError {
+				t.Errorf("Run() status = %+v, want failed with %q", status, tt.wantError)
+			}
+		})
+	}
+}
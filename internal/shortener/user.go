@@ -0,0 +1,497 @@
+package shortener
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// MinPasswordLength is the shortest password accepted by Signup.
+	MinPasswordLength = 8
+	// maxPasswordLength is bcrypt's input limit.
+	maxPasswordLength = 72
+	maxEmailLength    = 254
+
+	// apiKeyPrefix makes keys recognizable, e.g. by secret scanners.
+	apiKeyPrefix = "sk_"
+)
+
+// userColumns is the column list scanned by scanUser. Queries alias users as u.
+const userColumns = `u.id, u.email, u.password_hash, u.plan, u.role, u.created_at`
+
+// passwordCost is the bcrypt cost; tests lower it to keep hashing fast.
+var passwordCost = bcrypt.DefaultCost
+
+// dummyPasswordHash is compared against when logging in to an unknown email,
+// so that the response time does not tell which accounts exist. It is hashed
+// on first use, at the passwordCost in effect then.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a password"), passwordCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrWeakPassword       = fmt.Errorf("password must be between %d and %d characters", MinPasswordLength, maxPasswordLength)
+	ErrEmailTaken         = errors.New("email already registered")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrForbidden is returned when a user manages a link they do not own.
+	ErrForbidden = errors.New("link belongs to another user")
+)
+
+// User is an account that owns links.
+type User struct {
+	ID           uint64 `json:"id"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+	// Plan names the quota tier of the user (see WithPlans).
+	Plan string `json:"plan"`
+	// Role is what the user may do, one of the Role constants.
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	// Scopes limit what the API key the user authenticated with may do
+	// (see HasScope); nil allows everything.
+	Scopes []string `json:"-"`
+}
+
+// Signup registers a user and returns it together with a first API key.
+// The email is normalized to lower case.
+func (s *Service) Signup(ctx context.Context, email, password string) (*User, string, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(password) < MinPasswordLength || len(password) > maxPasswordLength {
+		return nil, "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	user := &User{Email: email, PasswordHash: string(hash), Plan: s.defaultPlan, Role: RoleEditor}
+	if err := s.repo.CreateUser(ctx, user); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, "", ErrEmailTaken
+		}
+		return nil, "", fmt.Errorf("failed to create user: %w", err)
+	}
+
+	_, key, err := s.issueAPIKey(ctx, user, AllScopes, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, key, nil
+}
+
+// Login checks email and password and issues a new API key.
+func (s *Service) Login(ctx context.Context, email, password string) (*User, string, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, "", ErrInvalidCredentials
+	}
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get user: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, "", ErrInvalidCredentials
+	}
+
+	_, key, err := s.issueAPIKey(ctx, user, AllScopes, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, key, nil
+}
+
+// Authenticate returns the user owning apiKey, with the scopes of the key.
+// Expired keys are rejected.
+func (s *Service) Authenticate(ctx context.Context, apiKey string) (*User, error) {
+	if !strings.HasPrefix(apiKey, apiKeyPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+	return s.authenticateKey(ctx, hashAPIKey(apiKey))
+}
+
+// authenticateKey resolves the API key with keyHash to its user, limited to
+// the scopes of the key.
+func (s *Service) authenticateKey(ctx context.Context, keyHash string) (*User, error) {
+	user, key, err := s.repo.GetUserByAPIKey(ctx, keyHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	now := s.now()
+	if key.expired(now) {
+		return nil, ErrInvalidCredentials
+	}
+	s.touchAPIKey(ctx, key, now)
+	user.Scopes = key.Scopes
+	return user, nil
+}
+
+// Identity is a user as vouched for by an external identity provider, e.g.
+// the verified claims of an OIDC token.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// AuthenticateIdentity returns the user linked to id. The first time a
+// subject is seen it is linked to the user with its email, which is created
+// without a password if there is none; this requires the provider to have
+// verified the email, as otherwise anyone could claim another user's links.
+func (s *Service) AuthenticateIdentity(ctx context.Context, id Identity) (*User, error) {
+	user, err := s.repo.LookupIdentity(ctx, id.Issuer, id.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	if !id.EmailVerified {
+		return nil, fmt.Errorf("%w: identity has no verified email", ErrInvalidCredentials)
+	}
+	email, err := normalizeEmail(id.Email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	user, err = s.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		user = &User{Email: email, Plan: s.defaultPlan, Role: RoleEditor}
+		err = s.repo.CreateUser(ctx, user)
+		if errors.Is(err, ErrConflict) {
+			// Signed up concurrently
+			user, err = s.repo.GetUserByEmail(ctx, email)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	err = s.repo.CreateIdentity(ctx, user.ID, id.Issuer, id.Subject)
+	if errors.Is(err, ErrConflict) {
+		// Linked concurrently, possibly to another user
+		return s.repo.LookupIdentity(ctx, id.Issuer, id.Subject)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+	return user, nil
+}
+
+// Logout revokes apiKey.
+func (s *Service) Logout(ctx context.Context, apiKey string) error {
+	if err := s.repo.DeleteAPIKey(ctx, hashAPIKey(apiKey)); err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return nil
+}
+
+// issueAPIKey creates a random API key for user with scopes, expiring at
+// expiresAt if set, and returns it with its secret. Only the SHA-256 hash of
+// the secret is stored: keys have enough entropy that a slow hash is
+// unnecessary.
+func (s *Service) issueAPIKey(ctx context.Context, user *User, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiKey := &APIKey{UserID: user.ID, KeyHash: hashAPIKey(key), Scopes: scopes, ExpiresAt: expiresAt}
+	if err := s.repo.CreateAPIKey(ctx, apiKey); err != nil {
+		return nil, "", fmt.Errorf("failed to store api key: %w", err)
+	}
+	return apiKey, key, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeEmail accepts a bare address (no display name) and lower-cases it.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
+
+// ListOwned returns a page of the links owned by user, newest first, in the
+// same way as List.
+func (s *Service) ListOwned(ctx context.Context, user *User, cursor uint64, limit int) ([]*Link, uint64, error) {
+	links, next, err := paginate(limit, func(n int) ([]*Link, error) {
+		return s.repo.ListByOwner(ctx, user.ID, cursor, n)
+	}, linkID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list urls: %w", err)
+	}
+	if err := s.attachHealth(ctx, links); err != nil {
+		return nil, 0, err
+	}
+	return links, next, nil
+}
+
+// LinkUpdate lists the attributes UpdateLink changes. Zero fields keep the
+// current value.
+type LinkUpdate struct {
+	// URL is the new destination.
+	URL string
+	// ExpiresAt is the new expiry; NoExpiry removes it instead.
+	ExpiresAt *time.Time
+	NoExpiry  bool
+	// MaxClicks is the new click limit; zero removes it.
+	MaxClicks *int64
+	// CampaignID moves the link to another campaign of its owner; zero
+	// takes it out of its campaign.
+	CampaignID *uint64
+	// Domain binds the link to another verified domain of its owner; ""
+	// serves it on any host.
+	Domain *string
+	// AnalyticsDisabled turns click tracking off or back on. Clicks
+	// recorded before are kept either way.
+	AnalyticsDisabled *bool
+	// OrgID gives the link to another organization of user; zero takes it
+	// back to its owner alone.
+	OrgID *uint64
+	// QueryPassthrough changes how the query string of redirect requests
+	// is carried over to the destination; QueryPassthroughOff stops it.
+	QueryPassthrough *QueryPassthrough
+	// Mask changes how the destination is hidden from visitors; MaskOff
+	// redirects them again.
+	Mask *Mask
+}
+
+// UpdateDestination points the link behind shortCode, which user must own,
+// at originalURL.
+func (s *Service) UpdateDestination(ctx context.Context, user *User, shortCode, originalURL string) (*Link, error) {
+	return s.UpdateLink(ctx, user, shortCode, LinkUpdate{URL: originalURL})
+}
+
+// UpdateLink changes the attributes of the link behind shortCode, which user
+// must own or manage through an organization, keeping its code. Returns
+// ErrInvalidExpiry if u.ExpiresAt is not in the future, ErrInvalidMaxClicks
+// for a negative u.MaxClicks, ErrInvalidCampaign if u.CampaignID is not a
+// campaign of user, ErrInvalidDomain if u.Domain is not a verified domain of
+// user, ErrInvalidOrg if user is not a member of u.OrgID,
+// ErrInvalidQueryPassthrough for an unknown u.QueryPassthrough and
+// ErrInvalidMask for an unknown or disabled u.Mask; a new URL is screened
+// like in ShortenWithOptions.
+func (s *Service) UpdateLink(ctx context.Context, user *User, shortCode string, u LinkUpdate) (*Link, error) {
+	if u.ExpiresAt != nil && !u.ExpiresAt.After(s.now()) {
+		return nil, ErrInvalidExpiry
+	}
+	if u.MaxClicks != nil && *u.MaxClicks < 0 {
+		return nil, ErrInvalidMaxClicks
+	}
+	if u.QueryPassthrough != nil {
+		if _, err := ParseQueryPassthrough(string(*u.QueryPassthrough)); err != nil {
+			return nil, err
+		}
+	}
+	if u.Mask != nil {
+		if err := s.checkMask(*u.Mask); err != nil {
+			return nil, err
+		}
+	}
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	var homograph string
+	if u.URL != "" {
+		if u.URL, homograph, err = s.checkHomograph(u.URL); err != nil {
+			return nil, err
+		}
+		if u.URL, err = s.normalization.Apply(u.URL); err != nil {
+			return nil, err
+		}
+		if err := s.screen(ctx, u.URL); err != nil {
+			return nil, err
+		}
+	}
+	if u.CampaignID != nil && *u.CampaignID != 0 {
+		if err := s.checkCampaign(ctx, user, *u.CampaignID); err != nil {
+			return nil, err
+		}
+	}
+	if u.OrgID != nil && *u.OrgID != 0 {
+		if err := s.checkOrg(ctx, user, *u.OrgID); err != nil {
+			return nil, err
+		}
+	}
+	var domain string
+	if u.Domain != nil && *u.Domain != "" {
+		if domain, err = s.checkDomain(ctx, user, *u.Domain); err != nil {
+			return nil, err
+		}
+	}
+
+	// The audit detail names what changed, the destination first
+	var changes []string
+	oldURL := link.OriginalURL
+	if u.URL != "" {
+		link.OriginalURL = u.URL
+		changes = append(changes, u.URL)
+	}
+	if u.NoExpiry {
+		link.ExpiresAt = nil
+		changes = append(changes, "no expiry")
+	} else if u.ExpiresAt != nil {
+		link.ExpiresAt = u.ExpiresAt
+		changes = append(changes, "expires "+u.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if u.MaxClicks != nil {
+		link.MaxClicks = *u.MaxClicks
+		changes = append(changes, fmt.Sprintf("max clicks %d", *u.MaxClicks))
+	}
+	if u.CampaignID != nil {
+		link.CampaignID = *u.CampaignID
+		changes = append(changes, fmt.Sprintf("campaign %d", *u.CampaignID))
+	}
+	if u.Domain != nil {
+		link.Domain = domain
+		changes = append(changes, "domain "+cmp.Or(domain, "none"))
+	}
+	if u.AnalyticsDisabled != nil {
+		link.AnalyticsDisabled = *u.AnalyticsDisabled
+		changes = append(changes, fmt.Sprintf("analytics disabled %t", *u.AnalyticsDisabled))
+	}
+	if u.OrgID != nil {
+		link.OrgID = *u.OrgID
+		changes = append(changes, fmt.Sprintf("organization %d", *u.OrgID))
+	}
+	if u.QueryPassthrough != nil {
+		link.QueryPassthrough = *u.QueryPassthrough
+		changes = append(changes, "query passthrough "+cmp.Or(string(*u.QueryPassthrough), "off"))
+	}
+	if u.Mask != nil {
+		link.Mask = *u.Mask
+		changes = append(changes, "mask "+cmp.Or(string(*u.Mask), "off"))
+	}
+	if err := s.repo.Update(ctx, link); err != nil {
+		return nil, fmt.Errorf("failed to update url: %w", err)
+	}
+	s.audit(ctx, UserActor(user), AuditUpdate, link.ShortCode(), strings.Join(changes, ", "))
+	if link.OriginalURL != oldURL {
+		s.recordChange(ctx, link, UserActor(user), oldURL)
+	}
+	if homograph != "" && s.homographs == HomographFlag {
+		s.holdHomograph(ctx, link.ShortCode(), homograph)
+	}
+	if u.URL != "" {
+		s.queuePage(ctx, link)
+	}
+	return link, nil
+}
+
+// Delete soft-deletes the link behind shortCode, which user must own. It can
+// be brought back with Restore.
+func (s *Service) Delete(ctx context.Context, user *User, shortCode string) error {
+	link, err := s.owned(ctx, user, shortCode)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, link); err != nil {
+		return fmt.Errorf("failed to delete url: %w", err)
+	}
+	s.audit(ctx, UserActor(user), AuditDelete, link.ShortCode(), "")
+	return nil
+}
+
+// Restore brings back the deleted link behind shortCode, which user must own.
+// Deleted links of other users are reported as ErrNotFound, like any other
+// deleted link. Returns a *QuotaError if the link would not fit in the user's
+// plan any more; free-form codes count as custom aliases, base62 ones do not.
+func (s *Service) Restore(ctx context.Context, user *User, shortCode string) (*Link, error) {
+	key := &Link{}
+	if id, ok := idFromCode(shortCode); ok {
+		key.ID = id
+	} else if ValidateAlias(shortCode) != nil {
+		return nil, ErrInvalidShortCode
+	} else {
+		key.Code = shortCode
+	}
+	if err := s.checkRestoreQuota(ctx, user, key.Code != ""); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Restore(ctx, key, user.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to restore url: %w", err)
+	}
+	s.audit(ctx, UserActor(user), AuditRestore, shortCode, "")
+	return s.Resolve(ctx, shortCode)
+}
+
+// OwnedLink returns the link behind shortCode if user may manage it.
+func (s *Service) OwnedLink(ctx context.Context, user *User, shortCode string) (*Link, error) {
+	return s.owned(ctx, user, shortCode)
+}
+
+// owned resolves shortCode and checks that user may manage the link: the
+// links of an organization are managed by its current members, even the ones
+// its creator left behind, and other links by their owner.
+func (s *Service) owned(ctx context.Context, user *User, shortCode string) (*Link, error) {
+	link, err := s.Resolve(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if link.OrgID != 0 {
+		member, err := s.repo.IsOrgMember(ctx, link.OrgID, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check membership: %w", err)
+		}
+		if member {
+			return link, nil
+		}
+		return nil, ErrForbidden
+	}
+	if link.OwnerID == 0 || link.OwnerID != user.ID {
+		return nil, ErrForbidden
+	}
+	return link, nil
+}
+
+// scanUser scans a row selected with userColumns.
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	if err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Plan, &user.Role, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,349 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	passwordCost = bcrypt.MinCost
+}
+
+func TestService_SignupAndLogin(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	user, key, err := service.Signup(ctx, " Alice@Example.com ", "correct horse")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	if user.Email != "alice@example.com" {
+		t.Errorf("Signup() email = %q, want normalized alice@example.com", user.Email)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		t.Errorf("Signup() key = %q, want %s prefix", key, apiKeyPrefix)
+	}
+	if got, err := service.Authenticate(ctx, key); err != nil || got.ID != user.ID {
+		t.Errorf("Authenticate() = %+v, %v, want user %d", got, err, user.ID)
+	}
+
+	if _, _, err := service.Signup(ctx, "alice@example.com", "another password"); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Signup() duplicate error = %v, want ErrEmailTaken", err)
+	}
+
+	_, loginKey, err := service.Login(ctx, "ALICE@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("Login() unexpected error = %v", err)
+	}
+	if loginKey == key {
+		t.Error("Login() reused the signup key, want a new one")
+	}
+	if _, _, err := service.Login(ctx, "alice@example.com", "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login() wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+	if _, _, err := service.Login(ctx, "bob@example.com", "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login() unknown user error = %v, want ErrInvalidCredentials", err)
+	}
+	// Unknown users pay for a bcrypt comparison too, at the same cost
+	if cost, err := bcrypt.Cost(dummyPasswordHash()); err != nil || cost != passwordCost {
+		t.Errorf("dummyPasswordHash() cost = %d, %v, want %d", cost, err, passwordCost)
+	}
+
+	if err := service.Logout(ctx, loginKey); err != nil {
+		t.Fatalf("Logout() unexpected error = %v", err)
+	}
+	if _, err := service.Authenticate(ctx, loginKey); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() revoked key error = %v, want ErrInvalidCredentials", err)
+	}
+	// Other keys stay valid
+	if _, err := service.Authenticate(ctx, key); err != nil {
+		t.Errorf("Authenticate() signup key after logout error = %v", err)
+	}
+}
+
+func TestService_SignupValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantErr  error
+	}{
+		{"empty email", "", "password1", ErrInvalidEmail},
+		{"missing domain", "alice", "password1", ErrInvalidEmail},
+		{"display name", "Alice <alice@example.com>", "password1", ErrInvalidEmail},
+		{"too long email", strings.Repeat("a", 250) + "@example.com", "password1", ErrInvalidEmail},
+		{"short password", "alice@example.com", "short", ErrWeakPassword},
+		{"long password", "alice@example.com", strings.Repeat("p", 73), ErrWeakPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(NewInMemoryRepository())
+			if _, _, err := service.Signup(context.Background(), tt.email, tt.password); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Signup() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_Authenticate_MalformedKey(t *testing.T) {
+	repo := &MockRepository{
+		GetUserByAPIKeyFunc: func(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+			t.Error("GetUserByAPIKey() called for a key without the sk_ prefix")
+			return nil, nil, ErrNotFound
+		},
+	}
+	if _, err := NewService(repo).Authenticate(context.Background(), "admin-token"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestService_AuthenticateIdentity(t *testing.T) {
+	repos := map[string]Repository{
+		"memory": NewInMemoryRepository(),
+		"sqlite": newTestSQLiteRepository(t),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(repo)
+			ctx := context.Background()
+			alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+			if err != nil {
+				t.Fatalf("Signup() unexpected error = %v", err)
+			}
+
+			// A verified email links the subject to the existing user
+			id := Identity{Issuer: "https://idp.example.com", Subject: "u1", Email: "Alice@Example.com", EmailVerified: true}
+			if got, err := service.AuthenticateIdentity(ctx, id); err != nil || got.ID != alice.ID {
+				t.Fatalf("AuthenticateIdentity() = %+v, %v, want user %d", got, err, alice.ID)
+			}
+			// Later tokens are matched by subject, even if the email changed
+			id.Email, id.EmailVerified = "alice@corp.example.com", false
+			if got, err := service.AuthenticateIdentity(ctx, id); err != nil || got.ID != alice.ID {
+				t.Errorf("AuthenticateIdentity() known subject = %+v, %v, want user %d", got, err, alice.ID)
+			}
+			// The same subject of another issuer is another identity
+			other := Identity{Issuer: "https://other.example.com", Subject: "u1", Email: "alice@example.com"}
+			if _, err := service.AuthenticateIdentity(ctx, other); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("AuthenticateIdentity() unverified email error = %v, want ErrInvalidCredentials", err)
+			}
+
+			// New emails get a user without a password
+			bob := Identity{Issuer: "https://idp.example.com", Subject: "u2", Email: "bob@example.com", EmailVerified: true}
+			user, err := service.AuthenticateIdentity(ctx, bob)
+			if err != nil || user.ID == alice.ID || user.Email != "bob@example.com" {
+				t.Fatalf("AuthenticateIdentity() new user = %+v, %v", user, err)
+			}
+			if _, _, err := service.Login(ctx, "bob@example.com", ""); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Login() passwordless user error = %v, want ErrInvalidCredentials", err)
+			}
+			if err := repo.CreateIdentity(ctx, alice.ID, bob.Issuer, bob.Subject); !errors.Is(err, ErrConflict) {
+				t.Errorf("CreateIdentity() linked subject error = %v, want ErrConflict", err)
+			}
+		})
+	}
+}
+
+func TestService_Ownership(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo)
+	ctx := context.Background()
+
+	alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	bob, _, err := service.Signup(ctx, "bob@example.com", "password2")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	anonymous, err := service.Shorten(ctx, "https://example.com/anonymous")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+
+	links, _, err := service.ListOwned(ctx, alice, 0, 10)
+	if err != nil {
+		t.Fatalf("ListOwned() unexpected error = %v", err)
+	}
+	if len(links) != 1 || links[0].ShortCode() != code {
+		t.Errorf("ListOwned() = %+v, want only %s", links, code)
+	}
+	if links, _, _ := service.ListOwned(ctx, bob, 0, 10); len(links) != 0 {
+		t.Errorf("ListOwned() for bob = %+v, want none", links)
+	}
+
+	if _, err := service.UpdateDestination(ctx, bob, code, "https://evil.example"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("UpdateDestination() by non-owner error = %v, want ErrForbidden", err)
+	}
+	if err := service.Delete(ctx, bob, code); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Delete() by non-owner error = %v, want ErrForbidden", err)
+	}
+	if err := service.Delete(ctx, alice, anonymous); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Delete() of anonymous link error = %v, want ErrForbidden", err)
+	}
+
+	if _, err := service.UpdateDestination(ctx, alice, code, "https://example.com/b"); err != nil {
+		t.Fatalf("UpdateDestination() unexpected error = %v", err)
+	}
+	if got, _ := service.Redirect(ctx, code); got != "https://example.com/b" {
+		t.Errorf("Redirect() after update = %q, want https://example.com/b", got)
+	}
+
+	if err := service.Delete(ctx, alice, code); err != nil {
+		t.Fatalf("Delete() unexpected error = %v", err)
+	}
+	if _, err := service.Redirect(ctx, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Redirect() after delete error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := service.Restore(ctx, bob, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() by non-owner error = %v, want ErrNotFound", err)
+	}
+	if _, err := service.Restore(ctx, alice, "not/valid"); !errors.Is(err, ErrInvalidShortCode) {
+		t.Errorf("Restore() invalid code error = %v, want ErrInvalidShortCode", err)
+	}
+	restored, err := service.Restore(ctx, alice, code)
+	if err != nil {
+		t.Fatalf("Restore() unexpected error = %v", err)
+	}
+	if restored.OriginalURL != "https://example.com/b" {
+		t.Errorf("Restore() OriginalURL = %q, want https://example.com/b", restored.OriginalURL)
+	}
+	if got, _ := service.Redirect(ctx, code); got != "https://example.com/b" {
+		t.Errorf("Redirect() after restore = %q, want https://example.com/b", got)
+	}
+	if _, err := service.Restore(ctx, alice, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() of live link error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestService_AdminDelete(t *testing.T) {
+	service := NewService(NewInMemoryRepository())
+	ctx := context.Background()
+
+	alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/a", ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	if err := service.AdminDelete(ctx, code); err != nil {
+		t.Fatalf("AdminDelete() unexpected error = %v", err)
+	}
+	if _, err := service.Redirect(ctx, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Redirect() after delete error = %v, want ErrNotFound", err)
+	}
+	if err := service.AdminDelete(ctx, code); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AdminDelete() of deleted link error = %v, want ErrNotFound", err)
+	}
+	entries, _, err := service.ListAudit(ctx, AuditFilter{Actor: ActorAdmin}, 0, 10)
+	if err != nil || len(entries) != 1 || entries[0].Action != AuditDelete || entries[0].Code != code {
+		t.Errorf("admin audit entries = %+v, %v, want the delete of %s", entries, err, code)
+	}
+
+	// The owner can still undo it
+	if _, err := service.Restore(ctx, alice, code); err != nil {
+		t.Errorf("Restore() unexpected error = %v", err)
+	}
+}
+
+func TestService_UpdateDestinationScreensURL(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo, WithURLChecker(&stubChecker{threats: map[string]string{"https://malware.example": "MALWARE"}}))
+	ctx := context.Background()
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+	if _, err := service.UpdateDestination(ctx, user, code, "https://malware.example"); !errors.Is(err, ErrUnsafeURL) {
+		t.Errorf("UpdateDestination() error = %v, want ErrUnsafeURL", err)
+	}
+}
+
+func TestService_UpdateLink(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo)
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	user, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com", ShortenOptions{Owner: user})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	expiresAt := now.Add(time.Hour)
+	maxClicks := int64(5)
+	link, err := service.UpdateLink(ctx, user, code, LinkUpdate{ExpiresAt: &expiresAt, MaxClicks: &maxClicks})
+	if err != nil {
+		t.Fatalf("UpdateLink() unexpected error = %v", err)
+	}
+	if link.OriginalURL != "https://example.com" || link.ExpiresAt == nil || !link.ExpiresAt.Equal(expiresAt) || link.MaxClicks != 5 {
+		t.Errorf("UpdateLink() = %+v, want same URL, new expiry and 5 max clicks", link)
+	}
+
+	// Zero fields keep what was set before
+	link, err = service.UpdateLink(ctx, user, code, LinkUpdate{URL: "https://example.com/new"})
+	if err != nil {
+		t.Fatalf("UpdateLink() unexpected error = %v", err)
+	}
+	if link.ExpiresAt == nil || link.MaxClicks != 5 {
+		t.Errorf("UpdateLink() = %+v, want expiry and click limit kept", link)
+	}
+
+	unlimited := int64(0)
+	if _, err := service.UpdateLink(ctx, user, code, LinkUpdate{NoExpiry: true, MaxClicks: &unlimited}); err != nil {
+		t.Fatalf("UpdateLink() unexpected error = %v", err)
+	}
+	if link, _ = service.Resolve(ctx, code); link.ExpiresAt != nil || link.MaxClicks != 0 {
+		t.Errorf("stored link = %+v, want no expiry and no click limit", link)
+	}
+
+	past := now.Add(-time.Hour)
+	if _, err := service.UpdateLink(ctx, user, code, LinkUpdate{ExpiresAt: &past}); !errors.Is(err, ErrInvalidExpiry) {
+		t.Errorf("UpdateLink() past expiry error = %v, want ErrInvalidExpiry", err)
+	}
+	negative := int64(-1)
+	if _, err := service.UpdateLink(ctx, user, code, LinkUpdate{MaxClicks: &negative}); !errors.Is(err, ErrInvalidMaxClicks) {
+		t.Errorf("UpdateLink() negative max clicks error = %v, want ErrInvalidMaxClicks", err)
+	}
+
+	entries, _, err := service.ListAudit(ctx, AuditFilter{Code: code}, 0, 10)
+	if err != nil {
+		t.Fatalf("ListAudit() unexpected error = %v", err)
+	}
+	var details []string
+	for _, e := range entries {
+		if e.Action == AuditUpdate {
+			details = append(details, e.Detail)
+		}
+	}
+	want := []string{"no expiry, max clicks 0", "https://example.com/new", "expires 2024-01-01T01:00:00Z, max clicks 5"}
+	if !slices.Equal(details, want) {
+		t.Errorf("update audit details = %q, want %q", details, want)
+	}
+}
@@ -0,0 +1,67 @@
+package shortener
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// UTM holds campaign tags appended to a destination when it is shortened.
+// Empty fields are left out.
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+}
+
+// IsZero reports whether no tag is set.
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+func (u UTM) params() [][2]string {
+	return [][2]string{
+		{"utm_source", u.Source},
+		{"utm_medium", u.Medium},
+		{"utm_campaign", u.Campaign},
+	}
+}
+
+// AddUTM returns rawURL with the set tags of utm appended to its query.
+// Existing parameters with the same names are replaced; the rest of the
+// query, including its order and encoding, is preserved.
+func AddUTM(rawURL string, utm UTM) (string, error) {
+	if utm.IsZero() {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	set := make(map[string]struct{})
+	var tags []string
+	for _, p := range utm.params() {
+		if p[1] == "" {
+			continue
+		}
+		set[p[0]] = struct{}{}
+		tags = append(tags, p[0]+"="+url.QueryEscape(p[1]))
+	}
+
+	var query []string
+	if u.RawQuery != "" {
+		for _, pair := range strings.Split(u.RawQuery, "&") {
+			key, _, _ := strings.Cut(pair, "=")
+			if name, err := url.QueryUnescape(key); err == nil {
+				if _, ok := set[name]; ok {
+					continue
+				}
+			}
+			query = append(query, pair)
+		}
+	}
+	u.RawQuery = strings.Join(append(query, tags...), "&")
+	u.ForceQuery = false
+	return u.String(), nil
+}
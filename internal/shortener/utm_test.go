@@ -0,0 +1,78 @@
+package shortener
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAddUTM(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		utm  UTM
+		want string
+	}{
+		{
+			name: "no tags",
+			url:  "https://example.com/sale?b=2&a=1",
+			want: "https://example.com/sale?b=2&a=1",
+		},
+		{
+			name: "all tags",
+			url:  "https://example.com/sale",
+			utm:  UTM{Source: "newsletter", Medium: "email", Campaign: "spring sale"},
+			want: "https://example.com/sale?utm_source=newsletter&utm_medium=email&utm_campaign=spring+sale",
+		},
+		{
+			name: "existing query and fragment kept",
+			url:  "https://example.com/sale?b=2&a=1#top",
+			utm:  UTM{Source: "twitter"},
+			want: "https://example.com/sale?b=2&a=1&utm_source=twitter#top",
+		},
+		{
+			name: "existing tag replaced",
+			url:  "https://example.com/sale?utm_source=old&utm_medium=cpc",
+			utm:  UTM{Source: "new"},
+			want: "https://example.com/sale?utm_medium=cpc&utm_source=new",
+		},
+		{
+			name: "special characters escaped",
+			url:  "https://example.com/",
+			utm:  UTM{Campaign: "a&b=c"},
+			want: "https://example.com/?utm_campaign=a%26b%3Dc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AddUTM(tt.url, tt.utm)
+			if err != nil {
+				t.Fatalf("AddUTM() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("AddUTM() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_ShortenWithUTM(t *testing.T) {
+	repo := NewInMemoryRepository()
+	service := NewService(repo)
+	ctx := context.Background()
+
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/sale?ref=home", ShortenOptions{
+		UTM: UTM{Source: "newsletter", Medium: "email"},
+	})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+	}
+
+	link, err := service.Resolve(ctx, code)
+	if err != nil {
+		t.Fatalf("Resolve() unexpected error = %v", err)
+	}
+	if want := "https://example.com/sale?ref=home&utm_source=newsletter&utm_medium=email"; link.OriginalURL != want {
+		t.Errorf("Resolve() OriginalURL = %q, want %q", link.OriginalURL, want)
+	}
+}
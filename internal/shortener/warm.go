@@ -0,0 +1,138 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// warmBatchSize is the number of links WarmCache loads per query and
+// writes per Redis pipeline.
+const warmBatchSize = 1000
+
+// WithCacheWarming prepares the repository for WarmCache to run every
+// interval: warmed entries live for two intervals, so that every link stays
+// cached through one failed pass and redirects need not reach the database.
+func WithCacheWarming(interval time.Duration) PostgresOption {
+	return func(r *PostgresRedisRepository) {
+		r.warmTTL = 2 * interval
+	}
+}
+
+// WarmCache caches every link that is neither deleted nor expired in Redis,
+// under its ID and, for free-form codes, its code, and returns how many it
+// cached. Disabled and flagged links are cached too, so that redirects can
+// refuse them without the database.
+//
+// Links are read in batches of warmBatchSize by keyset pagination, so a pass
+// over millions of links holds no long transaction. Links created or edited
+// since the last pass are read through the cache as usual.
+func (r *PostgresRedisRepository) WarmCache(ctx context.Context) (int, error) {
+	if r.redis == nil {
+		return 0, nil
+	}
+	ttl := func() time.Duration { return r.warmTTL }
+	if r.warmTTL <= 0 {
+		ttl = r.jitteredTTL
+	}
+
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE id > $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > $2) ORDER BY id LIMIT $3`
+	total := 0
+	var cursor uint64
+	for {
+		rows, err := r.db.QueryContext(ctx, query, cursor, time.Now(), warmBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to load urls to warm: %w", err)
+		}
+		links, err := scanLinks(rows, warmBatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(links) == 0 {
+			break
+		}
+		if err := r.loadTargets(ctx, links); err != nil {
+			return total, err
+		}
+		if err := r.cacheLinks(ctx, links, ttl); err != nil {
+			return total, err
+		}
+		total += len(links)
+		cursor = links[len(links)-1].ID
+		if len(links) < warmBatchSize {
+			break
+		}
+	}
+	lastWarm.Store(time.Now().Unix())
+	cacheMetrics.Add("warmed", int64(total))
+	return total, nil
+}
+
+// loadTargets fills in the Targets of links with a single query.
+func (r *PostgresRedisRepository) loadTargets(ctx context.Context, links []*Link) error {
+	byID := make(map[uint64]*Link, len(links))
+	ids := make([]int64, len(links))
+	for i, link := range links {
+		byID[link.ID] = link
+		ids[i] = int64(link.ID)
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT url_id, country, url FROM link_targets WHERE url_id = ANY($1) ORDER BY url_id, country`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get targets to warm: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		var t Target
+		if err := rows.Scan(&id, &t.Country, &t.URL); err != nil {
+			return fmt.Errorf("failed to scan target: %w", err)
+		}
+		if link := byID[id]; link != nil {
+			link.Targets = append(link.Targets, t)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read targets: %w", err)
+	}
+	return nil
+}
+
+// cacheLinks writes links to Redis for the TTLs returned by ttl, and their
+// stale copies if enabled, in one pipeline. Unlike setCached, it skips the
+// local cache, which only holds what this instance serves.
+func (r *PostgresRedisRepository) cacheLinks(ctx context.Context, links []*Link, ttl func() time.Duration) error {
+	err := r.retry.do(ctx, "redis", transientRedis, func() error {
+		_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, link := range links {
+				linkTTL := r.cappedTTL(ttl(), link)
+				if linkTTL <= 0 {
+					continue
+				}
+				val, err := json.Marshal(link)
+				if err != nil {
+					return fmt.Errorf("failed to encode link %d: %w", link.ID, err)
+				}
+				keys := []string{idCacheKey(link.ID)}
+				if link.Code != "" {
+					keys = append(keys, codeCacheKey(link.Code))
+				}
+				for _, key := range keys {
+					pipe.Set(ctx, key, val, linkTTL)
+					if r.staleTTL > 0 {
+						pipe.Set(ctx, staleCacheKey(key), val, r.cappedTTL(r.staleTTL, link))
+					}
+				}
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to warm cache: %w", err)
+	}
+	return nil
+}
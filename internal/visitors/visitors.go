@@ -0,0 +1,139 @@
+// Package visitors estimates unique visitors with Redis HyperLogLogs.
+package visitors
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "shorturl:visitors:"
+	// retention keeps daily HyperLogLogs a little longer than the longest
+	// stats period (shortener.MaxStatsPeriod).
+	retention = 100 * 24 * time.Hour
+)
+
+// RedisCounter counts distinct visitors per UTC day in HyperLogLogs (up to
+// 12 KB each): one for all links and one for each link clicked that day. It
+// implements shortener.VisitorCounter.
+type RedisCounter struct {
+	client *redis.Client
+}
+
+// NewRedisCounter returns a counter storing its HyperLogLogs in client.
+func NewRedisCounter(client *redis.Client) *RedisCounter {
+	return &RedisCounter{client: client}
+}
+
+func dayKey(t time.Time) string {
+	return keyPrefix + t.UTC().Format(time.DateOnly)
+}
+
+func linkDayKey(linkID uint64, t time.Time) string {
+	return keyPrefix + strconv.FormatUint(linkID, 10) + ":" + t.UTC().Format(time.DateOnly)
+}
+
+// days returns the starts of the UTC days overlapping [from, to).
+func days(from, to time.Time) []time.Time {
+	var days []time.Time
+	y, m, d := from.UTC().Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC); day.Before(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// Add records visitor in the HyperLogLogs of the day of at.
+func (c *RedisCounter) Add(ctx context.Context, at time.Time, linkID uint64, visitor string) error {
+	pipe := c.client.Pipeline()
+	for _, key := range []string{dayKey(at), linkDayKey(linkID, at)} {
+		pipe.PFAdd(ctx, key, visitor)
+		pipe.Expire(ctx, key, retention)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to add visitor: %w", err)
+	}
+	return nil
+}
+
+// Count merges the HyperLogLogs of the UTC days overlapping [from, to).
+func (c *RedisCounter) Count(ctx context.Context, from, to time.Time) (int64, error) {
+	var keys []string
+	for _, day := range days(from, to) {
+		keys = append(keys, dayKey(day))
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	n, err := c.client.PFCount(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count visitors: %w", err)
+	}
+	return n, nil
+}
+
+// CountLink merges the HyperLogLogs of a link for the UTC days overlapping
+// [from, to), and counts each of them.
+func (c *RedisCounter) CountLink(ctx context.Context, linkID uint64, from, to time.Time) (int64, []int64, error) {
+	days := days(from, to)
+	if len(days) == 0 {
+		return 0, nil, nil
+	}
+	keys := make([]string, len(days))
+	for i, day := range days {
+		keys[i] = linkDayKey(linkID, day)
+	}
+
+	pipe := c.client.Pipeline()
+	total := pipe.PFCount(ctx, keys...)
+	counts := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		counts[i] = pipe.PFCount(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, nil, fmt.Errorf("failed to count link visitors: %w", err)
+	}
+	daily := make([]int64, len(counts))
+	for i, cmd := range counts {
+		daily[i] = cmd.Val()
+	}
+	return total.Val(), daily, nil
+}
+
+// Forget deletes the HyperLogLogs of the link with ID linkID for the days
+// still kept. The HyperLogLogs of all links keep counting its visitors.
+func (c *RedisCounter) Forget(ctx context.Context, linkID uint64) error {
+	now := time.Now()
+	var keys []string
+	for _, day := range days(now.Add(-retention), now.Add(24*time.Hour)) {
+		keys = append(keys, linkDayKey(linkID, day))
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to forget link visitors: %w", err)
+	}
+	return nil
+}
+
+// CountLinks merges the HyperLogLogs of the links with IDs linkIDs for the
+// UTC days overlapping [from, to).
+func (c *RedisCounter) CountLinks(ctx context.Context, linkIDs []uint64, from, to time.Time) (int64, error) {
+	days := days(from, to)
+	keys := make([]string, 0, len(linkIDs)*len(days))
+	for _, id := range linkIDs {
+		for _, day := range days {
+			keys = append(keys, linkDayKey(id, day))
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	n, err := c.client.PFCount(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count links visitors: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,126 @@
+package visitors
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// about reports whether the estimate got is within the error of a
+// HyperLogLog, whose standard error is 0.81%, of want.
+func about(got, want int64) bool {
+	return got >= want*98/100 && got <= want*102/100+1
+}
+
+func TestRedisCounter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	c := NewRedisCounter(client)
+	ctx := context.Background()
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	add := func(at time.Time, linkID uint64, visitor string) {
+		t.Helper()
+		if err := c.Add(ctx, at, linkID, visitor); err != nil {
+			t.Fatalf("Add() unexpected error = %v", err)
+		}
+	}
+	for i := range 50 {
+		add(day.Add(time.Duration(i)*time.Minute), uint64(i%2), fmt.Sprintf("v%d", i))
+	}
+	add(day.Add(time.Hour), 1, "v0")
+	add(day.Add(25*time.Hour), 0, "v0")
+	add(day.Add(26*time.Hour), 1, "w")
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     int64
+	}{
+		{"first day", day, day.AddDate(0, 0, 1), 50},
+		{"partial day counts the whole day", day.Add(12 * time.Hour), day.Add(13 * time.Hour), 50},
+		{"second day", day.AddDate(0, 0, 1), day.AddDate(0, 0, 2), 2},
+		{"both days merge repeat visitors", day, day.AddDate(0, 0, 2), 51},
+		{"no visitors", day.AddDate(0, 0, 5), day.AddDate(0, 0, 6), 0},
+		{"empty range", day, day, 0},
+	}
+	for _, tt := range tests {
+		if got, err := c.Count(ctx, tt.from, tt.to); err != nil || !about(got, tt.want) {
+			t.Errorf("%s: Count() = %d, %v, want about %d", tt.name, got, err, tt.want)
+		}
+	}
+	for _, key := range []string{dayKey(day), linkDayKey(1, day)} {
+		if ttl := mr.TTL(key); ttl != retention {
+			t.Errorf("TTL of %s = %v, want %v", key, ttl, retention)
+		}
+	}
+
+	// Link 0 had v0, v2, ..., v48 on the first day and v0 again the next
+	total, daily, err := c.CountLink(ctx, 0, day.Add(12*time.Hour), day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatalf("CountLink() unexpected error = %v", err)
+	}
+	if !about(total, 25) || len(daily) != 3 || !about(daily[0], 25) || daily[1] != 1 || daily[2] != 0 {
+		t.Errorf("CountLink(0) = %d, %v, want 25, [25 1 0]", total, daily)
+	}
+	// Link 1 had v1, v3, ..., v49 and v0 on the first day and w the next
+	if total, daily, err := c.CountLink(ctx, 1, day, day.AddDate(0, 0, 2)); err != nil || !about(total, 27) || len(daily) != 2 || !about(daily[0], 26) || daily[1] != 1 {
+		t.Errorf("CountLink(1) = %d, %v, %v, want 27, [26 1]", total, daily, err)
+	}
+	if total, daily, err := c.CountLink(ctx, 1, day, day); err != nil || total != 0 || len(daily) != 0 {
+		t.Errorf("CountLink() of an empty range = %d, %v, %v, want 0, []", total, daily, err)
+	}
+
+	// Together they had v0, ..., v49 and w
+	if got, err := c.CountLinks(ctx, []uint64{0, 1}, day, day.AddDate(0, 0, 2)); err != nil || !about(got, 51) {
+		t.Errorf("CountLinks(0, 1) = %d, %v, want about 51", got, err)
+	}
+	if got, err := c.CountLinks(ctx, nil, day, day.AddDate(0, 0, 2)); err != nil || got != 0 {
+		t.Errorf("CountLinks() without links = %d, %v, want 0", got, err)
+	}
+
+	mr.Close()
+	if _, _, err := c.CountLink(ctx, 1, day, day.AddDate(0, 0, 1)); err == nil {
+		t.Error("CountLink() with Redis down succeeded, want error")
+	}
+	if err := c.Add(ctx, day, 1, "x"); err == nil {
+		t.Error("Add() with Redis down succeeded, want error")
+	}
+}
+
+func TestRedisCounter_Forget(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	c := NewRedisCounter(client)
+	ctx := context.Background()
+	now := time.Now()
+	for _, visit := range []struct {
+		at     time.Time
+		linkID uint64
+	}{{now, 0}, {now, 1}, {now.AddDate(0, 0, -30), 1}} {
+		if err := c.Add(ctx, visit.at, visit.linkID, "v"); err != nil {
+			t.Fatalf("Add() unexpected error = %v", err)
+		}
+	}
+	if err := c.Forget(ctx, 1); err != nil {
+		t.Fatalf("Forget() unexpected error = %v", err)
+	}
+
+	from, to := now.AddDate(0, 0, -31), now.Add(time.Hour)
+	if n, _, err := c.CountLink(ctx, 1, from, to); err != nil || n != 0 {
+		t.Errorf("CountLink() of the forgotten link = %d, %v, want 0", n, err)
+	}
+	if n, _, err := c.CountLink(ctx, 0, from, to); err != nil || n != 1 {
+		t.Errorf("CountLink() of another link = %d, %v, want 1", n, err)
+	}
+	if n, err := c.Count(ctx, now, to); err != nil || n != 1 {
+		t.Errorf("Count() = %d, %v, want the visitor still counted", n, err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) for feeds that push messages to clients. Pushing text messages
+// and answering pings and closes is simple enough not to need a library.
+// Extensions such as compression are not negotiated, and the messages
+// clients send are discarded.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the key of the client to compute the accept
+// header of the handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes.
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+const (
+	// writeTimeout bounds a single write to the client.
+	writeTimeout = 10 * time.Second
+	// maxMessage is the largest message accepted from clients.
+	maxMessage = 64 << 10
+)
+
+// ErrBadHandshake is returned by Upgrade for requests that are not
+// WebSocket handshakes.
+var ErrBadHandshake = errors.New("not a websocket handshake")
+
+// Conn is a WebSocket connection. Its methods may be called concurrently.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu        sync.Mutex
+	closeSent bool
+}
+
+// Upgrade completes the WebSocket handshake of r and takes over its
+// connection. It writes nothing if the handshake is invalid, so that the
+// caller can respond with 400 to ErrBadHandshake. Errors wrapping
+// http.ErrHijacked come after the connection was taken over and closed,
+// leaving nothing to respond to.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("%w: method %s", ErrBadHandshake, r.Method)
+	}
+	if !hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("%w: missing upgrade headers", ErrBadHandshake)
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, v)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, fmt.Errorf("%w: invalid key", ErrBadHandshake)
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over connection: %w", err)
+	}
+	// The server's read and write timeouts would otherwise cut the
+	// connection
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to clear deadline: %w", err), http.ErrHijacked, conn.Close())
+	}
+	c := &Conn{conn: conn, r: rw.Reader}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if err := c.write([]byte(resp)); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to complete handshake: %w", err), http.ErrHijacked, conn.Close())
+	}
+	return c, nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept header for the key of a
+// client.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma-separated header name contains token,
+// ignoring case.
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText sends data as a text message.
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Ping sends a ping, which keeps proxies from closing an idle connection.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame, unless one was sent already, and closes the
+// connection.
+func (c *Conn) Close() error {
+	err := c.writeClose(closeNormal)
+	return errors.Join(err, c.conn.Close())
+}
+
+// Discard reads messages from the client until it closes the connection,
+// answering pings and discarding everything else. It returns nil once the
+// client has closed the connection cleanly.
+func (c *Conn) Discard() error {
+	var messageSize int64
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errTooBig) {
+				return errors.Join(err, c.writeClose(closeTooBig))
+			}
+			if errors.Is(err, errProtocol) {
+				return errors.Join(err, c.writeClose(closeProtocolError))
+			}
+			return err
+		}
+		switch op {
+		case opClose:
+			return c.writeClose(closeNormal)
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return err
+			}
+		case opPong:
+		case opText, opBinary, opContinuation:
+			if messageSize += int64(len(payload)); messageSize > maxMessage {
+				return errors.Join(errTooBig, c.writeClose(closeTooBig))
+			}
+			if fin {
+				messageSize = 0
+			}
+		default:
+			return errors.Join(fmt.Errorf("%w: opcode %d", errProtocol, op), c.writeClose(closeProtocolError))
+		}
+	}
+}
+
+var (
+	errProtocol = errors.New("websocket protocol error")
+	errTooBig   = errors.New("websocket message too big")
+)
+
+// readFrame reads a frame from the client, unmasking its payload.
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", errProtocol)
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, fmt.Errorf("%w: unmasked client frame", errProtocol)
+	}
+
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (size > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("%w: invalid control frame", errProtocol)
+	}
+	if size > maxMessage {
+		return false, 0, nil, errTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// writeFrame sends payload as a single unmasked frame, as servers do.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.write(frame)
+}
+
+// writeClose sends a close frame with code, unless one was sent already.
+func (c *Conn) writeClose(code uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	frame := []byte{0x80 | opClose, 2}
+	return c.write(binary.BigEndian.AppendUint16(frame, code))
+}
+
+// write writes data within writeTimeout. Callers other than Upgrade must
+// hold mu.
+func (c *Conn) write(data []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return fmt.Errorf("failed to set write deadline: %w", err)
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write to websocket: %w", err)
+	}
+	return nil
+}
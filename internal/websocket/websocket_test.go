@@ -0,0 +1,205 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testClient speaks just enough of the client side of the protocol to test
+// Conn.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, url string, header http.Header) (*testClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			t.Errorf("failed to close connection: %v", err)
+		}
+	})
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header = header
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	return &testClient{t: t, conn: conn, r: r}, resp
+}
+
+// send writes a masked frame.
+func (c *testClient) send(op byte, payload []byte) {
+	c.t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatalf("failed to send frame: %v", err)
+	}
+}
+
+// receive reads an unmasked frame.
+func (c *testClient) receive() (op byte, payload []byte) {
+	c.t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		c.t.Fatalf("failed to read frame: %v", err)
+	}
+	size := int(head[1] & 0x7F)
+	if size == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			c.t.Fatalf("failed to read frame length: %v", err)
+		}
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatalf("failed to read payload: %v", err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func handshake() http.Header {
+	return http.Header{
+		"Connection":            {"keep-alive, Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	discarded := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := conn.WriteText([]byte(`{"type":"link.created"}`)); err != nil {
+			t.Errorf("WriteText() error = %v", err)
+		}
+		if err := conn.WriteText([]byte(strings.Repeat("x", 300))); err != nil {
+			t.Errorf("WriteText() error = %v", err)
+		}
+		discarded <- conn.Discard()
+		if err := conn.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	t.Run("handshake errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(h http.Header)
+		}{
+			{name: "no upgrade", modify: func(h http.Header) { h.Del("Upgrade") }},
+			{name: "old version", modify: func(h http.Header) { h.Set("Sec-Websocket-Version", "8") }},
+			{name: "invalid key", modify: func(h http.Header) { h.Set("Sec-Websocket-Key", "short") }},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				h := handshake()
+				tt.modify(h)
+				_, resp := dial(t, srv.URL, h)
+				if resp.StatusCode != http.StatusBadRequest {
+					t.Errorf("status = %d, want 400", resp.StatusCode)
+				}
+			})
+		}
+	})
+
+	client, resp := dial(t, srv.URL, handshake())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	// The example of RFC 6455, section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+
+	if op, payload := client.receive(); op != opText || string(payload) != `{"type":"link.created"}` {
+		t.Errorf("first frame = %d %q", op, payload)
+	}
+	if op, payload := client.receive(); op != opText || len(payload) != 300 {
+		t.Errorf("second frame = %d with %d bytes, want text of 300", op, len(payload))
+	}
+	client.send(opText, []byte("ignored"))
+	client.send(opPing, []byte("hi"))
+	if op, payload := client.receive(); op != opPong || string(payload) != "hi" {
+		t.Errorf("ping answer = %d %q, want pong", op, payload)
+	}
+	client.send(opClose, []byte{0x03, 0xE8})
+	if op, payload := client.receive(); op != opClose || binary.BigEndian.Uint16(payload) != closeNormal {
+		t.Errorf("close answer = %d %v, want close 1000", op, payload)
+	}
+	if err := <-discarded; err != nil {
+		t.Errorf("Discard() error = %v, want nil after a clean close", err)
+	}
+}
+
+func TestDiscard_ProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     []byte
+		wantErr   error
+		wantClose uint16
+	}{
+		{name: "unmasked frame", frame: []byte{0x81, 0x01, 'x'}, wantErr: errProtocol, wantClose: closeProtocolError},
+		{name: "unknown opcode", frame: []byte{0x83, 0x80, 0, 0, 0, 0}, wantErr: errProtocol, wantClose: closeProtocolError},
+		{name: "message too big", frame: []byte{0x82, 0xFF, 0, 0, 0, 0, 0, 0x10, 0, 0}, wantErr: errTooBig, wantClose: closeTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discarded := make(chan error, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := Upgrade(w, r)
+				if err != nil {
+					t.Errorf("Upgrade() error = %v", err)
+					return
+				}
+				discarded <- conn.Discard()
+				if err := conn.Close(); err != nil {
+					t.Errorf("Close() error = %v", err)
+				}
+			}))
+			defer srv.Close()
+
+			client, _ := dial(t, srv.URL, handshake())
+			if _, err := client.conn.Write(tt.frame); err != nil {
+				t.Fatalf("failed to send frame: %v", err)
+			}
+			if op, payload := client.receive(); op != opClose || binary.BigEndian.Uint16(payload) != tt.wantClose {
+				t.Errorf("answer = %d %v, want close %d", op, payload, tt.wantClose)
+			}
+			if err := <-discarded; !errors.Is(err, tt.wantErr) {
+				t.Errorf("Discard() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
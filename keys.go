@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// CreateAPIKeyRequest asks for an API key limited to Scopes. ExpiresAt and
+// TTLSeconds are mutually exclusive ways to make it expire; without them it
+// does not. Signing asks for a credential to sign requests with instead of
+// the key itself.
+type CreateAPIKeyRequest struct {
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	Signing    bool       `json:"signing,omitempty"`
+}
+
+type ListAPIKeysResponse struct {
+	Keys []*shortener.APIKey `json:"keys"`
+}
+
+// APIKeyResponse carries the secret of a new API key, which is only
+// returned once: either the key itself or, for keys that sign requests, the
+// key ID and signing secret.
+type APIKeyResponse struct {
+	*shortener.APIKey
+	Secret        string `json:"api_key,omitempty"`
+	SigningKeyID  string `json:"signing_key_id,omitempty"`
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
+
+// ListAPIKeysHandler lists the caller's API keys, oldest first.
+func (a *App) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	keys, err := a.Service.APIKeys(ctx, user)
+	if err != nil {
+		writeAPIKeyError(w, r, "list api keys", 0, err)
+		return
+	}
+	writeJSON(w, r, ListAPIKeysResponse{Keys: keys})
+}
+
+// CreateAPIKeyHandler issues an API key for the caller with the requested
+// scopes, which the key of the request must have as well.
+func (a *App) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	var req CreateAPIKeyRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.ExpiresAt != nil && req.TTLSeconds != 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidExpiry, "Only one of expires_at or ttl_seconds may be set")
+		return
+	}
+	if req.TTLSeconds < 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidExpiry, "ttl_seconds must be positive")
+		return
+	}
+	if req.Signing && !a.Service.SigningEnabled() {
+		apierror.Write(w, http.StatusBadRequest, apierror.SigningDisabled, shortener.ErrSigningDisabled.Error())
+		return
+	}
+	if req.TTLSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+		req.ExpiresAt = &expiresAt
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	key, secret, err := a.Service.CreateAPIKey(ctx, user, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		writeAPIKeyError(w, r, "create api key", 0, err)
+		return
+	}
+	a.writeAPIKey(w, r, key, secret, req.Signing)
+}
+
+// RotateAPIKeyHandler issues a successor to an API key of the caller. The
+// old key keeps working for shortener.APIKeyRotationGrace. With
+// ?signing=true, the signing credential of the successor is returned
+// instead of the key.
+func (a *App) RotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseAPIKeyID(w, r)
+	if !ok {
+		return
+	}
+	signing := r.URL.Query().Get("signing") == "true"
+	if signing && !a.Service.SigningEnabled() {
+		apierror.Write(w, http.StatusBadRequest, apierror.SigningDisabled, shortener.ErrSigningDisabled.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	key, secret, err := a.Service.RotateAPIKey(ctx, user, id)
+	if err != nil {
+		writeAPIKeyError(w, r, "rotate api key", id, err)
+		return
+	}
+	a.writeAPIKey(w, r, key, secret, signing)
+}
+
+// RevokeAPIKeyHandler revokes an API key of the caller at once.
+func (a *App) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseAPIKeyID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.RevokeAPIKey(ctx, user, id); err != nil {
+		writeAPIKeyError(w, r, "revoke api key", id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAPIKey writes a 201 response with a new key and its secret, or with
+// its signing credential if signing is set.
+func (a *App) writeAPIKey(w http.ResponseWriter, r *http.Request, key *shortener.APIKey, secret string, signing bool) {
+	resp := APIKeyResponse{APIKey: key, Secret: secret}
+	if signing {
+		keyID, signingSecret, err := a.Service.SigningCredential(key)
+		if err != nil {
+			writeAPIKeyError(w, r, "sign api key", key.ID, err)
+			return
+		}
+		resp = APIKeyResponse{APIKey: key, SigningKeyID: keyID, SigningSecret: signingSecret}
+	}
+	writeJSONStatus(w, r, http.StatusCreated, resp)
+}
+
+// parseAPIKeyID reads the keyID path variable, writing a 400 response if it
+// is not a number.
+func parseAPIKeyID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["keyID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid API key ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeAPIKeyError(w http.ResponseWriter, r *http.Request, op string, id uint64, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+		slog.WarnContext(r.Context(), op+" timeout", "key_id", id, "error", err)
+	case errors.Is(err, shortener.ErrInvalidScopes), errors.Is(err, shortener.ErrInvalidExpiry):
+		writeServiceError(w, http.StatusBadRequest, err)
+	case errors.Is(err, shortener.ErrMissingScope):
+		writeServiceError(w, http.StatusForbidden, err)
+	case errors.Is(err, shortener.ErrNotFound):
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "API key not found")
+	default:
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(r.Context(), op+" failed", "key_id", id, "error", err)
+	}
+}
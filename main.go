@@ -5,28 +5,143 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
-	httpSwagger "github.com/swaggo/http-swagger"
+	"github.com/hszk-dev/url-shortener/internal/accesslog"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/compress"
+	"github.com/hszk-dev/url-shortener/internal/config"
+	"github.com/hszk-dev/url-shortener/internal/events"
+	"github.com/hszk-dev/url-shortener/internal/geoip"
+	"github.com/hszk-dev/url-shortener/internal/httpx"
+	"github.com/hszk-dev/url-shortener/internal/idempotency"
+	"github.com/hszk-dev/url-shortener/internal/idgen"
+	"github.com/hszk-dev/url-shortener/internal/ipanon"
+	"github.com/hszk-dev/url-shortener/internal/jobs"
+	"github.com/hszk-dev/url-shortener/internal/metadata"
+	"github.com/hszk-dev/url-shortener/internal/oidc"
+	"github.com/hszk-dev/url-shortener/internal/ratelimit"
+	"github.com/hszk-dev/url-shortener/internal/realip"
+	"github.com/hszk-dev/url-shortener/internal/requestid"
+	"github.com/hszk-dev/url-shortener/internal/safebrowsing"
+	"github.com/hszk-dev/url-shortener/internal/scheduler"
 	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/hszk-dev/url-shortener/internal/visitors"
 )
 
 type App struct {
 	Service *shortener.Service
 	BaseURL string
+	// CanonicalizeMaxBatch caps the number of URLs accepted by /api/canonicalize.
+	// Zero means defaultCanonicalizeMaxBatch.
+	CanonicalizeMaxBatch int
+	// Metadata fetches destination titles for the preview page. Nil disables fetching.
+	Metadata MetadataFetcher
+	// GeoIP locates clients for links with country targets. Nil disables
+	// lookups unless CountryHeader is set.
+	GeoIP geoip.Resolver
+	// CountryHeader names a request header with the client country set by
+	// a trusted proxy. It takes precedence over GeoIP.
+	CountryHeader string
+	// Events receives a click event for every redirect. Nil disables event
+	// streaming.
+	Events EventSink
+	// ErrorPages renders HTML pages for browsers that cannot follow a short
+	// link. Nil answers them with a JSON error like other clients.
+	ErrorPages *ErrorPages
+	// MaxBodyBytes caps the size of JSON request bodies. Zero means
+	// defaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// Jobs runs the background jobs reported by the admin API. Nil reports
+	// none.
+	Jobs *scheduler.Scheduler
+	// Anonymizer rewrites client IPs before they are stored or published.
+	// Nil keeps them as they are.
+	Anonymizer *ipanon.Anonymizer
+	// HonorDoNotTrack only counts the clicks of clients asking not to be
+	// tracked (see doNotTrack).
+	HonorDoNotTrack bool
+	// Bus receives system events for the admin event feed. Nil disables
+	// the feed.
+	Bus *events.Bus
+	// MaskProxy fetches the destinations of links masked by proxy. Nil
+	// redirects to them instead.
+	MaskProxy MaskFetcher
+	// MaskProxyMaxBytes caps the size of the pages served for links masked
+	// by proxy. Zero means defaultMaskProxyMaxBytes.
+	MaskProxyMaxBytes int64
 }
 
+// EventSink publishes click events without blocking the request.
+// It is implemented by events.Publisher.
+type EventSink interface {
+	Publish(c events.Click)
+}
+
+const (
+	defaultCanonicalizeMaxBatch = 100
+	defaultMaxBodyBytes         = 1 << 20
+)
+
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL         string `json:"url"`
+	CustomAlias string `json:"custom_alias,omitempty"`
+	// SlugFrom is a title whose slug, e.g. spring-sale-24 for
+	// "Spring Sale 24", becomes the custom alias, numbered if it is taken.
+	SlugFrom string `json:"slug_from,omitempty"`
+	// ExpiresAt and TTLSeconds are mutually exclusive ways to set link expiry.
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	// MaxClicks limits how many times the link can be followed. Zero means unlimited.
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// UTM tags are appended to the query of URL before it is stored.
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	// ActiveFrom and ActiveUntil bound when the link redirects. Before the
+	// window it answers 404, afterwards 410.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// Alphabet writes the generated code: base62, base58 (no look-alike
+	// characters) or base32 (case-insensitive). Empty means the default.
+	Alphabet string `json:"alphabet,omitempty"`
+	// CampaignID adds the link to one of the caller's campaigns.
+	CampaignID uint64 `json:"campaign_id,omitempty"`
+	// Domain serves the link on one of the caller's verified custom
+	// domains instead of the base URL.
+	Domain string `json:"domain,omitempty"`
+	// DisableAnalytics opts the link out of click tracking.
+	DisableAnalytics bool `json:"disable_analytics,omitempty"`
+	// OrgID gives the link to one of the caller's organizations, whose
+	// members all manage it.
+	OrgID uint64 `json:"org_id,omitempty"`
+	// QueryPassthrough passes the query string of redirect requests on to
+	// the destination: merge or override. Empty means off.
+	QueryPassthrough string `json:"query_passthrough,omitempty"`
+	// Mask keeps the short URL in the address bar instead of redirecting:
+	// frame shows the destination in a frame, proxy serves it. The mask
+	// must be enabled on the server. Empty means off.
+	Mask string `json:"mask,omitempty"`
+	// BurnAfterReading makes the link redirect once, after which it
+	// answers 410.
+	BurnAfterReading bool `json:"burn_after_reading,omitempty"`
 }
 
 type ShortenResponse struct {
@@ -34,22 +149,89 @@ type ShortenResponse struct {
 	ShortURL  string `json:"short_url"`
 }
 
+type URLResponse struct {
+	ShortCode   string     `json:"short_code"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int64      `json:"max_clicks,omitempty"`
+	Clicks      int64      `json:"clicks"`
+	Status      string     `json:"status"`
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// Targets, Rules and Experiment are only included where the link was
+	// looked up individually.
+	Targets    []shortener.Target    `json:"targets,omitempty"`
+	Rules      []shortener.Rule      `json:"rules,omitempty"`
+	Experiment *shortener.Experiment `json:"experiment,omitempty"`
+	// Page describes the destination page once it has been fetched.
+	Page       *shortener.PageInfo `json:"page,omitempty"`
+	CampaignID uint64              `json:"campaign_id,omitempty"`
+	Domain     string              `json:"domain,omitempty"`
+	// AnalyticsDisabled reports that clicks of the link are not tracked.
+	AnalyticsDisabled bool `json:"analytics_disabled,omitempty"`
+	// OrgID is the organization managing the link, if any.
+	OrgID uint64 `json:"org_id,omitempty"`
+	// Metadata are the notes of the owner about the link.
+	Metadata *shortener.Metadata `json:"metadata,omitempty"`
+	// QueryPassthrough tells how the query string of redirect requests is
+	// passed on to the destination, if it is.
+	QueryPassthrough shortener.QueryPassthrough `json:"query_passthrough,omitempty"`
+	// Mask tells how the destination is hidden from visitors, if it is.
+	Mask shortener.Mask `json:"mask,omitempty"`
+	// BurnAfterReading reports that the link redirects once, and
+	// ConsumedAt when it did.
+	BurnAfterReading bool       `json:"burn_after_reading,omitempty"`
+	ConsumedAt       *time.Time `json:"consumed_at,omitempty"`
+	// Access restricts which visitors the link redirects, if it does.
+	Access *shortener.Access `json:"access,omitempty"`
+	// Health is the latest health check of the destination, in lists
+	// when health checks are enabled.
+	Health *shortener.Health `json:"health,omitempty"`
+}
+
+// ResolveResponse describes where a short link leads, for clients that ask
+// /{shortCode} for JSON instead of following the redirect.
+type ResolveResponse struct {
+	ShortCode   string     `json:"short_code"`
+	ShortURL    string     `json:"short_url"`
+	Destination string     `json:"destination"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type ListURLsResponse struct {
+	URLs       []URLResponse `json:"urls"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type CanonicalizeRequest struct {
+	URLs []string `json:"urls"`
+}
+
+type CanonicalizeResult struct {
+	Input     string `json:"input"`
+	Canonical string `json:"canonical,omitempty"`
+	Valid     bool   `json:"valid"`
+	Error     string `json:"error,omitempty"`
+}
+
+type CanonicalizeResponse struct {
+	Results []CanonicalizeResult `json:"results"`
+}
+
 func (a *App) ShortenHandler(w http.ResponseWriter, r *http.Request) {
 	var req ShortenRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate URL
-	if req.URL == "" {
-		http.Error(w, "URL is required", http.StatusBadRequest)
+	if err := a.decodeStrictJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
 		return
 	}
 
-	parsedURL, err := url.ParseRequestURI(req.URL)
-	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
-		http.Error(w, "Invalid URL format. Must be http:// or https://", http.StatusBadRequest)
+	opts, apiErr := req.options()
+	if apiErr != nil {
+		apierror.WriteError(w, http.StatusBadRequest, *apiErr)
 		return
 	}
 
@@ -57,34 +239,395 @@ func (a *App) ShortenHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	shortCode, err := a.Service.Shorten(ctx, req.URL)
+	if user, ok := auth.UserFromContext(r.Context()); ok {
+		if !user.May(shortener.RoleEditor) {
+			apierror.Write(w, http.StatusForbidden, apierror.Forbidden, "Forbidden")
+			return
+		}
+		opts.Owner = user
+	}
+
+	shortCode, err := a.Service.ShortenWithOptions(ctx, req.URL, opts)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
-			http.Error(w, "Request timeout", http.StatusRequestTimeout)
-			log.Printf("Shorten timeout: %v", err)
+			apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+			slog.WarnContext(ctx, "shorten timeout", "error", err)
+			return
+		}
+		if errors.Is(err, shortener.ErrInvalidAlias) || errors.Is(err, shortener.ErrInvalidActiveWindow) ||
+			errors.Is(err, shortener.ErrInvalidAlphabet) || errors.Is(err, shortener.ErrInvalidCampaign) || errors.Is(err, shortener.ErrInvalidDomain) ||
+			errors.Is(err, shortener.ErrInvalidOrg) || errors.Is(err, shortener.ErrInvalidURL) || errors.Is(err, shortener.ErrHomograph) ||
+			errors.Is(err, shortener.ErrDomainBlocked) || errors.Is(err, shortener.ErrRecursiveURL) || errors.Is(err, shortener.ErrInvalidMask) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Is(err, shortener.ErrAliasTaken) {
+			apierror.Write(w, http.StatusConflict, apierror.AliasTaken, "Custom alias already in use")
+			return
+		}
+		if errors.Is(err, shortener.ErrAliasReserved) {
+			writeServiceError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if errors.Is(err, shortener.ErrInvalidExpiry) {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidExpiry, "Expiry must be in the future")
+			return
+		}
+		if errors.Is(err, shortener.ErrUnsafeURL) {
+			apierror.Write(w, http.StatusBadRequest, apierror.UnsafeURL, "URL is flagged as unsafe")
+			return
+		}
+		if errors.Is(err, shortener.ErrURLTooLong) {
+			writeServiceError(w, http.StatusUnprocessableEntity, err)
 			return
 		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		log.Printf("Shorten error: %v", err)
+		var quotaErr *shortener.QuotaError
+		if errors.As(err, &quotaErr) {
+			writeQuotaError(w, quotaErr)
+			return
+		}
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(ctx, "shorten failed", "error", err)
 		return
 	}
 
+	// The service accepted the domain, so it is valid
+	domain, _ := shortener.NormalizeDomain(req.Domain)
 	resp := ShortenResponse{
 		ShortCode: shortCode,
-		ShortURL:  fmt.Sprintf("%s/%s", a.BaseURL, shortCode),
+		ShortURL:  a.shortURL(domain, shortCode),
 	}
 
 	// Marshal to JSON before writing headers to catch encoding errors
 	respJSON, err := json.Marshal(resp)
 	if err != nil {
-		log.Printf("Failed to encode response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(respJSON); err != nil {
-		log.Printf("Failed to write response: %v", err)
+		slog.ErrorContext(r.Context(), "failed to write response", "error", err)
+	}
+}
+
+// options validates req and returns the options it shortens its URL with,
+// or the error to answer with 400.
+func (req *ShortenRequest) options() (shortener.ShortenOptions, *apierror.Error) {
+	if req.URL == "" {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.URLRequired, Message: "URL is required", Field: "url"}
+	}
+	if err := shortener.ValidateURL(req.URL); err != nil {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.InvalidURL, Message: "Invalid URL format. Must be http:// or https://", Field: "url"}
+	}
+	if req.ExpiresAt != nil && req.TTLSeconds != 0 {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.InvalidExpiry, Message: "Only one of expires_at or ttl_seconds may be set", Field: "ttl_seconds"}
+	}
+	if req.TTLSeconds < 0 {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.InvalidExpiry, Message: "ttl_seconds must be positive", Field: "ttl_seconds"}
+	}
+	if req.MaxClicks < 0 {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.InvalidMaxClicks, Message: "max_clicks must be positive", Field: "max_clicks"}
+	}
+
+	opts := shortener.ShortenOptions{
+		CustomAlias: req.CustomAlias,
+		SlugFrom:    req.SlugFrom,
+		ExpiresAt:   req.ExpiresAt,
+		MaxClicks:   req.MaxClicks,
+		ActiveFrom:  req.ActiveFrom,
+		ActiveUntil: req.ActiveUntil,
+		CampaignID:  req.CampaignID,
+		Domain:      req.Domain,
+		UTM: shortener.UTM{
+			Source:   req.UTMSource,
+			Medium:   req.UTMMedium,
+			Campaign: req.UTMCampaign,
+		},
+		DisableAnalytics: req.DisableAnalytics,
+		OrgID:            req.OrgID,
+		BurnAfterReading: req.BurnAfterReading,
+	}
+	if req.Alphabet != "" {
+		alphabet, err := shortener.AlphabetByName(req.Alphabet)
+		if err != nil {
+			return shortener.ShortenOptions{}, &apierror.Error{Code: errorCode(err), Message: err.Error(), Field: "alphabet"}
+		}
+		opts.Alphabet = alphabet
+	}
+	passthrough, err := shortener.ParseQueryPassthrough(req.QueryPassthrough)
+	if err != nil {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.InvalidPassthrough, Message: err.Error(), Field: "query_passthrough"}
+	}
+	opts.QueryPassthrough = passthrough
+	mask, err := shortener.ParseMask(req.Mask)
+	if err != nil {
+		return shortener.ShortenOptions{}, &apierror.Error{Code: apierror.InvalidMask, Message: err.Error(), Field: "mask"}
+	}
+	opts.Mask = mask
+	if req.TTLSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+		opts.ExpiresAt = &expiresAt
+	}
+	return opts, nil
+}
+
+// CanonicalizeHandler validates and canonicalizes a batch of URLs without storing them.
+// Each input gets its own result so that one bad URL does not fail the whole batch.
+func (a *App) CanonicalizeHandler(w http.ResponseWriter, r *http.Request) {
+	var req CanonicalizeRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if len(req.URLs) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.URLRequired, "At least one URL is required")
+		return
+	}
+
+	maxBatch := a.CanonicalizeMaxBatch
+	if maxBatch <= 0 {
+		maxBatch = defaultCanonicalizeMaxBatch
+	}
+	if len(req.URLs) > maxBatch {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, fmt.Sprintf("Too many URLs (max %d)", maxBatch))
+		return
+	}
+
+	resp := CanonicalizeResponse{
+		Results: make([]CanonicalizeResult, 0, len(req.URLs)),
+	}
+	for _, rawURL := range req.URLs {
+		result := CanonicalizeResult{Input: rawURL}
+		canonical, err := shortener.NormalizeURL(rawURL)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Canonical = canonical
+			result.Valid = true
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	writeJSON(w, r, resp)
+}
+
+// parsePage reads the cursor and limit query parameters shared by the list
+// endpoints. It writes a 400 response and returns false if either is invalid.
+func parsePage(w http.ResponseWriter, r *http.Request) (cursor uint64, limit int, ok bool) {
+	query := r.URL.Query()
+
+	if v := query.Get("cursor"); v != "" {
+		c, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidCursor, "Invalid cursor")
+			return 0, 0, false
+		}
+		cursor = c
+	}
+
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > shortener.MaxListLimit {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidLimit, fmt.Sprintf("Invalid limit. Must be between 1 and %d", shortener.MaxListLimit))
+			return 0, 0, false
+		}
+		limit = l
+	}
+	return cursor, limit, true
+}
+
+func (a *App) listURLsResponse(links []*shortener.Link, next uint64) ListURLsResponse {
+	resp := ListURLsResponse{
+		URLs: make([]URLResponse, 0, len(links)),
+	}
+	for _, link := range links {
+		resp.URLs = append(resp.URLs, a.urlResponse(link))
+	}
+	if next != 0 {
+		resp.NextCursor = strconv.FormatUint(next, 10)
+	}
+	return resp
+}
+
+func (a *App) urlResponse(link *shortener.Link) URLResponse {
+	shortCode := link.ShortCode()
+	return URLResponse{
+		ShortCode:         shortCode,
+		ShortURL:          a.shortURL(link.Domain, shortCode),
+		OriginalURL:       link.OriginalURL,
+		CreatedAt:         link.CreatedAt,
+		ExpiresAt:         link.ExpiresAt,
+		MaxClicks:         link.MaxClicks,
+		Clicks:            link.Clicks,
+		Status:            string(linkStatus(link)),
+		ActiveFrom:        link.ActiveFrom,
+		ActiveUntil:       link.ActiveUntil,
+		Targets:           link.Targets,
+		Rules:             link.Rules,
+		Experiment:        link.Experiment,
+		Page:              link.Page,
+		CampaignID:        link.CampaignID,
+		Domain:            link.Domain,
+		AnalyticsDisabled: link.AnalyticsDisabled,
+		OrgID:             link.OrgID,
+		Metadata:          link.Metadata,
+		QueryPassthrough:  link.QueryPassthrough,
+		Mask:              link.Mask,
+		BurnAfterReading:  link.BurnAfterReading,
+		ConsumedAt:        link.ConsumedAt,
+		Access:            link.Access,
+		Health:            link.Health,
+	}
+}
+
+// linkStatus reports links stored before statuses existed as active.
+func linkStatus(link *shortener.Link) shortener.Status {
+	if link.Status == "" {
+		return shortener.StatusActive
+	}
+	return link.Status
+}
+
+// clientIP returns the client address of r as analytics, abuse reports and
+// events may keep it. Rate limiting and country lookups, which keep nothing,
+// use the real address.
+func (a *App) clientIP(r *http.Request) string {
+	return a.Anonymizer.IP(httpx.ClientIP(r))
+}
+
+// idempotencyScope keeps the Idempotency-Keys of users, or of anonymous
+// clients by IP, apart from each other's.
+func (a *App) idempotencyScope(r *http.Request) string {
+	if user, ok := auth.UserFromContext(r.Context()); ok {
+		return "user:" + strconv.FormatUint(user.ID, 10)
+	}
+	return "ip:" + httpx.ClientIP(r)
+}
+
+// errTrailingData is returned by decodeStrictJSON for bodies with data
+// after the JSON value.
+var errTrailingData = errors.New("unexpected data after JSON value")
+
+// decodeJSON decodes the JSON body of r into v, reading at most
+// MaxBodyBytes. The error is reported to the client with writeBodyError.
+func (a *App) decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	return a.bodyDecoder(w, r).Decode(v)
+}
+
+// decodeStrictJSON is decodeJSON rejecting fields v does not have and data
+// after the JSON value, so that clients learn about typos such as "ur" for
+// "url" rather than having the field ignored.
+func (a *App) decodeStrictJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := a.bodyDecoder(w, r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	_, err := dec.Token()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case errors.As(err, &tooLarge):
+		return err
+	default:
+		return errTrailingData
+	}
+}
+
+func (a *App) bodyDecoder(w http.ResponseWriter, r *http.Request) *json.Decoder {
+	limit := a.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+}
+
+// writeBodyError responds to a request body decodeJSON could not decode:
+// 413 if it was too large, 400 otherwise. Errors of a field name it.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var (
+		tooLarge  *http.MaxBytesError
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.BodyTooLarge, fmt.Sprintf("Request body too large. Must be at most %d bytes", tooLarge.Limit))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		apierror.WriteError(w, http.StatusBadRequest, apierror.Error{
+			Code:    apierror.InvalidBody,
+			Message: fmt.Sprintf("Invalid request body: %s must be %s", typeErr.Field, jsonKind(typeErr.Type)),
+			Field:   typeErr.Field,
+		})
+	case unknownField(err) != "":
+		field := unknownField(err)
+		apierror.WriteError(w, http.StatusBadRequest, apierror.Error{Code: apierror.UnknownField, Message: fmt.Sprintf("Unknown field %q", field), Field: field})
+	case errors.As(err, &syntaxErr):
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidBody, fmt.Sprintf("Invalid request body: malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.Is(err, io.EOF):
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidBody, "Invalid request body: empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidBody, "Invalid request body: truncated JSON")
+	case errors.Is(err, errTrailingData):
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidBody, "Invalid request body: data after the JSON object")
+	default:
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidBody, "Invalid request body")
+	}
+}
+
+// unknownField returns the field named by the error encoding/json reports
+// for unknown fields with DisallowUnknownFields, which has no type of its
+// own, or "" for other errors.
+func unknownField(err error) string {
+	field, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return ""
+	}
+	return strings.Trim(field, `"`)
+}
+
+// jsonKind describes the JSON value expected for t.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	default:
+		return "an object"
+	}
+}
+
+// writeJSON encodes v before writing headers so that encoding errors can
+// still be reported as 500.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v any) {
+	respJSON, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode response", "error", err)
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(respJSON); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write response", "error", err)
 	}
 }
 
@@ -96,70 +639,554 @@ func (a *App) RedirectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 	defer cancel()
 
-	originalURL, err := a.Service.Redirect(ctx, shortCode)
-	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			http.Error(w, "Request timeout", http.StatusRequestTimeout)
-			log.Printf("Redirect timeout for code %s: %v", shortCode, err)
+	// The response depends on Accept and on crawlers asking for link
+	// previews, so caches must not mix them
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "User-Agent")
+	if accepts(r, "application/json") && !accepts(r, "text/html") {
+		a.resolve(ctx, w, r, shortCode)
+		return
+	}
+
+	// Link checkers and chat apps unfurling links validate them with HEAD,
+	// and crawlers fetch them for link previews, which must not count as a
+	// visit. Crawlers get the Open Graph tags of unmasked links with a
+	// fetched page.
+	if r.Method == http.MethodHead || isLinkCrawler(r.UserAgent()) {
+		route, err := a.Service.Peek(ctx, shortCode, a.visitor(r))
+		if err != nil {
+			a.writeLinkError(w, r, "redirect", shortCode, err)
 			return
 		}
-		if errors.Is(err, shortener.ErrInvalidShortCode) {
-			http.Error(w, "Invalid short code", http.StatusBadRequest)
-			return
+		if r.Method == http.MethodGet && route.Mask == shortener.MaskOff {
+			link, err := a.Service.Preview(ctx, shortCode)
+			if err != nil {
+				a.writeLinkError(w, r, "redirect", shortCode, err)
+				return
+			}
+			if a.serveOpenGraph(w, r, link, route) {
+				return
+			}
 		}
-		if errors.Is(err, shortener.ErrNotFound) {
-			http.Error(w, "URL not found", http.StatusNotFound)
-			return
+		if !a.serveMasked(w, r, shortCode, route) {
+			http.Redirect(w, r, route.URL, http.StatusFound)
 		}
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		log.Printf("Redirect error: %v", err)
 		return
 	}
 
+	route, err := a.Service.RedirectVisitor(ctx, shortCode, a.visitor(r))
+	if err != nil {
+		a.writeLinkError(w, r, "redirect", shortCode, err)
+		return
+	}
+
+	if route.Sticky {
+		setVariantCookie(w, shortCode, route.Variant)
+	}
+	if a.Events != nil && !route.Untracked {
+		click := events.Click{ShortCode: shortCode, Timestamp: time.Now().UTC()}
+		if !a.doNotTrack(r) {
+			click.IP, click.UserAgent, click.Referrer = a.clientIP(r), r.UserAgent(), r.Referer()
+		}
+		a.Events.Publish(click)
+	}
+
+	if a.serveMasked(w, r, shortCode, route) {
+		return
+	}
 	// 302 Found for analytics
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	http.Redirect(w, r, route.URL, http.StatusFound)
+}
+
+// resolve responds with where shortCode leads as JSON. Like a preview, it
+// neither counts a click nor publishes a click event.
+func (a *App) resolve(ctx context.Context, w http.ResponseWriter, r *http.Request, shortCode string) {
+	link, err := a.Service.Preview(ctx, shortCode)
+	if err == nil && !link.ServedOn(requestHost(r)) {
+		err = shortener.ErrNotFound
+	}
+	if err == nil {
+		err = a.Service.CheckAccess(link, a.visitor(r))
+	}
+	if err != nil {
+		a.writeLinkError(w, r, "resolve", shortCode, err)
+		return
+	}
+
+	writeJSON(w, r, ResolveResponse{
+		ShortCode:   link.ShortCode(),
+		ShortURL:    a.shortURL(link.Domain, link.ShortCode()),
+		Destination: link.OriginalURL,
+		Status:      string(linkStatus(link)),
+		CreatedAt:   link.CreatedAt,
+		ExpiresAt:   link.ExpiresAt,
+	})
+}
+
+// newLogger builds the process-wide logger. Output is JSON on stderr so that
+// log collectors can index fields such as request_id.
+func newLogger(level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	return slog.New(requestid.NewLogHandler(handler))
+}
+
+// newRepository opens the configured storage backend: PostgreSQL cached in
+// Redis, a single SQLite file, a DynamoDB table, or non-persistent memory for
+// local development.
+func newRepository(cfg *config.Config, redisClient *redis.Client) (shortener.Repository, error) {
+	switch cfg.StorageBackend {
+	case config.BackendPostgres:
+		if len(cfg.ShardDSNs) > 0 {
+			repo, err := newShardedRepository(cfg, redisClient)
+			if err != nil {
+				return nil, err
+			}
+			return repo, nil
+		}
+		repo, err := newPostgresRepository(cfg, cfg.Postgres.DSN(), redisClient)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case config.BackendSQLite:
+		return shortener.NewSQLiteRepository(cfg.SQLitePath)
+	case config.BackendMemory:
+		return shortener.NewInMemoryRepository(), nil
+	case config.BackendDynamoDB:
+		return shortener.NewDynamoDBRepository(shortener.DynamoDBConfig{
+			Table:           cfg.DynamoDB.Table,
+			Region:          cfg.DynamoDB.Region,
+			Endpoint:        cfg.DynamoDB.Endpoint,
+			AccessKeyID:     cfg.DynamoDB.AccessKeyID,
+			SecretAccessKey: cfg.DynamoDB.SecretAccessKey,
+			SessionToken:    cfg.DynamoDB.SessionToken,
+			Retry: shortener.RetryPolicy{
+				MaxAttempts: cfg.RetryMaxAttempts,
+				BaseDelay:   cfg.RetryBaseDelay,
+				MaxDelay:    cfg.RetryMaxDelay,
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+// newPostgresRepository opens the PostgreSQL database at dsn with the pool
+// and cache settings of cfg.
+func newPostgresRepository(cfg *config.Config, dsn string, redisClient *redis.Client, extra ...shortener.PostgresOption) (*shortener.PostgresRedisRepository, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.Postgres.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Postgres.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Postgres.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.Postgres.ConnMaxIdleTime)
+
+	// Don't start serving before the database is reachable
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Postgres.ConnectTimeout)
+	defer cancel()
+	if err := pingWithRetry(ctx, db.PingContext); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database not reachable within %s: %w", cfg.Postgres.ConnectTimeout, err)
+	}
+	opts := []shortener.PostgresOption{
+		shortener.WithCacheTTL(cfg.CacheTTL, float64(cfg.CacheJitterPercent)/100),
+		shortener.WithRetryPolicy(shortener.RetryPolicy{
+			MaxAttempts: cfg.RetryMaxAttempts,
+			BaseDelay:   cfg.RetryBaseDelay,
+			MaxDelay:    cfg.RetryMaxDelay,
+		}),
+	}
+	if !cfg.Postgres.PreparedStatements {
+		opts = append(opts, shortener.WithoutPreparedStatements())
+	}
+	if cfg.LocalCacheSize > 0 {
+		opts = append(opts, shortener.WithLocalCache(cfg.LocalCacheSize, cfg.LocalCacheTTL))
+	}
+	if cfg.StaleCacheTTL > 0 {
+		opts = append(opts, shortener.WithStaleCache(cfg.StaleCacheTTL))
+	}
+	if cfg.CacheWarmInterval > 0 {
+		opts = append(opts, shortener.WithCacheWarming(cfg.CacheWarmInterval))
+	}
+	if cfg.CachePreloadTop > 0 {
+		opts = append(opts, shortener.WithCachePreload(cfg.CachePreloadTop))
+	}
+	return shortener.NewPostgresRedisRepository(db, redisClient, append(opts, extra...)...), nil
+}
+
+// newShardedRepository opens the primary database and the shards in
+// SHARD_DSNS, all sharing redisClient.
+func newShardedRepository(cfg *config.Config, redisClient *redis.Client) (*shortener.ShardedRepository, error) {
+	var m shortener.ShardMap
+	var err error
+	if cfg.ShardStrategy == config.ShardHash {
+		m, err = shortener.HashShards(len(cfg.ShardDSNs) + 1)
+	} else {
+		m, err = shortener.RangeShards(cfg.ShardBounds...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dsns := append([]string{cfg.Postgres.DSN()}, cfg.ShardDSNs...)
+	shards := make([]shortener.Repository, 0, len(dsns))
+	for i, dsn := range dsns {
+		shard, err := newPostgresRepository(cfg, dsn, redisClient, shortener.WithShardIndex(i))
+		if err != nil {
+			for _, opened := range shards {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		shards = append(shards, shard)
+	}
+	return shortener.NewShardedRepository(m, shards...)
+}
+
+// postgresShards returns the PostgreSQL repositories behind repo: itself,
+// the shards of a ShardedRepository, or none.
+func postgresShards(repo shortener.Repository) []*shortener.PostgresRedisRepository {
+	var shards []shortener.Repository
+	switch r := repo.(type) {
+	case *shortener.PostgresRedisRepository:
+		return []*shortener.PostgresRedisRepository{r}
+	case *shortener.ShardedRepository:
+		shards = r.Shards()
+	}
+	var pgs []*shortener.PostgresRedisRepository
+	for _, shard := range shards {
+		if pg, ok := shard.(*shortener.PostgresRedisRepository); ok {
+			pgs = append(pgs, pg)
+		}
+	}
+	return pgs
+}
+
+// preloadTimeout bounds how long startup waits for PreloadCache.
+const preloadTimeout = 30 * time.Second
+
+// preloadCache runs PreloadCache on the PostgreSQL repositories behind
+// repo. Failures are logged: the caches then fill on demand as usual.
+func preloadCache(repo shortener.Repository) {
+	ctx, cancel := context.WithTimeout(context.Background(), preloadTimeout)
+	defer cancel()
+	start := time.Now()
+	total := 0
+	for _, pg := range postgresShards(repo) {
+		n, err := pg.PreloadCache(ctx)
+		total += n
+		if err != nil {
+			slog.Warn("failed to preload cache", "error", err)
+		}
+	}
+	slog.Info("cache preloaded", "links", total, "duration", time.Since(start))
+}
+
+const (
+	pingInitialBackoff = 500 * time.Millisecond
+	pingMaxBackoff     = 5 * time.Second
+)
+
+// newCodeStrategy returns the strategy selected by CODE_STRATEGY.
+func newCodeStrategy(cfg *config.Config) (shortener.CodeStrategy, error) {
+	switch cfg.CodeStrategy {
+	case config.CodeRandom:
+		return shortener.NewRandomCodes(cfg.RandomCodeLength)
+	case config.CodeObfuscated:
+		return shortener.NewObfuscatedCodes([]byte(cfg.CodeObfuscationKey))
+	case config.CodeBlock:
+		return shortener.NewBlockCodes(cfg.IDBlockSize)
+	case config.CodeSnowflake:
+		ids, err := idgen.New(cfg.SnowflakeWorkerID)
+		if err != nil {
+			return nil, err
+		}
+		return shortener.SnowflakeCodes{IDs: ids}, nil
+	default:
+		return shortener.SequentialCodes{}, nil
+	}
+}
+
+// newEventSink returns the broker selected by EVENT_SINK.
+func newEventSink(cfg *config.Config, redisClient *redis.Client) (events.Sink, error) {
+	switch cfg.EventSink {
+	case config.EventSinkNATS:
+		return events.NewNATS(cfg.EventSinkURL, cfg.EventSubject, 5*time.Second)
+	case config.EventSinkRedis:
+		return events.NewRedisStream(redisClient, cfg.EventSubject, int64(cfg.EventStreamMaxLen)), nil
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", cfg.EventSink)
+	}
+}
+
+// newPlans converts the configured quota tiers.
+func newPlans(cfg *config.Config) map[string]shortener.Plan {
+	plans := make(map[string]shortener.Plan, len(cfg.Plans))
+	for name, p := range cfg.Plans {
+		plans[name] = shortener.Plan{
+			LinksPerDay:   p.LinksPerDay,
+			TotalLinks:    p.TotalLinks,
+			CustomAliases: p.CustomAliases,
+		}
+	}
+	return plans
+}
+
+// pingWithRetry calls ping until it succeeds or ctx is done, backing off
+// exponentially between attempts. It returns the last ping error.
+func pingWithRetry(ctx context.Context, ping func(context.Context) error) error {
+	backoff := pingInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("database ping failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pingMaxBackoff)
+	}
+}
+
+// fatal logs msg at error level and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
 	// Load .env (optional in CI/production environments)
 	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found (using environment variables): %v", err)
+		slog.Warn(".env file not found (using environment variables)", "error", err)
 	}
 
-	// Connect to PostgreSQL
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPass, dbName)
-
-	db, err := sql.Open("postgres", connStr)
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	defer db.Close()
+	slog.SetDefault(newLogger(cfg.LogLevel))
 
-	// Connect to Redis
-	redisAddr := os.Getenv("REDIS_ADDR")
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
-	defer redisClient.Close()
-
-	// Get base URL for short URLs
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
+	// Connect to Redis (cache for PostgreSQL, and rate limiting state).
+	// Optional for the sqlite and memory backends so that they run without external services.
+	var redisClient *redis.Client
+	if cfg.RedisAddr != "" {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr: cfg.RedisAddr,
+		})
+		defer redisClient.Close()
 	}
 
 	// Initialize Service
-	repo := shortener.NewPostgresRedisRepository(db, redisClient)
-	service := shortener.NewService(repo)
+	repo, err := newRepository(cfg, redisClient)
+	if err != nil {
+		fatal("failed to initialize storage", "error", err)
+	}
+	defer repo.Close()
+	shortener.PublishRepositoryMetrics(postgresShards(repo)...)
+	serviceOpts := []shortener.Option{
+		shortener.WithReportThreshold(int64(cfg.AbuseReportThreshold)),
+		shortener.WithPlans(newPlans(cfg), cfg.DefaultPlan),
+		shortener.WithMaxURLLength(cfg.MaxURLLength),
+		shortener.WithURLNormalization(shortener.URLNormalization{
+			Host:        cfg.NormalizeHost,
+			DefaultPort: cfg.NormalizeDefaultPort,
+			Fragment:    cfg.StripFragment,
+			SortQuery:   cfg.SortQuery,
+		}),
+		shortener.WithHomographPolicy(shortener.HomographPolicy(cfg.HomographPolicy)),
+		shortener.WithBaseURL(cfg.BaseURL),
+		shortener.WithKnownShorteners(cfg.KnownShorteners),
+		shortener.WithClickRetention(cfg.ClickHourlyRetention, cfg.ClickDailyRetention),
+		shortener.WithMasking(masks(cfg.MaskedRedirects)...),
+	}
+	if codes, err := newCodeStrategy(cfg); err != nil {
+		fatal("invalid code strategy", "error", err)
+	} else {
+		serviceOpts = append(serviceOpts, shortener.WithCodeStrategy(codes))
+	}
+	if lists, err := shortener.NewDomainLists(cfg.DomainBlocklist, cfg.DomainAllowlist); err != nil {
+		fatal("invalid domain lists", "error", err)
+	} else {
+		serviceOpts = append(serviceOpts, shortener.WithDomainLists(lists))
+	}
+	if alphabet, err := shortener.AlphabetByName(cfg.CodeAlphabet); err != nil {
+		fatal("invalid code alphabet", "error", err)
+	} else {
+		serviceOpts = append(serviceOpts, shortener.WithAlphabet(alphabet))
+	}
+	if policy, err := shortener.NewCodePolicy(cfg.CodeMinLength, cfg.CodeOrgPrefixes, cfg.CodeReservedPrefixes); err != nil {
+		fatal("invalid code policy", "error", err)
+	} else {
+		serviceOpts = append(serviceOpts, shortener.WithCodePolicy(policy))
+	}
+	if cfg.SafeBrowsingAPIKey != "" {
+		serviceOpts = append(serviceOpts, shortener.WithURLChecker(safebrowsing.NewClient(cfg.SafeBrowsingAPIKey, cfg.SafeBrowsingTimeout)))
+	}
+	// Unique visitors are counted in Redis HyperLogLogs
+	if redisClient != nil {
+		serviceOpts = append(serviceOpts, shortener.WithVisitorCounter(visitors.NewRedisCounter(redisClient)))
+	} else {
+		slog.Warn("unique visitor counting disabled: REDIS_ADDR is not set", "storage_backend", cfg.StorageBackend)
+	}
+	// Signed requests need Redis to reject replayed nonces on every replica
+	if cfg.SigningSecret != "" && redisClient == nil {
+		slog.Warn("signed requests disabled: REDIS_ADDR is not set", "storage_backend", cfg.StorageBackend)
+	} else if cfg.SigningSecret != "" {
+		serviceOpts = append(serviceOpts, shortener.WithRequestSigning([]byte(cfg.SigningSecret)))
+	}
+	fetcher := metadata.NewFetcher(cfg.MetadataFetchTimeout)
+	if cfg.MetadataFetchWorkers > 0 {
+		serviceOpts = append(serviceOpts, shortener.WithPageFetcher(pageFetcher{fetcher}, cfg.MetadataFetchWorkers))
+	}
+	// Queued jobs are shared through Redis, so that they are spread over
+	// the instances and survive restarts
+	if redisClient != nil {
+		serviceOpts = append(serviceOpts, shortener.WithJobStore(jobs.NewRedisStore(redisClient)))
+	}
+	// Health checks request destinations with their own timeout, through the
+	// same guards against SSRF as page fetches
+	if cfg.HealthCheckInterval > 0 {
+		serviceOpts = append(serviceOpts, shortener.WithHealthChecks(metadata.NewFetcher(cfg.HealthCheckTimeout), shortener.HealthCheckPolicy{
+			Concurrency:  cfg.HealthCheckConcurrency,
+			DisableAfter: cfg.HealthCheckDisableAfter,
+		}))
+	}
+	// Link changes and refused requests are published to the admin event
+	// feed
+	bus := events.NewBus()
+	serviceOpts = append(serviceOpts, shortener.WithAuditListener(publishAudit(bus)))
+	service := shortener.NewService(repo, serviceOpts...)
+
 	app := &App{
-		Service: service,
-		BaseURL: baseURL,
+		Service:              service,
+		BaseURL:              cfg.BaseURL,
+		CanonicalizeMaxBatch: cfg.CanonicalizeMaxBatch,
+		Metadata:             fetcher,
+		CountryHeader:        cfg.GeoIPCountryHeader,
+		MaxBodyBytes:         int64(cfg.MaxRequestBody),
+		Anonymizer:           ipanon.New(cfg.IPAnonymization, cfg.IPHashKey),
+		HonorDoNotTrack:      cfg.HonorDoNotTrack,
+		Bus:                  bus,
+		MaskProxyMaxBytes:    int64(cfg.MaskProxyMaxBytes),
+	}
+	// Proxied destinations are fetched through the same guards against
+	// SSRF as page fetches
+	if service.MaskEnabled(shortener.MaskProxy) {
+		app.MaskProxy = metadata.NewFetcher(cfg.MaskProxyTimeout)
+	}
+	if pages, err := LoadErrorPages(cfg.ErrorPagesDir, cfg.BaseURL); err != nil {
+		fatal("failed to load error pages", "error", err)
+	} else {
+		app.ErrorPages = pages
+	}
+	rootFiles, err := LoadRootFiles(cfg.FaviconFile, cfg.RobotsFile)
+	if err != nil {
+		fatal("failed to load root files", "error", err)
+	}
+	if err := rootFiles.LoadWellKnown(cfg.WellKnownDir, cfg.SecurityContacts, cfg.BaseURL, time.Now()); err != nil {
+		fatal("failed to load well-known files", "error", err)
+	}
+	if cfg.GeoIPDatabase != "" {
+		table, err := geoip.LoadCSV(cfg.GeoIPDatabase)
+		if err != nil {
+			fatal("failed to load geoip database", "error", err)
+		}
+		slog.Info("geoip database loaded", "networks", table.Len())
+		app.GeoIP = table
+	}
+	if cfg.EventSink != "" {
+		sink, err := newEventSink(cfg, redisClient)
+		if err != nil {
+			fatal("failed to initialize event sink", "error", err)
+		}
+		publisher := events.NewPublisher(sink, cfg.EventBufferSize, cfg.EventIPHashKey)
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			publisher.Close(ctx)
+		}()
+		slog.Info("click event streaming enabled", "sink", cfg.EventSink, "subject", cfg.EventSubject)
+		app.Events = publisher
+	}
+
+	// Load the hottest links into the caches before serving, so that a cold
+	// restart does not send their redirects to the database all at once
+	if cfg.CachePreloadTop > 0 {
+		preloadCache(repo)
+	}
+
+	// Start background jobs: purge expired links and old clicks, persist
+	// click counters and roll up clicks into time series, queue rescans and
+	// check that destinations still answer. The workers of the queued jobs
+	// fetch destination pages and run the rescans.
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go service.Jobs().Run(bgCtx)
+	sched := scheduler.New()
+	// Jobs over shared state run on one instance at a time, elected through
+	// Redis locks. Flushing and rolling up clicks runs everywhere, as each
+	// instance buffers clicks of its own.
+	var exclusive []scheduler.Option
+	if redisClient != nil {
+		locker, err := scheduler.NewRedisLocker(redisClient)
+		if err != nil {
+			fatal("failed to initialize job locks", "error", err)
+		}
+		exclusive = append(exclusive, scheduler.Exclusive(locker))
+	}
+	sched.Add("reaper", cfg.ReaperInterval, service.PurgeExpired, exclusive...)
+	sched.Add("click_flush", cfg.ClickFlushInterval, service.FlushClicks)
+	sched.Add("click_rollup", cfg.ClickRollupInterval, service.RollupClicks)
+	if cfg.ClickHourlyRetention > 0 || cfg.ClickDailyRetention > 0 {
+		sched.Add("click_purge", cfg.CleanupInterval, service.PurgeClicks, exclusive...)
+	}
+	if cfg.SafeBrowsingAPIKey != "" {
+		sched.Add("rescan", cfg.SafeBrowsingRescanInterval, service.Rescan, exclusive...)
+	}
+	if cfg.HealthCheckInterval > 0 {
+		sched.Add("health_check", cfg.HealthCheckInterval, service.CheckHealth, exclusive...)
+	}
+	// Remove the Redis keys that deletes left behind
+	if cleaner, ok := repo.(interface {
+		CleanCache(context.Context) (int, error)
+	}); ok && redisClient != nil {
+		sched.Add("cache_clean", cfg.CleanupInterval, cleaner.CleanCache, exclusive...)
+	}
+	// Drop links edited through other instances from the local cache
+	for _, pg := range postgresShards(repo) {
+		go pg.ListenInvalidations(bgCtx)
+		// Keep every live link in Redis so redirects survive a database
+		// outage; the first pass runs right away
+		if cfg.CacheWarmInterval > 0 {
+			sched.Add("cache_warm", cfg.CacheWarmInterval, pg.WarmCache, append([]scheduler.Option{scheduler.Immediately()}, exclusive...)...)
+		}
+	}
+	go sched.Run(bgCtx)
+	expvar.Publish("jobs", expvar.Func(func() any { return sched.Status() }))
+	app.Jobs = sched
+
+	// Rate limit link creation and abuse reports per client IP
+	// (RATE_LIMIT_SHORTEN=0 and RATE_LIMIT_REPORT=0 disable)
+	var shortenLimiter, reportLimiter ratelimit.Limiter
+	if (cfg.RateLimitShorten > 0 || cfg.RateLimitReport > 0) && redisClient == nil {
+		slog.Warn("rate limiting disabled: REDIS_ADDR is not set", "storage_backend", cfg.StorageBackend)
+	} else {
+		if cfg.RateLimitShorten > 0 {
+			shortenLimiter = publishingLimiter{ratelimit.NewRedisLimiter(redisClient, "shorten", cfg.RateLimitShorten, time.Minute, cfg.RateLimitShortenBurst), "shorten", bus}
+		}
+		if cfg.RateLimitReport > 0 {
+			reportLimiter = publishingLimiter{ratelimit.NewRedisLimiter(redisClient, "report", cfg.RateLimitReport, time.Minute, cfg.RateLimitReport), "report", bus}
+		}
 	}
 
 	// Setup Router
@@ -169,35 +1196,182 @@ func main() {
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write([]byte("OK")); err != nil {
-			log.Printf("Failed to write health check response: %v", err)
+			slog.ErrorContext(r.Context(), "failed to write health check response", "error", err)
 		}
 	}).Methods("GET")
 
-	r.HandleFunc("/api/shorten", app.ShortenHandler).Methods("POST")
-	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+	// Links created with an API key are owned by its user
+	var authenticator auth.Authenticator = service
+	if cfg.OIDCIssuer != "" {
+		verifier := oidc.NewVerifier(oidc.Config{
+			Issuer:   cfg.OIDCIssuer,
+			Audience: cfg.OIDCAudience,
+			JWKSURL:  cfg.OIDCJWKSURL,
+			CacheTTL: cfg.OIDCJWKSCacheTTL,
+		})
+		authenticator = auth.WithOIDC(service, service, verifier)
+		slog.Info("accepting OIDC tokens", "issuer", cfg.OIDCIssuer)
+	}
+	authenticate := auth.Middleware(authenticator)
+	if service.SigningEnabled() {
+		verifier := auth.NewSignatureVerifier(service, auth.NewRedisNonces(redisClient), cfg.SigningMaxSkew, int64(cfg.MaxRequestBody))
+		authenticate = auth.WithSignatures(authenticate, verifier)
+	}
+	// Retries with the Idempotency-Key of a link created before get its
+	// response again rather than a duplicate
+	shortenHandler := http.Handler(http.HandlerFunc(app.ShortenHandler))
+	if cfg.IdempotencyTTL > 0 && redisClient == nil {
+		slog.Warn("idempotency keys ignored: REDIS_ADDR is not set", "storage_backend", cfg.StorageBackend)
+	} else if cfg.IdempotencyTTL > 0 {
+		store := idempotency.New(redisClient, cfg.IdempotencyTTL, int64(cfg.MaxRequestBody))
+		shortenHandler = store.Middleware(app.idempotencyScope)(shortenHandler)
+	}
+	shortenHandler = authenticate(shortenHandler)
+	if shortenLimiter != nil {
+		shortenHandler = ratelimit.Middleware(shortenLimiter, httpx.ClientIP)(shortenHandler)
+	}
+	r.Handle("/api/shorten", shortenHandler).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/api/canonicalize", app.CanonicalizeHandler).Methods("POST")
 
-	// Swagger UI endpoints
-	r.HandleFunc("/docs/swagger.yaml", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./docs/swagger.yaml")
-	}).Methods("GET")
-	r.PathPrefix("/docs/").Handler(httpSwagger.Handler(
-		httpSwagger.URL("/docs/swagger.yaml"),
-	))
+	reportHandler := http.Handler(http.HandlerFunc(app.ReportHandler))
+	if reportLimiter != nil {
+		reportHandler = ratelimit.Middleware(reportLimiter, httpx.ClientIP)(reportHandler)
+	}
+	r.Handle("/api/report/{shortCode}", reportHandler).Methods("POST")
+
+	// Admin API, only exposed when ADMIN_TOKEN is configured
+	if cfg.AdminToken != "" {
+		app.registerAdminRoutes(r, cfg.AdminToken, authenticate)
+	} else {
+		slog.Info("admin API disabled: ADMIN_TOKEN is not set")
+	}
+
+	// favicon.ico, robots.txt and /.well-known/ are requested by browsers,
+	// crawlers and apps
+	registerRootFiles(r, rootFiles)
+
+	r.HandleFunc("/preview/{shortCode}", app.PreviewHandler).Methods("GET")
+	r.HandleFunc(`/{shortCode:[0-9A-Za-z_-]+}+`, app.PreviewHandler).Methods("GET")
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET", "HEAD")
+
+	// OpenAPI document and Swagger UI
+	registerDocsRoutes(r)
+
+	// Log requests with their latency, sampling successful ones
+	accessLog := func(h http.Handler) http.Handler { return h }
+	if cfg.AccessLog {
+		r.Use(accesslog.RouteMiddleware)
+		accessLog = accesslog.Middleware(accesslog.Options{
+			SampleRate:  cfg.AccessLogSampleRate,
+			RedactCodes: cfg.AccessLogRedactCodes,
+			ClientIP:    app.clientIP,
+		})
+	}
+
+	// Behind TRUSTED_PROXIES, clients are identified by CLIENT_IP_HEADER
+	clientIPs := realip.New(cfg.TrustedProxies, cfg.ClientIPHeader)
+	if len(cfg.TrustedProxies) == 0 {
+		slog.Info("forwarding headers ignored: TRUSTED_PROXIES is not set")
+	}
 
 	// Configure HTTP Server with timeouts
-	port := "8080"
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: r,
+		Addr:    ":" + strconv.Itoa(cfg.Port),
+		Handler: clientIPs.Middleware(requestid.Middleware(accessLog(compress.Middleware(r)))),
 		// ReadTimeout covers the time from connection accepted to request body fully read
-		ReadTimeout: 10 * time.Second,
+		ReadTimeout: cfg.ReadTimeout,
+		// ReadHeaderTimeout cuts off clients trickling in headers
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
 		// WriteTimeout covers the time from end of request header read to end of response write
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: cfg.WriteTimeout,
 		// IdleTimeout is the max time to wait for the next request when keep-alives are enabled
-		IdleTimeout: 120 * time.Second,
+		IdleTimeout: cfg.IdleTimeout,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.HTTP2MaxConcurrentStreams,
+		},
+	}
+	srv.SetKeepAlivesEnabled(cfg.KeepAlives)
+	if cfg.H2C {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	// With TLS_CERT_FILE or AUTOCERT_DOMAINS the server terminates HTTPS itself
+	tlsConfig, challenges := newTLS(cfg)
+	srv.TLSConfig = tlsConfig
+	var redirectSrv *http.Server
+	if cfg.HTTPRedirectPort != 0 {
+		redirectSrv = &http.Server{
+			Addr:         ":" + strconv.Itoa(cfg.HTTPRedirectPort),
+			Handler:      challenges(httpsRedirect(cfg.Port)),
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+		}
 	}
 
-	// Start Server
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(srv.ListenAndServe())
+	// Start Server, and shut down gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 2)
+	go func() {
+		slog.Info("server starting", "port", cfg.Port, "storage_backend", cfg.StorageBackend, "tls", cfg.TLSEnabled())
+		if tlsConfig != nil {
+			// Empty file names use the autocert certificates of TLSConfig
+			serveErr <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
+	if redirectSrv != nil {
+		go func() {
+			slog.Info("HTTPS redirect server starting", "port", cfg.HTTPRedirectPort)
+			serveErr <- redirectSrv.ListenAndServe()
+		}()
+	}
+	select {
+	case err := <-serveErr:
+		fatal("server stopped", "error", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down server", "error", err)
+	}
+	if redirectSrv != nil {
+		if err := redirectSrv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shut down HTTPS redirect server", "error", err)
+		}
+	}
+	stopBackground()
+	flushClicks(shutdownCtx, service)
+}
+
+// shutdownTimeout bounds draining in-flight requests and the final click
+// flush on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// flushClicks persists buffered click counters and rollups one last time so
+// that clicks recorded since the previous background run are not lost.
+func flushClicks(ctx context.Context, service *shortener.Service) {
+	for _, task := range []struct {
+		name string
+		run  func(context.Context) (int, error)
+	}{
+		{"click_flush", service.FlushClicks},
+		{"click_rollup", service.RollupClicks},
+	} {
+		n, err := task.run(ctx)
+		if err != nil {
+			slog.Error("final background task failed", "task", task.name, "error", err)
+			continue
+		}
+		slog.Info("final background task completed", "task", task.name, "count", n)
+	}
 }
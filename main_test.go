@@ -1,16 +1,43 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/docs"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/config"
+	"github.com/hszk-dev/url-shortener/internal/events"
+	"github.com/hszk-dev/url-shortener/internal/geoip"
+	"github.com/hszk-dev/url-shortener/internal/httpx"
+	"github.com/hszk-dev/url-shortener/internal/ipanon"
+	"github.com/hszk-dev/url-shortener/internal/metadata"
+	"github.com/hszk-dev/url-shortener/internal/ratelimit"
+	"github.com/hszk-dev/url-shortener/internal/scheduler"
 	"github.com/hszk-dev/url-shortener/internal/shortener"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
 )
 
 func TestShortenHandler(t *testing.T) {
@@ -21,6 +48,8 @@ func TestShortenHandler(t *testing.T) {
 		mockSaveID     uint64
 		mockSaveError  error
 		expectedStatus int
+		expectedCode   string
+		expectedField  string
 		expectedFields []string
 		checkResponse  func(*testing.T, *httptest.ResponseRecorder)
 	}{
@@ -50,6 +79,8 @@ func TestShortenHandler(t *testing.T) {
 			requestBody:    `{"url":""}`,
 			contentType:    "application/json",
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.URLRequired,
+			expectedField:  "url",
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "URL is required") {
@@ -57,11 +88,55 @@ func TestShortenHandler(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:           "unknown alphabet",
+			requestBody:    `{"url":"https://example.com","alphabet":"base64"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidAlphabet,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if body := w.Body.String(); !strings.Contains(body, "base58") {
+					t.Errorf("Expected accepted alphabets in error, got: %s", body)
+				}
+			},
+		},
+		{
+			name:           "alphabet with custom alias",
+			requestBody:    `{"url":"https://example.com","alphabet":"base32","custom_alias":"my-link"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidAlphabet,
+		},
+		{
+			name:           "URL too long",
+			requestBody:    `{"url":"https://example.com/` + strings.Repeat("a", 3000) + `"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   apierror.URLTooLong,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if body := w.Body.String(); !strings.Contains(body, "at most 2048 allowed") {
+					t.Errorf("Expected maximum length in error, got: %s", body)
+				}
+			},
+		},
+		{
+			name:           "body too large",
+			requestBody:    `{"url":"https://example.com","custom_alias":"` + strings.Repeat("a", defaultMaxBodyBytes) + `"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedCode:   apierror.BodyTooLarge,
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if body := w.Body.String(); !strings.Contains(body, "Request body too large") {
+					t.Errorf("Expected 'Request body too large' error, got: %s", body)
+				}
+			},
+		},
 		{
 			name:           "invalid JSON",
 			requestBody:    `{invalid json}`,
 			contentType:    "application/json",
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidBody,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "Invalid request body") {
@@ -69,11 +144,54 @@ func TestShortenHandler(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:           "unknown field",
+			requestBody:    `{"ur":"https://example.com"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.UnknownField,
+			expectedField:  "ur",
+		},
+		{
+			name:           "wrong field type",
+			requestBody:    `{"url":"https://example.com","max_clicks":"10"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidBody,
+			expectedField:  "max_clicks",
+			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if body := w.Body.String(); !strings.Contains(body, "max_clicks must be a number") {
+					t.Errorf("Expected the expected type in error, got: %s", body)
+				}
+			},
+		},
+		{
+			name:           "trailing data",
+			requestBody:    `{"url":"https://example.com"}{"url":"https://example.org"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidBody,
+		},
+		{
+			name:           "trailing whitespace",
+			requestBody:    "{\"url\":\"https://example.com\"}\n",
+			contentType:    "application/json",
+			mockSaveID:     1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "truncated JSON",
+			requestBody:    `{"url":"https://exa`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidBody,
+		},
 		{
 			name:           "invalid URL scheme (ftp)",
 			requestBody:    `{"url":"ftp://example.com"}`,
 			contentType:    "application/json",
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidURL,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "Invalid URL format") {
@@ -86,6 +204,7 @@ func TestShortenHandler(t *testing.T) {
 			requestBody:    `{"url":"www.google.com"}`,
 			contentType:    "application/json",
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidURL,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "Invalid URL format") {
@@ -93,6 +212,20 @@ func TestShortenHandler(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:           "invalid international domain name",
+			requestBody:    `{"url":"https://xn--a.example/"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidHost,
+		},
+		{
+			name:           "short link of this service",
+			requestBody:    `{"url":"http://localhost:8080/abc"}`,
+			contentType:    "application/json",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.RecursiveURL,
+		},
 		{
 			name:           "service returns error",
 			requestBody:    `{"url":"https://www.example.com"}`,
@@ -100,6 +233,7 @@ func TestShortenHandler(t *testing.T) {
 			mockSaveID:     0,
 			mockSaveError:  context.DeadlineExceeded,
 			expectedStatus: http.StatusRequestTimeout,
+			expectedCode:   apierror.Timeout,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "Request timeout") {
@@ -129,13 +263,13 @@ func TestShortenHandler(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup mock repository
 			mockRepo := &shortener.MockRepository{
-				SaveFunc: func(ctx context.Context, url string) (uint64, error) {
+				SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
 					return tt.mockSaveID, tt.mockSaveError
 				},
 			}
 
 			// Create app with mock service
-			service := shortener.NewService(mockRepo)
+			service := shortener.NewService(mockRepo, shortener.WithBaseURL("http://localhost:8080"))
 			app := &App{
 				Service: service,
 				BaseURL: "http://localhost:8080",
@@ -158,6 +292,21 @@ func TestShortenHandler(t *testing.T) {
 				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
 			}
 
+			if tt.expectedCode != "" {
+				if code := errorCodeOf(t, w); code != tt.expectedCode {
+					t.Errorf("Expected error code %q, got %q", tt.expectedCode, code)
+				}
+			}
+			if tt.expectedField != "" {
+				var resp apierror.Response
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("Failed to decode error response: %v", err)
+				}
+				if resp.Error.Field != tt.expectedField {
+					t.Errorf("Expected error field %q, got %q", tt.expectedField, resp.Error.Field)
+				}
+			}
+
 			// Run custom response checks if provided
 			if tt.checkResponse != nil {
 				tt.checkResponse(t, w)
@@ -194,6 +343,7 @@ func TestRedirectHandler(t *testing.T) {
 		mockURL        string
 		mockError      error
 		expectedStatus int
+		expectedCode   string
 		expectedHeader string
 		checkResponse  func(*testing.T, *httptest.ResponseRecorder)
 	}{
@@ -211,6 +361,7 @@ func TestRedirectHandler(t *testing.T) {
 			shortCode:      "xyz",
 			mockError:      shortener.ErrNotFound,
 			expectedStatus: http.StatusNotFound,
+			expectedCode:   apierror.NotFound,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "URL not found") {
@@ -223,6 +374,7 @@ func TestRedirectHandler(t *testing.T) {
 			shortCode:      "invalid!@#",
 			mockError:      shortener.ErrInvalidShortCode,
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidCode,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "Invalid short code") {
@@ -235,12 +387,14 @@ func TestRedirectHandler(t *testing.T) {
 			shortCode:      "",
 			mockError:      shortener.ErrInvalidShortCode,
 			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.InvalidCode,
 		},
 		{
 			name:           "timeout error",
 			shortCode:      "1",
 			mockError:      context.DeadlineExceeded,
 			expectedStatus: http.StatusRequestTimeout,
+			expectedCode:   apierror.Timeout,
 			checkResponse: func(t *testing.T, w *httptest.ResponseRecorder) {
 				body := strings.TrimSpace(w.Body.String())
 				if !strings.Contains(body, "Request timeout") {
@@ -257,19 +411,33 @@ func TestRedirectHandler(t *testing.T) {
 			expectedStatus: http.StatusFound,
 			expectedHeader: "https://github.com/golang/go/issues/12345",
 		},
+		{
+			name:           "redirect loop",
+			shortCode:      "1",
+			mockID:         1,
+			mockURL:        "http://localhost:8080/1",
+			expectedStatus: http.StatusLoopDetected,
+			expectedCode:   apierror.RedirectLoop,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup mock repository
 			mockRepo := &shortener.MockRepository{
-				GetFunc: func(ctx context.Context, id uint64) (string, error) {
-					return tt.mockURL, tt.mockError
+				GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+					if tt.mockError != nil {
+						return nil, tt.mockError
+					}
+					return &shortener.Link{ID: id, OriginalURL: tt.mockURL}, nil
+				},
+				GetByCodeFunc: func(ctx context.Context, code string) (*shortener.Link, error) {
+					return nil, tt.mockError
 				},
 			}
 
 			// Create app with mock service
-			service := shortener.NewService(mockRepo)
+			service := shortener.NewService(mockRepo, shortener.WithBaseURL("http://localhost:8080"))
 			app := &App{
 				Service: service,
 				BaseURL: "http://localhost:8080",
@@ -300,6 +468,12 @@ func TestRedirectHandler(t *testing.T) {
 				}
 			}
 
+			if tt.expectedCode != "" {
+				if code := errorCodeOf(t, w); code != tt.expectedCode {
+					t.Errorf("Expected error code %q, got %q", tt.expectedCode, code)
+				}
+			}
+
 			// Run custom response checks if provided
 			if tt.checkResponse != nil {
 				tt.checkResponse(t, w)
@@ -308,11 +482,21 @@ func TestRedirectHandler(t *testing.T) {
 	}
 }
 
+// errorCodeOf returns the code of the API error w responded with.
+func errorCodeOf(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp apierror.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode error response %q: %v", w.Body.String(), err)
+	}
+	return resp.Error.Code
+}
+
 func TestRedirectHandler_HTTP302(t *testing.T) {
 	// Specific test to verify we use 302 Found (not 301 Moved Permanently)
 	mockRepo := &shortener.MockRepository{
-		GetFunc: func(ctx context.Context, id uint64) (string, error) {
-			return "https://www.google.com", nil
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://www.google.com"}, nil
 		},
 	}
 
@@ -338,29 +522,4299 @@ func TestRedirectHandler_HTTP302(t *testing.T) {
 	}
 }
 
-func TestShortenHandler_ContentType(t *testing.T) {
-	// Test that response has correct Content-Type header
+func TestRedirectHandler_JSON(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var clicks int
 	mockRepo := &shortener.MockRepository{
-		SaveFunc: func(ctx context.Context, url string) (uint64, error) {
-			return 1, nil
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			if id == 2 {
+				return &shortener.Link{ID: id, OriginalURL: "https://example.com", Status: shortener.StatusDisabled}, nil
+			}
+			return &shortener.Link{ID: id, OriginalURL: "https://www.google.com", CreatedAt: createdAt, MaxClicks: 1}, nil
+		},
+		IncrementClicksFunc: func(ctx context.Context, link *shortener.Link) (int64, error) {
+			clicks++
+			return int64(clicks), nil
 		},
 	}
+	sink := &recordingEvents{}
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+		Events:  sink,
+	}
 
-	service := shortener.NewService(mockRepo)
+	tests := []struct {
+		name       string
+		shortCode  string
+		accept     string
+		wantStatus int
+		wantType   string
+	}{
+		{"json", "1", "application/json", http.StatusOK, "application/json"},
+		{"json with quality", "1", "application/json;q=0.9, text/plain;q=0.5", http.StatusOK, "application/json"},
+		{"browser", "1", "text/html,application/xhtml+xml,application/json;q=0.9,*/*;q=0.8", http.StatusFound, "text/html; charset=utf-8"},
+		{"disabled", "2", "application/json", http.StatusGone, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clicks = 0
+			sink.clicks = nil
+
+			req := httptest.NewRequest("GET", "/"+tt.shortCode, nil)
+			req = mux.SetURLVars(req, map[string]string{"shortCode": tt.shortCode})
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+
+			app.RedirectHandler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.wantType, ct)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept" {
+				t.Errorf("Expected Vary: Accept, got %q", vary)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp ResolveResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			want := ResolveResponse{
+				ShortCode:   "1",
+				ShortURL:    "http://localhost:8080/1",
+				Destination: "https://www.google.com",
+				Status:      "active",
+				CreatedAt:   createdAt,
+			}
+			if resp != want {
+				t.Errorf("Expected %+v, got %+v", want, resp)
+			}
+			if loc := w.Header().Get("Location"); loc != "" {
+				t.Errorf("Expected no Location header, got %q", loc)
+			}
+			if clicks != 0 || len(sink.clicks) != 0 {
+				t.Errorf("Expected resolving not to count clicks, got %d clicks and %d events", clicks, len(sink.clicks))
+			}
+		})
+	}
+}
+
+func TestRedirectHandler_HEAD(t *testing.T) {
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			if id == 2 {
+				return nil, shortener.ErrNotFound
+			}
+			return &shortener.Link{ID: id, OriginalURL: "https://www.google.com"}, nil
+		},
+		RecordClickFunc: func(ctx context.Context, link *shortener.Link, at time.Time, src shortener.ClickSource) error {
+			t.Error("RecordClick called for HEAD request")
+			return nil
+		},
+	}
+	sink := &recordingEvents{}
 	app := &App{
-		Service: service,
+		Service: shortener.NewService(mockRepo),
 		BaseURL: "http://localhost:8080",
+		Events:  sink,
 	}
+	r := mux.NewRouter()
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET", "HEAD")
+	srv := httptest.NewServer(r)
+	defer srv.Close()
 
-	req := httptest.NewRequest("POST", "/api/shorten",
-		bytes.NewBufferString(`{"url":"https://www.google.com"}`))
-	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	tests := []struct {
+		path         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"/1", http.StatusFound, "https://www.google.com"},
+		{"/2", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := client.Head(srv.URL + tt.path)
+			if err != nil {
+				t.Fatalf("HEAD failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if loc := resp.Header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("Expected Location %q, got %q", tt.wantLocation, loc)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			if len(body) != 0 {
+				t.Errorf("Expected empty body, got %q", body)
+			}
+		})
+	}
+	if len(sink.clicks) != 0 {
+		t.Errorf("Expected no click events, got %d", len(sink.clicks))
+	}
+}
+
+// recordingEvents is an EventSink capturing published clicks.
+type recordingEvents struct {
+	clicks []events.Click
+}
+
+func (e *recordingEvents) Publish(c events.Click) {
+	e.clicks = append(e.clicks, c)
+}
+
+func TestRedirectHandler_AnalyticsDisabled(t *testing.T) {
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://clinic.example.com", AnalyticsDisabled: true}, nil
+		},
+		RecordClickFunc: func(ctx context.Context, link *shortener.Link, at time.Time, src shortener.ClickSource) error {
+			t.Error("RecordClick called for a link with analytics disabled")
+			return nil
+		},
+	}
+	sink := &recordingEvents{}
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+		Events:  sink,
+	}
+
+	req := httptest.NewRequest("GET", "/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortCode": "1"})
 	w := httptest.NewRecorder()
+	app.RedirectHandler(w, req)
 
-	app.ShortenHandler(w, req)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://clinic.example.com" {
+		t.Errorf("redirect = %d to %q, want 302 to the destination", w.Code, w.Header().Get("Location"))
+	}
+	if len(sink.clicks) != 0 {
+		t.Errorf("published %d events, want none", len(sink.clicks))
+	}
+}
 
-	contentType := w.Header().Get("Content-Type")
-	if contentType != "application/json" {
-		t.Errorf("Expected Content-Type 'application/json', got '%s'", contentType)
+func TestRedirectHandler_QueryPassthrough(t *testing.T) {
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://shop.example.com/sale?utm_source=news", QueryPassthrough: shortener.QueryPassthroughMerge}, nil
+		},
+	}
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+	}
+
+	req := httptest.NewRequest("GET", "/1?gclid=abc&utm_source=ads", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortCode": "1"})
+	w := httptest.NewRecorder()
+	app.RedirectHandler(w, req)
+
+	want := "https://shop.example.com/sale?utm_source=news&gclid=abc"
+	if w.Code != http.StatusFound || w.Header().Get("Location") != want {
+		t.Errorf("redirect = %d to %q, want 302 to %q", w.Code, w.Header().Get("Location"), want)
+	}
+}
+
+// fakeMaskFetcher serves every destination with resp, or fails with err.
+type fakeMaskFetcher struct {
+	resp *http.Response
+	err  error
+}
+
+func (f fakeMaskFetcher) Open(ctx context.Context, method, rawURL string, header http.Header) (*http.Response, error) {
+	return f.resp, f.err
+}
+
+func TestRedirectHandler_Masked(t *testing.T) {
+	page := func(contentLength int64) *http.Response {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {"text/html"}, "Set-Cookie": {"session=1"}},
+			Body:          io.NopCloser(strings.NewReader("<h1>Sale</h1>")),
+			ContentLength: contentLength,
+		}
+	}
+	tests := []struct {
+		name       string
+		mask       shortener.Mask
+		masks      []shortener.Mask
+		proxy      MaskFetcher
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "frame", mask: shortener.MaskFrame, masks: []shortener.Mask{shortener.MaskFrame}, wantStatus: http.StatusOK, wantBody: `<iframe src="https://shop.example.com/sale"`},
+		{name: "proxy", mask: shortener.MaskProxy, masks: []shortener.Mask{shortener.MaskProxy}, proxy: fakeMaskFetcher{resp: page(-1)}, wantStatus: http.StatusOK, wantBody: "<h1>Sale</h1>"},
+		{name: "proxy failure", mask: shortener.MaskProxy, masks: []shortener.Mask{shortener.MaskProxy}, proxy: fakeMaskFetcher{err: errors.New("connection refused")}, wantStatus: http.StatusBadGateway, wantBody: apierror.BadGateway},
+		{name: "proxy over the size limit", mask: shortener.MaskProxy, masks: []shortener.Mask{shortener.MaskProxy}, proxy: fakeMaskFetcher{resp: page(1 << 30)}, wantStatus: http.StatusBadGateway, wantBody: apierror.BadGateway},
+		{name: "proxy without fetcher", mask: shortener.MaskProxy, masks: []shortener.Mask{shortener.MaskProxy}, wantStatus: http.StatusFound},
+		{name: "masking disabled", mask: shortener.MaskFrame, wantStatus: http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &shortener.MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+					return &shortener.Link{ID: id, OriginalURL: "https://shop.example.com/sale", Mask: tt.mask}, nil
+				},
+			}
+			app := &App{
+				Service:   shortener.NewService(mockRepo, shortener.WithMasking(tt.masks...)),
+				BaseURL:   "http://localhost:8080",
+				MaskProxy: tt.proxy,
+			}
+
+			req := httptest.NewRequest("GET", "/1", nil)
+			req.Header.Set("Accept", "text/html")
+			req = mux.SetURLVars(req, map[string]string{"shortCode": "1"})
+			w := httptest.NewRecorder()
+			app.RedirectHandler(w, req)
+
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("response = %d %s, want %d with %q", w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+			if w.Code == http.StatusOK && (w.Header().Get("Location") != "" || w.Header().Get("Set-Cookie") != "" || w.Header().Get("Content-Security-Policy") == "") {
+				t.Errorf("masked headers = %v, want a content security policy and neither location nor cookies", w.Header())
+			}
+		})
+	}
+}
+
+func TestIsLinkCrawler(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      bool
+	}{
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true},
+		{"Twitterbot/1.0", true},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", false},
+		{"curl/8.4.0", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			if got := isLinkCrawler(tt.userAgent); got != tt.want {
+				t.Errorf("isLinkCrawler(%q) = %v, want %v", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler_BurnAfterReading(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	code, err := service.ShortenWithOptions(context.Background(), "https://example.com/secret", shortener.ShortenOptions{BurnAfterReading: true})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+
+	// Previews must neither use up the link nor give its destination away
+	steps := []struct {
+		name         string
+		method       string
+		header       map[string]string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "head", method: "HEAD", wantStatus: http.StatusForbidden},
+		{name: "crawler", method: "GET", header: map[string]string{"User-Agent": "Slackbot-LinkExpanding 1.0"}, wantStatus: http.StatusForbidden},
+		{name: "json", method: "GET", header: map[string]string{"Accept": "application/json"}, wantStatus: http.StatusForbidden},
+		{name: "first visit", method: "GET", wantStatus: http.StatusFound, wantLocation: "https://example.com/secret"},
+		{name: "second visit", method: "GET", wantStatus: http.StatusGone},
+		{name: "json once consumed", method: "GET", header: map[string]string{"Accept": "application/json"}, wantStatus: http.StatusGone},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			req := httptest.NewRequest(step.method, "/"+code, nil)
+			for k, v := range step.header {
+				req.Header.Set(k, v)
+			}
+			req = mux.SetURLVars(req, map[string]string{"shortCode": code})
+			w := httptest.NewRecorder()
+			app.RedirectHandler(w, req)
+
+			if w.Code != step.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, step.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != step.wantLocation {
+				t.Errorf("Location = %q, want %q", got, step.wantLocation)
+			}
+			if strings.Contains(w.Body.String(), "example.com/secret") && step.wantLocation == "" {
+				t.Errorf("body = %s, want the destination withheld", w.Body)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler_OpenGraph(t *testing.T) {
+	const crawler = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	sale := &shortener.PageInfo{Title: "Spring <Sale>", Description: "20% off", ImageURL: "https://shop.example.com/sale.png"}
+	tests := []struct {
+		name       string
+		userAgent  string
+		page       *shortener.PageInfo
+		mask       shortener.Mask
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "crawler",
+			userAgent:  crawler,
+			page:       sale,
+			wantStatus: http.StatusOK,
+			wantBody: []string{
+				`<meta property="og:title" content="Spring &lt;Sale&gt;">`,
+				`<meta property="og:description" content="20% off">`,
+				`<meta property="og:image" content="https://shop.example.com/sale.png">`,
+				`<meta property="og:url" content="http://localhost:8080/1">`,
+				`<meta name="twitter:card" content="summary_large_image">`,
+				`<meta http-equiv="refresh" content="0; url=https://shop.example.com/sale">`,
+				`location.replace("https://shop.example.com/sale")`,
+			},
+		},
+		{name: "crawler without a page", userAgent: crawler, wantStatus: http.StatusFound},
+		{name: "crawler of a masked link", userAgent: crawler, page: sale, mask: shortener.MaskFrame, wantStatus: http.StatusOK, wantBody: []string{"<iframe"}},
+		{name: "browser", userAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", page: sale, wantStatus: http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clicks int
+			mockRepo := &shortener.MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+					return &shortener.Link{ID: id, OriginalURL: "https://shop.example.com/sale", Page: tt.page, Mask: tt.mask}, nil
+				},
+				RecordClickFunc: func(ctx context.Context, link *shortener.Link, at time.Time, src shortener.ClickSource) error {
+					clicks++
+					return nil
+				},
+			}
+			app := &App{
+				Service: shortener.NewService(mockRepo, shortener.WithMasking(shortener.MaskFrame)),
+				BaseURL: "http://localhost:8080",
+			}
+
+			req := httptest.NewRequest("GET", "/1", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req = mux.SetURLVars(req, map[string]string{"shortCode": "1"})
+			w := httptest.NewRecorder()
+			app.RedirectHandler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body = %s, want %s", w.Body, want)
+				}
+			}
+			// Crawlers only preview links, browsers follow them
+			wantClicks := 0
+			if tt.userAgent != crawler {
+				wantClicks = 1
+			}
+			if clicks != wantClicks {
+				t.Errorf("recorded %d clicks, want %d", clicks, wantClicks)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler_DoNotTrack(t *testing.T) {
+	var sources []shortener.ClickSource
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://www.google.com"}, nil
+		},
+		RecordClickFunc: func(ctx context.Context, link *shortener.Link, at time.Time, src shortener.ClickSource) error {
+			sources = append(sources, src)
+			return nil
+		},
+	}
+	sink := &recordingEvents{}
+	app := &App{
+		Service:         shortener.NewService(mockRepo),
+		BaseURL:         "http://localhost:8080",
+		Events:          sink,
+		HonorDoNotTrack: true,
+	}
+
+	redirect := func(header, value string) {
+		req := httptest.NewRequest("GET", "/1?utm_source=newsletter", nil)
+		req = mux.SetURLVars(req, map[string]string{"shortCode": "1"})
+		req.Header.Set("User-Agent", "curl/8.0")
+		req.Header.Set("Referer", "https://news.example/")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		app.RedirectHandler(w, req)
+		if w.Code != http.StatusFound {
+			t.Fatalf("%s: %s status = %d, want 302", header, value, w.Code)
+		}
+	}
+	redirect("DNT", "1")
+	redirect("Sec-GPC", "1")
+	redirect("DNT", "0")
+
+	if len(sources) != 3 || len(sink.clicks) != 3 {
+		t.Fatalf("recorded %d clicks and published %d events, want 3 each", len(sources), len(sink.clicks))
+	}
+	for i, c := range sink.clicks[:2] {
+		if sources[i] != (shortener.ClickSource{Referrer: shortener.UntrackedReferrer}) || c.IP != "" || c.UserAgent != "" || c.Referrer != "" {
+			t.Errorf("opted-out click %d recorded %+v and published %+v, want only a count", i, sources[i], c)
+		}
+	}
+	if c := sink.clicks[2]; sources[2].Referrer == "" || sources[2].UTM.Source != "newsletter" || c.UserAgent == "" || c.Referrer == "" {
+		t.Errorf("tracked click recorded %+v and published %+v, want its source", sources[2], c)
+	}
+}
+
+func TestRedirectHandler_PublishesClickEvent(t *testing.T) {
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			if id != 1 {
+				return nil, shortener.ErrNotFound
+			}
+			return &shortener.Link{ID: id, OriginalURL: "https://www.google.com"}, nil
+		},
+	}
+	sink := &recordingEvents{}
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+		Events:  sink,
+	}
+
+	redirect := func(code string) int {
+		req := httptest.NewRequest("GET", "/"+code, nil)
+		req = mux.SetURLVars(req, map[string]string{"shortCode": code})
+		req.Header.Set("User-Agent", "curl/8.0")
+		req.Header.Set("Referer", "https://news.example/")
+		w := httptest.NewRecorder()
+		app.RedirectHandler(w, req)
+		return w.Code
+	}
+
+	before := time.Now().UTC()
+	if code := redirect("1"); code != http.StatusFound {
+		t.Fatalf("status = %d, want 302", code)
+	}
+	if code := redirect("2"); code != http.StatusNotFound {
+		t.Fatalf("unknown code status = %d, want 404", code)
+	}
+
+	// Only the successful redirect is published
+	if len(sink.clicks) != 1 {
+		t.Fatalf("published %d events, want 1", len(sink.clicks))
+	}
+	c := sink.clicks[0]
+	if c.ShortCode != "1" || c.IP != "192.0.2.1" || c.UserAgent != "curl/8.0" || c.Referrer != "https://news.example/" {
+		t.Errorf("event = %+v", c)
+	}
+	if c.Timestamp.Before(before) || c.Timestamp.Location() != time.UTC {
+		t.Errorf("event timestamp = %v, want UTC time of the redirect", c.Timestamp)
+	}
+}
+
+func TestShortenHandler_ContentType(t *testing.T) {
+	// Test that response has correct Content-Type header
+	mockRepo := &shortener.MockRepository{
+		SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
+			return 1, nil
+		},
+	}
+
+	service := shortener.NewService(mockRepo)
+	app := &App{
+		Service: service,
+		BaseURL: "http://localhost:8080",
+	}
+
+	req := httptest.NewRequest("POST", "/api/shorten",
+		bytes.NewBufferString(`{"url":"https://www.google.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	app.ShortenHandler(w, req)
+
+	contentType := w.Header().Get("Content-Type")
+	if contentType != "application/json" {
+		t.Errorf("Expected Content-Type 'application/json', got '%s'", contentType)
+	}
+}
+
+func TestCanonicalizeHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		maxBatch       int
+		expectedStatus int
+		wantResults    []CanonicalizeResult
+	}{
+		{
+			name: "mixed batch",
+			requestBody: `{"urls":[
+				"HTTPS://Example.COM/Path",
+				"https://bücher.example/",
+				"https://example.com/?utm_source=x&id=1&fbclid=y",
+				"ftp://example.com"
+			]}`,
+			expectedStatus: http.StatusOK,
+			wantResults: []CanonicalizeResult{
+				{Input: "HTTPS://Example.COM/Path", Canonical: "https://example.com/Path", Valid: true},
+				{Input: "https://bücher.example/", Canonical: "https://xn--bcher-kva.example/", Valid: true},
+				{Input: "https://example.com/?utm_source=x&id=1&fbclid=y", Canonical: "https://example.com/?id=1", Valid: true},
+				{Input: "ftp://example.com", Valid: false},
+			},
+		},
+		{
+			name:           "empty batch",
+			requestBody:    `{"urls":[]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid JSON",
+			requestBody:    `{invalid json}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "batch exceeds limit",
+			requestBody:    `{"urls":["https://a.example","https://b.example","https://c.example"]}`,
+			maxBatch:       2,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The canonicalize endpoint must never touch the repository
+			mockRepo := &shortener.MockRepository{
+				SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
+					t.Errorf("Save() must not be called, got %s", link.OriginalURL)
+					return 0, nil
+				},
+			}
+
+			app := &App{
+				Service:              shortener.NewService(mockRepo),
+				BaseURL:              "http://localhost:8080",
+				CanonicalizeMaxBatch: tt.maxBatch,
+			}
+
+			req := httptest.NewRequest("POST", "/api/canonicalize", bytes.NewBufferString(tt.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			app.CanonicalizeHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.wantResults == nil {
+				return
+			}
+
+			var resp CanonicalizeResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(resp.Results) != len(tt.wantResults) {
+				t.Fatalf("Expected %d results, got %d", len(tt.wantResults), len(resp.Results))
+			}
+			for i, want := range tt.wantResults {
+				got := resp.Results[i]
+				if got.Input != want.Input || got.Canonical != want.Canonical || got.Valid != want.Valid {
+					t.Errorf("Result %d = %+v, want %+v", i, got, want)
+				}
+				if !got.Valid && got.Error == "" {
+					t.Errorf("Result %d: expected error message for invalid URL", i)
+				}
+			}
+		})
+	}
+}
+
+func TestShortenHandler_CustomAlias(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		saveCodeErr    error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "custom alias accepted",
+			requestBody:    `{"url":"https://example.com","custom_alias":"spring-sale"}`,
+			expectedStatus: http.StatusOK,
+			expectedCode:   "spring-sale",
+		},
+		{
+			name:           "custom alias already taken",
+			requestBody:    `{"url":"https://example.com","custom_alias":"spring-sale"}`,
+			saveCodeErr:    shortener.ErrConflict,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "custom alias with invalid characters",
+			requestBody:    `{"url":"https://example.com","custom_alias":"no spaces!"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "slug accepted",
+			requestBody:    `{"url":"https://example.com","slug_from":"Spring Sale 24"}`,
+			expectedStatus: http.StatusOK,
+			expectedCode:   "spring-sale-24",
+		},
+		{
+			name:           "slug and all its numbered variants taken",
+			requestBody:    `{"url":"https://example.com","slug_from":"Spring Sale 24"}`,
+			saveCodeErr:    shortener.ErrConflict,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "slug without letters",
+			requestBody:    `{"url":"https://example.com","slug_from":"!!!"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "slug with custom alias",
+			requestBody:    `{"url":"https://example.com","custom_alias":"spring","slug_from":"Spring Sale 24"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &shortener.MockRepository{
+				SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
+					return 1, tt.saveCodeErr
+				},
+			}
+
+			app := &App{
+				Service: shortener.NewService(mockRepo),
+				BaseURL: "http://localhost:8080",
+			}
+
+			req := httptest.NewRequest("POST", "/api/shorten", bytes.NewBufferString(tt.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			app.ShortenHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp ShortenResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.ShortCode != tt.expectedCode {
+				t.Errorf("Expected short_code '%s', got '%s'", tt.expectedCode, resp.ShortCode)
+			}
+			if resp.ShortURL != "http://localhost:8080/"+tt.expectedCode {
+				t.Errorf("Unexpected short_url '%s'", resp.ShortURL)
+			}
+		})
+	}
+}
+
+func TestShortenHandler_Expiry(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		expectedStatus int
+		wantExpiry     bool
+	}{
+		{
+			name:           "ttl_seconds sets expiry",
+			requestBody:    `{"url":"https://example.com","ttl_seconds":3600}`,
+			expectedStatus: http.StatusOK,
+			wantExpiry:     true,
+		},
+		{
+			name:           "expires_at sets expiry",
+			requestBody:    `{"url":"https://example.com","expires_at":"2999-01-01T00:00:00Z"}`,
+			expectedStatus: http.StatusOK,
+			wantExpiry:     true,
+		},
+		{
+			name:           "no expiry",
+			requestBody:    `{"url":"https://example.com"}`,
+			expectedStatus: http.StatusOK,
+			wantExpiry:     false,
+		},
+		{
+			name:           "expires_at in the past",
+			requestBody:    `{"url":"https://example.com","expires_at":"2000-01-01T00:00:00Z"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "both expires_at and ttl_seconds",
+			requestBody:    `{"url":"https://example.com","expires_at":"2999-01-01T00:00:00Z","ttl_seconds":60}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "negative ttl_seconds",
+			requestBody:    `{"url":"https://example.com","ttl_seconds":-5}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *shortener.Link
+			mockRepo := &shortener.MockRepository{
+				SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
+					saved = link
+					return 1, nil
+				},
+			}
+
+			app := &App{
+				Service: shortener.NewService(mockRepo),
+				BaseURL: "http://localhost:8080",
+			}
+
+			req := httptest.NewRequest("POST", "/api/shorten", bytes.NewBufferString(tt.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			app.ShortenHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if gotExpiry := saved.ExpiresAt != nil; gotExpiry != tt.wantExpiry {
+				t.Errorf("Expected expiry set = %v, got %v", tt.wantExpiry, gotExpiry)
+			}
+		})
+	}
+}
+
+func TestRedirectHandler_Expired(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Minute)
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://example.com", ExpiresAt: &expiredAt}, nil
+		},
+	}
+
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+	}
+
+	req := httptest.NewRequest("GET", "/b", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortCode": "b"})
+	w := httptest.NewRecorder()
+
+	app.RedirectHandler(w, req)
+
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status 410 Gone, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "" {
+		t.Errorf("Expected no Location header for expired link, got '%s'", location)
+	}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	t.Run("succeeds after transient failures", func(t *testing.T) {
+		calls := 0
+		ping := func(ctx context.Context) error {
+			calls++
+			if calls < 2 {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := pingWithRetry(ctx, ping); err != nil {
+			t.Fatalf("pingWithRetry() unexpected error = %v", err)
+		}
+		if calls != 2 {
+			t.Errorf("Expected 2 ping attempts, got %d", calls)
+		}
+	})
+
+	t.Run("gives up when context is done", func(t *testing.T) {
+		pingErr := errors.New("connection refused")
+		ping := func(ctx context.Context) error { return pingErr }
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := pingWithRetry(ctx, ping); !errors.Is(err, pingErr) {
+			t.Errorf("pingWithRetry() error = %v, want last ping error", err)
+		}
+	})
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		port   int
+		target string
+		want   string
+	}{
+		{port: 443, target: "http://sho.rt/abc?utm_source=mail", want: "https://sho.rt/abc?utm_source=mail"},
+		{port: 443, target: "http://sho.rt:80/abc", want: "https://sho.rt/abc"},
+		{port: 8443, target: "http://sho.rt:8080/abc", want: "https://sho.rt:8443/abc"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		httpsRedirect(tt.port).ServeHTTP(rr, httptest.NewRequest("POST", tt.target, nil))
+		if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != tt.want {
+			t.Errorf("httpsRedirect(%d) %s = %d to %q, want 308 to %q", tt.port, tt.target, rr.Code, rr.Header().Get("Location"), tt.want)
+		}
+	}
+
+	if tlsConfig, _ := newTLS(&config.Config{}); tlsConfig != nil {
+		t.Error("newTLS() without certificates = TLS config, want nil")
+	}
+	tlsConfig, challenges := newTLS(&config.Config{AutocertDomains: []string{"sho.rt"}, AutocertCacheDir: t.TempDir()})
+	if tlsConfig == nil || tlsConfig.GetCertificate == nil || !slices.Contains(tlsConfig.NextProtos, "h2") {
+		t.Errorf("newTLS() with autocert = %+v, want certificates on demand over HTTP/2", tlsConfig)
+	}
+	// Non-challenge requests go to the wrapped handler
+	rr := httptest.NewRecorder()
+	challenges(httpsRedirect(443)).ServeHTTP(rr, httptest.NewRequest("GET", "http://sho.rt/abc", nil))
+	if rr.Header().Get("Location") != "https://sho.rt/abc" {
+		t.Errorf("challenge handler Location = %q, want the HTTPS redirect", rr.Header().Get("Location"))
+	}
+}
+
+func TestRedirectHandler_ClickLimitReached(t *testing.T) {
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://example.com", MaxClicks: 1}, nil
+		},
+		IncrementClicksFunc: func(ctx context.Context, link *shortener.Link) (int64, error) {
+			return 2, nil
+		},
+	}
+
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+	}
+
+	req := httptest.NewRequest("GET", "/b", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortCode": "b"})
+	w := httptest.NewRecorder()
+
+	app.RedirectHandler(w, req)
+
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status 410 Gone, got %d", w.Code)
+	}
+}
+
+func TestShortenHandler_MaxClicks(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		wantMaxClicks  int64
+	}{
+		{name: "with max_clicks", body: `{"url":"https://example.com","max_clicks":1}`, expectedStatus: http.StatusOK, wantMaxClicks: 1},
+		{name: "negative max_clicks", body: `{"url":"https://example.com","max_clicks":-1}`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *shortener.Link
+			mockRepo := &shortener.MockRepository{
+				SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
+					saved = link
+					return 1, nil
+				},
+			}
+			app := &App{
+				Service: shortener.NewService(mockRepo),
+				BaseURL: "http://localhost:8080",
+			}
+
+			req := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			app.ShortenHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus == http.StatusOK && saved.MaxClicks != tt.wantMaxClicks {
+				t.Errorf("Expected saved max_clicks %d, got %d", tt.wantMaxClicks, saved.MaxClicks)
+			}
+		})
+	}
+}
+
+func TestShortenHandler_UTM(t *testing.T) {
+	var saved *shortener.Link
+	mockRepo := &shortener.MockRepository{
+		SaveFunc: func(ctx context.Context, link *shortener.Link) (uint64, error) {
+			saved = link
+			return 1, nil
+		},
+	}
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+	}
+
+	body := `{"url":"https://example.com/sale?ref=home","utm_source":"newsletter","utm_medium":"email","utm_campaign":"spring"}`
+	req := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	app.ShortenHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	want := "https://example.com/sale?ref=home&utm_source=newsletter&utm_medium=email&utm_campaign=spring"
+	if saved.OriginalURL != want {
+		t.Errorf("Expected saved URL %q, got %q", want, saved.OriginalURL)
+	}
+}
+
+func TestActiveWindow(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name           string
+		link           *shortener.Link
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "not yet active",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com/launch", ActiveFrom: &future},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   apierror.NotYetActive,
+		},
+		{
+			name:           "inside window",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com/launch", ActiveFrom: &past, ActiveUntil: &future},
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:           "no longer active",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com/launch", ActiveUntil: &past},
+			expectedStatus: http.StatusGone,
+			expectedCode:   apierror.NoLongerActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &shortener.MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+					return tt.link, nil
+				},
+			}
+			app := &App{Service: shortener.NewService(mockRepo), BaseURL: "http://localhost:8080"}
+
+			for _, path := range []string{"/b", "/preview/b"} {
+				req := httptest.NewRequest("GET", path, nil)
+				req = mux.SetURLVars(req, map[string]string{"shortCode": "b"})
+				w := httptest.NewRecorder()
+				if path == "/b" {
+					app.RedirectHandler(w, req)
+				} else {
+					app.PreviewHandler(w, req)
+				}
+
+				want := tt.expectedStatus
+				if path != "/b" && want == http.StatusFound {
+					want = http.StatusOK
+				}
+				if w.Code != want {
+					t.Errorf("GET %s: expected status %d, got %d", path, want, w.Code)
+				}
+				if tt.expectedCode != "" {
+					var resp apierror.Response
+					if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Error.Code != tt.expectedCode {
+						t.Errorf("GET %s: expected error code %q, got %+v (%v)", path, tt.expectedCode, resp, err)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("invalid window", func(t *testing.T) {
+		app := &App{Service: shortener.NewService(&shortener.MockRepository{}), BaseURL: "http://localhost:8080"}
+
+		body := `{"url":"https://example.com","active_from":"2030-01-02T00:00:00Z","active_until":"2030-01-01T00:00:00Z"}`
+		req := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		app.ShortenHandler(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+type stubMetadataFetcher struct {
+	md  *metadata.Metadata
+	err error
+}
+
+func (s stubMetadataFetcher) Fetch(ctx context.Context, rawURL string) (*metadata.Metadata, error) {
+	return s.md, s.err
+}
+
+func TestPreviewHandler(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name           string
+		shortCode      string
+		link           *shortener.Link
+		fetcher        MetadataFetcher
+		expectedStatus int
+		wantContains   []string
+	}{
+		{
+			name:           "with title",
+			shortCode:      "b",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com/?q=<script>"},
+			fetcher:        stubMetadataFetcher{md: &metadata.Metadata{Title: "Example Domain"}},
+			expectedStatus: http.StatusOK,
+			wantContains:   []string{"Example Domain", "https://example.com/?q=&lt;script&gt;", `href="http://localhost:8080/1"`},
+		},
+		{
+			name:           "stored page",
+			shortCode:      "b",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com", Page: &shortener.PageInfo{Title: "Stored title", Description: "Stored description"}},
+			fetcher:        stubMetadataFetcher{err: errors.New("must not be fetched")},
+			expectedStatus: http.StatusOK,
+			wantContains:   []string{"Stored title", "Stored description"},
+		},
+		{
+			name:           "metadata fetch failure still renders",
+			shortCode:      "b",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com"},
+			fetcher:        stubMetadataFetcher{err: errors.New("timeout")},
+			expectedStatus: http.StatusOK,
+			wantContains:   []string{"https://example.com"},
+		},
+		{
+			name:           "expired",
+			shortCode:      "b",
+			link:           &shortener.Link{ID: 1, OriginalURL: "https://example.com", ExpiresAt: &expiredAt},
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "not found",
+			shortCode:      "b",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &shortener.MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+					if tt.link == nil {
+						return nil, shortener.ErrNotFound
+					}
+					return tt.link, nil
+				},
+				IncrementClicksFunc: func(ctx context.Context, link *shortener.Link) (int64, error) {
+					t.Error("preview must not count clicks")
+					return 0, nil
+				},
+			}
+
+			app := &App{
+				Service:  shortener.NewService(mockRepo),
+				BaseURL:  "http://localhost:8080",
+				Metadata: tt.fetcher,
+			}
+
+			req := httptest.NewRequest("GET", "/preview/"+tt.shortCode, nil)
+			req = mux.SetURLVars(req, map[string]string{"shortCode": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			app.PreviewHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			body := w.Body.String()
+			for _, want := range tt.wantContains {
+				if !strings.Contains(body, want) {
+					t.Errorf("Expected body to contain %q, got:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	var status shortener.Status
+	var invalidated, deleted uint64
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			if id != 1 {
+				return nil, shortener.ErrNotFound
+			}
+			return &shortener.Link{ID: 1, OriginalURL: "https://example.com/sale", Status: status}, nil
+		},
+		SearchFunc: func(ctx context.Context, query string, cursor uint64, limit int) ([]*shortener.Link, error) {
+			if query != "sale" {
+				return nil, nil
+			}
+			return []*shortener.Link{{ID: 1, OriginalURL: "https://example.com/sale", Status: status}}, nil
+		},
+		SetStatusFunc: func(ctx context.Context, link *shortener.Link, s shortener.Status) error {
+			status = s
+			return nil
+		},
+		InvalidateFunc: func(ctx context.Context, link *shortener.Link) error {
+			invalidated = link.ID
+			return nil
+		},
+		DeleteFunc: func(ctx context.Context, link *shortener.Link) error {
+			deleted = link.ID
+			return nil
+		},
+		StatsFunc: func(ctx context.Context, now time.Time) (*shortener.Stats, error) {
+			return &shortener.Stats{Total: 1, Disabled: 1}, nil
+		},
+	}
+	var audit []*shortener.AuditEntry
+	var auditFilter shortener.AuditFilter
+	mockRepo.AddAuditFunc = func(ctx context.Context, entry *shortener.AuditEntry) error {
+		entry.ID = uint64(len(audit)) + 1
+		audit = append(audit, entry)
+		return nil
+	}
+	mockRepo.ListAuditFunc = func(ctx context.Context, f shortener.AuditFilter, cursor uint64, limit int) ([]*shortener.AuditEntry, error) {
+		auditFilter = f
+		return audit, nil
+	}
+	app := &App{Service: shortener.NewService(mockRepo), BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("rejects missing or wrong token", func(t *testing.T) {
+		for _, auth := range []string{"", "Bearer wrong-token-000000", token} {
+			if w := do("GET", "/api/admin/stats", auth); w.Code != http.StatusUnauthorized {
+				t.Errorf("Authorization %q: status = %d, want 401", auth, w.Code)
+			}
+		}
+	})
+
+	t.Run("disable and enable", func(t *testing.T) {
+		if w := do("POST", "/api/admin/links/1/disable", "Bearer "+token); w.Code != http.StatusNoContent {
+			t.Fatalf("disable status = %d, want 204", w.Code)
+		}
+		if w := do("GET", "/1", ""); w.Code != http.StatusGone {
+			t.Errorf("redirect of disabled link status = %d, want 410", w.Code)
+		}
+		if w := do("POST", "/api/admin/links/1/enable", "Bearer "+token); w.Code != http.StatusNoContent {
+			t.Fatalf("enable status = %d, want 204", w.Code)
+		}
+		if w := do("GET", "/1", ""); w.Code != http.StatusFound {
+			t.Errorf("redirect of enabled link status = %d, want 302", w.Code)
+		}
+		if w := do("POST", "/api/admin/links/2/disable", "Bearer "+token); w.Code != http.StatusNotFound {
+			t.Errorf("disable missing link status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		w := do("GET", "/api/admin/links?q=sale", "Bearer "+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var resp ListURLsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(resp.URLs) != 1 || resp.URLs[0].ShortCode != "1" || resp.URLs[0].Status != "active" {
+			t.Errorf("URLs = %+v", resp.URLs)
+		}
+	})
+
+	t.Run("get link", func(t *testing.T) {
+		w := do("GET", "/api/admin/links/1", "Bearer "+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var resp URLResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.ShortCode != "1" || resp.OriginalURL != "https://example.com/sale" {
+			t.Errorf("link = %+v", resp)
+		}
+		if w := do("GET", "/api/admin/links/2", "Bearer "+token); w.Code != http.StatusNotFound {
+			t.Errorf("missing link status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		if w := do("POST", "/api/admin/links/1/invalidate", "Bearer "+token); w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want 204", w.Code)
+		}
+		if invalidated != 1 {
+			t.Errorf("invalidated id = %d, want 1", invalidated)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		w := do("GET", "/api/admin/metrics", "Bearer "+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var vars map[string]json.RawMessage
+		if err := json.NewDecoder(w.Body).Decode(&vars); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if _, ok := vars["cache"]; !ok {
+			t.Errorf("metrics = %v, want cache", slices.Sorted(maps.Keys(vars)))
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		w := do("GET", "/api/admin/stats", "Bearer "+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var stats shortener.Stats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if stats.Total != 1 || stats.Disabled != 1 {
+			t.Errorf("stats = %+v", stats)
+		}
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		w := do("GET", "/api/admin/audit?actor=admin&code=1&since=2025-01-01T00:00:00Z", "Bearer "+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		var resp ListAuditResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		// Recorded by the disable and enable subtest
+		if len(resp.Entries) != 2 || resp.Entries[0].Action != shortener.AuditDisable || resp.Entries[1].Action != shortener.AuditEnable ||
+			resp.Entries[0].Actor != shortener.ActorAdmin || resp.Entries[0].Code != "1" {
+			t.Errorf("entries = %+v", resp.Entries)
+		}
+		want := shortener.AuditFilter{Actor: "admin", Code: "1", Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+		if auditFilter != want {
+			t.Errorf("filter = %+v, want %+v", auditFilter, want)
+		}
+
+		if w := do("GET", "/api/admin/audit?until=yesterday", "Bearer "+token); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid until status = %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := do("DELETE", "/api/admin/links/1", "Bearer "+token); w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want 204", w.Code)
+		}
+		if deleted != 1 {
+			t.Errorf("deleted id = %d, want 1", deleted)
+		}
+		if w := do("DELETE", "/api/admin/links/2", "Bearer "+token); w.Code != http.StatusNotFound {
+			t.Errorf("missing link status = %d, want 404", w.Code)
+		}
+	})
+}
+
+type stubURLChecker map[string]string
+
+func (c stubURLChecker) Check(ctx context.Context, urls []string) (map[string]string, error) {
+	return c, nil
+}
+
+func TestShortenHandler_UnsafeURL(t *testing.T) {
+	checker := stubURLChecker{"https://malware.test/": "MALWARE"}
+	app := &App{
+		Service: shortener.NewService(&shortener.MockRepository{}, shortener.WithURLChecker(checker)),
+		BaseURL: "http://localhost:8080",
+	}
+
+	req := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://malware.test/"}`))
+	w := httptest.NewRecorder()
+
+	app.ShortenHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "unsafe") {
+		t.Errorf("Expected unsafe URL message, got %q", w.Body.String())
+	}
+}
+
+func TestRedirectHandler_Flagged(t *testing.T) {
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			return &shortener.Link{ID: id, OriginalURL: "https://example.com", Status: shortener.StatusFlagged}, nil
+		},
+	}
+
+	app := &App{
+		Service: shortener.NewService(mockRepo),
+		BaseURL: "http://localhost:8080",
+	}
+
+	req := httptest.NewRequest("GET", "/b", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortCode": "b"})
+	w := httptest.NewRecorder()
+
+	app.RedirectHandler(w, req)
+
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status 410 Gone, got %d", w.Code)
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Minute)
+	mockRepo := &shortener.MockRepository{
+		GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+			if id == 11 { // "b"
+				return &shortener.Link{ID: id, OriginalURL: "https://example.com", ExpiresAt: &expiredAt}, nil
+			}
+			return nil, shortener.ErrNotFound
+		},
+	}
+
+	dir := t.TempDir()
+	custom := `<h1>{{.Title}}</h1><p>{{.Message}} ({{.ShortCode}})</p>`
+	if err := os.WriteFile(filepath.Join(dir, "expired.html"), []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	builtin, err := LoadErrorPages("", "http://localhost:8080")
+	if err != nil {
+		t.Fatalf("LoadErrorPages() error = %v", err)
+	}
+	customPages, err := LoadErrorPages(dir, "http://localhost:8080")
+	if err != nil {
+		t.Fatalf("LoadErrorPages() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		pages       *ErrorPages
+		shortCode   string
+		accept      string
+		wantStatus  int
+		wantType    string
+		wantContain string
+	}{
+		{"browser gets html", builtin, "c", "text/html,application/xhtml+xml;q=0.9", http.StatusNotFound, "text/html; charset=utf-8", "Link not found"},
+		{"api client gets json", builtin, "c", "", http.StatusNotFound, "application/json", `{"error":{"code":"not_found","message":"URL not found"}}`},
+		{"json client gets json", builtin, "c", "application/json", http.StatusNotFound, "application/json", `"code":"not_found"`},
+		{"expired page", builtin, "b", "text/html", http.StatusGone, "text/html; charset=utf-8", "Link expired"},
+		{"custom page", customPages, "b", "text/html", http.StatusGone, "text/html; charset=utf-8", "<h1>Link expired</h1><p>URL has expired (b)</p>"},
+		{"custom dir falls back to built-in", customPages, "c", "text/html", http.StatusNotFound, "text/html; charset=utf-8", "Go to the home page"},
+		{"no pages", nil, "c", "text/html", http.StatusNotFound, "application/json", "URL not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{
+				Service:    shortener.NewService(mockRepo),
+				BaseURL:    "http://localhost:8080",
+				ErrorPages: tt.pages,
+			}
+
+			req := httptest.NewRequest("GET", "/"+tt.shortCode, nil)
+			req = mux.SetURLVars(req, map[string]string{"shortCode": tt.shortCode})
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			app.RedirectHandler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.wantType, ct)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantContain) {
+				t.Errorf("Expected body to contain %q, got %q", tt.wantContain, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("invalid template", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "error.html"), []byte("{{.Title"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadErrorPages(dir, ""); err == nil {
+			t.Error("Expected error for invalid template")
+		}
+	})
+
+	t.Run("missing dir", func(t *testing.T) {
+		if _, err := LoadErrorPages(filepath.Join(t.TempDir(), "missing"), ""); err == nil {
+			t.Error("Expected error for missing directory")
+		}
+	})
+}
+
+func TestRootFiles(t *testing.T) {
+	dir := t.TempDir()
+	robotsPath := filepath.Join(dir, "robots.txt")
+	if err := os.WriteFile(robotsPath, []byte("User-agent: *\nAllow: /\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	builtin, err := LoadRootFiles("", "")
+	if err != nil {
+		t.Fatalf("LoadRootFiles() error = %v", err)
+	}
+	custom, err := LoadRootFiles("", robotsPath)
+	if err != nil {
+		t.Fatalf("LoadRootFiles() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		files       RootFiles
+		method      string
+		path        string
+		wantType    string
+		wantContain string
+	}{
+		{"built-in robots.txt", builtin, "GET", "/robots.txt", "text/plain; charset=utf-8", "Disallow: /\n"},
+		{"custom robots.txt", custom, "GET", "/robots.txt", "text/plain; charset=utf-8", "Allow: /\n"},
+		{"favicon", builtin, "GET", "/favicon.ico", "image/x-icon", "\x00\x00\x01\x00"},
+		{"favicon head", builtin, "HEAD", "/favicon.ico", "image/x-icon", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			registerRootFiles(r, tt.files)
+			r.HandleFunc("/{shortCode}", func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("%s looked up as a short code", r.URL.Path)
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.wantType, ct)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+				t.Errorf("Expected Cache-Control to allow caching, got %q", cc)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantContain) {
+				t.Errorf("Expected body to contain %q, got %q", tt.wantContain, w.Body.String())
+			}
+
+			// Clients revalidate with the ETag
+			req = httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusNotModified {
+				t.Errorf("Expected status 304 for a matching ETag, got %d", w.Code)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadRootFiles(filepath.Join(dir, "missing.ico"), ""); err == nil {
+			t.Error("Expected error for missing favicon")
+		}
+	})
+}
+
+func TestWellKnown(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"apple-app-site-association": `{"applinks":{"details":[]}}`,
+		"assetlinks.json":            `[]`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ownDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(ownDir, "security.txt"), []byte("Contact: mailto:own@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	contacts := []string{"mailto:security@example.com", "https://example.com/security"}
+
+	tests := []struct {
+		name        string
+		dir         string
+		contacts    []string
+		path        string
+		wantStatus  int
+		wantType    string
+		wantContain string
+	}{
+		{"generated security.txt", "", contacts, "/.well-known/security.txt", http.StatusOK, "text/plain; charset=utf-8",
+			"Contact: mailto:security@example.com\nContact: https://example.com/security\nExpires: 2026-07-01T03:04:05Z\nCanonical: https://sho.rt/.well-known/security.txt\n"},
+		{"security.txt of the directory", ownDir, contacts, "/.well-known/security.txt", http.StatusOK, "text/plain; charset=utf-8", "Contact: mailto:own@example.com\n"},
+		{"no contacts", "", nil, "/.well-known/security.txt", http.StatusNotFound, "application/json", `"code":"not_found"`},
+		{"apple app site association", dir, nil, "/.well-known/apple-app-site-association", http.StatusOK, "application/json", `{"applinks"`},
+		{"asset links", dir, nil, "/.well-known/assetlinks.json", http.StatusOK, "application/json", `[]`},
+		{"unknown file", dir, nil, "/.well-known/change-password", http.StatusNotFound, "application/json", `"code":"not_found"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := LoadRootFiles("", "")
+			if err != nil {
+				t.Fatalf("LoadRootFiles() error = %v", err)
+			}
+			if err := files.LoadWellKnown(tt.dir, tt.contacts, "https://sho.rt", now); err != nil {
+				t.Fatalf("LoadWellKnown() error = %v", err)
+			}
+			r := mux.NewRouter()
+			registerRootFiles(r, files)
+			r.HandleFunc("/{shortCode}", func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("%s looked up as a short code", r.URL.Path)
+			})
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.wantType, ct)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantContain) {
+				t.Errorf("Expected body to contain %q, got %q", tt.wantContain, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("missing dir", func(t *testing.T) {
+		files, err := LoadRootFiles("", "")
+		if err != nil {
+			t.Fatalf("LoadRootFiles() error = %v", err)
+		}
+		if err := files.LoadWellKnown(filepath.Join(dir, "missing"), nil, "", now); err == nil {
+			t.Error("Expected error for missing directory")
+		}
+	})
+}
+
+func TestReportHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		shortCode      string
+		body           string
+		expectedStatus int
+	}{
+		{name: "with reason", shortCode: "1", body: `{"reason":"phishing"}`, expectedStatus: http.StatusAccepted},
+		{name: "without body", shortCode: "1", body: "", expectedStatus: http.StatusAccepted},
+		{name: "invalid body", shortCode: "1", body: `{`, expectedStatus: http.StatusBadRequest},
+		{name: "reason too long", shortCode: "1", body: `{"reason":"` + strings.Repeat("x", 501) + `"}`, expectedStatus: http.StatusBadRequest},
+		{name: "unknown link", shortCode: "2", body: "", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reporter string
+			mockRepo := &shortener.MockRepository{
+				GetFunc: func(ctx context.Context, id uint64) (*shortener.Link, error) {
+					if id != 1 {
+						return nil, shortener.ErrNotFound
+					}
+					return &shortener.Link{ID: id, OriginalURL: "https://example.com"}, nil
+				},
+				AddReportFunc: func(ctx context.Context, report *shortener.Report) (int64, error) {
+					reporter = report.Reporter
+					return 1, nil
+				},
+			}
+			app := &App{Service: shortener.NewService(mockRepo)}
+
+			req := httptest.NewRequest("POST", "/api/report/"+tt.shortCode, strings.NewReader(tt.body))
+			req.RemoteAddr = "192.0.2.1:1234"
+			req = mux.SetURLVars(req, map[string]string{"shortCode": tt.shortCode})
+			w := httptest.NewRecorder()
+
+			app.ReportHandler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus == http.StatusAccepted && reporter != "192.0.2.1" {
+				t.Errorf("Expected reporter 192.0.2.1, got %q", reporter)
+			}
+		})
+	}
+}
+
+func TestAccountAPI(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signup := func(email string) string {
+		t.Helper()
+		w := do("POST", "/api/auth/signup", "", `{"email":"`+email+`","password":"password1"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("signup status = %d, want 201: %s", w.Code, w.Body)
+		}
+		var resp AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp.APIKey
+	}
+
+	alice := signup("alice@example.com")
+	bob := signup("bob@example.com")
+
+	if w := do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate signup status = %d, want 409", w.Code)
+	}
+	if w := do("POST", "/api/auth/signup", "", `{"email":"carol@example.com","password":"short"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("weak password signup status = %d, want 400", w.Code)
+	}
+	if w := do("POST", "/api/auth/login", "", `{"email":"alice@example.com","password":"wrong-password"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad login status = %d, want 401", w.Code)
+	}
+
+	w := do("POST", "/api/shorten", alice, `{"url":"https://example.com/a"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("shorten status = %d, want 200", w.Code)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(w.Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if w := do("POST", "/api/shorten", "sk_invalid", `{"url":"https://example.com/b"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("shorten with invalid key status = %d, want 401", w.Code)
+	}
+
+	t.Run("list own urls", func(t *testing.T) {
+		if w := do("GET", "/api/me/urls", "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous status = %d, want 401", w.Code)
+		}
+		var resp ListURLsResponse
+		if err := json.NewDecoder(do("GET", "/api/me/urls", alice, "").Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(resp.URLs) != 1 || resp.URLs[0].ShortCode != shortened.ShortCode {
+			t.Errorf("alice URLs = %+v", resp.URLs)
+		}
+		if err := json.NewDecoder(do("GET", "/api/me/urls", bob, "").Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(resp.URLs) != 0 {
+			t.Errorf("bob URLs = %+v, want none", resp.URLs)
+		}
+	})
+
+	t.Run("update and delete", func(t *testing.T) {
+		path := "/api/urls/" + shortened.ShortCode
+		if w := do("PATCH", path, bob, `{"url":"https://example.com/evil"}`); w.Code != http.StatusForbidden {
+			t.Errorf("update by non-owner status = %d, want 403", w.Code)
+		}
+		if w := do("PATCH", path, alice, `{"url":"ftp://example.com"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("update with invalid url status = %d, want 400", w.Code)
+		}
+		w := do("PATCH", path, alice, `{"url":"https://example.com/new"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("update status = %d, want 200", w.Code)
+		}
+		var updated URLResponse
+		if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if updated.OriginalURL != "https://example.com/new" {
+			t.Errorf("updated OriginalURL = %q", updated.OriginalURL)
+		}
+
+		// Attributes change without touching the destination
+		if w := do("PATCH", path, alice, `{}`); w.Code != http.StatusBadRequest {
+			t.Errorf("empty update status = %d, want 400", w.Code)
+		}
+		if w := do("PATCH", path, alice, `{"ttl_seconds":60,"no_expiry":true}`); w.Code != http.StatusBadRequest {
+			t.Errorf("conflicting expiry update status = %d, want 400", w.Code)
+		}
+		if w := do("PATCH", path, alice, `{"expires_at":"2000-01-01T00:00:00Z"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("past expiry update status = %d, want 400", w.Code)
+		}
+		w = do("PATCH", path, alice, `{"ttl_seconds":3600,"max_clicks":10}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("attribute update status = %d, want 200", w.Code)
+		}
+		updated = URLResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if updated.OriginalURL != "https://example.com/new" || updated.ExpiresAt == nil || updated.MaxClicks != 10 {
+			t.Errorf("updated link = %+v, want same URL, an expiry and 10 max clicks", updated)
+		}
+		if w := do("PATCH", path, alice, `{"query_passthrough":"append"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), apierror.InvalidPassthrough) {
+			t.Errorf("invalid passthrough update = %d %s, want 400 %s", w.Code, w.Body, apierror.InvalidPassthrough)
+		}
+		w = do("PATCH", path, alice, `{"query_passthrough":"merge"}`)
+		updated = URLResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if w.Code != http.StatusOK || updated.QueryPassthrough != shortener.QueryPassthroughMerge {
+			t.Errorf("passthrough update = %d %+v, want 200 and merge", w.Code, updated)
+		}
+		if w := do("PATCH", path, alice, `{"mask":"frame"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), apierror.InvalidMask) {
+			t.Errorf("mask update with masking disabled = %d %s, want 400 %s", w.Code, w.Body, apierror.InvalidMask)
+		}
+
+		// Deleting links takes an admin, who still only deletes their own
+		if w := do("DELETE", path, alice, ""); w.Code != http.StatusForbidden {
+			t.Errorf("delete by editor status = %d, want 403", w.Code)
+		}
+		for _, key := range []string{alice, bob} {
+			user, err := service.Authenticate(context.Background(), key)
+			if err != nil {
+				t.Fatalf("Authenticate() unexpected error = %v", err)
+			}
+			if err := service.SetRole(context.Background(), user.ID, shortener.RoleAdmin); err != nil {
+				t.Fatalf("SetRole() unexpected error = %v", err)
+			}
+		}
+		if w := do("DELETE", path, bob, ""); w.Code != http.StatusForbidden {
+			t.Errorf("delete by non-owner status = %d, want 403", w.Code)
+		}
+		if w := do("DELETE", path, alice, ""); w.Code != http.StatusNoContent {
+			t.Errorf("delete status = %d, want 204", w.Code)
+		}
+		if w := do("DELETE", path, alice, ""); w.Code != http.StatusNotFound {
+			t.Errorf("second delete status = %d, want 404", w.Code)
+		}
+
+		if w := do("POST", path+"/restore", bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("restore by non-owner status = %d, want 404", w.Code)
+		}
+		w = do("POST", path+"/restore", alice, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("restore status = %d, want 200", w.Code)
+		}
+		var restored URLResponse
+		if err := json.NewDecoder(w.Body).Decode(&restored); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if restored.OriginalURL != "https://example.com/new" {
+			t.Errorf("restored OriginalURL = %q", restored.OriginalURL)
+		}
+		if w := do("POST", path+"/restore", alice, ""); w.Code != http.StatusNotFound {
+			t.Errorf("second restore status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("logout revokes key", func(t *testing.T) {
+		if w := do("POST", "/api/auth/logout", bob, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("logout status = %d, want 204", w.Code)
+		}
+		if w := do("GET", "/api/me/urls", bob, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("revoked key status = %d, want 401", w.Code)
+		}
+	})
+}
+
+func TestCampaignsAPI(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signup := func(email string) string {
+		t.Helper()
+		w := do("POST", "/api/auth/signup", "", `{"email":"`+email+`","password":"password1"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("signup status = %d, want 201: %s", w.Code, w.Body)
+		}
+		var resp AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp.APIKey
+	}
+	alice := signup("alice@example.com")
+	bob := signup("bob@example.com")
+
+	if w := do("POST", "/api/campaigns", "", `{"name":"Launch"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous create status = %d, want 401", w.Code)
+	}
+	if w := do("POST", "/api/campaigns", alice, `{"name":" "}`); w.Code != http.StatusBadRequest {
+		t.Errorf("blank name status = %d, want 400", w.Code)
+	}
+	w := do("POST", "/api/campaigns", alice, `{"name":"Launch"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var campaign shortener.Campaign
+	if err := json.NewDecoder(w.Body).Decode(&campaign); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if campaign.ID == 0 || campaign.Name != "Launch" {
+		t.Errorf("created campaign = %+v", campaign)
+	}
+	if w := do("POST", "/api/campaigns", alice, `{"name":"Launch"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate create status = %d, want 409", w.Code)
+	}
+	path := "/api/campaigns/" + strconv.FormatUint(campaign.ID, 10)
+
+	t.Run("get, list and rename", func(t *testing.T) {
+		if w := do("GET", path, bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("get by non-owner status = %d, want 404", w.Code)
+		}
+		if w := do("GET", "/api/campaigns/launch", alice, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("get with invalid id status = %d, want 400", w.Code)
+		}
+		var list ListCampaignsResponse
+		if err := json.NewDecoder(do("GET", "/api/campaigns", alice, "").Body).Decode(&list); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(list.Campaigns) != 1 || list.Campaigns[0].ID != campaign.ID {
+			t.Errorf("alice campaigns = %+v", list.Campaigns)
+		}
+		if err := json.NewDecoder(do("GET", "/api/campaigns", bob, "").Body).Decode(&list); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(list.Campaigns) != 0 {
+			t.Errorf("bob campaigns = %+v, want none", list.Campaigns)
+		}
+
+		if w := do("PATCH", path, bob, `{"name":"Mine"}`); w.Code != http.StatusNotFound {
+			t.Errorf("rename by non-owner status = %d, want 404", w.Code)
+		}
+		w := do("PATCH", path, alice, `{"name":"Spring launch"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("rename status = %d, want 200", w.Code)
+		}
+		var renamed shortener.Campaign
+		if err := json.NewDecoder(w.Body).Decode(&renamed); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if renamed.Name != "Spring launch" {
+			t.Errorf("renamed campaign = %+v", renamed)
+		}
+	})
+
+	t.Run("links and stats", func(t *testing.T) {
+		body := fmt.Sprintf(`{"url":"https://example.com/a","campaign_id":%d}`, campaign.ID)
+		if w := do("POST", "/api/shorten", bob, body); w.Code != http.StatusBadRequest {
+			t.Errorf("shorten into another user's campaign status = %d, want 400", w.Code)
+		}
+		w := do("POST", "/api/shorten", alice, body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("shorten status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var shortened ShortenResponse
+		if err := json.NewDecoder(w.Body).Decode(&shortened); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		var list ListURLsResponse
+		if err := json.NewDecoder(do("GET", path+"/urls", alice, "").Body).Decode(&list); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(list.URLs) != 1 || list.URLs[0].ShortCode != shortened.ShortCode || list.URLs[0].CampaignID != campaign.ID {
+			t.Errorf("campaign URLs = %+v", list.URLs)
+		}
+		if w := do("GET", path+"/urls", bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("list by non-owner status = %d, want 404", w.Code)
+		}
+
+		// Links leave a campaign with campaign_id 0
+		linkPath := "/api/urls/" + shortened.ShortCode
+		if w := do("PATCH", linkPath, alice, `{"campaign_id":999}`); w.Code != http.StatusBadRequest {
+			t.Errorf("move to unknown campaign status = %d, want 400", w.Code)
+		}
+		w = do("PATCH", linkPath, alice, `{"campaign_id":0}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("remove from campaign status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var updated URLResponse
+		if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if updated.CampaignID != 0 {
+			t.Errorf("updated campaign_id = %d, want 0", updated.CampaignID)
+		}
+
+		w = do("GET", path+"/stats?period=1d&limit=5", alice, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("stats status = %d, want 200: %s", w.Code, w.Body)
+		}
+		if w.Header().Get("ETag") == "" {
+			t.Error("stats response has no ETag")
+		}
+		var stats shortener.CampaignStats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if stats.Campaign == nil || stats.Campaign.ID != campaign.ID || stats.Links != 0 || stats.UniqueVisitors != nil {
+			t.Errorf("stats = %+v, want an empty campaign without visitor counts", stats)
+		}
+		for _, query := range []string{"?period=soon", "?period=1h1m", "?limit=0"} {
+			if w := do("GET", path+"/stats"+query, alice, ""); w.Code != http.StatusBadRequest {
+				t.Errorf("stats%s status = %d, want 400", query, w.Code)
+			}
+		}
+		if w := do("GET", path+"/stats", bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("stats by non-owner status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := do("DELETE", path, bob, ""); w.Code != http.StatusNotFound {
+			t.Errorf("delete by non-owner status = %d, want 404", w.Code)
+		}
+		if w := do("DELETE", path, alice, ""); w.Code != http.StatusNoContent {
+			t.Errorf("delete status = %d, want 204", w.Code)
+		}
+		if w := do("GET", path, alice, ""); w.Code != http.StatusNotFound {
+			t.Errorf("get after delete status = %d, want 404", w.Code)
+		}
+	})
+}
+
+// stubTXTResolver answers TXT lookups from a map.
+type stubTXTResolver map[string][]string
+
+func (r stubTXTResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r[name], nil
+}
+
+func TestDomainsAPI(t *testing.T) {
+	resolver := stubTXTResolver{}
+	service := shortener.NewService(shortener.NewInMemoryRepository(), shortener.WithTXTResolver(resolver))
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signup := func(email string) string {
+		t.Helper()
+		w := do("POST", "/api/auth/signup", "", `{"email":"`+email+`","password":"password1"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("signup status = %d, want 201: %s", w.Code, w.Body)
+		}
+		var resp AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp.APIKey
+	}
+	alice := signup("alice@example.com")
+	bob := signup("bob@example.com")
+
+	if w := do("POST", "/api/domains", "", `{"host":"go.example.com"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous add status = %d, want 401", w.Code)
+	}
+	if w := do("POST", "/api/domains", alice, `{"host":"localhost"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid host status = %d, want 400", w.Code)
+	}
+	w := do("POST", "/api/domains", alice, `{"host":"Go.Example.com"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("add status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var domain DomainResponse
+	if err := json.NewDecoder(w.Body).Decode(&domain); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if domain.Host != "go.example.com" || domain.Verified || domain.Verification.Type != "TXT" ||
+		domain.Verification.Name != "_url-shortener.go.example.com" || domain.Verification.Value == "" {
+		t.Errorf("added domain = %+v", domain)
+	}
+	if w := do("POST", "/api/domains", alice, `{"host":"go.example.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate add status = %d, want 409", w.Code)
+	}
+	path := "/api/domains/" + strconv.FormatUint(domain.ID, 10)
+
+	if w := do("GET", path, bob, ""); w.Code != http.StatusNotFound {
+		t.Errorf("get by non-owner status = %d, want 404", w.Code)
+	}
+	if w := do("GET", "/api/domains/go", alice, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("get with invalid id status = %d, want 400", w.Code)
+	}
+	if w := do("POST", path+"/verify", alice, ""); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("verify without record status = %d, want 422", w.Code)
+	}
+	if w := do("POST", "/api/shorten", alice, `{"url":"https://example.com/a","domain":"go.example.com"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("shorten on unverified domain status = %d, want 400", w.Code)
+	}
+
+	resolver[domain.Verification.Name] = []string{domain.Verification.Value}
+	w = do("POST", path+"/verify", alice, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("verify status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&domain); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !domain.Verified || domain.VerifiedAt == nil {
+		t.Errorf("verified domain = %+v", domain)
+	}
+	var list ListDomainsResponse
+	if err := json.NewDecoder(do("GET", "/api/domains", alice, "").Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.Domains) != 1 || !list.Domains[0].Verified {
+		t.Errorf("alice domains = %+v", list.Domains)
+	}
+
+	w = do("POST", "/api/shorten", alice, `{"url":"https://example.com/a","domain":"go.example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("shorten status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(w.Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if want := "http://go.example.com/" + shortened.ShortCode; shortened.ShortURL != want {
+		t.Errorf("short_url = %q, want %q", shortened.ShortURL, want)
+	}
+
+	// Bound links answer on their domain only
+	if w := do("GET", "http://go.example.com:8080/"+shortened.ShortCode, "", ""); w.Code != http.StatusFound {
+		t.Errorf("redirect on the domain status = %d, want 302", w.Code)
+	}
+	if w := do("GET", "http://localhost:8080/"+shortened.ShortCode, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("redirect on the base host status = %d, want 404", w.Code)
+	}
+
+	linkPath := "/api/urls/" + shortened.ShortCode
+	if w := do("PATCH", linkPath, alice, `{"domain":"other.example.com"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("move to unknown domain status = %d, want 400", w.Code)
+	}
+	w = do("PATCH", linkPath, alice, `{"domain":""}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unbind status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var updated URLResponse
+	if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if updated.Domain != "" || updated.ShortURL != "http://localhost:8080/"+shortened.ShortCode {
+		t.Errorf("unbound link = %+v", updated)
+	}
+
+	if w := do("DELETE", path, bob, ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete by non-owner status = %d, want 404", w.Code)
+	}
+	if w := do("DELETE", path, alice, ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want 204", w.Code)
+	}
+	if w := do("GET", path, alice, ""); w.Code != http.StatusNotFound {
+		t.Errorf("get after delete status = %d, want 404", w.Code)
+	}
+}
+
+func TestOrgsAPI(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signup := func(email string) AuthResponse {
+		t.Helper()
+		w := do("POST", "/api/auth/signup", "", `{"email":"`+email+`","password":"password1"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("signup status = %d, want 201: %s", w.Code, w.Body)
+		}
+		var resp AuthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+	alice, bob := signup("alice@example.com"), signup("bob@example.com")
+
+	if w := do("POST", "/api/orgs", "", `{"name":"Marketing"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous create status = %d, want 401", w.Code)
+	}
+	if w := do("POST", "/api/orgs", alice.APIKey, `{"name":" "}`); w.Code != http.StatusBadRequest {
+		t.Errorf("blank name status = %d, want 400", w.Code)
+	}
+	w := do("POST", "/api/orgs", alice.APIKey, `{"name":"Marketing"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var org shortener.Org
+	if err := json.NewDecoder(w.Body).Decode(&org); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	path := "/api/orgs/" + strconv.FormatUint(org.ID, 10)
+
+	body := fmt.Sprintf(`{"url":"https://example.com/a","org_id":%d}`, org.ID)
+	if w := do("POST", "/api/shorten", bob.APIKey, body); w.Code != http.StatusBadRequest {
+		t.Errorf("shorten into another user's organization status = %d, want 400", w.Code)
+	}
+	w = do("POST", "/api/shorten", alice.APIKey, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("shorten status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(w.Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	urlPath := "/api/urls/" + shortened.ShortCode
+	if w := do("PATCH", urlPath, bob.APIKey, `{"url":"https://example.com/b"}`); w.Code != http.StatusForbidden {
+		t.Errorf("edit by an outsider status = %d, want 403", w.Code)
+	}
+	if w := do("GET", path, bob.APIKey, ""); w.Code != http.StatusNotFound {
+		t.Errorf("get by an outsider status = %d, want 404", w.Code)
+	}
+
+	w = do("POST", path+"/invitations", alice.APIKey, `{"email":"bob@example.com"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("invite status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var inv InvitationResponse
+	if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if inv.Token == "" || inv.Invitation == nil || inv.Email != "bob@example.com" || strings.Contains(w.Body.String(), "token_hash") {
+		t.Errorf("invitation = %s", w.Body)
+	}
+	if w := do("POST", path+"/invitations", alice.APIKey, `{"email":"alice@example.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("invite a member status = %d, want 409", w.Code)
+	}
+	if w := do("POST", "/api/invitations/"+inv.Token+"/accept", alice.APIKey, ""); w.Code != http.StatusNotFound {
+		t.Errorf("accept for another email status = %d, want 404", w.Code)
+	}
+	if w := do("POST", "/api/invitations/"+inv.Token+"/accept", bob.APIKey, ""); w.Code != http.StatusOK {
+		t.Fatalf("accept status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var got OrgResponse
+	if err := json.NewDecoder(do("GET", path, bob.APIKey, "").Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.Org == nil || got.ID != org.ID || len(got.Members) != 2 || got.Members[1].Email != "bob@example.com" {
+		t.Errorf("organization = %+v", got)
+	}
+	if w := do("PATCH", urlPath, bob.APIKey, `{"url":"https://example.com/b"}`); w.Code != http.StatusOK {
+		t.Errorf("edit by a member status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var list ListURLsResponse
+	if err := json.NewDecoder(do("GET", path+"/urls", bob.APIKey, "").Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.URLs) != 1 || list.URLs[0].ShortCode != shortened.ShortCode || list.URLs[0].OrgID != org.ID {
+		t.Errorf("organization urls = %+v", list.URLs)
+	}
+
+	aliceID := strconv.FormatUint(alice.User.ID, 10)
+	bobID := strconv.FormatUint(bob.User.ID, 10)
+	if w := do("DELETE", path+"/members/"+aliceID, bob.APIKey, ""); w.Code != http.StatusNoContent {
+		t.Errorf("remove member status = %d, want 204", w.Code)
+	}
+	if w := do("DELETE", path+"/members/"+bobID, bob.APIKey, ""); w.Code != http.StatusConflict {
+		t.Errorf("remove last member status = %d, want 409", w.Code)
+	}
+	if w := do("PATCH", urlPath, alice.APIKey, `{"url":"https://example.com/c"}`); w.Code != http.StatusForbidden {
+		t.Errorf("edit by a former member status = %d, want 403", w.Code)
+	}
+}
+
+func TestAPIKeysAPI(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("signup status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var signup AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&signup); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	for _, body := range []string{`{"scopes":["write"]}`, `{"scopes":[]}`, `{"scopes":["read"],"ttl_seconds":-1}`, `{"scopes":["read"],"ttl_seconds":60,"expires_at":"2030-01-01T00:00:00Z"}`, `{"scopes":["read"],"signing":true}`} {
+		if w := do("POST", "/api/keys", signup.APIKey, body); w.Code != http.StatusBadRequest {
+			t.Errorf("create with %s status = %d, want 400", body, w.Code)
+		}
+	}
+	w = do("POST", "/api/keys", signup.APIKey, `{"scopes":["read"],"ttl_seconds":3600}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var created APIKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if created.APIKey == nil || created.Secret == "" || created.ExpiresAt == nil || len(created.Scopes) != 1 {
+		t.Fatalf("created key = %+v", created)
+	}
+	readOnly := created.Secret
+
+	// A read-only key reads but neither shortens nor creates stronger keys
+	if w := do("GET", "/api/me/urls", readOnly, ""); w.Code != http.StatusOK {
+		t.Errorf("list with a read key status = %d, want 200", w.Code)
+	}
+	if w := do("POST", "/api/shorten", readOnly, `{"url":"https://example.com"}`); w.Code != http.StatusForbidden {
+		t.Errorf("shorten with a read key status = %d, want 403", w.Code)
+	}
+	if w := do("POST", "/api/keys", readOnly, `{"scopes":["shorten"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("create a stronger key status = %d, want 403", w.Code)
+	}
+
+	var list ListAPIKeysResponse
+	if err := json.NewDecoder(do("GET", "/api/keys", readOnly, "").Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list.Keys) != 2 || list.Keys[1].ID != created.ID || list.Keys[1].LastUsedAt == nil {
+		t.Errorf("keys = %+v", list.Keys)
+	}
+	path := "/api/keys/" + strconv.FormatUint(created.ID, 10)
+	if w := do("POST", "/api/keys/"+strconv.FormatUint(list.Keys[0].ID, 10)+"/rotate", readOnly, ""); w.Code != http.StatusForbidden {
+		t.Errorf("rotate a stronger key status = %d, want 403", w.Code)
+	}
+	w = do("POST", path+"/rotate", readOnly, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("rotate status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var rotated APIKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if w := do("GET", "/api/me/urls", rotated.Secret, ""); w.Code != http.StatusOK {
+		t.Errorf("list with the rotated key status = %d, want 200", w.Code)
+	}
+	if w := do("GET", "/api/me/urls", readOnly, ""); w.Code != http.StatusOK {
+		t.Errorf("list with the old key in its grace period status = %d, want 200", w.Code)
+	}
+
+	if w := do("DELETE", path, signup.APIKey, ""); w.Code != http.StatusNoContent {
+		t.Errorf("revoke status = %d, want 204", w.Code)
+	}
+	if w := do("GET", "/api/me/urls", readOnly, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("list with a revoked key status = %d, want 401", w.Code)
+	}
+	if w := do("DELETE", path, signup.APIKey, ""); w.Code != http.StatusNotFound {
+		t.Errorf("revoke twice status = %d, want 404", w.Code)
+	}
+	if w := do("POST", "/api/auth/logout", rotated.Secret, ""); w.Code != http.StatusNoContent {
+		t.Errorf("logout with a read key status = %d, want 204", w.Code)
+	}
+}
+
+func TestSignedRequestsAPI(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	service := shortener.NewService(shortener.NewInMemoryRepository(),
+		shortener.WithRequestSigning([]byte("0123456789abcdef0123456789abcdef")))
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	verifier := auth.NewSignatureVerifier(service, auth.NewRedisNonces(client), 5*time.Minute, 1<<20)
+	authenticate := auth.WithSignatures(auth.Middleware(service), verifier)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	do := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`)
+	var signup AuthResponse
+	if err := json.NewDecoder(w.Body).Decode(&signup); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	w = do("POST", "/api/keys", "Bearer "+signup.APIKey, `{"scopes":["shorten","read"],"signing":true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var created APIKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if created.Secret != "" || created.SigningKeyID == "" || created.SigningSecret == "" {
+		t.Fatalf("created key = %+v, want only a signing credential", created)
+	}
+
+	signed := func(method, path, nonce, body string) string {
+		ts := time.Now().Unix()
+		sig := auth.Sign(created.SigningSecret, method, path, ts, nonce, []byte(body))
+		return fmt.Sprintf("HMAC-SHA256 KeyId=%s, Timestamp=%d, Nonce=%s, Signature=%s", created.SigningKeyID, ts, nonce, sig)
+	}
+	body := `{"url":"https://example.com/signed"}`
+	authorization := signed("POST", "/api/shorten", "nonce-0000000001", body)
+	if w := do("POST", "/api/shorten", authorization, body); w.Code != http.StatusOK {
+		t.Fatalf("signed shorten status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if w := do("POST", "/api/shorten", authorization, body); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed shorten status = %d, want 401", w.Code)
+	}
+	w = do("GET", "/api/me/urls", signed("GET", "/api/me/urls", "nonce-0000000002", ""), "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "https://example.com/signed") {
+		t.Errorf("signed list status = %d, want 200 with the signed link: %s", w.Code, w.Body)
+	}
+	// The scopes of the key apply to its signed requests
+	if w := do("POST", "/api/keys", signed("POST", "/api/keys", "nonce-0000000003", `{"scopes":["delete"]}`), `{"scopes":["delete"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("signed create of a stronger key status = %d, want 403", w.Code)
+	}
+
+	path := "/api/keys/" + strconv.FormatUint(created.ID, 10) + "/rotate?signing=true"
+	w = do("POST", path, signed("POST", path, "nonce-0000000004", ""), "")
+	var rotated APIKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("signed rotate status = %d, %v", w.Code, err)
+	}
+	if rotated.SigningKeyID == "" || rotated.SigningKeyID == created.SigningKeyID || rotated.Secret != "" {
+		t.Errorf("rotated key = %+v, want a new signing credential", rotated)
+	}
+}
+
+func TestShortenHandler_PlanQuota(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	plans := map[string]shortener.Plan{"free": {TotalLinks: 1}, "pro": {}}
+	service := shortener.NewService(shortener.NewInMemoryRepository(), shortener.WithPlans(plans, "free"))
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	app.registerAdminRoutes(r, token, authenticate)
+
+	do := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	if account.User.Plan != "free" {
+		t.Errorf("signup plan = %q, want free", account.User.Plan)
+	}
+
+	if w := do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com/a"}`); w.Code != http.StatusOK {
+		t.Fatalf("first shorten status = %d, want 200", w.Code)
+	}
+	w := do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com/b"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("over quota status = %d, want 403", w.Code)
+	}
+	var quota apierror.Response
+	if err := json.NewDecoder(w.Body).Decode(&quota); err != nil {
+		t.Fatalf("failed to decode quota response: %v", err)
+	}
+	if quota.Error.Code != shortener.QuotaTotalLinks || quota.Error.Limit != 1 {
+		t.Errorf("quota response = %+v, want %s of 1", quota, shortener.QuotaTotalLinks)
+	}
+	if w := do("POST", "/api/shorten", "", `{"url":"https://example.com/c"}`); w.Code != http.StatusOK {
+		t.Errorf("anonymous shorten status = %d, want 200", w.Code)
+	}
+
+	path := fmt.Sprintf("/api/admin/users/%d/plan", account.User.ID)
+	if w := do("PUT", path, token, `{"plan":"enterprise"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown plan status = %d, want 400", w.Code)
+	}
+	if w := do("PUT", "/api/admin/users/999/plan", token, `{"plan":"pro"}`); w.Code != http.StatusNotFound {
+		t.Errorf("unknown user status = %d, want 404", w.Code)
+	}
+	if w := do("PUT", path, token, `{"plan":"pro"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("set plan status = %d, want 204", w.Code)
+	}
+	if w := do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com/b"}`); w.Code != http.StatusOK {
+		t.Errorf("shorten after upgrade status = %d, want 200", w.Code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	app.registerAdminRoutes(r, token, authenticate)
+
+	do := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signup := func(email, role string) string {
+		t.Helper()
+		var account AuthResponse
+		if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"`+email+`","password":"password1"}`).Body).Decode(&account); err != nil {
+			t.Fatalf("failed to decode signup response: %v", err)
+		}
+		if account.User.Role != shortener.RoleEditor {
+			t.Errorf("signup role = %q, want editor", account.User.Role)
+		}
+		path := fmt.Sprintf("/api/admin/users/%d/role", account.User.ID)
+		if w := do("PUT", path, token, `{"role":"`+role+`"}`); w.Code != http.StatusNoContent {
+			t.Fatalf("set role status = %d, want 204: %s", w.Code, w.Body)
+		}
+		return account.APIKey
+	}
+	viewer := signup("viewer@example.com", shortener.RoleViewer)
+	editor := signup("editor@example.com", shortener.RoleEditor)
+	admin := signup("admin@example.com", shortener.RoleAdmin)
+
+	w := do("POST", "/api/shorten", editor, `{"url":"https://example.com/a"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("shorten by editor status = %d, want 200", w.Code)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(w.Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	path := "/api/urls/" + shortened.ShortCode
+
+	tests := []struct {
+		name, method, path, key, body string
+		want                          int
+	}{
+		{"viewer lists links", "GET", "/api/me/urls", viewer, "", http.StatusOK},
+		{"viewer lists campaigns", "GET", "/api/campaigns", viewer, "", http.StatusOK},
+		{"viewer shortens", "POST", "/api/shorten", viewer, `{"url":"https://example.com/b"}`, http.StatusForbidden},
+		{"viewer edits", "PATCH", path, viewer, `{"max_clicks":5}`, http.StatusForbidden},
+		{"viewer creates a campaign", "POST", "/api/campaigns", viewer, `{"name":"spring"}`, http.StatusForbidden},
+		{"editor reads stats", "GET", path + "/stats/timeseries", editor, "", http.StatusOK},
+		{"editor edits", "PATCH", path, editor, `{"max_clicks":5}`, http.StatusOK},
+		{"editor deletes", "DELETE", path, editor, "", http.StatusForbidden},
+		{"editor uses the admin API", "GET", "/api/admin/stats", editor, "", http.StatusForbidden},
+		{"admin uses the admin API", "GET", "/api/admin/stats", admin, "", http.StatusOK},
+		{"admin disables a link", "POST", "/api/admin/links/" + shortened.ShortCode + "/disable", admin, "", http.StatusNoContent},
+		{"unknown key uses the admin API", "GET", "/api/admin/stats", "sk_invalid", "", http.StatusUnauthorized},
+		{"unknown role", "PUT", "/api/admin/users/1/role", token, `{"role":"owner"}`, http.StatusBadRequest},
+		{"unknown user", "PUT", "/api/admin/users/999/role", token, `{"role":"viewer"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := do(tt.method, tt.path, tt.key, tt.body); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+	if w := do("POST", "/api/shorten", "", `{"url":"https://example.com/c"}`); w.Code != http.StatusOK {
+		t.Errorf("anonymous shorten status = %d, want 200", w.Code)
+	}
+}
+
+func TestGeoTargets(t *testing.T) {
+	table, err := geoip.ParseCSV(strings.NewReader("203.0.113.0/24,US\n198.51.100.0/24,JP\n"))
+	if err != nil {
+		t.Fatalf("ParseCSV() unexpected error = %v", err)
+	}
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080", GeoIP: table, CountryHeader: "CF-IPCountry"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com/sale"}`).Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode shorten response: %v", err)
+	}
+	path := "/api/urls/" + shortened.ShortCode + "/targets"
+
+	if w := do("PUT", path, account.APIKey, `{"targets":[{"country":"USA","url":"https://example.com/us"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid target status = %d, want 400", w.Code)
+	}
+	if w := do("PUT", path, "", `{"targets":[]}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want 401", w.Code)
+	}
+	w := do("PUT", path, account.APIKey, `{"targets":[{"country":"us","url":"https://example.com/us"},{"country":"JP","url":"https://example.com/jp"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set targets status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var resp URLResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Targets) != 2 || resp.Targets[0].Country != "US" {
+		t.Errorf("targets = %+v, want US and JP", resp.Targets)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		country    string
+		want       string
+	}{
+		{name: "geoip match", remoteAddr: "203.0.113.7:1234", want: "https://example.com/us"},
+		{name: "other geoip match", remoteAddr: "198.51.100.7:1234", want: "https://example.com/jp"},
+		{name: "unknown address", remoteAddr: "192.0.2.1:1234", want: "https://example.com/sale"},
+		{name: "header wins", remoteAddr: "203.0.113.7:1234", country: "jp", want: "https://example.com/jp"},
+		{name: "header without target", remoteAddr: "203.0.113.7:1234", country: "DE", want: "https://example.com/sale"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/"+shortened.ShortCode, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.country != "" {
+				req.Header.Set("CF-IPCountry", tt.country)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusFound {
+				t.Fatalf("redirect status = %d, want 302", w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceRules(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com/app"}`).Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode shorten response: %v", err)
+	}
+	path := "/api/urls/" + shortened.ShortCode + "/rules"
+
+	if w := do("PUT", path, account.APIKey, `{"rules":[{"device":"blackberry","url":"https://example.com/bb"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid rule status = %d, want 400", w.Code)
+	}
+	w := do("PUT", path, account.APIKey, `{"rules":[{"device":"ios","url":"https://apps.apple.com/app/id1"},{"device":"android","url":"https://play.google.com/store"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set rules status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var resp URLResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Rules) != 2 {
+		t.Errorf("rules = %+v, want 2", resp.Rules)
+	}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{name: "iOS", userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", want: "https://apps.apple.com/app/id1"},
+		{name: "Android", userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", want: "https://play.google.com/store"},
+		{name: "desktop", userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", want: "https://example.com/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/"+shortened.ShortCode, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestABExperiment(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com"}`).Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode shorten response: %v", err)
+	}
+	path := "/api/urls/" + shortened.ShortCode + "/experiment"
+
+	if w := do("PUT", path, account.APIKey, `{"variants":[{"name":"a","url":"https://example.com/a","weight":1}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("single variant status = %d, want 400", w.Code)
+	}
+	body := `{"variants":[{"name":"a","url":"https://example.com/a","weight":1},{"name":"b","url":"https://example.com/b","weight":1000}],"sticky":true}`
+	if w := do("PUT", path, account.APIKey, body); w.Code != http.StatusOK {
+		t.Fatalf("set experiment status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	// A sticky cookie keeps a visitor on the variant they were served
+	redirect := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+shortened.ShortCode, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := redirect(&http.Cookie{Name: "variant", Value: "a"})
+	if got := w.Header().Get("Location"); got != "https://example.com/a" {
+		t.Errorf("sticky Location = %q, want variant a", got)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "a" || cookies[0].Path != "/"+shortened.ShortCode {
+		t.Errorf("cookies = %+v, want variant=a scoped to the link", cookies)
+	}
+	if got := redirect(&http.Cookie{Name: "variant", Value: "gone"}).Header().Get("Location"); got != "https://example.com/a" && got != "https://example.com/b" {
+		t.Errorf("stale cookie Location = %q, want a variant", got)
+	}
+
+	w = do("GET", path, account.APIKey, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get experiment status = %d, want 200", w.Code)
+	}
+	var resp ExperimentResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Experiment == nil || len(resp.Experiment.Variants) != 2 || resp.Served["a"]+resp.Served["b"] != 2 || resp.Served["a"] < 1 {
+		t.Errorf("experiment response = %+v", resp)
+	}
+	if w := do("GET", path, "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous get experiment status = %d, want 401", w.Code)
+	}
+}
+
+func TestClickTimeSeries(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com"}`).Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode shorten response: %v", err)
+	}
+	for range 3 {
+		if w := do("GET", "/"+shortened.ShortCode, "", ""); w.Code != http.StatusFound {
+			t.Fatalf("redirect status = %d, want 302", w.Code)
+		}
+	}
+	if _, err := service.RollupClicks(context.Background()); err != nil {
+		t.Fatalf("RollupClicks() unexpected error = %v", err)
+	}
+
+	path := "/api/urls/" + shortened.ShortCode + "/stats/timeseries"
+	w := do("GET", path+"?granularity=day", account.APIKey, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("time series status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var series shortener.ClickSeries
+	if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if series.Granularity != "day" || len(series.Buckets) != 31 || sumClicks(series.Buckets) != 3 {
+		t.Errorf("time series = %+v, want 31 days with 3 clicks", series)
+	}
+
+	from := time.Now().UTC().Add(-2 * time.Hour).Format(time.RFC3339)
+	w = do("GET", path+"?from="+from, account.APIKey, "")
+	if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if series.Granularity != "hour" || len(series.Buckets) != 3 || sumClicks(series.Buckets) != 3 {
+		t.Errorf("hourly time series = %+v, want 3 hours with 3 clicks", series)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		key   string
+		want  int
+	}{
+		{"invalid granularity", "?granularity=week", account.APIKey, http.StatusBadRequest},
+		{"invalid from", "?from=yesterday", account.APIKey, http.StatusBadRequest},
+		{"range too long", "?from=2020-01-01T00:00:00Z", account.APIKey, http.StatusBadRequest},
+		{"anonymous", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := do("GET", path+tt.query, tt.key, ""); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestClickSources(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(path, key, referer, body string) *httptest.ResponseRecorder {
+		method := "GET"
+		if body != "" {
+			method = "POST"
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("/api/auth/signup", "", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(do("/api/shorten", account.APIKey, "", `{"url":"https://example.com"}`).Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode shorten response: %v", err)
+	}
+	for _, c := range []struct{ query, referer string }{
+		{"?utm_source=twitter&utm_campaign=launch", "https://t.co/xyz"},
+		{"?utm_source=Twitter", "https://t.co/abc"},
+		{"", "https://www.example.org/blog"},
+		{"", ""},
+	} {
+		if w := do("/"+shortened.ShortCode+c.query, "", c.referer, ""); w.Code != http.StatusFound {
+			t.Fatalf("redirect status = %d, want 302", w.Code)
+		}
+	}
+	if _, err := service.RollupClicks(context.Background()); err != nil {
+		t.Fatalf("RollupClicks() unexpected error = %v", err)
+	}
+
+	path := "/api/urls/" + shortened.ShortCode + "/stats/sources"
+	w := do(path, account.APIKey, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("sources status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var got struct {
+		Referrers    []shortener.SourceCount `json:"referrers"`
+		UTMSources   []shortener.SourceCount `json:"utm_sources"`
+		UTMMediums   []shortener.SourceCount `json:"utm_mediums"`
+		UTMCampaigns []shortener.SourceCount `json:"utm_campaigns"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	wantReferrers := []shortener.SourceCount{{Value: "t.co", Clicks: 2}, {Value: "(direct)", Clicks: 1}, {Value: "example.org", Clicks: 1}}
+	if !slices.Equal(got.Referrers, wantReferrers) {
+		t.Errorf("referrers = %+v, want %+v", got.Referrers, wantReferrers)
+	}
+	if want := []shortener.SourceCount{{Value: "twitter", Clicks: 2}}; !slices.Equal(got.UTMSources, want) {
+		t.Errorf("utm sources = %+v, want %+v", got.UTMSources, want)
+	}
+	if got.UTMMediums == nil || len(got.UTMMediums) != 0 || len(got.UTMCampaigns) != 1 {
+		t.Errorf("utm mediums = %+v, campaigns = %+v, want [] and launch", got.UTMMediums, got.UTMCampaigns)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		key   string
+		want  int
+	}{
+		{"limit", "?limit=1", account.APIKey, http.StatusOK},
+		{"invalid limit", "?limit=0", account.APIKey, http.StatusBadRequest},
+		{"invalid to", "?to=tomorrow", account.APIKey, http.StatusBadRequest},
+		{"empty range", "?from=2025-03-02T00:00:00Z&to=2025-03-01T00:00:00Z", account.APIKey, http.StatusBadRequest},
+		{"anonymous", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := do(path+tt.query, tt.key, "", ""); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestClickExport(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var account AuthResponse
+	if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"alice@example.com","password":"password1"}`).Body).Decode(&account); err != nil {
+		t.Fatalf("failed to decode signup response: %v", err)
+	}
+	var shortened ShortenResponse
+	if err := json.NewDecoder(do("POST", "/api/shorten", account.APIKey, `{"url":"https://example.com"}`).Body).Decode(&shortened); err != nil {
+		t.Fatalf("failed to decode shorten response: %v", err)
+	}
+	for range 2 {
+		if w := do("GET", "/"+shortened.ShortCode, "", ""); w.Code != http.StatusFound {
+			t.Fatalf("redirect status = %d, want 302", w.Code)
+		}
+	}
+	if _, err := service.RollupClicks(context.Background()); err != nil {
+		t.Fatalf("RollupClicks() unexpected error = %v", err)
+	}
+
+	path := "/api/urls/" + shortened.ShortCode + "/stats/export"
+	w := do("GET", path, account.APIKey, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=`+shortened.ShortCode+`-clicks-day.csv` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV: %v", err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != "short_code,start,clicks" || records[1][0] != shortened.ShortCode || records[1][2] != "2" {
+		t.Errorf("CSV = %q, want a header and one day with 2 clicks", records)
+	}
+	if _, err := time.Parse(time.RFC3339, records[1][1]); err != nil {
+		t.Errorf("CSV start = %q, want an RFC 3339 timestamp", records[1][1])
+	}
+
+	w = do("GET", path+"?format=json&granularity=hour", account.APIKey, "")
+	var rows []struct {
+		Code   string    `json:"short_code"`
+		Start  time.Time `json:"start"`
+		Clicks int64     `json:"clicks"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&rows); err != nil {
+		t.Fatalf("failed to decode JSON export: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Code != shortened.ShortCode || rows[0].Clicks != 2 {
+		t.Errorf("JSON export = %+v, want one hour with 2 clicks", rows)
+	}
+
+	w = do("GET", path+"?format=json&from=2020-01-01T00:00:00Z&to=2020-02-01T00:00:00Z", account.APIKey, "")
+	if body := w.Body.String(); w.Code != http.StatusOK || body != "[\n]\n" {
+		t.Errorf("empty JSON export = %d %q, want an empty array", w.Code, body)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		key   string
+		want  int
+	}{
+		{"invalid format", "?format=xlsx", account.APIKey, http.StatusBadRequest},
+		{"invalid granularity", "?granularity=week", account.APIKey, http.StatusBadRequest},
+		{"invalid from", "?from=yesterday", account.APIKey, http.StatusBadRequest},
+		{"anonymous", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := do("GET", path+tt.query, tt.key, ""); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func sumClicks(buckets []shortener.ClickBucket) int64 {
+	var n int64
+	for _, b := range buckets {
+		n += b.Clicks
+	}
+	return n
+}
+
+func TestTopStatsHandler(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	app.registerAdminRoutes(r, token, auth.Middleware(service))
+	r.HandleFunc("/{shortCode}", app.RedirectHandler).Methods("GET")
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if strings.HasPrefix(path, "/api/") {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The stats cover every user's links, so they are for admins only
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/stats/top", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous top stats status = %d, want 401", w.Code)
+	}
+
+	ctx := context.Background()
+	popular, err := service.Shorten(ctx, "https://example.com/popular")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	other, err := service.Shorten(ctx, "https://example.com/other")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	for _, code := range []string{popular, popular, other} {
+		if w := do("/" + code); w.Code != http.StatusFound {
+			t.Fatalf("redirect status = %d, want 302", w.Code)
+		}
+	}
+	if _, err := service.RollupClicks(ctx); err != nil {
+		t.Fatalf("RollupClicks() unexpected error = %v", err)
+	}
+
+	w = do("/api/admin/stats/top?period=7d&limit=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("top stats status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if v, ok := body["unique_visitors"]; !ok || v != nil {
+		t.Errorf("unique_visitors = %v, want null without a visitor counter", v)
+	}
+	var stats shortener.TopStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if stats.Redirects != 3 || stats.LinksCreated != 2 || len(stats.Top) != 1 || stats.Top[0] != (shortener.TopLink{Code: popular, Clicks: 2}) {
+		t.Errorf("top stats = %+v, want 3 redirects, 2 created and %s first", stats, popular)
+	}
+	if got := stats.To.Sub(stats.From); got != 7*24*time.Hour {
+		t.Errorf("top stats range = %v, want 7 days", got)
+	}
+
+	if w := do("/api/admin/stats/top?period=24h"); w.Code != http.StatusOK {
+		t.Errorf("hourly period status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	// Unchanged stats are revalidated with If-None-Match
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q, want a weak ETag", etag)
+	}
+	revalidate := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/admin/stats/top?period=7d&limit=1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := revalidate(`"other", ` + etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("revalidation status = %d, ETag = %q, want 304 with %s", w.Code, w.Header().Get("ETag"), etag)
+	}
+	if w := do("/" + other); w.Code != http.StatusFound {
+		t.Fatalf("redirect status = %d, want 302", w.Code)
+	}
+	if _, err := service.RollupClicks(ctx); err != nil {
+		t.Fatalf("RollupClicks() unexpected error = %v", err)
+	}
+	if w := revalidate(etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("revalidation after a click status = %d, ETag = %q, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+
+	for _, query := range []string{"?period=week", "?period=0d", "?period=90m", "?period=365d", "?limit=0", "?limit=101", "?limit=ten"} {
+		if w := do("/api/admin/stats/top" + query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestAPIDocs(t *testing.T) {
+	r := mux.NewRouter()
+	registerDocsRoutes(r)
+
+	do := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		maps.Copy(req.Header, header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/api/docs/openapi.yaml", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "openapi: 3") {
+		t.Fatalf("spec status = %d, want 200 with an OpenAPI 3 document", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/yaml" {
+		t.Errorf("spec Content-Type = %q, want application/yaml", got)
+	}
+	etag := w.Header().Get("ETag")
+	if w := do("/api/docs/openapi.yaml", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("spec revalidation status = %d, want 304", w.Code)
+	}
+
+	if w := do("/api/docs/index.html", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "openapi.yaml") {
+		t.Errorf("Swagger UI status = %d, want 200 with a UI loading /api/docs/openapi.yaml", w.Code)
+	}
+	for path, want := range map[string]string{"/api/docs": "/api/docs/", "/docs/": "/api/docs/", "/docs/index.html": "/api/docs/"} {
+		if w := do(path, nil); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != want {
+			t.Errorf("%s: status = %d, Location = %q, want 301 to %s", path, w.Code, w.Header().Get("Location"), want)
+		}
+	}
+	if w := do("/docs/swagger.yaml", nil); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "openapi: 3") {
+		t.Errorf("legacy spec path status = %d, want 200 with the document", w.Code)
+	}
+}
+
+// TestAPIDocs_CoversRoutes fails when an account or admin route is missing
+// from the OpenAPI document, so that the spec keeps up with the handlers.
+func TestAPIDocs_CoversRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(docs.OpenAPI, &spec); err != nil {
+		t.Fatalf("failed to parse OpenAPI document: %v", err)
+	}
+
+	app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository())}
+	r := mux.NewRouter()
+	app.registerAccountRoutes(r, func(next http.Handler) http.Handler { return next })
+	app.registerAdminRoutes(r, "0123456789abcdef", auth.Middleware(app.Service))
+
+	// Path variables are documented without their patterns
+	pattern := regexp.MustCompile(`\{(\w+):[^}]*\}`)
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := pattern.ReplaceAllString(tpl, "{$1}")
+		for _, method := range methods {
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is not documented in docs/swagger.yaml", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() unexpected error = %v", err)
+	}
+}
+
+func TestAdminExportImport(t *testing.T) {
+	const token = "0123456789abcdef"
+	ctx := context.Background()
+	newApp := func() (*App, *mux.Router) {
+		app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository()), BaseURL: "http://localhost:8080"}
+		r := mux.NewRouter()
+		app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+		return app, r
+	}
+	do := func(r *mux.Router, method, path string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	src, srcRouter := newApp()
+	for _, alias := range []string{"", "promo"} {
+		if _, err := src.Service.ShortenWithOptions(ctx, "https://example.com/"+alias, shortener.ShortenOptions{CustomAlias: alias}); err != nil {
+			t.Fatalf("ShortenWithOptions() unexpected error = %v", err)
+		}
+	}
+
+	for _, format := range []string{"ndjson", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			w := do(srcRouter, "GET", "/api/admin/export?format="+format, nil)
+			if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "links."+format) {
+				t.Fatalf("export status = %d, headers = %v", w.Code, w.Header())
+			}
+
+			dst, dstRouter := newApp()
+			imp := do(dstRouter, "POST", "/api/admin/import?format="+format, w.Body)
+			if imp.Code != http.StatusOK || !strings.Contains(imp.Body.String(), `"imported":2`) {
+				t.Fatalf("import = %d %q, want 2 imported", imp.Code, imp.Body.String())
+			}
+			if link, err := dst.Service.Resolve(ctx, "promo"); err != nil || link.OriginalURL != "https://example.com/promo" {
+				t.Errorf("Resolve(promo) after import = %+v, %v", link, err)
+			}
+		})
+	}
+
+	_, r := newApp()
+	if w := do(r, "GET", "/api/admin/export?format=xml", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("export format=xml status = %d, want 400", w.Code)
+	}
+	if w := do(r, "POST", "/api/admin/import", strings.NewReader(`{"id":1}`)); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "original_url") {
+		t.Errorf("import of an invalid link = %d %q, want 400", w.Code, w.Body.String())
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	app.registerAccountRoutes(r, auth.Middleware(service))
+	do := func(method, path, key, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	signup := func(email string) string {
+		var account AuthResponse
+		if err := json.NewDecoder(do("POST", "/api/auth/signup", "", "", `{"email":"`+email+`","password":"password1"}`).Body).Decode(&account); err != nil {
+			t.Fatalf("failed to decode signup response: %v", err)
+		}
+		return account.APIKey
+	}
+	alice, bob := signup("alice@example.com"), signup("bob@example.com")
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantLines   []ImportLineResponse
+		wantState   string
+	}{
+		{
+			name:        "csv by content type",
+			path:        "/api/import",
+			contentType: "text/csv; charset=utf-8",
+			body:        "url,custom_alias\nhttps://example.com/a,spring\nnot a url,\nhttps://example.com/b,spring\n",
+			wantLines: []ImportLineResponse{
+				{Line: 2, ShortCode: "spring", ShortURL: "http://localhost:8080/spring"},
+				{Line: 3, Error: &apierror.Error{Code: apierror.InvalidURL}},
+				{Line: 4, Error: &apierror.Error{Code: apierror.AliasTaken}},
+			},
+			wantState: shortener.ImportDone,
+		},
+		{
+			name: "ndjson",
+			path: "/api/import?format=ndjson",
+			body: `{"url":"https://example.com/c","expires_at":"2000-01-01T00:00:00Z"}` + "\n" + `{"url":"https://example.com/d","custom_alias":"summer"}`,
+			wantLines: []ImportLineResponse{
+				{Line: 1, Error: &apierror.Error{Code: apierror.InvalidExpiry}},
+				{Line: 2, ShortCode: "summer", ShortURL: "http://localhost:8080/summer"},
+			},
+			wantState: shortener.ImportDone,
+		},
+		{
+			name:      "csv without url column",
+			path:      "/api/import?format=csv",
+			body:      "link\nhttps://example.com/e\n",
+			wantState: shortener.ImportFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("POST", tt.path, alice, tt.contentType, tt.body)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("import = %d %q, want an NDJSON stream", w.Code, w.Body)
+			}
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			if len(lines) != len(tt.wantLines)+1 {
+				t.Fatalf("import response =\n%s\nwant %d lines and a summary", w.Body, len(tt.wantLines))
+			}
+			for i, want := range tt.wantLines {
+				var got ImportLineResponse
+				if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+					t.Fatalf("failed to decode line %d: %v", i, err)
+				}
+				if got.Line != want.Line || got.ShortCode != want.ShortCode || got.ShortURL != want.ShortURL ||
+					(got.Error == nil) != (want.Error == nil) || got.Error != nil && got.Error.Code != want.Error.Code {
+					t.Errorf("line %d = %s, want %+v", i, lines[i], want)
+				}
+			}
+			var summary ImportSummaryResponse
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &summary); err != nil || summary.Import.State != tt.wantState {
+				t.Errorf("summary = %s, %v, want state %s", lines[len(lines)-1], err, tt.wantState)
+			}
+
+			// The Location header reports the same progress to its owner only
+			location := w.Header().Get("Location")
+			if location != "/api/import/"+summary.Import.ID {
+				t.Errorf("Location = %q, want the status of import %s", location, summary.Import.ID)
+			}
+			var status shortener.ImportStatus
+			sw := do("GET", location, alice, "", "")
+			if err := json.NewDecoder(sw.Body).Decode(&status); sw.Code != http.StatusOK || err != nil || status.State != tt.wantState || status.Lines != len(tt.wantLines) {
+				t.Errorf("GET %s = %d %+v, %v, want the summary", location, sw.Code, status, err)
+			}
+			if sw := do("GET", location, bob, "", ""); sw.Code != http.StatusNotFound {
+				t.Errorf("GET %s by another user = %d, want 404", location, sw.Code)
+			}
+		})
+	}
+
+	if w := do("POST", "/api/import?format=xml", alice, "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("import format=xml status = %d, want 400", w.Code)
+	}
+	if w := do("POST", "/api/import", "", "", `{"url":"https://example.com/"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous import status = %d, want 401", w.Code)
+	}
+}
+
+func TestAdminJobsHandler(t *testing.T) {
+	const token = "0123456789abcdef"
+	app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository())}
+	r := mux.NewRouter()
+	app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+	get := func() ListJobsResponse {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/admin/jobs", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp ListJobsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("GET /api/admin/jobs = %d, %v", w.Code, err)
+		}
+		return resp
+	}
+
+	if resp := get(); resp.Jobs == nil || len(resp.Jobs) != 0 {
+		t.Errorf("jobs without a scheduler = %+v, want an empty list", resp.Jobs)
+	}
+
+	app.Jobs = scheduler.New()
+	done := make(chan struct{})
+	app.Jobs.Add("reaper", time.Hour, func(ctx context.Context) (int, error) {
+		defer close(done)
+		return 2, nil
+	}, scheduler.Immediately())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.Jobs.Run(ctx)
+	<-done
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		jobs := get().Jobs
+		if len(jobs) == 1 && jobs[0].Runs == 1 {
+			if jobs[0].Name != "reaper" || jobs[0].LastCount != 2 || jobs[0].LastSuccess == nil || jobs[0].NextRun == nil {
+				t.Errorf("job = %+v, want a successful reaper run", jobs[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs = %+v, want one reaper run", jobs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdminDeadJobsHandler(t *testing.T) {
+	const token = "0123456789abcdef"
+	fetcher := pageFetcher{stubMetadataFetcher{md: &metadata.Metadata{Title: "Example Domain"}}}
+	app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository(), shortener.WithPageFetcher(fetcher, 1))}
+	r := mux.NewRouter()
+	app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+	do := func(target string, resp any) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+				t.Fatalf("GET %s decode error = %v", target, err)
+			}
+		}
+		return w.Code
+	}
+
+	// A job whose payload cannot be decoded fails for good
+	ctx := context.Background()
+	if _, err := app.Service.Shorten(ctx, "https://example.com"); err != nil {
+		t.Fatalf("Shorten() error = %v", err)
+	}
+	if err := app.Service.Jobs().Enqueue(ctx, shortener.JobFetchPage, "not an object"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if n, err := app.Service.Jobs().Drain(ctx); err != nil || n != 2 {
+		t.Fatalf("Drain() = %d, %v, want 2 jobs run", n, err)
+	}
+
+	var list ListJobsResponse
+	if code := do("/api/admin/jobs", &list); code != http.StatusOK || len(list.Queues) != 1 {
+		t.Fatalf("GET /api/admin/jobs = %d, %+v, want the page fetch queue", code, list)
+	}
+	if q := list.Queues[0]; q.Kind != shortener.JobFetchPage || q.Workers != 1 || q.Queued != 0 || q.Dead != 1 || q.Succeeded != 1 {
+		t.Errorf("queue = %+v, want a job succeeded and one dead", q)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantJobs int
+	}{
+		{"dead jobs", "/api/admin/jobs/fetch_page/dead", http.StatusOK, 1},
+		{"other kind", "/api/admin/jobs/check_urls/dead?limit=5", http.StatusOK, 0},
+		{"invalid limit", "/api/admin/jobs/fetch_page/dead?limit=0", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp ListDeadJobsResponse
+			if code := do(tt.target, &resp); code != tt.wantCode || len(resp.Jobs) != tt.wantJobs {
+				t.Fatalf("GET %s = %d, %+v, want %d with %d jobs", tt.target, code, resp, tt.wantCode, tt.wantJobs)
+			}
+			if tt.wantJobs > 0 && (resp.Jobs[0].Attempts != 1 || resp.Jobs[0].LastError == "" || resp.Jobs[0].FailedAt == nil) {
+				t.Errorf("dead job = %+v, want its failure", resp.Jobs[0])
+			}
+		})
+	}
+}
+
+func TestAnonymizedClientIP(t *testing.T) {
+	app := &App{
+		Service:    shortener.NewService(shortener.NewInMemoryRepository()),
+		Anonymizer: ipanon.New(ipanon.Truncate, ""),
+	}
+	ctx := context.Background()
+	code, err := app.Service.Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/urls/{shortCode}/report", app.ReportHandler)
+	req := httptest.NewRequest("POST", "/api/urls/"+code+"/report", strings.NewReader(`{"reason":"spam"}`))
+	req.RemoteAddr = "203.0.113.77:40000"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code >= 300 {
+		t.Fatalf("report status = %d: %s", w.Code, w.Body.String())
+	}
+
+	reports, _, err := app.Service.ListReports(ctx, 0, 10)
+	if err != nil || len(reports) != 1 || reports[0].Reporter != "203.0.113.0" {
+		t.Errorf("reports = %+v, %v, want the reporter truncated to its /24", reports, err)
+	}
+}
+
+func TestAdminDeleteClicksHandler(t *testing.T) {
+	const token = "0123456789abcdef"
+	app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository())}
+	r := mux.NewRouter()
+	app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+	ctx := context.Background()
+	code, err := app.Service.Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("Shorten() unexpected error = %v", err)
+	}
+	if _, err := app.Service.Redirect(ctx, code); err != nil {
+		t.Fatalf("Redirect() unexpected error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{code, http.StatusOK, `{"deleted":3}`},
+		{code, http.StatusOK, `{"deleted":0}`},
+		{"missing", http.StatusNotFound, ""},
+	} {
+		req := httptest.NewRequest("DELETE", "/api/admin/links/"+tt.code+"/clicks", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("DELETE clicks of %q = %d %q, want %d %q", tt.code, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestAdminDomainRules(t *testing.T) {
+	const token = "0123456789abcdef"
+	lists, err := shortener.NewDomainLists([]string{"spam.example"}, nil)
+	if err != nil {
+		t.Fatalf("NewDomainLists() unexpected error = %v", err)
+	}
+	app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository(), shortener.WithDomainLists(lists)), BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/shorten", app.ShortenHandler).Methods("POST")
+	app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+
+	for _, tt := range []struct {
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"POST", "/api/shorten", `{"url":"https://spam.example/"}`, http.StatusBadRequest, `"code":"domain_blocked"`},
+		{"POST", "/api/admin/domain-rules", `{"pattern":"*.Farm.example","action":"block","reason":"link farm"}`, http.StatusCreated, `"pattern":"*.farm.example"`},
+		{"POST", "/api/admin/domain-rules", `{"pattern":"*.farm.example","action":"allow"}`, http.StatusConflict, `"code":"domain_rule_exists"`},
+		{"POST", "/api/admin/domain-rules", `{"pattern":"farm","action":"block"}`, http.StatusBadRequest, `"code":"invalid_domain_rule"`},
+		{"POST", "/api/shorten", `{"url":"https://www.farm.example/"}`, http.StatusBadRequest, `"code":"domain_blocked"`},
+		{"GET", "/api/admin/domain-rules", "", http.StatusOK, `{"rules":[{"pattern":"spam.example","action":"block","configured":true},{"id":1,"pattern":"*.farm.example","action":"block","reason":"link farm"`},
+		{"DELETE", "/api/admin/domain-rules/1", "", http.StatusNoContent, ""},
+		{"DELETE", "/api/admin/domain-rules/1", "", http.StatusNotFound, `"code":"not_found"`},
+		{"POST", "/api/shorten", `{"url":"https://www.farm.example/"}`, http.StatusOK, `"short_code"`},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestURLHistory(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	ctx := context.Background()
+	_, alice, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	_, bob, err := service.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		method   string
+		path     string
+		key      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"POST", "/api/shorten", alice, `{"url":"https://example.com/v1","custom_alias":"launch"}`, http.StatusOK, `"short_code":"launch"`},
+		{"GET", "/api/urls/launch/history", alice, "", http.StatusOK, `{"changes":[]}`},
+		{"PATCH", "/api/urls/launch", alice, `{"url":"https://example.com/v2"}`, http.StatusOK, `"original_url":"https://example.com/v2"`},
+		{"GET", "/api/urls/launch/history", alice, "", http.StatusOK, `{"changes":[{"id":1,"actor":"user:1","old_url":"https://example.com/v1","new_url":"https://example.com/v2"`},
+		{"GET", "/api/urls/launch/history", bob, "", http.StatusForbidden, `"code":"forbidden"`},
+		{"GET", "/api/urls/launch/history?limit=0", alice, "", http.StatusBadRequest, `"code":"invalid_limit"`},
+		{"POST", "/api/urls/launch/history/2/rollback", alice, "", http.StatusNotFound, `"code":"not_found"`},
+		{"POST", "/api/urls/launch/history/1/rollback", bob, "", http.StatusForbidden, `"code":"forbidden"`},
+		{"POST", "/api/urls/launch/history/1/rollback", alice, "", http.StatusOK, `"original_url":"https://example.com/v1"`},
+		{"GET", "/api/urls/launch/history?limit=1", alice, "", http.StatusOK, `"old_url":"https://example.com/v2","new_url":"https://example.com/v1"`},
+		{"GET", "/api/urls/missing/history", alice, "", http.StatusNotFound, `"code":"not_found"`},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	ctx := context.Background()
+	_, alice, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	_, bob, err := service.Signup(ctx, "bob@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		method   string
+		path     string
+		key      string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"POST", "/api/shorten", alice, `{"url":"https://example.com/sale","custom_alias":"sale"}`, http.StatusOK, `"short_code":"sale"`},
+		{"PUT", "/api/urls/sale/metadata", alice, `{"title":"Spring sale","attributes":{"team":"growth"}}`, http.StatusOK, `"metadata":{"title":"Spring sale","attributes":{"team":"growth"}}`},
+		{"GET", "/api/me/urls", alice, "", http.StatusOK, `"metadata":{"title":"Spring sale","attributes":{"team":"growth"}}`},
+		{"PUT", "/api/urls/sale/metadata", alice, `{"attributes":"growth"}`, http.StatusBadRequest, `"code":"invalid_metadata"`},
+		{"PUT", "/api/urls/sale/metadata", bob, `{"title":"Mine"}`, http.StatusForbidden, `"code":"forbidden"`},
+		{"PUT", "/api/urls/missing/metadata", alice, `{"title":"Missing"}`, http.StatusNotFound, `"code":"not_found"`},
+		{"PUT", "/api/urls/sale/metadata", alice, `{}`, http.StatusOK, `"short_code":"sale"`},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+		if tt.body == `{}` && strings.Contains(w.Body.String(), `"metadata"`) {
+			t.Errorf("%s %s with empty metadata = %q, want the metadata removed", tt.method, tt.path, w.Body.String())
+		}
+	}
+}
+
+func TestAccess(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	app.registerAccountRoutes(r, authenticate)
+
+	ctx := context.Background()
+	_, alice, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"POST", "/api/shorten", `{"url":"https://example.com/offer","custom_alias":"offer"}`, http.StatusOK, `"short_code":"offer"`},
+		{"PUT", "/api/urls/offer/access", `{"referrers":["News.Example.com"]}`, http.StatusOK, `"access":{"referrers":["news.example.com"]}`},
+		{"PUT", "/api/urls/offer/access", `{"referrers":["https://news.example.com/"]}`, http.StatusBadRequest, `"code":"invalid_access"`},
+		{"PUT", "/api/urls/missing/access", `{"referrers":["news.example.com"]}`, http.StatusNotFound, `"code":"not_found"`},
+		{"POST", "/api/shorten", `{"url":"https://example.com/wiki","custom_alias":"wiki"}`, http.StatusOK, `"short_code":"wiki"`},
+		{"PUT", "/api/urls/wiki/access", `{"networks":["10.0.0.0/8"],"denied_networks":["10.66.0.1"]}`, http.StatusOK, `"access":{"networks":["10.0.0.0/8"],"denied_networks":["10.66.0.1/32"]}`},
+		{"PUT", "/api/urls/wiki/access", `{"networks":["intranet"]}`, http.StatusBadRequest, `"code":"invalid_access"`},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+alice)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+
+	// Visitors from elsewhere learn nothing of the destination
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		code       string
+		method     string
+		remoteAddr string
+		referer    string
+		header     map[string]string
+		wantStatus int
+	}{
+		{name: "redirect from the newsletter", handler: app.RedirectHandler, referer: "https://mail.news.example.com/issue/42", wantStatus: http.StatusFound},
+		{name: "redirect from elsewhere", handler: app.RedirectHandler, referer: "https://forum.example.org/", wantStatus: http.StatusForbidden},
+		{name: "redirect without referrer", handler: app.RedirectHandler, wantStatus: http.StatusForbidden},
+		{name: "head without referrer", handler: app.RedirectHandler, method: "HEAD", wantStatus: http.StatusForbidden},
+		{name: "json from elsewhere", handler: app.RedirectHandler, referer: "https://forum.example.org/", header: map[string]string{"Accept": "application/json"}, wantStatus: http.StatusForbidden},
+		{name: "json from the newsletter", handler: app.RedirectHandler, referer: "https://news.example.com/", header: map[string]string{"Accept": "application/json"}, wantStatus: http.StatusOK},
+		{name: "preview from elsewhere", handler: app.PreviewHandler, referer: "https://forum.example.org/", wantStatus: http.StatusForbidden},
+		{name: "preview from the newsletter", handler: app.PreviewHandler, referer: "https://news.example.com/", wantStatus: http.StatusOK},
+		{name: "redirect from the office", handler: app.RedirectHandler, code: "wiki", remoteAddr: "10.1.2.3:4000", wantStatus: http.StatusFound},
+		{name: "redirect from a denied address", handler: app.RedirectHandler, code: "wiki", remoteAddr: "10.66.0.1:4000", wantStatus: http.StatusForbidden},
+		{name: "redirect from outside", handler: app.RedirectHandler, code: "wiki", remoteAddr: "192.0.2.1:4000", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			code := tt.code
+			if code == "" {
+				code = "offer"
+			}
+			req := httptest.NewRequest(method, "/"+code, nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			req = mux.SetURLVars(req, map[string]string{"shortCode": code})
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusForbidden && strings.Contains(w.Body.String(), "example.com/"+code) {
+				t.Errorf("body = %s, want the destination withheld", w.Body)
+			}
+		})
+	}
+}
+
+func TestRegionPage(t *testing.T) {
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	ctx := context.Background()
+	alice, _, err := service.Signup(ctx, "alice@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Signup() unexpected error = %v", err)
+	}
+	code, err := service.ShortenWithOptions(ctx, "https://example.com/stream", shortener.ShortenOptions{Owner: alice})
+	if err != nil {
+		t.Fatalf("ShortenWithOptions() error = %v", err)
+	}
+	if _, err := service.SetAccess(ctx, alice, code, &shortener.Access{Countries: []string{"DE"}}); err != nil {
+		t.Fatalf("SetAccess() error = %v", err)
+	}
+	pages, err := LoadErrorPages("", "http://localhost:8080")
+	if err != nil {
+		t.Fatalf("LoadErrorPages() error = %v", err)
+	}
+	app := &App{Service: service, BaseURL: "http://localhost:8080", ErrorPages: pages, CountryHeader: "CF-IPCountry"}
+
+	tests := []struct {
+		name         string
+		country      string
+		accept       string
+		language     string
+		wantStatus   int
+		wantLanguage string
+		wantContain  string
+	}{
+		{name: "allowed country", country: "DE", accept: "text/html", wantStatus: http.StatusFound},
+		{name: "browser", country: "US", accept: "text/html", wantStatus: http.StatusUnavailableForLegalReasons, wantLanguage: "en", wantContain: "Not available in your region"},
+		{name: "preferred language", country: "US", accept: "text/html", language: "ja, fr-CH;q=0.9, de;q=0.8", wantStatus: http.StatusUnavailableForLegalReasons, wantLanguage: "fr", wantContain: `<html lang="fr">`},
+		{name: "refused language", country: "US", accept: "text/html", language: "de;q=0, es;q=0.5", wantStatus: http.StatusUnavailableForLegalReasons, wantLanguage: "es", wantContain: "No disponible en tu región"},
+		{name: "api client", country: "US", language: "de", wantStatus: http.StatusUnavailableForLegalReasons, wantContain: `"code":"region_unavailable"`},
+		{name: "unknown country", accept: "text/html", wantStatus: http.StatusUnavailableForLegalReasons, wantLanguage: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/"+code, nil)
+			req = mux.SetURLVars(req, map[string]string{"shortCode": code})
+			for name, v := range map[string]string{"CF-IPCountry": tt.country, "Accept": tt.accept, "Accept-Language": tt.language} {
+				if v != "" {
+					req.Header.Set(name, v)
+				}
+			}
+			w := httptest.NewRecorder()
+			app.RedirectHandler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantLanguage)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantContain) {
+				t.Errorf("body = %s, want it to contain %q", w.Body, tt.wantContain)
+			}
+			if w.Code != http.StatusFound && strings.Contains(w.Body.String(), "example.com/stream") {
+				t.Errorf("body = %s, want the destination withheld", w.Body)
+			}
+		})
+	}
+}
+
+func TestAdminAliasReservations(t *testing.T) {
+	const token = "0123456789abcdef"
+	app := &App{Service: shortener.NewService(shortener.NewInMemoryRepository()), BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/shorten", app.ShortenHandler).Methods("POST")
+	app.registerAdminRoutes(r, token, auth.Middleware(app.Service))
+
+	for _, tt := range []struct {
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"POST", "/api/shorten", `{"url":"https://example.com/","custom_alias":"health"}`, http.StatusUnprocessableEntity, `"code":"alias_reserved"`},
+		{"POST", "/api/admin/alias-reservations", `{"pattern":"Login"}`, http.StatusCreated, `"pattern":"login"`},
+		{"POST", "/api/admin/alias-reservations", `{"pattern":"login"}`, http.StatusConflict, `"code":"reservation_exists"`},
+		{"POST", "/api/admin/alias-reservations", `{"pattern":"ab"}`, http.StatusBadRequest, `"code":"invalid_reservation"`},
+		{"POST", "/api/admin/alias-reservations", `{"pattern":"acme","org_id":7}`, http.StatusBadRequest, `"code":"invalid_reservation"`},
+		{"POST", "/api/shorten", `{"url":"https://example.com/","custom_alias":"LOGIN"}`, http.StatusUnprocessableEntity, `"code":"alias_reserved"`},
+		{"GET", "/api/admin/alias-reservations", "", http.StatusOK, `{"reservations":[{"id":1,"pattern":"login","prefix":false,"created_at"`},
+		{"DELETE", "/api/admin/alias-reservations/1", "", http.StatusNoContent, ""},
+		{"DELETE", "/api/admin/alias-reservations/1", "", http.StatusNotFound, `"code":"not_found"`},
+		{"POST", "/api/shorten", `{"url":"https://example.com/","custom_alias":"login"}`, http.StatusOK, `"short_code":"login"`},
+		{"POST", "/api/shorten", `{"url":"https://example.com/","custom_alias":"login"}`, http.StatusConflict, `"code":"alias_taken"`},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestGraphQLAPI(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	service := shortener.NewService(shortener.NewInMemoryRepository())
+	app := &App{Service: service, BaseURL: "http://localhost:8080"}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	app.registerAccountRoutes(r, authenticate)
+	app.registerAdminRoutes(r, token, authenticate)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	type graphQLResponse struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	graphQL := func(key, query string, variables map[string]any) graphQLResponse {
+		t.Helper()
+		body, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+		if err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+		w := do("POST", "/graphql", key, string(body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("graphql status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var resp graphQLResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+	signup := func(email, role string) string {
+		t.Helper()
+		var account AuthResponse
+		if err := json.NewDecoder(do("POST", "/api/auth/signup", "", `{"email":"`+email+`","password":"password1"}`).Body).Decode(&account); err != nil {
+			t.Fatalf("failed to decode signup response: %v", err)
+		}
+		path := fmt.Sprintf("/api/admin/users/%d/role", account.User.ID)
+		if w := do("PUT", path, token, `{"role":"`+role+`"}`); w.Code != http.StatusNoContent {
+			t.Fatalf("set role status = %d, want 204: %s", w.Code, w.Body)
+		}
+		return account.APIKey
+	}
+	viewer := signup("viewer@example.com", shortener.RoleViewer)
+	editor := signup("editor@example.com", shortener.RoleEditor)
+	admin := signup("admin@example.com", shortener.RoleAdmin)
+
+	w := do("POST", "/api/campaigns", editor, `{"name":"Launch"}`)
+	var campaign shortener.Campaign
+	if err := json.NewDecoder(w.Body).Decode(&campaign); err != nil {
+		t.Fatalf("failed to decode campaign: %v", err)
+	}
+
+	created := graphQL(editor, `mutation ($input: ShortenInput!) { createLink(input: $input) { short_code original_url campaign_id } }`,
+		map[string]any{"input": map[string]any{"url": "https://example.com/a", "custom_alias": "launch", "campaign_id": campaign.ID}})
+	if len(created.Errors) > 0 {
+		t.Fatalf("createLink errors = %+v", created.Errors)
+	}
+	if want := fmt.Sprintf(`{"createLink":{"short_code":"launch","original_url":"https://example.com/a","campaign_id":%d}}`, campaign.ID); string(created.Data) != want {
+		t.Errorf("createLink data = %s, want %s", created.Data, want)
+	}
+	if w := do("POST", "/graphql", "", `{"query":"{ links { urls { short_code } } }"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want 401", w.Code)
+	}
+	if w := do("POST", "/graphql", editor, `{"query":""}`); w.Code != http.StatusBadRequest || errorCodeOf(t, w) != apierror.InvalidRequest {
+		t.Errorf("empty query status = %d, want 400 invalid_request: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		query    string
+		wantData string
+		wantCode string
+	}{
+		{
+			name:     "links",
+			key:      editor,
+			query:    `{ links(limit: 10) { urls { short_code clicks status analytics_disabled } next_cursor } }`,
+			wantData: `{"links":{"urls":[{"short_code":"launch","clicks":0,"status":"active","analytics_disabled":false}],"next_cursor":null}}`,
+		},
+		{
+			name:     "link with stats",
+			key:      editor,
+			query:    `{ link(short_code: "launch") { original_url series(granularity: "day", from: "2024-01-01T00:00:00Z", to: "2024-01-02T00:00:00Z") { granularity buckets { clicks } } sources { referrers { value } } } }`,
+			wantData: `{"link":{"original_url":"https://example.com/a","series":{"granularity":"day","buckets":[{"clicks":0}]},"sources":{"referrers":[]}}}`,
+		},
+		{
+			name:     "campaigns",
+			key:      viewer,
+			query:    `{ campaigns { name } }`,
+			wantData: `{"campaigns":[]}`,
+		},
+		{
+			name:     "campaign with links and stats",
+			key:      editor,
+			query:    fmt.Sprintf(`{ campaign(id: %d) { name links { urls { short_code } } stats(period: "7d") { links clicks top { short_code } } } }`, campaign.ID),
+			wantData: `{"campaign":{"name":"Launch","links":{"urls":[{"short_code":"launch"}]},"stats":{"links":1,"clicks":0,"top":[]}}}`,
+		},
+		{
+			name:     "campaign of another user",
+			key:      viewer,
+			query:    fmt.Sprintf(`{ campaign(id: %d) { name } }`, campaign.ID),
+			wantData: `{"campaign":null}`,
+			wantCode: apierror.NotFound,
+		},
+		{
+			name:     "link of another user",
+			key:      viewer,
+			query:    `{ link(short_code: "launch") { short_code } }`,
+			wantData: `{"link":null}`,
+			wantCode: apierror.Forbidden,
+		},
+		{
+			name:     "invalid limit",
+			key:      editor,
+			query:    `{ links(limit: 0) { next_cursor } }`,
+			wantData: `{"links":null}`,
+			wantCode: apierror.InvalidLimit,
+		},
+		{
+			name:     "invalid period",
+			key:      editor,
+			query:    fmt.Sprintf(`{ campaign(id: %d) { stats(period: "soon") { clicks } } }`, campaign.ID),
+			wantData: `{"campaign":{"stats":null}}`,
+			wantCode: apierror.InvalidPeriod,
+		},
+		{
+			name:     "viewer creates",
+			key:      viewer,
+			query:    `mutation { createLink(input: {url: "https://example.com/b"}) { short_code } }`,
+			wantData: `{"createLink":null}`,
+			wantCode: apierror.Forbidden,
+		},
+		{
+			name:     "invalid url",
+			key:      editor,
+			query:    `mutation { createLink(input: {url: "ftp://example.com"}) { short_code } }`,
+			wantData: `{"createLink":null}`,
+			wantCode: apierror.InvalidURL,
+		},
+		{
+			name:     "alias taken",
+			key:      editor,
+			query:    `mutation { createLink(input: {url: "https://example.com/b", custom_alias: "launch"}) { short_code } }`,
+			wantData: `{"createLink":null}`,
+			wantCode: apierror.AliasTaken,
+		},
+		{
+			name:     "unknown input field",
+			key:      editor,
+			query:    `mutation { createLink(input: {link: "https://example.com/b"}) { short_code } }`,
+			wantData: `{"createLink":null}`,
+			wantCode: apierror.InvalidRequest,
+		},
+		{
+			name:     "update",
+			key:      editor,
+			query:    `mutation { updateLink(short_code: "launch", input: {max_clicks: 5}) { max_clicks } }`,
+			wantData: `{"updateLink":{"max_clicks":5}}`,
+		},
+		{
+			name:     "nothing to update",
+			key:      editor,
+			query:    `mutation { updateLink(short_code: "launch", input: {}) { max_clicks } }`,
+			wantData: `{"updateLink":null}`,
+			wantCode: apierror.NothingToUpdate,
+		},
+		{
+			name:     "editor deletes",
+			key:      editor,
+			query:    `mutation { deleteLink(short_code: "launch") }`,
+			wantData: `{"deleteLink":null}`,
+			wantCode: apierror.Forbidden,
+		},
+		{
+			name:     "admin deletes a link of another user",
+			key:      admin,
+			query:    `mutation { deleteLink(short_code: "launch") }`,
+			wantData: `{"deleteLink":null}`,
+			wantCode: apierror.Forbidden,
+		},
+		{
+			name:     "syntax error",
+			key:      editor,
+			query:    `{ links {`,
+			wantData: ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := graphQL(tt.key, tt.query, nil)
+			if string(resp.Data) != tt.wantData {
+				t.Errorf("data = %s, want %s", resp.Data, tt.wantData)
+			}
+			var code string
+			if len(resp.Errors) > 0 {
+				code = resp.Errors[0].Extensions.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("error code = %q, want %q (%+v)", code, tt.wantCode, resp.Errors)
+			}
+			if tt.wantData == "" && len(resp.Errors) == 0 {
+				t.Error("got no errors for a request that cannot be executed")
+			}
+		})
+	}
+}
+
+func TestAdminEventsFeed(t *testing.T) {
+	const token = "0123456789abcdef"
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+
+	bus := events.NewBus()
+	service := shortener.NewService(shortener.NewInMemoryRepository(), shortener.WithAuditListener(publishAudit(bus)))
+	app := &App{Service: service, BaseURL: "http://localhost:8080", Bus: bus}
+	r := mux.NewRouter()
+	authenticate := auth.Middleware(service)
+	r.Handle("/api/shorten", authenticate(http.HandlerFunc(app.ShortenHandler))).Methods("POST")
+	limiter := publishingLimiter{ratelimit.NewRedisLimiter(client, "report", 1, time.Minute, 1), "report", bus}
+	r.Handle("/api/report/{shortCode}", ratelimit.Middleware(limiter, httpx.ClientIP)(http.HandlerFunc(app.ReportHandler))).Methods("POST")
+	app.registerAdminRoutes(r, token, authenticate)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	do := func(method, path, authorization, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Fatalf("failed to close body: %v", err)
+		}
+		return resp
+	}
+
+	if resp := do("GET", "/api/admin/events", "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want 401", resp.StatusCode)
+	}
+	if resp := do("GET", "/api/admin/events", token, ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET status = %d, want 400", resp.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("failed to close connection: %v", err)
+		}
+	}()
+	handshake := "GET /api/admin/events HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer " + token + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+	feed := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(feed, nil)
+	if err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	readEvent := func() events.Event {
+		t.Helper()
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("failed to set deadline: %v", err)
+		}
+		var head [2]byte
+		if _, err := io.ReadFull(feed, head[:]); err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		size := int(head[1])
+		if size == 126 {
+			var ext [2]byte
+			if _, err := io.ReadFull(feed, ext[:]); err != nil {
+				t.Fatalf("failed to read frame length: %v", err)
+			}
+			size = int(ext[0])<<8 | int(ext[1])
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(feed, payload); err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		if head[0] != 0x81 {
+			t.Fatalf("frame header = %#x, want a text message", head[0])
+		}
+		var e events.Event
+		if err := json.Unmarshal(payload, &e); err != nil {
+			t.Fatalf("event %q is not JSON: %v", payload, err)
+		}
+		return e
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"https://example.com","custom_alias":"feed"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("shorten status = %d, want 200", w.Code)
+	}
+	if resp := do("POST", "/api/admin/links/feed/disable", token, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("disable status = %d, want 204", resp.StatusCode)
+	}
+	if resp := do("POST", "/api/report/feed", "", `{"reason":"phishing"}`); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("report status = %d, want 202", resp.StatusCode)
+	}
+	if resp := do("POST", "/api/report/feed", "", `{"reason":"phishing"}`); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second report status = %d, want 429", resp.StatusCode)
+	}
+
+	want := []events.Event{
+		{Type: events.LinkCreated, ShortCode: "feed", Actor: shortener.ActorAnonymous},
+		{Type: events.LinkDisabled, ShortCode: "feed", Actor: shortener.ActorAdmin, Detail: shortener.AuditDisable},
+		{Type: events.AbuseReportFiled, ShortCode: "feed", Detail: "phishing"},
+		{Type: events.RateLimited, Detail: "report"},
+	}
+	for _, wantEvent := range want {
+		e := readEvent()
+		if e.Timestamp.IsZero() {
+			t.Errorf("event %+v has no timestamp", e)
+		}
+		e.Timestamp = time.Time{}
+		if e != wantEvent {
+			t.Errorf("event = %+v, want %+v", e, wantEvent)
+		}
 	}
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// defaultMaskProxyMaxBytes caps proxied pages when App.MaskProxyMaxBytes is
+// zero.
+const defaultMaskProxyMaxBytes = 10 << 20
+
+// errDestinationUnavailable is a destination that could not be fetched for
+// a link masked by proxy.
+var errDestinationUnavailable = errors.New("destination unavailable")
+
+// MaskFetcher requests the destinations of links masked by proxy, guarding
+// against requests to internal addresses. It is implemented by
+// metadata.Fetcher.
+type MaskFetcher interface {
+	Open(ctx context.Context, method, rawURL string, header http.Header) (*http.Response, error)
+}
+
+// maskedHeaders are the response headers of a proxied destination served
+// to the visitor. Cookies and other headers tied to the origin of the
+// destination are dropped.
+var maskedHeaders = []string{"Content-Type", "Content-Language", "Cache-Control", "Expires", "Last-Modified", "ETag"}
+
+var maskFrameTemplate = template.Must(template.New("mask").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Host}}</title>
+<style>
+html, body, iframe { width: 100%; height: 100%; margin: 0; padding: 0; border: 0; display: block; }
+</style>
+</head>
+<body>
+<iframe src="{{.URL}}" title="{{.Host}}"></iframe>
+</body>
+</html>
+`))
+
+type maskFramePage struct {
+	Host string
+	URL  string
+}
+
+// masks converts the configured mask names, which the configuration has
+// validated.
+func masks(names []string) []shortener.Mask {
+	masks := make([]shortener.Mask, len(names))
+	for i, name := range names {
+		masks[i] = shortener.Mask(name)
+	}
+	return masks
+}
+
+// serveMasked serves route, whose destination is masked, in place of a
+// redirect. It reports false if the mask of route cannot be served, in
+// which case the caller redirects.
+func (a *App) serveMasked(w http.ResponseWriter, r *http.Request, shortCode string, route shortener.Route) bool {
+	switch route.Mask {
+	case shortener.MaskFrame:
+		a.serveMaskFrame(w, r, route.URL)
+		return true
+	case shortener.MaskProxy:
+		if a.MaskProxy == nil {
+			return false
+		}
+		a.serveMaskProxy(w, r, shortCode, route.URL)
+		return true
+	}
+	return false
+}
+
+// serveMaskFrame serves a page showing destination in a frame that fills
+// the window.
+func (a *App) serveMaskFrame(w http.ResponseWriter, r *http.Request, destination string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-src http: https:")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := maskFrameTemplate.Execute(w, maskFramePage{Host: requestHost(r), URL: destination}); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render masked frame", "error", err)
+	}
+}
+
+// serveMaskProxy fetches destination and streams it to the visitor. The
+// page is sandboxed in an origin of its own, so that its scripts cannot act
+// on behalf of the short domain. Relative links of the page resolve against
+// the short URL, so proxying suits self-contained pages best.
+func (a *App) serveMaskProxy(w http.ResponseWriter, r *http.Request, shortCode, destination string) {
+	resp, err := a.MaskProxy.Open(r.Context(), r.Method, destination, r.Header)
+	if err != nil {
+		a.writeLinkError(w, r, "proxy", shortCode, fmt.Errorf("%w: %w", errDestinationUnavailable, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	maxBytes := a.MaskProxyMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaskProxyMaxBytes
+	}
+	if resp.ContentLength > maxBytes {
+		a.writeLinkError(w, r, "proxy", shortCode, fmt.Errorf("%w: %d bytes is over the limit of %d", errDestinationUnavailable, resp.ContentLength, maxBytes))
+		return
+	}
+
+	for _, name := range maskedHeaders {
+		if v := resp.Header.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	w.Header().Set("Content-Security-Policy", "sandbox allow-forms allow-scripts allow-popups allow-popups-to-escape-sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(resp.StatusCode)
+	n, err := io.Copy(w, io.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to proxy destination", "short_code", shortCode, "error", err)
+	} else if n == maxBytes {
+		slog.WarnContext(r.Context(), "proxied destination reached the size limit", "short_code", shortCode, "max_bytes", maxBytes)
+	}
+}
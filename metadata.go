@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// SetMetadataHandler replaces the metadata of a link the caller owns or
+// manages through an organization. Empty metadata removes it.
+func (a *App) SetMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req shortener.Metadata
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.SetMetadata(ctx, user, shortCode, &req)
+	if err != nil {
+		if errors.Is(err, shortener.ErrInvalidMetadata) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeOwnedLinkError(w, r, "set metadata", shortCode, err)
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
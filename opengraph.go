@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// linkCrawlers are User-Agent substrings, in lowercase, of the crawlers
+// that social networks and chat apps send to build link previews.
+var linkCrawlers = []string{
+	"facebookexternalhit", "facebot", "twitterbot", "slackbot", "linkedinbot", "discordbot",
+	"telegrambot", "whatsapp", "skypeuripreview", "pinterest", "redditbot", "embedly",
+	"mastodon", "bluesky", "iframely", "vkshare", "applebot",
+}
+
+// isLinkCrawler reports whether userAgent is that of a crawler building a
+// link preview.
+func isLinkCrawler(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, c := range linkCrawlers {
+		if strings.Contains(userAgent, c) {
+			return true
+		}
+	}
+	return false
+}
+
+var openGraphTemplate = template.Must(template.New("opengraph").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.ShortURL}}">
+{{if .Title}}<meta property="og:title" content="{{.Title}}">
+<meta name="twitter:title" content="{{.Title}}">
+{{end}}{{if .Description}}<meta name="description" content="{{.Description}}">
+<meta property="og:description" content="{{.Description}}">
+<meta name="twitter:description" content="{{.Description}}">
+{{end}}{{if .ImageURL}}<meta property="og:image" content="{{.ImageURL}}">
+<meta name="twitter:image" content="{{.ImageURL}}">
+<meta name="twitter:card" content="summary_large_image">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}<meta http-equiv="refresh" content="0; url={{.URL}}">
+<script>location.replace({{.URL}});</script>
+</head>
+<body>
+<a href="{{.URL}}">{{.URL}}</a>
+</body>
+</html>
+`))
+
+type openGraphPage struct {
+	Title       string
+	Description string
+	ImageURL    string
+	ShortURL    string
+	// URL is where visitors are sent.
+	URL string
+}
+
+// serveOpenGraph serves a crawler the Open Graph and Twitter card tags of
+// the page of link, so that shared short links show a rich preview, with a
+// redirect to route for anyone else sent the page. It reports false if the
+// page of link has not been fetched.
+func (a *App) serveOpenGraph(w http.ResponseWriter, r *http.Request, link *shortener.Link, route shortener.Route) bool {
+	page := link.Page
+	if page == nil || (page.Title == "" && page.Description == "" && page.ImageURL == "") {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'")
+	if err := openGraphTemplate.Execute(w, openGraphPage{
+		Title:       page.Title,
+		Description: page.Description,
+		ImageURL:    page.ImageURL,
+		ShortURL:    a.shortURL(link.Domain, link.ShortCode()),
+		URL:         route.URL,
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render open graph page", "error", err)
+	}
+	return true
+}
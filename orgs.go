@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type OrgRequest struct {
+	Name string `json:"name"`
+}
+
+type ListOrgsResponse struct {
+	Orgs []*shortener.Org `json:"orgs"`
+}
+
+// OrgResponse is an organization with its members, oldest member first.
+type OrgResponse struct {
+	*shortener.Org
+	Members []*shortener.User `json:"members"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email"`
+}
+
+// InvitationResponse carries the token the invitee accepts the invitation
+// with. It is only returned once, to be passed on to the invitee.
+type InvitationResponse struct {
+	*shortener.Invitation
+	Token string `json:"token"`
+}
+
+// CreateOrgHandler creates an organization with the caller as its first
+// member.
+func (a *App) CreateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	var req OrgRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	org, err := a.Service.CreateOrg(ctx, user, req.Name)
+	if err != nil {
+		writeOrgError(w, r, "create organization", 0, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, org)
+}
+
+// ListOrgsHandler lists the organizations the caller is a member of, oldest
+// first.
+func (a *App) ListOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	orgs, err := a.Service.Orgs(ctx, user)
+	if err != nil {
+		writeOrgError(w, r, "list organizations", 0, err)
+		return
+	}
+	writeJSON(w, r, ListOrgsResponse{Orgs: orgs})
+}
+
+// GetOrgHandler returns an organization of the caller with its members.
+func (a *App) GetOrgHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseOrgID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	org, err := a.Service.Org(ctx, user, id)
+	if err != nil {
+		writeOrgError(w, r, "get organization", id, err)
+		return
+	}
+	members, err := a.Service.OrgMembers(ctx, user, id)
+	if err != nil {
+		writeOrgError(w, r, "list organization members", id, err)
+		return
+	}
+	writeJSON(w, r, OrgResponse{Org: org, Members: members})
+}
+
+// OrgURLsHandler lists the links of an organization of the caller, newest
+// first.
+func (a *App) OrgURLsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseOrgID(w, r)
+	if !ok {
+		return
+	}
+	cursor, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	links, next, err := a.Service.OrgLinks(ctx, user, id, cursor, limit)
+	if err != nil {
+		writeOrgError(w, r, "list organization urls", id, err)
+		return
+	}
+	writeJSON(w, r, a.listURLsResponse(links, next))
+}
+
+// InviteHandler invites an email to an organization of the caller and
+// returns the token of the invitation.
+func (a *App) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseOrgID(w, r)
+	if !ok {
+		return
+	}
+	var req InviteRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	inv, token, err := a.Service.Invite(ctx, user, id, req.Email)
+	if err != nil {
+		writeOrgError(w, r, "invite to organization", id, err)
+		return
+	}
+	writeJSONStatus(w, r, http.StatusCreated, InvitationResponse{Invitation: inv, Token: token})
+}
+
+// RemoveOrgMemberHandler removes a member from an organization of the
+// caller. Members may remove themselves to leave.
+func (a *App) RemoveOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	id, ok := parseOrgID(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.ParseUint(mux.Vars(r)["userID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid user ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Service.RemoveOrgMember(ctx, user, id, memberID); err != nil {
+		writeOrgError(w, r, "remove organization member", id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitationHandler makes the caller a member of the organization an
+// invitation for their email is for, and returns the organization.
+func (a *App) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	org, err := a.Service.AcceptInvitation(ctx, user, mux.Vars(r)["token"])
+	if err != nil {
+		writeOrgError(w, r, "accept invitation", 0, err)
+		return
+	}
+	writeJSON(w, r, org)
+}
+
+// parseOrgID reads the orgID path variable, writing a 400 response if it is
+// not a number.
+func parseOrgID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["orgID"], 10, 64)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid organization ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeOrgError(w http.ResponseWriter, r *http.Request, op string, id uint64, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+		slog.WarnContext(r.Context(), op+" timeout", "org_id", id, "error", err)
+	case errors.Is(err, shortener.ErrInvalidOrg), errors.Is(err, shortener.ErrInvalidEmail):
+		writeServiceError(w, http.StatusBadRequest, err)
+	case errors.Is(err, shortener.ErrInvalidInvitation):
+		apierror.Write(w, http.StatusNotFound, apierror.InvalidInvitation, "Invitation not found, expired or meant for another email")
+	case errors.Is(err, shortener.ErrAlreadyMember), errors.Is(err, shortener.ErrLastMember):
+		writeServiceError(w, http.StatusConflict, err)
+	case errors.Is(err, shortener.ErrNotFound):
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Organization or member not found")
+	default:
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(r.Context(), op+" failed", "org_id", id, "error", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/metadata"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+// MetadataFetcher retrieves information about destination pages.
+// It is implemented by metadata.Fetcher.
+type MetadataFetcher interface {
+	Fetch(ctx context.Context, rawURL string) (*metadata.Metadata, error)
+}
+
+// pageFetcher stores what a MetadataFetcher finds as shortener.PageInfo.
+type pageFetcher struct {
+	MetadataFetcher
+}
+
+func (f pageFetcher) FetchPage(ctx context.Context, rawURL string) (*shortener.PageInfo, error) {
+	md, err := f.Fetch(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &shortener.PageInfo{Title: md.Title, Description: md.Description, FaviconURL: md.FaviconURL, ImageURL: md.ImageURL}, nil
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.destination { word-break: break-all; background: #f4f4f4; padding: .75rem; border-radius: 4px; }
+.button { display: inline-block; margin-top: 1.5rem; padding: .6rem 1.2rem; background: #2456d3; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<h1>This short link leads to:</h1>
+{{if .Title}}<p><strong>{{.Title}}</strong></p>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p class="destination">{{.OriginalURL}}</p>
+<a class="button" href="{{.ShortURL}}" rel="noreferrer">Continue</a>
+</body>
+</html>
+`))
+
+type previewPage struct {
+	Title       string
+	Description string
+	OriginalURL string
+	ShortURL    string
+}
+
+// PreviewHandler shows where a short link leads without redirecting, so
+// users can inspect the destination first. It is served at both
+// /preview/{shortCode} and /{shortCode}+. The destination page title and
+// description come from the stored page information, or are fetched on a
+// best-effort basis while there is none.
+func (a *App) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	link, err := a.Service.Preview(ctx, shortCode)
+	if err == nil && !link.ServedOn(requestHost(r)) {
+		err = shortener.ErrNotFound
+	}
+	if err == nil {
+		err = a.Service.CheckAccess(link, a.visitor(r))
+	}
+	if err != nil {
+		a.writeLinkError(w, r, "preview", shortCode, err)
+		return
+	}
+
+	page := previewPage{
+		OriginalURL: link.OriginalURL,
+		ShortURL:    a.shortURL(link.Domain, link.ShortCode()),
+	}
+	if link.Page != nil {
+		page.Title, page.Description = link.Page.Title, link.Page.Description
+	} else if a.Metadata != nil {
+		// The fetcher applies its own timeout (METADATA_FETCH_TIMEOUT)
+		md, err := a.Metadata.Fetch(r.Context(), link.OriginalURL)
+		if err != nil {
+			slog.DebugContext(r.Context(), "preview metadata fetch failed", "short_code", shortCode, "error", err)
+		} else {
+			page.Title, page.Description = md.Title, md.Description
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := previewTemplate.Execute(w, page); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render preview", "error", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/events"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type ReportRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type ReportResponse struct {
+	ID        uint64    `json:"id"`
+	ShortCode string    `json:"short_code"`
+	Reason    string    `json:"reason,omitempty"`
+	Reporter  string    `json:"reporter"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ListReportsResponse struct {
+	Reports    []ReportResponse `json:"reports"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// ReportHandler lets end users report a link as malicious. The body is
+// optional; reports are counted once per client IP.
+func (a *App) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req ReportRequest
+	if err := a.decodeJSON(w, r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	err := a.Service.Report(ctx, shortCode, req.Reason, a.clientIP(r))
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			apierror.Write(w, http.StatusRequestTimeout, apierror.Timeout, "Request timeout")
+			slog.WarnContext(ctx, "report timeout", "short_code", shortCode, "error", err)
+			return
+		}
+		if errors.Is(err, shortener.ErrReasonTooLong) {
+			writeServiceError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Is(err, shortener.ErrInvalidShortCode) {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidCode, "Invalid short code")
+			return
+		}
+		if errors.Is(err, shortener.ErrNotFound) {
+			apierror.Write(w, http.StatusNotFound, apierror.NotFound, "URL not found")
+			return
+		}
+		apierror.Write(w, http.StatusInternalServerError, apierror.Internal, "Internal server error")
+		slog.ErrorContext(ctx, "report failed", "short_code", shortCode, "error", err)
+		return
+	}
+
+	a.Bus.Publish(events.Event{Type: events.AbuseReportFiled, ShortCode: shortCode, Detail: req.Reason})
+
+	// The report is queued for review rather than acted on directly
+	w.WriteHeader(http.StatusAccepted)
+}
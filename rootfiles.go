@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/assets"
+)
+
+const (
+	// rootFileMaxAge is how long clients may cache the files of the site
+	// root.
+	rootFileMaxAge = 24 * time.Hour
+	// wellKnownPrefix is where RFC 8615 puts metadata about a site.
+	wellKnownPrefix = "/.well-known/"
+	// securityTxtValidity is how far ahead of startup the generated
+	// security.txt expires; RFC 9116 recommends less than a year.
+	securityTxtValidity = 180 * 24 * time.Hour
+)
+
+// wellKnownTypes are the content types of well-known files whose names
+// have no extension to tell them.
+var wellKnownTypes = map[string]string{
+	"apple-app-site-association": "application/json",
+}
+
+// rootFile is a file served at a fixed path of the site root.
+type rootFile struct {
+	contentType string
+	body        []byte
+}
+
+// RootFiles are the files served at fixed paths of the site root by path,
+// such as /robots.txt. Browsers and crawlers request them from every host,
+// and they would otherwise be looked up as short codes.
+type RootFiles map[string]rootFile
+
+// LoadRootFiles reads the files replacing the built-in favicon and
+// robots.txt. Empty paths keep the built-in files.
+func LoadRootFiles(faviconPath, robotsPath string) (RootFiles, error) {
+	favicon, err := readOr(faviconPath, assets.Favicon)
+	if err != nil {
+		return nil, err
+	}
+	robots, err := readOr(robotsPath, assets.Robots)
+	if err != nil {
+		return nil, err
+	}
+	return RootFiles{
+		"/favicon.ico": {contentType: "image/x-icon", body: favicon},
+		"/robots.txt":  {contentType: "text/plain; charset=utf-8", body: robots},
+	}, nil
+}
+
+// readOr returns the contents of the file at path, or builtin if path is
+// empty.
+func readOr(path string, builtin []byte) ([]byte, error) {
+	if path == "" {
+		return builtin, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// LoadWellKnown adds the files of dir to files under /.well-known/, such as
+// apple-app-site-association and assetlinks.json that let apps open links
+// to the site. Unless dir has one, a security.txt listing contacts is added
+// when there are any; it is canonical at baseURL if that is https.
+func (files RootFiles) LoadWellKnown(dir string, contacts []string, baseURL string, now time.Time) error {
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read well-known directory: %w", err)
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			body, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return fmt.Errorf("failed to read well-known file: %w", err)
+			}
+			files[wellKnownPrefix+e.Name()] = rootFile{contentType: wellKnownType(e.Name()), body: body}
+		}
+	}
+
+	path := wellKnownPrefix + "security.txt"
+	if _, ok := files[path]; ok || len(contacts) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	for _, c := range contacts {
+		b.WriteString("Contact: " + c + "\n")
+	}
+	b.WriteString("Expires: " + now.Add(securityTxtValidity).UTC().Format(time.RFC3339) + "\n")
+	if strings.HasPrefix(baseURL, "https://") {
+		b.WriteString("Canonical: " + baseURL + path + "\n")
+	}
+	files[path] = rootFile{contentType: "text/plain; charset=utf-8", body: []byte(b.String())}
+	return nil
+}
+
+// wellKnownType returns the content type of the well-known file name.
+func wellKnownType(name string) string {
+	if t, ok := wellKnownTypes[name]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// registerRootFiles routes the paths of files, answering 404 for other
+// well-known paths. It must be called before the /{shortCode} route.
+func registerRootFiles(r *mux.Router, files RootFiles) {
+	for path, f := range files {
+		r.Handle(path, f.handler()).Methods("GET", "HEAD")
+	}
+	r.PathPrefix(wellKnownPrefix).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Not found")
+	})
+}
+
+// handler serves f with an ETag, so that clients revalidate it cheaply once
+// their copy is older than rootFileMaxAge.
+func (f rootFile) handler() http.Handler {
+	sum := sha256.Sum256(f.body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(rootFileMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", f.contentType)
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(f.body))
+	})
+}
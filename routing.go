@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hszk-dev/url-shortener/internal/apierror"
+	"github.com/hszk-dev/url-shortener/internal/auth"
+	"github.com/hszk-dev/url-shortener/internal/httpx"
+	"github.com/hszk-dev/url-shortener/internal/shortener"
+)
+
+type SetTargetsRequest struct {
+	Targets []shortener.Target `json:"targets"`
+}
+
+type SetRulesRequest struct {
+	Rules []shortener.Rule `json:"rules"`
+}
+
+type ExperimentResponse struct {
+	Experiment *shortener.Experiment `json:"experiment"`
+	// Served counts redirects to each variant since the experiment started.
+	Served map[string]int64 `json:"served"`
+}
+
+const (
+	// variantCookie remembers the experiment variant served to a visitor.
+	// It is scoped to the path of the short link, so each link has its own.
+	variantCookie       = "variant"
+	variantCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// visitor describes the client of r for links with routing rules or
+// country targets, and for click analytics.
+func (a *App) visitor(r *http.Request) shortener.Visitor {
+	v := shortener.Visitor{
+		Device:   shortener.ParseDevice(r.UserAgent()),
+		ID:       shortener.VisitorID(a.clientIP(r), r.UserAgent()),
+		Source:   shortener.ParseClickSource(r.Referer(), r.URL.Query()),
+		Host:     requestHost(r),
+		Query:    r.URL.Query(),
+		Referrer: shortener.ReferrerHost(r.Referer()),
+		Addr:     httpx.ClientAddr(r),
+	}
+	if a.CountryHeader != "" {
+		v.Country = strings.ToUpper(r.Header.Get(a.CountryHeader))
+	}
+	if v.Country == "" && a.GeoIP != nil && v.Addr.IsValid() {
+		v.Country = a.GeoIP.Country(v.Addr)
+	}
+	if c, err := r.Cookie(variantCookie); err == nil {
+		v.Variant = c.Value
+	}
+	if a.doNotTrack(r) {
+		// Still routed by device and country, but only counted
+		v.ID, v.Source = "", shortener.ClickSource{Referrer: shortener.UntrackedReferrer}
+	}
+	return v
+}
+
+// doNotTrack reports whether the client asked not to be tracked, with
+// Do-Not-Track or Global Privacy Control, and the deployment honors it.
+func (a *App) doNotTrack(r *http.Request) bool {
+	return a.HonorDoNotTrack && (r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1")
+}
+
+// setVariantCookie keeps the visitor on variant for later visits of shortCode.
+func setVariantCookie(w http.ResponseWriter, shortCode, variant string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie,
+		Value:    variant,
+		Path:     "/" + shortCode,
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SetTargetsHandler replaces the country targets of a link owned by the
+// caller. An empty list removes them.
+func (a *App) SetTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req SetTargetsRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.SetTargets(ctx, user, shortCode, req.Targets)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrInvalidTarget), errors.Is(err, shortener.ErrDomainBlocked), errors.Is(err, shortener.ErrRecursiveURL):
+			writeServiceError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shortener.ErrUnsafeURL):
+			apierror.Write(w, http.StatusBadRequest, apierror.UnsafeURL, "URL is flagged as unsafe")
+		case errors.Is(err, shortener.ErrURLTooLong):
+			writeServiceError(w, http.StatusUnprocessableEntity, err)
+		default:
+			writeOwnedLinkError(w, r, "set targets", shortCode, err)
+		}
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
+
+// SetRulesHandler replaces the device routing rules of a link owned by the
+// caller. An empty list removes them.
+func (a *App) SetRulesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req SetRulesRequest
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.SetRules(ctx, user, shortCode, req.Rules)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrInvalidRule), errors.Is(err, shortener.ErrDomainBlocked), errors.Is(err, shortener.ErrRecursiveURL):
+			writeServiceError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shortener.ErrUnsafeURL):
+			apierror.Write(w, http.StatusBadRequest, apierror.UnsafeURL, "URL is flagged as unsafe")
+		case errors.Is(err, shortener.ErrURLTooLong):
+			writeServiceError(w, http.StatusUnprocessableEntity, err)
+		default:
+			writeOwnedLinkError(w, r, "set rules", shortCode, err)
+		}
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
+
+// SetExperimentHandler replaces the A/B experiment of a link owned by the
+// caller. An experiment without variants removes it.
+func (a *App) SetExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	var req shortener.Experiment
+	if err := a.decodeJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, err := a.Service.SetExperiment(ctx, user, shortCode, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortener.ErrInvalidExperiment), errors.Is(err, shortener.ErrDomainBlocked), errors.Is(err, shortener.ErrRecursiveURL):
+			writeServiceError(w, http.StatusBadRequest, err)
+		case errors.Is(err, shortener.ErrUnsafeURL):
+			apierror.Write(w, http.StatusBadRequest, apierror.UnsafeURL, "URL is flagged as unsafe")
+		case errors.Is(err, shortener.ErrURLTooLong):
+			writeServiceError(w, http.StatusUnprocessableEntity, err)
+		default:
+			writeOwnedLinkError(w, r, "set experiment", shortCode, err)
+		}
+		return
+	}
+	writeJSON(w, r, a.urlResponse(link))
+}
+
+// ExperimentHandler returns the A/B experiment of a link owned by the caller
+// and how often each variant has been served.
+func (a *App) ExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	shortCode := mux.Vars(r)["shortCode"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	link, served, err := a.Service.ExperimentStats(ctx, user, shortCode)
+	if err != nil {
+		writeOwnedLinkError(w, r, "get experiment", shortCode, err)
+		return
+	}
+	writeJSON(w, r, ExperimentResponse{Experiment: link.Experiment, Served: served})
+}
@@ -0,0 +1,6 @@
+-- Apply after init.sql to every shard database but the primary (see
+-- SHARD_DSNS). Users, campaigns and organizations only exist on the primary,
+-- so links on other shards cannot reference them with foreign keys.
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_owner_id_fkey;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_campaign_id_fkey;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_org_id_fkey;
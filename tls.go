@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/hszk-dev/url-shortener/internal/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLS returns the TLS configuration of the server for cfg, or nil if it
+// serves plain HTTP. With autocert, challenges wraps a handler of the plain
+// HTTP server so that it also answers Let's Encrypt HTTP-01 challenges;
+// otherwise it returns the handler as is.
+func newTLS(cfg *config.Config) (tlsConfig *tls.Config, challenges func(http.Handler) http.Handler) {
+	challenges = func(h http.Handler) http.Handler { return h }
+	switch {
+	case len(cfg.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Email:      cfg.AutocertEmail,
+		}
+		// TLSConfig also answers TLS-ALPN-01 challenges, so certificates
+		// are obtained even without HTTP_REDIRECT_PORT
+		return m.TLSConfig(), m.HTTPHandler
+	case cfg.TLSCertFile != "":
+		// The certificate is loaded by ListenAndServeTLS
+		return &tls.Config{MinVersion: tls.VersionTLS12}, challenges
+	}
+	return nil, challenges
+}
+
+// httpsRedirect redirects plain HTTP requests to the same URL on HTTPS at
+// port. The redirect is permanent: unlike short link redirects, it carries no
+// analytics and the scheme of a host never changes back.
+func httpsRedirect(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}